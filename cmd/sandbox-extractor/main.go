@@ -0,0 +1,21 @@
+// Command sandbox-extractor is the extractor the server runs in its
+// WebAssembly sandbox. Build it for WASI next to the server's binary:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -o sandbox-extractor.wasm ./cmd/sandbox-extractor
+//
+// It reads the file on stdin and writes the result to stdout, as described
+// by its one argument; it isn't meant to be run by hand.
+package main
+
+import (
+	"os"
+
+	"file-meta/internal/sandbox"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		os.Exit(2)
+	}
+	os.Exit(sandbox.Serve(os.Args[1], os.Stdin, os.Stdout))
+}
@@ -1,17 +1,75 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+)
+
+// Rate limiting algorithms
+const (
+	// RateLimitTokenBucket refills a key's whole allowance once its window
+	// has passed
+	RateLimitTokenBucket = "token_bucket"
+	// RateLimitSlidingWindow counts a key's requests in the last window,
+	// estimating the previous fixed window's share from its total
+	RateLimitSlidingWindow = "sliding_window"
+)
+
+// What the Redis rate limiters do while Redis is unavailable
+const (
+	RateLimitFailOpen   = "open"   // let every request through
+	RateLimitFailClosed = "closed" // reject every request
+	RateLimitFailMemory = "memory" // limit each instance in memory
+)
+
+// Access log formats
+const (
+	AccessLogText     = "text"     // Started and Completed lines among the other logs
+	AccessLogJSON     = "json"     // a JSON object per request
+	AccessLogCombined = "combined" // Apache's combined log format
+	AccessLogKeyValue = "kv"       // key=value pairs per request
 )
 
-// Config holds application configuration
+// API key scopes, each required by some routes
+const (
+	// ScopeMetadataRead reads stored results, history, jobs and usage
+	ScopeMetadataRead = "metadata:read"
+	// ScopeMetadataWrite extracts metadata, which is what costs compute
+	ScopeMetadataWrite = "metadata:write"
+	// ScopeAdmin reads every key's usage
+	ScopeAdmin = "admin"
+	// ScopeMetadataPersonal sees location data and device serial numbers
+	// in privacy mode
+	ScopeMetadataPersonal = "metadata:personal"
+)
+
+// Scopes lists every scope a key can be given
+var Scopes = []string{ScopeMetadataRead, ScopeMetadataWrite, ScopeAdmin, ScopeMetadataPersonal}
+
+// APIKeyHashPrefix marks a key setting that lists a key by the hex SHA-256
+// digest of it rather than the key itself
+const APIKeyHashPrefix = "sha256:"
+
+// Config holds application configuration. Fields tagged report:"secret"
+// are redacted in Report.
 type Config struct {
 	Port              string
-	APIKeys           map[string]bool
+	APIKeys           map[string]bool `report:"secret"`
 	MaxFileSizeMB     int64
 	RateLimitRequests int
 	RateLimitWindow   time.Duration
@@ -20,25 +78,714 @@ type Config struct {
 	RedisURL          string
 	RedisHost         string
 	RedisPort         string
-	RedisPassword     string
+	RedisPassword     string `report:"secret"`
 	RedisDB           int
+	ClamAVAddress     string
+	ClamAVTimeout     time.Duration
+	ClamAVFailOpen    bool
+	NSRLFile          string
+	NSRLRedisPrefix   string
+
+	// How RequestLogger logs each request, one of the AccessLog formats;
+	// empty is AccessLogText
+	AccessLogFormat string
+	// What logs show of filenames, one of the logger's Filenames policies
+	LogFilenames string
+	// MaxMind DB files placing client IPs in access logs and usage
+	GeoIPDatabases []string
+
+	// External AI-image classifier
+	AIClassifierURL     string
+	AIClassifierAPIKey  string `report:"secret"`
+	AIClassifierTimeout time.Duration
+	AIClassifierWeight  float64
+
+	// Decompression bomb limits
+	DecompressionMaxRatio int
+	DecompressionMaxDepth int
+	DecompressionMaxMB    int64
+
+	// Per-extraction memory limits
+	MemoryMaxPixels     int64
+	MemoryMaxDocumentKB int64
+	MemoryMaxEXIFKB     int64
+
+	// Named extraction profiles, each a list of modules to run
+	Profiles       map[string][]string
+	DefaultProfile string
+
+	// DisabledModules never run, whatever the profile or request asks for.
+	// Admins can switch modules on and off at runtime from there.
+	DisabledModules []string
+
+	// Uploads whose detected MIME type or extension is denied, or not
+	// allowed when allowlists are set, are rejected before extraction.
+	// Types may end in /* to match every subtype.
+	AllowedMIMETypes  []string
+	DeniedMIMETypes   []string
+	AllowedExtensions []string
+	DeniedExtensions  []string
+
+	// DocsUI serves Swagger UI at /docs
+	DocsUI bool
+	// HealthFailCritical makes /health answer 503 while a critical
+	// dependency is down
+	HealthFailCritical bool
+	// Profiling and runtime debug endpoints: on a listener of their own at
+	// DebugAddr without authentication, and with DebugEndpoints under
+	// /admin/debug/ for admin keys. Dumps are written to DebugDumpDir.
+	DebugAddr      string
+	DebugEndpoints bool
+	DebugDumpDir   string
+	// MetricsKeySecret keys the HMAC that labels API keys in the public
+	// per-key metrics. Empty uses a random secret per process.
+	MetricsKeySecret []byte `report:"secret"`
+
+	// How long shutdown reports not ready on /readyz before it stops
+	// accepting connections, so load balancers stop sending requests first
+	ShutdownDrainDelay time.Duration
+
+	// HTTP server limits, as in http.Server: zero timeouts disable them.
+	// Without ServerKeepAlives every connection serves a single request.
+	ServerReadTimeout       time.Duration
+	ServerReadHeaderTimeout time.Duration
+	ServerWriteTimeout      time.Duration
+	ServerIdleTimeout       time.Duration
+	ServerMaxHeaderBytes    int
+	ServerKeepAlives        bool
+
+	// Result cache for GET /v1/metadata/{sha256}. A zero size disables it.
+	ResultCacheSize int
+	ResultCacheTTL  time.Duration
+
+	// Resumable uploads. A zero size disables them.
+	UploadDir       string
+	UploadMaxSizeMB int64
+	UploadExpiry    time.Duration
+
+	// Multipart uploads up to MultipartMemoryMB are held in memory, larger
+	// ones spill to TempDir
+	MultipartMemoryMB int64
+
+	// Multipart uploads may carry at most MaxFilesPerRequest files, of which
+	// only the first is extracted, in a body of at most MaxRequestSizeMB.
+	// Zero doesn't limit the files, and allows a body of the file size
+	// limit plus form fields.
+	MaxFilesPerRequest int
+	MaxRequestSizeMB   int64
+
+	// Uploads sent with Content-Encoding gzip or zstd may decompress to at
+	// most RequestDecompressionMaxRatio times their size. Zero rejects them.
+	RequestDecompressionMaxRatio int
+
+	// Temporary files for spilled uploads and extraction. A zero quota
+	// doesn't limit disk use.
+	TempDir       string
+	TempQuotaMB   int64
+	TempOrphanAge time.Duration
+
+	// ExtractionTimeout bounds metadata extraction per request. Zero
+	// disables it.
+	ExtractionTimeout time.Duration
+
+	// Concurrent extraction limit and how long a request waits for a free
+	// slot. A zero limit disables it.
+	MaxConcurrentExtractions int
+	ExtractionQueueTimeout   time.Duration
+
+	// Extractions a single API key may run at once, so one tenant can't
+	// take every slot. Zero disables it.
+	MaxConcurrentPerKey int
+
+	// Extraction in a WebAssembly instance of SandboxModule per file,
+	// capped at SandboxMemoryMB of linear memory and SandboxTimeout. An
+	// empty module is the one next to the binary; zero caps are unlimited.
+	SandboxEnabled  bool
+	SandboxModule   string
+	SandboxMemoryMB int64
+	SandboxTimeout  time.Duration
+
+	// Async extraction jobs over resumable uploads: how long a job may run,
+	// queueing included, and how long its result is kept once finished
+	JobTimeout   time.Duration
+	JobRetention time.Duration
+
+	// Remote object ingestion from cloud storage, read in ranged GETs of
+	// StorageChunkSizeMB that each take at most StorageRequestTimeout
+	StorageChunkSizeMB    int64
+	StorageRequestTimeout time.Duration
+
+	// S3. Bucket/key references use the AWS credentials, and only for
+	// S3AllowedBuckets if it isn't empty; presigned URLs carry their own.
+	// An empty endpoint means AWS.
+	S3Enabled         bool
+	S3Region          string
+	S3Endpoint        string
+	S3AccessKeyID     string `report:"secret"`
+	S3SecretAccessKey string `report:"secret"`
+	S3SessionToken    string `report:"secret"`
+	S3AllowedBuckets  []string
+
+	// Google Cloud Storage. gs:// references use the service account key
+	// file; signed URLs carry their own authorization.
+	GCSEnabled         bool
+	GCSCredentialsFile string
+	GCSEndpoint        string
+
+	// Azure Blob Storage. azure:// references use the account key, or
+	// else the account SAS token; SAS URLs carry their own.
+	AzureEnabled        bool
+	AzureStorageAccount string
+	AzureStorageKey     string `report:"secret"`
+	AzureSASToken       string `report:"secret"`
+	AzureBlobEndpoint   string
+
+	// Event worker mode (-worker): S3 event notifications are read from the
+	// SQS queue, which hides each one for SQSVisibilityTimeout while it is
+	// processed, or for its own default when zero
+	SQSQueueURL          string
+	SQSVisibilityTimeout time.Duration
+
+	// Webhook receiving the worker's results, signed with WebhookSecret when
+	// set
+	WebhookURL     string
+	WebhookSecret  string `report:"secret"`
+	WebhookTimeout time.Duration
+
+	// Kafka publishing of every completed result to KafkaTopic, keyed by
+	// SHA256. Empty KafkaBrokers disables it. A username enables
+	// SASL/PLAIN, best combined with TLS.
+	KafkaBrokers    []string
+	KafkaTopic      string
+	KafkaTLS        bool
+	KafkaUsername   string
+	KafkaPassword   string `report:"secret"`
+	KafkaBufferSize int
+	KafkaTimeout    time.Duration
+
+	// NATS connection. Completed results are published to
+	// NATSResultsSubject.<sha256>, acknowledged by a stream with
+	// NATSJetStream; extraction requests on NATSRequestsSubject are shared
+	// across NATSQueueGroup. Empty subjects disable either side.
+	NATSURL             string
+	NATSResultsSubject  string
+	NATSJetStream       bool
+	NATSRequestsSubject string
+	NATSQueueGroup      string
+	NATSBufferSize      int
+	NATSTimeout         time.Duration
+
+	// Directories the -scan mode may walk, and everything under them. Empty
+	// disables scanning.
+	ScanAllowedDirs []string
+
+	// Drop folders the -watch mode polls every WatchInterval
+	WatchDirs     []string
+	WatchInterval time.Duration
+
+	// PostgreSQL database recording every extraction in DatabaseTable, over
+	// up to DatabaseMaxConns connections. An empty URL disables it.
+	DatabaseURL      string
+	DatabaseTable    string
+	DatabaseMaxConns int
+	DatabaseTimeout  time.Duration
+
+	// Local file recording every extraction, for single instances without
+	// a database
+	HistoryFile string
+
+	// Per-key monthly usage counts, shared through Redis when it is
+	// available and kept for UsageRetention there
+	UsageTracking  bool
+	UsageRetention time.Duration
+
+	// Per-key overrides of the upload size, extraction modules and result
+	// retention, set by admins and shared through Redis when it is available
+	KeyOverrides bool
+
+	// Leave location data and device serial numbers out of responses to
+	// keys without the metadata:personal scope
+	PrivacyMode bool
+
+	// API keys that may also read every key's usage
+	AdminAPIKeys map[string]bool `report:"secret"`
+
+	// Scopes of the keys given them explicitly. Other keys may read and
+	// write metadata, and are admins when in AdminAPIKeys.
+	KeyScopes map[string]map[string]bool `report:"secret"`
+
+	// Replacement keys by their entry, each authenticating as the key it
+	// replaces, which keeps working until the rotation's deadline
+	APIKeyRotations map[string]KeyRotation `report:"secret"`
+
+	// OAuth 2.0 clients by ID, each the API_KEYS entry of the key that is
+	// its secret. Their access tokens are signed with OAuthTokenSecret and
+	// expire after OAuthTokenTTL.
+	OAuthClients     map[string]string `report:"secret"`
+	OAuthTokenSecret []byte            `report:"secret"`
+	OAuthTokenTTL    time.Duration
+
+	// HTTPS with TLSCertFile and TLSKeyFile. TLSClientCAFile makes clients
+	// present a certificate issued by one of its CAs, unless
+	// TLSClientCertOptional. ClientCertKeys maps a certificate's common name
+	// or URI SAN to the API_KEYS entry its requests authenticate as.
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSClientCAFile       string
+	TLSClientCertOptional bool
+	ClientCertKeys        map[string]string `report:"secret"`
+	// Certificates for TLSAutocertDomains from the ACME CA at
+	// TLSAutocertDirectoryURL instead of TLSCertFile, renewed automatically
+	// and cached in TLSAutocertDir
+	TLSAutocertDomains      []string
+	TLSAutocertEmail        string
+	TLSAutocertDir          string
+	TLSAutocertDirectoryURL string
+	// TLSMinVersion is the lowest TLS version accepted
+	TLSMinVersion uint16
+	// HTTPRedirectPort serves plain HTTP redirecting to HTTPS, and the
+	// ACME CA's challenges
+	HTTPRedirectPort string
+
+	// Named tiers and the API keys assigned to them; other keys get the
+	// global rate limit and upload size
+	Tiers    map[string]Tier
+	KeyTiers map[string]string `report:"secret"`
+
+	// How the rate limit is enforced, RateLimitTokenBucket or
+	// RateLimitSlidingWindow
+	RateLimitAlgorithm string
+
+	// Tokens a key's bucket holds, so it can make RateLimitBurst requests at
+	// once while refilling RateLimitRequests per RateLimitWindow. Zero
+	// makes it RateLimitRequests.
+	RateLimitBurst int
+
+	// Proxies whose X-Forwarded-For is believed, for rate limiting requests
+	// without a valid API key by client IP
+	TrustedProxies []netip.Prefix
+
+	// API keys of internal services that are never rate limited
+	RateLimitExemptKeys map[string]bool `report:"secret"`
+
+	// The Redis rate limiters stop calling Redis for
+	// RateLimitBreakerCooldown after RateLimitBreakerFailures errors in a
+	// row, handling requests per RateLimitFailMode meanwhile
+	RateLimitFailMode        string
+	RateLimitBreakerFailures int
+	RateLimitBreakerCooldown time.Duration
+
+	// Rate limited requests wait up to RateLimitQueueMaxDelay for a token
+	// rather than failing, RateLimitQueueDepth per key at most. Zero
+	// rejects them at once.
+	RateLimitQueueMaxDelay time.Duration
+	RateLimitQueueDepth    int
+
+	// Load shedding starts rejecting the lowest-priority requests once the
+	// goroutines, heap or in-flight extractions (running or waiting for a
+	// slot) reach these limits. Zero disables a limit.
+	LoadShedMaxGoroutines int
+	LoadShedMaxHeapMB     int64
+	LoadShedMaxInFlight   int
+
+	// A client IP sending AuthBanThreshold invalid API keys within
+	// AuthBanWindow is banned for AuthBanDuration, doubling with each ban
+	// up to AuthBanMaxDuration. A zero threshold disables it.
+	AuthBanThreshold   int
+	AuthBanWindow      time.Duration
+	AuthBanDuration    time.Duration
+	AuthBanMaxDuration time.Duration
+}
+
+// KeyRotation replaces an API key with a new one that has its identity:
+// its tier, history, usage and other settings
+type KeyRotation struct {
+	// Replaces is the entry of the old key, in API_KEYS or replaced itself
+	Replaces string
+	// Until is when the old key stops working
+	Until time.Time
+}
+
+// Tier overrides the global rate limit and upload size for its API keys
+type Tier struct {
+	Name              string
+	RateLimitRequests int
+	RateLimitWindow   time.Duration
+	RateLimitBurst    int
+	MaxFileSizeMB     int64
+}
+
+// Burst returns the tokens the tier's token bucket holds
+func (t Tier) Burst() int {
+	if t.RateLimitBurst > 0 {
+		return t.RateLimitBurst
+	}
+	return t.RateLimitRequests
+}
+
+// Limits returns the tier apiKey is assigned to, or the global limits with
+// no name for keys without one
+func (c *Config) Limits(apiKey string) Tier {
+	if tier, ok := c.Tiers[c.KeyTiers[apiKey]]; ok {
+		return tier
+	}
+	return Tier{
+		RateLimitRequests: c.RateLimitRequests,
+		RateLimitWindow:   c.RateLimitWindow,
+		RateLimitBurst:    c.RateLimitBurst,
+		MaxFileSizeMB:     c.MaxFileSizeMB,
+	}
+}
+
+// HasScope reports whether apiKey, as listed in API_KEYS, may use routes
+// requiring scope
+func (c *Config) HasScope(apiKey, scope string) bool {
+	if scope == ScopeAdmin && c.AdminAPIKeys[apiKey] {
+		return true
+	}
+	scopes, ok := c.KeyScopes[apiKey]
+	if !ok {
+		return scope != ScopeAdmin && scope != ScopeMetadataPersonal
+	}
+	return scopes[scope]
+}
+
+// APIKey returns the API_KEYS entry key is listed under, the key itself or
+// its hash, and whether it's listed at all. Other key settings are looked up
+// by that entry. Every entry is compared in constant time, so how long a
+// request takes doesn't tell how close a guess was.
+func (c *Config) APIKey(key string) (string, bool) {
+	entry, _, ok := c.LookupAPIKey(key, time.Now())
+	return entry, ok
+}
+
+// LookupAPIKey is APIKey at now, counting replacement keys as the key they
+// replace, and replaced keys until their deadline. sunset is that deadline
+// when key has been replaced, and zero otherwise.
+func (c *Config) LookupAPIKey(key string, now time.Time) (entry string, sunset time.Time, ok bool) {
+	match := c.matchAPIKey(key)
+	if match == "" {
+		return "", time.Time{}, false
+	}
+	return c.resolveEntry(match, now)
+}
+
+// KeyFingerprint identifies the API_KEYS or API_KEY_ROTATIONS entry key
+// matches, without revealing it, for what must stop working with the key,
+// such as its access tokens. It is empty when key matches no entry.
+func (c *Config) KeyFingerprint(key string) string {
+	if match := c.matchAPIKey(key); match != "" {
+		return entryFingerprint(match)
+	}
+	return ""
+}
+
+// LookupKeyFingerprint is LookupAPIKey for the key with fingerprint
+func (c *Config) LookupKeyFingerprint(fingerprint string, now time.Time) (entry string, sunset time.Time, ok bool) {
+	if fingerprint == "" {
+		return "", time.Time{}, false
+	}
+	var match string
+	for entry := range c.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(entryFingerprint(entry)), []byte(fingerprint)) == 1 {
+			match = entry
+		}
+	}
+	for entry := range c.APIKeyRotations {
+		if subtle.ConstantTimeCompare([]byte(entryFingerprint(entry)), []byte(fingerprint)) == 1 {
+			match = entry
+		}
+	}
+	if match == "" {
+		return "", time.Time{}, false
+	}
+	return c.resolveEntry(match, now)
+}
+
+// entryFingerprint is the hex SHA-256 of entry
+func entryFingerprint(entry string) string {
+	digest := sha256.Sum256([]byte(entry))
+	return hex.EncodeToString(digest[:])
+}
+
+// matchAPIKey returns the API_KEYS or API_KEY_ROTATIONS entry of key, or
+// empty if there is none
+func (c *Config) matchAPIKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	hashed := HashAPIKey(key)
+	var match string
+	matches := func(entry string) {
+		// A hashed entry is matched by its key only, so a leaked hash is
+		// no use as a key
+		want := key
+		if strings.HasPrefix(entry, APIKeyHashPrefix) {
+			want = hashed
+		}
+		if subtle.ConstantTimeCompare([]byte(entry), []byte(want)) == 1 {
+			match = entry
+		}
+	}
+	for entry := range c.APIKeys {
+		matches(entry)
+	}
+	for entry := range c.APIKeyRotations {
+		matches(entry)
+	}
+	return match
+}
+
+// resolveEntry returns the API_KEYS entry that match, an entry of either
+// setting, stands for at now, and the deadline of a replaced match
+func (c *Config) resolveEntry(match string, now time.Time) (entry string, sunset time.Time, ok bool) {
+	for _, rotation := range c.APIKeyRotations {
+		if rotation.Replaces == match {
+			if !now.Before(rotation.Until) {
+				return "", time.Time{}, false
+			}
+			sunset = rotation.Until
+		}
+	}
+
+	// Replacements of replacements lead back to the original entry
+	entry = match
+	for {
+		rotation, ok := c.APIKeyRotations[entry]
+		if !ok {
+			break
+		}
+		entry = rotation.Replaces
+	}
+	return entry, sunset, true
+}
+
+// HashAPIKey returns the entry that lists key in API_KEYS without revealing
+// it
+func HashAPIKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return APIKeyHashPrefix + hex.EncodeToString(digest[:])
+}
+
+// parseKeyScopes reads semicolon-separated key=scope,scope entries for keys
+// listed in apiKeys
+func parseKeyScopes(value string, apiKeys map[string]bool) (map[string]map[string]bool, error) {
+	keyScopes := make(map[string]map[string]bool)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		// Keys may end in base64 padding, so the scopes follow the last =
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("expected key=scope,scope")
+		}
+		key, err := parseAPIKey(entry[:i])
+		if err != nil {
+			return nil, err
+		}
+		if !apiKeys[key] {
+			return nil, fmt.Errorf("keys must be listed in API_KEYS")
+		}
+		if _, ok := keyScopes[key]; ok {
+			return nil, fmt.Errorf("a key's scopes must be listed once")
+		}
+
+		scopes := make(map[string]bool)
+		for _, scope := range strings.Split(entry[i+1:], ",") {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if scope == "" {
+				continue
+			}
+			if !slices.Contains(Scopes, scope) {
+				return nil, fmt.Errorf("unknown scope %q", scope)
+			}
+			scopes[scope] = true
+		}
+		if len(scopes) == 0 {
+			return nil, fmt.Errorf("keys need at least one scope")
+		}
+		keyScopes[key] = scopes
+	}
+	return keyScopes, nil
+}
+
+// parseKeyRotation reads an API_KEY_ROTATIONS entry, old>new@until with the
+// deadline in RFC 3339, returning the replacement's entry and its rotation
+func parseKeyRotation(entry string) (string, KeyRotation, error) {
+	// Keys may contain @, the deadline can't
+	i := strings.LastIndex(entry, "@")
+	if i < 0 {
+		return "", KeyRotation{}, fmt.Errorf("expected old>new@until")
+	}
+	keys, until := entry[:i], entry[i+1:]
+	oldKey, newKey, ok := strings.Cut(keys, ">")
+	if !ok {
+		return "", KeyRotation{}, fmt.Errorf("expected old>new@until")
+	}
+	replaces, err := parseAPIKey(oldKey)
+	if err != nil {
+		return "", KeyRotation{}, err
+	}
+	replacement, err := parseAPIKey(newKey)
+	if err != nil {
+		return "", KeyRotation{}, err
+	}
+	if replaces == "" || replacement == "" {
+		return "", KeyRotation{}, fmt.Errorf("expected old>new@until")
+	}
+	deadline, err := time.Parse(time.RFC3339, strings.TrimSpace(until))
+	if err != nil {
+		return "", KeyRotation{}, fmt.Errorf("invalid deadline: %w", err)
+	}
+	return replacement, KeyRotation{Replaces: replaces, Until: deadline}, nil
+}
+
+// parseAPIKey reads an entry of a key setting, checking that hashed entries
+// are SHA-256 digests
+func parseAPIKey(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	digest, ok := strings.CutPrefix(entry, APIKeyHashPrefix)
+	if !ok {
+		return entry, nil
+	}
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("%s entries must be 64 hex digits", APIKeyHashPrefix)
+	}
+	return APIKeyHashPrefix + strings.ToLower(digest), nil
+}
+
+// defaultProfiles are available unless EXTRACTION_PROFILES redefines them
+var defaultProfiles = map[string][]string{
+	"fast": {
+		metadata.ModuleImage, metadata.ModuleAudio, metadata.ModuleVideo, metadata.ModuleDocument,
+	},
+	"moderation": {
+		metadata.ModuleImage, metadata.ModuleDocument, metadata.ModuleAIDetection,
+		metadata.ModuleScreenshotDetection, metadata.ModuleSecurity, metadata.ModulePerceptualHash,
+	},
+	"forensic": metadata.Modules,
 }
 
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
+	return load(true)
+}
+
+// LoadLocal reads configuration like Load for tools that don't serve
+// requests, such as the CLI, so API_KEYS isn't required
+func LoadLocal() (*Config, error) {
+	return load(false)
+}
+
+func load(requireAPIKeys bool) (*Config, error) {
+	var env envReader
 	cfg := &Config{
 		Port:              getEnv("PORT", "8080"),
-		MaxFileSizeMB:     getEnvAsInt("MAX_FILE_SIZE_MB", 20),
-		RateLimitRequests: int(getEnvAsInt("RATE_LIMIT_REQUESTS", 10)),
+		MaxFileSizeMB:     env.getInt("MAX_FILE_SIZE_MB", 20),
+		RateLimitRequests: int(env.getInt("RATE_LIMIT_REQUESTS", 10)),
 		LogLevel:          getEnv("LOG_LEVEL", "info"),
 		Environment:       getEnv("ENV", "development"),
 		RedisURL:          os.Getenv("REDIS_URL"),
 		RedisHost:         getEnv("REDIS_HOST", "localhost"),
 		RedisPort:         getEnv("REDIS_PORT", "6379"),
 		RedisPassword:     os.Getenv("REDIS_PASSWORD"),
-		RedisDB:           int(getEnvAsInt("REDIS_DB", 0)),
+		RedisDB:           int(env.getInt("REDIS_DB", 0)),
+		ClamAVAddress:     os.Getenv("CLAMAV_ADDRESS"),
+		NSRLFile:          os.Getenv("NSRL_FILE"),
+		NSRLRedisPrefix:   os.Getenv("NSRL_REDIS_PREFIX"),
+
+		AIClassifierURL:    os.Getenv("AI_CLASSIFIER_URL"),
+		AIClassifierAPIKey: os.Getenv("AI_CLASSIFIER_API_KEY"),
+		AIClassifierWeight: env.getFloat("AI_CLASSIFIER_WEIGHT", 0.7),
+
+		DecompressionMaxRatio: int(env.getInt("DECOMPRESSION_MAX_RATIO", 100)),
+		DecompressionMaxDepth: int(env.getInt("DECOMPRESSION_MAX_DEPTH", 3)),
+		DecompressionMaxMB:    env.getInt("DECOMPRESSION_MAX_MB", 1024),
+
+		MemoryMaxPixels:     env.getInt("MEMORY_MAX_PIXELS", 4_000_000),
+		MemoryMaxDocumentKB: env.getInt("MEMORY_MAX_DOCUMENT_KB", 1024),
+		MemoryMaxEXIFKB:     env.getInt("MEMORY_MAX_EXIF_KB", 256),
+
+		DefaultProfile: strings.ToLower(os.Getenv("DEFAULT_PROFILE")),
+
+		DocsUI: env.getBool("DOCS_UI", false),
+
+		HealthFailCritical: env.getBool("HEALTH_FAIL_CRITICAL", false),
+
+		DebugAddr:      os.Getenv("DEBUG_ADDR"),
+		DebugEndpoints: env.getBool("DEBUG_ENDPOINTS", false),
+		DebugDumpDir:   getEnv("DEBUG_DUMP_DIR", filepath.Join(os.TempDir(), "file-meta-dumps")),
+
+		ResultCacheSize: int(env.getInt("RESULT_CACHE_SIZE", 1000)),
+
+		UploadDir:       getEnv("UPLOAD_DIR", filepath.Join(os.TempDir(), "file-meta-uploads")),
+		UploadMaxSizeMB: env.getInt("UPLOAD_MAX_SIZE_MB", 4096),
+
+		MaxConcurrentExtractions: int(env.getInt("MAX_CONCURRENT_EXTRACTIONS", int64(runtime.NumCPU()))),
+		MaxConcurrentPerKey:      int(env.getInt("MAX_CONCURRENT_EXTRACTIONS_PER_KEY", 0)),
+
+		TempDir:     getEnv("TEMP_DIR", filepath.Join(os.TempDir(), "file-meta")),
+		TempQuotaMB: env.getInt("TEMP_QUOTA_MB", 2048),
+
+		SandboxEnabled:    env.getBool("SANDBOX_ENABLED", false),
+		SandboxModule:     os.Getenv("SANDBOX_MODULE"),
+		SandboxMemoryMB:   env.getInt("SANDBOX_MEMORY_MB", 1024),
+		S3Enabled:         env.getBool("S3_ENABLED", false),
+		S3Region:          getEnv("AWS_REGION", "us-east-1"),
+		S3Endpoint:        os.Getenv("S3_ENDPOINT"),
+		S3AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		S3SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		S3SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+
+		StorageChunkSizeMB: env.getInt("STORAGE_CHUNK_SIZE_MB", 8),
+
+		GCSEnabled:         env.getBool("GCS_ENABLED", false),
+		GCSCredentialsFile: os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
+		GCSEndpoint:        os.Getenv("GCS_ENDPOINT"),
+
+		AzureEnabled:        env.getBool("AZURE_ENABLED", false),
+		AzureStorageAccount: os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		AzureStorageKey:     os.Getenv("AZURE_STORAGE_KEY"),
+		AzureSASToken:       os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+		AzureBlobEndpoint:   os.Getenv("AZURE_BLOB_ENDPOINT"),
+
+		SQSQueueURL: os.Getenv("SQS_QUEUE_URL"),
+
+		WebhookURL:    os.Getenv("WEBHOOK_URL"),
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+
+		KafkaTopic:      getEnv("KAFKA_TOPIC", "file-meta-results"),
+		KafkaTLS:        env.getBool("KAFKA_TLS", false),
+		KafkaUsername:   os.Getenv("KAFKA_SASL_USERNAME"),
+		KafkaPassword:   os.Getenv("KAFKA_SASL_PASSWORD"),
+		KafkaBufferSize: int(env.getInt("KAFKA_BUFFER_SIZE", 1000)),
+
+		NATSURL:             os.Getenv("NATS_URL"),
+		NATSResultsSubject:  getEnv("NATS_RESULTS_SUBJECT", "file-meta.results"),
+		NATSJetStream:       env.getBool("NATS_JETSTREAM", false),
+		NATSRequestsSubject: os.Getenv("NATS_REQUESTS_SUBJECT"),
+		NATSQueueGroup:      getEnv("NATS_QUEUE_GROUP", "file-meta"),
+		NATSBufferSize:      int(env.getInt("NATS_BUFFER_SIZE", 1000)),
+
+		DatabaseURL:      os.Getenv("DATABASE_URL"),
+		DatabaseTable:    getEnv("DATABASE_TABLE", "extractions"),
+		DatabaseMaxConns: int(env.getInt("DATABASE_MAX_CONNS", 4)),
+
+		HistoryFile: os.Getenv("HISTORY_FILE"),
+
+		UsageTracking: env.getBool("USAGE_TRACKING", false),
+		KeyOverrides:  env.getBool("KEY_OVERRIDES", false),
+
+		PrivacyMode: env.getBool("PRIVACY_MODE", false),
 	}
 
+	// Hold whole uploads in memory unless told otherwise
+	cfg.MultipartMemoryMB = env.getInt("MULTIPART_MEMORY_MB", cfg.MaxFileSizeMB)
+	cfg.MaxFilesPerRequest = int(env.getInt("MAX_FILES_PER_REQUEST", 0))
+	cfg.MaxRequestSizeMB = env.getInt("MAX_REQUEST_SIZE_MB", 0)
+	cfg.RequestDecompressionMaxRatio = int(env.getInt("REQUEST_DECOMPRESSION_MAX_RATIO", 100))
+
 	// Parse rate limit window
 	windowStr := getEnv("RATE_LIMIT_WINDOW", "1m")
 	window, err := time.ParseDuration(windowStr)
@@ -46,25 +793,475 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid RATE_LIMIT_WINDOW: %w", err)
 	}
 	cfg.RateLimitWindow = window
+	cfg.RateLimitBurst = int(env.getInt("RATE_LIMIT_BURST", 0))
+
+	switch algorithm := getEnv("RATE_LIMIT_ALGORITHM", RateLimitTokenBucket); algorithm {
+	case RateLimitTokenBucket, RateLimitSlidingWindow:
+		cfg.RateLimitAlgorithm = algorithm
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_ALGORITHM: must be token_bucket or sliding_window")
+	}
+
+	switch format := getEnv("ACCESS_LOG_FORMAT", AccessLogText); format {
+	case AccessLogText, AccessLogJSON, AccessLogCombined, AccessLogKeyValue:
+		cfg.AccessLogFormat = format
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT: must be text, json, combined or kv")
+	}
+
+	switch filenames := getEnv("LOG_FILENAMES", logger.FilenamesExtension); filenames {
+	case logger.FilenamesFull, logger.FilenamesExtension, logger.FilenamesHash:
+		cfg.LogFilenames = filenames
+	default:
+		return nil, fmt.Errorf("invalid LOG_FILENAMES: must be full, extension or hash")
+	}
+
+	switch failMode := getEnv("RATE_LIMIT_FAIL_MODE", RateLimitFailMemory); failMode {
+	case RateLimitFailOpen, RateLimitFailClosed, RateLimitFailMemory:
+		cfg.RateLimitFailMode = failMode
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_FAIL_MODE: must be open, closed or memory")
+	}
+	cfg.RateLimitBreakerFailures = int(env.getInt("RATE_LIMIT_BREAKER_FAILURES", 5))
+	breakerCooldown, err := time.ParseDuration(getEnv("RATE_LIMIT_BREAKER_COOLDOWN", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BREAKER_COOLDOWN: %w", err)
+	}
+	cfg.RateLimitBreakerCooldown = breakerCooldown
+
+	queueMaxDelay, err := time.ParseDuration(getEnv("RATE_LIMIT_QUEUE_MAX_DELAY", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_QUEUE_MAX_DELAY: %w", err)
+	}
+	cfg.RateLimitQueueMaxDelay = queueMaxDelay
+	cfg.RateLimitQueueDepth = int(env.getInt("RATE_LIMIT_QUEUE_DEPTH", 10))
+
+	cfg.LoadShedMaxGoroutines = int(env.getInt("LOAD_SHED_MAX_GOROUTINES", 0))
+	cfg.LoadShedMaxHeapMB = env.getInt("LOAD_SHED_MAX_HEAP_MB", 0)
+	cfg.LoadShedMaxInFlight = int(env.getInt("LOAD_SHED_MAX_IN_FLIGHT", 0))
+
+	cfg.AuthBanThreshold = int(env.getInt("AUTH_BAN_THRESHOLD", 10))
+	authBanWindow, err := time.ParseDuration(getEnv("AUTH_BAN_WINDOW", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_BAN_WINDOW: %w", err)
+	}
+	cfg.AuthBanWindow = authBanWindow
+	authBanDuration, err := time.ParseDuration(getEnv("AUTH_BAN_DURATION", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_BAN_DURATION: %w", err)
+	}
+	cfg.AuthBanDuration = authBanDuration
+	authBanMaxDuration, err := time.ParseDuration(getEnv("AUTH_BAN_MAX_DURATION", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_BAN_MAX_DURATION: %w", err)
+	}
+	cfg.AuthBanMaxDuration = authBanMaxDuration
+
+	// Parse ClamAV settings
+	clamTimeout, err := time.ParseDuration(getEnv("CLAMAV_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLAMAV_TIMEOUT: %w", err)
+	}
+	cfg.ClamAVTimeout = clamTimeout
+
+	switch failMode := getEnv("CLAMAV_FAIL_MODE", "open"); failMode {
+	case "open":
+		cfg.ClamAVFailOpen = true
+	case "closed":
+		cfg.ClamAVFailOpen = false
+	default:
+		return nil, fmt.Errorf("invalid CLAMAV_FAIL_MODE: must be open or closed")
+	}
+
+	// Parse AI classifier settings
+	classifierTimeout, err := time.ParseDuration(getEnv("AI_CLASSIFIER_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AI_CLASSIFIER_TIMEOUT: %w", err)
+	}
+	cfg.AIClassifierTimeout = classifierTimeout
+
+	// Parse result cache TTL
+	cacheTTL, err := time.ParseDuration(getEnv("RESULT_CACHE_TTL", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RESULT_CACHE_TTL: %w", err)
+	}
+	cfg.ResultCacheTTL = cacheTTL
+
+	// Parse resumable upload expiry
+	uploadExpiry, err := time.ParseDuration(getEnv("UPLOAD_EXPIRY", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid UPLOAD_EXPIRY: %w", err)
+	}
+	cfg.UploadExpiry = uploadExpiry
+
+	// Parse temporary file orphan age
+	orphanAge, err := time.ParseDuration(getEnv("TEMP_ORPHAN_AGE", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TEMP_ORPHAN_AGE: %w", err)
+	}
+	cfg.TempOrphanAge = orphanAge
+
+	// Parse extraction timeout
+	extractionTimeout, err := time.ParseDuration(getEnv("EXTRACTION_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXTRACTION_TIMEOUT: %w", err)
+	}
+	cfg.ExtractionTimeout = extractionTimeout
+
+	// Parse extraction queue timeout
+	queueTimeout, err := time.ParseDuration(getEnv("EXTRACTION_QUEUE_TIMEOUT", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXTRACTION_QUEUE_TIMEOUT: %w", err)
+	}
+	cfg.ExtractionQueueTimeout = queueTimeout
+
+	// Parse async job timeout and retention
+	jobTimeout, err := time.ParseDuration(getEnv("JOB_TIMEOUT", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JOB_TIMEOUT: %w", err)
+	}
+	cfg.JobTimeout = jobTimeout
+
+	jobRetention, err := time.ParseDuration(getEnv("JOB_RETENTION", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JOB_RETENTION: %w", err)
+	}
+	cfg.JobRetention = jobRetention
+
+	// Parse remote storage request timeout
+	storageTimeout, err := time.ParseDuration(getEnv("STORAGE_REQUEST_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_REQUEST_TIMEOUT: %w", err)
+	}
+	cfg.StorageRequestTimeout = storageTimeout
+
+	// Parse event worker timeouts
+	visibilityTimeout, err := time.ParseDuration(getEnv("SQS_VISIBILITY_TIMEOUT", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SQS_VISIBILITY_TIMEOUT: %w", err)
+	}
+	cfg.SQSVisibilityTimeout = visibilityTimeout
+
+	webhookTimeout, err := time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_TIMEOUT: %w", err)
+	}
+	cfg.WebhookTimeout = webhookTimeout
+
+	// Parse Kafka brokers and timeout
+	for _, broker := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			cfg.KafkaBrokers = append(cfg.KafkaBrokers, broker)
+		}
+	}
+
+	kafkaTimeout, err := time.ParseDuration(getEnv("KAFKA_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KAFKA_TIMEOUT: %w", err)
+	}
+	cfg.KafkaTimeout = kafkaTimeout
+
+	natsTimeout, err := time.ParseDuration(getEnv("NATS_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS_TIMEOUT: %w", err)
+	}
+	cfg.NATSTimeout = natsTimeout
+
+	sandboxTimeout, err := time.ParseDuration(getEnv("SANDBOX_TIMEOUT", "60s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SANDBOX_TIMEOUT: %w", err)
+	}
+	cfg.SandboxTimeout = sandboxTimeout
+
+	databaseTimeout, err := time.ParseDuration(getEnv("DATABASE_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_TIMEOUT: %w", err)
+	}
+	cfg.DatabaseTimeout = databaseTimeout
+
+	usageRetention, err := time.ParseDuration(getEnv("USAGE_RETENTION", "9600h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid USAGE_RETENTION: %w", err)
+	}
+	cfg.UsageRetention = usageRetention
+
+	// Parse directories allowed for scanning
+	for _, dir := range strings.Split(os.Getenv("SCAN_ALLOWED_DIRS"), ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			cfg.ScanAllowedDirs = append(cfg.ScanAllowedDirs, dir)
+		}
+	}
+
+	// Parse GeoIP databases
+	for _, file := range strings.Split(os.Getenv("GEOIP_DATABASES"), ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			cfg.GeoIPDatabases = append(cfg.GeoIPDatabases, file)
+		}
+	}
+
+	// Parse watched directories and the polling interval
+	for _, dir := range strings.Split(os.Getenv("WATCH_DIRS"), ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			cfg.WatchDirs = append(cfg.WatchDirs, dir)
+		}
+	}
+
+	watchInterval, err := time.ParseDuration(getEnv("WATCH_INTERVAL", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATCH_INTERVAL: %w", err)
+	}
+	cfg.WatchInterval = watchInterval
+
+	drainDelay, err := time.ParseDuration(getEnv("SHUTDOWN_DRAIN_DELAY", "5s"))
+	if err != nil || drainDelay < 0 {
+		return nil, fmt.Errorf("invalid SHUTDOWN_DRAIN_DELAY: must be a non-negative duration")
+	}
+	cfg.ShutdownDrainDelay = drainDelay
+
+	// Parse HTTP server limits
+	for _, timeout := range []struct {
+		name     string
+		fallback string
+		value    *time.Duration
+	}{
+		{"SERVER_READ_TIMEOUT", "15s", &cfg.ServerReadTimeout},
+		{"SERVER_READ_HEADER_TIMEOUT", "10s", &cfg.ServerReadHeaderTimeout},
+		{"SERVER_WRITE_TIMEOUT", "15s", &cfg.ServerWriteTimeout},
+		{"SERVER_IDLE_TIMEOUT", "60s", &cfg.ServerIdleTimeout},
+	} {
+		d, err := time.ParseDuration(getEnv(timeout.name, timeout.fallback))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s: must be a non-negative duration", timeout.name)
+		}
+		*timeout.value = d
+	}
+	cfg.ServerMaxHeaderBytes = int(env.getInt("SERVER_MAX_HEADER_BYTES", 1<<20))
+	cfg.ServerKeepAlives = env.getBool("SERVER_KEEP_ALIVES", true)
+
+	// Parse extraction profiles
+	profiles, err := parseProfiles(os.Getenv("EXTRACTION_PROFILES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXTRACTION_PROFILES: %w", err)
+	}
+	cfg.Profiles = profiles
+
+	for _, module := range strings.Split(os.Getenv("DISABLED_MODULES"), ",") {
+		if module = strings.ToLower(strings.TrimSpace(module)); module != "" {
+			cfg.DisabledModules = append(cfg.DisabledModules, module)
+		}
+	}
+
+	cfg.S3AllowedBuckets = splitList(os.Getenv("S3_ALLOWED_BUCKETS"))
+	cfg.AllowedMIMETypes = splitList(os.Getenv("ALLOWED_MIME_TYPES"))
+	cfg.DeniedMIMETypes = splitList(os.Getenv("DENIED_MIME_TYPES"))
+	cfg.AllowedExtensions = splitList(os.Getenv("ALLOWED_EXTENSIONS"))
+	cfg.DeniedExtensions = splitList(os.Getenv("DENIED_EXTENSIONS"))
 
 	// Parse API keys
 	apiKeysStr := os.Getenv("API_KEYS")
-	if apiKeysStr == "" {
+	if apiKeysStr == "" && requireAPIKeys {
 		return nil, fmt.Errorf("API_KEYS environment variable is required")
 	}
 
 	cfg.APIKeys = make(map[string]bool)
-	for _, key := range strings.Split(apiKeysStr, ",") {
-		key = strings.TrimSpace(key)
+	for _, entry := range strings.Split(apiKeysStr, ",") {
+		key, err := parseAPIKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_KEYS: %w", err)
+		}
 		if key != "" {
 			cfg.APIKeys[key] = true
 		}
 	}
 
-	if len(cfg.APIKeys) == 0 {
+	if len(cfg.APIKeys) == 0 && requireAPIKeys {
 		return nil, fmt.Errorf("at least one API key is required")
 	}
 
+	cfg.APIKeyRotations = make(map[string]KeyRotation)
+	for _, entry := range strings.Split(os.Getenv("API_KEY_ROTATIONS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		replacement, rotation, err := parseKeyRotation(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_KEY_ROTATIONS: %w", err)
+		}
+		if _, ok := cfg.APIKeyRotations[rotation.Replaces]; !cfg.APIKeys[rotation.Replaces] && !ok {
+			return nil, fmt.Errorf("API_KEY_ROTATIONS must replace keys listed in API_KEYS or replaced before")
+		}
+		if _, ok := cfg.APIKeyRotations[replacement]; cfg.APIKeys[replacement] || ok {
+			return nil, fmt.Errorf("API_KEY_ROTATIONS replacements must be new keys")
+		}
+		for _, other := range cfg.APIKeyRotations {
+			if other.Replaces == rotation.Replaces {
+				return nil, fmt.Errorf("API_KEY_ROTATIONS must replace each key once")
+			}
+		}
+		cfg.APIKeyRotations[replacement] = rotation
+	}
+
+	// Admin keys are regular keys with more access
+	cfg.AdminAPIKeys = make(map[string]bool)
+	for _, entry := range strings.Split(os.Getenv("ADMIN_API_KEYS"), ",") {
+		key, err := parseAPIKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_API_KEYS: %w", err)
+		}
+		if key == "" {
+			continue
+		}
+		if !cfg.APIKeys[key] {
+			return nil, fmt.Errorf("ADMIN_API_KEYS must also be listed in API_KEYS")
+		}
+		cfg.AdminAPIKeys[key] = true
+	}
+
+	scopes, err := parseKeyScopes(os.Getenv("API_KEY_SCOPES"), cfg.APIKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_KEY_SCOPES: %w", err)
+	}
+	cfg.KeyScopes = scopes
+
+	cfg.RateLimitExemptKeys = make(map[string]bool)
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMIT_EXEMPT_KEYS"), ",") {
+		key, err := parseAPIKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_EXEMPT_KEYS: %w", err)
+		}
+		if key == "" {
+			continue
+		}
+		if !cfg.APIKeys[key] {
+			return nil, fmt.Errorf("RATE_LIMIT_EXEMPT_KEYS must also be listed in API_KEYS")
+		}
+		cfg.RateLimitExemptKeys[key] = true
+	}
+
+	// Tiers default to the global limits they override
+	tiers, err := parseTiers(os.Getenv("API_TIERS"), cfg.Limits(""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_TIERS: %w", err)
+	}
+	cfg.Tiers = tiers
+
+	cfg.KeyTiers = make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("API_KEY_TIERS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		// Keys may end in base64 padding, so the tier follows the last =
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid API_KEY_TIERS: expected key=tier")
+		}
+		key, err := parseAPIKey(entry[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_KEY_TIERS: %w", err)
+		}
+		tier := strings.ToLower(strings.TrimSpace(entry[i+1:]))
+		if !cfg.APIKeys[key] {
+			return nil, fmt.Errorf("API_KEY_TIERS must only assign keys listed in API_KEYS")
+		}
+		if _, ok := tiers[tier]; !ok {
+			return nil, fmt.Errorf("invalid API_KEY_TIERS: unknown tier %q", tier)
+		}
+		cfg.KeyTiers[key] = tier
+	}
+
+	cfg.OAuthClients = make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("OAUTH_CLIENTS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		// Client IDs can't contain =, keys may
+		id, key, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid OAUTH_CLIENTS: expected client_id=key")
+		}
+		key, err := parseAPIKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OAUTH_CLIENTS: %w", err)
+		}
+		if !cfg.APIKeys[key] {
+			return nil, fmt.Errorf("OAUTH_CLIENTS must only use keys listed in API_KEYS")
+		}
+		cfg.OAuthClients[id] = key
+	}
+	cfg.OAuthTokenSecret = []byte(os.Getenv("OAUTH_TOKEN_SECRET"))
+	if len(cfg.OAuthClients) > 0 && len(cfg.OAuthTokenSecret) < 32 {
+		return nil, fmt.Errorf("OAUTH_TOKEN_SECRET of at least 32 bytes is required with OAUTH_CLIENTS")
+	}
+	cfg.MetricsKeySecret = []byte(os.Getenv("METRICS_KEY_SECRET"))
+	if len(cfg.MetricsKeySecret) > 0 && len(cfg.MetricsKeySecret) < 32 {
+		return nil, fmt.Errorf("METRICS_KEY_SECRET must be at least 32 bytes")
+	}
+	tokenTTL, err := time.ParseDuration(getEnv("OAUTH_TOKEN_TTL", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OAUTH_TOKEN_TTL: %w", err)
+	}
+	cfg.OAuthTokenTTL = tokenTTL
+
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	cfg.TLSClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	cfg.TLSClientCertOptional = env.getBool("TLS_CLIENT_CERT_OPTIONAL", false)
+	cfg.ClientCertKeys = make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("CLIENT_CERT_KEYS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		// Names rarely contain =, keys may
+		name, key, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid CLIENT_CERT_KEYS: expected name=key")
+		}
+		key, err := parseAPIKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLIENT_CERT_KEYS: %w", err)
+		}
+		if !cfg.APIKeys[key] {
+			return nil, fmt.Errorf("CLIENT_CERT_KEYS must only use keys listed in API_KEYS")
+		}
+		cfg.ClientCertKeys[name] = key
+	}
+
+	for _, domain := range strings.Split(os.Getenv("TLS_AUTOCERT_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			cfg.TLSAutocertDomains = append(cfg.TLSAutocertDomains, domain)
+		}
+	}
+	cfg.TLSAutocertEmail = os.Getenv("TLS_AUTOCERT_EMAIL")
+	cfg.TLSAutocertDir = getEnv("TLS_AUTOCERT_DIR", filepath.Join(os.TempDir(), "file-meta-autocert"))
+	cfg.TLSAutocertDirectoryURL = getEnv("TLS_AUTOCERT_DIRECTORY_URL", "https://acme-v02.api.letsencrypt.org/directory")
+	switch version := getEnv("TLS_MIN_VERSION", "1.2"); version {
+	case "1.2":
+		cfg.TLSMinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.TLSMinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION: %q (must be 1.2 or 1.3)", version)
+	}
+	cfg.HTTPRedirectPort = os.Getenv("HTTP_REDIRECT_PORT")
+
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := parseTrustedProxy(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+
+	// Report every malformed number and boolean at once
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, err
+	}
+
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, err
@@ -73,6 +1270,11 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// TLSEnabled reports whether the server speaks HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.TLSAutocertDomains) > 0
+}
+
 // Validate checks if configuration values are valid
 func (c *Config) Validate() error {
 	if c.Port == "" {
@@ -91,6 +1293,200 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("RATE_LIMIT_WINDOW must be positive")
 	}
 
+	if c.RateLimitBurst < 0 {
+		return fmt.Errorf("RATE_LIMIT_BURST cannot be negative")
+	}
+
+	if c.RateLimitBreakerFailures < 0 || c.RateLimitBreakerCooldown < 0 {
+		return fmt.Errorf("RATE_LIMIT_BREAKER_* settings cannot be negative")
+	}
+
+	if c.RateLimitQueueMaxDelay < 0 || c.RateLimitQueueDepth < 0 {
+		return fmt.Errorf("RATE_LIMIT_QUEUE_* settings cannot be negative")
+	}
+
+	if c.LoadShedMaxGoroutines < 0 || c.LoadShedMaxHeapMB < 0 || c.LoadShedMaxInFlight < 0 {
+		return fmt.Errorf("LOAD_SHED_* limits cannot be negative")
+	}
+
+	if len(c.OAuthClients) > 0 && c.OAuthTokenTTL <= 0 {
+		return fmt.Errorf("OAUTH_TOKEN_TTL must be positive")
+	}
+
+	if c.ServerMaxHeaderBytes < 0 {
+		return fmt.Errorf("SERVER_MAX_HEADER_BYTES cannot be negative")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("set both TLS_CERT_FILE and TLS_KEY_FILE, or neither")
+	}
+	if c.TLSCertFile != "" && len(c.TLSAutocertDomains) > 0 {
+		return fmt.Errorf("set TLS_CERT_FILE or TLS_AUTOCERT_DOMAINS, not both")
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE, or TLS_AUTOCERT_DOMAINS")
+	}
+	if c.HTTPRedirectPort != "" && !c.TLSEnabled() {
+		return fmt.Errorf("HTTP_REDIRECT_PORT needs TLS_CERT_FILE and TLS_KEY_FILE, or TLS_AUTOCERT_DOMAINS")
+	}
+	if len(c.TLSAutocertDomains) > 0 && c.HTTPRedirectPort == "" {
+		return fmt.Errorf("TLS_AUTOCERT_DOMAINS needs HTTP_REDIRECT_PORT to answer the CA's challenges")
+	}
+	if c.HTTPRedirectPort != "" && c.HTTPRedirectPort == c.Port {
+		return fmt.Errorf("HTTP_REDIRECT_PORT must differ from PORT")
+	}
+	if len(c.ClientCertKeys) > 0 && c.TLSClientCAFile == "" {
+		return fmt.Errorf("CLIENT_CERT_KEYS needs TLS_CLIENT_CA_FILE")
+	}
+
+	if c.AuthBanThreshold < 0 {
+		return fmt.Errorf("AUTH_BAN_THRESHOLD cannot be negative")
+	}
+	if c.AuthBanThreshold > 0 && (c.AuthBanWindow <= 0 || c.AuthBanDuration <= 0 || c.AuthBanMaxDuration < c.AuthBanDuration) {
+		return fmt.Errorf("AUTH_BAN_WINDOW and AUTH_BAN_DURATION must be positive, and AUTH_BAN_MAX_DURATION at least AUTH_BAN_DURATION")
+	}
+
+	for name, tier := range c.Tiers {
+		if tier.RateLimitRequests <= 0 || tier.RateLimitWindow <= 0 || tier.MaxFileSizeMB <= 0 || tier.RateLimitBurst < 0 {
+			return fmt.Errorf("tier %s: limits must be positive", name)
+		}
+	}
+
+	if c.DecompressionMaxRatio < 0 || c.DecompressionMaxDepth < 0 || c.DecompressionMaxMB < 0 {
+		return fmt.Errorf("DECOMPRESSION_MAX_* limits cannot be negative")
+	}
+
+	if c.MemoryMaxPixels < 0 || c.MemoryMaxDocumentKB < 0 || c.MemoryMaxEXIFKB < 0 {
+		return fmt.Errorf("MEMORY_MAX_* limits cannot be negative")
+	}
+
+	if c.MemoryMaxDocumentKB > 1024 {
+		return fmt.Errorf("MEMORY_MAX_DOCUMENT_KB cannot exceed 1024")
+	}
+
+	if c.AIClassifierWeight < 0 || c.AIClassifierWeight > 1 {
+		return fmt.Errorf("AI_CLASSIFIER_WEIGHT must be between 0 and 1")
+	}
+
+	if c.ResultCacheSize < 0 || c.ResultCacheTTL < 0 {
+		return fmt.Errorf("RESULT_CACHE_SIZE and RESULT_CACHE_TTL cannot be negative")
+	}
+
+	if c.UploadMaxSizeMB < 0 {
+		return fmt.Errorf("UPLOAD_MAX_SIZE_MB cannot be negative")
+	}
+
+	if c.UploadMaxSizeMB > 0 && c.UploadExpiry <= 0 {
+		return fmt.Errorf("UPLOAD_EXPIRY must be positive")
+	}
+
+	if c.MultipartMemoryMB < 0 {
+		return fmt.Errorf("MULTIPART_MEMORY_MB cannot be negative")
+	}
+
+	if c.MaxFilesPerRequest < 0 || c.MaxRequestSizeMB < 0 {
+		return fmt.Errorf("MAX_FILES_PER_REQUEST and MAX_REQUEST_SIZE_MB cannot be negative")
+	}
+
+	if c.RequestDecompressionMaxRatio < 0 {
+		return fmt.Errorf("REQUEST_DECOMPRESSION_MAX_RATIO cannot be negative")
+	}
+
+	if c.TempQuotaMB < 0 {
+		return fmt.Errorf("TEMP_QUOTA_MB cannot be negative")
+	}
+
+	if c.TempDir != "" && c.TempOrphanAge <= 0 {
+		return fmt.Errorf("TEMP_ORPHAN_AGE must be positive")
+	}
+
+	if c.ExtractionTimeout < 0 {
+		return fmt.Errorf("EXTRACTION_TIMEOUT cannot be negative")
+	}
+
+	if c.MaxConcurrentExtractions < 0 || c.ExtractionQueueTimeout < 0 {
+		return fmt.Errorf("MAX_CONCURRENT_EXTRACTIONS and EXTRACTION_QUEUE_TIMEOUT cannot be negative")
+	}
+
+	if c.MaxConcurrentPerKey < 0 {
+		return fmt.Errorf("MAX_CONCURRENT_EXTRACTIONS_PER_KEY cannot be negative")
+	}
+
+	if c.UploadMaxSizeMB > 0 && (c.JobTimeout <= 0 || c.JobRetention <= 0) {
+		return fmt.Errorf("JOB_TIMEOUT and JOB_RETENTION must be positive")
+	}
+
+	if (c.S3Enabled || c.GCSEnabled || c.AzureEnabled) && (c.StorageChunkSizeMB <= 0 || c.StorageRequestTimeout <= 0) {
+		return fmt.Errorf("STORAGE_CHUNK_SIZE_MB and STORAGE_REQUEST_TIMEOUT must be positive")
+	}
+
+	if c.SQSVisibilityTimeout < 0 {
+		return fmt.Errorf("SQS_VISIBILITY_TIMEOUT cannot be negative")
+	}
+
+	if c.WebhookURL != "" && c.WebhookTimeout <= 0 {
+		return fmt.Errorf("WEBHOOK_TIMEOUT must be positive")
+	}
+
+	if len(c.KafkaBrokers) > 0 && (c.KafkaTopic == "" || c.KafkaBufferSize <= 0 || c.KafkaTimeout <= 0) {
+		return fmt.Errorf("KAFKA_TOPIC is required and KAFKA_BUFFER_SIZE and KAFKA_TIMEOUT must be positive")
+	}
+
+	if c.NATSURL != "" && (c.NATSBufferSize <= 0 || c.NATSTimeout <= 0) {
+		return fmt.Errorf("NATS_BUFFER_SIZE and NATS_TIMEOUT must be positive")
+	}
+	if c.NATSURL != "" && c.NATSJetStream && c.NATSResultsSubject == "" {
+		return fmt.Errorf("NATS_JETSTREAM needs NATS_RESULTS_SUBJECT")
+	}
+
+	if c.DatabaseURL != "" && (c.DatabaseMaxConns <= 0 || c.DatabaseTimeout <= 0) {
+		return fmt.Errorf("DATABASE_MAX_CONNS and DATABASE_TIMEOUT must be positive")
+	}
+	if c.DatabaseURL != "" && c.HistoryFile != "" {
+		return fmt.Errorf("set only one of DATABASE_URL and HISTORY_FILE")
+	}
+
+	if c.UsageTracking && c.UsageRetention <= 0 {
+		return fmt.Errorf("USAGE_RETENTION must be positive")
+	}
+
+	if c.SandboxMemoryMB < 0 || c.SandboxMemoryMB > 4096 {
+		return fmt.Errorf("SANDBOX_MEMORY_MB must be between 0 and 4096, the most a WebAssembly instance can address")
+	}
+	if c.SandboxTimeout < 0 {
+		return fmt.Errorf("SANDBOX_TIMEOUT cannot be negative")
+	}
+
+	if len(c.WatchDirs) > 0 && c.WatchInterval <= 0 {
+		return fmt.Errorf("WATCH_INTERVAL must be positive")
+	}
+
+	for name, modules := range c.Profiles {
+		for _, module := range modules {
+			if !slices.Contains(metadata.Modules, module) {
+				return fmt.Errorf("profile %q: unknown module %q", name, module)
+			}
+		}
+	}
+
+	for _, module := range c.DisabledModules {
+		if !slices.Contains(metadata.Modules, module) {
+			return fmt.Errorf("invalid DISABLED_MODULES: unknown module %q", module)
+		}
+	}
+
+	for name, types := range map[string][]string{"ALLOWED_MIME_TYPES": c.AllowedMIMETypes, "DENIED_MIME_TYPES": c.DeniedMIMETypes} {
+		for _, t := range types {
+			if major, minor, ok := strings.Cut(t, "/"); !ok || major == "" || minor == "" {
+				return fmt.Errorf("invalid %s: %q is not a MIME type", name, t)
+			}
+		}
+	}
+
+	if _, ok := c.Profiles[c.DefaultProfile]; c.DefaultProfile != "" && !ok {
+		return fmt.Errorf("DEFAULT_PROFILE %q is not a defined profile", c.DefaultProfile)
+	}
+
 	validLogLevels := map[string]bool{
 		"debug": true,
 		"info":  true,
@@ -104,6 +1500,96 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// parseProfiles reads semicolon-separated profiles of the form
+// name=module,module on top of the default profiles
+func parseProfiles(value string) (map[string][]string, error) {
+	profiles := make(map[string][]string, len(defaultProfiles))
+	for name, modules := range defaultProfiles {
+		profiles[name] = modules
+	}
+
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected name=modules, got %q", entry)
+		}
+
+		modules := []string{}
+		for _, module := range strings.Split(list, ",") {
+			if module = strings.ToLower(strings.TrimSpace(module)); module != "" {
+				modules = append(modules, module)
+			}
+		}
+		profiles[name] = modules
+	}
+
+	return profiles, nil
+}
+
+// parseTrustedProxy reads a CIDR range, or a single address as a range of
+// one
+func parseTrustedProxy(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseTiers reads semicolon-separated tiers of the form
+// name=requests:N,window:D,burst:N,max_file_size_mb:N. Omitted limits are taken
+// from defaults.
+func parseTiers(value string, defaults Tier) (map[string]Tier, error) {
+	tiers := make(map[string]Tier)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected name=limits, got %q", entry)
+		}
+
+		tier := defaults
+		tier.Name = name
+		for _, limit := range strings.Split(list, ",") {
+			if strings.TrimSpace(limit) == "" {
+				continue
+			}
+			field, value, _ := strings.Cut(limit, ":")
+			field, value = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(value)
+			var err error
+			switch field {
+			case "requests":
+				tier.RateLimitRequests, err = strconv.Atoi(value)
+			case "window":
+				tier.RateLimitWindow, err = time.ParseDuration(value)
+			case "burst":
+				tier.RateLimitBurst, err = strconv.Atoi(value)
+			case "max_file_size_mb":
+				tier.MaxFileSizeMB, err = strconv.ParseInt(value, 10, 64)
+			default:
+				return nil, fmt.Errorf("tier %s: unknown limit %q", name, field)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tier %s: invalid %s: %w", name, field, err)
+			}
+		}
+		tiers[name] = tier
+	}
+	return tiers, nil
+}
+
 // getEnv retrieves an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -112,8 +1598,25 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// getEnvAsInt retrieves an environment variable as int64 or returns a default value
-func getEnvAsInt(key string, defaultValue int64) int64 {
+// splitList splits a comma-separated value, dropping blank entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envReader reads typed environment variables, keeping an error for each
+// value that doesn't parse rather than quietly using the default
+type envReader struct {
+	errs []error
+}
+
+// getInt retrieves an environment variable as int64 or returns a default value
+func (e *envReader) getInt(key string, defaultValue int64) int64 {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
 		return defaultValue
@@ -121,6 +1624,39 @@ func getEnvAsInt(key string, defaultValue int64) int64 {
 
 	value, err := strconv.ParseInt(valueStr, 10, 64)
 	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s: %q is not an integer", key, valueStr))
+		return defaultValue
+	}
+
+	return value
+}
+
+// getBool retrieves an environment variable as bool or returns a default value
+func (e *envReader) getBool(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s: %q is not true or false", key, valueStr))
+		return defaultValue
+	}
+
+	return value
+}
+
+// getFloat retrieves an environment variable as float64 or returns a default value
+func (e *envReader) getFloat(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s: %q is not a number", key, valueStr))
 		return defaultValue
 	}
 
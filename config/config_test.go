@@ -1,9 +1,14 @@
 package config
 
 import (
+	"crypto/tls"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
+
+	"file-meta/internal/logger"
 )
 
 func TestLoad(t *testing.T) {
@@ -14,6 +19,8 @@ func TestLoad(t *testing.T) {
 	os.Setenv("RATE_LIMIT_REQUESTS", "20")
 	os.Setenv("RATE_LIMIT_WINDOW", "2m")
 	os.Setenv("LOG_LEVEL", "debug")
+	os.Setenv("DOCS_UI", "true")
+	os.Setenv("RESULT_CACHE_TTL", "1h")
 
 	defer func() {
 		os.Unsetenv("API_KEYS")
@@ -22,6 +29,8 @@ func TestLoad(t *testing.T) {
 		os.Unsetenv("RATE_LIMIT_REQUESTS")
 		os.Unsetenv("RATE_LIMIT_WINDOW")
 		os.Unsetenv("LOG_LEVEL")
+		os.Unsetenv("DOCS_UI")
+		os.Unsetenv("RESULT_CACHE_TTL")
 	}()
 
 	cfg, err := Load()
@@ -52,6 +61,46 @@ func TestLoad(t *testing.T) {
 	if !cfg.APIKeys["test_key_1"] || !cfg.APIKeys["test_key_2"] {
 		t.Errorf("APIKeys not loaded correctly: %v", cfg.APIKeys)
 	}
+
+	if !cfg.DocsUI {
+		t.Errorf("DocsUI = %v, want true", cfg.DocsUI)
+	}
+
+	if cfg.UploadMaxSizeMB != 4096 || cfg.UploadExpiry != 24*time.Hour || cfg.UploadDir == "" {
+		t.Errorf("uploads = %d MB, %v, %q, want defaults", cfg.UploadMaxSizeMB, cfg.UploadExpiry, cfg.UploadDir)
+	}
+
+	if cfg.ResultCacheSize != 1000 || cfg.ResultCacheTTL != time.Hour {
+		t.Errorf("result cache = %d, %v, want 1000, 1h", cfg.ResultCacheSize, cfg.ResultCacheTTL)
+	}
+
+	if cfg.ExtractionTimeout != 10*time.Second {
+		t.Errorf("ExtractionTimeout = %v, want 10s", cfg.ExtractionTimeout)
+	}
+
+	if cfg.TempDir == "" || cfg.TempQuotaMB != 2048 || cfg.TempOrphanAge != time.Hour {
+		t.Errorf("temp files = %q, %d MB, %v, want defaults", cfg.TempDir, cfg.TempQuotaMB, cfg.TempOrphanAge)
+	}
+
+	if cfg.MaxConcurrentExtractions != runtime.NumCPU() || cfg.ExtractionQueueTimeout != 5*time.Second {
+		t.Errorf("extraction pool = %d, %v, want %d, 5s", cfg.MaxConcurrentExtractions, cfg.ExtractionQueueTimeout, runtime.NumCPU())
+	}
+
+	if cfg.MaxConcurrentPerKey != 0 {
+		t.Errorf("MaxConcurrentPerKey = %d, want 0", cfg.MaxConcurrentPerKey)
+	}
+
+	if cfg.JobTimeout != 10*time.Minute || cfg.JobRetention != time.Hour {
+		t.Errorf("jobs = %v, %v, want 10m, 1h", cfg.JobTimeout, cfg.JobRetention)
+	}
+
+	if cfg.RequestDecompressionMaxRatio != 100 {
+		t.Errorf("RequestDecompressionMaxRatio = %d, want 100", cfg.RequestDecompressionMaxRatio)
+	}
+
+	if cfg.MaxFilesPerRequest != 0 || cfg.MaxRequestSizeMB != 0 {
+		t.Errorf("request limits = %d, %d, want 0, 0", cfg.MaxFilesPerRequest, cfg.MaxRequestSizeMB)
+	}
 }
 
 func TestLoadMissingAPIKeys(t *testing.T) {
@@ -61,6 +110,14 @@ func TestLoadMissingAPIKeys(t *testing.T) {
 	if err == nil {
 		t.Error("Load() should return error when API_KEYS is missing")
 	}
+
+	cfg, err := LoadLocal()
+	if err != nil {
+		t.Fatalf("LoadLocal() error = %v", err)
+	}
+	if len(cfg.APIKeys) != 0 {
+		t.Errorf("LoadLocal() API keys = %v, want none", cfg.APIKeys)
+	}
 }
 
 func TestValidate(t *testing.T) {
@@ -80,6 +137,42 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "negative per-key extraction limit",
+			config: &Config{
+				Port:                "8080",
+				MaxFileSizeMB:       20,
+				RateLimitRequests:   10,
+				RateLimitWindow:     time.Minute,
+				LogLevel:            "info",
+				MaxConcurrentPerKey: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative decompression ratio",
+			config: &Config{
+				Port:                         "8080",
+				MaxFileSizeMB:                20,
+				RateLimitRequests:            10,
+				RateLimitWindow:              time.Minute,
+				LogLevel:                     "info",
+				RequestDecompressionMaxRatio: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative files per request",
+			config: &Config{
+				Port:               "8080",
+				MaxFileSizeMB:      20,
+				RateLimitRequests:  10,
+				RateLimitWindow:    time.Minute,
+				LogLevel:           "info",
+				MaxFilesPerRequest: -1,
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid log level",
 			config: &Config{
@@ -102,6 +195,187 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "classifier weight above 1",
+			config: &Config{
+				Port:               "8080",
+				MaxFileSizeMB:      20,
+				RateLimitRequests:  10,
+				RateLimitWindow:    time.Minute,
+				LogLevel:           "info",
+				AIClassifierWeight: 1.5,
+			},
+			wantErr: true,
+		},
+		{
+			name: "document limit above the buffered head",
+			config: &Config{
+				Port:                "8080",
+				MaxFileSizeMB:       20,
+				RateLimitRequests:   10,
+				RateLimitWindow:     time.Minute,
+				LogLevel:            "info",
+				MemoryMaxDocumentKB: 2048,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative extraction timeout",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				ExtractionTimeout: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "uploads without a job timeout",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				UploadMaxSizeMB:   100,
+				UploadExpiry:      time.Hour,
+				JobRetention:      time.Hour,
+			},
+			wantErr: true,
+		},
+		{
+			name: "remote storage without a chunk size",
+			config: &Config{
+				Port:                  "8080",
+				MaxFileSizeMB:         20,
+				RateLimitRequests:     10,
+				RateLimitWindow:       time.Minute,
+				LogLevel:              "info",
+				GCSEnabled:            true,
+				StorageRequestTimeout: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "webhook without a timeout",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				WebhookURL:        "https://hooks.example.com/file-meta",
+			},
+			wantErr: true,
+		},
+		{
+			name: "kafka without a topic",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				KafkaBrokers:      []string{"localhost:9092"},
+				KafkaBufferSize:   1000,
+				KafkaTimeout:      10 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "nats without a timeout",
+			config: &Config{
+				Port:               "8080",
+				MaxFileSizeMB:      20,
+				RateLimitRequests:  10,
+				RateLimitWindow:    time.Minute,
+				LogLevel:           "info",
+				NATSURL:            "nats://localhost:4222",
+				NATSResultsSubject: "file-meta.results",
+				NATSBufferSize:     1000,
+			},
+			wantErr: true,
+		},
+		{
+			name: "watch without an interval",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				WatchDirs:         []string{"/srv/dropbox"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "database without connections",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				DatabaseURL:       "postgres://localhost/files",
+				DatabaseTimeout:   10 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "database and history file",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				DatabaseURL:       "postgres://localhost/files",
+				DatabaseMaxConns:  4,
+				DatabaseTimeout:   10 * time.Second,
+				HistoryFile:       "/var/lib/file-meta/history.ndjson",
+			},
+			wantErr: true,
+		},
+		{
+			name: "usage tracking without retention",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				UsageTracking:     true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative sandbox memory",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				SandboxEnabled:    true,
+				SandboxMemoryMB:   -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "sandbox memory over 4 GB",
+			config: &Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				SandboxEnabled:    true,
+				SandboxMemoryMB:   8192,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -113,3 +387,732 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadClamAV(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	os.Setenv("CLAMAV_ADDRESS", "tcp://clamav:3310")
+	os.Setenv("CLAMAV_TIMEOUT", "10s")
+	os.Setenv("CLAMAV_FAIL_MODE", "closed")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("CLAMAV_ADDRESS")
+		os.Unsetenv("CLAMAV_TIMEOUT")
+		os.Unsetenv("CLAMAV_FAIL_MODE")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.ClamAVAddress != "tcp://clamav:3310" {
+		t.Errorf("ClamAVAddress = %v, want tcp://clamav:3310", cfg.ClamAVAddress)
+	}
+
+	if cfg.ClamAVTimeout != 10*time.Second {
+		t.Errorf("ClamAVTimeout = %v, want 10s", cfg.ClamAVTimeout)
+	}
+
+	if cfg.ClamAVFailOpen {
+		t.Errorf("ClamAVFailOpen = %v, want false", cfg.ClamAVFailOpen)
+	}
+
+	os.Setenv("CLAMAV_FAIL_MODE", "maybe")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for invalid CLAMAV_FAIL_MODE")
+	}
+}
+
+func TestLoadAdminAPIKeys(t *testing.T) {
+	os.Setenv("API_KEYS", "customer, operator")
+	os.Setenv("ADMIN_API_KEYS", "operator")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("ADMIN_API_KEYS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.AdminAPIKeys["operator"] || cfg.AdminAPIKeys["customer"] {
+		t.Errorf("AdminAPIKeys = %v, want only operator", cfg.AdminAPIKeys)
+	}
+
+	os.Setenv("ADMIN_API_KEYS", "stranger")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an admin key missing from API_KEYS")
+	}
+}
+
+func TestLoadRateLimitAlgorithm(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	defer os.Unsetenv("API_KEYS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RateLimitAlgorithm != RateLimitTokenBucket {
+		t.Errorf("RateLimitAlgorithm = %q, want %q", cfg.RateLimitAlgorithm, RateLimitTokenBucket)
+	}
+
+	os.Setenv("RATE_LIMIT_ALGORITHM", "sliding_window")
+	defer os.Unsetenv("RATE_LIMIT_ALGORITHM")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RateLimitAlgorithm != RateLimitSlidingWindow {
+		t.Errorf("RateLimitAlgorithm = %q, want %q", cfg.RateLimitAlgorithm, RateLimitSlidingWindow)
+	}
+
+	os.Setenv("RATE_LIMIT_ALGORITHM", "leaky_bucket")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an unknown RATE_LIMIT_ALGORITHM")
+	}
+}
+
+func TestLoadLogFilenames(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	defer os.Unsetenv("API_KEYS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LogFilenames != logger.FilenamesExtension {
+		t.Errorf("LogFilenames = %q, want %q", cfg.LogFilenames, logger.FilenamesExtension)
+	}
+
+	os.Setenv("LOG_FILENAMES", "hash")
+	defer os.Unsetenv("LOG_FILENAMES")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.LogFilenames != logger.FilenamesHash {
+		t.Errorf("LogFilenames = %q, want %q", cfg.LogFilenames, logger.FilenamesHash)
+	}
+
+	os.Setenv("LOG_FILENAMES", "none")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an unknown LOG_FILENAMES")
+	}
+}
+
+func TestLoadShutdownDrainDelay(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	defer os.Unsetenv("API_KEYS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ShutdownDrainDelay != 5*time.Second {
+		t.Errorf("ShutdownDrainDelay = %v, want 5s", cfg.ShutdownDrainDelay)
+	}
+
+	defer os.Unsetenv("SHUTDOWN_DRAIN_DELAY")
+	for _, value := range []string{"soon", "-1s"} {
+		os.Setenv("SHUTDOWN_DRAIN_DELAY", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() should return error for SHUTDOWN_DRAIN_DELAY=%s", value)
+		}
+	}
+}
+
+func TestLoadServerLimits(t *testing.T) {
+	t.Setenv("API_KEYS", "test_key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ServerReadTimeout != 15*time.Second || cfg.ServerReadHeaderTimeout != 10*time.Second || cfg.ServerWriteTimeout != 15*time.Second ||
+		cfg.ServerIdleTimeout != time.Minute || cfg.ServerMaxHeaderBytes != 1<<20 || !cfg.ServerKeepAlives {
+		t.Errorf("server limits = %v %v %v %v %d %v, want the defaults", cfg.ServerReadTimeout, cfg.ServerReadHeaderTimeout,
+			cfg.ServerWriteTimeout, cfg.ServerIdleTimeout, cfg.ServerMaxHeaderBytes, cfg.ServerKeepAlives)
+	}
+
+	t.Setenv("SERVER_READ_TIMEOUT", "0")
+	t.Setenv("SERVER_WRITE_TIMEOUT", "10m")
+	t.Setenv("SERVER_KEEP_ALIVES", "false")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ServerReadTimeout != 0 || cfg.ServerWriteTimeout != 10*time.Minute || cfg.ServerKeepAlives {
+		t.Errorf("read timeout %v, write timeout %v, keep-alives %v, want none, 10m and off", cfg.ServerReadTimeout, cfg.ServerWriteTimeout, cfg.ServerKeepAlives)
+	}
+
+	for name, value := range map[string]string{"SERVER_IDLE_TIMEOUT": "-1s", "SERVER_READ_HEADER_TIMEOUT": "soon", "SERVER_MAX_HEADER_BYTES": "-1"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := Load(); err == nil {
+				t.Errorf("Load() should return error for %s=%s", name, value)
+			}
+		})
+	}
+}
+
+func TestLoadRateLimitFailMode(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	os.Setenv("RATE_LIMIT_FAIL_MODE", "closed")
+	os.Setenv("RATE_LIMIT_BREAKER_COOLDOWN", "1m")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("RATE_LIMIT_FAIL_MODE")
+		os.Unsetenv("RATE_LIMIT_BREAKER_COOLDOWN")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RateLimitFailMode != RateLimitFailClosed || cfg.RateLimitBreakerFailures != 5 || cfg.RateLimitBreakerCooldown != time.Minute {
+		t.Errorf("fail mode = %q after %d failures for %v, want closed after 5 for 1m",
+			cfg.RateLimitFailMode, cfg.RateLimitBreakerFailures, cfg.RateLimitBreakerCooldown)
+	}
+
+	os.Setenv("RATE_LIMIT_QUEUE_MAX_DELAY", "-1s")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for a negative RATE_LIMIT_QUEUE_MAX_DELAY")
+	}
+	os.Unsetenv("RATE_LIMIT_QUEUE_MAX_DELAY")
+
+	os.Setenv("RATE_LIMIT_FAIL_MODE", "maybe")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for invalid RATE_LIMIT_FAIL_MODE")
+	}
+}
+
+func TestLoadHashedAPIKeys(t *testing.T) {
+	hashed := HashAPIKey("operator")
+	os.Setenv("API_KEYS", "customer, "+APIKeyHashPrefix+strings.ToUpper(hashed[len(APIKeyHashPrefix):]))
+	os.Setenv("ADMIN_API_KEYS", hashed)
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("ADMIN_API_KEYS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.APIKeys[hashed] || !cfg.AdminAPIKeys[hashed] {
+		t.Errorf("APIKeys = %v, AdminAPIKeys = %v, want %s in both", cfg.APIKeys, cfg.AdminAPIKeys, hashed)
+	}
+
+	tests := []struct {
+		key       string
+		wantEntry string
+		wantOK    bool
+	}{
+		{"customer", "customer", true},
+		{"operator", hashed, true},
+		{hashed, "", false},
+		{"stranger", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if entry, ok := cfg.APIKey(tt.key); entry != tt.wantEntry || ok != tt.wantOK {
+			t.Errorf("APIKey(%q) = %q, %v, want %q, %v", tt.key, entry, ok, tt.wantEntry, tt.wantOK)
+		}
+	}
+
+	os.Setenv("API_KEYS", "customer,"+APIKeyHashPrefix+"abc123")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for a hashed key that isn't a SHA-256 digest")
+	}
+}
+
+func TestLoadKeyScopes(t *testing.T) {
+	os.Setenv("API_KEYS", "dashboard,ops,customer")
+	os.Setenv("ADMIN_API_KEYS", "ops")
+	os.Setenv("API_KEY_SCOPES", "dashboard=metadata:read; ops=metadata:read,admin")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("ADMIN_API_KEYS")
+		os.Unsetenv("API_KEY_SCOPES")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		key   string
+		scope string
+		want  bool
+	}{
+		{"dashboard", ScopeMetadataRead, true},
+		{"dashboard", ScopeMetadataWrite, false},
+		{"dashboard", ScopeAdmin, false},
+		{"ops", ScopeMetadataWrite, false},
+		{"ops", ScopeAdmin, true},
+		{"customer", ScopeMetadataRead, true},
+		{"customer", ScopeMetadataWrite, true},
+		{"customer", ScopeAdmin, false},
+	}
+	for _, tt := range tests {
+		if got := cfg.HasScope(tt.key, tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q, %q) = %v, want %v", tt.key, tt.scope, got, tt.want)
+		}
+	}
+
+	for _, scopes := range []string{"dashboard=sanitize", "stranger=metadata:read", "dashboard=", "dashboard"} {
+		os.Setenv("API_KEY_SCOPES", scopes)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() should return error for API_KEY_SCOPES=%s", scopes)
+		}
+	}
+}
+
+func TestLoadKeyRotations(t *testing.T) {
+	os.Setenv("API_KEYS", "old_key,other_key")
+	os.Setenv("API_KEY_ROTATIONS", "old_key>new_key@2026-01-01T00:00:00Z, new_key>"+HashAPIKey("newest_key")+"@2026-06-01T00:00:00Z")
+	os.Setenv("API_TIERS", "pro=requests:100")
+	os.Setenv("API_KEY_TIERS", "old_key=pro")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("API_KEY_ROTATIONS")
+		os.Unsetenv("API_TIERS")
+		os.Unsetenv("API_KEY_TIERS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	before := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)
+	between := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		key        string
+		now        time.Time
+		wantOK     bool
+		wantSunset time.Time
+	}{
+		{"old_key", before, true, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"old_key", between, false, time.Time{}},
+		{"new_key", between, true, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"new_key", after, false, time.Time{}},
+		{"newest_key", after, true, time.Time{}},
+		{"other_key", after, true, time.Time{}},
+	}
+	for _, tt := range tests {
+		entry, sunset, ok := cfg.LookupAPIKey(tt.key, tt.now)
+		if ok != tt.wantOK || !sunset.Equal(tt.wantSunset) {
+			t.Errorf("LookupAPIKey(%q, %v) = %v, %v, want %v, %v", tt.key, tt.now, sunset, ok, tt.wantSunset, tt.wantOK)
+		}
+		// Replacements keep the identity, and with it the tier
+		if ok && tt.key != "other_key" && (entry != "old_key" || cfg.Limits(entry).Name != "pro") {
+			t.Errorf("LookupAPIKey(%q) entry = %q, want old_key", tt.key, entry)
+		}
+	}
+
+	for _, rotations := range []string{
+		"stranger>new_key@2026-01-01T00:00:00Z",
+		"old_key>other_key@2026-01-01T00:00:00Z",
+		"old_key>new_key@2026-01-01T00:00:00Z,old_key>newer_key@2026-01-01T00:00:00Z",
+		"old_key>new_key@next week",
+		"old_key@2026-01-01T00:00:00Z",
+	} {
+		os.Setenv("API_KEY_ROTATIONS", rotations)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() should return error for API_KEY_ROTATIONS=%s", rotations)
+		}
+	}
+}
+
+func TestLoadOAuthClients(t *testing.T) {
+	os.Setenv("API_KEYS", "reporting_key,"+HashAPIKey("billing_key"))
+	os.Setenv("OAUTH_CLIENTS", "reporting=reporting_key, billing="+HashAPIKey("billing_key"))
+	os.Setenv("OAUTH_TOKEN_SECRET", "0123456789abcdef0123456789abcdef")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("OAUTH_CLIENTS")
+		os.Unsetenv("OAUTH_TOKEN_SECRET")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.OAuthClients["reporting"] != "reporting_key" || cfg.OAuthClients["billing"] != HashAPIKey("billing_key") || cfg.OAuthTokenTTL != time.Hour {
+		t.Errorf("OAuthClients = %v with TTL %v, want both clients with 1h", cfg.OAuthClients, cfg.OAuthTokenTTL)
+	}
+
+	os.Setenv("OAUTH_TOKEN_SECRET", "too short")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for a short OAUTH_TOKEN_SECRET")
+	}
+	os.Setenv("OAUTH_TOKEN_SECRET", "0123456789abcdef0123456789abcdef")
+
+	os.Setenv("OAUTH_CLIENTS", "stranger=stranger_key")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for a client whose key isn't in API_KEYS")
+	}
+}
+
+func TestLoadClientCertKeys(t *testing.T) {
+	os.Setenv("API_KEYS", "reporting_key")
+	os.Setenv("TLS_CERT_FILE", "/etc/file-meta/server.crt")
+	os.Setenv("TLS_KEY_FILE", "/etc/file-meta/server.key")
+	os.Setenv("TLS_CLIENT_CA_FILE", "/etc/file-meta/clients.crt")
+	os.Setenv("CLIENT_CERT_KEYS", "reporting=reporting_key, spiffe://example.org/billing=reporting_key")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("TLS_CERT_FILE")
+		os.Unsetenv("TLS_KEY_FILE")
+		os.Unsetenv("TLS_CLIENT_CA_FILE")
+		os.Unsetenv("CLIENT_CERT_KEYS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ClientCertKeys["reporting"] != "reporting_key" || cfg.ClientCertKeys["spiffe://example.org/billing"] != "reporting_key" || cfg.TLSClientCertOptional {
+		t.Errorf("ClientCertKeys = %v, optional %v, want both names and required certificates", cfg.ClientCertKeys, cfg.TLSClientCertOptional)
+	}
+
+	os.Setenv("CLIENT_CERT_KEYS", "stranger=stranger_key")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for a certificate whose key isn't in API_KEYS")
+	}
+	os.Unsetenv("CLIENT_CERT_KEYS")
+
+	os.Unsetenv("TLS_KEY_FILE")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for TLS_CERT_FILE without TLS_KEY_FILE")
+	}
+}
+
+func TestLoadAutocert(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "autocert", env: map[string]string{"TLS_AUTOCERT_DOMAINS": "files.example.com, meta.example.com", "HTTP_REDIRECT_PORT": "80", "TLS_MIN_VERSION": "1.3"}},
+		{name: "certificate files with redirect", env: map[string]string{"TLS_CERT_FILE": "server.crt", "TLS_KEY_FILE": "server.key", "HTTP_REDIRECT_PORT": "80"}},
+		{name: "autocert without redirect port", env: map[string]string{"TLS_AUTOCERT_DOMAINS": "files.example.com"}, wantErr: true},
+		{name: "autocert and certificate files", env: map[string]string{"TLS_AUTOCERT_DOMAINS": "files.example.com", "HTTP_REDIRECT_PORT": "80", "TLS_CERT_FILE": "server.crt", "TLS_KEY_FILE": "server.key"}, wantErr: true},
+		{name: "redirect without TLS", env: map[string]string{"HTTP_REDIRECT_PORT": "80"}, wantErr: true},
+		{name: "redirect to itself", env: map[string]string{"TLS_AUTOCERT_DOMAINS": "files.example.com", "HTTP_REDIRECT_PORT": "8080"}, wantErr: true},
+		{name: "invalid min version", env: map[string]string{"TLS_MIN_VERSION": "1.1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_KEYS", "test_key")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !cfg.TLSEnabled() {
+				t.Error("TLSEnabled() = false, want true")
+			}
+		})
+	}
+
+	t.Setenv("API_KEYS", "test_key")
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "files.example.com, meta.example.com")
+	t.Setenv("HTTP_REDIRECT_PORT", "80")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(cfg.TLSAutocertDomains, ",") != "files.example.com,meta.example.com" || cfg.TLSMinVersion != tls.VersionTLS12 || !strings.Contains(cfg.TLSAutocertDirectoryURL, "letsencrypt.org") {
+		t.Errorf("domains %v, min version %x, directory %s, want both domains, TLS 1.2 and Let's Encrypt", cfg.TLSAutocertDomains, cfg.TLSMinVersion, cfg.TLSAutocertDirectoryURL)
+	}
+}
+
+func TestLoadAuthBan(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	os.Setenv("AUTH_BAN_THRESHOLD", "5")
+	os.Setenv("AUTH_BAN_DURATION", "30s")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("AUTH_BAN_THRESHOLD")
+		os.Unsetenv("AUTH_BAN_DURATION")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AuthBanThreshold != 5 || cfg.AuthBanWindow != 10*time.Minute || cfg.AuthBanDuration != 30*time.Second || cfg.AuthBanMaxDuration != time.Hour {
+		t.Errorf("auth ban = %d in %v for %v up to %v, want 5 in 10m for 30s up to 1h",
+			cfg.AuthBanThreshold, cfg.AuthBanWindow, cfg.AuthBanDuration, cfg.AuthBanMaxDuration)
+	}
+
+	os.Setenv("AUTH_BAN_MAX_DURATION", "10s")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for AUTH_BAN_MAX_DURATION below AUTH_BAN_DURATION")
+	}
+	os.Unsetenv("AUTH_BAN_MAX_DURATION")
+
+	os.Setenv("AUTH_BAN_WINDOW", "soon")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for invalid AUTH_BAN_WINDOW")
+	}
+	os.Unsetenv("AUTH_BAN_WINDOW")
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.7 ,fd00::/8")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("TRUSTED_PROXIES")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "fd00::/8"}
+	if len(cfg.TrustedProxies) != len(want) {
+		t.Fatalf("TrustedProxies = %v, want %v", cfg.TrustedProxies, want)
+	}
+	for i, prefix := range cfg.TrustedProxies {
+		if prefix.String() != want[i] {
+			t.Errorf("TrustedProxies[%d] = %v, want %s", i, prefix, want[i])
+		}
+	}
+
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/33")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an invalid TRUSTED_PROXIES range")
+	}
+}
+
+func TestLoadRateLimitExemptKeys(t *testing.T) {
+	os.Setenv("API_KEYS", "customer, prober")
+	os.Setenv("RATE_LIMIT_EXEMPT_KEYS", "prober")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("RATE_LIMIT_EXEMPT_KEYS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.RateLimitExemptKeys["prober"] || cfg.RateLimitExemptKeys["customer"] {
+		t.Errorf("RateLimitExemptKeys = %v, want only prober", cfg.RateLimitExemptKeys)
+	}
+
+	os.Setenv("RATE_LIMIT_EXEMPT_KEYS", "stranger")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an exempt key missing from API_KEYS")
+	}
+}
+
+func TestLoadTiers(t *testing.T) {
+	os.Setenv("API_KEYS", "free_key,paid_key,c2VjcmV0==")
+	os.Setenv("RATE_LIMIT_REQUESTS", "10")
+	os.Setenv("MAX_FILE_SIZE_MB", "20")
+	os.Setenv("API_TIERS", "paid=requests:600,burst:6000,max_file_size_mb:500; trial=window:1h")
+	os.Setenv("API_KEY_TIERS", "paid_key=paid,c2VjcmV0===trial")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("RATE_LIMIT_REQUESTS")
+		os.Unsetenv("MAX_FILE_SIZE_MB")
+		os.Unsetenv("API_TIERS")
+		os.Unsetenv("API_KEY_TIERS")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		want Tier
+	}{
+		{key: "free_key", want: Tier{RateLimitRequests: 10, RateLimitWindow: time.Minute, MaxFileSizeMB: 20}},
+		{key: "paid_key", want: Tier{Name: "paid", RateLimitRequests: 600, RateLimitWindow: time.Minute, RateLimitBurst: 6000, MaxFileSizeMB: 500}},
+		{key: "c2VjcmV0==", want: Tier{Name: "trial", RateLimitRequests: 10, RateLimitWindow: time.Hour, MaxFileSizeMB: 20}},
+	}
+	for _, tt := range tests {
+		if got := cfg.Limits(tt.key); got != tt.want {
+			t.Errorf("Limits(%s) = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+
+	invalid := []struct{ tiers, keyTiers string }{
+		{tiers: "paid=requests:many", keyTiers: ""},
+		{tiers: "paid=rate:5", keyTiers: ""},
+		{tiers: "paid=burst:-1", keyTiers: ""},
+		{tiers: "paid=requests:0", keyTiers: ""},
+		{tiers: "paid=requests:600", keyTiers: "paid_key=gold"},
+		{tiers: "paid=requests:600", keyTiers: "unknown_key=paid"},
+	}
+	for _, tt := range invalid {
+		os.Setenv("API_TIERS", tt.tiers)
+		os.Setenv("API_KEY_TIERS", tt.keyTiers)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() with API_TIERS=%q API_KEY_TIERS=%q should return error", tt.tiers, tt.keyTiers)
+		}
+	}
+}
+
+func TestLoadAIClassifier(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	os.Setenv("AI_CLASSIFIER_URL", "http://classifier:9000/v1/classify")
+	os.Setenv("AI_CLASSIFIER_TIMEOUT", "5s")
+	os.Setenv("AI_CLASSIFIER_WEIGHT", "0.5")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("AI_CLASSIFIER_URL")
+		os.Unsetenv("AI_CLASSIFIER_TIMEOUT")
+		os.Unsetenv("AI_CLASSIFIER_WEIGHT")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.AIClassifierURL != "http://classifier:9000/v1/classify" {
+		t.Errorf("AIClassifierURL = %v, want http://classifier:9000/v1/classify", cfg.AIClassifierURL)
+	}
+
+	if cfg.AIClassifierTimeout != 5*time.Second {
+		t.Errorf("AIClassifierTimeout = %v, want 5s", cfg.AIClassifierTimeout)
+	}
+
+	if cfg.AIClassifierWeight != 0.5 {
+		t.Errorf("AIClassifierWeight = %v, want 0.5", cfg.AIClassifierWeight)
+	}
+
+	os.Setenv("AI_CLASSIFIER_WEIGHT", "2")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for AI_CLASSIFIER_WEIGHT above 1")
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	os.Setenv("API_KEYS", "test_key")
+	os.Setenv("EXTRACTION_PROFILES", "fast=ssdeep; thumbnails = image ,screenshot_detection;empty=")
+	os.Setenv("DEFAULT_PROFILE", "Thumbnails")
+
+	defer func() {
+		os.Unsetenv("API_KEYS")
+		os.Unsetenv("EXTRACTION_PROFILES")
+		os.Unsetenv("DEFAULT_PROFILE")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got := cfg.Profiles["fast"]; len(got) != 1 || got[0] != "ssdeep" {
+		t.Errorf("Profiles[fast] = %v, want [ssdeep]", got)
+	}
+
+	if got := cfg.Profiles["thumbnails"]; len(got) != 2 || got[1] != "screenshot_detection" {
+		t.Errorf("Profiles[thumbnails] = %v, want [image screenshot_detection]", got)
+	}
+
+	if got, ok := cfg.Profiles["empty"]; !ok || len(got) != 0 {
+		t.Errorf("Profiles[empty] = %v, want an empty profile", got)
+	}
+
+	if _, ok := cfg.Profiles["forensic"]; !ok {
+		t.Error("default forensic profile should still be defined")
+	}
+
+	if cfg.DefaultProfile != "thumbnails" {
+		t.Errorf("DefaultProfile = %v, want thumbnails", cfg.DefaultProfile)
+	}
+
+	os.Setenv("EXTRACTION_PROFILES", "fast=ssdeep,thumbnails")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an unknown module")
+	}
+
+	os.Setenv("EXTRACTION_PROFILES", "")
+	os.Setenv("DEFAULT_PROFILE", "thumbnails")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an undefined DEFAULT_PROFILE")
+	}
+}
+
+func TestLoadMalformedValues(t *testing.T) {
+	t.Setenv("API_KEYS", "test_key")
+	t.Setenv("MAX_FILE_SIZE_MB", "20MB")
+	t.Setenv("DOCS_UI", "yes please")
+	t.Setenv("AI_CLASSIFIER_WEIGHT", "high")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load() should return error for malformed values")
+	}
+	for _, name := range []string{"MAX_FILE_SIZE_MB", "DOCS_UI", "AI_CLASSIFIER_WEIGHT"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Load() error = %v, want %s mentioned", err, name)
+		}
+	}
+}
+
+func TestLoadDisabledModules(t *testing.T) {
+	t.Setenv("API_KEYS", "test_key")
+	t.Setenv("DISABLED_MODULES", " AI_Detection, screenshot_detection,")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.DisabledModules, ","); got != "ai_detection,screenshot_detection" {
+		t.Errorf("DisabledModules = %v, want [ai_detection screenshot_detection]", cfg.DisabledModules)
+	}
+
+	t.Setenv("DISABLED_MODULES", "ai_detection,ocr")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for an unknown module")
+	}
+}
+
+func TestLoadFilePolicy(t *testing.T) {
+	t.Setenv("API_KEYS", "test_key")
+	t.Setenv("ALLOWED_MIME_TYPES", "image/*, application/pdf,")
+	t.Setenv("DENIED_EXTENSIONS", "exe, .dll")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.AllowedMIMETypes, ","); got != "image/*,application/pdf" {
+		t.Errorf("AllowedMIMETypes = %v, want [image/* application/pdf]", cfg.AllowedMIMETypes)
+	}
+	if got := strings.Join(cfg.DeniedExtensions, ","); got != "exe,.dll" {
+		t.Errorf("DeniedExtensions = %v, want [exe .dll]", cfg.DeniedExtensions)
+	}
+
+	t.Setenv("DENIED_MIME_TYPES", "executable")
+	if _, err := Load(); err == nil {
+		t.Error("Load() should return error for a value that is not a MIME type")
+	}
+}
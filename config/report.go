@@ -0,0 +1,100 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Setting is one field of the configuration, formatted for people
+type Setting struct {
+	Name  string
+	Value string
+}
+
+// Report lists every configuration field in declaration order. Fields
+// tagged report:"secret" only show whether they are set, and credentials in
+// URLs are masked.
+func (c *Config) Report() []Setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	settings := make([]Setting, 0, t.NumField())
+	for i := range t.NumField() {
+		field := t.Field(i)
+		value := v.Field(i)
+		var formatted string
+		switch {
+		case field.Tag.Get("report") == "secret":
+			formatted = redacted(value)
+		case field.Name == "TLSMinVersion":
+			formatted = tls.VersionName(c.TLSMinVersion)
+		default:
+			formatted = formatValue(value)
+		}
+		settings = append(settings, Setting{Name: field.Name, Value: formatted})
+	}
+	return settings
+}
+
+// redacted describes a secret without revealing it
+func redacted(v reflect.Value) string {
+	switch {
+	case v.IsZero():
+		return ""
+	case v.Kind() == reflect.Map:
+		return fmt.Sprintf("[%d redacted]", v.Len())
+	default:
+		return "[redacted]"
+	}
+}
+
+// formatValue formats a field: durations as 1m30s, lists comma-separated
+// and maps as sorted key=value pairs separated by semicolons
+func formatValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case time.Duration:
+		return value.String()
+	case string:
+		return maskURLUserinfo(value)
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		items := make([]string, 0, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			items = append(items, formatValue(iter.Key())+"="+formatValue(iter.Value()))
+		}
+		slices.Sort(items)
+		return strings.Join(items, ";")
+	case reflect.Struct:
+		return fmt.Sprintf("%+v", v.Interface())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// maskURLUserinfo hides the credentials of a URL such as redis://:pw@host.
+// A username alone is masked too, since it may be a token, as in
+// nats://token@host.
+func maskURLUserinfo(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		// It may still hold credentials
+		return "[redacted]"
+	}
+	if u.User != nil {
+		u.User = url.User("xxxxx")
+	}
+	return u.String()
+}
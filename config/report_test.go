@@ -0,0 +1,54 @@
+package config
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	cfg := &Config{
+		Port:             "8080",
+		APIKeys:          map[string]bool{"sk_live_abc": true, "sk_live_def": true},
+		RateLimitWindow:  90 * time.Second,
+		RedisURL:         "redis://:hunter2@redis.internal:6379/0",
+		NATSURL:          "nats://s3cr3t-token@nats.internal:4222",
+		WebhookSecret:    "whsec_123",
+		GeoIPDatabases:   []string{"/geo/city.mmdb", "/geo/asn.mmdb"},
+		Profiles:         map[string][]string{"fast": {"image"}},
+		Tiers:            map[string]Tier{"pro": {Name: "pro", RateLimitRequests: 100}},
+		OAuthTokenSecret: []byte("signing-secret"),
+		TLSMinVersion:    tls.VersionTLS13,
+	}
+
+	report := map[string]string{}
+	for _, s := range cfg.Report() {
+		report[s.Name] = s.Value
+		if strings.Contains(s.Value, "sk_live") || strings.Contains(s.Value, "hunter2") || strings.Contains(s.Value, "s3cr3t-token") || strings.Contains(s.Value, "secret") {
+			t.Errorf("%s = %q reveals a secret", s.Name, s.Value)
+		}
+	}
+
+	tests := map[string]string{
+		"Port":             "8080",
+		"APIKeys":          "[2 redacted]",
+		"RateLimitWindow":  "1m30s",
+		"RedisURL":         "redis://xxxxx@redis.internal:6379/0",
+		"NATSURL":          "nats://xxxxx@nats.internal:4222",
+		"WebhookSecret":    "[redacted]",
+		"KafkaPassword":    "",
+		"GeoIPDatabases":   "/geo/city.mmdb,/geo/asn.mmdb",
+		"Profiles":         "fast=image",
+		"OAuthTokenSecret": "[redacted]",
+		"TLSMinVersion":    "TLS 1.3",
+	}
+	for name, want := range tests {
+		if got, ok := report[name]; !ok || got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !strings.Contains(report["Tiers"], "RateLimitRequests:100") {
+		t.Errorf("Tiers = %q, want the tier's fields", report["Tiers"])
+	}
+}
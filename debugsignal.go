@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+// toggleDebugOnSignal makes SIGUSR1 switch debug logging on, or back to
+// LOG_LEVEL
+func toggleDebugOnSignal(cfg *config.Config, log *logger.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			level := logger.DEBUG
+			if log.Level() == logger.DEBUG {
+				level, _ = logger.ParseLevel(cfg.LogLevel)
+			}
+			log.SetLevel(level)
+			log.Warnf("Log level set to %s by SIGUSR1", level)
+		}
+	}()
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+// toggleDebugOnSignal does nothing where there is no SIGUSR1; the admin
+// endpoint still changes the level
+func toggleDebugOnSignal(cfg *config.Config, log *logger.Logger) {}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// ConfigHandler returns this instance's effective configuration by field
+// name, with secrets redacted
+func ConfigHandler(cfg *config.Config, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		settings := make(map[string]string)
+		for _, s := range cfg.Report() {
+			settings[s.Name] = s.Value
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(settings); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
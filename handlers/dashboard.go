@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// Dashboard is what the admin dashboard reports on besides deps
+type Dashboard struct {
+	Activity *middleware.Activity
+	InFlight func() int64 // requests being served, or nil
+	Started  time.Time
+}
+
+// DashboardData is a snapshot of this instance for the admin dashboard.
+// Counts are totals since the start, so clients derive rates from two
+// snapshots.
+type DashboardData struct {
+	Time          time.Time                `json:"time"`
+	UptimeSeconds int64                    `json:"uptime_seconds"`
+	Requests      DashboardRequests        `json:"requests"`
+	Workers       *DashboardWorkers        `json:"workers,omitempty"`
+	RateLimits    []middleware.KeyQuota    `json:"rate_limits"`
+	RecentErrors  []middleware.RecentError `json:"recent_errors"`
+}
+
+// DashboardRequests counts the API requests served
+type DashboardRequests struct {
+	Total        int64 `json:"total"`
+	ClientErrors int64 `json:"client_errors"`
+	ServerErrors int64 `json:"server_errors"`
+	InFlight     int64 `json:"in_flight"`
+}
+
+// DashboardWorkers is the extraction pool's use
+type DashboardWorkers struct {
+	Size    int `json:"size"`
+	InUse   int `json:"in_use"`
+	Waiting int `json:"waiting"`
+}
+
+// DashboardHandler serves the admin dashboard's page and scripts, which
+// hold no data: the page asks for an admin key and polls
+// DashboardDataHandler with it
+func DashboardHandler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	server := http.StripPrefix("/admin/dashboard/", http.FileServerFS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		server.ServeHTTP(w, r)
+	})
+}
+
+// DashboardDataHandler returns a snapshot of this instance's requests,
+// recent errors, per-key rate limits and extraction slots
+func DashboardDataHandler(log *logger.Logger, deps Deps, dashboard Dashboard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		now := time.Now()
+		data := DashboardData{
+			Time:          now,
+			UptimeSeconds: int64(now.Sub(dashboard.Started).Seconds()),
+			RateLimits:    middleware.KeyQuotas(),
+			RecentErrors:  []middleware.RecentError{},
+		}
+		if dashboard.Activity != nil {
+			data.Requests.Total, data.Requests.ClientErrors, data.Requests.ServerErrors = dashboard.Activity.Requests()
+			data.RecentErrors = dashboard.Activity.RecentErrors()
+		}
+		if dashboard.InFlight != nil {
+			data.Requests.InFlight = dashboard.InFlight()
+		}
+		if deps.Workers != nil {
+			data.Workers = &DashboardWorkers{Size: deps.Workers.Size(), InUse: deps.Workers.InUse(), Waiting: deps.Workers.Waiting()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Polls /admin/dashboard/data with the admin key kept in sessionStorage and
+// renders it. Rates are derived from the totals of consecutive snapshots.
+'use strict';
+
+const refreshInterval = 5000;
+const storageKey = 'file-meta-admin-key';
+
+let previous = null;
+let timer = null;
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function setStatus(text, error) {
+  $('status').textContent = text;
+  $('status').classList.toggle('error', Boolean(error));
+}
+
+function rate(current, before, seconds) {
+  if (before === undefined || seconds <= 0) {
+    return '-';
+  }
+  return ((current - before) / seconds).toFixed(2);
+}
+
+function duration(seconds) {
+  const days = Math.floor(seconds / 86400);
+  const hours = Math.floor(seconds % 86400 / 3600);
+  const minutes = Math.floor(seconds % 3600 / 60);
+  return days > 0 ? `${days}d ${hours}h` : `${hours}h ${minutes}m`;
+}
+
+function row(cells, className) {
+  const tr = document.createElement('tr');
+  if (className) {
+    tr.className = className;
+  }
+  for (const [value, numeric] of cells) {
+    const td = document.createElement('td');
+    td.textContent = value;
+    if (numeric) {
+      td.className = 'number';
+    }
+    tr.append(td);
+  }
+  return tr;
+}
+
+function render(data) {
+  const seconds = previous ? (Date.parse(data.time) - Date.parse(previous.time)) / 1000 : 0;
+  const before = previous ? previous.requests : {};
+  $('request-rate').textContent = rate(data.requests.total, before.total, seconds);
+  $('client-error-rate').textContent = rate(data.requests.client_errors, before.client_errors, seconds);
+  $('server-error-rate').textContent = rate(data.requests.server_errors, before.server_errors, seconds);
+  $('in-flight').textContent = data.requests.in_flight;
+  $('uptime').textContent = duration(data.uptime_seconds);
+
+  if (data.workers) {
+    $('workers').textContent = `${data.workers.in_use} / ${data.workers.size}` +
+      (data.workers.waiting > 0 ? ` (+${data.workers.waiting} waiting)` : '');
+    $('workers-meter').value = data.workers.in_use / data.workers.size;
+  } else {
+    $('workers').textContent = 'unlimited';
+  }
+
+  $('rate-limits').replaceChildren(...data.rate_limits.map((q) => row([
+    [q.api_key_id || q.key_id],
+    [`${Math.round(q.saturation * 100)}%`, true],
+    [q.remaining, true],
+    [q.limit, true],
+    [q.requests, true],
+    [q.rate_limited, true],
+    [new Date(q.last_seen).toLocaleTimeString()],
+  ])));
+
+  $('recent-errors').replaceChildren(...data.recent_errors.map((e) => row([
+    [new Date(e.time).toLocaleTimeString()],
+    [e.status, true],
+    [e.method],
+    [e.path],
+    [`${e.duration_ms} ms`, true],
+    [e.request_id],
+  ], e.status >= 500 ? 'server-error' : '')));
+
+  previous = data;
+}
+
+async function refresh() {
+  const key = sessionStorage.getItem(storageKey);
+  try {
+    const response = await fetch('data', {headers: {'X-API-Key': key}, cache: 'no-store'});
+    if (response.status === 401 || response.status === 403) {
+      signOut('This key is not an admin key');
+      return;
+    }
+    if (!response.ok) {
+      setStatus(`Refresh failed: ${response.status}`, true);
+      return;
+    }
+    render(await response.json());
+    setStatus(`Updated ${new Date().toLocaleTimeString()}`);
+  } catch (err) {
+    setStatus(`Refresh failed: ${err.message}`, true);
+  }
+}
+
+function start() {
+  $('sign-in').hidden = true;
+  $('dashboard').hidden = false;
+  $('sign-out').hidden = false;
+  previous = null;
+  refresh();
+  timer = setInterval(refresh, refreshInterval);
+}
+
+function signOut(message) {
+  clearInterval(timer);
+  sessionStorage.removeItem(storageKey);
+  $('sign-in').hidden = false;
+  $('dashboard').hidden = true;
+  $('sign-out').hidden = true;
+  setStatus(message || 'Not connected', Boolean(message));
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+  $('sign-in').addEventListener('submit', (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(storageKey, $('api-key').value);
+    $('api-key').value = '';
+    start();
+  });
+  $('sign-out').addEventListener('click', () => signOut());
+  if (sessionStorage.getItem(storageKey)) {
+    start();
+  }
+});
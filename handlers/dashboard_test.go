@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/workpool"
+	"file-meta/middleware"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	handler := DashboardHandler()
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/admin/dashboard/", "text/html"},
+		{"/admin/dashboard/dashboard.js", "text/javascript"},
+		{"/admin/dashboard/dashboard.css", "text/css"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("GET %s = %d %q, want 200 %s", tt.path, rr.Code, rr.Header().Get("Content-Type"), tt.contentType)
+		}
+		if csp := rr.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self'") {
+			t.Errorf("GET %s Content-Security-Policy = %q, want scripts from self only", tt.path, csp)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/dashboard/", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rr.Code)
+	}
+}
+
+func TestDashboardDataHandler(t *testing.T) {
+	activity := &middleware.Activity{}
+	failing := activity.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/metadata/remote", nil))
+
+	workers := workpool.New(4, 0)
+	release, _ := workers.Acquire(context.Background())
+	defer release()
+
+	handler := DashboardDataHandler(logger.New("info"), Deps{Workers: workers}, Dashboard{
+		Activity: activity,
+		InFlight: func() int64 { return 3 },
+		Started:  time.Now().Add(-time.Hour),
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/dashboard/data", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+
+	var data DashboardData
+	if err := json.NewDecoder(rr.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Requests != (DashboardRequests{Total: 1, ServerErrors: 1, InFlight: 3}) {
+		t.Errorf("requests = %+v, want one server error and 3 in flight", data.Requests)
+	}
+	if data.Workers == nil || *data.Workers != (DashboardWorkers{Size: 4, InUse: 1}) {
+		t.Errorf("workers = %+v, want 1 of 4 in use", data.Workers)
+	}
+	if len(data.RecentErrors) != 1 || data.RecentErrors[0].Path != "/v1/metadata/remote" || data.RecentErrors[0].Status != http.StatusBadGateway {
+		t.Errorf("recent errors = %+v, want the 502", data.RecentErrors)
+	}
+	if data.UptimeSeconds < 3600 {
+		t.Errorf("uptime = %d, want at least an hour", data.UptimeSeconds)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// Dump lists the files a dump wrote
+type Dump struct {
+	Goroutines string `json:"goroutines"`
+	Heap       string `json:"heap"`
+}
+
+// DebugHandler serves net/http/pprof under /debug/pprof/, expvar at
+// /debug/vars and a dump trigger at /debug/dump. It checks nothing, so it
+// must be behind an admin key or on a private listener.
+func DebugHandler(cfg *config.Config, log *logger.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/dump", DumpHandler(cfg, log))
+	return mux
+}
+
+// DumpHandler writes every goroutine's stack and a heap profile, after a
+// garbage collection, to files in DEBUG_DUMP_DIR on POST, so they can be
+// collected from a volume after the fact
+func DumpHandler(cfg *config.Config, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		dump, err := writeDump(cfg.DebugDumpDir, time.Now())
+		if err != nil {
+			log.Errorf("[%s] Failed to write dump: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to write dump")
+			return
+		}
+		log.Warnf("[%s] Wrote goroutine dump %s and heap profile %s", requestID, dump.Goroutines, dump.Heap)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(dump); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
+
+// writeDump writes the goroutine dump and heap profile of now to dir
+func writeDump(dir string, now time.Time) (Dump, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Dump{}, err
+	}
+	stamp := now.UTC().Format("20060102T150405.000Z")
+	dump := Dump{
+		Goroutines: filepath.Join(dir, "goroutines-"+stamp+".txt"),
+		Heap:       filepath.Join(dir, "heap-"+stamp+".pb.gz"),
+	}
+
+	write := func(name string, profile func(*os.File) error) error {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		if err := profile(file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	err := write(dump.Goroutines, func(f *os.File) error {
+		return rpprof.Lookup("goroutine").WriteTo(f, 2)
+	})
+	if err != nil {
+		return Dump{}, fmt.Errorf("goroutines: %w", err)
+	}
+	err = write(dump.Heap, func(f *os.File) error {
+		runtime.GC()
+		return rpprof.Lookup("heap").WriteTo(f, 0)
+	})
+	if err != nil {
+		return Dump{}, fmt.Errorf("heap: %w", err)
+	}
+	return dump, nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+func TestDebugHandler(t *testing.T) {
+	log := logger.New("info")
+	log.SetOutput(&strings.Builder{})
+	cfg := &config.Config{DebugDumpDir: t.TempDir()}
+	handler := DebugHandler(cfg, log)
+
+	tests := []struct {
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{method: http.MethodGet, path: "/debug/pprof/", wantCode: http.StatusOK, wantBody: "goroutine"},
+		{method: http.MethodGet, path: "/debug/pprof/goroutine?debug=1", wantCode: http.StatusOK, wantBody: "goroutine profile"},
+		{method: http.MethodGet, path: "/debug/vars", wantCode: http.StatusOK, wantBody: `"memstats"`},
+		{method: http.MethodGet, path: "/debug/dump", wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.wantCode || !strings.Contains(rr.Body.String(), tt.wantBody) {
+			t.Errorf("%s %s = %d, want %d with %q", tt.method, tt.path, rr.Code, tt.wantCode, tt.wantBody)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/debug/dump", nil))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("POST /debug/dump = %d: %s", rr.Code, rr.Body)
+	}
+	var dump Dump
+	if err := json.NewDecoder(rr.Body).Decode(&dump); err != nil {
+		t.Fatal(err)
+	}
+	goroutines, err := os.ReadFile(dump.Goroutines)
+	if err != nil || !strings.Contains(string(goroutines), "goroutine ") {
+		t.Errorf("goroutine dump %s = %.40q, %v", dump.Goroutines, goroutines, err)
+	}
+	if info, err := os.Stat(dump.Heap); err != nil || info.Size() == 0 {
+		t.Errorf("heap profile %s: %v", dump.Heap, err)
+	}
+}
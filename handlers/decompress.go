@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"file-meta/internal/zstd"
+)
+
+// errUnsupportedEncoding is returned for a Content-Encoding uploads can't use
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// acceptedEncodings lists the upload encodings, for Accept-Encoding
+const acceptedEncodings = "gzip, zstd"
+
+// minExpansionCheck is how much a body may decompress to whatever its ratio,
+// so small files that compress well aren't rejected
+const minExpansionCheck = 1 << 20
+
+// decompressBody replaces a compressed request body with its content, which
+// may be at most maxRatio times the size of what was sent. A zero maxRatio
+// accepts no encoding.
+func decompressBody(r *http.Request, maxRatio int) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+	if maxRatio == 0 {
+		return errUnsupportedEncoding
+	}
+
+	compressed := &countingReader{r: r.Body}
+	var content io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return fmt.Errorf("invalid gzip body: %w", err)
+		}
+		content = gz
+	case "zstd":
+		content = zstd.NewReader(compressed)
+	default:
+		return errUnsupportedEncoding
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{&expansionLimitReader{r: content, compressed: compressed, maxRatio: int64(maxRatio)}, r.Body}
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// expansionLimitReader fails once the content read is more than maxRatio
+// times the compressed bytes it came from
+type expansionLimitReader struct {
+	r          io.Reader
+	compressed *countingReader
+	maxRatio   int64
+	n          int64
+}
+
+func (e *expansionLimitReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.n += int64(n)
+	if e.n > minExpansionCheck && e.n > e.maxRatio*e.compressed.n {
+		return n, fmt.Errorf("%w: body decompresses to over %d times its size", errUploadTooLarge, e.maxRatio)
+	}
+	return n, err
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+func TestMetadataHandlerCompressedUpload(t *testing.T) {
+	log := logger.New("info")
+	jsonBody := []byte(`{"filename":"hello.txt","content_base64":"SGVsbG8sIFdvcmxkIQo="}`)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "hello.txt")
+	part.Write([]byte("Hello, World!\n"))
+	mw.Close()
+
+	// jsonBody compressed with the zstd command line tool
+	zstdJSON := []byte{
+		0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x68, 0x01, 0x02, 0x00, 0x7b, 0x22, 0x66, 0x69, 0x6c, 0x65, 0x6e,
+		0x61, 0x6d, 0x65, 0x22, 0x3a, 0x22, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x2e, 0x74, 0x78, 0x74, 0x22,
+		0x2c, 0x22, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x62, 0x61, 0x73, 0x65, 0x36, 0x34,
+		0x22, 0x3a, 0x22, 0x53, 0x47, 0x56, 0x73, 0x62, 0x47, 0x38, 0x73, 0x49, 0x46, 0x64, 0x76, 0x63,
+		0x6d, 0x78, 0x6b, 0x49, 0x51, 0x6f, 0x3d, 0x22, 0x7d, 0x38, 0x8d, 0xee, 0x45,
+	}
+
+	// A few megabytes of zeros shrink far beyond the allowed ratio
+	var bombForm bytes.Buffer
+	bomb := multipart.NewWriter(&bombForm)
+	part, _ = bomb.CreateFormFile("file", "zeros.bin")
+	part.Write(make([]byte, 3<<20))
+	bomb.Close()
+
+	tests := []struct {
+		name         string
+		encoding     string
+		contentType  string
+		body         []byte
+		maxRatio     int
+		expectedCode int
+		wantAccept   string
+	}{
+		{"gzip JSON", "gzip", "application/json", gzipped(jsonBody), 100, http.StatusOK, ""},
+		{"gzip multipart", "gzip", mw.FormDataContentType(), gzipped(form.Bytes()), 100, http.StatusOK, ""},
+		{"zstd JSON", "zstd", "application/json", zstdJSON, 100, http.StatusOK, ""},
+		{"identity", "identity", "application/json", jsonBody, 100, http.StatusOK, ""},
+		{"unsupported encoding", "br", "application/json", jsonBody, 100, http.StatusUnsupportedMediaType, "gzip, zstd"},
+		{"decompression disabled", "gzip", "application/json", gzipped(jsonBody), 0, http.StatusUnsupportedMediaType, "identity"},
+		{"invalid gzip", "gzip", "application/json", jsonBody, 100, http.StatusBadRequest, ""},
+		{"invalid zstd", "zstd", "application/json", jsonBody, 100, http.StatusBadRequest, ""},
+		{"expands too much", "gzip", bomb.FormDataContentType(), gzipped(bombForm.Bytes()), 100, http.StatusRequestEntityTooLarge, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Port:                         "8080",
+				MaxFileSizeMB:                20,
+				RateLimitRequests:            10,
+				RateLimitWindow:              time.Minute,
+				LogLevel:                     "info",
+				RequestDecompressionMaxRatio: tt.maxRatio,
+			}
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Content-Encoding", tt.encoding)
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.expectedCode, rr.Body.String())
+			}
+			if got := rr.Header().Get("Accept-Encoding"); got != tt.wantAccept {
+				t.Errorf("Accept-Encoding = %q, want %q", got, tt.wantAccept)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var response map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response["filename"] != "hello.txt" || response["size_bytes"] != float64(14) {
+				t.Errorf("response = %v, want the decompressed hello.txt", response)
+			}
+		})
+	}
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/openapi"
+	"file-meta/middleware"
+)
+
+// Index is the root document: what the server is, its API versions and
+// every endpoint, for clients and gateways that discover the API
+type Index struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	APIVersions []IndexVersion    `json:"api_versions"`
+	Endpoints   []IndexEndpoint   `json:"endpoints"`
+	Links       map[string]string `json:"links"`
+}
+
+// IndexVersion is a served API version
+type IndexVersion struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Schema string `json:"schema"` // JSON Schema of the version's result
+}
+
+// IndexEndpoint is a path and the methods it serves, as in the OpenAPI
+// document
+type IndexEndpoint struct {
+	Path          string   `json:"path"`
+	Methods       []string `json:"methods"`
+	Summary       string   `json:"summary,omitempty"`
+	Authenticated bool     `json:"authenticated"` // needs an API key or access token
+}
+
+// IndexHandler serves the root index, built from the OpenAPI document so the
+// two list the same endpoints
+func IndexHandler(cfg *config.Config, log *logger.Logger, buildVersion string) http.HandlerFunc {
+	body, err := json.Marshal(buildIndex(cfg, buildVersion))
+	if err != nil {
+		panic("failed to encode index: " + err.Error())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body); err != nil {
+			log.Errorf("Failed to write index: %v", err)
+		}
+	}
+}
+
+func buildIndex(cfg *config.Config, buildVersion string) Index {
+	index := Index{
+		Name:    "file-meta",
+		Version: buildVersion,
+		Links: map[string]string{
+			"openapi": "/openapi.json",
+			"version": "/version",
+			"health":  "/health",
+		},
+	}
+	if cfg.DocsUI {
+		index.Links["docs"] = "/docs"
+	}
+	for _, v := range Versions {
+		index.APIVersions = append(index.APIVersions, IndexVersion{Name: v.Name, Prefix: "/" + v.Name, Schema: "/" + v.Name + "/schema"})
+	}
+
+	for path, item := range Spec(cfg).Paths {
+		endpoint := IndexEndpoint{Path: path}
+		for _, op := range []struct {
+			method    string
+			operation *openapi.Operation
+		}{
+			{http.MethodGet, item.Get},
+			{http.MethodHead, item.Head},
+			{http.MethodPost, item.Post},
+			{http.MethodPut, item.Put},
+			{http.MethodPatch, item.Patch},
+			{http.MethodDelete, item.Delete},
+			{http.MethodOptions, item.Options},
+		} {
+			if op.operation == nil {
+				continue
+			}
+			endpoint.Methods = append(endpoint.Methods, op.method)
+			if endpoint.Summary == "" {
+				endpoint.Summary = op.operation.Summary
+			}
+			endpoint.Authenticated = endpoint.Authenticated || len(op.operation.Security) > 0
+		}
+		index.Endpoints = append(index.Endpoints, endpoint)
+	}
+	sort.Slice(index.Endpoints, func(i, j int) bool { return index.Endpoints[i].Path < index.Endpoints[j].Path })
+	return index
+}
+
+// AnswerOptions adds header to the responses to OPTIONS requests for next,
+// which answers them itself before authentication, as middleware.CORS does
+func AnswerOptions(header http.Header, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MetadataOptions describes the upload endpoint to OPTIONS requests: its
+// methods, the request bodies it takes and their accepted Content-Encodings
+func MetadataOptions(cfg *config.Config) http.Header {
+	header := http.Header{}
+	header.Set("Allow", "OPTIONS, POST")
+	header.Set("Accept-Post", "multipart/form-data, application/json")
+	if cfg.RequestDecompressionMaxRatio > 0 {
+		header.Set("Accept-Encoding", acceptedEncodings)
+	} else {
+		header.Set("Accept-Encoding", "identity")
+	}
+	return header
+}
+
+// JSONPostOptions describes an endpoint that takes a POSTed JSON body, such
+// as remote ingestion, to OPTIONS requests
+func JSONPostOptions() http.Header {
+	header := http.Header{}
+	header.Set("Allow", "OPTIONS, POST")
+	header.Set("Accept-Post", "application/json")
+	return header
+}
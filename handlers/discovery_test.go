@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/middleware"
+)
+
+func TestIndexHandler(t *testing.T) {
+	log := logger.New("info")
+
+	tests := []struct {
+		name       string
+		cfg        *config.Config
+		method     string
+		wantStatus int
+		wantDocs   bool
+	}{
+		{"get", &config.Config{}, http.MethodGet, http.StatusOK, false},
+		{"docs enabled", &config.Config{DocsUI: true}, http.MethodGet, http.StatusOK, true},
+		{"post", &config.Config{}, http.MethodPost, http.StatusMethodNotAllowed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			IndexHandler(tt.cfg, log, "1.2.3").ServeHTTP(rr, httptest.NewRequest(tt.method, "/", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var index Index
+			if err := json.NewDecoder(rr.Body).Decode(&index); err != nil {
+				t.Fatal(err)
+			}
+			if index.Version != "1.2.3" || len(index.APIVersions) != len(Versions) || index.APIVersions[0].Schema != "/v1/schema" {
+				t.Errorf("index = %+v", index)
+			}
+			if _, ok := index.Links["docs"]; ok != tt.wantDocs {
+				t.Errorf("links = %v, want docs %v", index.Links, tt.wantDocs)
+			}
+
+			endpoints := make(map[string]IndexEndpoint)
+			for _, e := range index.Endpoints {
+				endpoints[e.Path] = e
+			}
+			if e := endpoints["/v1/metadata"]; !slices.Equal(e.Methods, []string{"POST", "OPTIONS"}) || !e.Authenticated {
+				t.Errorf("/v1/metadata = %+v, want authenticated POST and OPTIONS", e)
+			}
+			if e := endpoints["/health"]; !slices.Equal(e.Methods, []string{"GET"}) || e.Authenticated {
+				t.Errorf("/health = %+v, want public GET", e)
+			}
+			if _, ok := endpoints["/"]; !ok {
+				t.Error("index doesn't list itself")
+			}
+		})
+	}
+}
+
+func TestAnswerOptions(t *testing.T) {
+	cfg := &config.Config{RequestDecompressionMaxRatio: 100}
+	handler := AnswerOptions(MetadataOptions(cfg), middleware.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})))
+
+	tests := []struct {
+		method         string
+		wantStatus     int
+		wantAllow      string
+		wantAcceptPost string
+		wantEncoding   string
+	}{
+		{http.MethodOptions, http.StatusNoContent, "OPTIONS, POST", "multipart/form-data, application/json", acceptedEncodings},
+		{http.MethodPost, http.StatusUnauthorized, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tt.method, "/v1/metadata", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if got := rr.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if got := rr.Header().Get("Accept-Post"); got != tt.wantAcceptPost {
+				t.Errorf("Accept-Post = %q, want %q", got, tt.wantAcceptPost)
+			}
+			if got := rr.Header().Get("Accept-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Accept-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+		})
+	}
+}
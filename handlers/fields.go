@@ -0,0 +1,68 @@
+package handlers
+
+import "strings"
+
+// parseFields splits a comma-separated list of dot-separated response paths
+// such as "checksum_sha256,image.width,image.gps". Returns nil when no
+// filtering was requested.
+func parseFields(value string) [][]string {
+	var paths [][]string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			paths = append(paths, strings.Split(field, "."))
+		}
+	}
+	return paths
+}
+
+// SelectFields reduces a response to the comma-separated fields, as the
+// fields option does, or returns it unchanged when fields is empty
+func SelectFields(response any, fields string) (any, error) {
+	paths := parseFields(fields)
+	if paths == nil {
+		return response, nil
+	}
+	return filterFields(response, paths)
+}
+
+// filterFields returns v's JSON form reduced to the requested paths. A path
+// naming an object keeps the whole object; paths that don't exist in the
+// response are ignored.
+func filterFields(v any, paths [][]string) (map[string]any, error) {
+	doc, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make(map[string]any)
+	for _, path := range paths {
+		copyPath(doc, filtered, path)
+	}
+	return filtered, nil
+}
+
+// copyPath copies the value at path from src into dst, creating the
+// intermediate objects it needs only when the path exists
+func copyPath(src, dst map[string]any, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+
+	child, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	next, ok := dst[path[0]].(map[string]any)
+	if !ok {
+		next = make(map[string]any)
+	}
+	copyPath(child, next, path[1:])
+	if len(next) > 0 {
+		dst[path[0]] = next
+	}
+}
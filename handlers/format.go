@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Response serialization formats
+const (
+	FormatJSON    = "json"
+	FormatXML     = "xml"
+	FormatYAML    = "yaml"
+	FormatMsgPack = "msgpack"
+)
+
+// formatContentTypes is the Content-Type sent for each format
+var formatContentTypes = map[string]string{
+	FormatJSON:    "application/json",
+	FormatXML:     "application/xml",
+	FormatYAML:    "application/yaml",
+	FormatMsgPack: "application/msgpack",
+}
+
+// acceptedMediaTypes maps Accept header media types to formats
+var acceptedMediaTypes = map[string]string{
+	"application/json":        FormatJSON,
+	"application/*":           FormatJSON,
+	"*/*":                     FormatJSON,
+	"application/xml":         FormatXML,
+	"text/xml":                FormatXML,
+	"application/yaml":        FormatYAML,
+	"application/x-yaml":      FormatYAML,
+	"text/yaml":               FormatYAML,
+	"application/msgpack":     FormatMsgPack,
+	"application/x-msgpack":   FormatMsgPack,
+	"application/vnd.msgpack": FormatMsgPack,
+}
+
+// negotiateFormat picks the response format from the format parameter, then
+// the Accept header. Unsupported Accept values fall back to JSON; an
+// unsupported format parameter is an error.
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(strings.TrimSpace(r.FormValue("format"))); format != "" {
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", fmt.Errorf("unsupported format %q", format)
+		}
+		return format, nil
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var candidates []candidate
+	for _, value := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		format, ok := acceptedMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{format, q})
+		}
+	}
+
+	// Highest quality wins; ties keep the client's order
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) > 0 {
+		return candidates[0].format, nil
+	}
+	return FormatJSON, nil
+}
+
+// writeResponse sets the Content-Type for format and writes v
+func writeResponse(w http.ResponseWriter, format string, v any) error {
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Add("Vary", "Accept")
+	return encodeResponse(w, format, v)
+}
+
+// encodeResponse serializes v in format. Formats other than JSON are written
+// from v's JSON form so every format carries the same field names.
+func encodeResponse(w io.Writer, format string, v any) error {
+	if format == FormatJSON {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	tree, err := toTree(v)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatXML:
+		return encodeXML(w, "result", tree)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(tree); err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatMsgPack:
+		return msgpack.NewEncoder(w).Encode(tree)
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// toTree converts v to its generic JSON form: objects, arrays, strings,
+// bools, nil, and int64 or float64 numbers
+func toTree(v any) (map[string]any, error) {
+	if tree, ok := v.(map[string]any); ok {
+		return tree, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree map[string]any
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return normalizeNumbers(tree).(map[string]any), nil
+}
+
+// normalizeNumbers replaces json.Number values with int64 where the number
+// is integral and float64 otherwise
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = normalizeNumbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = normalizeNumbers(value)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// encodeXML writes tree as an XML document under a root element. Object
+// keys become child elements in sorted order and array items become <item>
+// elements. Keys that aren't valid element names are written as
+// <entry key="...">.
+func encodeXML(w io.Writer, root string, tree map[string]any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := encodeXMLValue(enc, xml.StartElement{Name: xml.Name{Local: root}}, tree); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func encodeXMLValue(enc *xml.Encoder, start xml.StartElement, v any) error {
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if err := encodeXMLValue(enc, xmlElement(key), v[key]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if err := encodeXMLValue(enc, xml.StartElement{Name: xml.Name{Local: "item"}}, item); err != nil {
+				return err
+			}
+		}
+	case nil:
+		// Empty element
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlElement returns the start element for an object key
+func xmlElement(key string) xml.StartElement {
+	if validXMLName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+	}
+}
+
+// validXMLName reports whether key can be used as an element name as is
+func validXMLName(key string) bool {
+	if key == "" || strings.HasPrefix(strings.ToLower(key), "xml") {
+		return false
+	}
+	for i, c := range key {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		case i > 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
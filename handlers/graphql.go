@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"file-meta/internal/graphql"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/openapi"
+	"file-meta/middleware"
+)
+
+// graphQLMaxLookups bounds the stored results one query may look up
+const graphQLMaxLookups = 100
+
+// graphQLRequest is a GraphQL-over-HTTP request
+type graphQLRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// graphQLResponse is the response to a GraphQL request. Data is omitted
+// when the query couldn't be executed at all.
+type graphQLResponse struct {
+	Data   *graphQLObject `json:"data,omitempty"`
+	Errors []graphQLError `json:"errors,omitempty"`
+}
+
+// graphQLError is an entry of the response's errors
+type graphQLError struct {
+	Message   string             `json:"message"`
+	Locations []graphql.Position `json:"locations,omitempty"`
+	Path      []any              `json:"path,omitempty"`
+}
+
+// graphQLObject is a selected object, encoded with its fields in the order
+// they were selected
+type graphQLObject struct {
+	keys   []string
+	values []any
+}
+
+func (o *graphQLObject) set(key string, value any) {
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, value)
+}
+
+// MarshalJSON implements json.Marshaler
+func (o *graphQLObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// graphQLSchema types stored results for queries. Object types are the
+// component schemas of the response, named as in /openapi.json.
+type graphQLSchema struct {
+	version    Version
+	result     *openapi.Schema
+	components map[string]*openapi.Schema
+}
+
+func newGraphQLSchema(version Version) *graphQLSchema {
+	doc := openapi.New(openapi.Info{})
+	return &graphQLSchema{
+		version:    version,
+		result:     doc.SchemaFor(version.Response),
+		components: doc.Components.Schemas,
+	}
+}
+
+// resolve follows a schema reference and returns the schema and its type
+// name
+func (s *graphQLSchema) resolve(schema *openapi.Schema) (*openapi.Schema, string) {
+	if name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/"); ok {
+		return s.components[name], name
+	}
+	if schema.Type == "array" {
+		item, name := s.resolve(schema.Items)
+		return &openapi.Schema{Type: "array", Items: item}, "[" + name + "]"
+	}
+	if schema.Properties != nil {
+		return schema, "Object"
+	}
+	if schema.Type == "" {
+		return schema, "JSON"
+	}
+	return schema, schema.Type
+}
+
+// GraphQLHandler answers GraphQL queries over stored results, so clients
+// can look up batches of checksums and select exactly the fields they need:
+//
+//	{ results(sha256: ["..."]) { filename checksums { sha256 } image { width } } }
+//
+// result(sha256) returns one result and results(sha256) a list with null
+// for unknown checksums. Results use the newest API version's schema.
+func GraphQLHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	schema := newGraphQLSchema(Versions[len(Versions)-1])
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+
+		if deps.Results == nil && deps.History == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Result lookup is disabled")
+			return
+		}
+
+		var req graphQLRequest
+		switch r.Method {
+		case http.MethodPost:
+			if !isJSONRequest(r) {
+				middleware.WriteError(w, http.StatusUnsupportedMediaType, models.CodeUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFormValueBytes)).Decode(&req); err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid JSON body: "+err.Error())
+				return
+			}
+		case http.MethodGet:
+			req.Query = r.FormValue("query")
+			req.OperationName = r.FormValue("operationName")
+			if variables := r.FormValue("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid variables: "+err.Error())
+					return
+				}
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if req.Query == "" {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "query is required")
+			return
+		}
+
+		response := schema.execute(r, log, requestID, deps, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
+
+// execute parses, validates and runs a query
+func (s *graphQLSchema) execute(r *http.Request, log *logger.Logger, requestID string, deps Deps, req graphQLRequest) graphQLResponse {
+	op, err := graphql.Parse(req.Query, req.OperationName)
+	if err != nil {
+		if parseErr, ok := err.(*graphql.Error); ok {
+			return graphQLResponse{Errors: []graphQLError{{Message: "Syntax error: " + parseErr.Message, Locations: []graphql.Position{parseErr.Position}}}}
+		}
+		return graphQLResponse{Errors: []graphQLError{{Message: err.Error()}}}
+	}
+
+	vars, errs := s.variables(op, req.Variables)
+	errs = append(errs, s.validateRoot(op)...)
+	if len(errs) > 0 {
+		return graphQLResponse{Errors: errs}
+	}
+
+	e := &graphQLExecution{schema: s, r: r, log: log, requestID: requestID, deps: deps, vars: vars}
+	data := &graphQLObject{}
+	for _, field := range op.Selections {
+		data.set(field.Key(), e.root(field))
+	}
+	return graphQLResponse{Data: data, Errors: e.errors}
+}
+
+// variables applies defaults and checks that required variables were sent
+func (s *graphQLSchema) variables(op *graphql.Operation, sent map[string]any) (map[string]any, []graphQLError) {
+	vars := make(map[string]any)
+	var errs []graphQLError
+	for _, definition := range op.Variables {
+		value, ok := sent[definition.Name]
+		if !ok && definition.Default != nil {
+			value, ok = graphql.Resolve(definition.Default, nil), true
+		}
+		if (!ok || value == nil) && definition.NonNull {
+			errs = append(errs, graphQLError{
+				Message:   fmt.Sprintf("Variable \"$%s\" of required type \"%s!\" was not provided.", definition.Name, definition.Type),
+				Locations: []graphql.Position{definition.Position},
+			})
+		}
+		vars[definition.Name] = value
+	}
+	return vars, errs
+}
+
+// validateRoot checks the query's fields and arguments against the schema
+func (s *graphQLSchema) validateRoot(op *graphql.Operation) []graphQLError {
+	declared := make(map[string]bool)
+	for _, definition := range op.Variables {
+		declared[definition.Name] = true
+	}
+
+	var errs []graphQLError
+	fail := func(field *graphql.Field, format string, args ...any) {
+		errs = append(errs, graphQLError{Message: fmt.Sprintf(format, args...), Locations: []graphql.Position{field.Position}})
+	}
+
+	for _, field := range op.Selections {
+		switch field.Name {
+		case "__typename":
+			continue
+		case "result", "results":
+		default:
+			fail(field, "Cannot query field %q on type \"Query\".", field.Name)
+			continue
+		}
+
+		for name, value := range field.Arguments {
+			if name != "sha256" {
+				fail(field, "Unknown argument %q on field \"Query.%s\".", name, field.Name)
+			}
+			if variable, ok := value.(graphql.Variable); ok && !declared[string(variable)] {
+				fail(field, "Variable \"$%s\" is not defined.", variable)
+			}
+		}
+		if _, ok := field.Arguments["sha256"]; !ok {
+			fail(field, "Field \"Query.%s\" argument \"sha256\" is required.", field.Name)
+		}
+		if len(field.Selections) == 0 {
+			_, typeName := s.resolve(s.result)
+			fail(field, "Field %q of type %q must have a selection of subfields.", field.Name, typeName)
+			continue
+		}
+		errs = append(errs, s.validate(field.Selections, s.result)...)
+	}
+	return errs
+}
+
+// validate checks selections against an object schema
+func (s *graphQLSchema) validate(fields []*graphql.Field, schema *openapi.Schema) []graphQLError {
+	object, typeName := s.resolve(schema)
+
+	var errs []graphQLError
+	for _, field := range fields {
+		fail := func(format string, args ...any) {
+			errs = append(errs, graphQLError{Message: fmt.Sprintf(format, args...), Locations: []graphql.Position{field.Position}})
+		}
+		if len(field.Arguments) > 0 {
+			fail("Field %q takes no arguments.", field.Name)
+		}
+		if field.Name == "__typename" {
+			continue
+		}
+
+		property, ok := object.Properties[field.Name]
+		if !ok {
+			fail("Cannot query field %q on type %q.", field.Name, typeName)
+			continue
+		}
+
+		child, childType := s.resolve(property)
+		for child.Type == "array" {
+			child = child.Items
+		}
+		switch {
+		case child.Properties != nil && len(field.Selections) == 0:
+			fail("Field %q of type %q must have a selection of subfields.", field.Name, childType)
+		case child.Properties == nil && len(field.Selections) > 0:
+			fail("Field %q must not have a selection since type %q has no subfields.", field.Name, childType)
+		case len(field.Selections) > 0:
+			errs = append(errs, s.validate(field.Selections, property)...)
+		}
+	}
+	return errs
+}
+
+// graphQLExecution is the state of one query's execution
+type graphQLExecution struct {
+	schema    *graphQLSchema
+	r         *http.Request
+	log       *logger.Logger
+	requestID string
+	deps      Deps
+	vars      map[string]any
+	lookups   int
+	errors    []graphQLError
+}
+
+func (e *graphQLExecution) fail(field *graphql.Field, path []any, format string, args ...any) {
+	e.errors = append(e.errors, graphQLError{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []graphql.Position{field.Position},
+		Path:      path,
+	})
+}
+
+// root resolves a Query field
+func (e *graphQLExecution) root(field *graphql.Field) any {
+	path := []any{field.Key()}
+	if field.Name == "__typename" {
+		return "Query"
+	}
+
+	argument := graphql.Resolve(field.Arguments["sha256"], e.vars)
+	if field.Name == "result" {
+		sum, ok := argument.(string)
+		if !ok {
+			e.fail(field, path, "Argument \"sha256\" must be a String.")
+			return nil
+		}
+		return e.lookup(field, path, sum)
+	}
+
+	list, ok := argument.([]any)
+	if !ok {
+		// A single value is coerced to a list of one
+		list = []any{argument}
+	}
+	results := make([]any, len(list))
+	for i, item := range list {
+		sum, ok := item.(string)
+		if !ok {
+			e.fail(field, append(path, i), "Argument \"sha256\" must be a list of Strings.")
+			continue
+		}
+		results[i] = e.lookup(field, append(path, i), sum)
+	}
+	return results
+}
+
+// lookup fetches a stored result and selects the requested fields
+func (e *graphQLExecution) lookup(field *graphql.Field, path []any, sum string) any {
+	sum = strings.ToLower(sum)
+	if !validSHA256(sum) {
+		e.fail(field, path, "Invalid SHA256 %q.", sum)
+		return nil
+	}
+	if e.lookups++; e.lookups > graphQLMaxLookups {
+		e.fail(field, path, "Query looks up more than %d results.", graphQLMaxLookups)
+		return nil
+	}
+
+	result, err := storedResult(e.r.Context(), e.deps, sum)
+	if err != nil {
+		e.log.Errorf("[%s] Failed to read stored result: %v", e.requestID, err)
+		e.fail(field, path, "Failed to read result.")
+		return nil
+	}
+	if result == nil {
+		return nil
+	}
+
+	tree, err := toTree(e.schema.version.serializeFor(e.r.Context(), result))
+	if err != nil {
+		e.log.Errorf("[%s] Failed to encode result: %v", e.requestID, err)
+		e.fail(field, path, "Failed to encode result.")
+		return nil
+	}
+	return e.schema.selectFields(tree, e.schema.result, field.Selections)
+}
+
+// selectFields picks the selected fields out of a validated value's JSON
+// form. Fields the result omits are null.
+func (s *graphQLSchema) selectFields(value any, schema *openapi.Schema, fields []*graphql.Field) any {
+	resolved, typeName := s.resolve(schema)
+	switch v := value.(type) {
+	case []any:
+		if resolved.Type != "array" || len(fields) == 0 {
+			return v
+		}
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = s.selectFields(item, resolved.Items, fields)
+		}
+		return items
+	case map[string]any:
+		if len(fields) == 0 {
+			return v
+		}
+		object := &graphQLObject{}
+		for _, field := range fields {
+			if field.Name == "__typename" {
+				object.set(field.Key(), typeName)
+				continue
+			}
+			object.set(field.Key(), s.selectFields(v[field.Name], resolved.Properties[field.Name], field.Selections))
+		}
+		return object
+	}
+	return value
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/store"
+)
+
+func TestGraphQLHandler(t *testing.T) {
+	results := store.NewMemoryStore(10, time.Hour)
+	sum := strings.Repeat("ab", 32)
+	missing := strings.Repeat("cd", 32)
+	results.Put(context.Background(), "", &metadata.Result{
+		Filename:  "notes.txt",
+		SizeBytes: 14,
+		MimeType:  "text/plain; charset=utf-8",
+		SHA256:    sum,
+		Document:  &metadata.DocumentMetadata{LineCount: 1},
+	}, 0)
+	handler := GraphQLHandler(logger.New("info"), Deps{Results: results})
+
+	tests := []struct {
+		name      string
+		body      string
+		wantData  string
+		wantError string
+	}{
+		{
+			name:     "single result",
+			body:     `{"query": "{ result(sha256: \"` + sum + `\") { filename checksums { sha256 } } }"}`,
+			wantData: `{"result":{"filename":"notes.txt","checksums":{"sha256":"` + sum + `"}}}`,
+		},
+		{
+			name:     "batch with aliases and a missing entry",
+			body:     `{"query": "query ($ids: [String!]!) { found: results(sha256: $ids) { name: filename document { line_count } image { width } } }", "variables": {"ids": ["` + sum + `", "` + missing + `"]}}`,
+			wantData: `{"found":[{"name":"notes.txt","document":{"line_count":1},"image":null},null]}`,
+		},
+		{
+			name:     "typename",
+			body:     `{"query": "{ __typename result(sha256: \"` + sum + `\") { __typename } }"}`,
+			wantData: `{"__typename":"Query","result":{"__typename":"ResultV2"}}`,
+		},
+		{
+			name:      "unknown field",
+			body:      `{"query": "{ result(sha256: \"` + sum + `\") { owner } }"}`,
+			wantError: `Cannot query field "owner" on type "ResultV2".`,
+		},
+		{
+			name:      "object without selection",
+			body:      `{"query": "{ result(sha256: \"` + sum + `\") { checksums } }"}`,
+			wantError: `must have a selection of subfields`,
+		},
+		{
+			name:      "missing variable",
+			body:      `{"query": "query ($id: String!) { result(sha256: $id) { filename } }"}`,
+			wantError: `Variable "$id" of required type "String!" was not provided.`,
+		},
+		{
+			name:      "syntax error",
+			body:      `{"query": "{ result(sha256: \"x\") { filename }"}`,
+			wantError: "Syntax error",
+		},
+		{
+			name:      "invalid checksum",
+			body:      `{"query": "{ result(sha256: \"xyz\") { filename } }"}`,
+			wantData:  `{"result":null}`,
+			wantError: `Invalid SHA256 "xyz".`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+			var response struct {
+				Data   json.RawMessage `json:"data"`
+				Errors []graphQLError  `json:"errors"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if string(response.Data) != tt.wantData {
+				t.Errorf("data = %s, want %s", response.Data, tt.wantData)
+			}
+			switch {
+			case tt.wantError == "" && len(response.Errors) > 0:
+				t.Errorf("errors = %+v, want none", response.Errors)
+			case tt.wantError != "" && (len(response.Errors) == 0 || !strings.Contains(response.Errors[0].Message, tt.wantError)):
+				t.Errorf("errors = %+v, want %q", response.Errors, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestGraphQLHandlerGet(t *testing.T) {
+	results := store.NewMemoryStore(10, time.Hour)
+	sum := strings.Repeat("ab", 32)
+	results.Put(context.Background(), "", &metadata.Result{Filename: "notes.txt", SHA256: sum}, 0)
+
+	query := url.Values{
+		"query":     {"query ($id: String!) { result(sha256: $id) { filename } }"},
+		"variables": {`{"id": "` + sum + `"}`},
+	}
+	rr := httptest.NewRecorder()
+	GraphQLHandler(logger.New("info"), Deps{Results: results}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/graphql?"+query.Encode(), nil))
+
+	if got := strings.TrimSpace(rr.Body.String()); got != `{"data":{"result":{"filename":"notes.txt"}}}` {
+		t.Errorf("body = %s", got)
+	}
+
+	rr = httptest.NewRecorder()
+	GraphQLHandler(logger.New("info"), Deps{}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/graphql?"+query.Encode(), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status without a result store = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
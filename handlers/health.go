@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+)
+
+// healthCheckTimeout bounds each dependency check, so a hung dependency
+// can't hang health checks
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck tests one dependency of the service
+type HealthCheck struct {
+	Name     string
+	Critical bool // the service is down while it is
+	Check    func(ctx context.Context) error
+}
+
+// Health is what the health check reports besides its dependencies
+type Health struct {
+	Version  string
+	Commit   string
+	Started  time.Time
+	InFlight func() int64 // requests being served, or nil
+	Checks   []HealthCheck
+}
+
+// Readiness is what decides whether the service should be sent requests.
+// Nil funcs are never true, and only critical checks count.
+type Readiness struct {
+	Draining  func() bool // shutting down
+	Saturated func() bool // no extraction slot would be free soon
+	Checks    []HealthCheck
+}
+
+// HealthHandler reports the service's build, uptime and requests in flight,
+// and checks its dependencies in parallel. It answers 503 when a critical
+// one is down only with HEALTH_FAIL_CRITICAL, so by default load balancers
+// keep an instance that can still serve some requests.
+func HealthHandler(cfg *config.Config, log *logger.Logger, health Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := models.HealthResponse{
+			Status:        models.HealthOK,
+			Version:       health.Version,
+			Commit:        health.Commit,
+			UptimeSeconds: int64(time.Since(health.Started).Seconds()),
+		}
+		if health.InFlight != nil {
+			response.InFlightRequests = health.InFlight()
+		}
+
+		response.Dependencies = runHealthChecks(r.Context(), log, health.Checks)
+		for _, dependency := range response.Dependencies {
+			switch {
+			case dependency.Status == models.HealthOK:
+			case dependency.Critical:
+				response.Status = models.HealthDown
+			case response.Status == models.HealthOK:
+				response.Status = models.HealthDegraded
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if response.Status == models.HealthDown && cfg.HealthFailCritical {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Errorf("Failed to encode health response: %v", err)
+		}
+	}
+}
+
+// ReadinessHandler answers 200 while the service should be sent requests:
+// it isn't draining for shutdown, its critical dependencies are up and its
+// extraction slots aren't all taken. Otherwise it answers 503 with the
+// reasons.
+func ReadinessHandler(log *logger.Logger, readiness Readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := models.ProbeResponse{Status: models.ProbeReady}
+		if readiness.Draining != nil && readiness.Draining() {
+			response.Reasons = append(response.Reasons, "draining")
+		}
+		if readiness.Saturated != nil && readiness.Saturated() {
+			response.Reasons = append(response.Reasons, "extraction slots saturated")
+		}
+		var critical []HealthCheck
+		for _, check := range readiness.Checks {
+			if check.Critical {
+				critical = append(critical, check)
+			}
+		}
+		dependencies := runHealthChecks(r.Context(), log, critical)
+		for _, name := range slices.Sorted(maps.Keys(dependencies)) {
+			if dependencies[name].Status != models.HealthOK {
+				response.Reasons = append(response.Reasons, name+" down")
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if len(response.Reasons) > 0 {
+			response.Status = models.ProbeNotReady
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Errorf("Failed to encode readiness response: %v", err)
+		}
+	}
+}
+
+// LivenessHandler answers 200 as long as the process serves requests at
+// all, whatever its dependencies, so orchestrators restart only hung
+// processes
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(models.ProbeResponse{Status: models.HealthOK})
+	}
+}
+
+// runHealthChecks runs checks in parallel, returning the health of each
+// dependency by name. Errors are logged rather than returned, as the
+// endpoints are public.
+func runHealthChecks(ctx context.Context, log *logger.Logger, checks []HealthCheck) map[string]models.DependencyHealth {
+	if len(checks) == 0 {
+		return nil
+	}
+	dependencies := make(map[string]models.DependencyHealth, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Check(ctx)
+			dependency := models.DependencyHealth{
+				Status:    models.HealthOK,
+				Critical:  check.Critical,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				log.Warnf("Health check of %s failed: %v", check.Name, err)
+				dependency.Status = models.HealthDown
+			}
+			mu.Lock()
+			dependencies[check.Name] = dependency
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return dependencies
+}
+
+// WritableDir returns a check that a file can be created in dir
+func WritableDir(dir string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		file, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		file.Close()
+		return os.Remove(file.Name())
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+)
+
+func TestHealthHandler(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name         string
+		failCritical bool
+		checks       []HealthCheck
+		wantStatus   string
+		wantCode     int
+	}{
+		{name: "no dependencies", wantStatus: models.HealthOK, wantCode: http.StatusOK},
+		{
+			name:       "all up",
+			checks:     []HealthCheck{{Name: "redis", Critical: true, Check: up}, {Name: "clamav", Check: up}},
+			wantStatus: models.HealthOK,
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:         "optional one down",
+			failCritical: true,
+			checks:       []HealthCheck{{Name: "redis", Critical: true, Check: up}, {Name: "clamav", Check: down}},
+			wantStatus:   models.HealthDegraded,
+			wantCode:     http.StatusOK,
+		},
+		{
+			name:       "critical one down",
+			checks:     []HealthCheck{{Name: "redis", Critical: true, Check: down}, {Name: "clamav", Check: down}},
+			wantStatus: models.HealthDown,
+			wantCode:   http.StatusOK,
+		},
+		{
+			name:         "critical one down, failing",
+			failCritical: true,
+			checks:       []HealthCheck{{Name: "redis", Critical: true, Check: down}},
+			wantStatus:   models.HealthDown,
+			wantCode:     http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := logger.New("info")
+			var logs strings.Builder
+			log.SetOutput(&logs)
+			handler := HealthHandler(&config.Config{HealthFailCritical: tt.failCritical}, log, Health{
+				Version:  "v1.2.3",
+				Commit:   "abc123",
+				Started:  time.Now().Add(-time.Minute),
+				InFlight: func() int64 { return 4 },
+				Checks:   tt.checks,
+			})
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if rr.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rr.Code, tt.wantCode)
+			}
+			var got models.HealthResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus || got.Version != "v1.2.3" || got.Commit != "abc123" || got.UptimeSeconds < 60 || got.InFlightRequests != 4 {
+				t.Errorf("response = %+v, want status %s and the build, uptime and requests", got, tt.wantStatus)
+			}
+			for _, check := range tt.checks {
+				if dependency, ok := got.Dependencies[check.Name]; !ok || dependency.Critical != check.Critical || (dependency.Status == models.HealthOK) != (check.Check(context.Background()) == nil) {
+					t.Errorf("dependency %s = %+v, want it reported", check.Name, dependency)
+				}
+			}
+			if strings.Contains(rr.Body.String(), "connection refused") || (tt.wantStatus != models.HealthOK) != strings.Contains(logs.String(), "connection refused") {
+				t.Errorf("errors should be logged, not returned: body %s, logs %q", rr.Body, logs.String())
+			}
+		})
+	}
+}
+
+func TestWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := WritableDir(dir)(context.Background()); err != nil {
+		t.Errorf("WritableDir(%s) = %v", dir, err)
+	}
+	if err := WritableDir(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Error("WritableDir of a missing directory succeeded")
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	yes := func() bool { return true }
+
+	tests := []struct {
+		name        string
+		readiness   Readiness
+		wantCode    int
+		wantReasons []string
+	}{
+		{name: "ready", readiness: Readiness{Checks: []HealthCheck{{Name: "redis", Critical: true, Check: up}}}, wantCode: http.StatusOK},
+		{name: "optional dependency down", readiness: Readiness{Checks: []HealthCheck{{Name: "clamav", Check: down}}}, wantCode: http.StatusOK},
+		{
+			name:        "critical dependency down",
+			readiness:   Readiness{Checks: []HealthCheck{{Name: "redis", Critical: true, Check: down}, {Name: "clamav", Check: down}}},
+			wantCode:    http.StatusServiceUnavailable,
+			wantReasons: []string{"redis down"},
+		},
+		{
+			name:        "draining and saturated",
+			readiness:   Readiness{Draining: yes, Saturated: yes},
+			wantCode:    http.StatusServiceUnavailable,
+			wantReasons: []string{"draining", "extraction slots saturated"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := logger.New("info")
+			log.SetOutput(&strings.Builder{})
+			rr := httptest.NewRecorder()
+			ReadinessHandler(log, tt.readiness).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rr.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rr.Code, tt.wantCode)
+			}
+			var got models.ProbeResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			wantStatus := models.ProbeReady
+			if tt.wantReasons != nil {
+				wantStatus = models.ProbeNotReady
+			}
+			if got.Status != wantStatus || fmt.Sprint(got.Reasons) != fmt.Sprint(tt.wantReasons) {
+				t.Errorf("response = %+v, want %s with reasons %v", got, wantStatus, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	LivenessHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"status":"ok"}` {
+		t.Errorf("response = %d %s, want 200 ok", rr.Code, rr.Body)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// History pages hold historyDefaultLimit extractions unless the limit
+// parameter asks for up to historyMaxLimit
+const (
+	historyDefaultLimit = 50
+	historyMaxLimit     = 500
+)
+
+// HistoryHandler lists the calling API key's extractions, newest first.
+// Each page's next_cursor, passed back as cursor, fetches the next one.
+func HistoryHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.History == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Extraction history is disabled")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		format, err := negotiateFormat(r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		limit := historyDefaultLimit
+		if value := r.FormValue("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > historyMaxLimit {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "limit must be between 1 and "+strconv.Itoa(historyMaxLimit))
+				return
+			}
+		}
+
+		keyID := history.KeyID(middleware.GetAPIKey(r.Context()))
+		page, err := deps.History.List(r.Context(), keyID, limit, r.FormValue("cursor"))
+		if errors.Is(err, history.ErrInvalidCursor) {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid cursor")
+			return
+		}
+		if err != nil {
+			log.Errorf("[%s] Failed to read extraction history: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to read history")
+			return
+		}
+
+		// Pages change as extractions are recorded
+		w.Header().Set("Cache-Control", "private, no-store")
+		if err := writeResponse(w, format, page); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
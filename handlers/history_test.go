@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/store"
+	"file-meta/middleware"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	cfg := &config.Config{APIKeys: map[string]bool{"alice": true, "bob": true}}
+	log := logger.New("info")
+
+	recorded, err := history.OpenFile(filepath.Join(t.TempDir(), "history.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorded.Close()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		entry := history.Entry{
+			APIKey:    "alice",
+			RequestID: fmt.Sprintf("req-%d", i),
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+			Result:    &metadata.Result{Filename: fmt.Sprintf("file-%d.txt", i), SHA256: fmt.Sprintf("%064d", i), SizeBytes: int64(i)},
+		}
+		if err := recorded.Record(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob := history.Entry{APIKey: "bob", CreatedAt: start, Result: &metadata.Result{Filename: "bob.txt"}}
+	if err := recorded.Record(context.Background(), bob); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(deps Deps, key, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/history"+query, nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		middleware.APIKeyAuth(cfg, log)(HistoryHandler(log, deps)).ServeHTTP(rr, req)
+		return rr
+	}
+	deps := Deps{History: recorded}
+
+	// Page through alice's extractions two at a time
+	var filenames []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("more pages than expected")
+		}
+		rr := list(deps, "alice", "?limit=2&cursor="+cursor)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rr.Code, rr.Body)
+		}
+		var page history.Page
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.Items {
+			filenames = append(filenames, item.Filename)
+		}
+		if cursor = page.Next; cursor == "" {
+			break
+		}
+	}
+	want := []string{"file-4.txt", "file-3.txt", "file-2.txt", "file-1.txt", "file-0.txt"}
+	if fmt.Sprint(filenames) != fmt.Sprint(want) {
+		t.Errorf("listed %v, want %v", filenames, want)
+	}
+
+	tests := []struct {
+		name         string
+		deps         Deps
+		key          string
+		query        string
+		expectedCode int
+		wantItems    int
+	}{
+		{name: "default limit", deps: deps, key: "alice", expectedCode: http.StatusOK, wantItems: 5},
+		{name: "other key", deps: deps, key: "bob", expectedCode: http.StatusOK, wantItems: 1},
+		{name: "zero limit", deps: deps, key: "alice", query: "?limit=0", expectedCode: http.StatusBadRequest},
+		{name: "limit too large", deps: deps, key: "alice", query: "?limit=501", expectedCode: http.StatusBadRequest},
+		{name: "invalid cursor", deps: deps, key: "alice", query: "?cursor=abc", expectedCode: http.StatusBadRequest},
+		{name: "history disabled", deps: Deps{}, key: "alice", expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := list(tt.deps, tt.key, tt.query)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var page history.Page
+			if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != tt.wantItems || page.Next != "" {
+				t.Errorf("got %d items and cursor %q, want %d items", len(page.Items), page.Next, tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestDuplicateUploads(t *testing.T) {
+	cfg := &config.Config{
+		MaxFileSizeMB: 20,
+		APIKeys:       map[string]bool{"alice": true, "bob": true},
+	}
+	log := logger.New("info")
+
+	recorded, err := history.OpenFile(filepath.Join(t.TempDir(), "history.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorded.Close()
+	results := store.NewMemoryStore(10, time.Hour)
+	deps := Deps{Results: results, History: recorded}
+
+	upload := func(key, filename string) map[string]any {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", filename)
+		io.WriteString(part, "Hello, World!\n")
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		middleware.APIKeyAuth(cfg, log)(MetadataHandler(cfg, log, deps)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upload status = %d: %s", rr.Code, rr.Body)
+		}
+		var response map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if first := upload("alice", "first.txt"); first["duplicate_of"] != nil {
+		t.Errorf("first upload marked as a duplicate: %v", first["duplicate_of"])
+	}
+	if other := upload("bob", "other.txt"); other["duplicate_of"] != nil {
+		t.Errorf("another key's upload marked as a duplicate: %v", other["duplicate_of"])
+	}
+
+	second := upload("alice", "second.txt")
+	duplicate, ok := second["duplicate_of"].(map[string]any)
+	if !ok || duplicate["filename"] != "first.txt" || duplicate["first_seen"] == nil {
+		t.Errorf("duplicate_of = %v, want the first upload", second["duplicate_of"])
+	}
+
+	// Lookups serve the stored result, without the response's duplicate_of
+	stored, err := results.Get(context.Background(), history.KeyID("alice"), second["checksum_sha256"].(string))
+	if err != nil || stored == nil {
+		t.Fatalf("stored result = %v, %v", stored, err)
+	}
+	if stored.DuplicateOf != nil {
+		t.Errorf("stored result carries duplicate_of %+v", stored.DuplicateOf)
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/filepolicy"
+	"file-meta/internal/jobs"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+	"file-meta/internal/uploads"
+	"file-meta/internal/workpool"
+	"file-meta/middleware"
+)
+
+// errShuttingDown fails jobs that shutdown stopped from running
+var errShuttingDown error = &codedError{models.CodeShuttingDown, "server shutting down"}
+
+// sseKeepalive is how often an idle event stream sends a comment so proxies
+// don't close it
+var sseKeepalive = 15 * time.Second
+
+// jobResponse is a job with its result in the requested API version
+type jobResponse struct {
+	jobs.Job
+	Result any `json:"result,omitempty"`
+}
+
+// jobEvent is the data of one server-sent event. The last event of a
+// stream carries the result or the error.
+type jobEvent struct {
+	jobs.Event
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// JobsHandler starts an async extraction job for a completed resumable
+// upload named by upload_id, taking the same options as the metadata
+// endpoint. The response's Location is the job URL to poll.
+func JobsHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.Jobs == nil || deps.Uploads == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Async jobs are disabled")
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		opts, err := parseOptions(cfg, deps.Modules, r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		uploadID := r.FormValue("upload_id")
+		if uploadID == "" {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "upload_id is required")
+			return
+		}
+		info, err := getUpload(r, deps, uploadID)
+		if err == nil && !info.Complete() {
+			err = uploads.ErrIncomplete
+		}
+		if err == nil && info.Length > maxUploadBytes(cfg, r) {
+			err = errUploadTooLarge
+		}
+		if err != nil {
+			writeUploadError(w, log, requestID, err)
+			return
+		}
+
+		job, err := deps.Jobs.Create(uploadID, requestOwner(r))
+		if err != nil {
+			log.Errorf("[%s] Failed to create job: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to create job")
+			return
+		}
+		log.Infof("[%s] Started job %s for upload %s", requestID, job.ID, uploadID)
+
+		run := func(ctx context.Context) { runJob(ctx, cfg, log, requestID, deps, job, opts) }
+		if deps.Background == nil {
+			go run(context.WithoutCancel(r.Context()))
+		} else if !deps.Background.Go(r.Context(), "job "+job.ID+" (upload "+uploadID+")", run) {
+			deps.Jobs.Finish(job.ID, nil, errShuttingDown)
+			middleware.WriteError(w, http.StatusServiceUnavailable, models.CodeShuttingDown, "Server shutting down")
+			return
+		}
+
+		w.Header().Set("Location", "/"+version.Name+"/jobs/"+job.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(jobResponse{Job: job})
+	}
+}
+
+// runJob extracts metadata from the job's upload and records each stage.
+// It runs after the request that started it has returned, so it waits for
+// a slot of its API key's and an extraction slot for as long as JOB_TIMEOUT
+// allows. parent carries the request's values but not its cancellation,
+// and is canceled if shutdown abandons the job.
+func runJob(parent context.Context, cfg *config.Config, log *logger.Logger, requestID string, deps Deps, job jobs.Job, opts metadata.Options) {
+	ctx, cancel := context.WithTimeout(parent, cfg.JobTimeout)
+	defer cancel()
+
+	result, err := func() (*metadata.Result, error) {
+		if key := middleware.GetAPIKey(ctx); deps.KeySlots != nil && key != "" && !cfg.RateLimitExemptKeys[key] {
+			release, err := deps.KeySlots.Wait(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			deps.KeySlots = nil
+		}
+		if deps.Workers != nil {
+			release, err := deps.Workers.Wait(ctx)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			deps.Workers = nil
+		}
+
+		file, info, err := deps.Uploads.Open(job.UploadID)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		deps.Jobs.Stage(job.ID, jobs.StageUploaded)
+
+		opts.TempFiles = deps.TempFiles
+		opts.Progress = func(stage string) { deps.Jobs.Stage(job.ID, stage) }
+		return extractFile(ctx, cfg, log, requestID, deps, opts, file, uploadHeader(info))
+	}()
+	if err != nil {
+		log.Errorf("[%s] Job %s failed: %v", requestID, job.ID, err)
+		deps.Jobs.Finish(job.ID, nil, jobError(err))
+		return
+	}
+
+	deps.Jobs.Finish(job.ID, result, nil)
+	log.Infof("[%s] Job %s finished: %s", requestID, job.ID, log.Filename(result.Filename))
+}
+
+// codedError is an error reported to clients with one of the models.Code
+// values
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e *codedError) Error() string     { return e.message }
+func (e *codedError) ErrorCode() string { return e.code }
+
+// jobError is the error reported to clients for a failed job, without
+// internal details
+func jobError(err error) error {
+	var violation *filepolicy.Violation
+	switch {
+	case errors.As(err, &violation):
+		return &codedError{models.CodeUnsupportedType, violation.Error()}
+	case errors.Is(err, errAntivirusUnavailable):
+		return &codedError{models.CodeAntivirusUnavailable, "antivirus scan unavailable"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &codedError{models.CodeExtractionTimeout, "metadata extraction timed out"}
+	case errors.Is(err, context.Canceled):
+		return errShuttingDown
+	case errors.Is(err, uploads.ErrNotFound):
+		return &codedError{models.CodeNotFound, "upload not found"}
+	case errors.Is(err, workpool.ErrBusy):
+		return &codedError{models.CodeServerBusy, "server busy"}
+	}
+	return &codedError{models.CodeExtractionFailed, "failed to extract metadata"}
+}
+
+// JobHandler reports a job's status and stages, with the result once it is
+// done. Jobs of other API keys are not found.
+func JobHandler(log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.Jobs == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Async jobs are disabled")
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		format, err := negotiateFormat(r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		job, err := deps.Jobs.Get(r.PathValue("id"))
+		if err != nil || job.Owner != requestOwner(r) {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeNotFound, "Job not found")
+			return
+		}
+
+		response := jobResponse{Job: job}
+		if job.Result != nil {
+			response.Result = version.serializeFor(r.Context(), job.Result)
+		}
+		if err := writeResponse(w, format, response); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
+
+// JobEventsHandler streams a job's stages as server-sent events, replaying
+// those already finished. Event IDs count the stages, so a reconnecting
+// client's Last-Event-ID resumes where it left off. The stream ends after
+// the done or failed event.
+func JobEventsHandler(log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.Jobs == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Async jobs are disabled")
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		id := r.PathValue("id")
+		job, changed, err := deps.Jobs.Watch(id)
+		if err != nil || job.Owner != requestOwner(r) {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeNotFound, "Job not found")
+			return
+		}
+
+		// The stream outlives the server's write timeout
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Warnf("[%s] Failed to clear write deadline: %v", requestID, err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		sent, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+		sent = max(sent, 0)
+
+		keepalive := time.NewTicker(sseKeepalive)
+		defer keepalive.Stop()
+
+		for {
+			for ; sent < len(job.Events); sent++ {
+				event := jobEvent{Event: job.Events[sent]}
+				if sent == len(job.Events)-1 && job.Done() {
+					event.Error, event.Code = job.Error, job.Code
+					if job.Result != nil {
+						event.Result = version.serializeFor(r.Context(), job.Result)
+					}
+				}
+				if err := writeEvent(w, sent+1, event); err != nil {
+					log.Warnf("[%s] Failed to write event for job %s: %v", requestID, id, err)
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil || job.Done() {
+				return
+			}
+
+			select {
+			case <-changed:
+				if job, changed, err = deps.Jobs.Watch(id); err != nil {
+					return
+				}
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes one server-sent event with JSON data
+func writeEvent(w http.ResponseWriter, id int, event jobEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, data)
+	return err
+}
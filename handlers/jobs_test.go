@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/background"
+	"file-meta/internal/history"
+	"file-meta/internal/jobs"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/uploads"
+	"file-meta/middleware"
+)
+
+func TestJobs(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+		ExtractionTimeout: 10 * time.Second,
+		JobTimeout:        time.Minute,
+	}
+	log := logger.New("info")
+
+	store, err := uploads.NewStore(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := Deps{Uploads: store, Jobs: jobs.NewManager(time.Hour), Background: background.NewTracker()}
+
+	content := "Hello, World!\n"
+	info, err := store.Create(int64(len(content)), "notes.txt", "text/plain", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	incomplete, err := store.Create(10, "partial.txt", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	for _, version := range Versions {
+		prefix := "/" + version.Name
+		mux.Handle(prefix+"/jobs", JobsHandler(cfg, log, deps, version))
+		mux.Handle(prefix+"/jobs/{id}", JobHandler(log, deps, version))
+		mux.Handle(prefix+"/jobs/{id}/events", JobEventsHandler(log, deps, version))
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	create := func(uploadID string) *http.Response {
+		resp, err := http.Post(server.URL+"/v2/jobs?upload_id="+uploadID, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		uploadID string
+		wantCode int
+	}{
+		{"missing upload_id", "", http.StatusBadRequest},
+		{"unknown upload", strings.Repeat("0", 32), http.StatusNotFound},
+		{"incomplete upload", incomplete.ID, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := create(tt.uploadID); resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+
+	// Stages already finished when the stream opens are replayed, so the
+	// stream is the same however quickly the job runs
+	if _, err := store.Append(info.ID, 0, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	resp := create(info.ID)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("create status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, "/v2/jobs/") {
+		t.Fatalf("Location = %q, want a job URL", location)
+	}
+
+	events, err := http.Get(server.URL + location + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	if ct := events.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	var stages []string
+	var last jobEvent
+	scanner := bufio.NewScanner(events.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		last = jobEvent{}
+		if err := json.Unmarshal([]byte(data), &last); err != nil {
+			t.Fatalf("event data %q: %v", data, err)
+		}
+		stages = append(stages, last.Stage)
+	}
+
+	want := []string{jobs.StageUploaded, metadata.StageHashed, metadata.StageDocumentAnalyzed, jobs.StageDone}
+	if strings.Join(stages, ",") != strings.Join(want, ",") {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+	result, _ := last.Result.(map[string]any)
+	if checksums, _ := result["checksums"].(map[string]any); result["filename"] != "notes.txt" || checksums["sha256"] == nil {
+		t.Errorf("done event result = %v, want the v2 result for notes.txt", last.Result)
+	}
+
+	// A reconnecting client resumes after its last event
+	req, _ := http.NewRequest(http.MethodGet, server.URL+location+"/events", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	resumed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resumed.Body)
+	resumed.Body.Close()
+	if n := strings.Count(body.String(), "data: "); n != 1 || !strings.Contains(body.String(), "id: 4\n") {
+		t.Errorf("resumed stream = %q, want only event 4", body.String())
+	}
+
+	// Polling returns the same result, in the version of the URL
+	poll, err := http.Get(server.URL + "/v1/jobs/" + path.Base(location))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer poll.Body.Close()
+	var job map[string]any
+	if err := json.NewDecoder(poll.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	v1, _ := job["result"].(map[string]any)
+	if job["status"] != jobs.StatusDone || v1["checksum_sha256"] == nil {
+		t.Errorf("job = %v, want done with a v1 result", job)
+	}
+
+	if resp, _ := http.Get(server.URL + "/v1/jobs/" + strings.Repeat("0", 32) + "/events"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job events status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestJobsDisabled(t *testing.T) {
+	cfg := &config.Config{Port: "8080", MaxFileSizeMB: 20, RateLimitRequests: 10, RateLimitWindow: time.Minute, LogLevel: "info"}
+	log := logger.New("info")
+
+	rr := httptest.NewRecorder()
+	JobsHandler(cfg, log, Deps{}, Versions[0]).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/jobs?upload_id=x", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestJobsShuttingDown(t *testing.T) {
+	cfg := &config.Config{MaxFileSizeMB: 20, JobTimeout: time.Minute}
+	log := logger.New("info")
+	log.SetOutput(&strings.Builder{})
+
+	store, err := uploads.NewStore(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.Create(5, "notes.txt", "text/plain", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(info.ID, 0, strings.NewReader("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	tracker := background.NewTracker()
+	tracker.Wait(context.Background())
+
+	rr := httptest.NewRecorder()
+	JobsHandler(cfg, log, Deps{Uploads: store, Jobs: jobs.NewManager(time.Hour), Background: tracker}, Versions[0]).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/jobs?upload_id="+info.ID, nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d once shutdown waits for jobs", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestJobsOwner(t *testing.T) {
+	cfg := &config.Config{APIKeys: map[string]bool{"alice": true, "bob": true}, MaxFileSizeMB: 20, ExtractionTimeout: 10 * time.Second, JobTimeout: time.Minute}
+	log := logger.New("info")
+	log.SetOutput(&strings.Builder{})
+
+	store, err := uploads.NewStore(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := Deps{Uploads: store, Jobs: jobs.NewManager(time.Hour), Background: background.NewTracker()}
+
+	info, err := store.Create(5, "notes.txt", "text/plain", history.KeyID("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(info.ID, 0, strings.NewReader("Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := middleware.APIKeyAuth(cfg, log)
+	serve := func(h http.Handler, key, method, target, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.SetPathValue("id", id)
+		req.Header.Set("X-API-Key", key)
+		req.Header.Set("Tus-Resumable", TusVersion)
+		rr := httptest.NewRecorder()
+		auth(h).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve(UploadHandler(log, deps), "bob", http.MethodHead, "/v1/uploads/"+info.ID, info.ID); rr.Code != http.StatusNotFound {
+		t.Errorf("bob's HEAD of alice's upload status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if rr := serve(UploadHandler(log, deps), "bob", http.MethodDelete, "/v1/uploads/"+info.ID, info.ID); rr.Code != http.StatusNotFound {
+		t.Errorf("bob's DELETE of alice's upload status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if rr := serve(JobsHandler(cfg, log, deps, Versions[0]), "bob", http.MethodPost, "/v1/jobs?upload_id="+info.ID, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("bob's job for alice's upload status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	rr := serve(JobsHandler(cfg, log, deps, Versions[0]), "alice", http.MethodPost, "/v1/jobs?upload_id="+info.ID, "")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("alice's job status = %d, want %d: %s", rr.Code, http.StatusAccepted, rr.Body)
+	}
+	jobID := path.Base(rr.Header().Get("Location"))
+	deps.Background.Wait(context.Background())
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		target   string
+		key      string
+		wantCode int
+	}{
+		{"owner polls", JobHandler(log, deps, Versions[0]), "/v1/jobs/" + jobID, "alice", http.StatusOK},
+		{"other key polls", JobHandler(log, deps, Versions[0]), "/v1/jobs/" + jobID, "bob", http.StatusNotFound},
+		{"owner streams", JobEventsHandler(log, deps, Versions[0]), "/v1/jobs/" + jobID + "/events", "alice", http.StatusOK},
+		{"other key streams", JobEventsHandler(log, deps, Versions[0]), "/v1/jobs/" + jobID + "/events", "bob", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := serve(tt.handler, tt.key, http.MethodGet, tt.target, jobID); rr.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantCode)
+			}
+		})
+	}
+}
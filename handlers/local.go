@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+// ExtractLocalFile extracts metadata from a file on the server's disk with
+// the default options. Like an upload's, the result is stored and
+// published; it is returned in version's schema. Directory scans process
+// each file with it.
+func ExtractLocalFile(ctx context.Context, cfg *config.Config, log *logger.Logger, requestID string, deps Deps, version Version, path string) (any, error) {
+	opts, err := ExtractionOptions(cfg, deps.Modules, url.Values{}.Get)
+	if err != nil {
+		return nil, err
+	}
+	opts.TempFiles = deps.TempFiles
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > cfg.MaxFileSizeMB<<20 {
+		return nil, fmt.Errorf("%w: %d bytes", errUploadTooLarge, info.Size())
+	}
+
+	header := &multipart.FileHeader{
+		Filename: filepath.Base(path),
+		Size:     info.Size(),
+		Header:   textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}},
+	}
+
+	log.DebugContextf(ctx, "[%s] Processing file: %s (%d bytes)", requestID, log.Filename(path), header.Size)
+	result, err := extractFile(ctx, cfg, log, requestID, deps, opts, file, header)
+	if err != nil {
+		return nil, err
+	}
+	return version.Serialize(result), nil
+}
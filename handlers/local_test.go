@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+)
+
+func TestExtractLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte(remoteContent), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	large := filepath.Join(dir, "large.bin")
+	if err := os.WriteFile(large, make([]byte, 2<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ExtractLocalFile(context.Background(), remoteTestConfig(), logger.New("error"), "test", Deps{}, Versions[0], path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := result.(*metadata.Result); r.Filename != "notes.txt" || r.SizeBytes != int64(len(remoteContent)) {
+		t.Errorf("result = %s of %d bytes, want notes.txt of %d", r.Filename, r.SizeBytes, len(remoteContent))
+	}
+
+	if _, err := ExtractLocalFile(context.Background(), remoteTestConfig(), logger.New("error"), "test", Deps{}, Versions[0], large); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("error for a file over the limit = %v, want errUploadTooLarge", err)
+	}
+	if _, err := ExtractLocalFile(context.Background(), remoteTestConfig(), logger.New("error"), "test", Deps{}, Versions[0], filepath.Join(dir, "gone")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error for a missing file = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// LogLevel is the body of log level requests and responses
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler reads (GET) and changes (PUT) the log level of this
+// instance, without a restart. Other instances keep theirs.
+func LogLevelHandler(log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var body LogLevel
+			decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&body); err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid log level: "+err.Error())
+				return
+			}
+			level, ok := logger.ParseLevel(body.Level)
+			if !ok {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid log level: must be debug, info, warn or error")
+				return
+			}
+			previous := log.Level()
+			log.SetLevel(level)
+			log.Warnf("[%s] Log level changed from %s to %s", requestID, previous, level)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(LogLevel{Level: log.Level().String()}); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
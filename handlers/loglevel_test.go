@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-meta/internal/logger"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	log := logger.New("info")
+	log.SetOutput(&strings.Builder{})
+	handler := LogLevelHandler(log)
+
+	tests := []struct {
+		method     string
+		body       string
+		wantStatus int
+		wantLevel  string
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK, wantLevel: "info"},
+		{method: http.MethodPut, body: `{"level": "debug"}`, wantStatus: http.StatusOK, wantLevel: "debug"},
+		{method: http.MethodGet, wantStatus: http.StatusOK, wantLevel: "debug"},
+		{method: http.MethodPut, body: `{"level": "verbose"}`, wantStatus: http.StatusBadRequest, wantLevel: "debug"},
+		{method: http.MethodPut, body: `{"lvl": "warn"}`, wantStatus: http.StatusBadRequest, wantLevel: "debug"},
+		{method: http.MethodPut, body: `{"level": "warn"}`, wantStatus: http.StatusOK, wantLevel: "warn"},
+		{method: http.MethodPost, body: `{"level": "info"}`, wantStatus: http.StatusMethodNotAllowed, wantLevel: "warn"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tt.method, "/admin/log-level", strings.NewReader(tt.body)))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.body, rr.Code, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusOK && strings.TrimSpace(rr.Body.String()) != `{"level":"`+tt.wantLevel+`"}` {
+			t.Errorf("%s %s body = %s, want level %s", tt.method, tt.body, rr.Body, tt.wantLevel)
+		}
+		if got := log.Level().String(); got != tt.wantLevel {
+			t.Errorf("after %s %s level = %s, want %s", tt.method, tt.body, got, tt.wantLevel)
+		}
+	}
+}
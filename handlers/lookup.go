@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// lookupCacheControl makes clients revalidate stored results on every use.
+// A result is replaced when the same file is uploaded again, so it can't be
+// cached as immutable, but an unchanged result costs only a 304.
+const lookupCacheControl = "private, no-cache"
+
+// LookupHandler returns the stored result for the SHA256 in the path, from
+// the result cache or the extraction history, in the given API version's
+// schema. Only results of the caller's own API key are found. Responses carry an ETag so repeated
+// lookups with If-None-Match return 304 Not Modified.
+func LookupHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		if deps.Results == nil && deps.History == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Result lookup is disabled")
+			return
+		}
+
+		sum := strings.ToLower(r.PathValue("sha256"))
+		if !validSHA256(sum) {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid SHA256")
+			return
+		}
+
+		format, err := negotiateFormat(r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		result, err := storedResult(r.Context(), deps, sum)
+		if err != nil {
+			log.Errorf("[%s] Failed to read stored result: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to read result")
+			return
+		}
+		if result == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeNotFound, "Result not found")
+			return
+		}
+
+		response := version.serializeFor(r.Context(), result)
+		if fields := parseFields(r.FormValue("fields")); fields != nil {
+			response, err = filterFields(response, fields)
+			if err != nil {
+				log.Errorf("[%s] Failed to filter response fields: %v", requestID, err)
+				middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to encode response")
+				return
+			}
+		}
+
+		// The ETag covers the encoded body, so it changes with the stored
+		// result, the format and the fields
+		var body bytes.Buffer
+		if err := encodeResponse(&body, format, response); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to encode response")
+			return
+		}
+		digest := sha256.Sum256(body.Bytes())
+		etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", lookupCacheControl)
+		w.Header().Add("Vary", "Accept")
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", formatContentTypes[format])
+		if _, err := w.Write(body.Bytes()); err != nil {
+			log.Errorf("[%s] Failed to write response: %v", requestID, err)
+		}
+	}
+}
+
+// storedResult returns the result cached for sum with the request's API
+// key or, once it has left the cache, the key's latest one in the history.
+// Failing those, it returns the server's own result from extractions without
+// a key, such as watched folders and queue consumers, and otherwise nil,
+// even if another key extracted the file.
+func storedResult(ctx context.Context, deps Deps, sum string) (*metadata.Result, error) {
+	keyIDs := []string{history.KeyID(middleware.GetAPIKey(ctx))}
+	if keyIDs[0] != "" {
+		keyIDs = append(keyIDs, "")
+	}
+	for _, keyID := range keyIDs {
+		if deps.Results != nil {
+			result, err := deps.Results.Get(ctx, keyID, sum)
+			if err != nil || result != nil {
+				return result, err
+			}
+		}
+		if deps.History != nil {
+			result, err := deps.History.Get(ctx, keyID, sum)
+			if err != nil || result != nil {
+				return result, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// validSHA256 reports whether s is a hex SHA256 digest
+func validSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/store"
+	"file-meta/middleware"
+)
+
+func TestLookupHandler(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+	recorded, err := history.OpenFile(filepath.Join(t.TempDir(), "history.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorded.Close()
+	deps := Deps{Results: store.NewMemoryStore(10, time.Hour), History: recorded}
+
+	// Upload a file so its result is stored
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "test.txt")
+	io.WriteString(part, "Hello, World!\n")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	MetadataHandler(cfg, log, deps).ServeHTTP(rr, req)
+
+	var uploaded map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&uploaded); err != nil {
+		t.Fatal(err)
+	}
+	sum := uploaded["checksum_sha256"].(string)
+
+	lookup := func(deps Deps, method, sha256, query, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/metadata/"+sha256+query, nil)
+		req.SetPathValue("sha256", sha256)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		LookupHandler(cfg, log, deps, Versions[0]).ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := lookup(deps, http.MethodGet, sum, "", "")
+	if first.Code != http.StatusOK {
+		t.Fatalf("lookup status = %d, want %d", first.Code, http.StatusOK)
+	}
+	var stored map[string]any
+	if err := json.NewDecoder(first.Body).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored["filename"] != "test.txt" || stored["checksum_sha256"] != sum {
+		t.Errorf("stored result = %v, want the uploaded result", stored)
+	}
+
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag header missing")
+	}
+	if got := first.Header().Get("Cache-Control"); got != lookupCacheControl {
+		t.Errorf("Cache-Control = %q, want %q", got, lookupCacheControl)
+	}
+
+	tests := []struct {
+		name         string
+		deps         Deps
+		method       string
+		sha256       string
+		query        string
+		ifNoneMatch  string
+		expectedCode int
+		sameETag     bool
+	}{
+		{name: "matching ETag", deps: deps, method: http.MethodGet, sha256: sum, ifNoneMatch: etag, expectedCode: http.StatusNotModified, sameETag: true},
+		{name: "weak ETag in a list", deps: deps, method: http.MethodGet, sha256: sum, ifNoneMatch: `"other", W/` + etag, expectedCode: http.StatusNotModified, sameETag: true},
+		{name: "wildcard", deps: deps, method: http.MethodGet, sha256: sum, ifNoneMatch: "*", expectedCode: http.StatusNotModified, sameETag: true},
+		{name: "stale ETag", deps: deps, method: http.MethodGet, sha256: sum, ifNoneMatch: `"stale"`, expectedCode: http.StatusOK, sameETag: true},
+		{name: "uppercase hash", deps: deps, method: http.MethodGet, sha256: string(bytes.ToUpper([]byte(sum))), expectedCode: http.StatusOK, sameETag: true},
+		{name: "fields change the ETag", deps: deps, method: http.MethodGet, sha256: sum, query: "?fields=filename", ifNoneMatch: etag, expectedCode: http.StatusOK},
+		{name: "format changes the ETag", deps: deps, method: http.MethodGet, sha256: sum, query: "?format=yaml", ifNoneMatch: etag, expectedCode: http.StatusOK},
+		{name: "unknown hash", deps: deps, method: http.MethodGet, sha256: "0000000000000000000000000000000000000000000000000000000000000000", expectedCode: http.StatusNotFound},
+		{name: "invalid hash", deps: deps, method: http.MethodGet, sha256: "not-a-hash", expectedCode: http.StatusBadRequest},
+		{name: "invalid format", deps: deps, method: http.MethodGet, sha256: sum, query: "?format=csv", expectedCode: http.StatusBadRequest},
+		{name: "evicted from the cache", deps: Deps{Results: store.NewMemoryStore(10, time.Hour), History: recorded}, method: http.MethodGet, sha256: sum, expectedCode: http.StatusOK, sameETag: true},
+		{name: "history only", deps: Deps{History: recorded}, method: http.MethodGet, sha256: sum, expectedCode: http.StatusOK, sameETag: true},
+		{name: "cache disabled", deps: Deps{}, method: http.MethodGet, sha256: sum, expectedCode: http.StatusNotFound},
+		{name: "wrong method", deps: deps, method: http.MethodPost, sha256: sum, expectedCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := lookup(tt.deps, tt.method, tt.sha256, tt.query, tt.ifNoneMatch)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedCode)
+			}
+			if rr.Code != http.StatusOK && rr.Code != http.StatusNotModified {
+				return
+			}
+
+			if got := rr.Header().Get("ETag"); (got == etag) != tt.sameETag {
+				t.Errorf("ETag = %s, first lookup had %s", got, etag)
+			}
+			if rr.Code == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("304 response has a body: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestLookupHandlerPrivacyMode(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys: map[string]bool{"customer": true, "investigator": true},
+		KeyScopes: map[string]map[string]bool{
+			"investigator": {config.ScopeMetadataRead: true, config.ScopeMetadataPersonal: true},
+		},
+		PrivacyMode: true,
+	}
+	log := logger.New("error")
+	results := store.NewMemoryStore(10, time.Hour)
+	sum := strings.Repeat("ab", 32)
+	photo := &metadata.Result{Filename: "photo.jpg", SHA256: sum, Image: &metadata.ImageMetadata{
+		SerialNumber: "083024001234",
+		GPS:          &metadata.GPSData{Latitude: 48.8584, Longitude: 2.2945},
+	}}
+	results.Put(context.Background(), history.KeyID("customer"), photo, 0)
+	results.Put(context.Background(), history.KeyID("investigator"), photo, 0)
+	handler := middleware.APIKeyAuth(cfg, log)(middleware.PrivacyMode(cfg)(LookupHandler(cfg, log, Deps{Results: results}, Versions[1])))
+
+	for apiKey, wantPersonal := range map[string]bool{"customer": false, "investigator": true} {
+		req := httptest.NewRequest(http.MethodGet, "/v2/metadata/"+sum, nil)
+		req.SetPathValue("sha256", sum)
+		req.Header.Set("X-API-Key", apiKey)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", apiKey, rr.Code, body)
+		}
+		if got := strings.Contains(body, "48.8584") && strings.Contains(body, "083024001234"); got != wantPersonal {
+			t.Errorf("%s: body = %s, want personal data: %v", apiKey, body, wantPersonal)
+		}
+	}
+}
+
+func TestLookupHandlerKeys(t *testing.T) {
+	cfg := &config.Config{MaxFileSizeMB: 20, APIKeys: map[string]bool{"alice": true, "bob": true}}
+	log := logger.New("error")
+	recorded, err := history.OpenFile(filepath.Join(t.TempDir(), "history.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorded.Close()
+	results := store.NewMemoryStore(10, time.Hour)
+	deps := Deps{Results: results, History: recorded}
+	auth := middleware.APIKeyAuth(cfg, log)
+
+	upload := func(key, content, query string) string {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "notes.txt")
+		io.WriteString(part, content)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metadata"+query, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		auth(MetadataHandler(cfg, log, deps)).ServeHTTP(rr, req)
+		var uploaded map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&uploaded); err != nil {
+			t.Fatal(err)
+		}
+		return uploaded["checksum_sha256"].(string)
+	}
+	full := upload("alice", "Hello, World!\n", "")
+	partial := upload("alice", "Only the document module\n", "?include=document")
+	watched := strings.Repeat("ab", 32)
+	results.Put(context.Background(), "", &metadata.Result{Filename: "watched.txt", SHA256: watched}, 0)
+
+	tests := []struct {
+		name     string
+		deps     Deps
+		key      string
+		sha256   string
+		wantCode int
+	}{
+		{"own result", deps, "alice", full, http.StatusOK},
+		{"another key's result", deps, "bob", full, http.StatusNotFound},
+		{"another key's result in the history", Deps{History: recorded}, "bob", full, http.StatusNotFound},
+		{"partial result isn't cached", Deps{Results: results}, "alice", partial, http.StatusNotFound},
+		{"server's own result", deps, "bob", watched, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/metadata/"+tt.sha256, nil)
+			req.SetPathValue("sha256", tt.sha256)
+			req.Header.Set("X-API-Key", tt.key)
+			rr := httptest.NewRecorder()
+			auth(LookupHandler(cfg, log, tt.deps, Versions[0])).ServeHTTP(rr, req)
+			if rr.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"file-meta/config"
+	"file-meta/internal/filepolicy"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/tempfiles"
+	"file-meta/internal/workpool"
+)
+
+// messageRequest asks for an extraction over a message queue: an object in
+// cloud storage named like a remote request, or the file itself like a JSON
+// upload. Options are the metadata endpoint's form fields.
+type messageRequest struct {
+	URI           string            `json:"uri"`
+	Filename      string            `json:"filename"`
+	ContentBase64 string            `json:"content_base64"`
+	Version       string            `json:"version"`
+	Options       map[string]string `json:"options"`
+}
+
+// messageReply answers a messageRequest. Status is the one the HTTP API
+// would have responded with.
+type messageReply struct {
+	Status int    `json:"status"`
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"` // one of the models.Code values
+}
+
+// ExtractMessage runs the extraction a JSON message asks for and returns
+// the JSON reply. The result is in the requested API version's schema, the
+// newest by default, and is stored and published like an upload's.
+func ExtractMessage(ctx context.Context, cfg *config.Config, log *logger.Logger, requestID string, deps Deps, data []byte) []byte {
+	reply := extractMessage(ctx, cfg, log, requestID, deps, data)
+	if reply.Error != "" {
+		log.Warnf("[%s] Extraction request failed with status %d: %s", requestID, reply.Status, reply.Error)
+	}
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		log.Errorf("[%s] Failed to encode reply: %v", requestID, err)
+		encoded, _ = json.Marshal(messageReply{Status: http.StatusInternalServerError, Code: models.CodeInternal, Error: "Failed to encode response"})
+	}
+	return encoded
+}
+
+func extractMessage(ctx context.Context, cfg *config.Config, log *logger.Logger, requestID string, deps Deps, data []byte) messageReply {
+	var req messageRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return messageReply{Status: http.StatusBadRequest, Code: models.CodeBadRequest, Error: "Invalid JSON body: " + err.Error()}
+	}
+
+	version := Versions[len(Versions)-1]
+	if req.Version != "" {
+		var ok bool
+		if version, ok = VersionByName(req.Version); !ok {
+			return messageReply{Status: http.StatusBadRequest, Code: models.CodeBadRequest, Error: "Unknown version " + req.Version}
+		}
+	}
+
+	opts, err := ExtractionOptions(cfg, deps.Modules, func(name string) string { return req.Options[name] })
+	if err != nil {
+		return messageReply{Status: http.StatusBadRequest, Code: models.CodeInvalidOptions, Error: "Invalid options: " + err.Error()}
+	}
+	opts.TempFiles = deps.TempFiles
+
+	var file multipart.File
+	var header *multipart.FileHeader
+	switch {
+	case req.URI != "" && req.ContentBase64 != "":
+		return messageReply{Status: http.StatusBadRequest, Code: models.CodeBadRequest, Error: "Send either uri, or filename and content_base64"}
+	case req.URI != "":
+		if len(deps.Storage) == 0 {
+			return messageReply{Status: http.StatusNotFound, Code: models.CodeFeatureDisabled, Error: "Remote ingestion is disabled"}
+		}
+		obj, err := deps.Storage.Open(ctx, req.URI)
+		if err != nil {
+			return objectError(cfg, err)
+		}
+		defer obj.Close()
+		if file, header, err = bufferObject(cfg, deps, obj, cfg.MaxFileSizeMB<<20); err != nil {
+			return objectError(cfg, err)
+		}
+	case req.Filename != "" && req.ContentBase64 != "":
+		content, err := base64.StdEncoding.DecodeString(req.ContentBase64)
+		if err != nil {
+			return messageReply{Status: http.StatusBadRequest, Code: models.CodeInvalidFile, Error: "Invalid content_base64: " + err.Error()}
+		}
+		if int64(len(content)) > cfg.MaxFileSizeMB<<20 {
+			return messageError(cfg, errUploadTooLarge)
+		}
+		file = memoryFile{bytes.NewReader(content)}
+		header = &multipart.FileHeader{
+			Filename: req.Filename,
+			Size:     int64(len(content)),
+			Header:   textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}},
+		}
+	default:
+		return messageReply{Status: http.StatusBadRequest, Code: models.CodeBadRequest, Error: "uri, or filename and content_base64, are required"}
+	}
+	defer file.Close()
+
+	log.DebugContextf(ctx, "[%s] Processing file: %s (%d bytes)", requestID, log.Filename(header.Filename), header.Size)
+	result, err := extractFile(ctx, cfg, log, requestID, deps, opts, file, header)
+	if err != nil {
+		return messageError(cfg, err)
+	}
+
+	// Messages carry no API key to be given the metadata:personal scope
+	if cfg.PrivacyMode {
+		result = result.WithoutPersonalData()
+	}
+	response, err := SelectFields(version.Serialize(result), req.Options["fields"])
+	if err != nil {
+		return messageReply{Status: http.StatusInternalServerError, Code: models.CodeInternal, Error: "Failed to encode response"}
+	}
+	log.Infof("[%s] Successfully processed file: %s", requestID, log.Filename(header.Filename))
+	return messageReply{Status: http.StatusOK, Result: response}
+}
+
+// messageError maps a failed extraction to a reply with the HTTP API's
+// status for it
+func messageError(cfg *config.Config, err error) messageReply {
+	var violation *filepolicy.Violation
+	switch {
+	case errors.As(err, &violation):
+		return messageReply{Status: http.StatusUnsupportedMediaType, Code: models.CodeUnsupportedType, Error: violation.Error()}
+	case errors.Is(err, errUploadTooLarge):
+		return messageReply{Status: http.StatusRequestEntityTooLarge, Code: models.CodeFileTooLarge, Error: "File too large"}
+	case errors.Is(err, tempfiles.ErrQuotaExceeded), errors.Is(err, workpool.ErrBusy):
+		return messageReply{Status: http.StatusServiceUnavailable, Code: models.CodeServerBusy, Error: "Server busy, retry later"}
+	case errors.Is(err, errAntivirusUnavailable):
+		return messageReply{Status: http.StatusServiceUnavailable, Code: models.CodeAntivirusUnavailable, Error: "Antivirus scan unavailable"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return messageReply{Status: http.StatusGatewayTimeout, Code: models.CodeExtractionTimeout, Error: "Metadata extraction timed out after " + cfg.ExtractionTimeout.String()}
+	case errors.Is(err, context.Canceled):
+		return messageReply{Status: http.StatusServiceUnavailable, Code: models.CodeShuttingDown, Error: "Server shutting down, retry later"}
+	default:
+		return messageReply{Status: http.StatusInternalServerError, Code: models.CodeExtractionFailed, Error: "Failed to extract metadata"}
+	}
+}
+
+// objectError maps a failure to read a remote object to a reply
+func objectError(cfg *config.Config, err error) messageReply {
+	if errors.Is(err, errUploadTooLarge) || errors.Is(err, tempfiles.ErrQuotaExceeded) || errors.Is(err, context.Canceled) {
+		return messageError(cfg, err)
+	}
+	status, code, message := storageErrorResponse(err)
+	return messageReply{Status: status, Code: code, Error: message}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"file-meta/internal/logger"
+)
+
+func TestExtractMessage(t *testing.T) {
+	bucket := newFakeBucket()
+	defer bucket.Close()
+	deps := newRemoteDeps(t, bucket)
+	content := base64.StdEncoding.EncodeToString([]byte(remoteContent))
+
+	tests := []struct {
+		name       string
+		message    string
+		wantStatus int
+		wantField  string
+	}{
+		{"object", `{"uri": "s3://files/notes.txt", "version": "v1"}`, http.StatusOK, "filename"},
+		{"content", `{"filename": "notes.txt", "content_base64": "` + content + `", "version": "v1"}`, http.StatusOK, "filename"},
+		{"newest version by default", `{"uri": "s3://files/notes.txt"}`, http.StatusOK, "checksums"},
+		{"fields option", `{"uri": "s3://files/notes.txt", "version": "v1", "options": {"fields": "size_bytes"}}`, http.StatusOK, "size_bytes"},
+		{"missing object", `{"uri": "s3://files/gone.txt"}`, http.StatusNotFound, ""},
+		{"access denied", `{"uri": "s3://files/secret.txt"}`, http.StatusForbidden, ""},
+		{"unknown version", `{"uri": "s3://files/notes.txt", "version": "v9"}`, http.StatusBadRequest, ""},
+		{"invalid options", `{"uri": "s3://files/notes.txt", "options": {"checksums": "crc7"}}`, http.StatusBadRequest, ""},
+		{"both forms", `{"uri": "s3://files/notes.txt", "filename": "a", "content_base64": "` + content + `"}`, http.StatusBadRequest, ""},
+		{"nothing named", `{}`, http.StatusBadRequest, ""},
+		{"not JSON", `notes.txt`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := ExtractMessage(context.Background(), remoteTestConfig(), logger.New("error"), "test", deps, []byte(tt.message))
+
+			var reply struct {
+				Status int            `json:"status"`
+				Result map[string]any `json:"result"`
+				Error  string         `json:"error"`
+			}
+			if err := json.Unmarshal(data, &reply); err != nil {
+				t.Fatalf("reply %s: %v", data, err)
+			}
+			if reply.Status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", reply.Status, tt.wantStatus, reply.Error)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if reply.Error == "" {
+					t.Error("error is empty")
+				}
+				return
+			}
+			if _, ok := reply.Result[tt.wantField]; !ok {
+				t.Errorf("result = %v, want a %s field", reply.Result, tt.wantField)
+			}
+		})
+	}
+}
@@ -1,58 +1,700 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"file-meta/config"
+	"file-meta/internal/aiclassifier"
+	"file-meta/internal/background"
+	"file-meta/internal/clamav"
+	"file-meta/internal/filepolicy"
+	"file-meta/internal/history"
+	"file-meta/internal/jobs"
+	"file-meta/internal/knownfiles"
 	"file-meta/internal/logger"
 	"file-meta/internal/metadata"
+	"file-meta/internal/metrics"
+	"file-meta/internal/models"
+	"file-meta/internal/modules"
+	"file-meta/internal/overrides"
+	"file-meta/internal/storage"
+	"file-meta/internal/tempfiles"
+	"file-meta/internal/uploads"
+	"file-meta/internal/usage"
+	"file-meta/internal/workpool"
 	"file-meta/middleware"
 )
 
-// MetadataHandler handles file metadata extraction requests
-func MetadataHandler(cfg *config.Config, log *logger.Logger) http.HandlerFunc {
+// VirusScanner scans uploaded content for malware
+type VirusScanner interface {
+	Scan(ctx context.Context, r io.Reader) (*clamav.Verdict, error)
+}
+
+// KnownFileSet looks up uploads in a known-good hash set such as NSRL
+type KnownFileSet interface {
+	Lookup(ctx context.Context, d knownfiles.Digests) (*knownfiles.Entry, error)
+}
+
+// AIImageClassifier scores how likely an image is to be AI-generated
+type AIImageClassifier interface {
+	Classify(ctx context.Context, r io.Reader, mimeType string) (*aiclassifier.Result, error)
+}
+
+// ResultStore keeps extraction results by API key ID and SHA256 for later
+// lookups, each for the TTL given to Put or, if zero, the store's
+type ResultStore interface {
+	Get(ctx context.Context, keyID, sha256 string) (*metadata.Result, error)
+	Put(ctx context.Context, keyID string, result *metadata.Result, ttl time.Duration) error
+}
+
+// ResultPublisher streams completed results to downstream consumers, keyed
+// by SHA256. Publish must not block; it returns false when it dropped the
+// message.
+type ResultPublisher interface {
+	Publish(key, value []byte) bool
+}
+
+// HistoryRecorder keeps a permanent record of every extraction. Get
+// returns the latest result recorded for a SHA256 with an API key, or nil;
+// FirstSeen the
+// earliest extraction of a SHA256 with an API key, or nil; List pages
+// through an API key's extractions, newest first; Similar finds an API
+// key's images by perceptual hash, nearest first.
+type HistoryRecorder interface {
+	Record(ctx context.Context, entry history.Entry) error
+	Get(ctx context.Context, keyID, sha256 string) (*metadata.Result, error)
+	FirstSeen(ctx context.Context, keyID, sha256 string) (*history.Item, error)
+	List(ctx context.Context, keyID string, limit int, cursor string) (history.Page, error)
+	Similar(ctx context.Context, keyID string, phash uint64, distance, limit int) ([]history.Match, error)
+}
+
+// UsageTracker counts each API key's requests and extractions per month.
+// Get returns one key's usage in a month, and All every key's.
+type UsageTracker interface {
+	Request(ctx context.Context, keyID, endpoint string, origin usage.Origin) error
+	Extraction(ctx context.Context, keyID, mimeType string, size int64) error
+	Get(ctx context.Context, keyID, month string) (usage.Usage, error)
+	All(ctx context.Context, month string) ([]usage.Usage, error)
+}
+
+// OverrideStore keeps each API key's settings overrides by history.KeyID.
+// Get returns nil for keys without any.
+type OverrideStore interface {
+	Get(ctx context.Context, keyID string) (*overrides.Overrides, error)
+	Put(ctx context.Context, keyID string, o overrides.Overrides) error
+	Delete(ctx context.Context, keyID string) error
+}
+
+// Extractor runs extractions somewhere other than in process; the
+// sandbox's WebAssembly instances implement it
+type Extractor interface {
+	Extract(ctx context.Context, file multipart.File, header *multipart.FileHeader, opts metadata.Options) (*metadata.Result, error)
+}
+
+// Deps holds optional external services used by the handlers. Nil fields
+// disable the corresponding feature.
+type Deps struct {
+	Scanner      VirusScanner
+	KnownFiles   KnownFileSet
+	AIClassifier AIImageClassifier
+	Results      ResultStore
+	Uploads      *uploads.Store
+	Workers      *workpool.Pool
+	KeySlots     *workpool.KeyLimit
+	TempFiles    *tempfiles.Manager
+	Jobs         *jobs.Manager
+	Storage      storage.Providers
+	Publishers   []ResultPublisher
+	Sandbox      Extractor
+	History      HistoryRecorder
+	Usage        UsageTracker
+	Overrides    OverrideStore
+	Background   *background.Tracker
+	Modules      *modules.Switches
+	FilePolicy   *filepolicy.Policy
+}
+
+// MetadataHandler handles file metadata extraction requests with the v1
+// response schema
+func MetadataHandler(cfg *config.Config, log *logger.Logger, deps Deps) http.HandlerFunc {
+	return VersionedMetadataHandler(cfg, log, deps, Versions[0])
+}
+
+// VersionedMetadataHandler handles file metadata extraction requests,
+// responding with the given API version's schema
+func VersionedMetadataHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		requestID := middleware.GetRequestID(r.Context())
 
-		// Check Content-Length before parsing
-		maxBytes := cfg.MaxFileSizeMB << 20 // Convert MB to bytes
-		if r.ContentLength > maxBytes {
-			log.Warnf("[%s] File too large: %d bytes", requestID, r.ContentLength)
-			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		err := r.ParseMultipartForm(maxBytes)
-		if err != nil {
-			log.Errorf("[%s] Failed to parse multipart form: %v", requestID, err)
-			http.Error(w, "File too large or invalid", http.StatusRequestEntityTooLarge)
+		maxBytes := maxUploadBytes(cfg, r)
+
+		if err := decompressBody(r, cfg.RequestDecompressionMaxRatio); errors.Is(err, errUnsupportedEncoding) {
+			log.Warnf("[%s] Unsupported Content-Encoding %q", requestID, r.Header.Get("Content-Encoding"))
+			if cfg.RequestDecompressionMaxRatio > 0 {
+				w.Header().Set("Accept-Encoding", acceptedEncodings)
+			} else {
+				w.Header().Set("Accept-Encoding", "identity")
+			}
+			middleware.WriteError(w, http.StatusUnsupportedMediaType, models.CodeUnsupportedMediaType, "Unsupported Content-Encoding")
+			return
+		} else if err != nil {
+			log.Warnf("[%s] Invalid compressed body: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidFile, "Invalid compressed body")
 			return
 		}
-		defer r.MultipartForm.RemoveAll()
 
-		file, header, err := r.FormFile("file")
-		if err != nil {
-			log.Warnf("[%s] Invalid file in request: %v", requestID, err)
-			http.Error(w, "Invalid file parameter", http.StatusBadRequest)
-			return
+		var file multipart.File
+		var header *multipart.FileHeader
+		var err error
+		if isJSONRequest(r) {
+			file, header, err = readJSONUpload(w, r, maxBytes)
+			if errors.Is(err, errUploadTooLarge) {
+				log.Warnf("[%s] File too large", requestID)
+				middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "File too large")
+				return
+			}
+			if err != nil {
+				log.Warnf("[%s] Invalid JSON upload: %v", requestID, err)
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidFile, "Invalid JSON upload: "+err.Error())
+				return
+			}
+		} else {
+			// Check Content-Length before parsing
+			if cfg.MaxRequestSizeMB > 0 && r.ContentLength > cfg.MaxRequestSizeMB<<20 {
+				log.Warnf("[%s] Request body too large: %d bytes", requestID, r.ContentLength)
+				writeProblem(w, problem{
+					Status: http.StatusRequestEntityTooLarge,
+					Code:   models.CodeRequestTooLarge,
+					Detail: fmt.Sprintf("The request body of %d bytes is too large", r.ContentLength),
+					Limit:  "MAX_REQUEST_SIZE_MB",
+					Max:    cfg.MaxRequestSizeMB,
+				})
+				return
+			}
+			if r.ContentLength > maxBytes && cfg.MaxRequestSizeMB == 0 {
+				log.Warnf("[%s] File too large: %d bytes", requestID, r.ContentLength)
+				middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "File too large")
+				return
+			}
+
+			limits := uploadLimits{fileBytes: maxBytes, requestBytes: cfg.MaxRequestSizeMB << 20, files: cfg.MaxFilesPerRequest}
+			file, header, err = readMultipartUpload(w, r, limits, cfg.MultipartMemoryMB<<20, deps.TempFiles)
+			switch {
+			case errors.Is(err, errTooManyFiles):
+				log.Warnf("[%s] More than %d files in request", requestID, cfg.MaxFilesPerRequest)
+				writeProblem(w, problem{
+					Status: http.StatusRequestEntityTooLarge,
+					Code:   models.CodeTooManyFiles,
+					Detail: fmt.Sprintf("The request has more than %d files", cfg.MaxFilesPerRequest),
+					Limit:  "MAX_FILES_PER_REQUEST",
+					Max:    int64(cfg.MaxFilesPerRequest),
+				})
+				return
+			case errors.Is(err, errRequestTooLarge):
+				log.Warnf("[%s] Request body too large", requestID)
+				p := problem{Status: http.StatusRequestEntityTooLarge, Code: models.CodeRequestTooLarge, Detail: "The request body, files and form fields together, is too large"}
+				if cfg.MaxRequestSizeMB > 0 {
+					p.Limit, p.Max = "MAX_REQUEST_SIZE_MB", cfg.MaxRequestSizeMB
+				}
+				writeProblem(w, p)
+				return
+			case errors.Is(err, errUploadTooLarge):
+				log.Warnf("[%s] File too large", requestID)
+				middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "File too large")
+				return
+			case errors.Is(err, tempfiles.ErrQuotaExceeded):
+				log.Warnf("[%s] Temporary file quota full, rejecting upload", requestID)
+				w.Header().Set("Retry-After", retryAfter(cfg.ExtractionTimeout))
+				middleware.WriteError(w, http.StatusServiceUnavailable, models.CodeServerBusy, "Server busy, retry later")
+				return
+			case errors.Is(err, http.ErrMissingFile):
+				log.Warnf("[%s] Invalid file in request: %v", requestID, err)
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidFile, "Invalid file parameter")
+				return
+			case err != nil:
+				log.Errorf("[%s] Failed to parse multipart form: %v", requestID, err)
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidFile, "Invalid multipart form")
+				return
+			}
 		}
 		defer file.Close()
 
-		log.Debugf("[%s] Processing file: %s (%d bytes)", requestID, header.Filename, header.Size)
+		serveExtraction(w, r, cfg, log, deps, version, file, header)
+	}
+}
+
+// serveExtraction extracts metadata from an upload with the request's
+// options and writes the response
+func serveExtraction(w http.ResponseWriter, r *http.Request, cfg *config.Config, log *logger.Logger, deps Deps, version Version, file multipart.File, header *multipart.FileHeader) {
+	requestID := middleware.GetRequestID(r.Context())
+
+	opts, err := parseOptions(cfg, deps.Modules, r)
+	if err != nil {
+		log.Warnf("[%s] Invalid options: %v", requestID, err)
+		middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+		return
+	}
+
+	opts.TempFiles = deps.TempFiles
+
+	format, err := negotiateFormat(r)
+	if err != nil {
+		log.Warnf("[%s] Invalid options: %v", requestID, err)
+		middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+		return
+	}
+
+	log.DebugContextf(r.Context(), "[%s] Processing file: %s (%d bytes)", requestID, log.Filename(header.Filename), header.Size)
+
+	result, err := extractFile(r.Context(), cfg, log, requestID, deps, opts, file, header)
+	var violation *filepolicy.Violation
+	switch {
+	case errors.As(err, &violation):
+		log.Warnf("[%s] Rejected %s: %v", requestID, log.Filename(header.Filename), err)
+		writeProblem(w, problem{
+			Status:    http.StatusUnsupportedMediaType,
+			Code:      models.CodeUnsupportedType,
+			Detail:    violation.Error(),
+			Reason:    violation.Reason,
+			MIMEType:  violation.MIMEType,
+			Extension: violation.Extension,
+		})
+		return
+	case errors.Is(err, errAntivirusUnavailable):
+		log.Errorf("[%s] %v", requestID, err)
+		middleware.WriteError(w, http.StatusServiceUnavailable, models.CodeAntivirusUnavailable, "Antivirus scan unavailable")
+		return
+	case errors.Is(err, workpool.ErrKeyBusy):
+		log.Warnf("[%s] API key already runs %d extractions, rejecting %s", requestID, cfg.MaxConcurrentPerKey, log.Filename(header.Filename))
+		w.Header().Set("Retry-After", retryAfter(cfg.ExtractionTimeout))
+		middleware.WriteError(w, http.StatusTooManyRequests, models.CodeConcurrencyLimited, "Too many concurrent extractions for this API key")
+		return
+	case errors.Is(err, workpool.ErrBusy):
+		log.Warnf("[%s] No free extraction slot for %s", requestID, log.Filename(header.Filename))
+		w.Header().Set("Retry-After", retryAfter(cfg.ExtractionTimeout))
+		middleware.WriteError(w, http.StatusServiceUnavailable, models.CodeServerBusy, "Server busy, retry later")
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Warnf("[%s] Extraction of %s timed out after %s", requestID, log.Filename(header.Filename), cfg.ExtractionTimeout)
+		middleware.WriteError(w, http.StatusGatewayTimeout, models.CodeExtractionTimeout, "Metadata extraction timed out")
+		return
+	case errors.Is(err, context.Canceled):
+		log.Warnf("[%s] Client went away during extraction of %s", requestID, log.Filename(header.Filename))
+		return
+	case err != nil:
+		log.Errorf("[%s] Failed to extract metadata: %v", requestID, err)
+		middleware.WriteError(w, http.StatusInternalServerError, models.CodeExtractionFailed, "Failed to extract metadata")
+		return
+	}
 
-		result, err := metadata.Extract(file, header)
+	response := version.serializeFor(r.Context(), result)
+	if fields := parseFields(r.FormValue("fields")); fields != nil {
+		response, err = filterFields(response, fields)
 		if err != nil {
-			log.Errorf("[%s] Failed to extract metadata: %v", requestID, err)
-			http.Error(w, "Failed to extract metadata", http.StatusInternalServerError)
+			log.Errorf("[%s] Failed to filter response fields: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to encode response")
 			return
 		}
+	}
+
+	if err := writeResponse(w, format, response); err != nil {
+		log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+	}
+
+	log.Infof("[%s] Successfully processed file: %s", requestID, log.Filename(header.Filename))
+}
+
+// errAntivirusUnavailable is returned when the antivirus scan fails and
+// CLAMAV_FAIL_MODE is closed
+var errAntivirusUnavailable = errors.New("antivirus scan unavailable")
+
+var (
+	extractorRuns = metrics.NewCounterVec("file_meta_extractor_runs_total",
+		"Extractor module runs by detected MIME type", "mime_type", "module")
+	extractorSeconds = metrics.NewHistogramVec("file_meta_extractor_duration_seconds",
+		"Time extractor modules took by detected MIME type", metrics.DefaultBuckets, "mime_type", "module")
+)
+
+// observeExtractor records a module's run. Content whose type wasn't
+// recognized from its magic bytes is labeled unknown.
+func observeExtractor(module, mimeType string, elapsed time.Duration) {
+	if mimeType == "" {
+		mimeType = "unknown"
+	}
+	extractorRuns.With(mimeType, module).Inc()
+	extractorSeconds.With(mimeType, module).Observe(elapsed.Seconds())
+}
+
+// extractFile runs an extraction with everything around it: the file type
+// policy, antivirus scan, known-file lookup, the API key's and an extraction
+// slot, the extraction timeout, the external classifier, the result store
+// and the publishers. Errors are a *filepolicy.Violation,
+// errAntivirusUnavailable, workpool.ErrKeyBusy, workpool.ErrBusy, ctx's
+// error, a deadline error when the extraction timed out, or an extraction
+// failure.
+func extractFile(ctx context.Context, cfg *config.Config, log *logger.Logger, requestID string, deps Deps, opts metadata.Options, file multipart.File, header *multipart.FileHeader) (*metadata.Result, error) {
+	if deps.FilePolicy != nil {
+		mimeType, err := metadata.DetectMIME(file, header.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect file type: %w", err)
+		}
+		if err := deps.FilePolicy.Check(mimeType, header.Filename); err != nil {
+			return nil, err
+		}
+	}
+
+	var verdict *metadata.AntivirusVerdict
+	if deps.Scanner != nil {
+		var err error
+		verdict, err = scanUpload(ctx, deps.Scanner, file)
+		if err != nil {
+			if !cfg.ClamAVFailOpen {
+				return nil, fmt.Errorf("%w: %v", errAntivirusUnavailable, err)
+			}
+			log.Warnf("[%s] Antivirus scan failed, continuing without verdict: %v", requestID, err)
+			verdict = &metadata.AntivirusVerdict{Engine: "clamav", Status: metadata.AntivirusError}
+		}
+	}
+
+	var known *metadata.KnownFileMatch
+	if deps.KnownFiles != nil && !opts.Skip[metadata.ModuleSecurity] {
+		var err error
+		known, err = lookupKnownFile(ctx, deps.KnownFiles, file)
+		if err != nil {
+			// Triage aid only; never fail the request over it
+			log.Warnf("[%s] Known-file lookup failed: %v", requestID, err)
+		}
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(result); err != nil {
-			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+	// Cap the key's own extractions before queueing, so one tenant's burst
+	// can't fill the pool
+	if key := middleware.GetAPIKey(ctx); deps.KeySlots != nil && key != "" && !cfg.RateLimitExemptKeys[key] {
+		release, err := deps.KeySlots.Acquire(key)
+		if err != nil {
+			return nil, err
 		}
+		defer release()
+	}
 
-		log.Infof("[%s] Successfully processed file: %s", requestID, header.Filename)
+	// Wait for a free extraction slot so bursts queue instead of decoding
+	// every upload at once
+	if deps.Workers != nil {
+		release, err := deps.Workers.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	extractCtx := ctx
+	if cfg.ExtractionTimeout > 0 {
+		var cancel context.CancelFunc
+		extractCtx, cancel = context.WithTimeout(ctx, cfg.ExtractionTimeout)
+		defer cancel()
+	}
+
+	extract := metadata.ExtractWithOptions
+	if deps.Sandbox != nil {
+		extract = deps.Sandbox.Extract
+	}
+	opts.Timing = observeExtractor
+	result, err := extract(extractCtx, file, header, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if deps.AIClassifier != nil && result.Image != nil && result.Image.AIDetection != nil {
+		signal, err := classifyImage(ctx, deps.AIClassifier, file, result.MimeType)
+		if err != nil {
+			// Keep the heuristic verdict when the classifier is down
+			log.Warnf("[%s] AI classifier failed, using heuristics only: %v", requestID, err)
+		}
+		result.Image.AIDetection = metadata.BlendAIClassification(result.Image.AIDetection, signal, cfg.AIClassifierWeight)
+	}
+
+	if verdict != nil || known != nil {
+		if result.Security == nil {
+			result.Security = &metadata.SecurityMetadata{}
+		}
+		result.Security.Antivirus = verdict
+		result.Security.KnownFile = known
+	}
+
+	if verdict != nil && verdict.Status == metadata.AntivirusInfected {
+		log.Warnf("[%s] Infected file %s: %s", requestID, log.Filename(header.Filename), verdict.Signature)
+	}
+
+	// Only a full extraction is cached, so lookups never return a result
+	// narrowed by one request's modules as if it were complete
+	if deps.Results != nil && fullExtraction(cfg, deps.Modules, opts) {
+		var retention time.Duration
+		if o := middleware.GetOverrides(ctx); o != nil {
+			retention = o.ResultRetention()
+		}
+		if err := deps.Results.Put(ctx, history.KeyID(middleware.GetAPIKey(ctx)), result, retention); err != nil {
+			log.Warnf("[%s] Failed to store result: %v", requestID, err)
+		}
+	}
+
+	var duplicate *metadata.Duplicate
+	if deps.History != nil {
+		apiKey := middleware.GetAPIKey(ctx)
+		if apiKey != "" {
+			first, err := deps.History.FirstSeen(ctx, history.KeyID(apiKey), result.SHA256)
+			if err != nil {
+				log.Warnf("[%s] Failed to look up earlier uploads: %v", requestID, err)
+			} else if first != nil {
+				duplicate = &metadata.Duplicate{FirstSeen: first.CreatedAt, Filename: first.Filename, RequestID: first.RequestID}
+			}
+		}
+
+		entry := history.Entry{
+			APIKey:    apiKey,
+			RequestID: requestID,
+			CreatedAt: time.Now(),
+			Result:    result,
+		}
+		if err := deps.History.Record(ctx, entry); err != nil {
+			log.Warnf("[%s] Failed to record extraction history: %v", requestID, err)
+		}
+	}
+
+	if deps.Usage != nil {
+		if apiKey := middleware.GetAPIKey(ctx); apiKey != "" {
+			if err := deps.Usage.Extraction(ctx, history.KeyID(apiKey), result.MimeType, result.SizeBytes); err != nil {
+				log.Warnf("[%s] Failed to count usage: %v", requestID, err)
+			}
+		}
+	}
+
+	if len(deps.Publishers) > 0 {
+		if data, err := json.Marshal(result); err != nil {
+			log.Warnf("[%s] Failed to encode result for publishing: %v", requestID, err)
+		} else {
+			for _, publisher := range deps.Publishers {
+				if !publisher.Publish([]byte(result.SHA256), data) {
+					log.Warnf("[%s] Result publishing is backed up, dropped the result", requestID)
+				}
+			}
+		}
+	}
+
+	// Only this caller sees the duplicate; the stored result is shared
+	if duplicate != nil {
+		annotated := *result
+		annotated.DuplicateOf = duplicate
+		result = &annotated
+	}
+
+	return result, nil
+}
+
+// maxUploadBytes is the largest file the request's API key may send, from
+// its overrides, its tier or MAX_FILE_SIZE_MB
+func maxUploadBytes(cfg *config.Config, r *http.Request) int64 {
+	if o := middleware.GetOverrides(r.Context()); o != nil && o.MaxFileSizeMB > 0 {
+		return o.MaxFileSizeMB << 20
+	}
+	return cfg.Limits(middleware.GetAPIKey(r.Context())).MaxFileSizeMB << 20
+}
+
+// retryAfter is the Retry-After value for a busy server: a slot frees up at
+// the latest when the longest running extraction times out
+func retryAfter(timeout time.Duration) string {
+	seconds := int(math.Ceil(timeout.Seconds()))
+	return strconv.Itoa(max(seconds, 1))
+}
+
+// scanUpload scans the file with the antivirus engine and rewinds it for
+// extraction
+func scanUpload(ctx context.Context, scanner VirusScanner, file io.ReadSeeker) (*metadata.AntivirusVerdict, error) {
+	result, err := scanner.Scan(ctx, file)
+	if _, serr := file.Seek(0, io.SeekStart); serr != nil && err == nil {
+		err = fmt.Errorf("failed to rewind file: %w", serr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := &metadata.AntivirusVerdict{Engine: "clamav", Status: metadata.AntivirusClean}
+	if result.Infected {
+		verdict.Status = metadata.AntivirusInfected
+		verdict.Signature = result.Signature
+	}
+	return verdict, nil
+}
+
+// lookupKnownFile hashes the file, checks it against the known-file set and
+// rewinds it for extraction
+func lookupKnownFile(ctx context.Context, set KnownFileSet, file io.ReadSeeker) (*metadata.KnownFileMatch, error) {
+	digests, err := knownfiles.Compute(file)
+	if _, serr := file.Seek(0, io.SeekStart); serr != nil {
+		return nil, fmt.Errorf("failed to rewind file: %w", serr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := set.Lookup(ctx, digests)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	return &metadata.KnownFileMatch{
+		KnownBenign: true,
+		Source:      "nsrl",
+		MatchedHash: entry.Algorithm,
+		FileName:    entry.FileName,
+		ProductCode: entry.ProductCode,
+	}, nil
+}
+
+// classifyImage rewinds the file and sends it to the external classifier.
+// On failure the returned signal carries the error for the response.
+func classifyImage(ctx context.Context, classifier AIImageClassifier, file io.ReadSeeker, mimeType string) (*metadata.AIClassifierSignal, error) {
+	signal := &metadata.AIClassifierSignal{Backend: "external"}
+
+	_, err := file.Seek(0, io.SeekStart)
+	var result *aiclassifier.Result
+	if err == nil {
+		result, err = classifier.Classify(ctx, file, mimeType)
+	}
+	if err != nil {
+		signal.Error = "classifier unavailable"
+		return signal, err
+	}
+
+	signal.Model = result.Model
+	signal.Score = result.Score
+	signal.LikelyAIGenerated = result.Score >= 0.5
+	return signal, nil
+}
+
+// parseOptions reads optional extraction settings from the query string or
+// form fields. checksums is a comma-separated list of extra checksum types.
+// profile picks a configured set of extraction modules, which include and
+// exclude (comma-separated module lists) then narrow, and the API key's
+// overrides may narrow further.
+func parseOptions(cfg *config.Config, switches *modules.Switches, r *http.Request) (metadata.Options, error) {
+	opts, err := ExtractionOptions(cfg, switches, r.FormValue)
+	if err != nil {
+		return opts, err
+	}
+	if o := middleware.GetOverrides(r.Context()); o != nil && len(o.Modules) > 0 {
+		for _, module := range metadata.Modules {
+			if !slices.Contains(o.Modules, module) {
+				if opts.Skip == nil {
+					opts.Skip = make(map[string]bool)
+				}
+				opts.Skip[module] = true
+			}
+		}
+	}
+	return opts, nil
+}
+
+// ExtractionOptions builds extraction settings from the metadata endpoint's
+// option fields (checksums, profile, include, exclude) by name. value
+// returns "" for everything the caller didn't set. Modules switched off in
+// switches never run; nil switches leave them all on.
+func ExtractionOptions(cfg *config.Config, switches *modules.Switches, value func(name string) string) (metadata.Options, error) {
+	opts := metadata.Options{
+		Decompression: metadata.DecompressionLimits{
+			MaxRatio: cfg.DecompressionMaxRatio,
+			MaxDepth: cfg.DecompressionMaxDepth,
+			MaxBytes: cfg.DecompressionMaxMB << 20,
+		},
+		Memory: metadata.MemoryLimits{
+			MaxPixels:        cfg.MemoryMaxPixels,
+			MaxDocumentBytes: int(cfg.MemoryMaxDocumentKB << 10),
+			MaxEXIFBytes:     cfg.MemoryMaxEXIFKB << 10,
+		},
+	}
+
+	for _, checksum := range strings.Split(value("checksums"), ",") {
+		switch strings.ToLower(strings.TrimSpace(checksum)) {
+		case "", "sha256", "ssdeep":
+			// Always computed
+		case metadata.ChecksumTLSH:
+			opts.TLSH = true
+		default:
+			return opts, fmt.Errorf("unsupported checksum type %q", checksum)
+		}
+	}
+
+	selected := metadata.Modules
+	profile := strings.ToLower(strings.TrimSpace(value("profile")))
+	if profile == "" {
+		profile = cfg.DefaultProfile
+	}
+	if profile != "" {
+		var ok bool
+		if selected, ok = cfg.Profiles[profile]; !ok {
+			return opts, fmt.Errorf("unknown profile %q", profile)
+		}
+	}
+
+	include, err := parseModules(value("include"))
+	if err != nil {
+		return opts, err
+	}
+	exclude, err := parseModules(value("exclude"))
+	if err != nil {
+		return opts, err
+	}
+
+	// Start from the profile, or every module, then apply include, exclude
+	// and the switches
+	for _, module := range metadata.Modules {
+		if !slices.Contains(selected, module) || (len(include) > 0 && !include[module]) || exclude[module] ||
+			(switches != nil && !switches.Enabled(module)) {
+			if opts.Skip == nil {
+				opts.Skip = make(map[string]bool)
+			}
+			opts.Skip[module] = true
+		}
+	}
+
+	return opts, nil
+}
+
+// fullExtraction reports whether opts run every module an extraction without
+// options runs
+func fullExtraction(cfg *config.Config, switches *modules.Switches, opts metadata.Options) bool {
+	defaults, err := ExtractionOptions(cfg, switches, func(string) string { return "" })
+	return err == nil && maps.Equal(opts.Skip, defaults.Skip)
+}
+
+// parseModules parses a comma-separated list of extraction module names
+func parseModules(value string) (map[string]bool, error) {
+	modules := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(metadata.Modules, name) {
+			return nil, fmt.Errorf("unsupported module %q", name)
+		}
+		modules[name] = true
 	}
+	return modules, nil
 }
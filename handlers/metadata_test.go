@@ -2,15 +2,33 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"file-meta/config"
+	"file-meta/internal/aiclassifier"
+	"file-meta/internal/clamav"
+	"file-meta/internal/filepolicy"
+	"file-meta/internal/knownfiles"
 	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+	"file-meta/internal/modules"
+	"file-meta/internal/workpool"
+	"file-meta/middleware"
+
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func TestMetadataHandler(t *testing.T) {
@@ -61,15 +79,19 @@ func TestMetadataHandler(t *testing.T) {
 
 			// Create response recorder
 			rr := httptest.NewRecorder()
+			documentRuns := extractorRuns.With("unknown", metadata.ModuleDocument).Value()
 
 			// Call handler
-			handler := MetadataHandler(cfg, log)
+			handler := MetadataHandler(cfg, log, Deps{})
 			handler.ServeHTTP(rr, req)
 
 			// Check status code
 			if status := rr.Code; status != tt.expectedStatus {
 				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
 			}
+			if got := extractorRuns.With("unknown", metadata.ModuleDocument).Value(); got != documentRuns+1 {
+				t.Errorf("document extractor runs = %v, want %v", got, documentRuns+1)
+			}
 
 			// Check content type for successful responses
 			if tt.expectedStatus == http.StatusOK {
@@ -101,10 +123,987 @@ func TestMetadataHandlerMissingFile(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := MetadataHandler(cfg, log)
+	handler := MetadataHandler(cfg, log, Deps{})
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
+	var response models.ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || response.Code != models.CodeInvalidFile {
+		t.Errorf("response = %+v (%v), want code %s", response, err, models.CodeInvalidFile)
+	}
+}
+
+func TestMetadataHandlerTierFileSize(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     1,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+		APIKeys:           map[string]bool{"free_key": true, "paid_key": true},
+		Tiers: map[string]config.Tier{
+			"paid": {Name: "paid", RateLimitRequests: 10, RateLimitWindow: time.Minute, MaxFileSizeMB: 4},
+		},
+		KeyTiers: map[string]string{"paid_key": "paid"},
+	}
+	log := logger.New("info")
+	content := bytes.Repeat([]byte("a"), 2<<20)
+
+	tests := []struct {
+		key          string
+		expectedCode int
+	}{
+		{key: "free_key", expectedCode: http.StatusRequestEntityTooLarge},
+		{key: "paid_key", expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", "big.txt")
+			part.Write(content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.Header.Set("X-API-Key", tt.key)
+			rr := httptest.NewRecorder()
+			middleware.APIKeyAuth(cfg, log)(MetadataHandler(cfg, log, Deps{})).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Errorf("status = %d, want %d", rr.Code, tt.expectedCode)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlerExtractionTimeout(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+		ExtractionTimeout: time.Nanosecond,
+	}
+	log := logger.New("info")
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "test.txt")
+	io.WriteString(part, "Hello, World!")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusGatewayTimeout)
+	}
+	var response models.ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || response.Code != models.CodeExtractionTimeout {
+		t.Errorf("response = %+v (%v), want code %s", response, err, models.CodeExtractionTimeout)
+	}
+}
+
+func TestMetadataHandlerMethodNotAllowed(t *testing.T) {
+	cfg := &config.Config{MaxFileSizeMB: 10}
+	log := logger.New("info")
+
+	rr := httptest.NewRecorder()
+	MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/metadata", nil))
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rr.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("Allow = %q, want POST", allow)
+	}
+}
+
+func TestMetadataHandlerBusy(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+		ExtractionTimeout: 1500 * time.Millisecond,
+	}
+	log := logger.New("info")
+
+	// The only slot is taken and nobody waits for it
+	workers := workpool.New(1, 0)
+	release, _ := workers.Acquire(context.Background())
+
+	request := func() *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		io.WriteString(part, "Hello, World!")
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		MetadataHandler(cfg, log, Deps{Workers: workers}).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+
+	release()
+	if rr := request(); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code once a slot freed: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if workers.InUse() != 0 {
+		t.Errorf("InUse() = %d after the request, want 0", workers.InUse())
+	}
+}
+
+func TestMetadataHandlerKeyBusy(t *testing.T) {
+	cfg := &config.Config{
+		Port:                "8080",
+		MaxFileSizeMB:       20,
+		RateLimitRequests:   10,
+		RateLimitWindow:     time.Minute,
+		LogLevel:            "info",
+		ExtractionTimeout:   1500 * time.Millisecond,
+		APIKeys:             map[string]bool{"busy-key": true, "other-key": true, "exempt-key": true},
+		RateLimitExemptKeys: map[string]bool{"exempt-key": true},
+		MaxConcurrentPerKey: 1,
+	}
+	log := logger.New("info")
+
+	// busy-key's only slot is taken
+	slots := workpool.NewKeyLimit(cfg.MaxConcurrentPerKey)
+	release, _ := slots.Acquire("busy-key")
+	defer release()
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"busy-key", http.StatusTooManyRequests},
+		{"other-key", http.StatusOK},
+		{"exempt-key", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		io.WriteString(part, "Hello, World!")
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-API-Key", tt.key)
+
+		rr := httptest.NewRecorder()
+		middleware.APIKeyAuth(cfg, log)(MetadataHandler(cfg, log, Deps{KeySlots: slots})).ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.key, rr.Code, tt.want)
+		}
+		if tt.want == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "2" {
+			t.Errorf("%s: Retry-After = %q, want 2", tt.key, rr.Header().Get("Retry-After"))
+		}
+	}
+	if got := slots.InUse("other-key"); got != 0 {
+		t.Errorf("InUse(other-key) = %d after the request, want 0", got)
+	}
+}
+
+func TestMetadataHandlerChecksums(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. 0123456789\n", 20)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectTLSH     bool
+	}{
+		{name: "default", query: "", expectedStatus: http.StatusOK},
+		{name: "tlsh requested", query: "?checksums=sha256,tlsh", expectedStatus: http.StatusOK, expectTLSH: true},
+		{name: "unsupported type", query: "?checksums=md5", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata"+tt.query, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result metadata.Result
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if (result.TLSH != "") != tt.expectTLSH {
+				t.Errorf("TLSH = %q, want present: %v", result.TLSH, tt.expectTLSH)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlerModules(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+		Profiles: map[string][]string{
+			"fast":   {metadata.ModuleDocument},
+			"hashes": {metadata.ModuleSSDeep},
+		},
+	}
+	log := logger.New("info")
+
+	// Long enough for an ssdeep hash
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. 0123456789\n", 100)
+
+	tests := []struct {
+		name            string
+		query           string
+		disabled        []string
+		expectedStatus  int
+		expectSSDeep    bool
+		expectDocument  bool
+		expectAIText    bool
+		expectReadScore bool
+	}{
+		{name: "all modules", query: "", expectedStatus: http.StatusOK, expectSSDeep: true, expectDocument: true, expectAIText: true, expectReadScore: true},
+		{name: "checksums only", query: "?include=ssdeep", expectedStatus: http.StatusOK, expectSSDeep: true},
+		{name: "document without AI detection", query: "?include=document", expectedStatus: http.StatusOK, expectDocument: true, expectReadScore: true},
+		{name: "exclude document", query: "?exclude=document", expectedStatus: http.StatusOK, expectSSDeep: true},
+		{name: "include and exclude", query: "?include=document,ai_detection,ssdeep&exclude=SSDEEP", expectedStatus: http.StatusOK, expectDocument: true, expectAIText: true, expectReadScore: true},
+		{name: "unknown module", query: "?include=image,thumbnails", expectedStatus: http.StatusBadRequest},
+		{name: "profile", query: "?profile=hashes", expectedStatus: http.StatusOK, expectSSDeep: true},
+		{name: "profile narrowed by exclude", query: "?profile=fast&exclude=ssdeep", expectedStatus: http.StatusOK, expectDocument: true, expectReadScore: true},
+		{name: "unknown profile", query: "?profile=thorough", expectedStatus: http.StatusBadRequest},
+		{name: "switched off", disabled: []string{"ai_detection", "ssdeep"}, expectedStatus: http.StatusOK, expectDocument: true, expectReadScore: true},
+		{name: "switched off despite include", query: "?include=document,ai_detection", disabled: []string{"ai_detection"}, expectedStatus: http.StatusOK, expectDocument: true, expectReadScore: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata"+tt.query, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{Modules: modules.New(tt.disabled)}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result metadata.Result
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			if result.SHA256 == "" {
+				t.Error("SHA256 is always returned")
+			}
+			if (result.SSDeep != "") != tt.expectSSDeep {
+				t.Errorf("SSDeep = %q, want present: %v", result.SSDeep, tt.expectSSDeep)
+			}
+			if (result.Document != nil) != tt.expectDocument {
+				t.Fatalf("Document = %+v, want present: %v", result.Document, tt.expectDocument)
+			}
+			if result.Document == nil {
+				return
+			}
+			if (result.Document.AIDetection != nil) != tt.expectAIText {
+				t.Errorf("AIDetection = %+v, want present: %v", result.Document.AIDetection, tt.expectAIText)
+			}
+			if (result.Document.Readability != nil) != tt.expectReadScore {
+				t.Errorf("Readability = %+v, want present: %v", result.Document.Readability, tt.expectReadScore)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlerFields(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. 0123456789\n", 20)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "top-level field",
+			query:    "?fields=size_bytes",
+			expected: `{"size_bytes":1120}`,
+		},
+		{
+			name:     "nested fields",
+			query:    "?fields=size_bytes,document.word_count,document.line_count",
+			expected: `{"document":{"line_count":21,"word_count":200},"size_bytes":1120}`,
+		},
+		{
+			name:     "missing paths are ignored",
+			query:    "?fields=size_bytes,image.width,document.word_count.value,,",
+			expected: `{"size_bytes":1120}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata"+tt.query, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+
+			if got := strings.TrimSpace(rr.Body.String()); got != tt.expected {
+				t.Errorf("body = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	// A whole object keeps all of its fields
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "test.txt")
+	io.WriteString(part, content)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata?fields=checksum_sha256,document", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+	var result metadata.Result
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SHA256 == "" || result.Document == nil || result.Document.Readability == nil {
+		t.Errorf("result = %+v, want checksum and full document", result)
+	}
+	if result.Filename != "" || result.Security != nil {
+		t.Errorf("result = %+v, want unrequested fields dropped", result)
+	}
+}
+
+func TestMetadataHandlerFormats(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. 0123456789\n", 20)
+
+	tests := []struct {
+		name                string
+		query               string
+		accept              string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "default JSON",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        `"size_bytes":1120`,
+		},
+		{
+			name:                "XML via format parameter",
+			query:               "?format=xml",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/xml",
+			expectedBody:        "<size_bytes>1120</size_bytes>",
+		},
+		{
+			name:                "YAML via Accept",
+			accept:              "application/yaml",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/yaml",
+			expectedBody:        "size_bytes: 1120",
+		},
+		{
+			name:                "Accept quality values",
+			accept:              "application/xml;q=0.5, text/yaml",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/yaml",
+			expectedBody:        "size_bytes: 1120",
+		},
+		{
+			name:                "format parameter overrides Accept",
+			query:               "?format=json",
+			accept:              "application/xml",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        `"size_bytes":1120`,
+		},
+		{
+			name:                "unsupported Accept falls back to JSON",
+			accept:              "text/html",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        `"size_bytes":1120`,
+		},
+		{
+			name:                "XML with fields",
+			query:               "?format=xml&fields=document.word_count",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/xml",
+			expectedBody:        "<result>\n  <document>\n    <word_count>200</word_count>\n  </document>\n</result>",
+		},
+		{
+			name:           "unsupported format",
+			query:          "?format=csv",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata"+tt.query, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			if got := rr.Header().Get("Content-Type"); got != tt.expectedContentType {
+				t.Errorf("Content-Type = %v, want %v", got, tt.expectedContentType)
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.expectedBody) {
+				t.Errorf("body = %s, want it to contain %s", rr.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+
+	t.Run("MessagePack", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		io.WriteString(part, content)
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Accept", "application/msgpack")
+
+		rr := httptest.NewRecorder()
+		MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Type"); got != "application/msgpack" {
+			t.Errorf("Content-Type = %v, want application/msgpack", got)
+		}
+
+		var result struct {
+			SizeBytes int64  `msgpack:"size_bytes"`
+			SHA256    string `msgpack:"checksum_sha256"`
+			Document  struct {
+				WordCount int `msgpack:"word_count"`
+			} `msgpack:"document"`
+		}
+		if err := msgpack.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.SizeBytes != 1120 || result.SHA256 == "" || result.Document.WordCount != 200 {
+			t.Errorf("result = %+v, want size 1120, a checksum and 200 words", result)
+		}
+	})
+}
+
+type fakeScanner struct {
+	verdict *clamav.Verdict
+	err     error
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, r io.Reader) (*clamav.Verdict, error) {
+	io.Copy(io.Discard, r)
+	return f.verdict, f.err
+}
+
+func TestMetadataHandlerAntivirus(t *testing.T) {
+	log := logger.New("info")
+
+	tests := []struct {
+		name           string
+		scanner        *fakeScanner
+		failOpen       bool
+		expectedStatus int
+		expectedAV     string
+	}{
+		{
+			name:           "clean",
+			scanner:        &fakeScanner{verdict: &clamav.Verdict{}},
+			expectedStatus: http.StatusOK,
+			expectedAV:     "clean",
+		},
+		{
+			name:           "infected",
+			scanner:        &fakeScanner{verdict: &clamav.Verdict{Infected: true, Signature: "Eicar-Signature"}},
+			expectedStatus: http.StatusOK,
+			expectedAV:     "infected",
+		},
+		{
+			name:           "scanner down, fail open",
+			scanner:        &fakeScanner{err: errors.New("connection refused")},
+			failOpen:       true,
+			expectedStatus: http.StatusOK,
+			expectedAV:     "error",
+		},
+		{
+			name:           "scanner down, fail closed",
+			scanner:        &fakeScanner{err: errors.New("connection refused")},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Port:              "8080",
+				MaxFileSizeMB:     20,
+				RateLimitRequests: 10,
+				RateLimitWindow:   time.Minute,
+				LogLevel:          "info",
+				ClamAVFailOpen:    tt.failOpen,
+			}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "test.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, "Hello, World!")
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{Scanner: tt.scanner}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var result metadata.Result
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Security == nil || result.Security.Antivirus == nil {
+				t.Fatalf("Security.Antivirus missing from response")
+			}
+			if result.Security.Antivirus.Status != tt.expectedAV {
+				t.Errorf("Antivirus.Status = %v, want %v", result.Security.Antivirus.Status, tt.expectedAV)
+			}
+			// The file must have been rewound for extraction after the scan
+			if result.Document == nil || result.Document.WordCount != 2 {
+				t.Errorf("Document = %+v, want word count 2", result.Document)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlerFilePolicy(t *testing.T) {
+	log := logger.New("info")
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		policy         *filepolicy.Policy
+		filename       string
+		expectedStatus int
+		expectedReason string
+	}{
+		{"allowed type", filepolicy.New([]string{"image/*"}, nil, nil, nil), "photo.png", http.StatusOK, ""},
+		{"denied by magic bytes, not the name", filepolicy.New(nil, []string{"image/png"}, nil, nil), "notes.txt", http.StatusUnsupportedMediaType, filepolicy.ReasonDeniedType},
+		{"extension not allowed", filepolicy.New(nil, nil, []string{"jpg"}, nil), "photo.png", http.StatusUnsupportedMediaType, filepolicy.ReasonExtensionNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(encoded.Bytes())
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{FilePolicy: tt.policy}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				return
+			}
+
+			if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", ct)
+			}
+			var p problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Reason != tt.expectedReason || p.MIMEType != "image/png" || p.Code != models.CodeUnsupportedType {
+				t.Errorf("problem = %+v, want reason %s for image/png", p, tt.expectedReason)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlerKnownFile(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	known, err := knownfiles.Compute(strings.NewReader("known system file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := knownfiles.Load(strings.NewReader(known.SHA1 + " system.dll\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		content     string
+		expectKnown bool
+	}{
+		{name: "known file", content: "known system file", expectKnown: true},
+		{name: "unknown file", content: "user document"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "upload.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, tt.content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{KnownFiles: set}).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+
+			var result metadata.Result
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+
+			var match *metadata.KnownFileMatch
+			if result.Security != nil {
+				match = result.Security.KnownFile
+			}
+			if (match != nil) != tt.expectKnown {
+				t.Fatalf("KnownFile = %+v, want match: %v", match, tt.expectKnown)
+			}
+			if match != nil && (match.MatchedHash != "sha1" || match.FileName != "system.dll") {
+				t.Errorf("KnownFile = %+v, want sha1 match for system.dll", match)
+			}
+		})
+	}
+}
+
+// fakePublisher records published messages
+type fakePublisher struct {
+	keys   []string
+	values [][]byte
+}
+
+func (f *fakePublisher) Publish(key, value []byte) bool {
+	f.keys = append(f.keys, string(key))
+	f.values = append(f.values, value)
+	return true
+}
+
+func TestMetadataHandlerPublisher(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	publisher := &fakePublisher{}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, "Hello, World!")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/metadata", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	VersionedMetadataHandler(cfg, logger.New("info"), Deps{Publishers: []ResultPublisher{publisher}}, Versions[1]).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if len(publisher.keys) != 1 {
+		t.Fatalf("published %d messages, want 1", len(publisher.keys))
+	}
+
+	// Published in the v1 schema whatever version the client asked for
+	var published metadata.Result
+	if err := json.Unmarshal(publisher.values[0], &published); err != nil {
+		t.Fatal(err)
+	}
+	if publisher.keys[0] != published.SHA256 || published.Filename != "notes.txt" || published.SHA256 == "" {
+		t.Errorf("published key %s, result %+v", publisher.keys[0], published)
+	}
+}
+
+type fakeClassifier struct {
+	result *aiclassifier.Result
+	err    error
+}
+
+func (f *fakeClassifier) Classify(ctx context.Context, r io.Reader, mimeType string) (*aiclassifier.Result, error) {
+	if _, err := png.DecodeConfig(r); err != nil {
+		return nil, err
+	}
+	return f.result, f.err
+}
+
+func TestMetadataHandlerAIClassifier(t *testing.T) {
+	cfg := &config.Config{
+		Port:               "8080",
+		MaxFileSizeMB:      20,
+		RateLimitRequests:  10,
+		RateLimitWindow:    time.Minute,
+		LogLevel:           "info",
+		AIClassifierWeight: 0.7,
+	}
+	log := logger.New("info")
+
+	// A PNG without EXIF, which the heuristics alone flag as AI-generated.
+	// The pixels vary so the image doesn't read as a flat screen capture.
+	render := image.NewRGBA(image.Rect(0, 0, 1024, 1024))
+	for y := 0; y < 1024; y++ {
+		for x := 0; x < 1024; x++ {
+			render.Set(x, y, color.RGBA{uint8(x*7 + y*13), uint8(x * y), uint8(x ^ y), 255})
+		}
+	}
+	var img bytes.Buffer
+	png.Encode(&img, render)
+
+	tests := []struct {
+		name             string
+		classifier       *fakeClassifier
+		expectedAI       bool
+		expectClassifier bool
+		expectError      bool
+	}{
+		{
+			name:       "no classifier",
+			expectedAI: true,
+		},
+		{
+			name:             "classifier says authentic",
+			classifier:       &fakeClassifier{result: &aiclassifier.Result{Score: 0.05, Model: "detector-v2"}},
+			expectedAI:       false,
+			expectClassifier: true,
+		},
+		{
+			name:             "classifier agrees",
+			classifier:       &fakeClassifier{result: &aiclassifier.Result{Score: 0.95}},
+			expectedAI:       true,
+			expectClassifier: true,
+		},
+		{
+			name:             "classifier down",
+			classifier:       &fakeClassifier{err: errors.New("connection refused")},
+			expectedAI:       true,
+			expectClassifier: true,
+			expectError:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "render.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(img.Bytes())
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			deps := Deps{}
+			if tt.classifier != nil {
+				deps.AIClassifier = tt.classifier
+			}
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, deps).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+
+			var result metadata.Result
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Image == nil || result.Image.AIDetection == nil {
+				t.Fatalf("Image.AIDetection missing from response")
+			}
+
+			detection := result.Image.AIDetection
+			if detection.LikelyAIGenerated != tt.expectedAI {
+				t.Errorf("LikelyAIGenerated = %v, want %v (%+v)", detection.LikelyAIGenerated, tt.expectedAI, detection)
+			}
+			if (detection.Classifier != nil) != tt.expectClassifier {
+				t.Fatalf("Classifier = %+v, want present: %v", detection.Classifier, tt.expectClassifier)
+			}
+			if tt.expectClassifier && detection.Heuristic == nil {
+				t.Error("Heuristic missing alongside classifier signal")
+			}
+			if detection.Classifier != nil && (detection.Classifier.Error != "") != tt.expectError {
+				t.Errorf("Classifier.Error = %q, want error: %v", detection.Classifier.Error, tt.expectError)
+			}
+		})
+	}
 }
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// ModulesHandler reads (GET) and switches (PUT) the extraction modules of
+// this instance, without a restart. The body maps module names to whether
+// they run; modules left out keep their state. Other instances keep theirs.
+func ModulesHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.Modules == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Module switches are disabled")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut:
+			var states map[string]bool
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&states); err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid module states: "+err.Error())
+				return
+			}
+			if err := deps.Modules.Set(states); err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid module states: "+err.Error())
+				return
+			}
+			log.Warnf("[%s] Extraction modules switched: %v", requestID, states)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(deps.Modules.States()); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/modules"
+)
+
+func TestModulesHandler(t *testing.T) {
+	log := logger.New("info")
+	log.SetOutput(&strings.Builder{})
+	switches := modules.New([]string{"ai_detection"})
+	handler := ModulesHandler(log, Deps{Modules: switches})
+
+	tests := []struct {
+		method     string
+		body       string
+		wantStatus int
+		wantAI     bool
+		wantVideo  bool
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusOK, wantVideo: true},
+		{method: http.MethodPut, body: `{"ai_detection": true, "video": false}`, wantStatus: http.StatusOK, wantAI: true},
+		{method: http.MethodPut, body: `{"ocr": false}`, wantStatus: http.StatusBadRequest, wantAI: true},
+		{method: http.MethodPut, body: `{"video": "off"}`, wantStatus: http.StatusBadRequest, wantAI: true},
+		{method: http.MethodPost, body: `{}`, wantStatus: http.StatusMethodNotAllowed, wantAI: true},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(tt.method, "/admin/modules", strings.NewReader(tt.body)))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.body, rr.Code, tt.wantStatus)
+		}
+		if got := switches.Enabled("ai_detection"); got != tt.wantAI {
+			t.Errorf("after %s %s ai_detection enabled = %v, want %v", tt.method, tt.body, got, tt.wantAI)
+		}
+		if got := switches.Enabled("video"); got != tt.wantVideo {
+			t.Errorf("after %s %s video enabled = %v, want %v", tt.method, tt.body, got, tt.wantVideo)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	ModulesHandler(log, Deps{}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/modules", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("without switches status = %d, want 404", rr.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"file-meta/internal/metrics"
+	"file-meta/internal/tempfiles"
+)
+
+// maxFormValueBytes bounds the non-file fields of a multipart request
+const maxFormValueBytes = 1 << 20
+
+var (
+	// errTooManyFiles is returned when a request has more file parts than
+	// uploadLimits.files
+	errTooManyFiles = errors.New("too many files")
+
+	// errRequestTooLarge is returned when a whole request body exceeds its
+	// limit, while each file may be within its own
+	errRequestTooLarge = errors.New("request too large")
+)
+
+// uploadLimits bounds a multipart request
+type uploadLimits struct {
+	fileBytes    int64 // the extracted file
+	requestBytes int64 // the whole body; zero allows the file plus form fields
+	files        int   // file parts, extracted or not; zero doesn't count them
+}
+
+var (
+	uploadsBuffered = metrics.NewCounter("file_meta_uploads_buffered_total",
+		"Uploads held in memory")
+	uploadSpills = metrics.NewCounter("file_meta_upload_spills_total",
+		"Uploads spilled to a temporary file")
+	uploadSpillBytes = metrics.NewCounter("file_meta_upload_spill_bytes_total",
+		"Bytes written to temporary files by spilled uploads")
+)
+
+// readMultipartUpload streams the file field of a multipart request. Files of
+// up to memoryLimit bytes are held in memory and larger ones spill to a
+// temporary file, which is removed when the file is closed. Other fields are
+// added to r.Form so options can be sent as form fields. Every part is read
+// before the file is returned, so the limits are checked before extraction.
+func readMultipartUpload(w http.ResponseWriter, r *http.Request, limits uploadLimits, memoryLimit int64, temp *tempfiles.Manager) (multipart.File, *multipart.FileHeader, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, err
+	}
+	requestBytes := limits.requestBytes
+	if requestBytes == 0 {
+		requestBytes = limits.fileBytes + maxFormValueBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, requestBytes)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var file multipart.File
+	var header *multipart.FileHeader
+	valueBytes := 0
+	files := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			closeUpload(file)
+			return nil, nil, uploadError(err)
+		}
+
+		if part.FileName() != "" {
+			if files++; limits.files > 0 && files > limits.files {
+				part.Close()
+				closeUpload(file)
+				return nil, nil, errTooManyFiles
+			}
+		}
+
+		switch {
+		case part.FormName() == "file" && part.FileName() != "" && file == nil:
+			header = &multipart.FileHeader{Filename: part.FileName(), Header: part.Header}
+			file, err = bufferFile(part, header, limits.fileBytes, memoryLimit, temp)
+		case part.FileName() != "":
+			// Only the first file is extracted
+			_, err = io.Copy(io.Discard, part)
+		default:
+			var value []byte
+			value, err = io.ReadAll(io.LimitReader(part, int64(maxFormValueBytes-valueBytes)+1))
+			valueBytes += len(value)
+			if err == nil && valueBytes > maxFormValueBytes {
+				err = errors.New("form fields too large")
+			}
+			r.Form.Add(part.FormName(), string(value))
+		}
+		part.Close()
+		if err != nil {
+			closeUpload(file)
+			return nil, nil, uploadError(err)
+		}
+	}
+
+	if file == nil {
+		return nil, nil, http.ErrMissingFile
+	}
+	return file, header, nil
+}
+
+// bufferFile reads an uploaded file into memory, spilling to disk once it
+// grows past memoryLimit, and sets header.Size
+func bufferFile(r io.Reader, header *multipart.FileHeader, maxBytes, memoryLimit int64, temp *tempfiles.Manager) (multipart.File, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, min(memoryLimit, maxBytes)+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n <= memoryLimit {
+		if n > maxBytes {
+			return nil, errUploadTooLarge
+		}
+		uploadsBuffered.Inc()
+		header.Size = n
+		return memoryFile{bytes.NewReader(buf.Bytes())}, nil
+	}
+
+	spill, err := temp.Create("file-meta-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	written, err := io.Copy(spill, io.MultiReader(&buf, io.LimitReader(r, maxBytes-n+1)))
+	if err == nil && written > maxBytes {
+		err = errUploadTooLarge
+	}
+	if err == nil {
+		_, err = spill.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spill.Close()
+		return nil, err
+	}
+
+	uploadSpills.Inc()
+	uploadSpillBytes.Add(float64(written))
+	header.Size = written
+	return spill, nil
+}
+
+// uploadError maps body limit errors to errRequestTooLarge
+func uploadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errRequestTooLarge
+	}
+	return err
+}
+
+func closeUpload(file multipart.File) {
+	if file != nil {
+		file.Close()
+	}
+}
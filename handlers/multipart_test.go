@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/tempfiles"
+)
+
+func TestReadMultipartUpload(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		memoryLimit int64
+		wantSpill   bool
+		wantErr     error
+	}{
+		{name: "held in memory", content: "Hello, World!", memoryLimit: 64},
+		{name: "at the memory limit", content: "Hello, World!", memoryLimit: 13},
+		{name: "spilled to disk", content: "Hello, World!", memoryLimit: 4, wantSpill: true},
+		{name: "always spilled", content: "Hello, World!", wantSpill: true},
+		{name: "too large in memory", content: strings.Repeat("a", 101), memoryLimit: 1000, wantErr: errUploadTooLarge},
+		{name: "too large on disk", content: strings.Repeat("a", 101), memoryLimit: 10, wantErr: errUploadTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			writer.WriteField("checksums", "tlsh")
+			part, _ := writer.CreateFormFile("file", "test.txt")
+			io.WriteString(part, tt.content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata?profile=fast", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			tempDir := t.TempDir()
+			temp, _ := tempfiles.New(tempDir, 0)
+			spills := uploadSpills.Value()
+			file, header, err := readMultipartUpload(httptest.NewRecorder(), req, uploadLimits{fileBytes: 100}, tt.memoryLimit, temp)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readMultipartUpload() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+					t.Errorf("temporary file left behind: %v", entries)
+				}
+				return
+			}
+
+			got, _ := io.ReadAll(file)
+			if string(got) != tt.content || header.Size != int64(len(tt.content)) || header.Filename != "test.txt" {
+				t.Errorf("upload = %q (%d bytes, %q), want %q", got, header.Size, header.Filename, tt.content)
+			}
+			if spilled := uploadSpills.Value() > spills; spilled != tt.wantSpill {
+				t.Errorf("spilled = %v, want %v", spilled, tt.wantSpill)
+			}
+			if req.FormValue("checksums") != "tlsh" || req.FormValue("profile") != "fast" {
+				t.Errorf("form = %v, want checksums and profile", req.Form)
+			}
+
+			file.Close()
+			if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+				t.Errorf("temporary file left after Close: %v", entries)
+			}
+		})
+	}
+}
+
+func TestReadMultipartUploadMissingFile(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("checksums", "tlsh")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	if _, _, err := readMultipartUpload(httptest.NewRecorder(), req, uploadLimits{fileBytes: 100}, 100, nil); !errors.Is(err, http.ErrMissingFile) {
+		t.Errorf("readMultipartUpload() error = %v, want http.ErrMissingFile", err)
+	}
+}
+
+func TestReadMultipartUploadLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   int
+		limits  uploadLimits
+		wantErr error
+	}{
+		{name: "within the file count", files: 2, limits: uploadLimits{fileBytes: 100, files: 2}},
+		{name: "too many files", files: 3, limits: uploadLimits{fileBytes: 100, files: 2}, wantErr: errTooManyFiles},
+		{name: "files not counted", files: 3, limits: uploadLimits{fileBytes: 100}},
+		{name: "within the request size", files: 3, limits: uploadLimits{fileBytes: 100, requestBytes: 1000}},
+		{name: "request too large", files: 3, limits: uploadLimits{fileBytes: 100, requestBytes: 200}, wantErr: errRequestTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			for range tt.files {
+				part, _ := writer.CreateFormFile("file", "test.txt")
+				io.WriteString(part, strings.Repeat("a", 60))
+			}
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			file, _, err := readMultipartUpload(httptest.NewRecorder(), req, tt.limits, 1000, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readMultipartUpload() error = %v, want %v", err, tt.wantErr)
+			}
+			if file != nil {
+				file.Close()
+			}
+		})
+	}
+}
+
+func TestMetadataHandlerUploadLimits(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for range 3 {
+		part, _ := writer.CreateFormFile("file", "test.txt")
+		io.WriteString(part, "Hello, World!")
+	}
+	writer.Close()
+
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		wantLimit string
+		wantMax   int64
+		wantCode  string
+	}{
+		{name: "too many files", cfg: config.Config{MaxFileSizeMB: 1, MaxFilesPerRequest: 2}, wantLimit: "MAX_FILES_PER_REQUEST", wantMax: 2, wantCode: models.CodeTooManyFiles},
+		{name: "request too large", cfg: config.Config{MaxFileSizeMB: 1, MaxRequestSizeMB: 1}, wantLimit: "MAX_REQUEST_SIZE_MB", wantMax: 1, wantCode: models.CodeRequestTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := body.Bytes()
+			if tt.cfg.MaxRequestSizeMB > 0 {
+				content = append(bytes.Clone(content), make([]byte, 1<<20)...)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata", bytes.NewReader(content))
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(&tt.cfg, logger.New("info"), Deps{}).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusRequestEntityTooLarge || rr.Header().Get("Content-Type") != "application/problem+json" {
+				t.Fatalf("status = %d, Content-Type %q, want 413 problem+json", rr.Code, rr.Header().Get("Content-Type"))
+			}
+			var p problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Status != http.StatusRequestEntityTooLarge || p.Limit != tt.wantLimit || p.Max != tt.wantMax || p.Code != tt.wantCode {
+				t.Errorf("problem = %+v, want %s for limit %s of %d", p, tt.wantCode, tt.wantLimit, tt.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/oauth"
+	"file-meta/middleware"
+)
+
+// TokenResponse is an access token issued to an OAuth client
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds
+	Scope       string `json:"scope"`
+}
+
+// TokenError is an OAuth error response
+type TokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// TokenHandler issues access tokens to OAUTH_CLIENTS with the client
+// credentials grant. A client authenticates with its ID and the API key that
+// is its secret, by HTTP Basic or in the form, and gets a token that works
+// like the key until it expires, or until the key stops working if that is
+// sooner. Failed authentications count towards middleware.AuthBan.
+func TokenHandler(cfg *config.Config, log *logger.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if len(cfg.OAuthClients) == 0 {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "OAuth clients are disabled")
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+		if err := r.ParseForm(); err != nil {
+			tokenError(w, http.StatusBadRequest, "invalid_request", "Body must be a URL-encoded form")
+			return
+		}
+		if grant := r.PostForm.Get("grant_type"); grant != "client_credentials" {
+			tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "Only client_credentials is supported")
+			return
+		}
+
+		now := time.Now()
+		var key string
+		authenticate := func(clientID, secret string) (string, bool) {
+			want, known := cfg.OAuthClients[clientID]
+			entry, _, valid := cfg.LookupAPIKey(secret, now)
+			key = secret
+			return entry, known && valid && entry == want
+		}
+		clientID, secret, basic := r.BasicAuth()
+		if !basic {
+			clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		entry, ok := authenticate(clientID, secret)
+		if !ok && basic {
+			// Both should be form-encoded before Basic encoding, though
+			// many clients skip it
+			unescapedID, _ := url.QueryUnescape(clientID)
+			unescapedSecret, _ := url.QueryUnescape(secret)
+			if entry, ok = authenticate(unescapedID, unescapedSecret); ok {
+				clientID = unescapedID
+			}
+		}
+		if !ok {
+			middleware.AuthFailed(r.Context())
+			log.Warnf("[%s] OAuth client authentication failed for %q", requestID, clientID)
+			if basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="file-meta"`)
+			}
+			tokenError(w, http.StatusUnauthorized, "invalid_client", "Unknown client or wrong secret")
+			return
+		}
+
+		var scopes []string
+		for _, scope := range config.Scopes {
+			if cfg.HasScope(entry, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		for _, scope := range strings.Fields(r.PostForm.Get("scope")) {
+			if !cfg.HasScope(entry, scope) {
+				tokenError(w, http.StatusBadRequest, "invalid_scope", "The client doesn't have the "+scope+" scope")
+				return
+			}
+		}
+
+		token, err := oauth.Issue(cfg.OAuthTokenSecret, clientID, cfg.KeyFingerprint(key), cfg.OAuthTokenTTL, now)
+		if err != nil {
+			log.Errorf("[%s] Failed to issue access token: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to issue access token")
+			return
+		}
+		log.Infof("[%s] Issued access token to OAuth client %q", requestID, clientID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(TokenResponse{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(cfg.OAuthTokenTTL / time.Second),
+			Scope:       strings.Join(scopes, " "),
+		})
+	}
+}
+
+// tokenError writes an OAuth error response
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(TokenError{Error: code, ErrorDescription: description})
+}
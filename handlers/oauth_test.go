@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/oauth"
+	"file-meta/middleware"
+)
+
+func TestTokenHandler(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:          map[string]bool{"reports_key": true, "other_key": true},
+		OAuthClients:     map[string]string{"reports": "reports_key"},
+		OAuthTokenSecret: []byte("0123456789abcdef0123456789abcdef"),
+		OAuthTokenTTL:    time.Hour,
+		KeyScopes: map[string]map[string]bool{
+			"reports_key": {config.ScopeMetadataRead: true},
+		},
+	}
+	handler := TokenHandler(cfg, logger.New("error"))
+
+	tests := []struct {
+		name string
+		form url.Values
+		// basic is the client ID and secret sent by HTTP Basic, if any
+		basic     []string
+		wantCode  int
+		wantError string
+	}{
+		{
+			name:     "form credentials",
+			form:     url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"reports_key"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "basic credentials",
+			form:     url.Values{"grant_type": {"client_credentials"}},
+			basic:    []string{"reports", "reports_key"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "scope the client has",
+			form:     url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"reports_key"}, "scope": {"metadata:read"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "scope the client lacks",
+			form:      url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"reports_key"}, "scope": {"metadata:write"}},
+			wantCode:  http.StatusBadRequest,
+			wantError: "invalid_scope",
+		},
+		{
+			name:      "another client's key",
+			form:      url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"other_key"}},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "invalid_client",
+		},
+		{
+			name:      "unknown client",
+			form:      url.Values{"grant_type": {"client_credentials"}},
+			basic:     []string{"nobody", "reports_key"},
+			wantCode:  http.StatusUnauthorized,
+			wantError: "invalid_client",
+		},
+		{
+			name:      "other grant",
+			form:      url.Values{"grant_type": {"password"}, "username": {"reports"}, "password": {"reports_key"}},
+			wantCode:  http.StatusBadRequest,
+			wantError: "unsupported_grant_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.basic != nil {
+				req.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantCode, rr.Body)
+			}
+			if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+
+			if tt.wantError != "" {
+				var body TokenError
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body.Error != tt.wantError {
+					t.Errorf("error = %+v (%v), want %s", body, err, tt.wantError)
+				}
+				if tt.basic != nil && rr.Header().Get("WWW-Authenticate") == "" {
+					t.Error("WWW-Authenticate missing for a Basic client")
+				}
+				return
+			}
+
+			var body TokenResponse
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.TokenType != "Bearer" || body.ExpiresIn != 3600 || body.Scope != config.ScopeMetadataRead {
+				t.Errorf("response = %+v, want a Bearer token for an hour with metadata:read", body)
+			}
+			client, key, err := oauth.Verify(cfg.OAuthTokenSecret, body.AccessToken, time.Now())
+			if err != nil || client != "reports" || key != cfg.KeyFingerprint("reports_key") {
+				t.Errorf("Verify() = %q, %q, %v, want the reports client and key", client, key, err)
+			}
+		})
+	}
+
+	t.Run("GET", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/oauth/token", nil))
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want 405", rr.Code)
+		}
+	})
+}
+
+func TestTokenHandlerAuthBan(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:            map[string]bool{"reports_key": true},
+		OAuthClients:       map[string]string{"reports": "reports_key"},
+		OAuthTokenSecret:   []byte("0123456789abcdef0123456789abcdef"),
+		OAuthTokenTTL:      time.Hour,
+		AuthBanThreshold:   2,
+		AuthBanWindow:      time.Minute,
+		AuthBanDuration:    time.Minute,
+		AuthBanMaxDuration: time.Hour,
+	}
+	log := logger.New("error")
+	handler := middleware.AuthBan(cfg, log, nil)(TokenHandler(cfg, log))
+
+	// Wrong secrets are guessed keys: the second one bans the IP, which
+	// then can't get a token with the right secret either
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		secret := "guessed_key"
+		if i == 2 {
+			secret = "reports_key"
+		}
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {secret}}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, rr.Code, want)
+		}
+	}
+}
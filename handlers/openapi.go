@@ -0,0 +1,1028 @@
+package handlers
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/jobs"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+	"file-meta/internal/openapi"
+	"file-meta/internal/overrides"
+	"file-meta/internal/usage"
+	"file-meta/middleware"
+)
+
+// APIVersion is the version reported in the OpenAPI document
+const APIVersion = "1.0.0"
+
+// Spec describes the API served by this package. Schemas come from the
+// response types and parameter values from the same tables the handlers
+// validate against, so the document can't drift from the code.
+func Spec(cfg *config.Config) *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "file-meta",
+		Description: "File metadata extraction, content detection and security scanning",
+		Version:     APIVersion,
+	})
+	doc.Components.SecuritySchemes["apiKey"] = &openapi.SecurityScheme{Type: "apiKey", In: "header", Name: "X-API-Key"}
+	// Operations take an API key, or an access token of an OAuth client
+	authenticated := []map[string][]string{{"apiKey": {}}}
+	if len(cfg.OAuthClients) > 0 {
+		doc.Components.SecuritySchemes["accessToken"] = &openapi.SecurityScheme{
+			Type:         "http",
+			Description:  "Access token from POST /oauth/token, valid for OAUTH_TOKEN_TTL",
+			Scheme:       "bearer",
+			BearerFormat: "JWT",
+		}
+		authenticated = append(authenticated, map[string][]string{"accessToken": {}})
+	}
+
+	errorSchema := doc.SchemaFor(models.ErrorResponse{})
+	doc.Components.Schemas["ErrorResponse"].Properties["code"].Enum = models.ErrorCodes
+	errorJSON := map[string]openapi.MediaType{"application/json": {Schema: errorSchema}}
+	errorResponse := func(description string) *openapi.Response {
+		return &openapi.Response{Description: description, Content: errorJSON}
+	}
+	rateLimited := &openapi.Response{
+		Description: "Rate limit exceeded (see Retry-After and the RateLimit headers), or the client IP is banned for sending too many invalid API keys (see Retry-After)",
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(middleware.RateLimitError{})}},
+	}
+	// Extraction endpoints are also limited by MAX_CONCURRENT_EXTRACTIONS_PER_KEY
+	extractionLimited := &openapi.Response{
+		Description: "Rate limit exceeded (see Retry-After and the RateLimit headers), the client IP is banned for sending too many invalid API keys, or the API key already runs MAX_CONCURRENT_EXTRACTIONS_PER_KEY extractions (an ErrorResponse with code CONCURRENCY_LIMITED, see Retry-After)",
+		Content: map[string]openapi.MediaType{
+			"application/json": {Schema: &openapi.Schema{OneOf: []*openapi.Schema{doc.SchemaFor(middleware.RateLimitError{}), errorSchema}}},
+		},
+	}
+
+	profiles := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		profiles = append(profiles, name)
+	}
+	slices.Sort(profiles)
+
+	formatNames := make([]string, 0, len(formatContentTypes))
+	for name := range formatContentTypes {
+		formatNames = append(formatNames, name)
+	}
+	slices.Sort(formatNames)
+
+	moduleList := "Comma-separated extraction modules: " + strings.Join(metadata.Modules, ", ")
+
+	// Parameters controlling extraction
+	extractParameters := []openapi.Parameter{
+		{
+			Name: "checksums", In: "query",
+			Description: "Comma-separated extra checksum types. SHA256 and ssdeep are always computed.",
+			Schema:      &openapi.Schema{Type: "string", Enum: []string{metadata.ChecksumTLSH}},
+		},
+		{
+			Name: "profile", In: "query",
+			Description: "Named set of extraction modules",
+			Schema:      &openapi.Schema{Type: "string", Enum: profiles},
+		},
+		{Name: "include", In: "query", Description: moduleList + ". Only these run.", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "exclude", In: "query", Description: moduleList + ". These are skipped.", Schema: &openapi.Schema{Type: "string"}},
+	}
+
+	// Parameters shaping any metadata response
+	responseParameters := []openapi.Parameter{
+		{
+			Name: "fields", In: "query",
+			Description: "Comma-separated dot-separated response paths to return, e.g. checksum_sha256,image.width",
+			Schema:      &openapi.Schema{Type: "string"},
+		},
+		{
+			Name: "format", In: "query",
+			Description: "Response format. Overrides the Accept header.",
+			Schema:      &openapi.Schema{Type: "string", Enum: formatNames},
+		},
+	}
+
+	usageMonth := openapi.Parameter{
+		Name: "month", In: "query",
+		Description: "Month as YYYY-MM, the current month by default",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+
+	upload := &openapi.RequestBody{
+		Required: true,
+		Content: map[string]openapi.MediaType{
+			"multipart/form-data": {Schema: &openapi.Schema{
+				Type: "object",
+				Properties: map[string]*openapi.Schema{
+					"file": {Type: "string", Format: "binary", Description: "The file to analyze"},
+				},
+				Required: []string{"file"},
+			}},
+			"application/json": {Schema: &openapi.Schema{
+				Type: "object",
+				Properties: map[string]*openapi.Schema{
+					"filename":       {Type: "string", Description: "Name of the file, used for its extension"},
+					"content_base64": {Type: "string", Format: "byte", Description: "The file to analyze, base64-encoded"},
+				},
+				Required: []string{"filename", "content_base64"},
+			}},
+		},
+	}
+
+	contentEncoding := openapi.Parameter{
+		Name: "Content-Encoding", In: "header",
+		Description: "gzip or zstd to send the body compressed. It may decompress to REQUEST_DECOMPRESSION_MAX_RATIO times its size.",
+		Schema:      &openapi.Schema{Type: "string", Enum: []string{"gzip", "zstd", "identity"}},
+	}
+
+	for _, version := range Versions {
+		result := doc.SchemaFor(version.Response)
+		formats := make(map[string]openapi.MediaType, len(formatContentTypes))
+		for _, contentType := range formatContentTypes {
+			formats[contentType] = openapi.MediaType{Schema: result}
+		}
+
+		doc.Post("/"+version.Name+"/metadata", &openapi.Operation{
+			OperationID: "extractMetadata" + strings.ToUpper(version.Name),
+			Summary:     "Extract file metadata (" + version.Name + ")",
+			Description: "Uploads a file and returns its checksums, type-specific metadata, detections and security findings.",
+			Tags:        []string{"metadata"},
+			Parameters:  append(append(slices.Clone(extractParameters), responseParameters...), contentEncoding),
+			RequestBody: upload,
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Extracted metadata", Content: formats},
+				"400": errorResponse("Invalid file, missing file parameter, invalid JSON upload, invalid compressed body or invalid options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:write scope"),
+				"413": errorResponse("File too large, the body decompresses to more than REQUEST_DECOMPRESSION_MAX_RATIO times its size, or a multipart upload breaks MAX_FILES_PER_REQUEST or MAX_REQUEST_SIZE_MB (an application/problem+json body names the limit)"),
+				"415": errorResponse("Unsupported Content-Encoding (see Accept-Encoding), or the file type policy rejects the file (an application/problem+json body gives the reason, detected mime_type and extension)"),
+				"429": extractionLimited,
+				"500": errorResponse("Extraction failed"),
+				"503": errorResponse("Antivirus scan unavailable and CLAMAV_FAIL_MODE is closed, every extraction slot stayed busy, or the temporary file quota is full (see Retry-After)"),
+				"504": errorResponse("Extraction exceeded EXTRACTION_TIMEOUT"),
+			},
+			Security: authenticated,
+		})
+
+		lookupParameters := append([]openapi.Parameter{
+			{
+				Name: "sha256", In: "path", Required: true,
+				Description: "SHA256 of the uploaded file",
+				Schema:      &openapi.Schema{Type: "string"},
+			},
+			{
+				Name: "If-None-Match", In: "header",
+				Description: "ETag from an earlier lookup. An unchanged response returns 304.",
+				Schema:      &openapi.Schema{Type: "string"},
+			},
+		}, responseParameters...)
+
+		doc.Options("/"+version.Name+"/metadata", &openapi.Operation{
+			OperationID: "describeMetadata" + strings.ToUpper(version.Name),
+			Summary:     "Describe the upload endpoint (" + version.Name + ")",
+			Description: "Reports the endpoint's methods in Allow, the request bodies it takes in Accept-Post and the Content-Encodings it decompresses in Accept-Encoding. No API key is needed; the response also answers CORS preflights.",
+			Tags:        []string{"metadata"},
+			Responses: map[string]*openapi.Response{
+				"204": {Description: "The Allow, Accept-Post and Accept-Encoding headers describe the endpoint"},
+			},
+		})
+
+		doc.Get("/"+version.Name+"/metadata/{sha256}", &openapi.Operation{
+			OperationID: "getMetadata" + strings.ToUpper(version.Name),
+			Summary:     "Look up a stored result (" + version.Name + ")",
+			Description: "Returns the result of an earlier upload with the same SHA256 by the calling API key, or by the server itself, from the result cache or the extraction history, without re-uploading the file.",
+			Tags:        []string{"metadata"},
+			Parameters:  lookupParameters,
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Stored metadata", Content: formats},
+				"304": {Description: "Not modified since the ETag in If-None-Match"},
+				"400": errorResponse("Invalid SHA256 or options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:read scope"),
+				"404": errorResponse("No stored result for the SHA256 and API key, or both the result cache and the extraction history are disabled"),
+				"429": rateLimited,
+				"500": errorResponse("Result store unavailable"),
+			},
+			Security: authenticated,
+		})
+
+		doc.Post("/"+version.Name+"/metadata/remote", &openapi.Operation{
+			OperationID: "extractRemoteMetadata" + strings.ToUpper(version.Name),
+			Summary:     "Extract metadata from an object in cloud storage (" + version.Name + ")",
+			Description: "The server reads the object in ranged GETs. Name it as s3://bucket/key, gs://bucket/object or " +
+				"azure://container/blob, read with the server's credentials, or by a presigned, signed or SAS URL on a provider host.",
+			Tags:       []string{"metadata"},
+			Parameters: append(slices.Clone(extractParameters), responseParameters...),
+			RequestBody: &openapi.RequestBody{
+				Required: true,
+				Content: map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+					Type:     "object",
+					Required: []string{"uri"},
+					Properties: map[string]*openapi.Schema{
+						"uri": {Type: "string", Description: "Object reference or presigned URL"},
+					},
+				}}},
+			},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Extracted metadata", Content: formats},
+				"400": errorResponse("Missing or malformed uri, an unsupported or disabled scheme, a URL outside the configured storage, no configured credentials, or invalid options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("The provider denied access to the object, the bucket isn't in S3_ALLOWED_BUCKETS, or the API key lacks the metadata:write scope"),
+				"404": errorResponse("Object not found, or remote ingestion is disabled"),
+				"413": errorResponse("File too large"),
+				"415": errorResponse("Body is not application/json, or the file type policy rejects the object"),
+				"429": extractionLimited,
+				"500": errorResponse("Extraction failed"),
+				"502": errorResponse("Reading from the provider failed"),
+				"503": errorResponse("Antivirus scan unavailable, every extraction slot stayed busy, or the temporary file quota is full (see Retry-After)"),
+				"504": errorResponse("Extraction exceeded EXTRACTION_TIMEOUT"),
+			},
+			Security: authenticated,
+		})
+
+		doc.Post("/"+version.Name+"/metadata/s3", &openapi.Operation{
+			OperationID: "extractS3Metadata" + strings.ToUpper(version.Name),
+			Summary:     "Extract metadata from an S3 object (" + version.Name + ")",
+			Description: "The server reads the object from S3 in ranged GETs. Name it by bucket and key, read with the server's credentials, " +
+				"or by a presigned GET URL on an S3 host.",
+			Tags:       []string{"metadata"},
+			Parameters: append(slices.Clone(extractParameters), responseParameters...),
+			RequestBody: &openapi.RequestBody{
+				Required: true,
+				Content: map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+					Type: "object",
+					Properties: map[string]*openapi.Schema{
+						"bucket": {Type: "string", Description: "Bucket name, with key"},
+						"key":    {Type: "string", Description: "Object key, with bucket"},
+						"url":    {Type: "string", Description: "Presigned GET URL, instead of bucket and key"},
+					},
+				}}},
+			},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Extracted metadata", Content: formats},
+				"400": errorResponse("Missing or conflicting object names, a non-S3 URL, no configured credentials, or invalid options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("S3 denied access to the object, the bucket isn't in S3_ALLOWED_BUCKETS, or the API key lacks the metadata:write scope"),
+				"404": errorResponse("Object not found, or S3 ingestion is disabled"),
+				"413": errorResponse("File too large"),
+				"415": errorResponse("Body is not application/json, or the file type policy rejects the object"),
+				"429": extractionLimited,
+				"500": errorResponse("Extraction failed"),
+				"502": errorResponse("Reading from S3 failed"),
+				"503": errorResponse("Antivirus scan unavailable, every extraction slot stayed busy, or the temporary file quota is full (see Retry-After)"),
+				"504": errorResponse("Extraction exceeded EXTRACTION_TIMEOUT"),
+			},
+			Security: authenticated,
+		})
+
+		doc.Get("/"+version.Name+"/metadata/ws", &openapi.Operation{
+			OperationID: "extractMetadataWebSocket" + strings.ToUpper(version.Name),
+			Summary:     "Extract file metadata over a WebSocket (" + version.Name + ")",
+			Description: "Opens a WebSocket. Send a JSON text message {\"filename\", \"size\"}, then the file in binary messages. " +
+				"The server sends JSON text messages of type progress (after each binary message), stage, and finally result or error " +
+				"(with the HTTP status it corresponds to), then closes the connection. " +
+				"Browsers, which can't set headers on the handshake, may pass the key as api_key.",
+			Tags: []string{"metadata"},
+			Parameters: append(append([]openapi.Parameter{{
+				Name: "api_key", In: "query",
+				Description: "API key, when the X-API-Key header can't be sent",
+				Schema:      &openapi.Schema{Type: "string"},
+			}}, extractParameters...), responseParameters[0]),
+			Responses: map[string]*openapi.Response{
+				"101": {Description: "Switched to the WebSocket protocol"},
+				"400": errorResponse("Not a WebSocket handshake, or invalid options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:write scope"),
+				"426": errorResponse("Unsupported Sec-WebSocket-Version"),
+				"429": rateLimited,
+			},
+			Security: authenticated,
+		})
+
+		uploadPath := "/" + version.Name + "/uploads"
+		tusResumable := openapi.Parameter{
+			Name: "Tus-Resumable", In: "header", Required: true,
+			Description: "tus protocol version",
+			Schema:      &openapi.Schema{Type: "string", Enum: []string{TusVersion}},
+		}
+		uploadID := openapi.Parameter{
+			Name: "id", In: "path", Required: true,
+			Description: "Upload ID from the Location of the created upload",
+			Schema:      &openapi.Schema{Type: "string"},
+		}
+		tusErrors := map[string]*openapi.Response{
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the metadata:write scope"),
+			"404": errorResponse("Unknown or expired upload, or resumable uploads are disabled"),
+			"412": errorResponse("Unsupported Tus-Resumable version"),
+		}
+		tusResponses := func(responses map[string]*openapi.Response) map[string]*openapi.Response {
+			for status, response := range tusErrors {
+				responses[status] = response
+			}
+			return responses
+		}
+
+		tusOptionsResponses := map[string]*openapi.Response{
+			"204": {Description: "The Allow, Tus-Version, Tus-Extension and Tus-Max-Size headers describe the endpoint"},
+		}
+		doc.Options(uploadPath, &openapi.Operation{
+			OperationID: "describeUploads" + strings.ToUpper(version.Name),
+			Summary:     "Describe the resumable upload endpoint (tus)",
+			Description: "Reports the supported tus versions, extensions and largest upload, as tus core specifies. Neither an API key nor Tus-Resumable is needed; the response also answers CORS preflights.",
+			Tags:        []string{"uploads"},
+			Responses:   tusOptionsResponses,
+		})
+		doc.Options(uploadPath+"/{id}", &openapi.Operation{
+			OperationID: "describeUpload" + strings.ToUpper(version.Name),
+			Summary:     "Describe an upload's endpoint (tus)",
+			Description: "As OPTIONS on the upload creation endpoint.",
+			Tags:        []string{"uploads"},
+			Parameters:  []openapi.Parameter{uploadID},
+			Responses:   tusOptionsResponses,
+		})
+
+		doc.Post(uploadPath, &openapi.Operation{
+			OperationID: "createUpload" + strings.ToUpper(version.Name),
+			Summary:     "Create a resumable upload (tus)",
+			Description: "Starts a tus upload. The Location response header is the upload URL.",
+			Tags:        []string{"uploads"},
+			Parameters: []openapi.Parameter{
+				tusResumable,
+				{Name: "Upload-Length", In: "header", Required: true, Description: "File size in bytes", Schema: &openapi.Schema{Type: "integer", Format: "int64"}},
+				{Name: "Upload-Metadata", In: "header", Description: "tus metadata; filename and filetype are used", Schema: &openapi.Schema{Type: "string"}},
+			},
+			Responses: tusResponses(map[string]*openapi.Response{
+				"201": {Description: "Upload created"},
+				"400": errorResponse("Invalid Upload-Length or Upload-Metadata"),
+				"413": errorResponse("Upload-Length exceeds UPLOAD_MAX_SIZE_MB or the API key's upload size (see Tus-Max-Size)"),
+				"429": rateLimited,
+			}),
+			Security: authenticated,
+		})
+		doc.Head(uploadPath+"/{id}", &openapi.Operation{
+			OperationID: "getUploadOffset" + strings.ToUpper(version.Name),
+			Summary:     "Get the offset to resume an upload from",
+			Tags:        []string{"uploads"},
+			Parameters:  []openapi.Parameter{tusResumable, uploadID},
+			Responses: tusResponses(map[string]*openapi.Response{
+				"200": {Description: "Upload-Offset and Upload-Length headers describe the upload"},
+			}),
+			Security: authenticated,
+		})
+		doc.Patch(uploadPath+"/{id}", &openapi.Operation{
+			OperationID: "appendUpload" + strings.ToUpper(version.Name),
+			Summary:     "Append a part to an upload",
+			Tags:        []string{"uploads"},
+			Parameters: []openapi.Parameter{
+				tusResumable,
+				uploadID,
+				{Name: "Upload-Offset", In: "header", Required: true, Description: "Offset the part starts at", Schema: &openapi.Schema{Type: "integer", Format: "int64"}},
+			},
+			RequestBody: &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]openapi.MediaType{tusContentType: {Schema: &openapi.Schema{Type: "string", Format: "binary"}}},
+			},
+			Responses: tusResponses(map[string]*openapi.Response{
+				"204": {Description: "Part stored; Upload-Offset is the new offset"},
+				"400": errorResponse("Invalid Upload-Offset"),
+				"409": errorResponse("Upload-Offset does not match the upload"),
+				"413": errorResponse("Part exceeds Upload-Length"),
+				"415": errorResponse("Content-Type is not " + tusContentType),
+			}),
+			Security: authenticated,
+		})
+		doc.Delete(uploadPath+"/{id}", &openapi.Operation{
+			OperationID: "deleteUpload" + strings.ToUpper(version.Name),
+			Summary:     "Abandon an upload",
+			Tags:        []string{"uploads"},
+			Parameters:  []openapi.Parameter{tusResumable, uploadID},
+			Responses: tusResponses(map[string]*openapi.Response{
+				"204": {Description: "Upload deleted"},
+			}),
+			Security: authenticated,
+		})
+		doc.Post(uploadPath+"/{id}/metadata", &openapi.Operation{
+			OperationID: "extractUploadMetadata" + strings.ToUpper(version.Name),
+			Summary:     "Extract metadata from a completed upload (" + version.Name + ")",
+			Tags:        []string{"uploads"},
+			Parameters:  append([]openapi.Parameter{uploadID}, append(slices.Clone(extractParameters), responseParameters...)...),
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Extracted metadata", Content: formats},
+				"400": errorResponse("Invalid options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:write scope"),
+				"404": errorResponse("Unknown or expired upload, or resumable uploads are disabled"),
+				"409": errorResponse("Upload incomplete"),
+				"413": errorResponse("Upload larger than the API key's upload size"),
+				"429": extractionLimited,
+				"500": errorResponse("Extraction failed"),
+				"503": errorResponse("Antivirus scan unavailable and CLAMAV_FAIL_MODE is closed, or every extraction slot stayed busy (see Retry-After)"),
+				"504": errorResponse("Extraction exceeded EXTRACTION_TIMEOUT"),
+			},
+			Security: authenticated,
+		})
+
+		// A job is the jobs.Job schema plus the result in this version
+		doc.SchemaFor(jobs.Job{})
+		job := *doc.Components.Schemas["Job"]
+		job.Properties = maps.Clone(job.Properties)
+		job.Properties["result"] = result
+		jobContent := map[string]openapi.MediaType{"application/json": {Schema: &job}}
+		jobPath := "/" + version.Name + "/jobs"
+		jobID := openapi.Parameter{
+			Name: "id", In: "path", Required: true,
+			Description: "Job ID from the Location of the created job",
+			Schema:      &openapi.Schema{Type: "string"},
+		}
+
+		doc.Post(jobPath, &openapi.Operation{
+			OperationID: "createJob" + strings.ToUpper(version.Name),
+			Summary:     "Extract metadata from a completed upload in the background (" + version.Name + ")",
+			Description: "Starts an extraction job. The Location response header is the job URL; follow its events for progress.",
+			Tags:        []string{"jobs"},
+			Parameters: append([]openapi.Parameter{{
+				Name: "upload_id", In: "query", Required: true,
+				Description: "ID of a completed resumable upload",
+				Schema:      &openapi.Schema{Type: "string"},
+			}}, extractParameters...),
+			Responses: map[string]*openapi.Response{
+				"202": {Description: "Job created", Content: jobContent},
+				"400": errorResponse("Missing upload_id or invalid options"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:write scope"),
+				"404": errorResponse("Unknown or expired upload, or async jobs are disabled"),
+				"409": errorResponse("Upload incomplete"),
+				"413": errorResponse("Upload larger than the API key's upload size"),
+				"429": rateLimited,
+				"503": errorResponse("Server shutting down"),
+			},
+			Security: authenticated,
+		})
+		doc.Get(jobPath+"/{id}", &openapi.Operation{
+			OperationID: "getJob" + strings.ToUpper(version.Name),
+			Summary:     "Get a job's status, stages and result (" + version.Name + ")",
+			Tags:        []string{"jobs"},
+			Parameters:  []openapi.Parameter{jobID},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "The job", Content: jobContent},
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:read scope"),
+				"404": errorResponse("Unknown or expired job, or async jobs are disabled"),
+				"429": rateLimited,
+			},
+			Security: authenticated,
+		})
+		doc.Get(jobPath+"/{id}/events", &openapi.Operation{
+			OperationID: "streamJobEvents" + strings.ToUpper(version.Name),
+			Summary:     "Follow a job's progress (" + version.Name + ")",
+			Description: "Server-sent events, one per finished stage, with a JSON object of the stage and its time as data. " +
+				"The final done or failed event also carries the result or the error, then the stream ends. " +
+				"Event IDs count the stages; send Last-Event-ID to resume.",
+			Tags: []string{"jobs"},
+			Parameters: []openapi.Parameter{
+				jobID,
+				{Name: "Last-Event-ID", In: "header", Description: "ID of the last event received", Schema: &openapi.Schema{Type: "integer", Format: "int32"}},
+			},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Event stream", Content: map[string]openapi.MediaType{"text/event-stream": {Schema: &openapi.Schema{Type: "string"}}}},
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:read scope"),
+				"404": errorResponse("Unknown or expired job, or async jobs are disabled"),
+				"429": rateLimited,
+			},
+			Security: authenticated,
+		})
+
+		historyContent := map[string]openapi.MediaType{}
+		for _, contentType := range formatContentTypes {
+			historyContent[contentType] = openapi.MediaType{Schema: doc.SchemaFor(history.Page{})}
+		}
+		doc.Get("/"+version.Name+"/history", &openapi.Operation{
+			OperationID: "listHistory" + strings.ToUpper(version.Name),
+			Summary:     "List the API key's extractions (" + version.Name + ")",
+			Description: "Returns the calling API key's recorded extractions, newest first. Pass a page's next_cursor as cursor for the next page; the last page has none.",
+			Tags:        []string{"history"},
+			Parameters: []openapi.Parameter{
+				{Name: "limit", In: "query", Description: "Extractions per page, " + strconv.Itoa(historyDefaultLimit) + " by default and at most " + strconv.Itoa(historyMaxLimit), Schema: &openapi.Schema{Type: "integer", Format: "int32"}},
+				{Name: "cursor", In: "query", Description: "next_cursor of the previous page", Schema: &openapi.Schema{Type: "string"}},
+				responseParameters[1],
+			},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "A page of extractions", Content: historyContent},
+				"400": errorResponse("Invalid limit, cursor or format"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:read scope"),
+				"404": errorResponse("The extraction history is disabled"),
+				"429": rateLimited,
+				"500": errorResponse("History store unavailable"),
+			},
+			Security: authenticated,
+		})
+
+		similarContent := map[string]openapi.MediaType{}
+		for _, contentType := range formatContentTypes {
+			similarContent[contentType] = openapi.MediaType{Schema: doc.SchemaFor(SimilarResponse{})}
+		}
+		doc.Get("/"+version.Name+"/similar", &openapi.Operation{
+			OperationID: "findSimilar" + strings.ToUpper(version.Name),
+			Summary:     "Find near-duplicate images by perceptual hash (" + version.Name + ")",
+			Description: "Searches the images recorded for the calling API key for perceptual hashes (image.phash) within a Hamming distance of phash, nearest first.",
+			Tags:        []string{"history"},
+			Parameters: []openapi.Parameter{
+				{Name: "phash", In: "query", Required: true, Description: "Perceptual hash to search for, 16 hex digits", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "distance", In: "query", Description: "Most bits a match may differ in, " + strconv.Itoa(similarDefaultDistance) + " by default and at most " + strconv.Itoa(similarMaxDistance), Schema: &openapi.Schema{Type: "integer", Format: "int32"}},
+				{Name: "limit", In: "query", Description: "Most matches to return, " + strconv.Itoa(historyDefaultLimit) + " by default and at most " + strconv.Itoa(historyMaxLimit), Schema: &openapi.Schema{Type: "integer", Format: "int32"}},
+				responseParameters[1],
+			},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Matching images", Content: similarContent},
+				"400": errorResponse("Invalid phash, distance, limit or format"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:read scope"),
+				"404": errorResponse("The extraction history is disabled"),
+				"429": rateLimited,
+				"500": errorResponse("History store unavailable"),
+			},
+			Security: authenticated,
+		})
+
+		usageContent := map[string]openapi.MediaType{}
+		for _, contentType := range formatContentTypes {
+			usageContent[contentType] = openapi.MediaType{Schema: doc.SchemaFor(usage.Usage{})}
+		}
+		doc.Get("/"+version.Name+"/usage", &openapi.Operation{
+			OperationID: "getUsage" + strings.ToUpper(version.Name),
+			Summary:     "Get the API key's usage (" + version.Name + ")",
+			Description: "Returns the calling API key's requests, extracted files and bytes in a calendar month (UTC), with files and bytes per MIME type.",
+			Tags:        []string{"usage"},
+			Parameters:  []openapi.Parameter{usageMonth, responseParameters[1]},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "The month's usage", Content: usageContent},
+				"400": errorResponse("Invalid month or format"),
+				"401": errorResponse("Invalid or missing API key"),
+				"403": errorResponse("API key lacks the metadata:read scope"),
+				"404": errorResponse("Usage tracking is disabled"),
+				"429": rateLimited,
+				"500": errorResponse("Usage store unavailable"),
+			},
+			Security: authenticated,
+		})
+
+		doc.Get("/"+version.Name+"/schema", &openapi.Operation{
+			OperationID: "getResultSchema" + strings.ToUpper(version.Name),
+			Summary:     "JSON Schema of the result (" + version.Name + ")",
+			Description: "Returns a JSON Schema (draft 2020-12) of this version's extraction result and the types it is made of, for validation and code generation. No API key is needed.",
+			Tags:        []string{"metadata"},
+			Responses: map[string]*openapi.Response{
+				"200": {
+					Description: "The result schema, with the types it refers to under $defs",
+					Content:     map[string]openapi.MediaType{"application/schema+json": {Schema: &openapi.Schema{Type: "object"}}},
+				},
+				"405": errorResponse("Method not allowed"),
+			},
+		})
+	}
+
+	// GraphQL queries select fields of the newest version's Result schema
+	latest := Versions[len(Versions)-1]
+	graphQLDescription := "Looks up stored results and returns only the selected fields. " +
+		"Query fields: result(sha256: String!) and results(sha256: [String!]!), which returns null for unknown checksums. " +
+		"Result objects have the fields of the " + latest.Name + " schema. " +
+		"Errors are reported in the errors list of a 200 response. Fragments, directives and mutations are not supported."
+	graphQLResponses := map[string]*openapi.Response{
+		"200": {
+			Description: "Query response with data, errors or both",
+			Content: map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+				Type: "object",
+				Properties: map[string]*openapi.Schema{
+					"data":   {Type: "object"},
+					"errors": {Type: "array", Items: &openapi.Schema{Type: "object"}},
+				},
+			}}},
+		},
+		"400": errorResponse("Missing query, or malformed body or variables"),
+		"401": errorResponse("Invalid or missing API key"),
+		"403": errorResponse("API key lacks the metadata:read scope"),
+		"404": errorResponse("The result cache and the extraction history are disabled"),
+		"415": errorResponse("POST body is not application/json"),
+		"429": rateLimited,
+	}
+	doc.Get("/graphql", &openapi.Operation{
+		OperationID: "graphQLQuery",
+		Summary:     "Query stored results with GraphQL",
+		Description: graphQLDescription,
+		Tags:        []string{"metadata"},
+		Parameters: []openapi.Parameter{
+			{Name: "query", In: "query", Required: true, Description: "GraphQL query", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "variables", In: "query", Description: "Variables as a JSON object", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "operationName", In: "query", Description: "Operation to run when the query has several", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: graphQLResponses,
+		Security:  authenticated,
+	})
+	doc.Post("/graphql", &openapi.Operation{
+		OperationID: "graphQLQueryPost",
+		Summary:     "Query stored results with GraphQL",
+		Description: graphQLDescription,
+		Tags:        []string{"metadata"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+				Type: "object",
+				Properties: map[string]*openapi.Schema{
+					"query":         {Type: "string"},
+					"variables":     {Type: "object"},
+					"operationName": {Type: "string"},
+				},
+				Required: []string{"query"},
+			}}},
+		},
+		Responses: graphQLResponses,
+		Security:  authenticated,
+	})
+
+	adminUsageContent := map[string]openapi.MediaType{}
+	for _, contentType := range formatContentTypes {
+		adminUsageContent[contentType] = openapi.MediaType{Schema: doc.SchemaFor(UsageReport{})}
+	}
+	doc.Get("/admin/usage", &openapi.Operation{
+		OperationID: "getAllUsage",
+		Summary:     "Get every API key's usage",
+		Description: "Returns the usage of every API key used in a calendar month (UTC), by API key ID. Only keys listed in ADMIN_API_KEYS may call it.",
+		Tags:        []string{"usage"},
+		Parameters:  []openapi.Parameter{usageMonth, responseParameters[1]},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The month's usage per key", Content: adminUsageContent},
+			"400": errorResponse("Invalid month or format"),
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"404": errorResponse("Usage tracking is disabled"),
+			"429": rateLimited,
+			"500": errorResponse("Usage store unavailable"),
+		},
+		Security: authenticated,
+	})
+
+	exportContent := map[string]openapi.MediaType{
+		"application/json": {Schema: doc.SchemaFor(UsageReport{})},
+		"text/csv":         {Schema: &openapi.Schema{Type: "string", Description: "Columns " + strings.Join(usageCSVHeader, ",")}},
+	}
+	doc.Get("/admin/usage/export", &openapi.Operation{
+		OperationID: "exportUsage",
+		Summary:     "Export every API key's usage for billing",
+		Description: "Returns every API key's usage in a calendar month (UTC) as a JSON or CSV attachment. " +
+			"CSV has a total row per key, a row per endpoint with its requests and a row per MIME type with its files and bytes. " +
+			"Only keys listed in ADMIN_API_KEYS may call it.",
+		Tags: []string{"usage"},
+		Parameters: []openapi.Parameter{
+			usageMonth,
+			{Name: "format", In: "query", Description: "csv or json, json by default unless Accept is text/csv", Schema: &openapi.Schema{Type: "string", Enum: []string{"csv", "json"}}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The month's usage per key", Content: exportContent},
+			"400": errorResponse("Invalid month or format"),
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"404": errorResponse("Usage tracking is disabled"),
+			"429": rateLimited,
+			"500": errorResponse("Usage store unavailable"),
+		},
+		Security: authenticated,
+	})
+
+	keyID := openapi.Parameter{Name: "key_id", In: "path", Description: "API key ID, as in usage reports", Required: true, Schema: &openapi.Schema{Type: "string"}}
+	overridesContent := map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(overrides.Overrides{})}}
+	doc.Get("/admin/overrides/{key_id}", &openapi.Operation{
+		OperationID: "getOverrides",
+		Summary:     "Get an API key's settings overrides",
+		Tags:        []string{"admin"},
+		Parameters:  []openapi.Parameter{keyID},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The key's overrides", Content: overridesContent},
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"404": errorResponse("Key overrides are disabled, or the key has none"),
+			"429": rateLimited,
+			"500": errorResponse("Override store unavailable"),
+		},
+		Security: authenticated,
+	})
+	doc.Put("/admin/overrides/{key_id}", &openapi.Operation{
+		OperationID: "setOverrides",
+		Summary:     "Set an API key's settings overrides",
+		Description: "Replaces the key's overrides of the upload size limit, the extraction modules that may run, how long its results are cached and privacy mode. " +
+			"They apply to the key's next request on every instance. Only keys listed in ADMIN_API_KEYS may call it.",
+		Tags:        []string{"admin"},
+		Parameters:  []openapi.Parameter{keyID},
+		RequestBody: &openapi.RequestBody{Required: true, Content: overridesContent},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The overrides now in effect", Content: overridesContent},
+			"400": errorResponse("Invalid overrides"),
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"404": errorResponse("Key overrides are disabled"),
+			"429": rateLimited,
+			"500": errorResponse("Override store unavailable"),
+		},
+		Security: authenticated,
+	})
+	doc.Delete("/admin/overrides/{key_id}", &openapi.Operation{
+		OperationID: "deleteOverrides",
+		Summary:     "Remove an API key's settings overrides",
+		Tags:        []string{"admin"},
+		Parameters:  []openapi.Parameter{keyID},
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "The key uses the process-wide settings again"},
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"404": errorResponse("Key overrides are disabled"),
+			"429": rateLimited,
+			"500": errorResponse("Override store unavailable"),
+		},
+		Security: authenticated,
+	})
+
+	logLevelContent := map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(LogLevel{})}}
+	doc.Get("/admin/log-level", &openapi.Operation{
+		OperationID: "getLogLevel",
+		Summary:     "Get the instance's log level",
+		Tags:        []string{"admin"},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "debug, info, warn or error", Content: logLevelContent},
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"429": rateLimited,
+		},
+		Security: authenticated,
+	})
+	doc.Put("/admin/log-level", &openapi.Operation{
+		OperationID: "setLogLevel",
+		Summary:     "Change the instance's log level",
+		Description: "Sets the log level of the instance serving the request until it restarts or the level is changed again; other instances keep theirs. " +
+			"To log a single request at debug level instead, an admin key can send it with X-Debug: true.",
+		Tags:        []string{"admin"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: logLevelContent},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The log level now in effect", Content: logLevelContent},
+			"400": errorResponse("Invalid log level"),
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"429": rateLimited,
+		},
+		Security: authenticated,
+	})
+
+	moduleStates := map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+		Type:                 "object",
+		Description:          "Whether each extraction module runs, by name: " + strings.Join(metadata.Modules, ", "),
+		AdditionalProperties: &openapi.Schema{Type: "boolean"},
+	}}}
+	doc.Get("/admin/modules", &openapi.Operation{
+		OperationID: "getModules",
+		Summary:     "Get the instance's extraction module switches",
+		Tags:        []string{"admin"},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Whether each module runs", Content: moduleStates},
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"429": rateLimited,
+		},
+		Security: authenticated,
+	})
+	doc.Put("/admin/modules", &openapi.Operation{
+		OperationID: "setModules",
+		Summary:     "Switch extraction modules on or off",
+		Description: "Switches the named modules of the instance serving the request until it restarts, when DISABLED_MODULES applies again; modules left out and other instances keep their state. " +
+			"A module switched off never runs, whatever the profile, include or key overrides ask for.",
+		Tags:        []string{"admin"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: moduleStates},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Whether each module now runs", Content: moduleStates},
+			"400": errorResponse("Invalid body or unknown module"),
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"429": rateLimited,
+		},
+		Security: authenticated,
+	})
+
+	doc.Get("/admin/config", &openapi.Operation{
+		OperationID: "getConfig",
+		Summary:     "Get the instance's effective configuration",
+		Description: "Every configuration field by name, formatted as text. Secrets only show whether they are set, and passwords in URLs are masked.",
+		Tags:        []string{"admin"},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Settings by field name", Content: map[string]openapi.MediaType{"application/json": {Schema: &openapi.Schema{
+				Type:                 "object",
+				AdditionalProperties: &openapi.Schema{Type: "string"},
+			}}}},
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"429": rateLimited,
+		},
+		Security: authenticated,
+	})
+
+	doc.Get("/admin/dashboard/data", &openapi.Operation{
+		OperationID: "getDashboardData",
+		Summary:     "Get the admin dashboard's snapshot of the instance",
+		Description: "Requests and errors since the start, requests in flight, extraction slots, the rate limit of each key that made a request, most saturated first, and the latest 4xx and 5xx responses, newest first. Not rate limited, so the dashboard page at /admin/dashboard/ can poll it.",
+		Tags:        []string{"admin"},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The instance's snapshot", Content: map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(DashboardData{})}}},
+			"401": errorResponse("Invalid or missing API key"),
+			"403": errorResponse("API key lacks the admin scope"),
+			"429": rateLimited,
+		},
+		Security: authenticated,
+	})
+
+	if len(cfg.OAuthClients) > 0 {
+		tokenError := map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(TokenError{})}}
+		doc.Post("/oauth/token", &openapi.Operation{
+			OperationID: "issueAccessToken",
+			Summary:     "Issue an OAuth access token",
+			Description: "Issues an access token with the OAuth 2.0 client credentials grant to a client listed in OAUTH_CLIENTS. " +
+				"The client authenticates with its ID and its API key as the secret, by HTTP Basic or as client_id and client_secret in the form. " +
+				"The token is sent as Authorization: Bearer and works like the API key, with its scopes, until it expires.",
+			Tags: []string{"auth"},
+			RequestBody: &openapi.RequestBody{
+				Required: true,
+				Content: map[string]openapi.MediaType{"application/x-www-form-urlencoded": {Schema: &openapi.Schema{
+					Type: "object",
+					Properties: map[string]*openapi.Schema{
+						"grant_type":    {Type: "string", Enum: []string{"client_credentials"}},
+						"client_id":     {Type: "string"},
+						"client_secret": {Type: "string"},
+						"scope":         {Type: "string", Description: "Space-separated scopes the client must have: " + strings.Join(config.Scopes, ", ")},
+					},
+					Required: []string{"grant_type"},
+				}}},
+			},
+			Responses: map[string]*openapi.Response{
+				"200": {
+					Description: "The access token",
+					Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(TokenResponse{})}},
+				},
+				"400": {Description: "Malformed request, unsupported grant type or a scope the client lacks", Content: tokenError},
+				"401": {Description: "Unknown client or wrong secret", Content: tokenError},
+				"429": rateLimited,
+			},
+		})
+	}
+
+	doc.Get("/", &openapi.Operation{
+		OperationID: "index",
+		Summary:     "Index of the API versions and endpoints",
+		Tags:        []string{"health"},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Server name and version, API versions with their result schemas, every endpoint with its methods, and links to the OpenAPI document and status endpoints",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(Index{})}},
+			},
+		},
+	})
+
+	doc.Get("/health", &openapi.Operation{
+		OperationID: "health",
+		Summary:     "Health check with build info and dependency status",
+		Tags:        []string{"health"},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Service is up, perhaps degraded or, without HEALTH_FAIL_CRITICAL, down",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(models.HealthResponse{})}},
+			},
+			"503": {
+				Description: "A critical dependency is down (with HEALTH_FAIL_CRITICAL)",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(models.HealthResponse{})}},
+			},
+		},
+	})
+
+	doc.Get("/version", &openapi.Operation{
+		OperationID: "version",
+		Summary:     "Build and enabled features",
+		Tags:        []string{"health"},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Version, commit, build date, Go version, enabled features, extraction modules and profiles",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(models.VersionInfo{})}},
+			},
+		},
+	})
+
+	doc.Get("/livez", &openapi.Operation{
+		OperationID: "liveness",
+		Summary:     "Liveness probe",
+		Tags:        []string{"health"},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The process is serving requests",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(models.ProbeResponse{})}},
+			},
+		},
+	})
+
+	doc.Get("/readyz", &openapi.Operation{
+		OperationID: "readiness",
+		Summary:     "Readiness probe",
+		Tags:        []string{"health"},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The instance should be sent requests",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(models.ProbeResponse{})}},
+			},
+			"503": {
+				Description: "Draining for shutdown, a critical dependency is down or every extraction slot is taken",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: doc.SchemaFor(models.ProbeResponse{})}},
+			},
+		},
+	})
+
+	doc.Get("/metrics", &openapi.Operation{
+		OperationID: "metrics",
+		Summary:     "Service metrics in the Prometheus text format",
+		Tags:        []string{"health"},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Counters and gauges",
+				Content:     map[string]openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}},
+			},
+		},
+	})
+
+	return doc
+}
+
+// OpenAPIHandler serves the OpenAPI document as JSON
+func OpenAPIHandler(cfg *config.Config, log *logger.Logger) http.HandlerFunc {
+	spec, err := json.MarshalIndent(Spec(cfg), "", "  ")
+	if err != nil {
+		panic("failed to encode OpenAPI document: " + err.Error())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(spec); err != nil {
+			log.Errorf("Failed to write OpenAPI document: %v", err)
+		}
+	}
+}
+
+// SchemaHandler serves a JSON Schema of version's result, generated from
+// the same types as the OpenAPI document
+func SchemaHandler(log *logger.Logger, version Version) http.HandlerFunc {
+	schema := openapi.JSONSchema(version.Response, "/"+version.Name+"/schema", "file-meta "+version.Name+" result")
+	body, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		panic("failed to encode JSON Schema: " + err.Error())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/schema+json")
+		if _, err := w.Write(body); err != nil {
+			log.Errorf("Failed to write JSON Schema: %v", err)
+		}
+	}
+}
+
+// swaggerUIPage renders Swagger UI for /openapi.json. The UI assets are
+// loaded from the unpkg CDN.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>file-meta API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// DocsHandler serves Swagger UI
+func DocsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	}
+}
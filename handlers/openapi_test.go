@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/openapi"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+		Profiles:          map[string][]string{"fast": {"image"}},
+	}
+	log := logger.New("info")
+
+	rr := httptest.NewRecorder()
+	OpenAPIHandler(cfg, log).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var doc openapi.Document
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.OpenAPI != openapi.Version {
+		t.Errorf("openapi = %v, want %v", doc.OpenAPI, openapi.Version)
+	}
+
+	metadataOp := doc.Paths["/v1/metadata"]
+	if metadataOp == nil || metadataOp.Post == nil {
+		t.Fatal("POST /v1/metadata missing")
+	}
+	if v2 := doc.Paths["/v2/metadata"]; v2 == nil || v2.Post == nil {
+		t.Error("POST /v2/metadata missing")
+	} else if _, ok := doc.Components.Schemas["ResultV2"].Properties["checksums"]; !ok {
+		t.Error("ResultV2 schema missing checksums")
+	}
+	if lookup := doc.Paths["/v1/metadata/{sha256}"]; lookup == nil || lookup.Get == nil {
+		t.Error("GET /v1/metadata/{sha256} missing")
+	} else if _, ok := lookup.Get.Responses["304"]; !ok {
+		t.Error("GET /v1/metadata/{sha256} missing 304 response")
+	}
+	if upload := doc.Paths["/v1/uploads/{id}"]; upload == nil || upload.Head == nil || upload.Patch == nil || upload.Delete == nil {
+		t.Error("tus operations on /v1/uploads/{id} missing")
+	}
+	if events := doc.Paths["/v2/jobs/{id}/events"]; events == nil || events.Get == nil {
+		t.Error("GET /v2/jobs/{id}/events missing")
+	}
+	if job := doc.Paths["/v2/jobs/{id}"]; job == nil || job.Get == nil {
+		t.Error("GET /v2/jobs/{id} missing")
+	} else if result := job.Get.Responses["200"].Content["application/json"].Schema.Properties["result"]; result == nil || result.Ref != "#/components/schemas/ResultV2" {
+		t.Errorf("v2 job result schema = %+v, want ResultV2", result)
+	}
+	if doc.Paths["/health"] == nil || doc.Paths["/health"].Get == nil {
+		t.Error("GET /health missing")
+	}
+	if item := doc.Paths["/admin/overrides/{key_id}"]; item == nil || item.Get == nil || item.Put == nil || item.Delete == nil {
+		t.Error("operations on /admin/overrides/{key_id} missing")
+	}
+	if item := doc.Paths["/admin/log-level"]; item == nil || item.Get == nil || item.Put == nil {
+		t.Error("operations on /admin/log-level missing")
+	}
+	if doc.Paths["/oauth/token"] != nil {
+		t.Error("POST /oauth/token documented without OAUTH_CLIENTS")
+	}
+
+	// OAuth clients add the token endpoint and access tokens
+	cfg.OAuthClients = map[string]string{"reports": "reports_key"}
+	withOAuth := Spec(cfg)
+	cfg.OAuthClients = nil
+	if token := withOAuth.Paths["/oauth/token"]; token == nil || token.Post == nil {
+		t.Error("POST /oauth/token missing with OAUTH_CLIENTS")
+	}
+	if security := withOAuth.Paths["/v1/metadata"].Post.Security; len(security) != 2 {
+		t.Errorf("POST /v1/metadata security = %v, want an API key or access token", security)
+	}
+
+	for _, contentType := range formatContentTypes {
+		if _, ok := metadataOp.Post.Responses["200"].Content[contentType]; !ok {
+			t.Errorf("200 response missing %s", contentType)
+		}
+	}
+
+	for _, param := range metadataOp.Post.Parameters {
+		if param.Name == "profile" && (len(param.Schema.Enum) != 1 || param.Schema.Enum[0] != "fast") {
+			t.Errorf("profile enum = %v, want configured profiles", param.Schema.Enum)
+		}
+	}
+
+	// Every field of a real response is described by the Result schema
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "test.txt")
+	io.WriteString(part, "Hello, World!\n")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr = httptest.NewRecorder()
+	MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+	var response map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	result := doc.Components.Schemas["Result"]
+	if result == nil {
+		t.Fatal("Result schema missing")
+	}
+	for key := range response {
+		if _, ok := result.Properties[key]; !ok {
+			t.Errorf("response field %q not in the Result schema", key)
+		}
+	}
+	if _, ok := doc.Components.Schemas["DocumentMetadata"].Properties["word_count"]; !ok {
+		t.Error("DocumentMetadata schema missing word_count")
+	}
+}
+
+func TestSchemaHandler(t *testing.T) {
+	log := logger.New("info")
+
+	tests := []struct {
+		name       string
+		version    Version
+		method     string
+		wantStatus int
+		wantRoot   string
+		wantProp   string
+	}{
+		{"v1", Versions[0], http.MethodGet, http.StatusOK, "Result", "checksum_sha256"},
+		{"v2", Versions[1], http.MethodGet, http.StatusOK, "ResultV2", "checksums"},
+		{"post", Versions[0], http.MethodPost, http.StatusMethodNotAllowed, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			SchemaHandler(log, tt.version).ServeHTTP(rr, httptest.NewRequest(tt.method, "/"+tt.version.Name+"/schema", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/schema+json" {
+				t.Errorf("Content-Type = %q, want application/schema+json", ct)
+			}
+
+			var schema openapi.Schema
+			if err := json.NewDecoder(rr.Body).Decode(&schema); err != nil {
+				t.Fatal(err)
+			}
+			if schema.Dialect != openapi.JSONSchemaDialect || schema.ID != "/"+tt.version.Name+"/schema" {
+				t.Errorf("$schema = %q, $id = %q", schema.Dialect, schema.ID)
+			}
+			if schema.Ref != "#/$defs/"+tt.wantRoot {
+				t.Fatalf("$ref = %q, want #/$defs/%s", schema.Ref, tt.wantRoot)
+			}
+			if _, ok := schema.Defs[tt.wantRoot].Properties[tt.wantProp]; !ok {
+				t.Errorf("%s schema missing %s", tt.wantRoot, tt.wantProp)
+			}
+			if _, ok := schema.Defs["ImageMetadata"]; !ok {
+				t.Error("$defs missing ImageMetadata")
+			}
+		})
+	}
+}
+
+func TestDocsHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	DocsHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %v, want text/html", ct)
+	}
+	if !bytes.Contains(rr.Body.Bytes(), []byte(`url: "/openapi.json"`)) {
+		t.Error("Swagger UI page should load /openapi.json")
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/overrides"
+	"file-meta/middleware"
+)
+
+// OverridesHandler reads (GET), replaces (PUT) and removes (DELETE) the
+// settings overrides of the API key with the key_id path value, as listed
+// in usage reports
+func OverridesHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.Overrides == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Key overrides are disabled")
+			return
+		}
+		keyID := r.PathValue("key_id")
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			o, err := deps.Overrides.Get(r.Context(), keyID)
+			if err != nil {
+				log.Errorf("[%s] Failed to read overrides: %v", requestID, err)
+				middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to read overrides")
+				return
+			}
+			if o == nil {
+				middleware.WriteError(w, http.StatusNotFound, models.CodeNotFound, "No overrides for this key")
+				return
+			}
+			writeOverrides(w, log, requestID, o)
+
+		case http.MethodPut:
+			var o overrides.Overrides
+			decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&o); err != nil {
+				log.Warnf("[%s] Invalid overrides: %v", requestID, err)
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid overrides: "+err.Error())
+				return
+			}
+			if err := o.Validate(); err != nil {
+				log.Warnf("[%s] Invalid overrides: %v", requestID, err)
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid overrides: "+err.Error())
+				return
+			}
+			if err := deps.Overrides.Put(r.Context(), keyID, o); err != nil {
+				log.Errorf("[%s] Failed to save overrides: %v", requestID, err)
+				middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to save overrides")
+				return
+			}
+			log.Infof("[%s] Set overrides of API key %s", requestID, keyID)
+			writeOverrides(w, log, requestID, &o)
+
+		case http.MethodDelete:
+			if err := deps.Overrides.Delete(r.Context(), keyID); err != nil {
+				log.Errorf("[%s] Failed to delete overrides: %v", requestID, err)
+				middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to delete overrides")
+				return
+			}
+			log.Infof("[%s] Removed overrides of API key %s", requestID, keyID)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// writeOverrides responds with o as JSON
+func writeOverrides(w http.ResponseWriter, log *logger.Logger, requestID string, o *overrides.Overrides) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+	}
+}
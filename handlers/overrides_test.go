@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/overrides"
+	"file-meta/middleware"
+)
+
+func TestOverridesHandler(t *testing.T) {
+	log := logger.New("error")
+	store := overrides.NewMemoryStore()
+	handler := OverridesHandler(log, Deps{Overrides: store})
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/overrides/alice", strings.NewReader(body))
+		req.SetPathValue("key_id", "alice")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve(http.MethodGet, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("GET before PUT status = %d, want 404", rr.Code)
+	}
+	for _, body := range []string{`{"max_file_size_mb": -1}`, `{"modules": ["telepathy"]}`, `{"max_file_size": 5}`, `not json`} {
+		if rr := serve(http.MethodPut, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s status = %d, want 400", body, rr.Code)
+		}
+	}
+
+	if rr := serve(http.MethodPut, `{"max_file_size_mb": 5, "modules": ["document"]}`); rr.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", rr.Code, rr.Body)
+	}
+	rr := serve(http.MethodGet, "")
+	var got overrides.Overrides
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.MaxFileSizeMB != 5 || len(got.Modules) != 1 {
+		t.Errorf("GET = %+v (%v), want the overrides just set", got, err)
+	}
+
+	if rr := serve(http.MethodDelete, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want 204", rr.Code)
+	}
+	if o, _ := store.Get(context.Background(), "alice"); o != nil {
+		t.Errorf("overrides after DELETE = %+v, want none", o)
+	}
+
+	if rr := serve(http.MethodPost, "{}"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rr.Code)
+	}
+}
+
+func TestMetadataHandlerOverrides(t *testing.T) {
+	cfg := &config.Config{
+		MaxFileSizeMB: 4,
+		APIKeys:       map[string]bool{"small_key": true, "docs_key": true, "plain_key": true},
+	}
+	log := logger.New("error")
+	store := overrides.NewMemoryStore()
+	store.Put(context.Background(), history.KeyID("small_key"), overrides.Overrides{MaxFileSizeMB: 1})
+	store.Put(context.Background(), history.KeyID("docs_key"), overrides.Overrides{Modules: []string{metadata.ModuleDocument}})
+	handler := middleware.APIKeyAuth(cfg, log)(middleware.ResolveOverrides(store, log)(MetadataHandler(cfg, log, Deps{})))
+
+	// Two megabytes of text, long enough for an ssdeep hash
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. 0123456789\n", 2<<20/56)
+
+	tests := []struct {
+		key          string
+		query        string
+		wantCode     int
+		expectSSDeep bool
+	}{
+		{key: "small_key", wantCode: http.StatusRequestEntityTooLarge},
+		{key: "plain_key", wantCode: http.StatusOK, expectSSDeep: true},
+		{key: "docs_key", wantCode: http.StatusOK},
+		{key: "docs_key", query: "?include=ssdeep,document", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+tt.query, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", "big.txt")
+			io.WriteString(part, content)
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata"+tt.query, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.Header.Set("X-API-Key", tt.key)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantCode, rr.Body)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var result metadata.Result
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if (result.SSDeep != "") != tt.expectSSDeep {
+				t.Errorf("SSDeep = %q, want present: %v", result.SSDeep, tt.expectSSDeep)
+			}
+			if result.Document == nil {
+				t.Error("Document missing, want the document module to run")
+			}
+		})
+	}
+}
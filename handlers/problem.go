@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problem is an RFC 9457 problem details body. Code is one of the
+// models.Code values, as in other error responses. Limit and Max name the
+// configured limit a request breached; Reason, MIMEType and Extension say
+// why the file type policy rejected a file.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Limit     string `json:"limit,omitempty"`
+	Max       int64  `json:"max,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	MIMEType  string `json:"mime_type,omitempty"`
+	Extension string `json:"extension,omitempty"`
+}
+
+// writeProblem writes p as application/problem+json
+func writeProblem(w http.ResponseWriter, p problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
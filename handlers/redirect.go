@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// RedirectHTTPSHandler redirects plain HTTP requests to the same URL over
+// HTTPS on port, keeping the method and body
+func RedirectHTTPSHandler(port string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if host == "" {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Host header required")
+			return
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectHTTPSHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     string
+		target   string
+		host     string
+		wantCode int
+		wantURL  string
+	}{
+		{name: "default port", port: "443", target: "/v1/metadata?modules=exif", host: "files.example.com", wantCode: http.StatusPermanentRedirect, wantURL: "https://files.example.com/v1/metadata?modules=exif"},
+		{name: "other port", port: "8443", target: "/health", host: "files.example.com:8080", wantCode: http.StatusPermanentRedirect, wantURL: "https://files.example.com:8443/health"},
+		{name: "IPv6", port: "443", target: "/", host: "[2001:db8::1]:80", wantCode: http.StatusPermanentRedirect, wantURL: "https://[2001:db8::1]/"},
+		{name: "no host", port: "443", target: "/", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+			RedirectHTTPSHandler(tt.port).ServeHTTP(rr, req)
+			if rr.Code != tt.wantCode || rr.Header().Get("Location") != tt.wantURL {
+				t.Errorf("response = %d to %q, want %d to %q", rr.Code, rr.Header().Get("Location"), tt.wantCode, tt.wantURL)
+			}
+		})
+	}
+}
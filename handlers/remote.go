@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/storage"
+	"file-meta/internal/tempfiles"
+	"file-meta/middleware"
+)
+
+// remoteRequest names an object in cloud storage: scheme://container/name
+// (s3://, gs:// or azure://), or a presigned, signed or SAS URL
+type remoteRequest struct {
+	URI string `json:"uri"`
+}
+
+// s3Request names an object by bucket and key, or by a presigned URL
+type s3Request struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+}
+
+// RemoteMetadataHandler extracts metadata from an object in cloud storage
+// that the server reads itself, so the file never passes through the
+// client. It takes the same options as an upload to the metadata endpoint.
+func RemoteMetadataHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if len(deps.Storage) == 0 {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Remote ingestion is disabled")
+			return
+		}
+
+		var req remoteRequest
+		if !decodeRemoteRequest(w, r, log, requestID, &req) {
+			return
+		}
+		if req.URI == "" {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "uri is required")
+			return
+		}
+
+		serveRemoteObject(w, r, cfg, log, deps, version, func(ctx context.Context) (*storage.Object, error) {
+			return deps.Storage.Open(ctx, req.URI)
+		})
+	}
+}
+
+// S3MetadataHandler extracts metadata from an S3 object named by bucket and
+// key, read with the server's credentials, or by a presigned URL
+func S3MetadataHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		provider, ok := deps.Storage["s3"]
+		if !ok {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "S3 ingestion is disabled")
+			return
+		}
+
+		var req s3Request
+		if !decodeRemoteRequest(w, r, log, requestID, &req) {
+			return
+		}
+
+		var open func(ctx context.Context) (*storage.Object, error)
+		switch {
+		case req.URL != "" && (req.Bucket != "" || req.Key != ""):
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Send either url, or bucket and key")
+			return
+		case req.URL != "":
+			u, err := url.Parse(req.URL)
+			if err != nil {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid url: "+err.Error())
+				return
+			}
+			open = func(ctx context.Context) (*storage.Object, error) { return provider.OpenURL(ctx, u) }
+		case req.Bucket != "" && req.Key != "":
+			open = func(ctx context.Context) (*storage.Object, error) { return provider.Open(ctx, req.Bucket, req.Key) }
+		default:
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "url, or bucket and key, are required")
+			return
+		}
+
+		serveRemoteObject(w, r, cfg, log, deps, version, open)
+	}
+}
+
+// decodeRemoteRequest reads a POSTed JSON body into v. It writes the
+// response and returns false if the request is unusable.
+func decodeRemoteRequest(w http.ResponseWriter, r *http.Request, log *logger.Logger, requestID string, v any) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+		return false
+	}
+	if !isJSONRequest(r) {
+		middleware.WriteError(w, http.StatusUnsupportedMediaType, models.CodeUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFormValueBytes)).Decode(v); err != nil {
+		log.Warnf("[%s] Invalid remote object request: %v", requestID, err)
+		middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// serveRemoteObject reads the object open returns and extracts its metadata
+func serveRemoteObject(w http.ResponseWriter, r *http.Request, cfg *config.Config, log *logger.Logger, deps Deps, version Version, open func(ctx context.Context) (*storage.Object, error)) {
+	requestID := middleware.GetRequestID(r.Context())
+
+	obj, err := open(r.Context())
+	if err != nil {
+		writeStorageError(w, log, requestID, err)
+		return
+	}
+	defer obj.Close()
+
+	file, header, err := bufferObject(cfg, deps, obj, maxUploadBytes(cfg, r))
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		log.Warnf("[%s] File too large", requestID)
+		middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "File too large")
+		return
+	case errors.Is(err, tempfiles.ErrQuotaExceeded):
+		log.Warnf("[%s] Temporary file quota full, rejecting remote object", requestID)
+		w.Header().Set("Retry-After", retryAfter(cfg.ExtractionTimeout))
+		middleware.WriteError(w, http.StatusServiceUnavailable, models.CodeServerBusy, "Server busy, retry later")
+		return
+	case err != nil:
+		writeStorageError(w, log, requestID, err)
+		return
+	}
+	defer file.Close()
+
+	serveExtraction(w, r, cfg, log, deps, version, file, header)
+}
+
+// ExtractObject reads an object from a storage provider and extracts its
+// metadata with the default options. Like an upload's, the result is stored
+// for lookups; it is returned in version's schema. The event worker
+// processes each new object with it.
+func ExtractObject(ctx context.Context, cfg *config.Config, log *logger.Logger, requestID string, deps Deps, version Version, provider storage.Provider, container, name string) (any, error) {
+	opts, err := ExtractionOptions(cfg, deps.Modules, url.Values{}.Get)
+	if err != nil {
+		return nil, err
+	}
+	opts.TempFiles = deps.TempFiles
+
+	obj, err := provider.Open(ctx, container, name)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	file, header, err := bufferObject(cfg, deps, obj, cfg.MaxFileSizeMB<<20)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	log.DebugContextf(ctx, "[%s] Processing object: %s (%d bytes)", requestID, log.Filename(name), header.Size)
+	result, err := extractFile(ctx, cfg, log, requestID, deps, opts, file, header)
+	if err != nil {
+		return nil, err
+	}
+	return version.serializeFor(ctx, result), nil
+}
+
+// bufferObject reads an opened object of up to maxBytes into memory, or a
+// temporary file when it is large, as a multipart upload would be
+func bufferObject(cfg *config.Config, deps Deps, obj *storage.Object, maxBytes int64) (multipart.File, *multipart.FileHeader, error) {
+	if obj.Size > maxBytes {
+		return nil, nil, errUploadTooLarge
+	}
+
+	contentType := obj.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := &multipart.FileHeader{
+		Filename: obj.Name,
+		Header:   textproto.MIMEHeader{"Content-Type": {contentType}},
+	}
+	file, err := bufferFile(obj, header, maxBytes, cfg.MultipartMemoryMB<<20, deps.TempFiles)
+	return file, header, err
+}
+
+// writeStorageError maps storage provider errors to responses
+func writeStorageError(w http.ResponseWriter, log *logger.Logger, requestID string, err error) {
+	if errors.Is(err, context.Canceled) {
+		log.Warnf("[%s] Client went away while reading remote object", requestID)
+		return
+	}
+	status, code, message := storageErrorResponse(err)
+	if status == http.StatusBadGateway {
+		log.Errorf("[%s] Failed to read remote object: %v", requestID, err)
+	} else {
+		log.Warnf("[%s] Rejected remote object: %v", requestID, err)
+	}
+	middleware.WriteError(w, status, code, message)
+}
+
+// storageErrorResponse is the status, error code and message for a storage
+// provider error
+func storageErrorResponse(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound, models.CodeNotFound, "Object not found"
+	case errors.Is(err, storage.ErrAccessDenied):
+		return http.StatusForbidden, models.CodeForbidden, "Access to the object was denied"
+	case errors.Is(err, storage.ErrBucketNotAllowed):
+		return http.StatusForbidden, models.CodeForbidden, "Reading from this bucket is not allowed"
+	case errors.Is(err, storage.ErrURLNotAllowed):
+		return http.StatusBadRequest, models.CodeBadRequest, "URL does not point at a configured storage provider"
+	case errors.Is(err, storage.ErrInvalidReference):
+		return http.StatusBadRequest, models.CodeBadRequest, "Invalid uri: " + err.Error()
+	case errors.Is(err, storage.ErrUnknownScheme):
+		return http.StatusBadRequest, models.CodeBadRequest, "Unsupported or disabled storage scheme"
+	case errors.Is(err, storage.ErrNoCredentials):
+		return http.StatusBadRequest, models.CodeBadRequest, "No credentials are configured for this storage, send a presigned URL"
+	default:
+		return http.StatusBadGateway, models.CodeUpstreamError, "Failed to read the object"
+	}
+}
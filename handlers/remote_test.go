@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/s3"
+	"file-meta/internal/sigv4"
+	"file-meta/internal/storage"
+)
+
+// remoteContent is the body of notes.txt in the fake bucket
+const remoteContent = "Hello, World!\nRead from S3.\n"
+
+// newFakeBucket serves files/notes.txt in ranges, a forbidden
+// files/secret.txt and nothing else
+func newFakeBucket() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/notes.txt":
+		case "/files/secret.txt":
+			w.WriteHeader(http.StatusForbidden)
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(remoteContent)-1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(remoteContent)))
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, remoteContent[start:end+1])
+	}))
+}
+
+// newRemoteDeps returns Deps with an S3 provider reading from bucket,
+// allowed to read only the named buckets if there are any
+func newRemoteDeps(t *testing.T, bucket *httptest.Server, allowed ...string) Deps {
+	t.Helper()
+	client, err := s3.NewClient("us-east-1", bucket.URL, sigv4.Credentials{AccessKeyID: "AK", SecretAccessKey: "secret"}, allowed, 16, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Deps{Storage: storage.Providers{"s3": client}}
+}
+
+// remoteTestConfig is the configuration the remote ingestion tests run with
+func remoteTestConfig() *config.Config {
+	return &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     1,
+		MultipartMemoryMB: 1,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+}
+
+// postRemote POSTs a JSON body to handler and checks the status, and for a
+// 200 that the result describes notes.txt
+func postRemote(t *testing.T, handler http.Handler, body string, wantStatus int) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata/remote", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != wantStatus {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, wantStatus, rr.Body)
+	}
+	if wantStatus != http.StatusOK {
+		return
+	}
+	var result map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result["filename"] != "notes.txt" || result["size_bytes"] != float64(len(remoteContent)) {
+		t.Errorf("result = %v, want notes.txt of %d bytes", result, len(remoteContent))
+	}
+}
+
+func TestRemoteMetadataHandler(t *testing.T) {
+	bucket := newFakeBucket()
+	defer bucket.Close()
+	handler := RemoteMetadataHandler(remoteTestConfig(), logger.New("info"), newRemoteDeps(t, bucket), Versions[0])
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"s3 reference", `{"uri": "s3://files/notes.txt"}`, http.StatusOK},
+		{"presigned URL", `{"uri": "` + bucket.URL + `/files/notes.txt?X-Amz-Signature=abc"}`, http.StatusOK},
+		{"missing object", `{"uri": "s3://files/gone.txt"}`, http.StatusNotFound},
+		{"disabled provider", `{"uri": "gs://files/notes.txt"}`, http.StatusBadRequest},
+		{"no object name", `{"uri": "s3://files"}`, http.StatusBadRequest},
+		{"URL outside storage", `{"uri": "http://169.254.169.254/latest/meta-data/"}`, http.StatusBadRequest},
+		{"nothing named", `{}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postRemote(t, handler, tt.body, tt.wantStatus)
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata/remote", strings.NewReader(`{"uri": "s3://b/k"}`))
+	req.Header.Set("Content-Type", "application/json")
+	RemoteMetadataHandler(remoteTestConfig(), logger.New("info"), Deps{}, Versions[0]).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status with no providers = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestS3MetadataHandler(t *testing.T) {
+	bucket := newFakeBucket()
+	defer bucket.Close()
+	handler := S3MetadataHandler(remoteTestConfig(), logger.New("info"), newRemoteDeps(t, bucket), Versions[0])
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"bucket and key", `{"bucket": "files", "key": "notes.txt"}`, http.StatusOK},
+		{"presigned URL", `{"url": "` + bucket.URL + `/files/notes.txt?X-Amz-Signature=abc"}`, http.StatusOK},
+		{"missing object", `{"bucket": "files", "key": "gone.txt"}`, http.StatusNotFound},
+		{"access denied", `{"bucket": "files", "key": "secret.txt"}`, http.StatusForbidden},
+		{"not an S3 URL", `{"url": "http://169.254.169.254/latest/meta-data/"}`, http.StatusBadRequest},
+		{"both forms", `{"url": "` + bucket.URL + `/files/notes.txt", "bucket": "files"}`, http.StatusBadRequest},
+		{"nothing named", `{}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postRemote(t, handler, tt.body, tt.wantStatus)
+		})
+	}
+}
+
+func TestS3MetadataHandlerAllowedBuckets(t *testing.T) {
+	bucket := newFakeBucket()
+	defer bucket.Close()
+	deps := newRemoteDeps(t, bucket, "files")
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		body       string
+		wantStatus int
+	}{
+		{"allowed bucket", S3MetadataHandler(remoteTestConfig(), logger.New("info"), deps, Versions[0]), `{"bucket": "files", "key": "notes.txt"}`, http.StatusOK},
+		{"other bucket", S3MetadataHandler(remoteTestConfig(), logger.New("info"), deps, Versions[0]), `{"bucket": "payroll", "key": "notes.txt"}`, http.StatusForbidden},
+		{"other bucket by reference", RemoteMetadataHandler(remoteTestConfig(), logger.New("info"), deps, Versions[0]), `{"uri": "s3://payroll/notes.txt"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postRemote(t, tt.handler, tt.body, tt.wantStatus)
+		})
+	}
+}
+
+func TestS3MetadataHandlerDisabled(t *testing.T) {
+	cfg := &config.Config{Port: "8080", MaxFileSizeMB: 1, RateLimitRequests: 10, RateLimitWindow: time.Minute, LogLevel: "info"}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metadata/s3", strings.NewReader(`{"bucket": "b", "key": "k"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	S3MetadataHandler(cfg, logger.New("info"), Deps{}, Versions[0]).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// Searches match perceptual hashes up to similarDefaultDistance bits
+// apart unless the distance parameter allows up to similarMaxDistance;
+// beyond that, unrelated images start to match
+const (
+	similarDefaultDistance = 10
+	similarMaxDistance     = 32
+)
+
+// SimilarResponse lists the images near a perceptual hash
+type SimilarResponse struct {
+	Matches []history.Match `json:"matches"`
+}
+
+// SimilarHandler finds images extracted with the calling API key whose
+// perceptual hash is within a Hamming distance of the phash parameter
+func SimilarHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.History == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Extraction history is disabled")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		format, err := negotiateFormat(r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		phash, err := metadata.ParsePerceptualHash(r.FormValue("phash"))
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "phash: "+err.Error())
+			return
+		}
+		distance := similarDefaultDistance
+		if value := r.FormValue("distance"); value != "" {
+			distance, err = strconv.Atoi(value)
+			if err != nil || distance < 0 || distance > similarMaxDistance {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "distance must be between 0 and "+strconv.Itoa(similarMaxDistance))
+				return
+			}
+		}
+		limit := historyDefaultLimit
+		if value := r.FormValue("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > historyMaxLimit {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "limit must be between 1 and "+strconv.Itoa(historyMaxLimit))
+				return
+			}
+		}
+
+		keyID := history.KeyID(middleware.GetAPIKey(r.Context()))
+		matches, err := deps.History.Similar(r.Context(), keyID, phash, distance, limit)
+		if err != nil {
+			log.Errorf("[%s] Failed to search perceptual hashes: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to search history")
+			return
+		}
+		if matches == nil {
+			matches = []history.Match{}
+		}
+
+		// Matches change as extractions are recorded
+		w.Header().Set("Cache-Control", "private, no-store")
+		if err := writeResponse(w, format, SimilarResponse{Matches: matches}); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/middleware"
+)
+
+func TestSimilarHandler(t *testing.T) {
+	cfg := &config.Config{
+		MaxFileSizeMB: 20,
+		APIKeys:       map[string]bool{"alice": true, "bob": true},
+	}
+	log := logger.New("info")
+
+	recorded, err := history.OpenFile(filepath.Join(t.TempDir(), "history.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorded.Close()
+	deps := Deps{History: recorded}
+
+	// Two encodings of the same picture are near-duplicates
+	img := image.NewRGBA(image.Rect(0, 0, 128, 96))
+	for y := range 96 {
+		for x := range 128 {
+			img.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), uint8(x ^ y), 255})
+		}
+	}
+	upload := func(img image.Image, compression png.CompressionLevel) string {
+		var encoded bytes.Buffer
+		(&png.Encoder{CompressionLevel: compression}).Encode(&encoded, img)
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "picture.png")
+		part.Write(encoded.Bytes())
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/metadata", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-API-Key", "alice")
+		rr := httptest.NewRecorder()
+		middleware.APIKeyAuth(cfg, log)(MetadataHandler(cfg, log, deps)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upload status = %d: %s", rr.Code, rr.Body)
+		}
+		var response struct {
+			Image struct {
+				PHash string `json:"phash"`
+			} `json:"image"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Image.PHash
+	}
+	phash := upload(img, png.BestSpeed)
+	if len(phash) != 16 {
+		t.Fatalf("phash = %q, want 16 hex digits", phash)
+	}
+	upload(img, png.BestCompression)
+
+	tests := []struct {
+		name         string
+		deps         Deps
+		key          string
+		query        string
+		expectedCode int
+		wantMatches  int
+	}{
+		{name: "same key", deps: deps, key: "alice", query: "?phash=" + phash, expectedCode: http.StatusOK, wantMatches: 2},
+		{name: "limit", deps: deps, key: "alice", query: "?phash=" + phash + "&limit=1", expectedCode: http.StatusOK, wantMatches: 1},
+		{name: "other key", deps: deps, key: "bob", query: "?phash=" + phash, expectedCode: http.StatusOK},
+		{name: "missing phash", deps: deps, key: "alice", expectedCode: http.StatusBadRequest},
+		{name: "short phash", deps: deps, key: "alice", query: "?phash=abc", expectedCode: http.StatusBadRequest},
+		{name: "distance too large", deps: deps, key: "alice", query: "?phash=" + phash + "&distance=33", expectedCode: http.StatusBadRequest},
+		{name: "history disabled", deps: Deps{}, key: "alice", query: "?phash=" + phash, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/similar"+tt.query, nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rr := httptest.NewRecorder()
+			middleware.APIKeyAuth(cfg, log)(SimilarHandler(log, tt.deps)).ServeHTTP(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.expectedCode, rr.Body)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var response SimilarResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Matches == nil || len(response.Matches) != tt.wantMatches {
+				t.Fatalf("got %+v, want %d matches", response.Matches, tt.wantMatches)
+			}
+			for _, match := range response.Matches {
+				if match.Distance != 0 || match.PHash != phash {
+					t.Errorf("match %+v, want distance 0 from %s", match, phash)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/uploads"
+	"file-meta/middleware"
+)
+
+// TusVersion is the tus resumable upload protocol version served by the
+// upload handlers
+const TusVersion = "1.0.0"
+
+// tusExtensions are the tus extensions the upload handlers support
+const tusExtensions = "creation,termination,expiration"
+
+// tusContentType is the required Content-Type of upload parts
+const tusContentType = "application/offset+octet-stream"
+
+// Methods of the upload creation endpoint and of an upload, for Allow
+const (
+	uploadsAllow = "OPTIONS, POST"
+	uploadAllow  = "OPTIONS, HEAD, PATCH, DELETE"
+)
+
+// UploadsHandler creates resumable uploads (tus creation). The response's
+// Location is the upload URL that parts are sent to.
+func UploadsHandler(cfg *config.Config, log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if !tusPreflight(w, r, deps, uploadsAllow) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", uploadsAllow)
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid Upload-Length")
+			return
+		}
+		meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid Upload-Metadata: "+err.Error())
+			return
+		}
+		if limit := maxUploadBytes(cfg, r); length > limit {
+			log.Warnf("[%s] Upload-Length %d over the key's limit of %d bytes", requestID, length, limit)
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(min(limit, deps.Uploads.MaxSize()), 10))
+			middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "Upload too large")
+			return
+		}
+
+		info, err := deps.Uploads.Create(length, meta["filename"], meta["filetype"], requestOwner(r))
+		if errors.Is(err, uploads.ErrTooLarge) {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(deps.Uploads.MaxSize(), 10))
+			middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "Upload too large")
+			return
+		}
+		if err != nil {
+			log.Errorf("[%s] Failed to create upload: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to create upload")
+			return
+		}
+
+		log.Infof("[%s] Created upload %s for %s (%d bytes)", requestID, info.ID, log.Filename(info.Filename), info.Length)
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+info.ID)
+		w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// UploadHandler serves one resumable upload: HEAD reports the offset to
+// resume from, PATCH appends a part and DELETE abandons the upload
+func UploadHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if !tusPreflight(w, r, deps, uploadAllow) {
+			return
+		}
+		id := r.PathValue("id")
+
+		switch r.Method {
+		case http.MethodHead:
+			info, err := getUpload(r, deps, id)
+			if err != nil {
+				writeUploadError(w, log, requestID, err)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+			w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+			w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusOK)
+
+		case http.MethodPatch:
+			if r.Header.Get("Content-Type") != tusContentType {
+				middleware.WriteError(w, http.StatusUnsupportedMediaType, models.CodeUnsupportedMediaType, "Content-Type must be "+tusContentType)
+				return
+			}
+			offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil || offset < 0 {
+				middleware.WriteError(w, http.StatusBadRequest, models.CodeBadRequest, "Invalid Upload-Offset")
+				return
+			}
+
+			if _, err := getUpload(r, deps, id); err != nil {
+				writeUploadError(w, log, requestID, err)
+				return
+			}
+			offset, err = deps.Uploads.Append(id, offset, r.Body)
+			if err != nil {
+				writeUploadError(w, log, requestID, err)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodDelete:
+			if _, err := getUpload(r, deps, id); err != nil {
+				writeUploadError(w, log, requestID, err)
+				return
+			}
+			if err := deps.Uploads.Delete(id); err != nil {
+				writeUploadError(w, log, requestID, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", uploadAllow)
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// UploadMetadataHandler extracts metadata from a completed resumable upload,
+// taking the same options as an upload to the metadata endpoint. The upload
+// is kept until it expires or is deleted, so it can be extracted again.
+func UploadMetadataHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		if deps.Uploads == nil {
+			middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Resumable uploads are disabled")
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		upload, err := getUpload(r, deps, r.PathValue("id"))
+		if err == nil && upload.Length > maxUploadBytes(cfg, r) {
+			err = errUploadTooLarge
+		}
+		if err != nil {
+			writeUploadError(w, log, requestID, err)
+			return
+		}
+		file, info, err := deps.Uploads.Open(r.PathValue("id"))
+		if err != nil {
+			writeUploadError(w, log, requestID, err)
+			return
+		}
+		defer file.Close()
+
+		serveExtraction(w, r, cfg, log, deps, version, file, uploadHeader(info))
+	}
+}
+
+// requestOwner is the owner of the uploads and jobs a request creates: the
+// history.KeyID of its API key
+func requestOwner(r *http.Request) string {
+	return history.KeyID(middleware.GetAPIKey(r.Context()))
+}
+
+// getUpload returns an upload owned by the request's API key. Other keys'
+// uploads are not found, as if their IDs didn't exist.
+func getUpload(r *http.Request, deps Deps, id string) (*uploads.Info, error) {
+	info, err := deps.Uploads.Get(id)
+	if err == nil && info.Owner != requestOwner(r) {
+		return nil, uploads.ErrNotFound
+	}
+	return info, err
+}
+
+// uploadHeader describes a completed upload like a multipart file part
+func uploadHeader(info *uploads.Info) *multipart.FileHeader {
+	fileType := info.FileType
+	if fileType == "" {
+		fileType = "application/octet-stream"
+	}
+	return &multipart.FileHeader{
+		Filename: info.Filename,
+		Size:     info.Length,
+		Header:   textproto.MIMEHeader{"Content-Type": {fileType}},
+	}
+}
+
+// UploadsOptions describes the upload creation endpoint to OPTIONS requests
+func UploadsOptions(deps Deps) http.Header {
+	return tusOptions(deps, uploadsAllow)
+}
+
+// UploadOptions describes an upload's endpoint to OPTIONS requests
+func UploadOptions(deps Deps) http.Header {
+	return tusOptions(deps, uploadAllow)
+}
+
+// tusOptions is the answer to OPTIONS requests that tus core specifies: the
+// protocol versions, the extensions and the largest upload
+// UPLOAD_MAX_SIZE_MB allows, along with the endpoint's methods in allow
+func tusOptions(deps Deps, allow string) http.Header {
+	header := http.Header{}
+	header.Set("Allow", allow)
+	if deps.Uploads == nil {
+		return header
+	}
+	header.Set("Tus-Resumable", TusVersion)
+	header.Set("Tus-Version", TusVersion)
+	header.Set("Tus-Extension", tusExtensions)
+	header.Set("Tus-Max-Size", strconv.FormatInt(deps.Uploads.MaxSize(), 10))
+	return header
+}
+
+// tusPreflight sets the protocol headers and rejects requests the upload
+// handlers can't serve, and answers OPTIONS requests, which need no
+// Tus-Resumable header. It reports whether the request should proceed.
+func tusPreflight(w http.ResponseWriter, r *http.Request, deps Deps, allow string) bool {
+	if deps.Uploads == nil {
+		middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Resumable uploads are disabled")
+		return false
+	}
+	if r.Method == http.MethodOptions {
+		for name, values := range tusOptions(deps, allow) {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	w.Header().Set("Tus-Resumable", TusVersion)
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		middleware.WriteError(w, http.StatusPreconditionFailed, models.CodePreconditionFailed, "Unsupported Tus-Resumable version")
+		return false
+	}
+	return true
+}
+
+// writeUploadError maps upload store errors to responses
+func writeUploadError(w http.ResponseWriter, log *logger.Logger, requestID string, err error) {
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		middleware.WriteError(w, http.StatusNotFound, models.CodeNotFound, "Upload not found")
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		middleware.WriteError(w, http.StatusConflict, models.CodeConflict, "Upload-Offset does not match the upload")
+	case errors.Is(err, uploads.ErrExceedsLength):
+		middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "Part exceeds Upload-Length")
+	case errors.Is(err, uploads.ErrIncomplete):
+		middleware.WriteError(w, http.StatusConflict, models.CodeConflict, "Upload incomplete")
+	case errors.Is(err, errUploadTooLarge):
+		middleware.WriteError(w, http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "Upload too large")
+	default:
+		log.Errorf("[%s] Upload failed: %v", requestID, err)
+		middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Upload failed")
+	}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// pairs of a key and an optional base64 value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("value of %q is not base64", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/uploads"
+	"file-meta/middleware"
+)
+
+func TestResumableUpload(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	store, err := uploads.NewStore(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := Deps{Uploads: store}
+
+	content := "Hello, World!\nThis file arrives in two parts.\n"
+	split := 14
+
+	// Create the upload
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads", nil)
+	req.Header.Set("Tus-Resumable", TusVersion)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("notes.txt"))+",filetype "+base64.StdEncoding.EncodeToString([]byte("text/plain")))
+	rr := httptest.NewRecorder()
+	UploadsHandler(cfg, log, deps).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	id := path.Base(location)
+	if !strings.HasPrefix(location, "/v1/uploads/") {
+		t.Fatalf("Location = %q, want an upload URL", location)
+	}
+
+	upload := func(method string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, location, strings.NewReader(body))
+		req.SetPathValue("id", id)
+		req.Header.Set("Tus-Resumable", TusVersion)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rr := httptest.NewRecorder()
+		UploadHandler(log, deps).ServeHTTP(rr, req)
+		return rr
+	}
+	part := func(offset string) map[string]string {
+		return map[string]string{"Content-Type": tusContentType, "Upload-Offset": offset}
+	}
+	extract := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, location+"/metadata", nil)
+		req.SetPathValue("id", id)
+		rr := httptest.NewRecorder()
+		UploadMetadataHandler(cfg, log, deps, Versions[0]).ServeHTTP(rr, req)
+		return rr
+	}
+
+	steps := []struct {
+		name         string
+		do           func() *httptest.ResponseRecorder
+		expectedCode int
+		offset       string
+	}{
+		{"initial offset", func() *httptest.ResponseRecorder { return upload(http.MethodHead, nil, "") }, http.StatusOK, "0"},
+		{"first part", func() *httptest.ResponseRecorder { return upload(http.MethodPatch, part("0"), content[:split]) }, http.StatusNoContent, "14"},
+		{"stale offset", func() *httptest.ResponseRecorder { return upload(http.MethodPatch, part("0"), content[:split]) }, http.StatusConflict, ""},
+		{"resume offset", func() *httptest.ResponseRecorder { return upload(http.MethodHead, nil, "") }, http.StatusOK, "14"},
+		{"wrong content type", func() *httptest.ResponseRecorder {
+			return upload(http.MethodPatch, map[string]string{"Content-Type": "text/plain", "Upload-Offset": "14"}, content[split:])
+		}, http.StatusUnsupportedMediaType, ""},
+		{"missing Tus-Resumable", func() *httptest.ResponseRecorder {
+			return upload(http.MethodHead, map[string]string{"Tus-Resumable": ""}, "")
+		}, http.StatusPreconditionFailed, ""},
+		{"extract incomplete", extract, http.StatusConflict, ""},
+		{"too long part", func() *httptest.ResponseRecorder {
+			return upload(http.MethodPatch, part("14"), content[split:]+"extra")
+		}, http.StatusRequestEntityTooLarge, ""},
+		{"last part", func() *httptest.ResponseRecorder { return upload(http.MethodPatch, part("14"), content[split:]) }, http.StatusNoContent, strconv.Itoa(len(content))},
+		{"extract", extract, http.StatusOK, ""},
+		{"delete", func() *httptest.ResponseRecorder { return upload(http.MethodDelete, nil, "") }, http.StatusNoContent, ""},
+		{"deleted", func() *httptest.ResponseRecorder { return upload(http.MethodHead, nil, "") }, http.StatusNotFound, ""},
+	}
+
+	for _, step := range steps {
+		rr := step.do()
+		if rr.Code != step.expectedCode {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rr.Code, step.expectedCode, rr.Body.String())
+		}
+		if step.offset != "" && rr.Header().Get("Upload-Offset") != step.offset {
+			t.Errorf("%s: Upload-Offset = %q, want %q", step.name, rr.Header().Get("Upload-Offset"), step.offset)
+		}
+
+		if step.name == "extract" {
+			var response map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response["filename"] != "notes.txt" || response["size_bytes"] != float64(len(content)) {
+				t.Errorf("response = %v, want notes.txt with %d bytes", response, len(content))
+			}
+		}
+	}
+}
+
+func TestResumableUploadDisabled(t *testing.T) {
+	log := logger.New("info")
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads", nil)
+	req.Header.Set("Tus-Resumable", TusVersion)
+	req.Header.Set("Upload-Length", "10")
+	rr := httptest.NewRecorder()
+	UploadsHandler(&config.Config{}, log, Deps{}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestResumableUploadOptions(t *testing.T) {
+	store, err := uploads.NewStore(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := Deps{Uploads: store}
+	log := logger.New("info")
+
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		wantAllow string
+	}{
+		{"creation", UploadsHandler(&config.Config{}, log, deps), "OPTIONS, POST"},
+		{"upload", UploadHandler(log, deps), "OPTIONS, HEAD, PATCH, DELETE"},
+		{"creation behind CORS", AnswerOptions(UploadsOptions(deps), middleware.CORS(UploadsHandler(&config.Config{}, log, deps))), "OPTIONS, POST"},
+		{"upload behind CORS", AnswerOptions(UploadOptions(deps), middleware.CORS(UploadHandler(log, deps))), "OPTIONS, HEAD, PATCH, DELETE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No Tus-Resumable, which tus core doesn't require of OPTIONS
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, httptest.NewRequest(http.MethodOptions, "/v1/uploads", nil))
+
+			if rr.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusNoContent)
+			}
+			want := map[string]string{
+				"Allow":         tt.wantAllow,
+				"Tus-Version":   TusVersion,
+				"Tus-Extension": "creation,termination,expiration",
+				"Tus-Max-Size":  strconv.Itoa(1 << 20),
+			}
+			for name, value := range want {
+				if got := rr.Header().Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestResumableUploadKeyLimit(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:       map[string]bool{"free_key": true, "paid_key": true},
+		MaxFileSizeMB: 20,
+		Tiers:         map[string]config.Tier{"free": {Name: "free", MaxFileSizeMB: 1}},
+		KeyTiers:      map[string]string{"free_key": "free"},
+	}
+	log := logger.New("info")
+	log.SetOutput(&strings.Builder{})
+	auth := middleware.APIKeyAuth(cfg, log)
+
+	store, err := uploads.NewStore(t.TempDir(), 100<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := Deps{Uploads: store}
+
+	tests := []struct {
+		name       string
+		key        string
+		length     int
+		wantStatus int
+	}{
+		{"within the tier", "free_key", 1 << 20, http.StatusCreated},
+		{"over the tier", "free_key", 2 << 20, http.StatusRequestEntityTooLarge},
+		{"within MAX_FILE_SIZE_MB", "paid_key", 2 << 20, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/uploads", nil)
+			req.Header.Set("X-API-Key", tt.key)
+			req.Header.Set("Tus-Resumable", TusVersion)
+			req.Header.Set("Upload-Length", strconv.Itoa(tt.length))
+			rr := httptest.NewRecorder()
+			auth(UploadsHandler(cfg, log, deps)).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantStatus == http.StatusRequestEntityTooLarge && rr.Header().Get("Tus-Max-Size") != strconv.Itoa(1<<20) {
+				t.Errorf("Tus-Max-Size = %q, want the tier's %d", rr.Header().Get("Tus-Max-Size"), 1<<20)
+			}
+		})
+	}
+
+	// An upload created before the key's limit was lowered isn't extracted
+	info, err := store.Create(2<<20, "large.bin", "", history.KeyID("free_key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(info.ID, 0, strings.NewReader(strings.Repeat("a", 2<<20))); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/uploads/"+info.ID+"/metadata", nil)
+	req.SetPathValue("id", info.ID)
+	req.Header.Set("X-API-Key", "free_key")
+	rr := httptest.NewRecorder()
+	auth(UploadMetadataHandler(cfg, log, deps, Versions[0])).ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("extract status = %d, want %d", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
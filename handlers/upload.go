@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+)
+
+// errUploadTooLarge is returned when a decoded upload exceeds the size limit
+var errUploadTooLarge = errors.New("file too large")
+
+// jsonUploadOverhead allows for the JSON around the base64 content
+const jsonUploadOverhead = 64 << 10
+
+// jsonUpload is the body of a JSON upload, for clients that can't send
+// multipart requests
+type jsonUpload struct {
+	Filename      string `json:"filename"`
+	ContentBase64 string `json:"content_base64"`
+}
+
+// isJSONRequest reports whether the request body is JSON
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// readJSONUpload decodes a JSON upload. The body may be as large as the
+// base64 encoding of maxBytes, and the decoded file no larger than maxBytes.
+func readJSONUpload(w http.ResponseWriter, r *http.Request, maxBytes int64) (multipart.File, *multipart.FileHeader, error) {
+	limit := int64(base64.StdEncoding.EncodedLen(int(maxBytes))) + jsonUploadOverhead
+	if r.ContentLength > limit {
+		return nil, nil, errUploadTooLarge
+	}
+
+	var upload jsonUpload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(&upload); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, nil, errUploadTooLarge
+		}
+		return nil, nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	if upload.Filename == "" {
+		return nil, nil, errors.New("filename is required")
+	}
+	if upload.ContentBase64 == "" {
+		return nil, nil, errors.New("content_base64 is required")
+	}
+
+	content, err := base64.StdEncoding.DecodeString(upload.ContentBase64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid content_base64: %w", err)
+	}
+	if int64(len(content)) > maxBytes {
+		return nil, nil, errUploadTooLarge
+	}
+
+	header := &multipart.FileHeader{
+		Filename: upload.Filename,
+		Size:     int64(len(content)),
+		Header:   textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}},
+	}
+	return memoryFile{bytes.NewReader(content)}, header, nil
+}
+
+// memoryFile serves decoded content as a multipart.File
+type memoryFile struct {
+	*bytes.Reader
+}
+
+// Close implements io.Closer
+func (memoryFile) Close() error {
+	return nil
+}
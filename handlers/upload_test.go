@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+func TestMetadataHandlerJSONUpload(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     1,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	content := "Hello, World!\n"
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	digest := sha256.Sum256([]byte(content))
+	oversized := base64.StdEncoding.EncodeToString(make([]byte, 1<<20+1))
+
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "valid upload",
+			contentType:  "application/json",
+			body:         `{"filename":"hello.txt","content_base64":"` + encoded + `"}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "content type with charset",
+			contentType:  "application/json; charset=utf-8",
+			body:         `{"filename":"hello.txt","content_base64":"` + encoded + `"}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "missing filename",
+			contentType:  "application/json",
+			body:         `{"content_base64":"` + encoded + `"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing content",
+			contentType:  "application/json",
+			body:         `{"filename":"hello.txt"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid base64",
+			contentType:  "application/json",
+			body:         `{"filename":"hello.txt","content_base64":"not base64!"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid JSON",
+			contentType:  "application/json",
+			body:         `{"filename":`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "decoded file too large",
+			contentType:  "application/json",
+			body:         `{"filename":"big.bin","content_base64":"` + oversized + `"}`,
+			expectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/metadata?include=document", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+			MetadataHandler(cfg, log, Deps{}).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.expectedCode, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var response map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response["filename"] != "hello.txt" || response["size_bytes"] != float64(len(content)) {
+				t.Errorf("response = %v, want hello.txt with %d bytes", response, len(content))
+			}
+			if response["checksum_sha256"] != hex.EncodeToString(digest[:]) {
+				t.Errorf("checksum_sha256 = %v, want the decoded content's hash", response["checksum_sha256"])
+			}
+			// Query options apply to JSON uploads too
+			if response["document"] == nil {
+				t.Errorf("response = %v, want document metadata", response)
+			}
+		})
+	}
+}
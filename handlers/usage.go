@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/usage"
+	"file-meta/middleware"
+)
+
+// UsageReport is every API key's usage in one month
+type UsageReport struct {
+	Month string        `json:"month"`
+	Keys  []usage.Usage `json:"keys"`
+}
+
+// UsageHandler returns the calling API key's usage in the month parameter
+// (YYYY-MM), the current month by default
+func UsageHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		month, ok := usageMonth(w, r, deps)
+		if !ok {
+			return
+		}
+		format, err := negotiateFormat(r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		keyID := history.KeyID(middleware.GetAPIKey(r.Context()))
+		used, err := deps.Usage.Get(r.Context(), keyID, month)
+		if err != nil {
+			log.Errorf("[%s] Failed to read usage: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to read usage")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, no-store")
+		if err := writeResponse(w, format, used); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
+
+// AdminUsageHandler returns every API key's usage in the month parameter,
+// for admin keys
+func AdminUsageHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		month, ok := usageMonth(w, r, deps)
+		if !ok {
+			return
+		}
+		format, err := negotiateFormat(r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+
+		all, err := deps.Usage.All(r.Context(), month)
+		if err != nil {
+			log.Errorf("[%s] Failed to read usage: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to read usage")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, no-store")
+		if err := writeResponse(w, format, UsageReport{Month: month, Keys: all}); err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
+
+// usageCSVHeader names the columns of a CSV usage export. Each key has a
+// "total" row, a row per endpoint, country and autonomous system with its
+// requests, and a row per MIME type with its files and bytes.
+var usageCSVHeader = []string{"month", "api_key_id", "dimension", "name", "requests", "files", "bytes"}
+
+// UsageExportHandler exports every API key's usage in the month parameter
+// for billing systems, as JSON or, with format=csv or Accept: text/csv, as
+// CSV
+func UsageExportHandler(log *logger.Logger, deps Deps) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+		month, ok := usageMonth(w, r, deps)
+		if !ok {
+			return
+		}
+
+		format := strings.ToLower(strings.TrimSpace(r.FormValue("format")))
+		if format == "" {
+			format = FormatJSON
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Accept")); mediaType == "text/csv" {
+				format = "csv"
+			}
+		}
+		if format != FormatJSON && format != "csv" {
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "format must be csv or json")
+			return
+		}
+
+		all, err := deps.Usage.All(r.Context(), month)
+		if err != nil {
+			log.Errorf("[%s] Failed to read usage: %v", requestID, err)
+			middleware.WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Failed to read usage")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.%s"`, month, format))
+		if format == FormatJSON {
+			err = writeResponse(w, format, UsageReport{Month: month, Keys: all})
+		} else {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = writeUsageCSV(w, all)
+		}
+		if err != nil {
+			log.Errorf("[%s] Failed to encode response: %v", requestID, err)
+		}
+	}
+}
+
+// writeUsageCSV writes usage as rows under usageCSVHeader, endpoints,
+// origins and types in name order
+func writeUsageCSV(w io.Writer, all []usage.Usage) error {
+	out := csv.NewWriter(w)
+	out.Write(usageCSVHeader)
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+	for _, u := range all {
+		out.Write([]string{u.Month, u.APIKeyID, "total", "", count(u.Requests), count(u.Files), count(u.Bytes)})
+		for _, endpoint := range slices.Sorted(maps.Keys(u.Endpoints)) {
+			out.Write([]string{u.Month, u.APIKeyID, "endpoint", endpoint, count(u.Endpoints[endpoint]), "", ""})
+		}
+		for _, country := range slices.Sorted(maps.Keys(u.Countries)) {
+			out.Write([]string{u.Month, u.APIKeyID, "country", country, count(u.Countries[country]), "", ""})
+		}
+		for _, as := range slices.Sorted(maps.Keys(u.ASNs)) {
+			out.Write([]string{u.Month, u.APIKeyID, "asn", as, count(u.ASNs[as]), "", ""})
+		}
+		for _, mimeType := range slices.Sorted(maps.Keys(u.Types)) {
+			byType := u.Types[mimeType]
+			out.Write([]string{u.Month, u.APIKeyID, "type", mimeType, "", count(byType.Files), count(byType.Bytes)})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// usageMonth checks a usage request and reads its month, answering it
+// with an error if it is invalid
+func usageMonth(w http.ResponseWriter, r *http.Request, deps Deps) (string, bool) {
+	if deps.Usage == nil {
+		middleware.WriteError(w, http.StatusNotFound, models.CodeFeatureDisabled, "Usage tracking is disabled")
+		return "", false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+		return "", false
+	}
+
+	month := r.FormValue("month")
+	if month == "" {
+		return usage.Month(time.Now()), true
+	}
+	if _, err := time.Parse(usage.MonthLayout, month); err != nil {
+		middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "month must be formatted as YYYY-MM")
+		return "", false
+	}
+	return month, true
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/usage"
+	"file-meta/middleware"
+)
+
+func TestUsageHandlers(t *testing.T) {
+	cfg := &config.Config{
+		MaxFileSizeMB: 20,
+		APIKeys:       map[string]bool{"alice": true, "bob": true, "operator": true},
+		AdminAPIKeys:  map[string]bool{"operator": true},
+	}
+	log := logger.New("info")
+	tracker := usage.NewMemoryTracker()
+	deps := Deps{Usage: tracker}
+
+	serve := func(h http.Handler, method, target, key string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("X-API-Key", key)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		middleware.APIKeyAuth(cfg, log)(middleware.CountRequests(tracker, log)(h)).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Alice uploads two text files
+	for _, name := range []string{"a.txt", "b.txt"} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", name)
+		io.WriteString(part, "Hello, World!\n")
+		writer.Close()
+		if rr := serve(MetadataHandler(cfg, log, deps), http.MethodPost, "/v1/metadata", "alice", body, writer.FormDataContentType()); rr.Code != http.StatusOK {
+			t.Fatalf("upload status = %d: %s", rr.Code, rr.Body)
+		}
+	}
+
+	rr := serve(UsageHandler(log, deps), http.MethodGet, "/v1/usage", "alice", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("usage status = %d: %s", rr.Code, rr.Body)
+	}
+	var used usage.Usage
+	if err := json.NewDecoder(rr.Body).Decode(&used); err != nil {
+		t.Fatal(err)
+	}
+	if used.APIKeyID != history.KeyID("alice") || used.Requests != 3 || used.Files != 2 || used.Bytes != 28 || len(used.Types) != 1 {
+		t.Errorf("usage = %+v, want 3 requests and 2 files of one type and 14 bytes", used)
+	}
+
+	rr = serve(AdminUsageHandler(log, deps), http.MethodGet, "/admin/usage", "operator", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("admin usage status = %d: %s", rr.Code, rr.Body)
+	}
+	var report UsageReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Month != used.Month || len(report.Keys) != 2 {
+		t.Errorf("report = %+v, want alice and the operator in %s", report, used.Month)
+	}
+
+	// The billing export has the same usage as rows, plus a request from a
+	// known origin
+	tracker.Request(context.Background(), history.KeyID("alice"), "/v1/usage", usage.Origin{Country: "NL", AS: "AS1136"})
+	rr = serve(UsageExportHandler(log, deps), http.MethodGet, "/admin/usage/export?format=csv", "operator", nil, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("export status = %d: %s", rr.Code, rr.Body)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+		t.Errorf("export Content-Type = %q", contentType)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceID := history.KeyID("alice")
+	wantRows := map[string][]string{
+		"total":    {used.Month, aliceID, "total", "", "4", "2", "28"},
+		"endpoint": {used.Month, aliceID, "endpoint", "/v1/metadata", "2", "", ""},
+		"country":  {used.Month, aliceID, "country", "NL", "1", "", ""},
+		"asn":      {used.Month, aliceID, "asn", "AS1136", "1", "", ""},
+	}
+	for _, record := range records[1:] {
+		if want, ok := wantRows[record[2]]; ok && record[1] == aliceID && record[3] == want[3] {
+			if fmt.Sprint(record) != fmt.Sprint(want) {
+				t.Errorf("export row %v, want %v", record, want)
+			}
+			delete(wantRows, record[2])
+		}
+	}
+	if len(wantRows) > 0 {
+		t.Errorf("export is missing rows %v:\n%v", wantRows, records)
+	}
+	if rr := serve(UsageExportHandler(log, deps), http.MethodGet, "/admin/usage/export?format=xml", "operator", nil, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("export as XML status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	tests := []struct {
+		name         string
+		deps         Deps
+		query        string
+		expectedCode int
+		wantRequests int64
+	}{
+		{name: "other key", deps: deps, expectedCode: http.StatusOK, wantRequests: 1},
+		{name: "past month", deps: deps, query: "?month=2020-01", expectedCode: http.StatusOK},
+		{name: "invalid month", deps: deps, query: "?month=2020-13", expectedCode: http.StatusBadRequest},
+		{name: "tracking disabled", deps: Deps{}, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(UsageHandler(log, tt.deps), http.MethodGet, "/v1/usage"+tt.query, "bob", nil, "")
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.expectedCode)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var used usage.Usage
+			if err := json.NewDecoder(rr.Body).Decode(&used); err != nil {
+				t.Fatal(err)
+			}
+			if used.Requests != tt.wantRequests || used.Files != 0 {
+				t.Errorf("usage = %+v, want %d requests", used, tt.wantRequests)
+			}
+		})
+	}
+}
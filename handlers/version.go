@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+	"file-meta/middleware"
+)
+
+// VersionHandler reports the build in info and what it serves, for support
+// to tell which release and settings a customer's report came from. The
+// extraction modules, profiles and API versions are filled in.
+func VersionHandler(cfg *config.Config, log *logger.Logger, info models.VersionInfo) http.HandlerFunc {
+	info.Modules = metadata.Modules
+	info.Profiles = slices.Sorted(maps.Keys(cfg.Profiles))
+	info.APIVersions = nil
+	for _, v := range Versions {
+		info.APIVersions = append(info.APIVersions, v.Name)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			middleware.WriteError(w, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			log.Errorf("Failed to encode version response: %v", err)
+		}
+	}
+}
+
+// Features lists the optional features cfg and deps enable, plus extra ones
+// only the caller knows of, in name order
+func Features(cfg *config.Config, deps Deps, extra ...string) []string {
+	features := append([]string{}, extra...)
+	add := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	add("clamav", deps.Scanner != nil)
+	add("known_files", deps.KnownFiles != nil)
+	add("ai_classifier", deps.AIClassifier != nil)
+	add("result_cache", deps.Results != nil)
+	add("resumable_uploads", deps.Uploads != nil)
+	add("jobs", deps.Jobs != nil)
+	add("sandbox", deps.Sandbox != nil)
+	add("history", deps.History != nil)
+	add("usage", deps.Usage != nil)
+	add("key_overrides", deps.Overrides != nil)
+	add("file_policy", deps.FilePolicy != nil)
+	for _, scheme := range slices.Sorted(maps.Keys(deps.Storage)) {
+		add("storage:"+scheme, true)
+	}
+	add("kafka", len(cfg.KafkaBrokers) > 0)
+	add("nats", cfg.NATSURL != "")
+	add("webhook", cfg.WebhookURL != "")
+	add("oauth", len(cfg.OAuthClients) > 0)
+	add("tls", cfg.TLSEnabled())
+	add("autocert", len(cfg.TLSAutocertDomains) > 0)
+	add("client_certificates", cfg.TLSClientCAFile != "")
+	add("request_decompression", cfg.RequestDecompressionMaxRatio > 0)
+	add("privacy_mode", cfg.PrivacyMode)
+	add("docs_ui", cfg.DocsUI)
+	slices.Sort(features)
+	return features
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/storage"
+	"file-meta/internal/usage"
+)
+
+func TestVersionHandler(t *testing.T) {
+	cfg := &config.Config{Profiles: map[string][]string{"fast": nil, "forensic": nil}}
+	handler := VersionHandler(cfg, logger.New("error"), models.VersionInfo{Version: "v1.2.3", Commit: "abc123", GoVersion: "go1.23.0", Features: []string{"redis"}})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var got models.VersionInfo
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "v1.2.3" || got.Commit != "abc123" || got.GoVersion != "go1.23.0" || !slices.Equal(got.Features, []string{"redis"}) {
+		t.Errorf("response = %+v, want the build passed in", got)
+	}
+	if !slices.Equal(got.Profiles, []string{"fast", "forensic"}) || !slices.Equal(got.APIVersions, []string{"v1", "v2"}) || len(got.Modules) == 0 {
+		t.Errorf("response = %+v, want the profiles, API versions and modules served", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/version", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rr.Code)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	cfg := &config.Config{WebhookURL: "https://example.com/hook", PrivacyMode: true}
+	deps := Deps{Usage: usage.NewMemoryTracker(), Storage: storage.Providers{"s3": nil, "gs": nil}}
+
+	got := Features(cfg, deps, "redis")
+	want := []string{"privacy_mode", "redis", "storage:gs", "storage:s3", "usage", "webhook"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Features() = %v, want %v", got, want)
+	}
+	if got := Features(&config.Config{}, Deps{}); len(got) != 0 {
+		t.Errorf("Features() = %v with nothing enabled, want none", got)
+	}
+}
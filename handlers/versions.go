@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+
+	"file-meta/internal/metadata"
+	"file-meta/middleware"
+)
+
+// Version is one version of the public API. Each version owns its response
+// schema so the extractor can evolve without breaking existing clients.
+type Version struct {
+	// Name is the path prefix, e.g. "v1"
+	Name string
+
+	// Serialize converts an extraction result to this version's response
+	Serialize func(*metadata.Result) any
+
+	// Response is a zero value of the response type, for the OpenAPI schema
+	Response any
+}
+
+// Versions lists the served API versions, oldest first
+var Versions = []Version{
+	{Name: "v1", Serialize: serializeV1, Response: metadata.Result{}},
+	{Name: "v2", Serialize: serializeV2, Response: ResultV2{}},
+}
+
+// VersionByName returns the served version called name
+func VersionByName(name string) (Version, bool) {
+	for _, v := range Versions {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+// serializeFor serializes result for the request with ctx, leaving out
+// personal data when middleware.WithholdPersonalData says so
+func (v Version) serializeFor(ctx context.Context, result *metadata.Result) any {
+	if middleware.WithholdPersonalData(ctx) {
+		result = result.WithoutPersonalData()
+	}
+	return v.Serialize(result)
+}
+
+// serializeV1 returns the result unchanged. v1 is frozen: a change to
+// metadata.Result that would alter the v1 schema must be mapped back here.
+func serializeV1(result *metadata.Result) any {
+	return result
+}
+
+// ResultV2 is the /v2 response. Checksums are grouped, and detections move
+// out of the type-specific sections into one block.
+type ResultV2 struct {
+	Filename    string                     `json:"filename"`
+	SizeBytes   int64                      `json:"size_bytes"`
+	MimeType    string                     `json:"mime_type"`
+	Extension   string                     `json:"extension,omitempty"`
+	Checksums   ChecksumsV2                `json:"checksums"`
+	Image       *metadata.ImageMetadata    `json:"image,omitempty"`
+	Audio       *metadata.AudioMetadata    `json:"audio,omitempty"`
+	Video       *metadata.VideoMetadata    `json:"video,omitempty"`
+	Document    *metadata.DocumentMetadata `json:"document,omitempty"`
+	Detections  *DetectionsV2              `json:"detections,omitempty"`
+	Security    *metadata.SecurityMetadata `json:"security,omitempty"`
+	Warnings    []metadata.Warning         `json:"warnings,omitempty"`
+	DuplicateOf *metadata.Duplicate        `json:"duplicate_of,omitempty"`
+}
+
+// ChecksumsV2 holds every computed digest
+type ChecksumsV2 struct {
+	SHA256 string `json:"sha256"`
+	SSDeep string `json:"ssdeep,omitempty"`
+	TLSH   string `json:"tlsh,omitempty"`
+}
+
+// DetectionsV2 holds content detections for any file type
+type DetectionsV2 struct {
+	AIGenerated *metadata.AIDetection         `json:"ai_generated,omitempty"`
+	Screenshot  *metadata.ScreenshotDetection `json:"screenshot,omitempty"`
+}
+
+// serializeV2 maps a result to the v2 schema without modifying it
+func serializeV2(result *metadata.Result) any {
+	v2 := ResultV2{
+		Filename:  result.Filename,
+		SizeBytes: result.SizeBytes,
+		MimeType:  result.MimeType,
+		Extension: result.Extension,
+		Checksums: ChecksumsV2{
+			SHA256: result.SHA256,
+			SSDeep: result.SSDeep,
+			TLSH:   result.TLSH,
+		},
+		Audio:       result.Audio,
+		Video:       result.Video,
+		Security:    result.Security,
+		Warnings:    result.Warnings,
+		DuplicateOf: result.DuplicateOf,
+	}
+
+	detections := &DetectionsV2{}
+	if result.Image != nil {
+		image := *result.Image
+		detections.AIGenerated = image.AIDetection
+		detections.Screenshot = image.ScreenshotDetection
+		image.AIDetection, image.ScreenshotDetection = nil, nil
+		v2.Image = &image
+	}
+	if result.Document != nil {
+		document := *result.Document
+		detections.AIGenerated = document.AIDetection
+		document.AIDetection = nil
+		v2.Document = &document
+	}
+	if detections.AIGenerated != nil || detections.Screenshot != nil {
+		v2.Detections = detections
+	}
+
+	return v2
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+)
+
+func TestSerializeV2(t *testing.T) {
+	ai := &metadata.AIDetection{Probability: 0.9}
+	screenshot := &metadata.ScreenshotDetection{Probability: 0.1}
+	result := &metadata.Result{
+		Filename: "render.png",
+		SHA256:   "abc",
+		SSDeep:   "3:abc:def",
+		Image:    &metadata.ImageMetadata{Width: 1024, AIDetection: ai, ScreenshotDetection: screenshot},
+	}
+
+	v2 := serializeV2(result).(ResultV2)
+
+	if v2.Checksums.SHA256 != "abc" || v2.Checksums.SSDeep != "3:abc:def" {
+		t.Errorf("Checksums = %+v, want sha256 and ssdeep", v2.Checksums)
+	}
+
+	if v2.Detections == nil || v2.Detections.AIGenerated != ai || v2.Detections.Screenshot != screenshot {
+		t.Errorf("Detections = %+v, want the image detections", v2.Detections)
+	}
+
+	if v2.Image == nil || v2.Image.Width != 1024 || v2.Image.AIDetection != nil || v2.Image.ScreenshotDetection != nil {
+		t.Errorf("Image = %+v, want metadata without detections", v2.Image)
+	}
+
+	// The extraction result is shared with other serializers
+	if result.Image.AIDetection != ai || result.Image.ScreenshotDetection != screenshot {
+		t.Error("serializeV2() modified the result")
+	}
+
+	if v2 := serializeV2(&metadata.Result{SHA256: "abc"}).(ResultV2); v2.Detections != nil {
+		t.Errorf("Detections = %+v, want nil without detections", v2.Detections)
+	}
+}
+
+func TestVersionedMetadataHandler(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     20,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. 0123456789\n", 20)
+
+	tests := []struct {
+		version      Version
+		expected     []string
+		notExpected  []string
+		fieldsQuery  string
+		expectedBody string
+	}{
+		{
+			version:      Versions[0],
+			expected:     []string{`"checksum_sha256":`, `"ai_detection":`},
+			notExpected:  []string{`"checksums":`, `"detections":`},
+			fieldsQuery:  "?fields=size_bytes,document.word_count",
+			expectedBody: `{"document":{"word_count":200},"size_bytes":1120}`,
+		},
+		{
+			version:      Versions[1],
+			expected:     []string{`"checksums":{"sha256":`, `"detections":{"ai_generated":`},
+			notExpected:  []string{`"checksum_sha256":`, `"ai_detection":`},
+			fieldsQuery:  "?fields=size_bytes,checksum_sha256,document.word_count",
+			expectedBody: `{"document":{"word_count":200},"size_bytes":1120}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version.Name, func(t *testing.T) {
+			for _, query := range []string{"", tt.fieldsQuery} {
+				body := &bytes.Buffer{}
+				writer := multipart.NewWriter(body)
+				part, err := writer.CreateFormFile("file", "test.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.WriteString(part, content)
+				writer.Close()
+
+				req := httptest.NewRequest(http.MethodPost, "/"+tt.version.Name+"/metadata"+query, body)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+
+				rr := httptest.NewRecorder()
+				VersionedMetadataHandler(cfg, log, Deps{}, tt.version).ServeHTTP(rr, req)
+
+				if rr.Code != http.StatusOK {
+					t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+				}
+
+				got := strings.TrimSpace(rr.Body.String())
+				if query != "" {
+					if got != tt.expectedBody {
+						t.Errorf("body = %s, want %s", got, tt.expectedBody)
+					}
+					continue
+				}
+
+				if !json.Valid([]byte(got)) {
+					t.Fatalf("body is not JSON: %s", got)
+				}
+				for _, want := range tt.expected {
+					if !strings.Contains(got, want) {
+						t.Errorf("body missing %s: %s", want, got)
+					}
+				}
+				for _, unwanted := range tt.notExpected {
+					if strings.Contains(got, unwanted) {
+						t.Errorf("body should not contain %s: %s", unwanted, got)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/filepolicy"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+	"file-meta/internal/tempfiles"
+	"file-meta/internal/websocket"
+	"file-meta/internal/workpool"
+	"file-meta/middleware"
+)
+
+// wsIdleTimeout bounds how long the WebSocket channel waits for the client's
+// next message while the file arrives
+var wsIdleTimeout = time.Minute
+
+// wsStart is the client's first message: the file that follows in binary
+// messages
+type wsStart struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// wsMessage is a server message on the WebSocket channel. Type is progress,
+// stage, result or error.
+type wsMessage struct {
+	Type     string `json:"type"`
+	Received int64  `json:"received,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Stage    string `json:"stage,omitempty"`
+	Result   any    `json:"result,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Code     string `json:"code,omitempty"` // one of the models.Code values
+}
+
+// errUnexpectedMessage is returned when the client sends a message out of
+// turn
+var errUnexpectedMessage = errors.New("unexpected message")
+
+// WebSocketHandler extracts metadata from a file sent over a WebSocket, for
+// browsers behind proxies that mangle multipart uploads. The client sends a
+// JSON text message with the filename and size, then the content in binary
+// messages. The server answers each binary message with progress, reports
+// extraction stages, and finally sends the result and closes the
+// connection. Options are query parameters, as for the metadata endpoint.
+func WebSocketHandler(cfg *config.Config, log *logger.Logger, deps Deps, version Version) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetRequestID(r.Context())
+
+		opts, err := parseOptions(cfg, deps.Modules, r)
+		if err != nil {
+			log.Warnf("[%s] Invalid options: %v", requestID, err)
+			middleware.WriteError(w, http.StatusBadRequest, models.CodeInvalidOptions, "Invalid options: "+err.Error())
+			return
+		}
+		fields := parseFields(r.FormValue("fields"))
+
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			log.Warnf("[%s] WebSocket handshake failed: %v", requestID, err)
+			var handshake *websocket.HandshakeError
+			if errors.As(err, &handshake) {
+				middleware.WriteError(w, handshake.Status, handshake.Code, handshake.Message)
+			}
+			return
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+
+		send := func(message wsMessage) {
+			data, err := json.Marshal(message)
+			if err == nil {
+				err = conn.WriteMessage(websocket.TextMessage, data)
+			}
+			if err != nil {
+				log.Warnf("[%s] Failed to send WebSocket message: %v", requestID, err)
+			}
+		}
+		fail := func(status int, code, message string) {
+			send(wsMessage{Type: "error", Status: status, Error: message, Code: code})
+			conn.Close(wsCloseCode(status), "")
+		}
+
+		file, header, err := receiveFile(conn, maxUploadBytes(cfg, r), cfg.MultipartMemoryMB<<20, deps.TempFiles, func(received, size int64) {
+			send(wsMessage{Type: "progress", Received: received, Size: size})
+		})
+		var netErr net.Error
+		var closeErr *websocket.CloseError
+		switch {
+		case errors.As(err, &closeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, websocket.ErrProtocol):
+			log.Warnf("[%s] Client went away during WebSocket upload: %v", requestID, err)
+			return
+		case errors.As(err, &netErr) && netErr.Timeout():
+			log.Warnf("[%s] WebSocket upload idle for %s", requestID, wsIdleTimeout)
+			fail(http.StatusRequestTimeout, models.CodeRequestTimeout, "Timed out waiting for file data")
+			return
+		case errors.Is(err, errUploadTooLarge):
+			log.Warnf("[%s] File too large", requestID)
+			fail(http.StatusRequestEntityTooLarge, models.CodeFileTooLarge, "File too large")
+			return
+		case errors.Is(err, tempfiles.ErrQuotaExceeded):
+			log.Warnf("[%s] Temporary file quota full, rejecting upload", requestID)
+			fail(http.StatusServiceUnavailable, models.CodeServerBusy, "Server busy, retry later")
+			return
+		case err != nil:
+			log.Warnf("[%s] Invalid WebSocket upload: %v", requestID, err)
+			fail(http.StatusBadRequest, models.CodeInvalidFile, "Invalid upload: "+err.Error())
+			return
+		}
+		defer file.Close()
+
+		// Reading on notices the client going away, and answers its pings
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		conn.SetReadDeadline(time.Time{})
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.NextMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		log.DebugContextf(ctx, "[%s] Processing file: %s (%d bytes)", requestID, log.Filename(header.Filename), header.Size)
+
+		opts.TempFiles = deps.TempFiles
+		opts.Progress = func(stage string) { send(wsMessage{Type: "stage", Stage: stage}) }
+		result, err := extractFile(ctx, cfg, log, requestID, deps, opts, file, header)
+		var violation *filepolicy.Violation
+		switch {
+		case errors.As(err, &violation):
+			log.Warnf("[%s] Rejected %s: %v", requestID, log.Filename(header.Filename), err)
+			fail(http.StatusUnsupportedMediaType, models.CodeUnsupportedType, violation.Error())
+			return
+		case errors.Is(err, errAntivirusUnavailable):
+			log.Errorf("[%s] %v", requestID, err)
+			fail(http.StatusServiceUnavailable, models.CodeAntivirusUnavailable, "Antivirus scan unavailable")
+			return
+		case errors.Is(err, workpool.ErrKeyBusy):
+			log.Warnf("[%s] API key already runs %d extractions, rejecting %s", requestID, cfg.MaxConcurrentPerKey, log.Filename(header.Filename))
+			fail(http.StatusTooManyRequests, models.CodeConcurrencyLimited, "Too many concurrent extractions for this API key")
+			return
+		case errors.Is(err, workpool.ErrBusy):
+			log.Warnf("[%s] No free extraction slot for %s", requestID, log.Filename(header.Filename))
+			fail(http.StatusServiceUnavailable, models.CodeServerBusy, "Server busy, retry later")
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			log.Warnf("[%s] Extraction of %s timed out after %s", requestID, log.Filename(header.Filename), cfg.ExtractionTimeout)
+			fail(http.StatusGatewayTimeout, models.CodeExtractionTimeout, "Metadata extraction timed out")
+			return
+		case errors.Is(err, context.Canceled):
+			log.Warnf("[%s] Client went away during extraction of %s", requestID, log.Filename(header.Filename))
+			return
+		case err != nil:
+			log.Errorf("[%s] Failed to extract metadata: %v", requestID, err)
+			fail(http.StatusInternalServerError, models.CodeExtractionFailed, "Failed to extract metadata")
+			return
+		}
+
+		response := version.serializeFor(r.Context(), result)
+		if fields != nil {
+			if response, err = filterFields(response, fields); err != nil {
+				log.Errorf("[%s] Failed to filter response fields: %v", requestID, err)
+				fail(http.StatusInternalServerError, models.CodeInternal, "Failed to encode response")
+				return
+			}
+		}
+		send(wsMessage{Type: "result", Result: response})
+
+		log.Infof("[%s] Successfully processed file: %s", requestID, log.Filename(header.Filename))
+	}
+}
+
+// receiveFile reads the start message and the binary messages carrying the
+// file, buffered like a multipart upload. progress is called after each
+// binary message.
+func receiveFile(conn *websocket.Conn, maxBytes, memoryLimit int64, temp *tempfiles.Manager, progress func(received, size int64)) (multipart.File, *multipart.FileHeader, error) {
+	conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+	messageType, message, err := conn.NextMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if messageType != websocket.TextMessage {
+		return nil, nil, fmt.Errorf("%w: file data before the start message", errUnexpectedMessage)
+	}
+
+	var start wsStart
+	if err := json.NewDecoder(io.LimitReader(message, maxFormValueBytes)).Decode(&start); err != nil {
+		return nil, nil, fmt.Errorf("invalid start message: %w", err)
+	}
+	switch {
+	case start.Filename == "":
+		return nil, nil, errors.New("filename is required")
+	case start.Size < 0:
+		return nil, nil, errors.New("size cannot be negative")
+	case start.Size > maxBytes:
+		return nil, nil, errUploadTooLarge
+	}
+
+	header := &multipart.FileHeader{
+		Filename: start.Filename,
+		Header:   textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}},
+	}
+	file, err := bufferFile(&wsFileReader{conn: conn, size: start.Size, progress: progress}, header, start.Size, memoryLimit, temp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, header, nil
+}
+
+// wsFileReader reads the file from binary messages until size bytes have
+// arrived
+type wsFileReader struct {
+	conn     *websocket.Conn
+	size     int64
+	received int64
+	message  io.Reader
+	progress func(received, size int64)
+}
+
+func (f *wsFileReader) Read(p []byte) (int, error) {
+	for f.message == nil {
+		if f.received == f.size {
+			return 0, io.EOF
+		}
+		f.conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+		messageType, message, err := f.conn.NextMessage()
+		if err != nil {
+			return 0, err
+		}
+		if messageType != websocket.BinaryMessage {
+			return 0, fmt.Errorf("%w: text before the file was complete", errUnexpectedMessage)
+		}
+		f.message = message
+	}
+
+	n, err := f.message.Read(p)
+	f.received += int64(n)
+	if f.received > f.size {
+		return n, fmt.Errorf("more data than the declared size of %d bytes", f.size)
+	}
+	if errors.Is(err, io.EOF) {
+		f.message, err = nil, nil
+		f.progress(f.received, f.size)
+	}
+	return n, err
+}
+
+// wsCloseCode is the close code sent after an error with an HTTP status
+func wsCloseCode(status int) int {
+	switch {
+	case status == http.StatusRequestEntityTooLarge:
+		return websocket.CloseTooLarge
+	case status == http.StatusServiceUnavailable:
+		return websocket.CloseTryAgainLater
+	case status < 500:
+		return websocket.ClosePolicyViolation
+	}
+	return websocket.CloseInternalError
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/metadata"
+	"file-meta/internal/models"
+)
+
+// wsClient is a minimal WebSocket client for the upload channel
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string) *wsClient {
+	t.Helper()
+	host, path, _ := strings.Cut(strings.TrimPrefix(url, "http://"), "/")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	io.WriteString(conn, "GET /"+path+" HTTP/1.1\r\nHost: "+host+"\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	return &wsClient{conn: conn, r: r}
+}
+
+// send writes a masked message of up to 64KB in one frame
+func (c *wsClient) send(opcode byte, payload []byte) {
+	frame := []byte{0x80 | opcode, 0x80 | 126}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	mask := []byte{7, 1, 3, 9}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+// messages reads server text messages until the connection closes
+func (c *wsClient) messages(t *testing.T) (messages []wsMessage, closeCode int) {
+	t.Helper()
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.r, head[:]); err != nil {
+			t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		length := int(head[1])
+		if length == 126 {
+			var ext [2]byte
+			io.ReadFull(c.r, ext[:])
+			length = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, length)
+		io.ReadFull(c.r, payload)
+
+		if head[0]&0x0f == 8 {
+			return messages, int(binary.BigEndian.Uint16(payload))
+		}
+		var message wsMessage
+		if err := json.Unmarshal(payload, &message); err != nil {
+			t.Fatalf("message %q: %v", payload, err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "8080",
+		MaxFileSizeMB:     1,
+		MultipartMemoryMB: 1,
+		RateLimitRequests: 10,
+		RateLimitWindow:   time.Minute,
+		LogLevel:          "info",
+	}
+	log := logger.New("info")
+
+	server := httptest.NewServer(WebSocketHandler(cfg, log, Deps{}, Versions[1]))
+	defer server.Close()
+
+	content := "Hello, World!\nSent over a WebSocket.\n"
+	start := func(filename string, size int) []byte {
+		data, _ := json.Marshal(wsStart{Filename: filename, Size: int64(size)})
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		frames    [][]byte
+		text      []bool
+		wantTypes []string
+		wantCode  int
+	}{
+		{
+			name:      "file in two messages",
+			query:     "?fields=filename,size_bytes",
+			frames:    [][]byte{start("notes.txt", len(content)), []byte(content[:10]), []byte(content[10:])},
+			text:      []bool{true, false, false},
+			wantTypes: []string{"progress", "progress", "stage", "stage", "result"},
+			wantCode:  1000,
+		},
+		{
+			name:      "too large",
+			frames:    [][]byte{start("big.bin", 2<<20)},
+			text:      []bool{true},
+			wantTypes: []string{"error"},
+			wantCode:  1009,
+		},
+		{
+			name:      "data before start",
+			frames:    [][]byte{[]byte(content)},
+			text:      []bool{false},
+			wantTypes: []string{"error"},
+			wantCode:  1008,
+		},
+		{
+			name:      "more data than declared",
+			frames:    [][]byte{start("notes.txt", 5), []byte(content)},
+			text:      []bool{true, false},
+			wantTypes: []string{"error"},
+			wantCode:  1009,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialWebSocket(t, server.URL+"/v2/metadata/ws"+tt.query)
+			for i, frame := range tt.frames {
+				opcode := byte(2)
+				if tt.text[i] {
+					opcode = 1
+				}
+				c.send(opcode, frame)
+			}
+
+			messages, code := c.messages(t)
+			var types []string
+			for _, message := range messages {
+				types = append(types, message.Type)
+			}
+			if strings.Join(types, ",") != strings.Join(tt.wantTypes, ",") {
+				t.Errorf("messages = %v, want %v", types, tt.wantTypes)
+			}
+			if code != tt.wantCode {
+				t.Errorf("close code = %d, want %d", code, tt.wantCode)
+			}
+
+			if tt.wantCode != 1000 {
+				return
+			}
+			if messages[1].Received != int64(len(content)) || messages[2].Stage != metadata.StageHashed {
+				t.Errorf("progress = %+v, stage = %+v", messages[1], messages[2])
+			}
+			result, _ := messages[len(messages)-1].Result.(map[string]any)
+			if len(result) != 2 || result["filename"] != "notes.txt" || result["size_bytes"] != float64(len(content)) {
+				t.Errorf("result = %v, want filename and size_bytes of notes.txt", result)
+			}
+		})
+	}
+}
+
+func TestWebSocketHandlerRequiresUpgrade(t *testing.T) {
+	cfg := &config.Config{Port: "8080", MaxFileSizeMB: 1, RateLimitRequests: 10, RateLimitWindow: time.Minute, LogLevel: "info"}
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"plain request", nil, http.StatusBadRequest},
+		{"old version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/metadata/ws", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+			WebSocketHandler(cfg, logger.New("info"), Deps{}, Versions[0]).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			var response models.ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || response.Code != models.CodeBadRequest {
+				t.Errorf("response = %+v (%v), want code %s", response, err, models.CodeBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,568 @@
+// Package acme obtains and renews a TLS certificate from an ACME
+// certificate authority such as Let's Encrypt (RFC 8555), proving control
+// of the domains with http-01 challenges
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"file-meta/internal/logger"
+)
+
+const (
+	// LetsEncrypt is the directory of Let's Encrypt's production CA
+	LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// renewBefore is how long before expiry a certificate is renewed
+	renewBefore = 30 * 24 * time.Hour
+
+	// challengePath is where the CA fetches http-01 key authorizations
+	challengePath = "/.well-known/acme-challenge/"
+
+	// maxResponseSize bounds how much of a CA response is read
+	maxResponseSize = 1 << 20
+
+	// Files kept in the cache directory
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "key.pem"
+)
+
+// ErrNoCertificate is returned by GetCertificate until a certificate has
+// been obtained
+var ErrNoCertificate = errors.New("no certificate obtained yet")
+
+// Manager keeps a certificate for its domains, cached in a directory so
+// restarts don't request new ones
+type Manager struct {
+	directoryURL string
+	domains      []string
+	email        string
+	dir          string
+	http         *http.Client
+	log          *logger.Logger
+	now          func() time.Time
+	poll         time.Duration
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	tokens map[string]string // http-01 token -> key authorization
+}
+
+// NewManager creates a manager for domains, registering with the CA at
+// directoryURL with contact email, if any. It loads the certificate cached
+// in dir, which is created if missing.
+func NewManager(directoryURL string, domains []string, email, dir string, log *logger.Logger) (*Manager, error) {
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("no domains to obtain a certificate for")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		directoryURL: directoryURL,
+		domains:      domains,
+		email:        email,
+		dir:          dir,
+		http:         &http.Client{Timeout: 30 * time.Second},
+		log:          log,
+		now:          time.Now,
+		poll:         time.Second,
+		tokens:       make(map[string]string),
+	}
+	if cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile), filepath.Join(dir, keyFile)); err == nil && m.covers(cert.Leaf) {
+		m.cert = &cert
+	}
+	return m, nil
+}
+
+// covers reports whether leaf is valid for every domain of the manager
+func (m *Manager) covers(leaf *x509.Certificate) bool {
+	if leaf == nil {
+		return false
+	}
+	for _, domain := range m.domains {
+		if leaf.VerifyHostname(domain) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GetCertificate returns the current certificate whatever the server name,
+// for tls.Config
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, ErrNoCertificate
+	}
+	return m.cert, nil
+}
+
+// HTTPHandler answers the CA's http-01 challenges and passes other
+// requests to fallback
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, challengePath)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		m.mu.Lock()
+		keyAuth, ok := m.tokens[token]
+		m.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, keyAuth)
+	})
+}
+
+// renewAt returns when the current certificate is due for renewal, or the
+// zero time without one
+func (m *Manager) renewAt() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return time.Time{}
+	}
+	return m.cert.Leaf.NotAfter.Add(-renewBefore)
+}
+
+// Run obtains a certificate when there is none or it is due for renewal,
+// until ctx is done. Failures are retried with backoff, up to an hour
+// apart.
+func (m *Manager) Run(ctx context.Context) {
+	backoff := time.Minute
+	for {
+		wait := m.renewAt().Sub(m.now())
+		if wait <= 0 {
+			if err := m.Obtain(ctx); err != nil {
+				m.log.Errorf("Failed to obtain a certificate for %s: %v", strings.Join(m.domains, ", "), err)
+				wait = backoff
+				backoff = min(2*backoff, time.Hour)
+			} else {
+				backoff = time.Minute
+				continue
+			}
+		}
+		// Check at least daily, so a clock change or sleep can't delay
+		// renewal for long
+		timer := time.NewTimer(min(wait, 24*time.Hour))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Obtain requests a new certificate for the manager's domains, caches it
+// and serves it from then on
+func (m *Manager) Obtain(ctx context.Context) error {
+	accountKey, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	c := &client{http: m.http, key: accountKey}
+	if err := c.discover(ctx, m.directoryURL); err != nil {
+		return err
+	}
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if m.email != "" {
+		account["contact"] = []string{"mailto:" + m.email}
+	}
+	resp, err := c.post(ctx, c.directory.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("account registration failed: %w", err)
+	}
+	c.kid = resp.Header.Get("Location")
+
+	identifiers := make([]map[string]string, len(m.domains))
+	for i, domain := range m.domains {
+		identifiers[i] = map[string]string{"type": "dns", "value": domain}
+	}
+	var o order
+	resp, err = c.post(ctx, c.directory.NewOrder, map[string]any{"identifiers": identifiers}, &o)
+	if err != nil {
+		return fmt.Errorf("order failed: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range o.Authorizations {
+		if err := m.authorize(ctx, c, authzURL); err != nil {
+			return err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domains[0]},
+		DNSNames: m.domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+	if _, err := c.post(ctx, o.Finalize, map[string]string{"csr": b64(csr)}, &o); err != nil {
+		return fmt.Errorf("finalization failed: %w", err)
+	}
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return fmt.Errorf("order %s is invalid", orderURL)
+		}
+		if err := sleep(ctx, m.poll); err != nil {
+			return err
+		}
+		if _, err := c.post(ctx, orderURL, nil, &o); err != nil {
+			return err
+		}
+	}
+
+	resp, err = c.post(ctx, o.Certificate, nil, nil)
+	if err != nil {
+		return fmt.Errorf("certificate download failed: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := resp.body
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	if err := writeFile(filepath.Join(m.dir, keyFile), keyPEM); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(m.dir, certFile), certPEM); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	m.log.Infof("Obtained a certificate for %s, valid until %s", strings.Join(m.domains, ", "), cert.Leaf.NotAfter.Format(time.DateOnly))
+	return nil
+}
+
+// authorize answers the http-01 challenge of an authorization and waits
+// for the CA to validate it
+func (m *Manager) authorize(ctx context.Context, c *client, authzURL string) error {
+	var a authorization
+	if _, err := c.post(ctx, authzURL, nil, &a); err != nil {
+		return err
+	}
+	if a.Status == "valid" {
+		return nil
+	}
+	var challenge *challenge
+	for i := range a.Challenges {
+		if a.Challenges[i].Type == "http-01" {
+			challenge = &a.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no http-01 challenge for %s", a.Identifier.Value)
+	}
+
+	m.mu.Lock()
+	m.tokens[challenge.Token] = challenge.Token + "." + c.thumbprint()
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.tokens, challenge.Token)
+		m.mu.Unlock()
+	}()
+
+	if _, err := c.post(ctx, challenge.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("challenge for %s failed: %w", a.Identifier.Value, err)
+	}
+	for a.Status != "valid" {
+		if a.Status == "invalid" {
+			return fmt.Errorf("validation of %s failed", a.Identifier.Value)
+		}
+		if err := sleep(ctx, m.poll); err != nil {
+			return err
+		}
+		if _, err := c.post(ctx, authzURL, nil, &a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountKey loads the account key from the cache directory, generating
+// it on first use
+func (m *Manager) accountKey() (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(m.dir, accountKeyFile)
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s is not PEM", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+// writeFile replaces path with data, readable by the owner only
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type authorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// problem is an ACME error document (RFC 7807)
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *problem) Error() string {
+	return strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:") + ": " + p.Detail
+}
+
+// client signs requests to the CA with the account key
+type client struct {
+	http      *http.Client
+	key       *ecdsa.PrivateKey
+	directory directory
+	kid       string // account URL, once registered
+	nonce     string
+}
+
+type response struct {
+	Header http.Header
+	body   []byte
+}
+
+// discover fetches the CA's directory
+func (c *client) discover(ctx context.Context, directoryURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, directoryURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ACME directory returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&c.directory)
+}
+
+// post sends payload signed to url, decoding a JSON response into out if
+// it isn't nil. A nil payload is a POST-as-GET. A rejected nonce is retried
+// once with a fresh one.
+func (c *client) post(ctx context.Context, url string, payload, out any) (*response, error) {
+	resp, err := c.send(ctx, url, payload)
+	var p *problem
+	if errors.As(err, &p) && p.Type == "urn:ietf:params:acme:error:badNonce" {
+		resp, err = c.send(ctx, url, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.Unmarshal(resp.body, out); err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %w", url, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *client) send(ctx context.Context, url string, payload any) (*response, error) {
+	if c.nonce == "" {
+		if err := c.newNonce(ctx); err != nil {
+			return nil, err
+		}
+	}
+	body, err := c.sign(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		p := &problem{}
+		if json.Unmarshal(data, p) != nil || p.Type == "" {
+			return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+		}
+		return nil, p
+	}
+	return &response{Header: resp.Header, body: data}, nil
+}
+
+// newNonce fetches a nonce for the next request
+func (c *client) newNonce(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.directory.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if c.nonce = resp.Header.Get("Replay-Nonce"); c.nonce == "" {
+		return fmt.Errorf("ACME server returned no nonce")
+	}
+	return nil
+}
+
+// sign wraps payload in a flattened JWS signed with ES256, identifying the
+// account by its URL once registered and by its public key before
+func (c *client) sign(url string, payload any) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var encodedPayload string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = b64(data)
+	}
+	signingInput := b64(header) + "." + encodedPayload
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	c.nonce = ""
+	return json.Marshal(map[string]string{
+		"protected": b64(header),
+		"payload":   encodedPayload,
+		"signature": b64(signature),
+	})
+}
+
+// jwk is the account's public key as a JSON Web Key
+func (c *client) jwk() map[string]string {
+	x, y := c.coordinates()
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": x, "y": y}
+}
+
+// thumbprint identifies the account key in key authorizations (RFC 7638)
+func (c *client) thumbprint() string {
+	x, y := c.coordinates()
+	sum := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+	return b64(sum[:])
+}
+
+// coordinates returns the account key's public point, base64url-encoded
+func (c *client) coordinates() (x, y string) {
+	pub, err := c.key.PublicKey.ECDH()
+	if err != nil {
+		return "", ""
+	}
+	point := pub.Bytes() // 0x04 || x || y
+	return b64(point[1:33]), b64(point[33:])
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,299 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+)
+
+// testCA is an ACME server that validates challenges by asking fetch for
+// the key authorization of a token, and issues certificates for 90 days
+type testCA struct {
+	t      *testing.T
+	server *httptest.Server
+	fetch  func(token string) string
+
+	mu        sync.Mutex
+	nonces    map[string]bool
+	nextNonce int
+	account   *ecdsa.PublicKey
+	thumb     string
+	authz     map[string]string // token -> status
+	domains   []string
+	cert      []byte
+	badNonce  bool // reject the next nonce once
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{t: t, nonces: make(map[string]bool), authz: make(map[string]string), badNonce: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(directory{NewNonce: ca.url("/nonce"), NewAccount: ca.url("/account"), NewOrder: ca.url("/order")})
+	})
+	mux.HandleFunc("HEAD /nonce", func(w http.ResponseWriter, r *http.Request) {
+		ca.issueNonce(w)
+	})
+	mux.HandleFunc("POST /", ca.serve)
+	ca.server = httptest.NewServer(mux)
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *testCA) url(path string) string {
+	return ca.server.URL + path
+}
+
+func (ca *testCA) issueNonce(w http.ResponseWriter) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.nextNonce++
+	nonce := fmt.Sprint("nonce-", ca.nextNonce)
+	ca.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func (ca *testCA) problem(w http.ResponseWriter, kind string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(problem{Type: "urn:ietf:params:acme:error:" + kind, Detail: kind})
+}
+
+// verify checks a JWS and returns its protected header and payload
+func (ca *testCA) verify(r *http.Request) (map[string]any, []byte, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, nil, false
+	}
+	decode := func(s string) []byte {
+		data, _ := base64.RawURLEncoding.DecodeString(s)
+		return data
+	}
+	var header map[string]any
+	if json.Unmarshal(decode(jws.Protected), &header) != nil || header["url"] != ca.url(r.URL.Path) || header["alg"] != "ES256" {
+		return nil, nil, false
+	}
+
+	key := ca.account
+	if jwk, ok := header["jwk"].(map[string]any); ok {
+		x, _ := jwk["x"].(string)
+		y, _ := jwk["y"].(string)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(decode(x)), Y: new(big.Int).SetBytes(decode(y))}
+		ca.account = key
+		sum := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+		ca.thumb = base64.RawURLEncoding.EncodeToString(sum[:])
+	} else if header["kid"] != ca.url("/account/1") {
+		return nil, nil, false
+	}
+	signature := decode(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if key == nil || len(signature) != 64 || !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return nil, nil, false
+	}
+	return header, decode(jws.Payload), true
+}
+
+func (ca *testCA) serve(w http.ResponseWriter, r *http.Request) {
+	header, payload, ok := ca.verify(r)
+	if !ok {
+		ca.problem(w, "malformed")
+		return
+	}
+	ca.mu.Lock()
+	nonce, _ := header["nonce"].(string)
+	valid := ca.nonces[nonce]
+	delete(ca.nonces, nonce)
+	bad := ca.badNonce && r.URL.Path == "/order"
+	ca.badNonce = ca.badNonce && !bad
+	ca.mu.Unlock()
+	ca.issueNonce(w)
+	if !valid || bad {
+		ca.problem(w, "badNonce")
+		return
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	switch path := r.URL.Path; {
+	case path == "/account":
+		w.Header().Set("Location", ca.url("/account/1"))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"status":"valid"}`)
+	case path == "/order":
+		var request struct{ Identifiers []struct{ Value string } }
+		json.Unmarshal(payload, &request)
+		o := order{Status: "pending", Finalize: ca.url("/finalize")}
+		ca.domains = nil
+		for _, id := range request.Identifiers {
+			ca.domains = append(ca.domains, id.Value)
+			ca.authz["token-"+id.Value] = "pending"
+			o.Authorizations = append(o.Authorizations, ca.url("/authz/"+id.Value))
+		}
+		w.Header().Set("Location", ca.url("/order/1"))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(o)
+	case strings.HasPrefix(path, "/authz/"):
+		domain := strings.TrimPrefix(path, "/authz/")
+		token := "token-" + domain
+		fmt.Fprintf(w, `{"status":%q,"identifier":{"type":"dns","value":%q},"challenges":[{"type":"dns-01","url":%q,"token":"other"},{"type":"http-01","url":%q,"token":%q}]}`,
+			ca.authz[token], domain, ca.url("/chal/dns"), ca.url("/chal/"+token), token)
+	case strings.HasPrefix(path, "/chal/"):
+		token := strings.TrimPrefix(path, "/chal/")
+		ca.mu.Unlock()
+		keyAuth := ca.fetch(token)
+		ca.mu.Lock()
+		ca.authz[token] = "invalid"
+		if keyAuth == token+"."+ca.thumb {
+			ca.authz[token] = "valid"
+		}
+		io.WriteString(w, `{"status":"processing"}`)
+	case path == "/finalize":
+		var request struct{ CSR string }
+		json.Unmarshal(payload, &request)
+		der, _ := base64.RawURLEncoding.DecodeString(request.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || strings.Join(csr.DNSNames, ",") != strings.Join(ca.domains, ",") {
+			ca.problem(w, "badCSR")
+			return
+		}
+		ca.cert = ca.issue(csr)
+		json.NewEncoder(w).Encode(order{Status: "processing"})
+	case path == "/order/1":
+		json.NewEncoder(w).Encode(order{Status: "valid", Certificate: ca.url("/cert/1")})
+	case path == "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.cert)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// issue signs a certificate for the CSR with a throwaway CA
+func (ca *testCA) issue(csr *x509.CertificateRequest) []byte {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+}
+
+func TestObtain(t *testing.T) {
+	log := logger.New("info")
+	log.SetOutput(io.Discard)
+	dir := t.TempDir()
+	domains := []string{"files.example.com", "meta.example.com"}
+
+	ca := newTestCA(t)
+	m, err := NewManager(ca.url("/directory"), domains, "ops@example.com", dir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.poll = time.Millisecond
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	ca.fetch = func(token string) string {
+		rr := httptest.NewRecorder()
+		m.HTTPHandler(notFound).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://files.example.com"+challengePath+token, nil))
+		return rr.Body.String()
+	}
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != ErrNoCertificate {
+		t.Fatalf("GetCertificate() before Obtain = %v, want %v", err, ErrNoCertificate)
+	}
+	if err := m.Obtain(context.Background()); err != nil {
+		t.Fatalf("Obtain() = %v", err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || !m.covers(cert.Leaf) {
+		t.Fatalf("GetCertificate() = %v, %v, want a certificate for %v", cert, err, domains)
+	}
+	if renew := m.renewAt(); renew.Sub(cert.Leaf.NotAfter) != -renewBefore {
+		t.Errorf("renewAt() = %s, want 30 days before %s", renew, cert.Leaf.NotAfter)
+	}
+
+	// Other requests fall through, and answered challenges are forgotten
+	rr := httptest.NewRecorder()
+	m.HTTPHandler(notFound).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rr.Code != http.StatusTeapot {
+		t.Errorf("fallback status = %d, want %d", rr.Code, http.StatusTeapot)
+	}
+	if keyAuth := ca.fetch("token-files.example.com"); keyAuth != "404 page not found\n" {
+		t.Errorf("challenge after validation = %q, want not found", keyAuth)
+	}
+
+	// The certificate and account key are cached, and reloaded on restart
+	for _, name := range []string{accountKeyFile, certFile, keyFile} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("%s: %v, want a file only its owner can read", name, err)
+		}
+	}
+	restarted, err := NewManager(ca.url("/directory"), domains, "", dir, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := restarted.GetCertificate(&tls.ClientHelloInfo{}); err != nil || !cached.Leaf.Equal(cert.Leaf) {
+		t.Errorf("GetCertificate() after restart = %v, want the cached certificate", err)
+	}
+	if other, _ := NewManager(ca.url("/directory"), []string{"other.example.com"}, "", dir, log); other.renewAt() != (time.Time{}) {
+		t.Error("cached certificate used for other domains")
+	}
+}
+
+func TestObtainInvalidChallenge(t *testing.T) {
+	log := logger.New("info")
+	log.SetOutput(io.Discard)
+
+	ca := newTestCA(t)
+	ca.fetch = func(token string) string { return token + ".wrong" }
+	m, err := NewManager(ca.url("/directory"), []string{"files.example.com"}, "", t.TempDir(), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.poll = time.Millisecond
+
+	if err := m.Obtain(context.Background()); err == nil || !strings.Contains(err.Error(), "validation of files.example.com failed") {
+		t.Errorf("Obtain() = %v, want the failed validation", err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err != ErrNoCertificate {
+		t.Errorf("GetCertificate() = %v, want %v", err, ErrNoCertificate)
+	}
+}
@@ -0,0 +1,77 @@
+// Package aiclassifier calls an external machine-learning service that scores
+// how likely an image is to be AI-generated
+package aiclassifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"file-meta/internal/tracecontext"
+)
+
+// maxResponseSize bounds how much of the classifier's reply is read
+const maxResponseSize = 64 * 1024
+
+// Result is a classifier's verdict for one image
+type Result struct {
+	// Score is the probability in [0, 1] that the image is AI-generated
+	Score float64 `json:"score"`
+	Label string  `json:"label,omitempty"`
+	Model string  `json:"model,omitempty"`
+}
+
+// Client posts images to an HTTP classifier endpoint. The image is sent as
+// the raw request body with its MIME type as Content-Type, and the endpoint
+// replies with a JSON Result.
+type Client struct {
+	url    string
+	apiKey string
+	http   *http.Client
+}
+
+// NewClient creates a classifier client for url. apiKey, when set, is sent
+// as a bearer token.
+func NewClient(url, apiKey string, timeout time.Duration) *Client {
+	return &Client{
+		url:    url,
+		apiKey: apiKey,
+		http:   &http.Client{Transport: &tracecontext.Transport{}, Timeout: timeout},
+	}
+}
+
+// Classify sends the image in r to the classifier and returns its verdict
+func (c *Client) Classify(ctx context.Context, r io.Reader, mimeType string) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mimeType)
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("classifier returned status %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode classifier response: %w", err)
+	}
+	if result.Score < 0 || result.Score > 1 {
+		return nil, fmt.Errorf("classifier score %v out of range [0, 1]", result.Score)
+	}
+
+	return &result, nil
+}
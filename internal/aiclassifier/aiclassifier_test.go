@@ -0,0 +1,97 @@
+package aiclassifier
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		apiKey        string
+		expectError   bool
+		expectedScore float64
+		expectedModel string
+	}{
+		{
+			name:          "AI-generated verdict",
+			status:        http.StatusOK,
+			body:          `{"score": 0.93, "label": "ai", "model": "detector-v2"}`,
+			expectedScore: 0.93,
+			expectedModel: "detector-v2",
+		},
+		{
+			name:          "authenticated request",
+			status:        http.StatusOK,
+			body:          `{"score": 0.1}`,
+			apiKey:        "secret",
+			expectedScore: 0.1,
+		},
+		{
+			name:        "server error",
+			status:      http.StatusInternalServerError,
+			body:        `{"error": "model not loaded"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			status:      http.StatusOK,
+			body:        `not json`,
+			expectError: true,
+		},
+		{
+			name:        "score out of range",
+			status:      http.StatusOK,
+			body:        `{"score": 93}`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Type") != "image/png" {
+					t.Errorf("Content-Type = %q, want image/png", r.Header.Get("Content-Type"))
+				}
+				if tt.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+tt.apiKey {
+					t.Errorf("Authorization = %q, want bearer token", r.Header.Get("Authorization"))
+				}
+				if body, _ := io.ReadAll(r.Body); string(body) != "image data" {
+					t.Errorf("body = %q, want image data", body)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, tt.apiKey, 5*time.Second)
+			result, err := client.Classify(context.Background(), strings.NewReader("image data"), "image/png")
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Classify() error = nil, want error (result %+v)", result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Classify() error = %v", err)
+			}
+
+			if result.Score != tt.expectedScore {
+				t.Errorf("Score = %v, want %v", result.Score, tt.expectedScore)
+			}
+
+			if result.Model != tt.expectedModel {
+				t.Errorf("Model = %v, want %v", result.Model, tt.expectedModel)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package azureblob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/internal/storage"
+)
+
+func TestSign(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("account-key"))
+	c, err := NewClient("myaccount", key, "", "", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://myaccount.blob.core.windows.net/container/dir/blob.txt", nil)
+	req.Header.Set("Range", "bytes=0-9")
+	req.Header.Set("x-ms-version", apiVersion)
+	c.sign(req, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	stringToSign := "GET" + strings.Repeat("\n", 11) + "bytes=0-9\n" +
+		"x-ms-date:Tue, 02 Jan 2024 03:04:05 GMT\n" +
+		"x-ms-version:2021-08-06\n" +
+		"/myaccount/container/dir/blob.txt"
+	mac := hmac.New(sha256.New, []byte("account-key"))
+	mac.Write([]byte(stringToSign))
+	want := "SharedKey myaccount:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	content := "Hello, World!\nRead from Azure.\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/container/notes.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("sig") != "abc" || r.Header.Get("x-ms-version") != apiVersion {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(content)-1)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, content[start:end+1])
+	}))
+	defer server.Close()
+
+	c, err := NewClient("devaccount", "", "?sv=2021-08-06&sig=abc", server.URL, 8, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := c.Open(context.Background(), "container", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(obj)
+	if err != nil || string(data) != content {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+
+	u, _ := url.Parse(server.URL + "/container/notes.txt?sig=abc")
+	if obj, err := c.OpenURL(context.Background(), u); err != nil || obj.Name != "notes.txt" {
+		t.Errorf("OpenURL() = %v, %v", obj, err)
+	}
+	u, _ = url.Parse(server.URL + "/container/notes.txt?sig=wrong")
+	if _, err := c.OpenURL(context.Background(), u); !errors.Is(err, storage.ErrAccessDenied) {
+		t.Errorf("OpenURL() with a bad signature error = %v, want ErrAccessDenied", err)
+	}
+	u, _ = url.Parse("https://evil.example/container/notes.txt")
+	if _, err := c.OpenURL(context.Background(), u); !errors.Is(err, storage.ErrURLNotAllowed) {
+		t.Errorf("OpenURL() of another host error = %v, want ErrURLNotAllowed", err)
+	}
+}
+
+func TestOpenWithoutCredentials(t *testing.T) {
+	c, err := NewClient("myaccount", "", "", "", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(context.Background(), "container", "blob"); !errors.Is(err, storage.ErrNoCredentials) {
+		t.Errorf("Open() error = %v, want ErrNoCredentials", err)
+	}
+}
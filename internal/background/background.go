@@ -0,0 +1,91 @@
+// Package background tracks work that outlives the request that started
+// it, such as async jobs, so shutdown can wait for it and report what it
+// had to abandon.
+package background
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Tracker runs and counts background tasks until Wait is called
+type Tracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	tasks   map[*string]struct{}
+	closing bool
+}
+
+// NewTracker creates a tracker accepting tasks
+func NewTracker() *Tracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Tracker{ctx: ctx, cancel: cancel, tasks: make(map[*string]struct{})}
+}
+
+// Go runs fn in a goroutine, tracked as name until it returns. fn's ctx
+// carries parent's values and is canceled when Wait gives up on it, but
+// not with parent. Go returns false without running fn once Wait has been
+// called.
+func (t *Tracker) Go(parent context.Context, name string, fn func(ctx context.Context)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+
+	task := &name
+	t.tasks[task] = struct{}{}
+	t.wg.Add(1)
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(t.ctx, cancel)
+	go func() {
+		defer t.wg.Done()
+		defer func() {
+			stop()
+			cancel()
+			t.mu.Lock()
+			delete(t.tasks, task)
+			t.mu.Unlock()
+		}()
+		fn(ctx)
+	}()
+	return true
+}
+
+// Pending returns the names of the running tasks, sorted
+func (t *Tracker) Pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.tasks))
+	for task := range t.tasks {
+		names = append(names, *task)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Wait stops accepting tasks and waits for the running ones until ctx is
+// done. It then cancels those still running and returns their names.
+func (t *Tracker) Wait(ctx context.Context) []string {
+	t.mu.Lock()
+	t.closing = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	abandoned := t.Pending()
+	t.cancel()
+	return abandoned
+}
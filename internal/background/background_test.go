@@ -0,0 +1,59 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type contextKey string
+
+func TestWait(t *testing.T) {
+	tracker := NewTracker()
+	parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), contextKey("request"), "abc"))
+	cancelParent()
+
+	finished := make(chan struct{})
+	tracker.Go(parent, "job quick", func(ctx context.Context) {
+		if ctx.Err() != nil || ctx.Value(contextKey("request")) != "abc" {
+			t.Errorf("task context = %v, %v, want the parent's values without its cancellation", ctx.Err(), ctx.Value(contextKey("request")))
+		}
+		close(finished)
+	})
+	canceled := make(chan struct{})
+	tracker.Go(context.Background(), "job stuck", func(ctx context.Context) {
+		<-ctx.Done()
+		close(canceled)
+	})
+	<-finished
+
+	if got := fmt.Sprint(tracker.Pending()); got != "[job stuck]" {
+		t.Errorf("Pending() = %s, want [job stuck]", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if got := fmt.Sprint(tracker.Wait(ctx)); got != "[job stuck]" {
+		t.Errorf("Wait() = %s, want [job stuck] abandoned", got)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("abandoned task was not canceled")
+	}
+	if tracker.Go(context.Background(), "job late", func(ctx context.Context) {}) {
+		t.Error("Go() after Wait() ran the task")
+	}
+}
+
+func TestWaitFinished(t *testing.T) {
+	tracker := NewTracker()
+	release := make(chan struct{})
+	tracker.Go(context.Background(), "job", func(ctx context.Context) { <-release })
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+
+	if abandoned := tracker.Wait(context.Background()); abandoned != nil {
+		t.Errorf("Wait() = %v, want every task finished", abandoned)
+	}
+}
@@ -0,0 +1,147 @@
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of each INSTREAM data chunk sent to clamd
+const chunkSize = 64 * 1024
+
+// Verdict is the outcome of scanning one stream
+type Verdict struct {
+	Infected  bool
+	Signature string
+}
+
+// Client talks to a clamd daemon over TCP or a unix socket
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClient creates a clamd client. address is "tcp://host:port",
+// "unix:///path/to/clamd.sock", a bare "host:port" or a bare socket path.
+func NewClient(address string, timeout time.Duration) (*Client, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{network: network, address: addr, timeout: timeout}, nil
+}
+
+func parseAddress(address string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "/"):
+		return "unix", address, nil
+	case strings.Contains(address, ":"):
+		return "tcp", address, nil
+	}
+	return "", "", fmt.Errorf("invalid clamd address %q", address)
+}
+
+// Ping checks that clamd is reachable and responding
+func (c *Client) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd with the INSTREAM command and returns its verdict
+func (c *Client) Scan(ctx context.Context, r io.Reader) (*Verdict, error) {
+	reply, err := c.command(ctx, "zINSTREAM\x00", r)
+	if err != nil {
+		return nil, err
+	}
+	return parseReply(reply)
+}
+
+// command sends a null-terminated command, optionally followed by a chunked
+// stream, and reads the null-terminated reply
+func (c *Client) command(ctx context.Context, cmd string, stream io.Reader) (string, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := io.WriteString(conn, cmd); err != nil {
+		return "", fmt.Errorf("failed to send clamd command: %w", err)
+	}
+
+	if stream != nil {
+		if err := writeChunks(conn, stream); err != nil {
+			return "", err
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// writeChunks sends r as length-prefixed chunks terminated by a zero-length
+// chunk
+func writeChunks(w io.Writer, r io.Reader) error {
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return fmt.Errorf("failed to stream data to clamd: %w", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read data to scan: %w", err)
+		}
+	}
+
+	if _, err := w.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("failed to stream data to clamd: %w", err)
+	}
+	return nil
+}
+
+// parseReply interprets an INSTREAM reply such as "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (*Verdict, error) {
+	_, status, _ := strings.Cut(reply, ": ")
+	switch {
+	case status == "OK":
+		return &Verdict{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return &Verdict{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	case strings.HasSuffix(reply, "ERROR"):
+		return nil, fmt.Errorf("clamd error: %s", reply)
+	}
+	return nil, fmt.Errorf("unexpected clamd reply: %q", reply)
+}
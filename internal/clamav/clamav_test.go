@@ -0,0 +1,155 @@
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts one INSTREAM connection and replies based on the
+// streamed content
+func fakeClamd(t *testing.T, reply func(data string) string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		cmd, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		if cmd == "zPING\x00" {
+			io.WriteString(conn, "PONG\x00")
+			return
+		}
+
+		var data strings.Builder
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+				return
+			}
+		}
+		io.WriteString(conn, reply(data.String())+"\x00")
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestScan(t *testing.T) {
+	eicar := `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+	reply := func(data string) string {
+		switch {
+		case strings.Contains(data, "EICAR"):
+			return "stream: Eicar-Signature FOUND"
+		case len(data) > 100*1024:
+			return "INSTREAM size limit exceeded. ERROR"
+		}
+		return "stream: OK"
+	}
+
+	tests := []struct {
+		name              string
+		data              string
+		expectErr         bool
+		expectedInfected  bool
+		expectedSignature string
+	}{
+		{name: "clean", data: "hello world"},
+		{name: "infected", data: eicar, expectedInfected: true, expectedSignature: "Eicar-Signature"},
+		{name: "multiple chunks", data: strings.Repeat("a", chunkSize+10)},
+		{name: "size limit", data: strings.Repeat("a", 200*1024), expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient("tcp://"+fakeClamd(t, reply), 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verdict, err := client.Scan(context.Background(), strings.NewReader(tt.data))
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Scan() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+
+			if verdict.Infected != tt.expectedInfected {
+				t.Errorf("Infected = %v, want %v", verdict.Infected, tt.expectedInfected)
+			}
+			if verdict.Signature != tt.expectedSignature {
+				t.Errorf("Signature = %v, want %v", verdict.Signature, tt.expectedSignature)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	client, err := NewClient(fakeClamd(t, nil), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+}
+
+func TestNewClientAddress(t *testing.T) {
+	tests := []struct {
+		address         string
+		expectedNetwork string
+		expectedAddress string
+		expectErr       bool
+	}{
+		{address: "tcp://clamav:3310", expectedNetwork: "tcp", expectedAddress: "clamav:3310"},
+		{address: "localhost:3310", expectedNetwork: "tcp", expectedAddress: "localhost:3310"},
+		{address: "unix:///var/run/clamav/clamd.ctl", expectedNetwork: "unix", expectedAddress: "/var/run/clamav/clamd.ctl"},
+		{address: "/tmp/clamd.sock", expectedNetwork: "unix", expectedAddress: "/tmp/clamd.sock"},
+		{address: "clamav", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			client, err := NewClient(tt.address, time.Second)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("NewClient(%q) error = nil, want error", tt.address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.network != tt.expectedNetwork || client.address != tt.expectedAddress {
+				t.Errorf("NewClient(%q) = %s %s, want %s %s", tt.address, client.network, client.address, tt.expectedNetwork, tt.expectedAddress)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package cli implements the file-meta command's local subcommands. They
+// run the extractor in process with the API's options and response schemas,
+// so results match the server's without running one.
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-meta/config"
+	"file-meta/handlers"
+	"file-meta/internal/dirscan"
+	"file-meta/internal/metadata"
+	"file-meta/internal/modules"
+)
+
+const usage = `Usage:
+  file-meta [serve] [-worker | -watch | -scan DIR]
+        Run the API server, or one of its other modes
+  file-meta extract [flags] [PATH | -]...
+        Extract metadata like POST /metadata and print it as JSON
+  file-meta hash [flags] [PATH | -]...
+        Print checksums
+  file-meta health
+        Check that the server on PORT is up, for container health checks
+  file-meta hash-key
+        Read an API key from stdin and print its hash for API_KEYS
+  file-meta help
+        Show this help
+
+PATH may be a file or a directory, whose files are all processed. With no
+PATH, or -, content is read from stdin. One file's result is printed as
+JSON; several, or a directory's, as NDJSON lines of {"path", "result"} or
+{"path", "error"}.
+
+Run "file-meta extract -h" or "file-meta hash -h" for their flags.
+`
+
+// Run runs the subcommand args names, writing results to stdout and errors
+// to stderr, and returns the exit code. ok is false when args name no
+// subcommand, so the caller starts the server.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "extract":
+		return extract(args[1:], stdin, stdout, stderr), true
+	case "hash":
+		return hash(args[1:], stdin, stdout, stderr), true
+	case "health":
+		return health(stderr), true
+	case "hash-key":
+		return hashKey(stdin, stdout, stderr), true
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0, true
+	}
+	return 0, false
+}
+
+// command is what extract and hash share: their inputs, the extraction
+// settings and how each result is printed
+type command struct {
+	cfg     *config.Config
+	opts    metadata.Options
+	name    string
+	ndjson  bool
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	present func(*metadata.Result) (any, error)
+}
+
+func extract(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	version := flags.String("version", "v1", "Response schema version")
+	fields := flags.String("fields", "", "Comma-separated response fields to keep, e.g. checksum_sha256,image.width")
+	values := map[string]*string{
+		"profile":   flags.String("profile", "", "Extraction profile, e.g. fast or forensic"),
+		"include":   flags.String("include", "", "Comma-separated modules to run"),
+		"exclude":   flags.String("exclude", "", "Comma-separated modules to skip"),
+		"checksums": flags.String("checksums", "", "Comma-separated extra checksums, e.g. tlsh"),
+	}
+	name := flags.String("name", "stdin", "File name for content read from stdin, used for its extension")
+	ndjson := flags.Bool("ndjson", false, "Print NDJSON lines even for a single file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	v, ok := handlers.VersionByName(*version)
+	if !ok {
+		fmt.Fprintf(stderr, "file-meta: unknown version %q\n", *version)
+		return 2
+	}
+	c, err := newCommand(func(name string) string { return *values[name] })
+	if err != nil {
+		fmt.Fprintf(stderr, "file-meta: %v\n", err)
+		return 2
+	}
+	c.name, c.ndjson, c.stdin, c.stdout, c.stderr = *name, *ndjson, stdin, stdout, stderr
+	c.present = func(result *metadata.Result) (any, error) {
+		return handlers.SelectFields(v.Serialize(result), *fields)
+	}
+	return c.run(flags.Args())
+}
+
+// checksums is a hash subcommand result
+type checksums struct {
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"checksum_sha256"`
+	SSDeep    string `json:"ssdeep,omitempty"`
+	TLSH      string `json:"tlsh,omitempty"`
+}
+
+func hash(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("hash", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	name := flags.String("name", "stdin", "File name for content read from stdin")
+	ndjson := flags.Bool("ndjson", false, "Print NDJSON lines even for a single file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// Checksums are computed whichever modules run, so run none
+	c, err := newCommand(func(name string) string {
+		if name == "checksums" {
+			return metadata.ChecksumTLSH
+		}
+		return ""
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "file-meta: %v\n", err)
+		return 2
+	}
+	c.opts.Skip = make(map[string]bool)
+	for _, module := range metadata.Modules {
+		c.opts.Skip[module] = true
+	}
+	c.name, c.ndjson, c.stdin, c.stdout, c.stderr = *name, *ndjson, stdin, stdout, stderr
+	c.present = func(result *metadata.Result) (any, error) {
+		return checksums{SizeBytes: result.SizeBytes, SHA256: result.SHA256, SSDeep: result.SSDeep, TLSH: result.TLSH}, nil
+	}
+	return c.run(flags.Args())
+}
+
+// health checks the local server's health endpoint
+func health(stderr io.Writer) int {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	scheme := "http"
+	if os.Getenv("TLS_CERT_FILE") != "" || os.Getenv("TLS_AUTOCERT_DOMAINS") != "" {
+		// The certificate is the server's own, issued for its public name
+		scheme = "https"
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Get(scheme + "://127.0.0.1:" + port + "/health")
+	if err != nil {
+		fmt.Fprintf(stderr, "file-meta: %v\n", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(stderr, "file-meta: health check returned %s\n", resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// hashKey prints the API_KEYS entry for the key on stdin's first line. The
+// key isn't an argument so it stays out of shell history.
+func hashKey(stdin io.Reader, stdout, stderr io.Writer) int {
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(stderr, "file-meta: %v\n", err)
+		return 1
+	}
+	key := strings.TrimSpace(line)
+	if key == "" {
+		fmt.Fprintln(stderr, "file-meta: no API key on stdin")
+		return 2
+	}
+	fmt.Fprintln(stdout, config.HashAPIKey(key))
+	return 0
+}
+
+// newCommand reads the configuration, without requiring API keys, for the
+// extraction limits and profiles, and builds options from value
+func newCommand(value func(name string) string) (*command, error) {
+	cfg, err := config.LoadLocal()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := handlers.ExtractionOptions(cfg, modules.New(cfg.DisabledModules), value)
+	if err != nil {
+		return nil, err
+	}
+	return &command{cfg: cfg, opts: opts}, nil
+}
+
+// run processes every input in order and returns the exit code: 1 if any
+// failed
+func (c *command) run(paths []string) int {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	// Expand directories to their regular files; symlinks are skipped
+	var files []string
+	many := c.ndjson || len(paths) > 1
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if path == "-" || err != nil || !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		many = true
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(c.stderr, "file-meta: %v\n", err)
+			return 1
+		}
+	}
+
+	code := 0
+	enc := json.NewEncoder(c.stdout)
+	for _, path := range files {
+		response, err := c.process(path)
+		if err != nil {
+			code = 1
+		}
+		switch {
+		case many && err != nil:
+			enc.Encode(dirscan.Line{Path: filepath.ToSlash(path), Error: err.Error()})
+		case many:
+			enc.Encode(dirscan.Line{Path: filepath.ToSlash(path), Result: response})
+		case err != nil:
+			fmt.Fprintf(c.stderr, "file-meta: %s: %v\n", path, err)
+		default:
+			enc.SetIndent("", "  ")
+			enc.Encode(response)
+		}
+	}
+	return code
+}
+
+// process extracts one file, or stdin for "-"
+func (c *command) process(path string) (any, error) {
+	ctx := context.Background()
+	if c.cfg.ExtractionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.ExtractionTimeout)
+		defer cancel()
+	}
+
+	var result *metadata.Result
+	var err error
+	if path == "-" {
+		result, err = metadata.ExtractStream(ctx, c.stdin, metadata.FileInfo{Filename: c.name, Size: -1}, c.opts)
+	} else {
+		result, err = c.extractFile(ctx, path)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("extraction timed out after %s", c.cfg.ExtractionTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.present(result)
+}
+
+func (c *command) extractFile(ctx context.Context, path string) (*metadata.Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := &multipart.FileHeader{
+		Filename: filepath.Base(path),
+		Header:   textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}},
+	}
+	return metadata.ExtractWithOptions(ctx, file, header, c.opts)
+}
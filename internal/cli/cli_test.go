@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("Hello, World!\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "more.txt"), []byte("More text.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const helloSHA256 = "c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		wantCode int
+		// want is a substring of stdout, or of stderr when the command fails
+		want  string
+		lines int
+	}{
+		{"extract a file", []string{"extract", notes}, "", 0, `"checksum_sha256": "` + helloSHA256 + `"`, 0},
+		{"extract stdin", []string{"extract", "-name", "notes.txt", "-version", "v2"}, "Hello, World!\n", 0, `"extension": "txt"`, 0},
+		{"extract fields", []string{"extract", "-fields", "size_bytes", notes}, "", 0, `"size_bytes": 14`, 0},
+		{"extract a directory", []string{"extract", dir}, "", 0, `"path":"` + filepath.ToSlash(notes) + `"`, 2},
+		{"extract several", []string{"extract", notes, filepath.Join(dir, "gone.txt")}, "", 1, `"error":"open `, 2},
+		{"extract a missing file", []string{"extract", filepath.Join(dir, "gone.txt")}, "", 1, "no such file", 0},
+		{"unknown version", []string{"extract", "-version", "v9", notes}, "", 2, `unknown version "v9"`, 0},
+		{"unknown profile", []string{"extract", "-profile", "nope", notes}, "", 2, `unknown profile "nope"`, 0},
+		{"hash", []string{"hash", "-ndjson", notes}, "", 0, `{"size_bytes":14,"checksum_sha256":"` + helloSHA256 + `"`, 1},
+		{"hash a key", []string{"hash-key"}, "Hello, World!\n", 0, "sha256:dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f\n", 0},
+		{"hash no key", []string{"hash-key"}, "", 2, "no API key", 0},
+		{"help", []string{"help"}, "", 0, "file-meta extract", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code, ok := Run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if !ok {
+				t.Fatal("Run() didn't recognize the subcommand")
+			}
+			if code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %s", code, tt.wantCode, stderr.String())
+			}
+
+			output := stdout.String()
+			if tt.wantCode != 0 && stdout.Len() == 0 {
+				output = stderr.String()
+			}
+			if !strings.Contains(output, tt.want) {
+				t.Errorf("output = %s, want it to contain %s", output, tt.want)
+			}
+			if tt.lines == 0 {
+				return
+			}
+			lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+			if len(lines) != tt.lines {
+				t.Fatalf("got %d lines, want %d", len(lines), tt.lines)
+			}
+			for _, line := range lines {
+				if !json.Valid([]byte(line)) {
+					t.Errorf("line %s is not JSON", line)
+				}
+			}
+		})
+	}
+
+	for _, args := range [][]string{nil, {"-worker"}, {"serve"}} {
+		if _, ok := Run(args, nil, nil, nil); ok {
+			t.Errorf("Run(%q) ran a subcommand, want the server", args)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	t.Setenv("PORT", u.Port())
+
+	if code, _ := Run([]string{"health"}, nil, io.Discard, io.Discard); code != 0 {
+		t.Errorf("exit code for a healthy server = %d, want 0", code)
+	}
+	healthy = false
+	if code, _ := Run([]string{"health"}, nil, io.Discard, io.Discard); code != 1 {
+		t.Errorf("exit code for an unhealthy server = %d, want 1", code)
+	}
+
+	// A server with TLS_CERT_FILE speaks HTTPS
+	healthy = true
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+	u, _ = url.Parse(tlsServer.URL)
+	t.Setenv("PORT", u.Port())
+	t.Setenv("TLS_CERT_FILE", "server.crt")
+	if code, _ := Run([]string{"health"}, nil, io.Discard, io.Discard); code != 0 {
+		t.Errorf("exit code for a healthy HTTPS server = %d, want 0", code)
+	}
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "files.example.com")
+	if code, _ := Run([]string{"health"}, nil, io.Discard, io.Discard); code != 0 {
+		t.Errorf("exit code for a healthy HTTPS server with ACME certificates = %d, want 0", code)
+	}
+}
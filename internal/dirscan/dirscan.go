@@ -0,0 +1,148 @@
+// Package dirscan extracts metadata for every file under a server-side
+// directory, for backfilling archives that are already on disk. Only
+// directories under an allowlist can be scanned.
+package dirscan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"file-meta/internal/logger"
+)
+
+// ErrNotAllowed is returned for a directory outside the allowlist
+var ErrNotAllowed = errors.New("directory is not under an allowed directory")
+
+// ExtractFunc extracts metadata for the file at path
+type ExtractFunc func(ctx context.Context, path string) (any, error)
+
+// Line is one line of the NDJSON output: the file's path relative to the
+// scanned directory, and its result or why there is none
+type Line struct {
+	Path   string `json:"path"`
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Summary counts the files a scan went through
+type Summary struct {
+	Files  int
+	Failed int
+}
+
+// Resolve returns dir as an absolute path with symlinks resolved, if it is
+// one of allowed or under one
+func Resolve(dir string, allowed []string) (string, error) {
+	resolved, err := resolve(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range allowed {
+		root, err := resolve(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotAllowed, dir)
+}
+
+func resolve(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// Run walks root, extracting up to workers files at once, and writes a Line
+// for each regular file to w in the order they finish. Symlinks are
+// skipped, so a scan never leaves root. Files that fail are reported in
+// their line; Run only fails if root can't be walked or w can't be written.
+// It stops early when ctx is done.
+func Run(ctx context.Context, root string, workers int, extract ExtractFunc, w io.Writer, log *logger.Logger) (Summary, error) {
+	var summary Summary
+	var mu sync.Mutex
+	var writeErr error
+	enc := json.NewEncoder(w)
+	emit := func(line Line) {
+		mu.Lock()
+		defer mu.Unlock()
+		summary.Files++
+		if line.Error != "" {
+			summary.Failed++
+		}
+		if err := enc.Encode(line); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				rel, _ := filepath.Rel(root, path)
+				line := Line{Path: filepath.ToSlash(rel)}
+				result, err := extract(ctx, path)
+				if err != nil {
+					log.Warnf("Failed to extract %s: %v", log.Filename(path), err)
+					line.Error = err.Error()
+				} else {
+					line.Result = result
+				}
+				emit(line)
+			}
+		}()
+	}
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// An unreadable subdirectory doesn't stop the scan
+			log.Warnf("Skipping %s: %v", log.Filename(path), err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		mu.Lock()
+		failed := writeErr
+		mu.Unlock()
+		if failed != nil {
+			return failed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		select {
+		case paths <- path:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(paths)
+	wg.Wait()
+
+	if walkErr == nil {
+		walkErr = writeErr
+	}
+	return summary, walkErr
+}
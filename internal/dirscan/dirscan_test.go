@@ -0,0 +1,134 @@
+package dirscan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"file-meta/internal/logger"
+)
+
+// newTree creates a directory holding a.txt, sub/b.txt, a bad.txt the
+// extractor rejects, and a symlink out of it
+func newTree(t *testing.T) (root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	root = filepath.Join(base, "archive")
+	outside = filepath.Join(base, "private")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(root, "a.txt"):        "a",
+		filepath.Join(root, "sub", "b.txt"): "bb",
+		filepath.Join(root, "bad.txt"):      "",
+		filepath.Join(outside, "secret"):    "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return root, outside
+}
+
+func TestResolve(t *testing.T) {
+	root, outside := newTree(t)
+	base := filepath.Dir(root)
+
+	tests := []struct {
+		name    string
+		dir     string
+		allowed []string
+		want    string
+		wantErr bool
+	}{
+		{"allowed directory", root, []string{root}, root, false},
+		{"subdirectory", filepath.Join(root, "sub"), []string{root}, filepath.Join(root, "sub"), false},
+		{"parent", base, []string{root}, "", true},
+		{"sibling with a common prefix", root + "2", []string{root}, "", true},
+		{"dot-dot out", filepath.Join(root, "..", "private"), []string{root}, "", true},
+		{"symlink out", filepath.Join(root, "link"), []string{root}, "", true},
+		{"second allowed directory", outside, []string{root, outside}, outside, false},
+		{"nothing allowed", root, nil, "", true},
+	}
+
+	os.Mkdir(root+"2", 0o755)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.dir, tt.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrNotAllowed) {
+				t.Errorf("Resolve() error = %v, want ErrNotAllowed", err)
+			}
+			want := tt.want
+			if want != "" {
+				want, _ = filepath.EvalSymlinks(want)
+			}
+			if got != want {
+				t.Errorf("Resolve() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	root, _ := newTree(t)
+	extract := func(ctx context.Context, path string) (any, error) {
+		data, err := os.ReadFile(path)
+		if err != nil || len(data) == 0 {
+			return nil, errors.New("empty file")
+		}
+		return map[string]int{"size": len(data)}, nil
+	}
+
+	var out bytes.Buffer
+	summary, err := Run(context.Background(), root, 2, extract, &out, logger.New("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (Summary{Files: 3, Failed: 1}) {
+		t.Errorf("summary = %+v, want 3 files, 1 failed", summary)
+	}
+
+	var got []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var line struct {
+			Path   string         `json:"path"`
+			Result map[string]int `json:"result"`
+			Error  string         `json:"error"`
+		}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case line.Path == "bad.txt" && line.Error == "empty file":
+		case line.Path != "bad.txt" && line.Result["size"] > 0:
+		default:
+			t.Errorf("unexpected line %+v", line)
+		}
+		got = append(got, line.Path)
+	}
+	slices.Sort(got)
+	if want := []string{"a.txt", "bad.txt", "sub/b.txt"}; !slices.Equal(got, want) {
+		t.Errorf("paths = %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, root, 1, extract, &out, logger.New("error")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() after cancel error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package events runs extraction as a pipeline stage: it consumes S3 event
+// notifications from a queue, extracts metadata for each new object and
+// hands the result on to a webhook
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/sqs"
+	"file-meta/internal/storage"
+)
+
+// Queue delivers notifications; *sqs.Client implements it
+type Queue interface {
+	Receive(ctx context.Context, count int, wait, visibility time.Duration) ([]sqs.Message, error)
+	Delete(ctx context.Context, receiptHandle string) error
+}
+
+// Notifier receives the result of each extraction; *webhook.Client
+// implements it
+type Notifier interface {
+	Send(ctx context.Context, payload any) error
+}
+
+// ExtractFunc extracts and stores an object's metadata and returns the
+// result as the API would
+type ExtractFunc func(ctx context.Context, requestID, bucket, key string) (any, error)
+
+// Object is a new object named by an S3 event notification
+type Object struct {
+	Bucket    string
+	Key       string
+	Size      int64
+	EventName string
+	EventTime time.Time
+}
+
+// Extracted is the webhook payload for one object
+type Extracted struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	EventName string    `json:"event_name"`
+	EventTime time.Time `json:"event_time"`
+	Result    any       `json:"result"`
+}
+
+// receiveRetryDelay is how long the worker waits after a failed receive
+var receiveRetryDelay = 5 * time.Second
+
+// Worker processes notifications one at a time. A message is deleted once
+// every object in it is handled; after a failure it is left on the queue,
+// which redelivers it when the visibility timeout expires.
+type Worker struct {
+	queue      Queue
+	extract    ExtractFunc
+	notifier   Notifier
+	maxSize    int64
+	visibility time.Duration
+	log        *logger.Logger
+}
+
+// NewWorker creates a worker. notifier may be nil. Objects larger than
+// maxSize bytes are skipped. visibility is how long a received message
+// stays hidden from other consumers, or the queue's default when zero.
+func NewWorker(queue Queue, extract ExtractFunc, notifier Notifier, maxSize int64, visibility time.Duration, log *logger.Logger) *Worker {
+	return &Worker{
+		queue:      queue,
+		extract:    extract,
+		notifier:   notifier,
+		maxSize:    maxSize,
+		visibility: visibility,
+		log:        log,
+	}
+}
+
+// Run receives and processes notifications until ctx is done. A message
+// being processed then is left for redelivery.
+func (w *Worker) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		messages, err := w.queue.Receive(ctx, 10, 20*time.Second, w.visibility)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.log.Warnf("Failed to receive notifications, retrying in %s: %v", receiveRetryDelay, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(receiveRetryDelay):
+			}
+			continue
+		}
+
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return
+			}
+			w.handle(ctx, message)
+		}
+	}
+}
+
+// handle processes every object in a message and deletes it if they all
+// succeeded or can never succeed
+func (w *Worker) handle(ctx context.Context, message sqs.Message) {
+	objects, err := ParseNotification(message.Body)
+	if err != nil {
+		// Redelivery won't make it readable
+		w.log.Warnf("[%s] Dropping unreadable notification: %v", message.ID, err)
+	}
+	for _, obj := range objects {
+		if err := w.process(ctx, message.ID, obj); err != nil {
+			if ctx.Err() == nil {
+				w.log.Errorf("[%s] Failed to process s3://%s/%s, leaving it for redelivery: %v", message.ID, obj.Bucket, w.log.Filename(obj.Key), err)
+			}
+			return
+		}
+	}
+
+	if err := w.queue.Delete(ctx, message.ReceiptHandle); err != nil {
+		w.log.Warnf("[%s] Failed to delete notification: %v", message.ID, err)
+	}
+}
+
+// process extracts one object and notifies the webhook
+func (w *Worker) process(ctx context.Context, id string, obj Object) error {
+	if w.maxSize > 0 && obj.Size > w.maxSize {
+		w.log.Warnf("[%s] Skipping s3://%s/%s: %d bytes is over the size limit", id, obj.Bucket, w.log.Filename(obj.Key), obj.Size)
+		return nil
+	}
+
+	result, err := w.extract(ctx, id, obj.Bucket, obj.Key)
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrChanged):
+		// A later notification covers whatever replaced it
+		w.log.Infof("[%s] Skipping s3://%s/%s: deleted or replaced since the notification", id, obj.Bucket, w.log.Filename(obj.Key))
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if w.notifier != nil {
+		err := w.notifier.Send(ctx, Extracted{
+			Bucket:    obj.Bucket,
+			Key:       obj.Key,
+			EventName: obj.EventName,
+			EventTime: obj.EventTime,
+			Result:    result,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.log.Infof("[%s] Extracted metadata for s3://%s/%s", id, obj.Bucket, w.log.Filename(obj.Key))
+	return nil
+}
+
+// ParseNotification returns the objects created in an S3 event
+// notification, which may arrive wrapped in an SNS notification. The test
+// event S3 sends when notifications are configured has no objects.
+func ParseNotification(body string) ([]Object, error) {
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+		Event   string `json:"Event"`
+		Records []struct {
+			EventSource string    `json:"eventSource"`
+			EventName   string    `json:"eventName"`
+			EventTime   time.Time `json:"eventTime"`
+			S3          struct {
+				Bucket struct {
+					Name string `json:"name"`
+				} `json:"bucket"`
+				Object struct {
+					Key  string `json:"key"`
+					Size int64  `json:"size"`
+				} `json:"object"`
+			} `json:"s3"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	switch {
+	case envelope.Type == "Notification":
+		return ParseNotification(envelope.Message)
+	case envelope.Event == "s3:TestEvent":
+		return nil, nil
+	case envelope.Records == nil:
+		return nil, fmt.Errorf("not an S3 event notification")
+	}
+
+	var objects []Object
+	for _, record := range envelope.Records {
+		if record.EventSource != "aws:s3" || !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+		// Keys are form-encoded, with spaces as +
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object key %q: %w", record.S3.Object.Key, err)
+		}
+		objects = append(objects, Object{
+			Bucket:    record.S3.Bucket.Name,
+			Key:       key,
+			Size:      record.S3.Object.Size,
+			EventName: record.EventName,
+			EventTime: record.EventTime,
+		})
+	}
+	return objects, nil
+}
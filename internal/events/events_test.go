@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/sqs"
+	"file-meta/internal/storage"
+)
+
+func TestParseNotification(t *testing.T) {
+	record := `{"Records": [{"eventSource": "aws:s3", "eventName": "ObjectCreated:Put", "eventTime": "2024-01-02T03:04:05.000Z",` +
+		`"s3": {"bucket": {"name": "uploads"}, "object": {"key": "photos/my+cat%281%29.jpg", "size": 1024}}}]}`
+	sns := fmt.Sprintf(`{"Type": "Notification", "Message": %q}`, record)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{"object created", record, []string{"uploads/photos/my cat(1).jpg"}, false},
+		{"through SNS", sns, []string{"uploads/photos/my cat(1).jpg"}, false},
+		{"test event", `{"Service": "Amazon S3", "Event": "s3:TestEvent", "Bucket": "uploads"}`, nil, false},
+		{"object removed", `{"Records": [{"eventSource": "aws:s3", "eventName": "ObjectRemoved:Delete"}]}`, nil, false},
+		{"other JSON", `{"hello": "world"}`, nil, true},
+		{"not JSON", `hello`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := ParseNotification(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, obj := range objects {
+				got = append(got, obj.Bucket+"/"+obj.Key)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("objects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	objects, _ := ParseNotification(record)
+	if objects[0].Size != 1024 || objects[0].EventName != "ObjectCreated:Put" || objects[0].EventTime.Year() != 2024 {
+		t.Errorf("object = %+v", objects[0])
+	}
+}
+
+// fakeQueue hands out its messages once, then cancels the worker
+type fakeQueue struct {
+	messages []sqs.Message
+	deleted  []string
+	cancel   context.CancelFunc
+}
+
+func (q *fakeQueue) Receive(ctx context.Context, count int, wait, visibility time.Duration) ([]sqs.Message, error) {
+	if q.messages == nil {
+		q.cancel()
+		return nil, ctx.Err()
+	}
+	messages := q.messages
+	q.messages = nil
+	return messages, nil
+}
+
+func (q *fakeQueue) Delete(ctx context.Context, receiptHandle string) error {
+	q.deleted = append(q.deleted, receiptHandle)
+	return nil
+}
+
+// fakeNotifier records payloads and fails while err is set
+type fakeNotifier struct {
+	sent []Extracted
+	err  error
+}
+
+func (n *fakeNotifier) Send(ctx context.Context, payload any) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, payload.(Extracted))
+	return nil
+}
+
+func TestWorker(t *testing.T) {
+	created := func(key string, size int) string {
+		return fmt.Sprintf(`{"Records": [{"eventSource": "aws:s3", "eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "b"}, "object": {"key": %q, "size": %d}}}]}`, key, size)
+	}
+	extract := func(ctx context.Context, requestID, bucket, key string) (any, error) {
+		switch key {
+		case "gone.txt":
+			return nil, storage.ErrNotFound
+		case "flaky.txt":
+			return nil, errors.New("storage returned status 503")
+		}
+		return map[string]string{"filename": key}, nil
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		notifyErr   error
+		wantDeleted bool
+		wantSent    int
+	}{
+		{"extracted", created("notes.txt", 10), nil, true, 1},
+		{"over the size limit", created("huge.bin", 1<<30), nil, true, 0},
+		{"deleted since", created("gone.txt", 10), nil, true, 0},
+		{"storage failure", created("flaky.txt", 10), nil, false, 0},
+		{"webhook failure", created("notes.txt", 10), errors.New("webhook returned status 500"), false, 0},
+		{"unreadable", `not json`, nil, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			queue := &fakeQueue{messages: []sqs.Message{{ID: "m1", ReceiptHandle: "h1", Body: tt.body}}, cancel: cancel}
+			notifier := &fakeNotifier{err: tt.notifyErr}
+
+			NewWorker(queue, extract, notifier, 1<<20, 0, logger.New("error")).Run(ctx)
+
+			if deleted := len(queue.deleted) == 1; deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if len(notifier.sent) != tt.wantSent {
+				t.Errorf("sent %d payloads, want %d", len(notifier.sent), tt.wantSent)
+			}
+			if tt.wantSent > 0 && (notifier.sent[0].Key != "notes.txt" || notifier.sent[0].Result == nil) {
+				t.Errorf("payload = %+v", notifier.sent[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package filepolicy accepts or rejects files by their detected MIME type and
+// extension, so a deployment can double as an upload policy gate.
+package filepolicy
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"file-meta/internal/metadata"
+)
+
+// Reasons a Violation gives for rejecting a file
+const (
+	ReasonDeniedType          = "denied_mime_type"
+	ReasonTypeNotAllowed      = "mime_type_not_allowed"
+	ReasonDeniedExtension     = "denied_extension"
+	ReasonExtensionNotAllowed = "extension_not_allowed"
+)
+
+// Violation is the error for a file the policy rejects
+type Violation struct {
+	Reason    string
+	MIMEType  string
+	Extension string
+}
+
+func (v *Violation) Error() string {
+	switch {
+	case v.Reason == ReasonDeniedType || v.Reason == ReasonTypeNotAllowed:
+		if v.MIMEType == "" {
+			return "files of unknown type are not accepted"
+		}
+		return fmt.Sprintf("files of type %s are not accepted", v.MIMEType)
+	case v.Extension == "":
+		return "files without an extension are not accepted"
+	default:
+		return fmt.Sprintf("files with extension .%s are not accepted", v.Extension)
+	}
+}
+
+// Policy decides which files are accepted. Denied types and extensions are
+// rejected; when allowed ones are listed, anything else is rejected too.
+type Policy struct {
+	allowedTypes      []string
+	deniedTypes       []string
+	allowedExtensions []string
+	deniedExtensions  []string
+}
+
+// New creates a policy from lists of MIME types, which may end in /* to
+// match every subtype, and extensions with or without the dot. It returns
+// nil, which accepts every file, when all the lists are empty.
+func New(allowedTypes, deniedTypes, allowedExtensions, deniedExtensions []string) *Policy {
+	if len(allowedTypes)+len(deniedTypes)+len(allowedExtensions)+len(deniedExtensions) == 0 {
+		return nil
+	}
+	return &Policy{
+		allowedTypes:      normalize(allowedTypes, normalizeType),
+		deniedTypes:       normalize(deniedTypes, normalizeType),
+		allowedExtensions: normalize(allowedExtensions, normalizeExtension),
+		deniedExtensions:  normalize(deniedExtensions, normalizeExtension),
+	}
+}
+
+// Check returns a *Violation if a file named filename whose detected type
+// is mimeType is not accepted
+func (p *Policy) Check(mimeType, filename string) error {
+	if p == nil {
+		return nil
+	}
+	mimeType = metadata.NormalizeMIME(mimeType)
+	ext := normalizeExtension(filepath.Ext(filename))
+
+	switch {
+	case slices.ContainsFunc(p.deniedTypes, matchesType(mimeType)):
+		return &Violation{Reason: ReasonDeniedType, MIMEType: mimeType, Extension: ext}
+	case slices.Contains(p.deniedExtensions, ext) && ext != "":
+		return &Violation{Reason: ReasonDeniedExtension, MIMEType: mimeType, Extension: ext}
+	case len(p.allowedTypes) > 0 && !slices.ContainsFunc(p.allowedTypes, matchesType(mimeType)):
+		return &Violation{Reason: ReasonTypeNotAllowed, MIMEType: mimeType, Extension: ext}
+	case len(p.allowedExtensions) > 0 && !slices.Contains(p.allowedExtensions, ext):
+		return &Violation{Reason: ReasonExtensionNotAllowed, MIMEType: mimeType, Extension: ext}
+	}
+	return nil
+}
+
+// matchesType returns a function reporting whether a pattern such as
+// image/png or image/* matches mimeType
+func matchesType(mimeType string) func(pattern string) bool {
+	return func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			return prefix == "*" || strings.HasPrefix(mimeType, prefix+"/")
+		}
+		return pattern == mimeType
+	}
+}
+
+// normalize applies fn to each value
+func normalize(values []string, fn func(string) string) []string {
+	normalized := make([]string, len(values))
+	for i, value := range values {
+		normalized[i] = fn(value)
+	}
+	return normalized
+}
+
+// normalizeType lowercases a MIME type pattern and resolves known aliases,
+// so application/x-msdownload matches the type detected for a Windows
+// executable
+func normalizeType(pattern string) string {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.ToLower(strings.TrimSpace(pattern))
+	}
+	return metadata.NormalizeMIME(pattern)
+}
+
+// normalizeExtension lowercases an extension and drops its dot
+func normalizeExtension(ext string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+}
@@ -0,0 +1,42 @@
+package filepolicy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     *Policy
+		mimeType   string
+		filename   string
+		wantReason string
+	}{
+		{"no policy", New(nil, nil, nil, nil), "application/x-executable", "a.out", ""},
+		{"denied type", New(nil, []string{"application/x-executable"}, nil, nil), "application/x-executable", "tool", ReasonDeniedType},
+		{"denied type alias", New(nil, []string{"application/x-msdownload"}, nil, nil), "application/vnd.microsoft.portable-executable", "setup.exe", ReasonDeniedType},
+		{"denied extension", New(nil, nil, nil, []string{".EXE"}), "application/octet-stream", "setup.exe", ReasonDeniedExtension},
+		{"allowed wildcard", New([]string{"image/*"}, nil, nil, nil), "image/png", "photo.png", ""},
+		{"type not allowed", New([]string{"image/*"}, nil, nil, nil), "application/pdf", "photo.png", ReasonTypeNotAllowed},
+		{"type parameters ignored", New([]string{"text/plain"}, nil, nil, nil), "text/plain; charset=utf-8", "notes.txt", ""},
+		{"extension not allowed", New(nil, nil, []string{"jpg", "png"}, nil), "image/gif", "photo.gif", ReasonExtensionNotAllowed},
+		{"missing extension not allowed", New(nil, nil, []string{"png"}, nil), "image/png", "photo", ReasonExtensionNotAllowed},
+		{"deny beats allow", New([]string{"image/*"}, []string{"image/svg+xml"}, nil, nil), "image/svg+xml", "logo.svg", ReasonDeniedType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.mimeType, tt.filename)
+			var violation *Violation
+			switch {
+			case tt.wantReason == "" && err != nil:
+				t.Errorf("Check() error = %v, want none", err)
+			case tt.wantReason != "" && !errors.As(err, &violation):
+				t.Errorf("Check() error = %v, want a violation", err)
+			case tt.wantReason != "" && violation.Reason != tt.wantReason:
+				t.Errorf("Reason = %q, want %q", violation.Reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Package gcs reads objects from Google Cloud Storage through its XML API,
+// authorized with OAuth2 tokens for a service account or by a signed URL
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"file-meta/internal/storage"
+)
+
+const (
+	// defaultEndpoint serves the XML API
+	defaultEndpoint = "https://storage.googleapis.com"
+
+	// defaultTokenURI is Google's OAuth2 token endpoint
+	defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+	// readOnlyScope is the only scope requested for tokens
+	readOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+	// maxTokenResponseSize bounds how much of a token response is read
+	maxTokenResponseSize = 64 * 1024
+)
+
+// ServiceAccount holds the fields of a service account key file the client
+// uses
+type ServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// LoadServiceAccount reads a service account key file, as downloaded from
+// the Google Cloud console
+func LoadServiceAccount(path string) (*ServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var account ServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account file: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("service account file has no client_email or private_key")
+	}
+	return &account, nil
+}
+
+// Client reads objects from Cloud Storage. It is the storage.Provider for
+// gs:// references.
+type Client struct {
+	endpoint  *url.URL
+	custom    bool
+	account   *ServiceAccount
+	key       *rsa.PrivateKey
+	tokenURI  string
+	chunkSize int64
+	http      *http.Client
+	now       func() time.Time
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient creates a client. Without a service account only signed URLs
+// can be read. An empty endpoint means Google's; any other endpoint is
+// also accepted in signed URLs. Each ranged GET reads up to chunkSize bytes
+// and is bounded by timeout.
+func NewClient(account *ServiceAccount, endpoint string, chunkSize int64, timeout time.Duration) (*Client, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+	custom, err := storage.ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		endpoint:  custom,
+		custom:    custom != nil,
+		account:   account,
+		chunkSize: chunkSize,
+		http:      storage.NewHTTPClient(timeout),
+		now:       time.Now,
+	}
+	if c.endpoint == nil {
+		c.endpoint, _ = url.Parse(defaultEndpoint)
+	}
+	if account != nil {
+		if c.key, err = parsePrivateKey(account.PrivateKey); err != nil {
+			return nil, err
+		}
+		c.tokenURI = account.TokenURI
+		if c.tokenURI == "" {
+			c.tokenURI = defaultTokenURI
+		}
+	}
+	return c, nil
+}
+
+// parsePrivateKey parses a PEM RSA key in PKCS #8 or PKCS #1 form
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("private_key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private_key is not an RSA key")
+	}
+	return key, nil
+}
+
+// Open starts reading an object with the service account's credentials
+func (c *Client) Open(ctx context.Context, bucket, name string) (*storage.Object, error) {
+	if c.account == nil {
+		return nil, storage.ErrNoCredentials
+	}
+	if bucket == "" || name == "" {
+		return nil, fmt.Errorf("bucket and object name are required")
+	}
+
+	objectPath := strings.TrimSuffix(c.endpoint.Path, "/") + "/" + bucket + "/" + name
+	u := &url.URL{Scheme: c.endpoint.Scheme, Host: c.endpoint.Host, Path: objectPath, RawPath: storage.EscapePath(objectPath)}
+	return c.open(ctx, u, true, name)
+}
+
+// OpenURL starts reading an object through a signed URL on Cloud Storage
+// or the configured endpoint
+func (c *Client) OpenURL(ctx context.Context, u *url.URL) (*storage.Object, error) {
+	if !(c.custom && storage.SameEndpoint(u, c.endpoint)) && !storage.HostAllowed(u, "storage.googleapis.com") {
+		return nil, storage.ErrURLNotAllowed
+	}
+	return c.open(ctx, u, false, u.Path)
+}
+
+func (c *Client) open(ctx context.Context, u *url.URL, authorize bool, name string) (*storage.Object, error) {
+	return storage.OpenRanges(ctx, c.http, c.chunkSize, name, func(ctx context.Context, start, end int64, etag string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		if authorize {
+			token, err := c.accessToken(ctx)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req, nil
+	})
+}
+
+// accessToken returns a cached OAuth2 token, exchanging a signed JWT for a
+// new one when it is about to expire
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.token != "" && now.Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	assertion, err := c.assertion(now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxTokenResponseSize)).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid token response: %v", err)
+	}
+
+	// Refresh a minute early so a token doesn't expire mid-request
+	c.token = token.AccessToken
+	c.tokenExpiry = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// assertion returns a JWT, signed with the service account's key, that
+// requests a read-only token for an hour
+func (c *Client) assertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   c.account.ClientEmail,
+		"scope": readOnlyScope,
+		"aud":   c.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
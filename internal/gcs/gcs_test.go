@@ -0,0 +1,125 @@
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/internal/storage"
+)
+
+func TestOpen(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	content := "Hello, World!\nRead from Cloud Storage.\n"
+	tokenRequests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			parts := strings.Split(r.FormValue("assertion"), ".")
+			claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+				t.Errorf("assertion signature: %v", err)
+			}
+			var claimSet map[string]any
+			json.Unmarshal(claims, &claimSet)
+			if claimSet["iss"] != "reader@project.iam.gserviceaccount.com" || claimSet["aud"] != server.URL+"/token" || claimSet["scope"] != readOnlyScope {
+				t.Errorf("claims = %v", claimSet)
+			}
+			io.WriteString(w, `{"access_token": "token-1", "expires_in": 3600, "token_type": "Bearer"}`)
+			return
+		}
+
+		if r.URL.Path != "/bucket/photos/cat 1.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(content)-1)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, content[start:end+1])
+	}))
+	defer server.Close()
+
+	// The key file as downloaded from the console
+	keyFile := filepath.Join(t.TempDir(), "key.json")
+	data, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "reader@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	os.WriteFile(keyFile, data, 0o600)
+	account, err := LoadServiceAccount(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(account, server.URL, 16, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := c.Open(context.Background(), "bucket", "photos/cat 1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	got, err := io.ReadAll(obj)
+	if err != nil || string(got) != content || obj.Name != "cat 1.jpg" {
+		t.Errorf("object %q = %q, %v", obj.Name, got, err)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("%d token requests, want 1 reused across ranges", tokenRequests)
+	}
+
+	if _, err := c.Open(context.Background(), "bucket", "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Open(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	c, err := NewClient(nil, "", 1<<20, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Open(context.Background(), "bucket", "object"); !errors.Is(err, storage.ErrNoCredentials) {
+		t.Errorf("Open() without a service account error = %v, want ErrNoCredentials", err)
+	}
+	for _, raw := range []string{"https://example.com/bucket/object", "http://storage.googleapis.com/bucket/object"} {
+		u, _ := url.Parse(raw)
+		if _, err := c.OpenURL(context.Background(), u); !errors.Is(err, storage.ErrURLNotAllowed) {
+			t.Errorf("OpenURL(%s) error = %v, want ErrURLNotAllowed", raw, err)
+		}
+	}
+}
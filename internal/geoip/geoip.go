@@ -0,0 +1,92 @@
+// Package geoip looks up the country and autonomous system of client IPs in
+// local MaxMind DB files, such as GeoLite2-Country and GeoLite2-ASN, so
+// requests can be placed without calling an outside service.
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+)
+
+// Location is what the databases tell of an IP. Empty fields are unknown.
+type Location struct {
+	Country      string // ISO 3166-1 alpha-2 code
+	ASN          uint32
+	Organization string // of the autonomous system
+}
+
+// AS returns the autonomous system as "AS<number>", or "" if unknown
+func (l Location) AS() string {
+	if l.ASN == 0 {
+		return ""
+	}
+	return "AS" + strconv.FormatUint(uint64(l.ASN), 10)
+}
+
+// DB looks IPs up in one or more databases, merging what they know
+type DB struct {
+	dbs []*mmdb
+}
+
+// Open reads the databases at paths into memory
+func Open(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GeoIP database: %w", err)
+		}
+		m, err := parseMMDB(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+		}
+		db.dbs = append(db.dbs, m)
+	}
+	return db, nil
+}
+
+// Types returns the database_type of each database, e.g. GeoLite2-Country
+func (db *DB) Types() []string {
+	types := make([]string, len(db.dbs))
+	for i, m := range db.dbs {
+		types[i] = m.dbType
+	}
+	return types
+}
+
+// Lookup returns what the databases know of ip. A database that fails to
+// decode is skipped.
+func (db *DB) Lookup(ip netip.Addr) Location {
+	var loc Location
+	for _, m := range db.dbs {
+		value, err := m.lookup(ip)
+		if err != nil {
+			continue
+		}
+		record, _ := value.(map[string]any)
+		if loc.Country == "" {
+			// The country the IP is in, or else the one its block is
+			// registered to
+			loc.Country = isoCode(record, "country")
+			if loc.Country == "" {
+				loc.Country = isoCode(record, "registered_country")
+			}
+		}
+		if loc.ASN == 0 {
+			if asn, ok := record["autonomous_system_number"].(uint64); ok && asn <= 1<<32-1 {
+				loc.ASN = uint32(asn)
+				loc.Organization, _ = record["autonomous_system_organization"].(string)
+			}
+		}
+	}
+	return loc
+}
+
+// isoCode returns the iso_code of the map at record[field]
+func isoCode(record map[string]any, field string) string {
+	country, _ := record[field].(map[string]any)
+	code, _ := country["iso_code"].(string)
+	return code
+}
@@ -0,0 +1,210 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// testNetwork is a network of a test database and its data
+type testNetwork struct {
+	prefix string
+	data   map[string]any
+}
+
+// encodeData encodes v in the data section format: maps, strings and
+// uint32s, plus rawData as is
+func encodeData(v any) []byte {
+	control := func(typ, size int) []byte {
+		var extra []byte
+		if size >= 29 {
+			size, extra = 29, []byte{byte(size - 29)}
+		}
+		out := []byte{byte(typ<<5 | size)}
+		if typ > 7 {
+			out = []byte{byte(size), byte(typ - 7)}
+		}
+		return append(out, extra...)
+	}
+	switch v := v.(type) {
+	case rawData:
+		return v
+	case string:
+		return append(control(typeString, len(v)), v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		return append(control(typeUint32, 4), b...)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out := control(typeMap, len(v))
+		for _, key := range keys {
+			out = append(out, encodeData(key)...)
+			out = append(out, encodeData(v[key])...)
+		}
+		return out
+	}
+	panic("unsupported test data")
+}
+
+// rawData is already encoded
+type rawData []byte
+
+// buildMMDB builds a database of networks with the given IP version and
+// record size. An IPv4 network in an IPv6 database goes under ::/96.
+func buildMMDB(t *testing.T, ipVersion, recordSize int, data []byte, networks []testNetwork) []byte {
+	t.Helper()
+	const empty = -1
+	type record struct{ node, data int }
+	nodes := [][2]record{{{node: empty, data: empty}, {node: empty, data: empty}}}
+	for _, network := range networks {
+		prefix := netip.MustParsePrefix(network.prefix)
+		var bits []byte
+		offset := 0
+		switch {
+		case prefix.Addr().Is6():
+			b := prefix.Addr().As16()
+			bits = b[:]
+		case ipVersion == 6:
+			b := prefix.Addr().As4()
+			bits, offset = append(make([]byte, 12), b[:]...), 96
+		default:
+			b := prefix.Addr().As4()
+			bits = b[:]
+		}
+		dataOffset := len(data)
+		data = append(data, encodeData(network.data)...)
+
+		node := 0
+		for i := 0; i < offset+prefix.Bits(); i++ {
+			bit := bits[i/8] >> (7 - i%8) & 1
+			if i == offset+prefix.Bits()-1 {
+				nodes[node][bit] = record{node: empty, data: dataOffset}
+				break
+			}
+			if nodes[node][bit].node == empty {
+				nodes = append(nodes, [2]record{{node: empty, data: empty}, {node: empty, data: empty}})
+				nodes[node][bit].node = len(nodes) - 1
+			}
+			node = nodes[node][bit].node
+		}
+	}
+
+	nodeCount := len(nodes)
+	value := func(r record) uint32 {
+		switch {
+		case r.node != empty:
+			return uint32(r.node)
+		case r.data != empty:
+			return uint32(nodeCount + dataSectionSeparator + r.data)
+		default:
+			return uint32(nodeCount)
+		}
+	}
+	var out []byte
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			out = append(out, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			out = append(out, byte(left>>16), byte(left>>8), byte(left), byte(left>>20&0xf0|right>>24&0x0f), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			out = binary.BigEndian.AppendUint32(out, left)
+			out = binary.BigEndian.AppendUint32(out, right)
+		}
+	}
+	out = append(out, make([]byte, dataSectionSeparator)...)
+	out = append(out, data...)
+	out = append(out, metadataMarker...)
+	return append(out, encodeData(map[string]any{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint32(recordSize),
+		"ip_version":    uint32(ipVersion),
+		"database_type": "Test",
+	})...)
+}
+
+func TestLookup(t *testing.T) {
+	// "GB" at offset 0, pointed to by a network's data
+	shared := encodeData("GB")
+	pointer := rawData{typePointer << 5, 0}
+	networks := []testNetwork{
+		{prefix: "203.0.113.0/24", data: map[string]any{"country": map[string]any{"iso_code": "US"}}},
+		{prefix: "198.51.100.0/25", data: map[string]any{"registered_country": map[string]any{"iso_code": pointer}}},
+		{prefix: "2001:db8::/32", data: map[string]any{"country": map[string]any{"iso_code": "DE"}, "autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Net"}},
+	}
+
+	tests := []struct {
+		ip   string
+		want Location
+	}{
+		{ip: "203.0.113.7", want: Location{Country: "US"}},
+		{ip: "::ffff:203.0.113.7", want: Location{Country: "US"}},
+		{ip: "198.51.100.1", want: Location{Country: "GB"}},
+		{ip: "198.51.100.200"},
+		{ip: "192.0.2.1"},
+		{ip: "2001:db8::1", want: Location{Country: "DE", ASN: 64500, Organization: "Example Net"}},
+	}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		file := filepath.Join(t.TempDir(), "test.mmdb")
+		if err := os.WriteFile(file, buildMMDB(t, 6, recordSize, shared, networks), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		db, err := Open(file)
+		if err != nil {
+			t.Fatalf("record size %d: %v", recordSize, err)
+		}
+		if types := db.Types(); len(types) != 1 || types[0] != "Test" {
+			t.Errorf("Types() = %v, want [Test]", types)
+		}
+		for _, tt := range tests {
+			if got := db.Lookup(netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("record size %d: Lookup(%s) = %+v, want %+v", recordSize, tt.ip, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLookupIPv4Database(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "asn.mmdb")
+	asn := buildMMDB(t, 4, 24, nil, []testNetwork{
+		{prefix: "203.0.113.0/24", data: map[string]any{"autonomous_system_number": uint32(64501)}},
+	})
+	if err := os.WriteFile(file, asn, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Lookup(netip.MustParseAddr("203.0.113.9")); got.AS() != "AS64501" {
+		t.Errorf("AS() = %q, want AS64501", got.AS())
+	}
+	if got := db.Lookup(netip.MustParseAddr("2001:db8::1")); got != (Location{}) {
+		t.Errorf("IPv6 lookup in an IPv4 database = %+v, want nothing", got)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	valid := buildMMDB(t, 4, 24, nil, []testNetwork{{prefix: "203.0.113.0/24", data: map[string]any{"country": map[string]any{"iso_code": "US"}}}})
+	for name, content := range map[string][]byte{
+		"not a database": []byte("hello"),
+		"truncated":      valid[len(valid)-20:],
+	} {
+		file := filepath.Join(t.TempDir(), "bad.mmdb")
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(file); err == nil {
+			t.Errorf("%s: Open() succeeded", name)
+		}
+	}
+}
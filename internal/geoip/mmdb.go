@@ -0,0 +1,311 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataMarker precedes the metadata map at the end of a database
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMetadataSize is how far from the end of a database the metadata may
+// start
+const maxMetadataSize = 128 << 10
+
+// dataSectionSeparator is the gap of zero bytes between the search tree
+// and the data section
+const dataSectionSeparator = 16
+
+// maxDataDepth bounds the nesting of maps and arrays decoded, so a corrupt
+// database can't exhaust the stack
+const maxDataDepth = 32
+
+var errCorrupt = errors.New("corrupt MaxMind database")
+
+// mmdb is a MaxMind DB file held in memory, as specified at
+// https://maxmind.github.io/MaxMind-DB/
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint // bits per record, 24, 28 or 32
+	ipVersion  uint
+	ipv4Start  uint // the node of ::/96, where IPv4 lookups begin
+	dbType     string
+}
+
+// parseMMDB reads a database from its file contents
+func parseMMDB(file []byte) (*mmdb, error) {
+	start := max(0, len(file)-maxMetadataSize)
+	marker := bytes.LastIndex(file[start:], metadataMarker)
+	if marker < 0 {
+		return nil, errors.New("not a MaxMind database: metadata marker missing")
+	}
+	metaStart := start + marker + len(metadataMarker)
+	meta, _, err := (&decoder{data: file[metaStart:]}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database metadata: %w", err)
+	}
+	fields, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errCorrupt
+	}
+
+	db := &mmdb{}
+	db.dbType, _ = fields["database_type"].(string)
+	nodeCount, ok1 := fields["node_count"].(uint64)
+	recordSize, ok2 := fields["record_size"].(uint64)
+	ipVersion, ok3 := fields["ip_version"].(uint64)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("invalid database metadata: node_count, record_size or ip_version missing")
+	}
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", ipVersion)
+	}
+	db.nodeCount, db.recordSize, db.ipVersion = uint(nodeCount), uint(recordSize), uint(ipVersion)
+
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+dataSectionSeparator > uint64(start+marker) {
+		return nil, errCorrupt
+	}
+	db.tree = file[:treeSize]
+	db.data = file[treeSize+dataSectionSeparator : start+marker]
+
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (db *mmdb) record(node, bit uint) uint {
+	size := db.recordSize / 4 // bytes per node
+	b := db.tree[node*size : (node+1)*size]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the data of the network ip is in, or nil if the database
+// has none
+func (db *mmdb) lookup(ip netip.Addr) (any, error) {
+	ip = ip.Unmap()
+	if ip.Is6() && db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	node, bits := uint(0), ip.AsSlice()
+	if ip.Is4() && db.ipVersion == 6 {
+		node = db.ipv4Start
+	}
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(bits[i/8]>>(7-i%8)&1))
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil
+	case node < db.nodeCount:
+		return nil, errCorrupt
+	}
+	offset := node - db.nodeCount - dataSectionSeparator
+	if offset >= uint(len(db.data)) {
+		return nil, errCorrupt
+	}
+	value, _, err := (&decoder{data: db.data}).decode(offset, 0)
+	return value, err
+}
+
+// Data section types
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+// decoder reads values of a data section: maps as map[string]any, arrays
+// as []any, unsigned integers as uint64 (uint128 as []byte), int32 as
+// int64, and floats as float64
+type decoder struct {
+	data []byte
+}
+
+// decode returns the value at offset and the offset after it
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDataDepth {
+		return nil, 0, errCorrupt
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		// Pointers lead to a value elsewhere, which is never another
+		// pointer; decoding carries on after the pointer itself
+		value, _, err := d.decode(size, depth+1)
+		return value, offset, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			if m[name], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, offset = append(a, value), next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errCorrupt
+	}
+	b, next := d.data[offset:offset+size], offset+size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown data type %d", errCorrupt, typ)
+	}
+}
+
+// control reads the control byte at offset: the type of the value and its
+// size, or for pointers the offset pointed to, and where the value starts
+func (d *decoder) control(offset uint) (typ, size, next uint, err error) {
+	read := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(d.data)) {
+			return nil, errCorrupt
+		}
+		b := d.data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	ctrl := b[0]
+	typ = uint(ctrl >> 5)
+	if typ == typeExtended {
+		if b, err = read(1); err != nil {
+			return 0, 0, 0, err
+		}
+		typ = 7 + uint(b[0])
+	}
+
+	if typ == typePointer {
+		n := uint(ctrl>>3&3) + 1
+		if b, err = read(n); err != nil {
+			return 0, 0, 0, err
+		}
+		var p uint
+		if n < 4 {
+			p = uint(ctrl & 7)
+		}
+		for _, c := range b {
+			p = p<<8 | uint(c)
+		}
+		switch n {
+		case 2:
+			p += 2048
+		case 3:
+			p += 526336
+		}
+		return typ, p, offset, nil
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, err = read(n); err != nil {
+			return 0, 0, 0, err
+		}
+		var extra uint
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+	return typ, size, offset, nil
+}
@@ -0,0 +1,597 @@
+// Package graphql parses GraphQL queries. It covers what clients need to
+// select fields from a read-only API: named or anonymous queries, aliases,
+// arguments, variables and nested selection sets. Fragments, directives and
+// mutations are rejected.
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operation is the query to execute
+type Operation struct {
+	Name       string
+	Variables  []VariableDefinition
+	Selections []*Field
+}
+
+// VariableDefinition declares a variable of the operation
+type VariableDefinition struct {
+	Name     string
+	Type     string
+	NonNull  bool
+	Default  Value
+	Position Position
+}
+
+// Field is a selected field
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]Value
+	Selections []*Field
+	Position   Position
+}
+
+// Key is the response key of the field: its alias, or else its name
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Value is an argument value: a literal (string, int64, float64, bool,
+// nil, []Value or map[string]Value), an enum value (Enum) or a variable
+// reference (Variable)
+type Value any
+
+// Variable refers to an operation variable
+type Variable string
+
+// Enum is an enum value literal
+type Enum string
+
+// Position is a location in the query, for error messages
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error is a syntax error or an unsupported feature
+type Error struct {
+	Message  string
+	Position Position
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Position.Line, e.Position.Column)
+}
+
+// Parse parses a query document and returns the operation named
+// operationName, or the only operation when operationName is empty
+func Parse(query, operationName string) (*Operation, error) {
+	p := &parser{src: query, line: 1, lineStart: 0}
+	p.next()
+
+	var operations []*Operation
+	for p.tok.kind != tokEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if len(operations) == 0 {
+		return nil, &Error{Message: "document has no operations", Position: p.tok.pos}
+	}
+	if operationName == "" {
+		if len(operations) > 1 {
+			return nil, &Error{Message: "operationName is required for a document with several operations", Position: Position{1, 1}}
+		}
+		return operations[0], nil
+	}
+	for _, op := range operations {
+		if op.Name == operationName {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("unknown operation %q", operationName), Position: Position{1, 1}}
+}
+
+// Resolve returns the argument's value with variables substituted from
+// vars, a decoded JSON object. Enum values become strings.
+func Resolve(v Value, vars map[string]any) any {
+	switch v := v.(type) {
+	case Variable:
+		return vars[string(v)]
+	case Enum:
+		return string(v)
+	case []Value:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = Resolve(item, vars)
+		}
+		return list
+	case map[string]Value:
+		object := make(map[string]any, len(v))
+		for key, item := range v {
+			object[key] = Resolve(item, vars)
+		}
+		return object
+	}
+	return v
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   Position
+}
+
+type parser struct {
+	src       string
+	offset    int
+	line      int
+	lineStart int
+	tok       token
+	err       error
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{}
+	if p.tok.kind == tokName {
+		switch p.tok.value {
+		case "query":
+			p.next()
+		case "mutation", "subscription":
+			return nil, p.errorf("%ss are not supported", p.tok.value)
+		case "fragment":
+			return nil, p.errorf("fragments are not supported")
+		default:
+			return nil, p.errorf("unexpected %q", p.tok.value)
+		}
+
+		if p.tok.kind == tokName {
+			op.Name = p.tok.value
+			p.next()
+		}
+		if p.isPunct("(") {
+			definitions, err := p.parseVariableDefinitions()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = definitions
+		}
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = selections
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions() ([]VariableDefinition, error) {
+	p.next() // (
+	var definitions []VariableDefinition
+	for !p.isPunct(")") {
+		pos := p.tok.pos
+		if !p.isPunct("$") {
+			return nil, p.errorf("expected a variable")
+		}
+		p.next()
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		typ, nonNull, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+
+		definition := VariableDefinition{Name: name, Type: typ, NonNull: nonNull, Position: pos}
+		if p.isPunct("=") {
+			p.next()
+			if definition.Default, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+	p.next() // )
+	return definitions, nil
+}
+
+// parseType parses a type reference such as [String!]! and returns it as
+// written, without the outer !, and whether it is non-null
+func (p *parser) parseType() (string, bool, error) {
+	var typ string
+	if p.isPunct("[") {
+		p.next()
+		inner, nonNull, err := p.parseType()
+		if err != nil {
+			return "", false, err
+		}
+		if nonNull {
+			inner += "!"
+		}
+		if err := p.expect("]"); err != nil {
+			return "", false, err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return "", false, err
+		}
+		typ = name
+	}
+
+	if p.isPunct("!") {
+		p.next()
+		return typ, true, nil
+	}
+	return typ, false, nil
+}
+
+func (p *parser) parseSelectionSet() ([]*Field, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*Field
+	for !p.isPunct("}") {
+		if p.isPunct("...") {
+			return nil, p.errorf("fragments are not supported")
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	p.next() // }
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return fields, nil
+}
+
+func (p *parser) parseField() (*Field, error) {
+	field := &Field{Position: p.tok.pos}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if p.isPunct(":") {
+		p.next()
+		field.Alias = name
+		if name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+
+	if p.isPunct("(") {
+		p.next()
+		field.Arguments = make(map[string]Value)
+		for !p.isPunct(")") {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseValue(false)
+			if err != nil {
+				return nil, err
+			}
+			field.Arguments[name] = value
+		}
+		p.next() // )
+	}
+
+	if p.isPunct("@") {
+		return nil, p.errorf("directives are not supported")
+	}
+
+	if p.isPunct("{") {
+		if field.Selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
+
+// parseValue parses a value; constant values can't reference variables
+func (p *parser) parseValue(constant bool) (Value, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokPunct && tok.value == "$" && !constant:
+		p.next()
+		name, err := p.expectName()
+		return Variable(name), err
+	case tok.kind == tokPunct && tok.value == "[":
+		p.next()
+		list := []Value{}
+		for !p.isPunct("]") {
+			item, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		p.next()
+		return list, nil
+	case tok.kind == tokPunct && tok.value == "{":
+		p.next()
+		object := make(map[string]Value)
+		for !p.isPunct("}") {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.parseValue(constant); err != nil {
+				return nil, err
+			}
+		}
+		p.next()
+		return object, nil
+	case tok.kind == tokString:
+		p.next()
+		return tok.value, nil
+	case tok.kind == tokInt:
+		p.next()
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("invalid integer %s", tok.value), Position: tok.pos}
+		}
+		return n, nil
+	case tok.kind == tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, &Error{Message: fmt.Sprintf("invalid float %s", tok.value), Position: tok.pos}
+		}
+		return f, nil
+	case tok.kind == tokName:
+		p.next()
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return Enum(tok.value), nil
+	}
+	return nil, p.errorf("expected a value")
+}
+
+func (p *parser) isPunct(value string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == value
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.isPunct(punct) {
+		return p.errorf("expected %q", punct)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.errorf("expected a name")
+	}
+	name := p.tok.value
+	p.next()
+	return name, nil
+}
+
+// errorf reports an error at the current token. A lexer error takes
+// precedence since it is what made the token unexpected.
+func (p *parser) errorf(format string, args ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	found := p.tok.value
+	if p.tok.kind == tokEOF {
+		found = "end of query"
+	}
+	return &Error{Message: fmt.Sprintf(format, args...) + fmt.Sprintf(", found %q", found), Position: p.tok.pos}
+}
+
+// next reads the next token into p.tok. Lexer errors end the token stream.
+func (p *parser) next() {
+	p.skipIgnored()
+	pos := Position{Line: p.line, Column: p.offset - p.lineStart + 1}
+	if p.offset >= len(p.src) || p.err != nil {
+		p.tok = token{kind: tokEOF, pos: pos}
+		return
+	}
+
+	c := p.src[p.offset]
+	switch {
+	case strings.HasPrefix(p.src[p.offset:], "..."):
+		p.offset += 3
+		p.tok = token{kind: tokPunct, value: "...", pos: pos}
+	case strings.IndexByte("!$():=@[]{|}", c) >= 0:
+		p.offset++
+		p.tok = token{kind: tokPunct, value: string(c), pos: pos}
+	case c == '_' || isLetter(c):
+		start := p.offset
+		for p.offset < len(p.src) && (p.src[p.offset] == '_' || isLetter(p.src[p.offset]) || isDigit(p.src[p.offset])) {
+			p.offset++
+		}
+		p.tok = token{kind: tokName, value: p.src[start:p.offset], pos: pos}
+	case c == '-' || isDigit(c):
+		p.tok = p.lexNumber(pos)
+	case c == '"':
+		p.tok = p.lexString(pos)
+	default:
+		p.fail(pos, fmt.Sprintf("unexpected character %q", c))
+	}
+}
+
+func (p *parser) lexNumber(pos Position) token {
+	start := p.offset
+	kind := tokInt
+	if p.src[p.offset] == '-' {
+		p.offset++
+	}
+	digits := func() int {
+		n := 0
+		for p.offset < len(p.src) && isDigit(p.src[p.offset]) {
+			p.offset++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		p.fail(pos, "invalid number")
+		return p.tok
+	}
+	if p.offset < len(p.src) && p.src[p.offset] == '.' {
+		kind = tokFloat
+		p.offset++
+		if digits() == 0 {
+			p.fail(pos, "invalid number")
+			return p.tok
+		}
+	}
+	if p.offset < len(p.src) && (p.src[p.offset] == 'e' || p.src[p.offset] == 'E') {
+		kind = tokFloat
+		p.offset++
+		if p.offset < len(p.src) && (p.src[p.offset] == '+' || p.src[p.offset] == '-') {
+			p.offset++
+		}
+		if digits() == 0 {
+			p.fail(pos, "invalid number")
+			return p.tok
+		}
+	}
+	return token{kind: kind, value: p.src[start:p.offset], pos: pos}
+}
+
+func (p *parser) lexString(pos Position) token {
+	if strings.HasPrefix(p.src[p.offset:], `"""`) {
+		p.fail(pos, "block strings are not supported")
+		return p.tok
+	}
+	p.offset++ // opening quote
+
+	var b strings.Builder
+	for p.offset < len(p.src) {
+		c := p.src[p.offset]
+		switch {
+		case c == '"':
+			p.offset++
+			return token{kind: tokString, value: b.String(), pos: pos}
+		case c == '\n' || c == '\r':
+			p.fail(pos, "unterminated string")
+			return p.tok
+		case c == '\\' && p.offset+1 < len(p.src):
+			escape := p.src[p.offset+1]
+			p.offset += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.offset+4 > len(p.src) {
+					p.fail(pos, "invalid unicode escape")
+					return p.tok
+				}
+				r, err := strconv.ParseUint(p.src[p.offset:p.offset+4], 16, 32)
+				if err != nil {
+					p.fail(pos, "invalid unicode escape")
+					return p.tok
+				}
+				b.WriteRune(rune(r))
+				p.offset += 4
+			default:
+				p.fail(pos, fmt.Sprintf("invalid escape \\%c", escape))
+				return p.tok
+			}
+		default:
+			b.WriteByte(c)
+			p.offset++
+		}
+	}
+	p.fail(pos, "unterminated string")
+	return p.tok
+}
+
+// skipIgnored skips whitespace, commas, comments and a byte order mark
+func (p *parser) skipIgnored() {
+	for p.offset < len(p.src) {
+		switch c := p.src[p.offset]; {
+		case c == '\n':
+			p.offset++
+			p.line++
+			p.lineStart = p.offset
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			p.offset++
+		case c == '#':
+			for p.offset < len(p.src) && p.src[p.offset] != '\n' {
+				p.offset++
+			}
+		case strings.HasPrefix(p.src[p.offset:], "\uFEFF"):
+			p.offset += len("\uFEFF")
+		default:
+			return
+		}
+	}
+}
+
+// fail records a lexer error and ends the token stream
+func (p *parser) fail(pos Position, message string) {
+	if p.err == nil {
+		p.err = &Error{Message: message, Position: pos}
+	}
+	p.tok = token{kind: tokEOF, pos: pos}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
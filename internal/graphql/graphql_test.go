@@ -0,0 +1,104 @@
+package graphql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	query := `
+		# Dashboard query
+		query Lookup($ids: [String!]!, $one: String = "abc") {
+			results(sha256: $ids) { mime: mime_type, checksums { sha256 } }
+			result(sha256: "a\"bé", limit: -1.5e2, flags: [true, null, ENUM], opts: {deep: 1}) { filename }
+		}`
+
+	op, err := Parse(query, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Name != "Lookup" || len(op.Variables) != 2 || len(op.Selections) != 2 {
+		t.Fatalf("operation = %+v", op)
+	}
+	if v := op.Variables[0]; v.Name != "ids" || v.Type != "[String!]" || !v.NonNull {
+		t.Errorf("variable = %+v, want ids: [String!]!", v)
+	}
+	if v := op.Variables[1]; v.NonNull || v.Default != "abc" {
+		t.Errorf("variable = %+v, want optional with default abc", v)
+	}
+
+	results := op.Selections[0]
+	if results.Arguments["sha256"] != Variable("ids") {
+		t.Errorf("arguments = %v, want $ids", results.Arguments)
+	}
+	if mime := results.Selections[0]; mime.Key() != "mime" || mime.Name != "mime_type" {
+		t.Errorf("aliased field = %+v", mime)
+	}
+	if got := results.Selections[1].Selections[0].Name; got != "sha256" {
+		t.Errorf("nested field = %q, want sha256", got)
+	}
+
+	args := op.Selections[1].Arguments
+	want := map[string]any{
+		"sha256": "a\"bé",
+		"limit":  -150.0,
+		"flags":  []any{true, nil, "ENUM"},
+		"opts":   map[string]any{"deep": int64(1)},
+	}
+	for name, value := range want {
+		if got := Resolve(args[name], nil); !reflect.DeepEqual(got, value) {
+			t.Errorf("argument %s = %#v, want %#v", name, got, value)
+		}
+	}
+	if got := Resolve(Variable("ids"), map[string]any{"ids": []any{"x"}}); !reflect.DeepEqual(got, []any{"x"}) {
+		t.Errorf("Resolve(variable) = %v", got)
+	}
+}
+
+func TestParseOperationName(t *testing.T) {
+	query := `query A { a } query B { b }`
+
+	if _, err := Parse(query, ""); err == nil {
+		t.Error("Parse() of several operations without a name succeeded")
+	}
+	op, err := Parse(query, "B")
+	if err != nil || op.Selections[0].Name != "b" {
+		t.Errorf("Parse(B) = %+v, %v", op, err)
+	}
+	if _, err := Parse(query, "C"); err == nil {
+		t.Error("Parse(C) succeeded")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantMsg string
+		line    int
+	}{
+		{"empty", "", "no operations", 1},
+		{"mutation", "mutation { a }", "mutations are not supported", 1},
+		{"fragment spread", "{ a { ...F } }", "fragments are not supported", 1},
+		{"directive", "{ a @skip(if: true) }", "directives are not supported", 1},
+		{"unclosed", "{\n  a {\n b", "expected a name", 3},
+		{"unterminated string", `{ a(x: "abc) }`, "unterminated string", 1},
+		{"bad character", "{ a ; }", "unexpected character", 1},
+		{"variable in default", "query ($a: String = $b) { a }", "expected a value", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query, "")
+			var parseErr *Error
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse() error = %v, want *Error", err)
+			}
+			if !strings.Contains(parseErr.Message, tt.wantMsg) || parseErr.Position.Line != tt.line {
+				t.Errorf("Parse() error = %v, want %q on line %d", err, tt.wantMsg, tt.line)
+			}
+		})
+	}
+}
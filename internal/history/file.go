@@ -0,0 +1,265 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+// FileStore records entries as JSON lines appended to one local file, for
+// single instances without a database. It also serves as a result store:
+// the latest entry for each API key and SHA256 is indexed when the file is
+// opened.
+type FileStore struct {
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	latest map[string]int64   // key ID/SHA256 to the offset of its latest line
+	byKey  map[string][]int64 // API key ID to the offsets of its lines
+	first  map[string]int64   // API key ID and SHA256 to their first line
+
+	// API key ID to the perceptual hashes of its images by SHA256
+	phashes map[string]map[string]uint64
+}
+
+type fileLine struct {
+	CreatedAt time.Time        `json:"created_at"`
+	APIKeyID  string           `json:"api_key_id"`
+	RequestID string           `json:"request_id"`
+	Result    *metadata.Result `json:"result"`
+}
+
+func (l fileLine) item() Item {
+	return Item{
+		CreatedAt: l.CreatedAt,
+		RequestID: l.RequestID,
+		Filename:  l.Result.Filename,
+		SHA256:    l.Result.SHA256,
+		MimeType:  l.Result.MimeType,
+		SizeBytes: l.Result.SizeBytes,
+	}
+}
+
+// OpenFile opens or creates the history file at path and indexes it. A
+// line cut short by a crash is removed.
+func OpenFile(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		file:    file,
+		latest:  make(map[string]int64),
+		byKey:   make(map[string][]int64),
+		first:   make(map[string]int64),
+		phashes: make(map[string]map[string]uint64),
+	}
+	if err := s.index(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) index() error {
+	r := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry struct {
+			APIKeyID string `json:"api_key_id"`
+			Result   struct {
+				SHA256 string `json:"checksum_sha256"`
+				Image  *struct {
+					PHash string `json:"phash"`
+				} `json:"image"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("line at offset %d: %w", offset, err)
+		}
+		var phash string
+		if entry.Result.Image != nil {
+			phash = entry.Result.Image.PHash
+		}
+		s.add(entry.APIKeyID, entry.Result.SHA256, phash, offset)
+		offset += int64(len(line))
+	}
+
+	// Drop a partial last line; new lines are written from here
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	s.size = offset
+	return nil
+}
+
+// Record appends entry
+func (s *FileStore) Record(ctx context.Context, entry Entry) error {
+	keyID := KeyID(entry.APIKey)
+	line, err := json.Marshal(fileLine{
+		CreatedAt: entry.CreatedAt,
+		APIKeyID:  keyID,
+		RequestID: entry.RequestID,
+		Result:    entry.Result,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n, err := s.file.WriteAt(line, s.size); err != nil {
+		// Leave a partial line for the next write to overwrite
+		return fmt.Errorf("wrote %d of %d bytes: %w", n, len(line), err)
+	}
+	var phash string
+	if entry.Result.Image != nil {
+		phash = entry.Result.Image.PHash
+	}
+	s.add(keyID, entry.Result.SHA256, phash, s.size)
+	s.size += int64(len(line))
+	return nil
+}
+
+// add indexes the line at offset
+func (s *FileStore) add(keyID, sha256, phash string, offset int64) {
+	s.latest[keyID+"/"+sha256] = offset
+	s.byKey[keyID] = append(s.byKey[keyID], offset)
+	if _, ok := s.first[keyID+"/"+sha256]; !ok {
+		s.first[keyID+"/"+sha256] = offset
+	}
+	if hash, err := metadata.ParsePerceptualHash(phash); err == nil {
+		if s.phashes[keyID] == nil {
+			s.phashes[keyID] = make(map[string]uint64)
+		}
+		s.phashes[keyID][sha256] = hash
+	}
+}
+
+// Get returns the latest result recorded for sha256 with the API key with
+// keyID, or nil if there is none
+func (s *FileStore) Get(ctx context.Context, keyID, sha256 string) (*metadata.Result, error) {
+	s.mu.Lock()
+	offset, ok := s.latest[keyID+"/"+sha256]
+	size := s.size
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	entry, err := s.read(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Result, nil
+}
+
+// List returns up to limit entries recorded for the API key with keyID,
+// older than the cursor
+func (s *FileStore) List(ctx context.Context, keyID string, limit int, cursor string) (Page, error) {
+	before, err := parseCursor(cursor)
+	if err != nil {
+		return Page{}, err
+	}
+
+	s.mu.Lock()
+	offsets := s.byKey[keyID]
+	size := s.size
+	s.mu.Unlock()
+
+	// Offsets only grow, so the entries before the cursor are a prefix
+	end := len(offsets)
+	if before >= 0 {
+		end = sort.Search(len(offsets), func(i int) bool { return offsets[i] >= before })
+	}
+	start := max(end-limit, 0)
+
+	page := Page{Items: make([]Item, 0, end-start)}
+	for i := end - 1; i >= start; i-- {
+		entry, err := s.read(offsets[i], size)
+		if err != nil {
+			return Page{}, err
+		}
+		page.Items = append(page.Items, entry.item())
+	}
+	if start > 0 {
+		page.Next = strconv.FormatInt(offsets[start], 10)
+	}
+	return page, nil
+}
+
+// FirstSeen returns the earliest entry recorded for sha256 with the API
+// key with keyID, or nil if there is none
+func (s *FileStore) FirstSeen(ctx context.Context, keyID, sha256 string) (*Item, error) {
+	s.mu.Lock()
+	offset, ok := s.first[keyID+"/"+sha256]
+	size := s.size
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	entry, err := s.read(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	item := entry.item()
+	return &item, nil
+}
+
+// Similar returns up to limit images recorded for the API key with keyID
+// whose perceptual hash is at most distance bits from phash, nearest first
+func (s *FileStore) Similar(ctx context.Context, keyID string, phash uint64, distance, limit int) ([]Match, error) {
+	s.mu.Lock()
+	var matches []Match
+	for sha256, hash := range s.phashes[keyID] {
+		if d := metadata.HammingDistance(phash, hash); d <= distance {
+			matches = append(matches, Match{SHA256: sha256, PHash: fmt.Sprintf("%016x", hash), Distance: d})
+		}
+	}
+	s.mu.Unlock()
+
+	sortMatches(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// read decodes the line at offset. Lines are never rewritten, so they can
+// be read without the lock.
+func (s *FileStore) read(offset, size int64) (fileLine, error) {
+	var entry fileLine
+	line, err := bufio.NewReader(io.NewSectionReader(s.file, offset, size-offset)).ReadBytes('\n')
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(line, &entry)
+	return entry, err
+}
+
+// Close closes the file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,127 @@
+package history
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.ndjson")
+
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, result := range []*metadata.Result{
+		{Filename: "a.txt", SHA256: "aaaa", SizeBytes: 1},
+		{Filename: "b.txt", SHA256: "bbbb", SizeBytes: 2},
+		{Filename: "a-again.txt", SHA256: "aaaa", SizeBytes: 1},
+	} {
+		if err := s.Record(ctx, Entry{APIKey: "key", RequestID: "req", CreatedAt: when, Result: result}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// A crash in the middle of a write leaves a partial line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"created_at":"2024-03-01T12:00:00Z","result":{"file`)
+	f.Close()
+
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		sha256       string
+		wantFilename string
+	}{
+		{"aaaa", "a-again.txt"},
+		{"bbbb", "b.txt"},
+		{"cccc", ""},
+	}
+	for _, tt := range tests {
+		result, err := s.Get(ctx, KeyID("key"), tt.sha256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.wantFilename == "" {
+			if result != nil {
+				t.Errorf("%s: got %+v, want nothing", tt.sha256, result)
+			}
+			continue
+		}
+		if result == nil || result.Filename != tt.wantFilename {
+			t.Errorf("%s: got %+v, want %s", tt.sha256, result, tt.wantFilename)
+		}
+	}
+
+	// Writing continues after the last complete line
+	if err := s.Record(ctx, Entry{CreatedAt: when, Result: &metadata.Result{Filename: "c.txt", SHA256: "cccc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.Get(ctx, "", "cccc"); err != nil || result == nil || result.Filename != "c.txt" {
+		t.Errorf("got %+v, %v after recording c.txt", result, err)
+	}
+}
+
+func TestFileStoreSimilar(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.ndjson")
+
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []Entry{
+		{APIKey: "key", Result: &metadata.Result{SHA256: "same", Image: &metadata.ImageMetadata{PHash: "f0f0f0f0f0f0f0f0"}}},
+		{APIKey: "key", Result: &metadata.Result{SHA256: "near", Image: &metadata.ImageMetadata{PHash: "f0f0f0f0f0f0f0f3"}}},
+		{APIKey: "key", Result: &metadata.Result{SHA256: "far", Image: &metadata.ImageMetadata{PHash: "0f0f0f0f0f0f0f0f"}}},
+		{APIKey: "key", Result: &metadata.Result{SHA256: "text"}},
+		{APIKey: "other", Result: &metadata.Result{SHA256: "other", Image: &metadata.ImageMetadata{PHash: "f0f0f0f0f0f0f0f0"}}},
+	} {
+		if err := s.Record(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(s *FileStore) {
+		t.Helper()
+		matches, err := s.Similar(ctx, KeyID("key"), 0xf0f0f0f0f0f0f0f0, 4, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Match{
+			{SHA256: "same", PHash: "f0f0f0f0f0f0f0f0", Distance: 0},
+			{SHA256: "near", PHash: "f0f0f0f0f0f0f0f3", Distance: 2},
+		}
+		if len(matches) != len(want) || matches[0] != want[0] || matches[1] != want[1] {
+			t.Errorf("Similar() = %+v, want %+v", matches, want)
+		}
+		if matches, _ := s.Similar(ctx, KeyID("key"), 0xf0f0f0f0f0f0f0f0, 64, 1); len(matches) != 1 {
+			t.Errorf("Similar() with limit 1 returned %d matches", len(matches))
+		}
+	}
+	check(s)
+	s.Close()
+
+	// The hashes are indexed again when the file is reopened
+	s, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check(s)
+}
@@ -0,0 +1,295 @@
+// Package history keeps a permanent record of every extraction: which file
+// was extracted, when, by which API key and in which request.
+package history
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-meta/internal/metadata"
+	"file-meta/internal/postgres"
+)
+
+// Entry is one extraction
+type Entry struct {
+	APIKey    string // empty outside HTTP requests
+	RequestID string
+	CreatedAt time.Time
+	Result    *metadata.Result
+}
+
+// Item summarizes one recorded extraction in a listing
+type Item struct {
+	CreatedAt time.Time `json:"created_at"`
+	RequestID string    `json:"request_id"`
+	Filename  string    `json:"filename"`
+	SHA256    string    `json:"sha256"`
+	MimeType  string    `json:"mime_type"`
+	SizeBytes int64     `json:"size_bytes"`
+}
+
+// Page is part of an API key's history, newest first. Next is the cursor
+// for the following page, empty on the last one.
+type Page struct {
+	Items []Item `json:"items"`
+	Next  string `json:"next_cursor,omitempty"`
+}
+
+// Match is an image whose perceptual hash is within the searched distance
+type Match struct {
+	SHA256   string `json:"sha256"`
+	PHash    string `json:"phash"`
+	Distance int    `json:"distance"`
+}
+
+// sortMatches orders matches nearest first, then by SHA256
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].SHA256 < matches[j].SHA256
+	})
+}
+
+// phashOf returns the perceptual hash of an image result
+func phashOf(result *metadata.Result) (uint64, bool) {
+	if result.Image == nil || result.Image.PHash == "" {
+		return 0, false
+	}
+	hash, err := metadata.ParsePerceptualHash(result.Image.PHash)
+	return hash, err == nil
+}
+
+// ErrInvalidCursor is returned for cursors that no listing handed out
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// parseCursor reads a cursor, which is a position in the store to list
+// from. The empty cursor starts at the newest entry and returns -1.
+func parseCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return -1, nil
+	}
+	position, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || position < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return position, nil
+}
+
+// KeyID identifies an API key without storing it: the first 16 hex digits
+// of its SHA256. Empty keys have an empty ID.
+func KeyID(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PostgresStore records entries in a PostgreSQL table, with the full
+// result as JSONB next to columns for the common queries
+type PostgresStore struct {
+	db    *postgres.DB
+	table string
+}
+
+// NewPostgresStore records entries in table, which Migrate creates
+func NewPostgresStore(db *postgres.DB, table string) (*PostgresStore, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	return &PostgresStore{db: db, table: table}, nil
+}
+
+// Migrate creates the table and its indexes unless they exist
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	return s.db.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	api_key_id TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	sha256 TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	result JSONB NOT NULL
+);
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS phash BIGINT;
+CREATE INDEX IF NOT EXISTS %[1]s_sha256_idx ON %[1]s (sha256);
+CREATE INDEX IF NOT EXISTS %[1]s_api_key_id_idx ON %[1]s (api_key_id, id DESC);
+`, s.table))
+}
+
+// Record inserts entry
+func (s *PostgresStore) Record(ctx context.Context, entry Entry) error {
+	data, err := json.Marshal(entry.Result)
+	if err != nil {
+		return err
+	}
+	// The hash's bits are stored as a signed BIGINT
+	var phash any
+	if hash, ok := phashOf(entry.Result); ok {
+		phash = int64(hash)
+	}
+	return s.db.Exec(ctx, fmt.Sprintf(`INSERT INTO %s
+	(created_at, api_key_id, request_id, sha256, filename, mime_type, size_bytes, phash, result)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`, s.table),
+		entry.CreatedAt, KeyID(entry.APIKey), entry.RequestID, entry.Result.SHA256,
+		withoutNUL(entry.Result.Filename), entry.Result.MimeType, entry.Result.SizeBytes, phash, jsonWithoutNUL(data))
+}
+
+// Get returns the latest result recorded for sha256 with the API key with
+// keyID, or nil if there is none
+func (s *PostgresStore) Get(ctx context.Context, keyID, sha256 string) (*metadata.Result, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`SELECT result FROM %s
+	WHERE api_key_id = $1 AND sha256 = $2 ORDER BY created_at DESC LIMIT 1`, s.table), keyID, sha256)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	var result metadata.Result
+	if err := json.Unmarshal(rows[0][0], &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// List returns up to limit entries recorded for the API key with keyID,
+// older than the cursor
+func (s *PostgresStore) List(ctx context.Context, keyID string, limit int, cursor string) (Page, error) {
+	before, err := parseCursor(cursor)
+	if err != nil {
+		return Page{}, err
+	}
+	if before < 0 {
+		before = math.MaxInt64
+	}
+
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`SELECT id, created_at, request_id, filename, sha256, mime_type, size_bytes
+	FROM %s WHERE api_key_id = $1 AND id < $2 ORDER BY id DESC LIMIT $3`, s.table), keyID, before, int64(limit)+1)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Items: make([]Item, 0, min(len(rows), limit))}
+	for i, row := range rows {
+		if i == limit {
+			page.Next = string(rows[i-1][0])
+			break
+		}
+		createdAt, err := time.Parse(postgres.TimestampLayout, string(row[1]))
+		if err != nil {
+			return Page{}, fmt.Errorf("created_at: %w", err)
+		}
+		size, err := strconv.ParseInt(string(row[6]), 10, 64)
+		if err != nil {
+			return Page{}, fmt.Errorf("size_bytes: %w", err)
+		}
+		page.Items = append(page.Items, Item{
+			CreatedAt: createdAt,
+			RequestID: string(row[2]),
+			Filename:  string(row[3]),
+			SHA256:    string(row[4]),
+			MimeType:  string(row[5]),
+			SizeBytes: size,
+		})
+	}
+	return page, nil
+}
+
+// FirstSeen returns the earliest entry recorded for sha256 with the API
+// key with keyID, or nil if there is none
+func (s *PostgresStore) FirstSeen(ctx context.Context, keyID, sha256 string) (*Item, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`SELECT created_at, request_id, filename, mime_type, size_bytes
+	FROM %s WHERE sha256 = $1 AND api_key_id = $2 ORDER BY id LIMIT 1`, s.table), sha256, keyID)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	row := rows[0]
+	createdAt, err := time.Parse(postgres.TimestampLayout, string(row[0]))
+	if err != nil {
+		return nil, fmt.Errorf("created_at: %w", err)
+	}
+	size, err := strconv.ParseInt(string(row[4]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("size_bytes: %w", err)
+	}
+	return &Item{
+		CreatedAt: createdAt,
+		RequestID: string(row[1]),
+		Filename:  string(row[2]),
+		SHA256:    sha256,
+		MimeType:  string(row[3]),
+		SizeBytes: size,
+	}, nil
+}
+
+// Similar returns up to limit images recorded for the API key with keyID
+// whose perceptual hash is at most distance bits from phash, nearest first
+func (s *PostgresStore) Similar(ctx context.Context, keyID string, phash uint64, distance, limit int) ([]Match, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(`SELECT sha256, phash, distance FROM (
+		SELECT DISTINCT ON (sha256) sha256, phash,
+			length(replace(((phash # $2)::bit(64))::text, '0', '')) AS distance
+		FROM %s WHERE api_key_id = $1 AND phash IS NOT NULL ORDER BY sha256, id DESC
+	) latest WHERE distance <= $3 ORDER BY distance, sha256 LIMIT $4`, s.table),
+		keyID, int64(phash), int64(distance), int64(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(rows))
+	for _, row := range rows {
+		hash, err := strconv.ParseInt(string(row[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("phash: %w", err)
+		}
+		d, err := strconv.Atoi(string(row[2]))
+		if err != nil {
+			return nil, fmt.Errorf("distance: %w", err)
+		}
+		matches = append(matches, Match{SHA256: string(row[0]), PHash: fmt.Sprintf("%016x", uint64(hash)), Distance: d})
+	}
+	return matches, nil
+}
+
+// PostgreSQL text can't hold NUL characters, which tags padded with them
+// and odd filenames do
+func withoutNUL(s string) string {
+	return strings.ReplaceAll(s, "\x00", "")
+}
+
+// jsonWithoutNUL drops \u0000 escapes from encoded JSON, skipping escaped
+// backslashes so "\\u0000" stays intact
+func jsonWithoutNUL(data []byte) []byte {
+	if !bytes.Contains(data, []byte(`\u0000`)) {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '\\' && i+1 < len(data) {
+			if bytes.HasPrefix(data[i:], []byte(`\u0000`)) {
+				i += 5
+				continue
+			}
+			out = append(out, data[i], data[i+1])
+			i++
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package history
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONWithoutNUL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"no NUL", `{"title":"Song"}`, `{"title":"Song"}`},
+		{"padded tag", `{"title":"Song\u0000\u0000"}`, `{"title":"Song"}`},
+		{"escaped backslash", `{"path":"C:\\u0000"}`, `{"path":"C:\\u0000"}`},
+		{"other escapes", `{"a":"\"\u0000\n"}`, `{"a":"\"\n"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := jsonWithoutNUL([]byte(tt.in))
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if !json.Valid(got) {
+				t.Errorf("result %s is not valid JSON", got)
+			}
+		})
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	if got := KeyID(""); got != "" {
+		t.Errorf("KeyID(\"\") = %q, want empty", got)
+	}
+	id := KeyID("secret-key")
+	if len(id) != 16 || id != KeyID("secret-key") || id == KeyID("other-key") {
+		t.Errorf("KeyID gave %q", id)
+	}
+}
+
+func TestNewPostgresStoreTableName(t *testing.T) {
+	for _, table := range []string{"extractions", "file_meta_history"} {
+		if _, err := NewPostgresStore(nil, table); err != nil {
+			t.Errorf("%q: %v", table, err)
+		}
+	}
+	for _, table := range []string{"", "1st", "results; DROP TABLE users", "public.extractions"} {
+		if _, err := NewPostgresStore(nil, table); err == nil {
+			t.Errorf("%q: expected an error", table)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package jobs tracks asynchronous extraction jobs in memory. A job records
+// each stage as it finishes so clients can poll it or follow it live.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+// ErrNotFound is returned for unknown or swept jobs
+var ErrNotFound = errors.New("job not found")
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Stages recorded around the extraction stages from metadata.Options.Progress
+const (
+	StageUploaded = "uploaded" // upload opened for extraction
+	StageDone     = "done"     // result available
+	StageFailed   = "failed"   // extraction failed, see Job.Error
+)
+
+// Event is one finished stage
+type Event struct {
+	Stage string    `json:"stage"`
+	Time  time.Time `json:"time"`
+}
+
+// Job is a snapshot of an extraction job
+type Job struct {
+	ID       string           `json:"id"`
+	UploadID string           `json:"upload_id"`
+	Status   string           `json:"status"`
+	Events   []Event          `json:"events"`
+	Error    string           `json:"error,omitempty"`
+	Code     string           `json:"code,omitempty"` // set for failed jobs, see Finish
+	Created  time.Time        `json:"created"`
+	Finished *time.Time       `json:"finished,omitempty"`
+	Result   *metadata.Result `json:"-"`
+	Owner    string           `json:"-"` // history.KeyID of the API key that created it
+}
+
+// Done reports whether the job is done or failed
+func (j *Job) Done() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed
+}
+
+type entry struct {
+	job Job
+
+	// changed is closed and replaced on every update
+	changed chan struct{}
+}
+
+// Manager holds jobs until they have been finished for the retention period
+type Manager struct {
+	retention time.Duration
+	now       func() time.Time
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+}
+
+// NewManager creates a manager that keeps finished jobs for retention
+func NewManager(retention time.Duration) *Manager {
+	return &Manager{
+		retention: retention,
+		now:       time.Now,
+		jobs:      make(map[string]*entry),
+	}
+}
+
+// Create adds a queued job for an upload, owned by owner
+func (m *Manager) Create(uploadID, owner string) (Job, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return Job{}, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := &entry{
+		job: Job{
+			ID:       hex.EncodeToString(b[:]),
+			UploadID: uploadID,
+			Status:   StatusQueued,
+			Created:  m.now(),
+			Owner:    owner,
+		},
+		changed: make(chan struct{}),
+	}
+	m.jobs[e.job.ID] = e
+	return e.snapshot(), nil
+}
+
+// Get returns a snapshot of a job
+func (m *Manager) Get(id string) (Job, error) {
+	job, _, err := m.Watch(id)
+	return job, err
+}
+
+// Watch returns a snapshot of a job and a channel that is closed on its
+// next update
+func (m *Manager) Watch(id string) (Job, <-chan struct{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, nil, ErrNotFound
+	}
+	return e.snapshot(), e.changed, nil
+}
+
+// Stage records a finished stage and marks the job running. Stages of
+// finished or unknown jobs are ignored.
+func (m *Manager) Stage(id, stage string) {
+	m.update(id, func(job *Job) {
+		job.Status = StatusRunning
+		job.Events = append(job.Events, Event{Stage: stage, Time: m.now()})
+	})
+}
+
+// Finish records the job's result, or its error if err is non-nil. An
+// error with an ErrorCode() string method sets the job's Code.
+func (m *Manager) Finish(id string, result *metadata.Result, err error) {
+	m.update(id, func(job *Job) {
+		job.Status, job.Result = StatusDone, result
+		stage := StageDone
+		if err != nil {
+			job.Status, job.Result, job.Error = StatusFailed, nil, err.Error()
+			var coded interface{ ErrorCode() string }
+			if errors.As(err, &coded) {
+				job.Code = coded.ErrorCode()
+			}
+			stage = StageFailed
+		}
+		now := m.now()
+		job.Finished = &now
+		job.Events = append(job.Events, Event{Stage: stage, Time: now})
+	})
+}
+
+// Sweep removes jobs finished longer than the retention period ago and
+// returns how many were removed
+func (m *Manager) Sweep() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	cutoff := m.now().Add(-m.retention)
+	for id, e := range m.jobs {
+		if e.job.Done() && e.job.Finished.Before(cutoff) {
+			delete(m.jobs, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (m *Manager) update(id string, apply func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok || e.job.Done() {
+		return
+	}
+	apply(&e.job)
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// snapshot copies the job so callers can read it without the lock
+func (e *entry) snapshot() Job {
+	job := e.job
+	job.Events = append([]Event{}, e.job.Events...)
+	return job
+}
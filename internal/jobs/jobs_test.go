@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+func TestManager(t *testing.T) {
+	m := NewManager(time.Hour)
+
+	job, err := m.Create("upload", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusQueued || job.UploadID != "upload" || len(job.ID) != 32 {
+		t.Errorf("Create() = %+v, want a queued job for upload", job)
+	}
+
+	_, changed, err := m.Watch(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Stage(job.ID, StageUploaded)
+	select {
+	case <-changed:
+	default:
+		t.Error("Watch() channel not closed after Stage")
+	}
+
+	m.Stage(job.ID, metadata.StageHashed)
+	m.Finish(job.ID, &metadata.Result{Filename: "a.txt"}, nil)
+	m.Stage(job.ID, "late")
+
+	got, err := m.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusDone || got.Result == nil || got.Finished == nil {
+		t.Errorf("Get() = %+v, want done with a result", got)
+	}
+	var stages []string
+	for _, event := range got.Events {
+		stages = append(stages, event.Stage)
+	}
+	if want := []string{StageUploaded, metadata.StageHashed, StageDone}; !slices.Equal(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+
+	failed, _ := m.Create("other", "")
+	m.Finish(failed.ID, nil, errors.New("boom"))
+	if got, _ := m.Get(failed.ID); got.Status != StatusFailed || got.Error != "boom" {
+		t.Errorf("Get() = %+v, want failed with boom", got)
+	}
+
+	coded, _ := m.Create("coded", "")
+	m.Finish(coded.ID, nil, fmt.Errorf("extract: %w", codedError("SERVER_BUSY")))
+	if got, _ := m.Get(coded.ID); got.Code != "SERVER_BUSY" {
+		t.Errorf("Code = %q, want SERVER_BUSY", got.Code)
+	}
+
+	if _, err := m.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestManagerSweep(t *testing.T) {
+	now := time.Now()
+	m := NewManager(time.Hour)
+	m.now = func() time.Time { return now }
+
+	finished, _ := m.Create("a", "")
+	m.Finish(finished.ID, &metadata.Result{}, nil)
+	running, _ := m.Create("b", "")
+	m.Stage(running.ID, StageUploaded)
+
+	if removed := m.Sweep(); removed != 0 {
+		t.Errorf("Sweep() within retention = %d, want 0", removed)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if removed := m.Sweep(); removed != 1 {
+		t.Errorf("Sweep() = %d, want 1", removed)
+	}
+	if _, err := m.Get(finished.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("finished job not swept: %v", err)
+	}
+	if _, err := m.Get(running.ID); err != nil {
+		t.Errorf("running job swept: %v", err)
+	}
+}
+
+// codedError is an error with a code, like the ones handlers report
+type codedError string
+
+func (e codedError) Error() string     { return "coded" }
+func (e codedError) ErrorCode() string { return string(e) }
@@ -0,0 +1,475 @@
+// Package kafka publishes messages to a Kafka topic. It speaks just enough
+// of the Kafka protocol to produce: metadata lookups, record batches sent
+// to each partition's leader, and optional TLS and SASL/PLAIN.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
+)
+
+var (
+	messagesPublished = metrics.NewCounter("file_meta_kafka_messages_published_total",
+		"Messages acknowledged by Kafka")
+	messagesDropped = metrics.NewCounter("file_meta_kafka_messages_dropped_total",
+		"Messages dropped because the buffer was full or Kafka kept failing")
+)
+
+// Batches are sent once they hold maxBatch messages, or batchLinger after
+// their first message arrived
+var (
+	maxBatch    = 500
+	batchLinger = 50 * time.Millisecond
+)
+
+// Options configure a producer. A nil TLS config means plaintext; a
+// username enables SASL/PLAIN.
+type Options struct {
+	ClientID   string
+	TLS        *tls.Config
+	Username   string
+	Password   string
+	BufferSize int
+	Timeout    time.Duration
+}
+
+// Producer publishes to one topic in the background. Publish never blocks;
+// messages are batched per partition and sent with acks from the leader.
+type Producer struct {
+	brokers []string
+	topic   string
+	opts    Options
+	log     *logger.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan message
+	done   chan struct{}
+
+	// Owned by the run goroutine
+	conns      map[int32]*brokerConn
+	nodes      map[int32]string
+	leaders    map[int32]int32
+	partitions int
+}
+
+// NewProducer starts a producer for topic, bootstrapping from brokers
+// ("host:port"). Close it to flush buffered messages.
+func NewProducer(brokers []string, topic string, opts Options, log *logger.Logger) (*Producer, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("at least one broker is required")
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("topic is required")
+	}
+	if opts.BufferSize <= 0 || opts.Timeout <= 0 {
+		return nil, fmt.Errorf("buffer size and timeout must be positive")
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "file-meta"
+	}
+
+	p := &Producer{
+		brokers: brokers,
+		topic:   topic,
+		opts:    opts,
+		log:     log,
+		queue:   make(chan message, opts.BufferSize),
+		done:    make(chan struct{}),
+		conns:   make(map[int32]*brokerConn),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Publish queues a message. It returns false, dropping the message, when
+// the buffer is full or the producer is closed.
+func (p *Producer) Publish(key, value []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		messagesDropped.Inc()
+		return false
+	}
+	select {
+	case p.queue <- message{key: key, value: value, timestamp: time.Now()}:
+		return true
+	default:
+		messagesDropped.Inc()
+		return false
+	}
+}
+
+// Close stops accepting messages and waits until those buffered are sent,
+// or ctx is done
+func (p *Producer) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run batches queued messages until the queue is closed
+func (p *Producer) run() {
+	defer close(p.done)
+	defer func() {
+		for _, c := range p.conns {
+			c.Close()
+		}
+	}()
+
+	var batch []message
+	linger := time.NewTimer(batchLinger)
+	linger.Stop()
+	for {
+		select {
+		case m, ok := <-p.queue:
+			if !ok {
+				p.send(batch)
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) == 1 {
+				linger.Reset(batchLinger)
+			}
+			if len(batch) >= maxBatch {
+				linger.Stop()
+				p.send(batch)
+				batch = nil
+			}
+		case <-linger.C:
+			p.send(batch)
+			batch = nil
+		}
+	}
+}
+
+// send produces a batch, retrying once with fresh metadata when a leader
+// moved or the connection failed
+func (p *Producer) send(batch []message) {
+	var err error
+	for attempt := 0; attempt < 2 && len(batch) > 0; attempt++ {
+		if p.partitions == 0 || attempt > 0 {
+			if err = p.refreshMetadata(); err != nil {
+				continue
+			}
+		}
+
+		byLeader := make(map[int32]map[int32][]message)
+		for _, m := range batch {
+			partition := partitionFor(m.key, p.partitions)
+			leader := p.leaders[partition]
+			if byLeader[leader] == nil {
+				byLeader[leader] = make(map[int32][]message)
+			}
+			byLeader[leader][partition] = append(byLeader[leader][partition], m)
+		}
+
+		var failed []message
+		for leader, partitions := range byLeader {
+			var retry []message
+			if retry, err = p.produce(leader, partitions); err != nil {
+				failed = append(failed, retry...)
+			}
+		}
+		batch = failed
+	}
+
+	if len(batch) > 0 {
+		messagesDropped.Add(float64(len(batch)))
+		p.log.Errorf("Dropped %d messages for Kafka topic %s: %v", len(batch), p.topic, err)
+	}
+}
+
+// produce sends each partition's messages to their leader and returns the
+// messages that weren't acknowledged
+func (p *Producer) produce(leader int32, partitions map[int32][]message) ([]message, error) {
+	var all []message
+	for _, messages := range partitions {
+		all = append(all, messages...)
+	}
+	if leader < 0 {
+		return all, brokerError(errLeaderNotAvailable)
+	}
+	conn, err := p.conn(leader)
+	if err != nil {
+		return all, err
+	}
+
+	var req encoder
+	req.int16(-1) // no transactional ID
+	req.int16(1)  // acks from the leader
+	req.int32(int32(p.opts.Timeout.Milliseconds()))
+	req.int32(1)
+	req.string(p.topic)
+	req.int32(int32(len(partitions)))
+	for partition, messages := range partitions {
+		req.int32(partition)
+		req.bytes(encodeRecordBatch(messages))
+	}
+
+	resp, err := conn.roundTrip(apiProduce, produceVersion, req.buf)
+	if err != nil {
+		p.dropConn(leader)
+		return all, err
+	}
+
+	var failed []message
+	d := &decoder{buf: resp}
+	for range d.arrayLen() {
+		d.string()
+		for range d.arrayLen() {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err != nil {
+				break
+			}
+			if code != 0 {
+				failed = append(failed, partitions[partition]...)
+				err = brokerError(code)
+				continue
+			}
+			messagesPublished.Add(float64(len(partitions[partition])))
+			delete(partitions, partition)
+		}
+	}
+	if d.err != nil {
+		p.dropConn(leader)
+		for _, messages := range partitions {
+			failed = append(failed, messages...)
+		}
+		return failed, fmt.Errorf("invalid produce response: %w", d.err)
+	}
+	return failed, err
+}
+
+// refreshMetadata learns the topic's partition leaders from the first
+// broker that answers
+func (p *Producer) refreshMetadata() error {
+	addrs := append([]string(nil), p.brokers...)
+	for _, addr := range p.nodes {
+		addrs = append(addrs, addr)
+	}
+
+	var err error
+	for _, addr := range addrs {
+		if err = p.fetchMetadata(addr); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("metadata request failed: %w", err)
+}
+
+func (p *Producer) fetchMetadata(addr string) error {
+	conn, err := p.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var req encoder
+	req.int32(1)
+	req.string(p.topic)
+	resp, err := conn.roundTrip(apiMetadata, metadataVersion, req.buf)
+	if err != nil {
+		return err
+	}
+
+	d := &decoder{buf: resp}
+	nodes := make(map[int32]string)
+	for range d.arrayLen() {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		nodes[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+
+	leaders := make(map[int32]int32)
+	var topicErr int16
+	for range d.arrayLen() {
+		topicErr = d.int16()
+		name := d.string()
+		d.int8() // internal
+		for range d.arrayLen() {
+			d.int16() // partition error
+			partition := d.int32()
+			leader := d.int32()
+			for range d.arrayLen() {
+				d.int32() // replicas
+			}
+			for range d.arrayLen() {
+				d.int32() // in-sync replicas
+			}
+			if name == p.topic {
+				leaders[partition] = leader
+			}
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("invalid metadata response: %w", d.err)
+	}
+	if topicErr != 0 {
+		return brokerError(topicErr)
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("topic %s has no partitions", p.topic)
+	}
+
+	// Partitions are numbered from zero
+	for partition := range int32(len(leaders)) {
+		if _, ok := leaders[partition]; !ok {
+			return fmt.Errorf("topic %s is missing partition %d", p.topic, partition)
+		}
+	}
+
+	for id, c := range p.conns {
+		if nodes[id] != c.addr {
+			p.dropConn(id)
+		}
+	}
+	p.nodes, p.leaders, p.partitions = nodes, leaders, len(leaders)
+	return nil
+}
+
+// conn returns the open connection to a node, dialing it if needed
+func (p *Producer) conn(node int32) (*brokerConn, error) {
+	if c, ok := p.conns[node]; ok {
+		return c, nil
+	}
+	addr, ok := p.nodes[node]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %d", node)
+	}
+	c, err := p.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[node] = c
+	return c, nil
+}
+
+func (p *Producer) dropConn(node int32) {
+	if c, ok := p.conns[node]; ok {
+		c.Close()
+		delete(p.conns, node)
+	}
+}
+
+// dial connects and authenticates to a broker
+func (p *Producer) dial(addr string) (*brokerConn, error) {
+	dialer := &net.Dialer{Timeout: p.opts.Timeout}
+	var nc net.Conn
+	var err error
+	if p.opts.TLS != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, p.opts.TLS)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &brokerConn{Conn: nc, addr: addr, clientID: p.opts.ClientID, timeout: p.opts.Timeout}
+	if p.opts.Username != "" {
+		if err := c.authenticate(p.opts.Username, p.opts.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// brokerConn sends one request at a time and reads its response
+type brokerConn struct {
+	net.Conn
+	addr          string
+	clientID      string
+	timeout       time.Duration
+	correlationID int32
+}
+
+// roundTrip sends a request and returns the response body after the
+// correlation ID
+func (c *brokerConn) roundTrip(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	c.correlationID++
+	var req encoder
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(c.correlationID)
+	req.string(c.clientID)
+	req.buf = append(req.buf, body...)
+	size := len(req.buf) - 4
+	req.buf[0], req.buf[1], req.buf[2], req.buf[3] = byte(size>>24), byte(size>>16), byte(size>>8), byte(size)
+
+	c.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := c.Write(req.buf); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: header}
+	size = int(d.int32())
+	if correlationID := d.int32(); correlationID != c.correlationID {
+		return nil, fmt.Errorf("response for request %d, want %d", correlationID, c.correlationID)
+	}
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// authenticate performs a SASL/PLAIN exchange
+func (c *brokerConn) authenticate(username, password string) error {
+	var req encoder
+	req.string("PLAIN")
+	resp, err := c.roundTrip(apiSaslHandshake, saslHandshakeVersion, req.buf)
+	if err != nil {
+		return fmt.Errorf("SASL handshake failed: %w", err)
+	}
+	d := &decoder{buf: resp}
+	if code := d.int16(); code != 0 {
+		return fmt.Errorf("SASL handshake failed: %w", brokerError(code))
+	}
+
+	req = encoder{}
+	req.bytes([]byte("\x00" + username + "\x00" + password))
+	resp, err = c.roundTrip(apiSaslAuthenticate, saslAuthenticateVersion, req.buf)
+	if err != nil {
+		return fmt.Errorf("SASL authentication failed: %w", err)
+	}
+	d = &decoder{buf: resp}
+	if code := d.int16(); code != 0 {
+		return fmt.Errorf("SASL authentication failed: %s", d.string())
+	}
+	return nil
+}
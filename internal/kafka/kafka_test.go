@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Vectors from the Java client's tests
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for input, want := range tests {
+		if got := murmur2([]byte(input)); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", input, got, want)
+		}
+	}
+}
+
+// record is a record decoded by the fake broker
+type record struct {
+	partition  int32
+	key, value string
+}
+
+// fakeBroker is a single-node cluster hosting one topic. Produce requests
+// fail with failCode while it is set.
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	topic      string
+	partitions int32
+
+	mu       sync.Mutex
+	records  []record
+	failCode int16
+	auth     string
+}
+
+func newFakeBroker(t *testing.T, topic string, partitions int32) *fakeBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, listener: listener, topic: topic, partitions: partitions}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		sizeBuf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, sizeBuf); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(sizeBuf))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &decoder{buf: req}
+		apiKey, _, correlationID := d.int16(), d.int16(), d.int32()
+		d.string() // client ID
+
+		var resp encoder
+		resp.int32(correlationID)
+		switch apiKey {
+		case apiMetadata:
+			host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+			portNum, _ := strconv.Atoi(port)
+			resp.int32(1)
+			resp.int32(7)
+			resp.string(host)
+			resp.int32(int32(portNum))
+			resp.int16(-1)
+			resp.int32(7) // controller
+			resp.int32(1)
+			resp.int16(0)
+			resp.string(b.topic)
+			resp.int8(0)
+			resp.int32(b.partitions)
+			for partition := range b.partitions {
+				resp.int16(0)
+				resp.int32(partition)
+				resp.int32(7)
+				resp.int32(0)
+				resp.int32(0)
+			}
+		case apiProduce:
+			b.produce(d, &resp)
+		case apiSaslHandshake:
+			resp.int16(0)
+			resp.int32(1)
+			resp.string("PLAIN")
+		case apiSaslAuthenticate:
+			b.mu.Lock()
+			b.auth = string(d.bytes())
+			b.mu.Unlock()
+			resp.int16(0)
+			resp.int16(-1)
+			resp.bytes(nil)
+		default:
+			b.t.Errorf("unexpected API key %d", apiKey)
+			return
+		}
+
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(resp.buf)))
+		conn.Write(append(out, resp.buf...))
+	}
+}
+
+func (b *fakeBroker) produce(d *decoder, resp *encoder) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d.string() // transactional ID
+	if acks := d.int16(); acks != 1 {
+		b.t.Errorf("acks = %d, want 1", acks)
+	}
+	d.int32() // timeout
+	resp.int32(int32(d.arrayLen()))
+	resp.string(d.string())
+	n := d.arrayLen()
+	resp.int32(int32(n))
+	for range n {
+		partition := d.int32()
+		batch := &decoder{buf: d.bytes()}
+		resp.int32(partition)
+		resp.int16(b.failCode)
+		resp.int64(0)
+		resp.int64(-1)
+		if b.failCode != 0 {
+			continue
+		}
+
+		batch.int64() // base offset
+		batch.int32() // length
+		batch.int32() // leader epoch
+		if magic := batch.int8(); magic != 2 {
+			b.t.Errorf("magic = %d, want 2", magic)
+		}
+		crc := uint32(batch.int32())
+		if got := crc32.Checksum(batch.buf, crc32.MakeTable(crc32.Castagnoli)); got != crc {
+			b.t.Errorf("batch CRC = %x, computed %x", crc, got)
+		}
+		batch.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+		count := batch.int32()
+		for range count {
+			length, n := binary.Varint(batch.buf)
+			fields := batch.buf[n : n+int(length)]
+			batch.take(n + int(length))
+
+			fields = fields[1:] // attributes
+			for range 2 {       // timestamp and offset deltas
+				_, n := binary.Varint(fields)
+				fields = fields[n:]
+			}
+			var kv [2]string
+			for i := range kv {
+				size, n := binary.Varint(fields)
+				kv[i] = string(fields[n : n+int(size)])
+				fields = fields[n+int(size):]
+			}
+			b.records = append(b.records, record{partition: partition, key: kv[0], value: kv[1]})
+		}
+	}
+	resp.int32(0) // throttle time
+}
+
+func TestProducer(t *testing.T) {
+	broker := newFakeBroker(t, "results", 3)
+	p, err := NewProducer([]string{broker.listener.Addr().String()}, "results",
+		Options{Username: "user", Password: "secret", BufferSize: 10, Timeout: 5 * time.Second}, logger.New("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"21", "foobar", "abc"}
+	for _, key := range keys {
+		if !p.Publish([]byte(key), []byte(`{"sha256":"`+key+`"}`)) {
+			t.Fatalf("Publish(%s) dropped", key)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if p.Publish([]byte("late"), nil) {
+		t.Error("Publish() after Close succeeded")
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.auth != "\x00user\x00secret" {
+		t.Errorf("SASL auth bytes = %q", broker.auth)
+	}
+	if len(broker.records) != len(keys) {
+		t.Fatalf("broker got %d records, want %d", len(broker.records), len(keys))
+	}
+	for _, r := range broker.records {
+		if want := partitionFor([]byte(r.key), 3); r.partition != want {
+			t.Errorf("key %s went to partition %d, want %d", r.key, r.partition, want)
+		}
+		if r.value != `{"sha256":"`+r.key+`"}` {
+			t.Errorf("value = %s", r.value)
+		}
+	}
+}
+
+func TestProducerDropsOnBrokerError(t *testing.T) {
+	broker := newFakeBroker(t, "results", 1)
+	broker.failCode = 10 // message too large
+	p, err := NewProducer([]string{broker.listener.Addr().String()}, "results",
+		Options{BufferSize: 1, Timeout: 5 * time.Second}, logger.New("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := messagesDropped.Value()
+	p.Publish([]byte("a"), []byte("v"))
+	p.Close(context.Background())
+	if dropped := messagesDropped.Value() - before; dropped != 1 {
+		t.Errorf("dropped %v messages, want 1", dropped)
+	}
+}
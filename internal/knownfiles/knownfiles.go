@@ -0,0 +1,243 @@
+package knownfiles
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Hash algorithm names, also used as Redis key suffixes
+const (
+	AlgorithmMD5    = "md5"
+	AlgorithmSHA1   = "sha1"
+	AlgorithmSHA256 = "sha256"
+)
+
+// Digests holds the hashes of an upload used for lookups
+type Digests struct {
+	MD5    string
+	SHA1   string
+	SHA256 string
+}
+
+// Entry is a matched known file
+type Entry struct {
+	Algorithm   string
+	FileName    string
+	ProductCode string
+}
+
+// Compute hashes r with every algorithm used by NSRL-style hash sets
+func Compute(r io.Reader) (Digests, error) {
+	md5h, sha1h, sha256h := md5.New(), sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5h, sha1h, sha256h), r); err != nil {
+		return Digests{}, fmt.Errorf("failed to hash file: %w", err)
+	}
+	return Digests{
+		MD5:    hex.EncodeToString(md5h.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1h.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256h.Sum(nil)),
+	}, nil
+}
+
+// lookupOrder lists digests from strongest to weakest
+func (d Digests) lookupOrder() [][2]string {
+	return [][2]string{
+		{AlgorithmSHA256, d.SHA256},
+		{AlgorithmSHA1, d.SHA1},
+		{AlgorithmMD5, d.MD5},
+	}
+}
+
+// algorithmForHash infers the algorithm from a hex digest's length
+func algorithmForHash(hash string) string {
+	switch len(hash) {
+	case 32:
+		return AlgorithmMD5
+	case 40:
+		return AlgorithmSHA1
+	case 64:
+		return AlgorithmSHA256
+	}
+	return ""
+}
+
+// MemorySet is a known-file hash set held in memory
+type MemorySet struct {
+	entries map[string]Entry
+}
+
+// Len returns the number of hashes in the set
+func (s *MemorySet) Len() int {
+	return len(s.entries)
+}
+
+// Lookup returns the matching entry, or nil if the upload is not known
+func (s *MemorySet) Lookup(ctx context.Context, d Digests) (*Entry, error) {
+	for _, h := range d.lookupOrder() {
+		if entry, ok := s.entries[h[1]]; ok {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// LoadFile reads a hash set from disk. Both NSRL RDS CSV files (with a
+// quoted header row such as "SHA-1","MD5",...) and plain lists with one hex
+// hash per line, optionally followed by a file name, are supported.
+func LoadFile(path string) (*MemorySet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hash set: %w", err)
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// Load reads a hash set in either supported format from r
+func Load(r io.Reader) (*MemorySet, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read hash set: %w", err)
+	}
+
+	set := &MemorySet{entries: make(map[string]Entry)}
+	if len(first) > 0 && first[0] == '"' {
+		err = set.loadCSV(br)
+	} else {
+		err = set.loadList(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// loadCSV reads an NSRL RDS file, keyed by every hash column present
+func (s *MemorySet) loadCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read hash set header: %w", err)
+	}
+
+	hashColumns := make(map[int]string)
+	nameCol, productCol := -1, -1
+	for i, col := range header {
+		switch strings.ToUpper(strings.TrimSpace(col)) {
+		case "MD5":
+			hashColumns[i] = AlgorithmMD5
+		case "SHA-1", "SHA1":
+			hashColumns[i] = AlgorithmSHA1
+		case "SHA-256", "SHA256":
+			hashColumns[i] = AlgorithmSHA256
+		case "FILENAME", "FILE_NAME":
+			nameCol = i
+		case "PRODUCTCODE", "PACKAGE_ID":
+			productCol = i
+		}
+	}
+	if len(hashColumns) == 0 {
+		return fmt.Errorf("hash set header has no MD5, SHA-1 or SHA-256 column")
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse hash set: %w", err)
+		}
+
+		for col, algorithm := range hashColumns {
+			if col >= len(record) {
+				continue
+			}
+			entry := Entry{Algorithm: algorithm}
+			if nameCol >= 0 && nameCol < len(record) {
+				entry.FileName = record[nameCol]
+			}
+			if productCol >= 0 && productCol < len(record) {
+				entry.ProductCode = record[productCol]
+			}
+			s.add(record[col], entry)
+		}
+	}
+}
+
+// loadList reads "hash [filename]" lines, skipping blanks and # comments
+func (s *MemorySet) loadList(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		algorithm := algorithmForHash(fields[0])
+		if algorithm == "" {
+			continue
+		}
+		s.add(fields[0], Entry{Algorithm: algorithm, FileName: strings.Join(fields[1:], " ")})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read hash set: %w", err)
+	}
+	return nil
+}
+
+// add records a hash, keeping the first entry seen for duplicates
+func (s *MemorySet) add(hash string, entry Entry) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if algorithmForHash(hash) != entry.Algorithm {
+		return
+	}
+	if _, exists := s.entries[hash]; !exists {
+		s.entries[hash] = entry
+	}
+}
+
+// RedisSet is a known-file hash set stored in Redis hashes named
+// "<prefix>:md5", "<prefix>:sha1" and "<prefix>:sha256", mapping lowercase
+// hex digests to file names
+type RedisSet struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisSet creates a Redis-backed hash set
+func NewRedisSet(client *redis.Client, prefix string) *RedisSet {
+	return &RedisSet{client: client, prefix: prefix}
+}
+
+// Lookup returns the matching entry, or nil if the upload is not known
+func (s *RedisSet) Lookup(ctx context.Context, d Digests) (*Entry, error) {
+	for _, h := range d.lookupOrder() {
+		name, err := s.client.HGet(ctx, s.prefix+":"+h[0], h[1]).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query known-file set: %w", err)
+		}
+		return &Entry{Algorithm: h[0], FileName: name}, nil
+	}
+	return nil, nil
+}
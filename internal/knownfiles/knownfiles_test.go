@@ -0,0 +1,89 @@
+package knownfiles
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLoadAndLookup(t *testing.T) {
+	digests, err := Compute(strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nsrl := `"SHA-1","MD5","CRC32","FileName","FileSize","ProductCode","OpSystemCode","SpecialCode"
+"` + strings.ToUpper(digests.SHA1) + `","0000000000000000000000000000000A","00000000","kernel32.dll",11,1234,"362",""
+"0000000000000000000000000000000000000001","` + strings.ToUpper(digests.MD5) + `","00000000","ntdll.dll",11,5678,"362",""
+`
+	list := `# known good
+` + digests.SHA256 + `  hello.txt
+not-a-hash
+`
+
+	tests := []struct {
+		name              string
+		data              string
+		expectedLen       int
+		expectedAlgorithm string
+		expectedFileName  string
+		expectedProduct   string
+	}{
+		{
+			name:              "NSRL RDS CSV matches SHA-1 before MD5",
+			data:              nsrl,
+			expectedLen:       4,
+			expectedAlgorithm: AlgorithmSHA1,
+			expectedFileName:  "kernel32.dll",
+			expectedProduct:   "1234",
+		},
+		{
+			name:              "plain list",
+			data:              list,
+			expectedLen:       1,
+			expectedAlgorithm: AlgorithmSHA256,
+			expectedFileName:  "hello.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := Load(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if set.Len() != tt.expectedLen {
+				t.Errorf("Len() = %d, want %d", set.Len(), tt.expectedLen)
+			}
+
+			entry, err := set.Lookup(context.Background(), digests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry == nil {
+				t.Fatal("Lookup() = nil, want match")
+			}
+			if entry.Algorithm != tt.expectedAlgorithm {
+				t.Errorf("Algorithm = %v, want %v", entry.Algorithm, tt.expectedAlgorithm)
+			}
+			if entry.FileName != tt.expectedFileName {
+				t.Errorf("FileName = %v, want %v", entry.FileName, tt.expectedFileName)
+			}
+			if entry.ProductCode != tt.expectedProduct {
+				t.Errorf("ProductCode = %v, want %v", entry.ProductCode, tt.expectedProduct)
+			}
+
+			other, _ := Compute(strings.NewReader("something else"))
+			if entry, _ := set.Lookup(context.Background(), other); entry != nil {
+				t.Errorf("Lookup() of unknown file = %+v, want nil", entry)
+			}
+		})
+	}
+}
+
+func TestLoadCSVWithoutHashColumns(t *testing.T) {
+	if _, err := Load(strings.NewReader("\"FileName\",\"FileSize\"\n\"a\",1\n")); err == nil {
+		t.Error("Load() should return error when no hash column is present")
+	}
+}
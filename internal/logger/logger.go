@@ -1,8 +1,11 @@
 package logger
 
 import (
+	"context"
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 // Level represents log level
@@ -15,80 +18,148 @@ const (
 	ERROR
 )
 
-// Logger provides structured logging
+// String returns the level's name, as LOG_LEVEL takes it
+func (l Level) String() string {
+	switch l {
+	case DEBUG:
+		return "debug"
+	case INFO:
+		return "info"
+	case WARN:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel returns the level named s, and whether there is one
+func ParseLevel(s string) (Level, bool) {
+	switch s {
+	case "debug":
+		return DEBUG, true
+	case "info":
+		return INFO, true
+	case "warn":
+		return WARN, true
+	case "error":
+		return ERROR, true
+	default:
+		return INFO, false
+	}
+}
+
+// Logger provides structured logging. Its level can be changed while it is
+// in use.
 type Logger struct {
-	level  Level
-	debug  *log.Logger
-	info   *log.Logger
-	warn   *log.Logger
-	errLog *log.Logger
+	level     atomic.Int32
+	filenames atomic.Value // what Filename shows, a Filenames policy
+	debug     *log.Logger
+	info      *log.Logger
+	warn      *log.Logger
+	errLog    *log.Logger
+	access    *log.Logger
 }
 
 // New creates a new logger with the specified level
 func New(levelStr string) *Logger {
-	level := parseLevel(levelStr)
-
-	return &Logger{
-		level:  level,
+	l := &Logger{
 		debug:  log.New(os.Stdout, "[DEBUG] ", log.LstdFlags|log.Lshortfile),
 		info:   log.New(os.Stdout, "[INFO]  ", log.LstdFlags),
 		warn:   log.New(os.Stdout, "[WARN]  ", log.LstdFlags),
 		errLog: log.New(os.Stderr, "[ERROR] ", log.LstdFlags|log.Lshortfile),
+		access: log.New(os.Stdout, "", 0),
 	}
+	l.SetLevel(parseLevel(levelStr))
+	return l
+}
+
+// Level returns the lowest level logged
+func (l *Logger) Level() Level {
+	return Level(l.level.Load())
+}
+
+// SetLevel changes the lowest level logged
+func (l *Logger) SetLevel(level Level) {
+	l.level.Store(int32(level))
+}
+
+// SetOutput sends debug, info and warning messages to w. Errors always go
+// to stderr.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.debug.SetOutput(w)
+	l.info.SetOutput(w)
+	l.warn.SetOutput(w)
+	l.access.SetOutput(w)
 }
 
 // Debug logs debug messages
 func (l *Logger) Debug(v ...interface{}) {
-	if l.level <= DEBUG {
+	if l.Level() <= DEBUG {
 		l.debug.Println(v...)
 	}
 }
 
 // Debugf logs formatted debug messages
 func (l *Logger) Debugf(format string, v ...interface{}) {
-	if l.level <= DEBUG {
+	if l.Level() <= DEBUG {
+		l.debug.Printf(format, v...)
+	}
+}
+
+// DebugContextf logs formatted debug messages like Debugf, and also below
+// the debug level for requests whose ctx has WithDebug
+func (l *Logger) DebugContextf(ctx context.Context, format string, v ...interface{}) {
+	if l.Level() <= DEBUG || Debugging(ctx) {
 		l.debug.Printf(format, v...)
 	}
 }
 
 // Info logs info messages
 func (l *Logger) Info(v ...interface{}) {
-	if l.level <= INFO {
+	if l.Level() <= INFO {
 		l.info.Println(v...)
 	}
 }
 
 // Infof logs formatted info messages
 func (l *Logger) Infof(format string, v ...interface{}) {
-	if l.level <= INFO {
+	if l.Level() <= INFO {
 		l.info.Printf(format, v...)
 	}
 }
 
+// Access logs an access log line as it is, without prefix or timestamp, at
+// the info level
+func (l *Logger) Access(line string) {
+	if l.Level() <= INFO {
+		l.access.Println(line)
+	}
+}
+
 // Warn logs warning messages
 func (l *Logger) Warn(v ...interface{}) {
-	if l.level <= WARN {
+	if l.Level() <= WARN {
 		l.warn.Println(v...)
 	}
 }
 
 // Warnf logs formatted warning messages
 func (l *Logger) Warnf(format string, v ...interface{}) {
-	if l.level <= WARN {
+	if l.Level() <= WARN {
 		l.warn.Printf(format, v...)
 	}
 }
 
 // Error logs error messages
 func (l *Logger) Error(v ...interface{}) {
-	if l.level <= ERROR {
+	if l.Level() <= ERROR {
 		l.errLog.Println(v...)
 	}
 }
 
 // Errorf logs formatted error messages
 func (l *Logger) Errorf(format string, v ...interface{}) {
-	if l.level <= ERROR {
+	if l.Level() <= ERROR {
 		l.errLog.Printf(format, v...)
 	}
 }
@@ -104,16 +175,20 @@ func (l *Logger) Fatalf(format string, v ...interface{}) {
 }
 
 func parseLevel(levelStr string) Level {
-	switch levelStr {
-	case "debug":
-		return DEBUG
-	case "info":
-		return INFO
-	case "warn":
-		return WARN
-	case "error":
-		return ERROR
-	default:
-		return INFO
-	}
+	level, _ := ParseLevel(levelStr)
+	return level
+}
+
+type debugKey struct{}
+
+// WithDebug returns a copy of ctx whose DebugContextf messages are logged
+// whatever the level
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey{}, true)
+}
+
+// Debugging reports whether ctx has WithDebug
+func Debugging(ctx context.Context) bool {
+	debug, _ := ctx.Value(debugKey{}).(bool)
+	return debug
 }
@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+)
+
+// What the log shows of filenames, as LOG_FILENAMES takes it
+const (
+	FilenamesFull      = "full"      // the filename as sent
+	FilenamesExtension = "extension" // only the extension, as "*.pdf"
+	FilenamesHash      = "hash"      // a short hash and the extension, to follow one file
+)
+
+// maxExtensionLength bounds the extension logs show, dot included
+const maxExtensionLength = 10
+
+// keyPrefixLength is how much of an API key logs show, enough to tell keys
+// apart but not to use one
+const keyPrefixLength = 8
+
+// KeyPrefix returns the start of key for logs: at most keyPrefixLength
+// characters and half the key, so short keys aren't given away
+func KeyPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key[:min(keyPrefixLength, len(key)/2)] + "..."
+}
+
+// SetFilenames sets what Filename shows, one of the Filenames policies;
+// anything else is FilenamesExtension
+func (l *Logger) SetFilenames(policy string) {
+	l.filenames.Store(policy)
+}
+
+// Filename returns what the log may show of a client's filename or object
+// key under the Filenames policy, by default only its extension
+func (l *Logger) Filename(name string) string {
+	policy, _ := l.filenames.Load().(string)
+	ext := strings.ToLower(path.Ext(strings.ReplaceAll(name, `\`, "/")))
+	if len(ext) > maxExtensionLength || strings.ContainsFunc(ext[min(len(ext), 1):], notAlphanumeric) {
+		ext = ""
+	}
+	switch policy {
+	case FilenamesFull:
+		return name
+	case FilenamesHash:
+		sum := sha256.Sum256([]byte(name))
+		return hex.EncodeToString(sum[:4]) + ext
+	default:
+		return "*" + ext
+	}
+}
+
+// notAlphanumeric reports whether c is not an ASCII letter or digit
+func notAlphanumeric(c rune) bool {
+	return (c < 'a' || c > 'z') && (c < '0' || c > '9')
+}
@@ -0,0 +1,42 @@
+package logger
+
+import "testing"
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "", want: ""},
+		{key: "abcd", want: "ab..."},
+		{key: "abcdefghijklmnopqrstuvwxyz", want: "abcdefgh..."},
+	}
+	for _, tt := range tests {
+		if got := KeyPrefix(tt.key); got != tt.want {
+			t.Errorf("KeyPrefix(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestFilename(t *testing.T) {
+	tests := []struct {
+		policy string
+		name   string
+		want   string
+	}{
+		{policy: "", name: "Jane Doe passport.PDF", want: "*.pdf"},
+		{policy: FilenamesExtension, name: "reports/2024/q1.xlsx", want: "*.xlsx"},
+		{policy: FilenamesExtension, name: `C:\Users\jane\notes`, want: "*"},
+		{policy: FilenamesExtension, name: "evil.\n[INFO] forged", want: "*"},
+		{policy: FilenamesExtension, name: "photo.verylongextension", want: "*"},
+		{policy: FilenamesFull, name: "Jane Doe passport.pdf", want: "Jane Doe passport.pdf"},
+		{policy: FilenamesHash, name: "Jane Doe passport.pdf", want: "42b43656.pdf"},
+	}
+	for _, tt := range tests {
+		log := New("info")
+		log.SetFilenames(tt.policy)
+		if got := log.Filename(tt.name); got != tt.want {
+			t.Errorf("Filename(%q) with %q = %q, want %q", tt.name, tt.policy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package metadata
+
+import "fmt"
+
+// AIHeuristicSignal is the metadata-heuristic verdict kept alongside a
+// blended result
+type AIHeuristicSignal struct {
+	LikelyAIGenerated bool    `json:"likely_ai_generated"`
+	Probability       float64 `json:"probability"`
+	Confidence        string  `json:"confidence"`
+}
+
+// AIClassifierSignal is an external classifier's verdict. Error is set and
+// Score omitted when the classifier could not be reached.
+type AIClassifierSignal struct {
+	Backend           string  `json:"backend"`
+	Model             string  `json:"model,omitempty"`
+	Score             float64 `json:"score"` // probability of AI generation
+	LikelyAIGenerated bool    `json:"likely_ai_generated"`
+	Error             string  `json:"error,omitempty"`
+}
+
+// BlendAIClassification combines the EXIF heuristics in detection with an
+// external classifier's verdict. weight is the classifier's share of the
+// blend, which pools both probabilities in log-odds space. Both inputs are
+// kept in Heuristic and Classifier, and the top-level verdict reflects the
+// blend. A classifier error leaves the heuristic verdict in place.
+func BlendAIClassification(detection *AIDetection, classifier *AIClassifierSignal, weight float64) *AIDetection {
+	if detection == nil || classifier == nil {
+		return detection
+	}
+
+	blended := *detection
+	blended.Heuristic = &AIHeuristicSignal{
+		LikelyAIGenerated: detection.LikelyAIGenerated,
+		Probability:       detection.Probability,
+		Confidence:        detection.Confidence,
+	}
+	blended.Classifier = classifier
+
+	if classifier.Error != "" {
+		blended.Signals = append(append([]Signal{}, detection.Signals...), Signal{Code: ReasonClassifierUnavailable})
+		blended.Indicators = append(append([]string{}, detection.Indicators...), string(ReasonClassifierUnavailable))
+		return &blended
+	}
+
+	// The heuristic's log-odds, prior included, are scaled down by the
+	// classifier's share so the reported signal weights still add up
+	weight = min(max(weight, 0), 1)
+	s := newScorer(0)
+	for _, signal := range detection.Signals {
+		s.add(signal.Code, round2(signal.Weight*(1-weight)))
+	}
+	s.bias = logit(detection.Probability)*(1-weight) - s.logOdds()
+
+	code := ReasonClassifierAuthentic
+	if classifier.LikelyAIGenerated {
+		code = ReasonClassifierAI
+	}
+	s.add(code, round2(logit(classifier.Score)*weight))
+
+	blended.apply(s)
+	blended.Reasons = append(append([]string{}, detection.Reasons...),
+		fmt.Sprintf("External classifier scored %.2f; blended probability %.2f", classifier.Score, blended.Probability))
+
+	return &blended
+}
@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBlendAIClassification(t *testing.T) {
+	// Heuristics flag an EXIF-stripped photo as AI-generated
+	stripped := (&AIDetection{}).apply(&scorer{
+		bias: aiImageBias,
+		signals: []Signal{
+			{Code: ReasonNoCameraMetadata, Weight: aiWeightNoCameraMetadata},
+			{Code: ReasonNoEXIF, Weight: aiWeightNoEXIF},
+		},
+	})
+
+	tests := []struct {
+		name               string
+		classifier         *AIClassifierSignal
+		weight             float64
+		expectedAI         bool
+		expectedConfidence string
+		expectedIndicator  string
+	}{
+		{
+			name:               "classifier overrides stripped EXIF",
+			classifier:         &AIClassifierSignal{Backend: "external", Score: 0.05},
+			weight:             0.7,
+			expectedAI:         false,
+			expectedConfidence: "medium",
+			expectedIndicator:  "classifier_authentic",
+		},
+		{
+			name:               "classifier agrees",
+			classifier:         &AIClassifierSignal{Backend: "external", Score: 0.97, LikelyAIGenerated: true},
+			weight:             0.7,
+			expectedAI:         true,
+			expectedConfidence: "high",
+			expectedIndicator:  "classifier_ai_generated",
+		},
+		{
+			name:               "zero weight keeps heuristics",
+			classifier:         &AIClassifierSignal{Backend: "external", Score: 0.0},
+			weight:             0,
+			expectedAI:         true,
+			expectedConfidence: "high",
+			expectedIndicator:  "classifier_authentic",
+		},
+		{
+			name:               "classifier error",
+			classifier:         &AIClassifierSignal{Backend: "external", Error: "classifier unavailable"},
+			weight:             0.7,
+			expectedAI:         true,
+			expectedConfidence: "high",
+			expectedIndicator:  "classifier_unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blended := BlendAIClassification(stripped, tt.classifier, tt.weight)
+
+			if blended.LikelyAIGenerated != tt.expectedAI {
+				t.Errorf("LikelyAIGenerated = %v, want %v (probability %v)", blended.LikelyAIGenerated, tt.expectedAI, blended.Probability)
+			}
+
+			if blended.Confidence != tt.expectedConfidence {
+				t.Errorf("Confidence = %v, want %v (probability %v)", blended.Confidence, tt.expectedConfidence, blended.Probability)
+			}
+
+			if blended.Heuristic == nil || blended.Heuristic.Probability != stripped.Probability {
+				t.Errorf("Heuristic = %+v, want original heuristic verdict", blended.Heuristic)
+			}
+
+			if blended.Classifier != tt.classifier {
+				t.Errorf("Classifier = %+v, want %+v", blended.Classifier, tt.classifier)
+			}
+
+			if last := blended.Indicators[len(blended.Indicators)-1]; last != tt.expectedIndicator {
+				t.Errorf("Indicators = %v, want %v last", blended.Indicators, tt.expectedIndicator)
+			}
+
+			// The blend pools both probabilities in log-odds space
+			if tt.classifier.Error == "" {
+				pooled := sigmoid(logit(stripped.Probability)*(1-tt.weight) + logit(tt.classifier.Score)*tt.weight)
+				if math.Abs(pooled-blended.Probability) > 0.011 {
+					t.Errorf("Probability = %v, want %.2f", blended.Probability, pooled)
+				}
+			}
+		})
+	}
+
+	// The heuristic result must not be modified in place
+	if len(stripped.Indicators) != 2 || stripped.Heuristic != nil {
+		t.Errorf("input detection was modified: %+v", stripped)
+	}
+
+	if BlendAIClassification(stripped, nil, 0.7) != stripped {
+		t.Error("BlendAIClassification() without a classifier should return the input")
+	}
+}
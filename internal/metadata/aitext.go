@@ -0,0 +1,212 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// TextAIMetrics are the statistics behind a document's AI-generation verdict
+type TextAIMetrics struct {
+	Burstiness       float64  `json:"burstiness"`        // sentence length coefficient of variation
+	CompressionRatio float64  `json:"compression_ratio"` // perplexity proxy, lower is more predictable
+	BoilerplateRate  float64  `json:"boilerplate_rate"`  // boilerplate phrases per 1000 words
+	MatchedPhrases   []string `json:"matched_phrases,omitempty"`
+}
+
+const (
+	// Documents shorter than this don't yield stable statistics
+	aiTextMinWords     = 100
+	aiTextMinSentences = 5
+
+	// aiTextSampleSize bounds how much text is compressed for the
+	// perplexity proxy
+	aiTextSampleSize = 64 * 1024
+
+	// Human prose typically varies sentence length with a coefficient of
+	// variation of 0.5 or more; generated text is more uniform
+	lowBurstinessThreshold = 0.35
+
+	// Deflate ratio below which text is unusually predictable
+	lowCompressionThreshold = 0.3
+
+	// Boilerplate phrases per 1000 words above which phrasing is flagged
+	boilerplateRateThreshold = 4.0
+)
+
+// AI-text signal weights in log-odds. The prior puts prose with no evidence
+// either way at about 12% likely generated.
+const (
+	aiTextBias                  = -2.0
+	aiTextWeightDisclaimer      = 6.0
+	aiTextWeightBurstiness      = 1.6
+	aiTextWeightPerplexity      = 1.6
+	aiTextWeightBoilerplate     = 2.8
+	aiTextWeightSomeBoilerplate = 0.8
+	aiTextWeightHumanLike       = -0.5
+)
+
+// aiDisclaimerPhrases are self-references left behind by chat assistants
+var aiDisclaimerPhrases = []string{
+	"as an ai language model",
+	"as a large language model",
+	"as an ai assistant",
+	"i'm an ai developed by",
+	"regenerate response",
+	"certainly! here",
+}
+
+// aiBoilerplatePhrases are stock phrasings overrepresented in generated text
+var aiBoilerplatePhrases = []string{
+	"it is important to note",
+	"it's important to note",
+	"it is worth noting",
+	"it's worth noting",
+	"in today's fast-paced",
+	"in today's digital age",
+	"in the ever-evolving",
+	"ever-evolving landscape",
+	"delve into",
+	"delves into",
+	"plays a crucial role",
+	"play a crucial role",
+	"a testament to",
+	"rich tapestry",
+	"navigate the complexities",
+	"unlock the potential",
+	"harness the power",
+	"a myriad of",
+	"in conclusion,",
+	"in summary,",
+	"overall,",
+	"furthermore,",
+	"moreover,",
+	"additionally,",
+	"ultimately,",
+	"when it comes to",
+	"whether you're",
+	"i hope this helps",
+}
+
+// detectAIText scores prose for signs of machine generation from sentence
+// length uniformity (burstiness), compressibility as a perplexity proxy and
+// boilerplate phrasing. Returns nil when the text is too short to judge.
+func detectAIText(content string) *AIDetection {
+	sentences := splitSentences(content)
+	lengths := make([]float64, 0, len(sentences))
+	words := 0
+	for _, sentence := range sentences {
+		n := len(tokenizeWords(sentence))
+		lengths = append(lengths, float64(n))
+		words += n
+	}
+	if words < aiTextMinWords || len(sentences) < aiTextMinSentences {
+		return nil
+	}
+
+	detection := &AIDetection{Reasons: []string{}}
+	score := newScorer(aiTextBias)
+
+	lower := strings.ToLower(content)
+
+	// Check 1: Chat assistant disclaimers (conclusive on their own)
+	for _, phrase := range aiDisclaimerPhrases {
+		if strings.Contains(lower, phrase) {
+			score.add(ReasonAIDisclaimer, aiTextWeightDisclaimer)
+			detection.Reasons = append(detection.Reasons, fmt.Sprintf("Text contains AI assistant phrasing: %q", phrase))
+			return detection.apply(score)
+		}
+	}
+
+	metrics := &TextAIMetrics{
+		Burstiness:       round2(coefficientOfVariation(lengths)),
+		CompressionRatio: round2(compressionRatio(content)),
+	}
+	detection.Metrics = metrics
+
+	matches := 0
+	matched := make(map[string]bool)
+	for _, phrase := range aiBoilerplatePhrases {
+		if n := strings.Count(lower, phrase); n > 0 {
+			matches += n
+			matched[strings.TrimSuffix(phrase, ",")] = true
+		}
+	}
+	metrics.BoilerplateRate = round2(float64(matches) / float64(words) * 1000)
+	metrics.MatchedPhrases = sortedKeys(matched)
+
+	// Check 2: Uniform sentence lengths
+	if metrics.Burstiness < lowBurstinessThreshold {
+		score.add(ReasonLowBurstiness, aiTextWeightBurstiness)
+		detection.Reasons = append(detection.Reasons, fmt.Sprintf("Sentence lengths are unusually uniform (variation %.2f)", metrics.Burstiness))
+	}
+
+	// Check 3: Highly predictable wording
+	if metrics.CompressionRatio < lowCompressionThreshold {
+		score.add(ReasonLowPerplexity, aiTextWeightPerplexity)
+		detection.Reasons = append(detection.Reasons, fmt.Sprintf("Text is highly predictable (compression ratio %.2f)", metrics.CompressionRatio))
+	}
+
+	// Check 4: Stock phrasing, weighted higher when it's dense and varied
+	if metrics.BoilerplateRate >= boilerplateRateThreshold && len(matched) >= 2 {
+		score.add(ReasonBoilerplate, aiTextWeightBoilerplate)
+		detection.Reasons = append(detection.Reasons, fmt.Sprintf("Frequent boilerplate phrasing: %s", strings.Join(metrics.MatchedPhrases, ", ")))
+	} else if matches > 0 {
+		score.add(ReasonSomeBoilerplate, aiTextWeightSomeBoilerplate)
+	}
+
+	if len(score.signals) == 0 {
+		// Varied, unpredictable prose without stock phrasing
+		score.add(ReasonHumanLikeVariance, aiTextWeightHumanLike)
+		detection.Reasons = append(detection.Reasons, "Sentence structure and wording vary like human writing")
+	}
+
+	detection.apply(score)
+	if detection.Confidence == "low" {
+		detection.Reasons = append(detection.Reasons, "Insufficient evidence to determine if AI-generated")
+	}
+
+	return detection
+}
+
+// coefficientOfVariation returns the standard deviation divided by the mean
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance/float64(len(values))) / mean
+}
+
+// compressionRatio returns the deflated size over the original size of a
+// normalized text sample. Predictable, repetitive text compresses better,
+// which makes the ratio a cheap stand-in for language model perplexity.
+func compressionRatio(content string) float64 {
+	sample := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	if len(sample) > aiTextSampleSize {
+		sample = sample[:aiTextSampleSize]
+	}
+	if sample == "" {
+		return 0
+	}
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write([]byte(sample))
+	w.Close()
+	return float64(buf.Len()) / float64(len(sample))
+}
@@ -0,0 +1,110 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+)
+
+const humanProse = `I missed the bus again. Third time this week, which is a record even for me. ` +
+	`The driver saw me running, I swear he did, and he just pulled away with that little shrug they all seem to learn in training school somewhere outside Leeds. ` +
+	`So I walked. Forty minutes in drizzle, past the chip shop that closed in March and the new gym nobody goes to. ` +
+	`Wet socks. ` +
+	`By the time I got to the office my manager had already started the stand-up and was halfway through a story about her cat's thyroid medication, which, honestly, was more interesting than anything on the sprint board. ` +
+	`Nobody noticed I was late. ` +
+	`Or maybe they did and didn't care; hard to say with this lot. ` +
+	`Lunch was a sad sandwich from the vending machine, egg and cress, slightly damp around the edges, and I ate it standing at the window watching pigeons fight over a crust. ` +
+	`Why do I do this to myself? ` +
+	`Tomorrow I'm setting two alarms.`
+
+const generatedProse = `In today's fast-paced world, remote work plays a crucial role in modern business strategy. ` +
+	`It is important to note that flexible schedules can improve employee satisfaction significantly. ` +
+	`Furthermore, companies can reduce their office costs while expanding their talent pools. ` +
+	`Moreover, digital collaboration tools help teams communicate across different time zones. ` +
+	`Additionally, employees often report better focus when working from their own homes. ` +
+	`However, it is worth noting that isolation can become a challenge for some workers. ` +
+	`Organizations should therefore delve into strategies that keep their teams connected. ` +
+	`Ultimately, a balanced hybrid approach can unlock the potential of every employee. ` +
+	`In conclusion, remote work is a testament to the adaptability of the modern workforce.`
+
+func TestDetectAIText(t *testing.T) {
+	tests := []struct {
+		name              string
+		content           string
+		expectNil         bool
+		expectedAI        bool
+		expectedIndicator string
+	}{
+		{
+			name:      "too short to judge",
+			content:   "A short note. Nothing else here.",
+			expectNil: true,
+		},
+		{
+			name:              "varied human prose",
+			content:           humanProse,
+			expectedAI:        false,
+			expectedIndicator: "human_like_variation",
+		},
+		{
+			name:              "uniform boilerplate prose",
+			content:           generatedProse,
+			expectedAI:        true,
+			expectedIndicator: "boilerplate_phrasing",
+		},
+		{
+			name:              "assistant disclaimer",
+			content:           "As an AI language model, I cannot browse the internet. " + humanProse,
+			expectedAI:        true,
+			expectedIndicator: "ai_disclaimer_detected",
+		},
+		{
+			name:              "repetitive text",
+			content:           strings.Repeat("The report covers the quarterly results for the sales team. ", 20),
+			expectedAI:        true,
+			expectedIndicator: "low_perplexity",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detection := detectAIText(tt.content)
+
+			if tt.expectNil {
+				if detection != nil {
+					t.Errorf("detectAIText() = %+v, want nil", detection)
+				}
+				return
+			}
+
+			if detection == nil {
+				t.Fatal("detectAIText() returned nil")
+			}
+
+			if detection.LikelyAIGenerated != tt.expectedAI {
+				t.Errorf("LikelyAIGenerated = %v, want %v (%+v, %+v)", detection.LikelyAIGenerated, tt.expectedAI, detection, detection.Metrics)
+			}
+
+			found := false
+			for _, indicator := range detection.Indicators {
+				if indicator == tt.expectedIndicator {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Indicators = %v, want %v", detection.Indicators, tt.expectedIndicator)
+			}
+		})
+	}
+}
+
+func TestCoefficientOfVariation(t *testing.T) {
+	if got := coefficientOfVariation([]float64{10, 10, 10}); got != 0 {
+		t.Errorf("coefficientOfVariation(uniform) = %v, want 0", got)
+	}
+	if got := round2(coefficientOfVariation([]float64{5, 15})); got != 0.5 {
+		t.Errorf("coefficientOfVariation(5, 15) = %v, want 0.5", got)
+	}
+	if got := coefficientOfVariation(nil); got != 0 {
+		t.Errorf("coefficientOfVariation(nil) = %v, want 0", got)
+	}
+}
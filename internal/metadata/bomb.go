@@ -0,0 +1,238 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DecompressionLimits bounds the work done when inspecting compressed
+// content. Zero fields fall back to DefaultDecompressionLimits.
+type DecompressionLimits struct {
+	MaxRatio int   // maximum decompressed:compressed size ratio
+	MaxDepth int   // maximum nesting of archives inside archives
+	MaxBytes int64 // maximum total decompressed bytes
+}
+
+// DefaultDecompressionLimits are used when no limits are configured
+var DefaultDecompressionLimits = DecompressionLimits{
+	MaxRatio: 100,
+	MaxDepth: 3,
+	MaxBytes: 1 << 30,
+}
+
+// withDefaults fills zero fields from DefaultDecompressionLimits
+func (l DecompressionLimits) withDefaults() DecompressionLimits {
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = DefaultDecompressionLimits.MaxRatio
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultDecompressionLimits.MaxDepth
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultDecompressionLimits.MaxBytes
+	}
+	return l
+}
+
+// DecompressionCheck reports a suspected decompression bomb
+type DecompressionCheck struct {
+	SuspectedBomb     bool     `json:"suspected_bomb"`
+	Format            string   `json:"format"`
+	CompressedBytes   int64    `json:"compressed_bytes"`
+	DecompressedBytes int64    `json:"decompressed_bytes"`
+	Ratio             float64  `json:"ratio"`
+	NestingDepth      int      `json:"nesting_depth,omitempty"`
+	Reasons           []string `json:"reasons,omitempty"`
+}
+
+// maxNestedArchiveBytes caps how large a nested archive may be to be
+// decompressed into memory for inspection
+const maxNestedArchiveBytes = 64 << 20
+
+// nestedArchiveExtensions are entry names inspected as nested archives
+var nestedArchiveExtensions = map[string]bool{
+	".zip": true, ".jar": true, ".war": true, ".apk": true, ".gz": true, ".tgz": true,
+}
+
+// bombScan accumulates sizes while walking an archive
+type bombScan struct {
+	limits DecompressionLimits
+	check  *DecompressionCheck
+	// inflated counts bytes actually decompressed, as opposed to sizes
+	// declared in archive headers
+	inflated int64
+	// truncated is set when a gzip stream hit its budget before ending
+	truncated bool
+}
+
+// checkDecompression inspects archives and images for decompression bombs
+// using headers and bounded decompression only. primary is the
+// extension-style name of the detected format. Returns nil for other types.
+func checkDecompression(r io.ReaderAt, size int64, primary, mimeType string, limits DecompressionLimits) *DecompressionCheck {
+	limits = limits.withDefaults()
+	scan := &bombScan{
+		limits: limits,
+		check:  &DecompressionCheck{Format: primary, CompressedBytes: size},
+	}
+
+	switch {
+	case zipContainerFormats[primary] || primary == "jar":
+		scan.zip(r, size, 1)
+	case primary == "gz":
+		scan.gzip(io.NewSectionReader(r, 0, size), size)
+	case strings.HasPrefix(mimeType, "image/"):
+		config, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil
+		}
+		// Decoded size at 4 bytes per pixel
+		scan.check.DecompressedBytes = saturatingMul(int64(config.Width)*int64(config.Height), 4)
+	default:
+		return nil
+	}
+
+	check := scan.check
+	if check.CompressedBytes > 0 {
+		check.Ratio = round2(float64(check.DecompressedBytes) / float64(check.CompressedBytes))
+	}
+
+	// Images routinely compress flat areas far beyond any archive ratio, so
+	// only their absolute decoded size is limited
+	if !strings.HasPrefix(mimeType, "image/") && check.Ratio > float64(limits.MaxRatio) {
+		check.flag(fmt.Sprintf("expansion ratio %.0f:1 exceeds limit of %d:1", check.Ratio, limits.MaxRatio))
+	}
+	if check.DecompressedBytes > limits.MaxBytes {
+		check.flag(fmt.Sprintf("decompressed size %d bytes exceeds limit of %d bytes", check.DecompressedBytes, limits.MaxBytes))
+	}
+	if scan.truncated && !check.SuspectedBomb {
+		check.flag("nested gzip stream exceeds decompression budget")
+	}
+
+	return check
+}
+
+func (c *DecompressionCheck) flag(reason string) {
+	c.SuspectedBomb = true
+	c.Reasons = append(c.Reasons, reason)
+}
+
+// zip sums declared entry sizes from the central directory, detects
+// overlapping entries and inspects small nested archives
+func (s *bombScan) zip(r io.ReaderAt, size int64, depth int) {
+	if depth > s.check.NestingDepth {
+		s.check.NestingDepth = depth
+	}
+	if depth > s.limits.MaxDepth {
+		s.check.flag(fmt.Sprintf("archive nesting depth exceeds limit of %d", s.limits.MaxDepth))
+		return
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+
+	type span struct{ start, end int64 }
+	spans := make([]span, 0, len(zr.File))
+
+	for _, f := range zr.File {
+		s.check.DecompressedBytes = saturatingAdd(s.check.DecompressedBytes, clampInt64(f.UncompressedSize64))
+
+		if offset, err := f.DataOffset(); err == nil {
+			spans = append(spans, span{offset, saturatingAdd(offset, clampInt64(f.CompressedSize64))})
+		}
+
+		if nestedArchiveExtensions[strings.ToLower(path.Ext(f.Name))] && !s.check.SuspectedBomb {
+			s.nested(f, depth)
+		}
+	}
+
+	// Entries sharing compressed data are the signature of non-recursive
+	// zip bombs, which reach huge ratios without any nesting
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			s.check.flag("archive entries overlap")
+			break
+		}
+	}
+}
+
+// nested decompresses a small archive entry and inspects it recursively
+func (s *bombScan) nested(f *zip.File, depth int) {
+	remaining := s.limits.MaxBytes - s.inflated
+	if f.UncompressedSize64 > maxNestedArchiveBytes || int64(f.UncompressedSize64) > remaining {
+		return
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, int64(f.UncompressedSize64)))
+	if err != nil {
+		return
+	}
+	s.inflated += int64(len(data))
+
+	// Count the nested archive's contents instead of the archive itself
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		s.check.DecompressedBytes -= int64(len(data))
+		s.zip(bytes.NewReader(data), int64(len(data)), depth+1)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		if depth+1 > s.check.NestingDepth {
+			s.check.NestingDepth = depth + 1
+		}
+		s.check.DecompressedBytes -= int64(len(data))
+		s.gzip(bytes.NewReader(data), int64(len(data)))
+	}
+}
+
+// gzip decompresses a stream, stopping as soon as either limit is exceeded
+func (s *bombScan) gzip(r io.Reader, compressed int64) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+
+	budget := min(s.limits.MaxBytes-s.inflated, saturatingMul(compressed, int64(s.limits.MaxRatio)))
+	n, _ := io.Copy(io.Discard, io.LimitReader(gz, budget+1))
+	s.inflated += n
+	s.check.DecompressedBytes = saturatingAdd(s.check.DecompressedBytes, n)
+	if n > budget {
+		s.truncated = true
+	}
+}
+
+func clampInt64(v uint64) int64 {
+	if v > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(v)
+}
+
+func saturatingAdd(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+func saturatingMul(a, b int64) int64 {
+	if a != 0 && b > math.MaxInt64/a {
+		return math.MaxInt64
+	}
+	return a * b
+}
@@ -0,0 +1,152 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func TestCheckDecompression(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 64*1024)
+	rng.Read(random)
+	zeros := make([]byte, 10<<20)
+
+	makeZip := func(name string, data []byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.Create(name)
+		w.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	makeGzip := func(data []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write(data)
+		gw.Close()
+		return buf.Bytes()
+	}
+
+	// Five levels of archives inside archives
+	nested := makeZip("payload.txt", []byte("hello"))
+	for i := 0; i < 4; i++ {
+		nested = makeZip("inner.zip", nested)
+	}
+
+	// A tiny PNG whose header claims 100000x100000 pixels
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	hugePNG := pngBuf.Bytes()
+	binary.BigEndian.PutUint32(hugePNG[16:20], 100000)
+	binary.BigEndian.PutUint32(hugePNG[20:24], 100000)
+	binary.BigEndian.PutUint32(hugePNG[29:33], crc32.ChecksumIEEE(hugePNG[12:29]))
+
+	tests := []struct {
+		name            string
+		data            []byte
+		primary         string
+		mimeType        string
+		limits          DecompressionLimits
+		expectNil       bool
+		expectedSuspect bool
+	}{
+		{
+			name:     "normal zip",
+			data:     makeZip("random.bin", random),
+			primary:  "zip",
+			mimeType: "application/zip",
+		},
+		{
+			name:            "zip with extreme ratio",
+			data:            makeZip("zeros.bin", zeros),
+			primary:         "zip",
+			mimeType:        "application/zip",
+			expectedSuspect: true,
+		},
+		{
+			name:            "deeply nested zip",
+			data:            nested,
+			primary:         "zip",
+			mimeType:        "application/zip",
+			expectedSuspect: true,
+		},
+		{
+			name:     "nested zip within depth limit",
+			data:     nested,
+			primary:  "zip",
+			mimeType: "application/zip",
+			limits:   DecompressionLimits{MaxDepth: 5},
+		},
+		{
+			name:     "normal gzip",
+			data:     makeGzip(random),
+			primary:  "gz",
+			mimeType: "application/gzip",
+		},
+		{
+			name:            "gzip bomb",
+			data:            makeGzip(zeros),
+			primary:         "gz",
+			mimeType:        "application/gzip",
+			expectedSuspect: true,
+		},
+		{
+			name:            "gzip over byte limit",
+			data:            makeGzip(random),
+			primary:         "gz",
+			mimeType:        "application/gzip",
+			limits:          DecompressionLimits{MaxBytes: 1024},
+			expectedSuspect: true,
+		},
+		{
+			name:      "unreadable image",
+			data:      []byte("\x89PNG\r\n\x1a\n"),
+			primary:   "png",
+			mimeType:  "image/png",
+			expectNil: true,
+		},
+		{
+			name:            "PNG with huge dimensions",
+			data:            hugePNG,
+			primary:         "png",
+			mimeType:        "image/png",
+			expectedSuspect: true,
+		},
+		{
+			name:      "not compressed",
+			data:      []byte("plain text"),
+			primary:   "txt",
+			mimeType:  "text/plain",
+			expectNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkDecompression(bytes.NewReader(tt.data), int64(len(tt.data)), tt.primary, tt.mimeType, tt.limits)
+
+			if tt.expectNil {
+				if check != nil {
+					t.Errorf("checkDecompression() = %+v, want nil", check)
+				}
+				return
+			}
+
+			if check == nil {
+				t.Fatal("checkDecompression() returned nil")
+			}
+
+			if check.SuspectedBomb != tt.expectedSuspect {
+				t.Errorf("SuspectedBomb = %v, want %v (%+v)", check.SuspectedBomb, tt.expectedSuspect, check)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Compound File Binary (OLE2) constants, see [MS-CFB]
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	cfbHeaderSize   = 512
+	cfbDirEntrySize = 128
+	cfbEndOfChain   = 0xFFFFFFFE
+	cfbNoStream     = 0xFFFFFFFF
+	cfbMaxSector    = 0xFFFFFFFA
+
+	cfbTypeStorage = 1
+	cfbTypeStream  = 2
+	cfbTypeRoot    = 5
+
+	// cfbMaxStreamSize bounds how much of any one stream is read
+	cfbMaxStreamSize = 32 << 20
+)
+
+var errInvalidCFB = errors.New("invalid compound file")
+
+// cfbEntry is a directory entry with its resolved path
+type cfbEntry struct {
+	name  string
+	path  string
+	kind  byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+// cfbFile is a read-only view of a compound file
+type cfbFile struct {
+	r          io.ReaderAt
+	size       int64
+	sectorSize int64
+	miniSize   int64
+	miniCutoff uint64
+	fat        []uint32
+	miniFAT    []uint32
+	miniStream []byte
+	entries    []cfbEntry
+}
+
+// openCFB parses the header, allocation tables and directory of a compound
+// file
+func openCFB(r io.ReaderAt, size int64) (*cfbFile, error) {
+	header := make([]byte, cfbHeaderSize)
+	if n, _ := r.ReadAt(header, 0); n < cfbHeaderSize || !bytes.Equal(header[:8], cfbSignature) {
+		return nil, errInvalidCFB
+	}
+
+	sectorShift := binary.LittleEndian.Uint16(header[0x1E:])
+	miniShift := binary.LittleEndian.Uint16(header[0x20:])
+	if sectorShift != 9 && sectorShift != 12 || miniShift != 6 {
+		return nil, errInvalidCFB
+	}
+
+	f := &cfbFile{
+		r:          r,
+		size:       size,
+		sectorSize: 1 << sectorShift,
+		miniSize:   1 << miniShift,
+		miniCutoff: uint64(binary.LittleEndian.Uint32(header[0x38:])),
+	}
+
+	// Collect FAT sector locations from the header DIFAT and DIFAT chain
+	numFAT := binary.LittleEndian.Uint32(header[0x2C:])
+	var fatSectors []uint32
+	for i := 0; i < 109 && uint32(len(fatSectors)) < numFAT; i++ {
+		fatSectors = append(fatSectors, binary.LittleEndian.Uint32(header[0x4C+4*i:]))
+	}
+	difat := binary.LittleEndian.Uint32(header[0x44:])
+	perSector := int(f.sectorSize/4) - 1
+	for visited := 0; difat <= cfbMaxSector && uint32(len(fatSectors)) < numFAT; visited++ {
+		if visited > int(size/f.sectorSize) {
+			return nil, errInvalidCFB
+		}
+		sector, err := f.readSector(difat)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector && uint32(len(fatSectors)) < numFAT; i++ {
+			fatSectors = append(fatSectors, binary.LittleEndian.Uint32(sector[4*i:]))
+		}
+		difat = binary.LittleEndian.Uint32(sector[4*perSector:])
+	}
+
+	for _, s := range fatSectors {
+		sector, err := f.readSector(s)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(sector); i += 4 {
+			f.fat = append(f.fat, binary.LittleEndian.Uint32(sector[i:]))
+		}
+	}
+
+	// Directory
+	dir, err := f.readChain(binary.LittleEndian.Uint32(header[0x30:]), cfbMaxStreamSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+cfbDirEntrySize <= len(dir); i += cfbDirEntrySize {
+		f.entries = append(f.entries, parseCFBEntry(dir[i:i+cfbDirEntrySize]))
+	}
+	if len(f.entries) == 0 || f.entries[0].kind != cfbTypeRoot {
+		return nil, errInvalidCFB
+	}
+	f.resolvePaths()
+
+	// Mini FAT and mini stream, which hold streams below the cutoff size
+	if miniFAT, err := f.readChain(binary.LittleEndian.Uint32(header[0x3C:]), cfbMaxStreamSize); err == nil {
+		for i := 0; i+4 <= len(miniFAT); i += 4 {
+			f.miniFAT = append(f.miniFAT, binary.LittleEndian.Uint32(miniFAT[i:]))
+		}
+	}
+	root := f.entries[0]
+	if root.start <= cfbMaxSector && root.size > 0 {
+		f.miniStream, _ = f.readChain(root.start, int64(min(root.size, cfbMaxStreamSize)))
+	}
+
+	return f, nil
+}
+
+func parseCFBEntry(b []byte) cfbEntry {
+	nameLen := int(binary.LittleEndian.Uint16(b[64:]))
+	if nameLen > 64 {
+		nameLen = 64
+	}
+	units := make([]uint16, 0, 32)
+	for i := 0; i+1 < nameLen; i += 2 {
+		u := binary.LittleEndian.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+
+	return cfbEntry{
+		name:  string(utf16.Decode(units)),
+		kind:  b[66],
+		left:  binary.LittleEndian.Uint32(b[68:]),
+		right: binary.LittleEndian.Uint32(b[72:]),
+		child: binary.LittleEndian.Uint32(b[76:]),
+		start: binary.LittleEndian.Uint32(b[116:]),
+		size:  binary.LittleEndian.Uint64(b[120:]) & 0xFFFFFFFF,
+	}
+}
+
+// resolvePaths walks the directory tree from the root, setting each
+// entry's slash-separated path
+func (f *cfbFile) resolvePaths() {
+	visited := make([]bool, len(f.entries))
+	var walkSiblings func(id uint32, parent int)
+	walkSiblings = func(id uint32, parent int) {
+		if id == cfbNoStream || int(id) >= len(f.entries) || visited[id] {
+			return
+		}
+		visited[id] = true
+		e := &f.entries[id]
+		if parent > 0 {
+			e.path = f.entries[parent].path + "/" + e.name
+		} else {
+			e.path = e.name
+		}
+		walkSiblings(e.left, parent)
+		walkSiblings(e.right, parent)
+		if e.kind == cfbTypeStorage {
+			walkSiblings(e.child, int(id))
+		}
+	}
+	visited[0] = true
+	walkSiblings(f.entries[0].child, 0)
+}
+
+// find returns the first entry whose path matches, case-insensitively
+func (f *cfbFile) find(path string) *cfbEntry {
+	for i := range f.entries {
+		if strings.EqualFold(f.entries[i].path, path) {
+			return &f.entries[i]
+		}
+	}
+	return nil
+}
+
+// storages returns the paths of all storages named name
+func (f *cfbFile) storages(name string) []string {
+	var paths []string
+	for _, e := range f.entries {
+		if e.kind == cfbTypeStorage && strings.EqualFold(e.name, name) && e.path != "" {
+			paths = append(paths, e.path)
+		}
+	}
+	return paths
+}
+
+// readStream returns the contents of a stream entry
+func (f *cfbFile) readStream(e *cfbEntry) ([]byte, error) {
+	if e.kind != cfbTypeStream {
+		return nil, errInvalidCFB
+	}
+	size := int64(min(e.size, cfbMaxStreamSize))
+	if e.size < f.miniCutoff {
+		return f.readMiniChain(e.start, size)
+	}
+	return f.readChain(e.start, size)
+}
+
+func (f *cfbFile) readSector(n uint32) ([]byte, error) {
+	offset := (int64(n) + 1) * f.sectorSize
+	if n > cfbMaxSector || offset+f.sectorSize > f.size {
+		return nil, errInvalidCFB
+	}
+	sector := make([]byte, f.sectorSize)
+	if _, err := f.r.ReadAt(sector, offset); err != nil {
+		return nil, err
+	}
+	return sector, nil
+}
+
+// readChain follows a FAT chain, returning at most limit bytes
+func (f *cfbFile) readChain(start uint32, limit int64) ([]byte, error) {
+	var out []byte
+	for n, steps := start, 0; n != cfbEndOfChain && int64(len(out)) < limit; steps++ {
+		if int(n) >= len(f.fat) || steps > len(f.fat) {
+			return nil, errInvalidCFB
+		}
+		sector, err := f.readSector(n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sector...)
+		n = f.fat[n]
+	}
+	if int64(len(out)) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// readMiniChain follows a mini FAT chain through the mini stream
+func (f *cfbFile) readMiniChain(start uint32, limit int64) ([]byte, error) {
+	var out []byte
+	for n, steps := start, 0; n != cfbEndOfChain && int64(len(out)) < limit; steps++ {
+		offset := int64(n) * f.miniSize
+		if int(n) >= len(f.miniFAT) || steps > len(f.miniFAT) || offset+f.miniSize > int64(len(f.miniStream)) {
+			return nil, errInvalidCFB
+		}
+		out = append(out, f.miniStream[offset:offset+f.miniSize]...)
+		n = f.miniFAT[n]
+	}
+	if int64(len(out)) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
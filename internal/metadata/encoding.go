@@ -0,0 +1,225 @@
+package metadata
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Character encoding names reported in DocumentMetadata.Encoding
+const (
+	EncodingASCII       = "ASCII"
+	EncodingUTF8        = "UTF-8"
+	EncodingUTF16LE     = "UTF-16LE"
+	EncodingUTF16BE     = "UTF-16BE"
+	EncodingUTF32LE     = "UTF-32LE"
+	EncodingUTF32BE     = "UTF-32BE"
+	EncodingShiftJIS    = "Shift_JIS"
+	EncodingWindows1252 = "Windows-1252"
+	EncodingISO88591    = "ISO-8859-1"
+)
+
+// encodingResult describes the detected character encoding of a text sample
+type encodingResult struct {
+	Name       string
+	Confidence float64
+	HasBOM     bool
+}
+
+// detectEncoding guesses the character encoding of a text sample using BOM
+// sniffing, UTF-8 validation and byte-distribution heuristics for common
+// legacy encodings
+func detectEncoding(data []byte) encodingResult {
+	if len(data) == 0 {
+		return encodingResult{}
+	}
+
+	// Check 1: Byte order marks are authoritative.
+	// UTF-32 must be checked before UTF-16 since FF FE is a prefix of FF FE 00 00.
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return encodingResult{Name: EncodingUTF8, Confidence: 1.0, HasBOM: true}
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE, 0x00, 0x00}):
+		return encodingResult{Name: EncodingUTF32LE, Confidence: 1.0, HasBOM: true}
+	case bytes.HasPrefix(data, []byte{0x00, 0x00, 0xFE, 0xFF}):
+		return encodingResult{Name: EncodingUTF32BE, Confidence: 1.0, HasBOM: true}
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return encodingResult{Name: EncodingUTF16LE, Confidence: 1.0, HasBOM: true}
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return encodingResult{Name: EncodingUTF16BE, Confidence: 1.0, HasBOM: true}
+	}
+
+	// Check 2: BOM-less UTF-16 shows up as NUL bytes in every other position
+	if name, confidence := detectUTF16Pattern(data); name != "" {
+		return encodingResult{Name: name, Confidence: confidence}
+	}
+
+	// Check 3: Pure 7-bit ASCII is valid in every encoding we report
+	highBytes := 0
+	for _, b := range data {
+		if b >= 0x80 {
+			highBytes++
+		}
+	}
+	if highBytes == 0 {
+		return encodingResult{Name: EncodingASCII, Confidence: 1.0}
+	}
+
+	// Check 4: Valid UTF-8 with multi-byte sequences is very unlikely by chance.
+	// The sample may have been cut mid-character, so ignore a trailing partial rune.
+	if utf8.Valid(trimPartialRune(data)) {
+		confidence := 0.9
+		if highBytes >= 8 {
+			confidence = 0.99
+		}
+		return encodingResult{Name: EncodingUTF8, Confidence: confidence}
+	}
+
+	// Check 5: Shift-JIS has a strict lead/trail byte structure
+	if pairs, ok := validShiftJIS(data); ok && pairs > 0 {
+		confidence := 0.6
+		if pairs >= 8 {
+			confidence = 0.8
+		}
+		return encodingResult{Name: EncodingShiftJIS, Confidence: confidence}
+	}
+
+	// Check 6: Single-byte Western encodings. Bytes 0x80-0x9F are C1 control
+	// codes in ISO-8859-1 but printable characters (curly quotes, dashes,
+	// euro sign) in Windows-1252, which is what they almost always mean.
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			return encodingResult{Name: EncodingWindows1252, Confidence: 0.7}
+		}
+	}
+
+	return encodingResult{Name: EncodingISO88591, Confidence: 0.6}
+}
+
+// detectUTF16Pattern looks for the alternating NUL byte pattern produced by
+// mostly-Latin text encoded as UTF-16 without a BOM
+func detectUTF16Pattern(data []byte) (string, float64) {
+	if len(data) < 4 {
+		return "", 0
+	}
+
+	evenZeros, oddZeros := 0, 0
+	pairs := len(data) / 2
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 {
+			evenZeros++
+		}
+		if data[i+1] == 0 {
+			oddZeros++
+		}
+	}
+
+	// Require the NUL bytes to be concentrated on one side
+	evenRatio := float64(evenZeros) / float64(pairs)
+	oddRatio := float64(oddZeros) / float64(pairs)
+	switch {
+	case oddRatio > 0.4 && evenRatio < 0.05:
+		return EncodingUTF16LE, 0.8
+	case evenRatio > 0.4 && oddRatio < 0.05:
+		return EncodingUTF16BE, 0.8
+	}
+
+	return "", 0
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence at the end of data
+func trimPartialRune(data []byte) []byte {
+	// A UTF-8 sequence is at most 4 bytes, so only the last 3 bytes can be
+	// the start of a truncated rune
+	for i := 1; i <= 3 && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if b < 0x80 {
+			return data
+		}
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			return data
+		}
+	}
+	return data
+}
+
+// validShiftJIS reports whether data is structurally valid Shift-JIS and how
+// many double-byte characters it contains. Latin-1 text often is too: an
+// accented lowercase letter (0xE0-0xFC) followed by an ASCII letter reads as
+// a kanji lead and trail byte. Such pairs are rare in Japanese, so data where
+// they make up half the pairs or more is rejected.
+func validShiftJIS(data []byte) (int, bool) {
+	pairs, latin := 0, 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b < 0x80:
+			// ASCII / JIS-Roman
+		case b >= 0xA1 && b <= 0xDF:
+			// Half-width katakana
+		case (b >= 0x81 && b <= 0x9F) || (b >= 0xE0 && b <= 0xFC):
+			if i+1 >= len(data) {
+				// Truncated sample, tolerate a dangling lead byte
+				return pairs, latin*2 < pairs
+			}
+			t := data[i+1]
+			if t < 0x40 || t == 0x7F || t > 0xFC {
+				return pairs, false
+			}
+			if b >= 0xE0 && isASCIILetter(t) {
+				latin++
+			}
+			pairs++
+			i++
+		default:
+			return pairs, false
+		}
+	}
+	return pairs, latin*2 < pairs
+}
+
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// decodeText converts a sample in a multi-byte Unicode encoding to a Go
+// string so that line and word counts are computed on characters rather
+// than raw bytes. Single-byte encodings are returned unchanged since their
+// ASCII range (newlines, spaces) is identical.
+func decodeText(data []byte, enc encodingResult) string {
+	switch enc.Name {
+	case EncodingUTF8:
+		if enc.HasBOM {
+			return string(data[3:])
+		}
+	case EncodingUTF16LE, EncodingUTF16BE:
+		if enc.HasBOM {
+			data = data[2:]
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if enc.Name == EncodingUTF16LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		return string(utf16.Decode(units))
+	case EncodingUTF32LE, EncodingUTF32BE:
+		data = data[4:]
+		runes := make([]rune, len(data)/4)
+		for i := range runes {
+			b := data[4*i : 4*i+4]
+			if enc.Name == EncodingUTF32LE {
+				runes[i] = rune(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
+			} else {
+				runes[i] = rune(uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]))
+			}
+		}
+		return string(runes)
+	}
+	return string(data)
+}
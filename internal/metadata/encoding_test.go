@@ -0,0 +1,124 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		expectedName string
+		expectedBOM  bool
+	}{
+		{
+			name:         "empty input",
+			data:         []byte{},
+			expectedName: "",
+		},
+		{
+			name:         "plain ASCII",
+			data:         []byte("Hello, World!\n"),
+			expectedName: EncodingASCII,
+		},
+		{
+			name:         "UTF-8 with BOM",
+			data:         append([]byte{0xEF, 0xBB, 0xBF}, []byte("hello")...),
+			expectedName: EncodingUTF8,
+			expectedBOM:  true,
+		},
+		{
+			name:         "UTF-8 without BOM",
+			data:         []byte("naïve café — déjà vu"),
+			expectedName: EncodingUTF8,
+		},
+		{
+			name:         "UTF-8 truncated mid-rune",
+			data:         []byte("café ü")[:len("café ü")-1],
+			expectedName: EncodingUTF8,
+		},
+		{
+			name:         "UTF-16LE with BOM",
+			data:         []byte{0xFF, 0xFE, 'h', 0, 'i', 0},
+			expectedName: EncodingUTF16LE,
+			expectedBOM:  true,
+		},
+		{
+			name:         "UTF-16BE with BOM",
+			data:         []byte{0xFE, 0xFF, 0, 'h', 0, 'i'},
+			expectedName: EncodingUTF16BE,
+			expectedBOM:  true,
+		},
+		{
+			name:         "UTF-32LE with BOM",
+			data:         []byte{0xFF, 0xFE, 0, 0, 'h', 0, 0, 0},
+			expectedName: EncodingUTF32LE,
+			expectedBOM:  true,
+		},
+		{
+			name:         "UTF-16LE without BOM",
+			data:         []byte{'h', 0, 'e', 0, 'l', 0, 'l', 0, 'o', 0},
+			expectedName: EncodingUTF16LE,
+		},
+		{
+			name:         "Windows-1252 smart quotes",
+			data:         []byte{0x93, 'q', 'u', 'o', 't', 'e', 0x94, ' ', 0x80, '5'},
+			expectedName: EncodingWindows1252,
+		},
+		{
+			name:         "ISO-8859-1 accented text",
+			data:         []byte{'c', 'a', 'f', 0xE9, ' ', 'n', 'a', 0xEF, 'v', 'e'},
+			expectedName: EncodingISO88591,
+		},
+		{
+			name:         "ISO-8859-1 French text",
+			data:         []byte("Le syst\xe8me \xe9l\xe8ve des probl\xe8mes"),
+			expectedName: EncodingISO88591,
+		},
+		{
+			name:         "ISO-8859-1 German text",
+			data:         []byte("Gr\xfc\xdfe aus M\xfcnchen und K\xf6ln"),
+			expectedName: EncodingISO88591,
+		},
+		{
+			name: "Shift-JIS Japanese text",
+			// "こんにちは" in Shift-JIS
+			data:         []byte{0x82, 0xB1, 0x82, 0xF1, 0x82, 0xC9, 0x82, 0xBF, 0x82, 0xCD},
+			expectedName: EncodingShiftJIS,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := detectEncoding(tt.data)
+
+			if result.Name != tt.expectedName {
+				t.Errorf("Name = %v, want %v", result.Name, tt.expectedName)
+			}
+
+			if result.HasBOM != tt.expectedBOM {
+				t.Errorf("HasBOM = %v, want %v", result.HasBOM, tt.expectedBOM)
+			}
+
+			if tt.expectedName != "" && (result.Confidence <= 0 || result.Confidence > 1) {
+				t.Errorf("Confidence = %v, want value in (0, 1]", result.Confidence)
+			}
+		})
+	}
+}
+
+func TestDecodeTextUTF16(t *testing.T) {
+	// "a b\nc" encoded as UTF-16LE with BOM
+	data := []byte{0xFF, 0xFE, 'a', 0, ' ', 0, 'b', 0, '\n', 0, 'c', 0}
+
+	text := decodeText(data, detectEncoding(data))
+
+	if text != "a b\nc" {
+		t.Errorf("decodeText() = %q, want %q", text, "a b\nc")
+	}
+
+	if words := len(strings.Fields(text)); words != 3 {
+		t.Errorf("word count = %d, want 3", words)
+	}
+}
@@ -0,0 +1,454 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// EncryptionInfo reports a password-protected archive or document, which
+// can't be inspected further
+type EncryptionInfo struct {
+	Encrypted        bool   `json:"encrypted"`
+	Format           string `json:"format"`
+	Algorithm        string `json:"algorithm,omitempty"`
+	HeadersEncrypted bool   `json:"headers_encrypted,omitempty"`
+	EncryptedEntries int    `json:"encrypted_entries,omitempty"`
+	TotalEntries     int    `json:"total_entries,omitempty"`
+}
+
+// detectEncryption checks ZIP, 7z, RAR and PDF files for password
+// protection. primary is the extension-style name of the detected format.
+// Returns nil when the file isn't encrypted or the format isn't supported.
+func detectEncryption(r io.ReaderAt, size int64, primary string) *EncryptionInfo {
+	var info *EncryptionInfo
+	switch {
+	case zipContainerFormats[primary]:
+		info = zipEncryption(r, size)
+	case primary == "7z":
+		info = sevenZipEncryption(r, size)
+	case primary == "rar":
+		info = rarEncryption(r, size)
+	case primary == "pdf":
+		info = pdfEncryption(r, size)
+	}
+
+	if info == nil || !info.Encrypted {
+		return nil
+	}
+	info.Format = primary
+	return info
+}
+
+// ZIP general purpose flags and methods
+const (
+	zipFlagEncrypted       = 0x0001
+	zipFlagStrongEncrypted = 0x0040
+	zipMethodAES           = 99
+	zipExtraAES            = 0x9901
+)
+
+func zipEncryption(r io.ReaderAt, size int64) *EncryptionInfo {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil
+	}
+
+	info := &EncryptionInfo{TotalEntries: len(zr.File)}
+	for _, f := range zr.File {
+		if f.Flags&zipFlagEncrypted == 0 {
+			continue
+		}
+		info.Encrypted = true
+		info.EncryptedEntries++
+		if info.Algorithm == "" {
+			info.Algorithm = zipEntryAlgorithm(f)
+		}
+	}
+	return info
+}
+
+// zipEntryAlgorithm identifies WinZip AES, PKWARE strong encryption or the
+// legacy ZipCrypto stream cipher
+func zipEntryAlgorithm(f *zip.File) string {
+	if f.Method == zipMethodAES {
+		// AES extra field: version(2) vendor "AE"(2) strength(1) method(2)
+		extra := f.Extra
+		for len(extra) >= 4 {
+			id := binary.LittleEndian.Uint16(extra[0:2])
+			n := int(binary.LittleEndian.Uint16(extra[2:4]))
+			if len(extra) < 4+n {
+				break
+			}
+			if id == zipExtraAES && n >= 5 {
+				switch extra[4+4] {
+				case 1:
+					return "AES-128"
+				case 2:
+					return "AES-192"
+				case 3:
+					return "AES-256"
+				}
+			}
+			extra = extra[4+n:]
+		}
+		return "AES"
+	}
+	if f.Flags&zipFlagStrongEncrypted != 0 {
+		return "PKWARE strong encryption"
+	}
+	return "ZipCrypto"
+}
+
+// 7z encoded header property ID and the 7zAES coder ID
+var (
+	sevenZipEncodedHeader = byte(0x17)
+	sevenZipAESCoder      = []byte{0x06, 0xF1, 0x07, 0x01}
+)
+
+// maxSevenZipHeader bounds how much of a 7z header is read
+const maxSevenZipHeader = 1 << 20
+
+// sevenZipEncryption looks for the 7zAES coder in the archive header. When
+// the header itself is compressed but not encrypted, file-level encryption
+// is described inside the compressed data and can't be detected.
+func sevenZipEncryption(r io.ReaderAt, size int64) *EncryptionInfo {
+	start := make([]byte, 32)
+	if n, _ := r.ReadAt(start, 0); n < len(start) {
+		return nil
+	}
+
+	offset := binary.LittleEndian.Uint64(start[12:20])
+	length := binary.LittleEndian.Uint64(start[20:28])
+	if length == 0 || length > maxSevenZipHeader || offset > uint64(size) || 32+offset+length > uint64(size) {
+		return nil
+	}
+
+	header := make([]byte, length)
+	if n, _ := r.ReadAt(header, int64(32+offset)); n < len(header) {
+		return nil
+	}
+	if !bytes.Contains(header, sevenZipAESCoder) {
+		return nil
+	}
+
+	info := &EncryptionInfo{Encrypted: true, Algorithm: "AES-256"}
+	// An encoded header that uses AES can't even be listed without the
+	// password
+	if header[0] == sevenZipEncodedHeader {
+		info.HeadersEncrypted = true
+	}
+	return info
+}
+
+// RAR signatures and block constants
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+const (
+	rar4MainHeader       = 0x73
+	rar4FileHeader       = 0x74
+	rar4EndHeader        = 0x7b
+	rar4FlagLongBlock    = 0x8000
+	rar4MainFlagPassword = 0x0080
+	rar4FileFlagPassword = 0x0004
+	rar4FileFlagLarge    = 0x0100
+
+	rar5FileHeader       = 2
+	rar5EncryptionHeader = 4
+	rar5EndHeader        = 5
+	rar5FlagExtraArea    = 0x0001
+	rar5FlagDataArea     = 0x0002
+	rar5ExtraEncryption  = 0x01
+
+	// maxRARBlocks bounds the number of headers walked
+	maxRARBlocks = 100000
+)
+
+func rarEncryption(r io.ReaderAt, size int64) *EncryptionInfo {
+	sig := make([]byte, len(rar5Signature))
+	n, _ := r.ReadAt(sig, 0)
+	switch {
+	case n >= len(rar5Signature) && bytes.Equal(sig, rar5Signature):
+		return rar5Encryption(r, size)
+	case n >= len(rar4Signature) && bytes.Equal(sig[:len(rar4Signature)], rar4Signature):
+		return rar4Encryption(r, size)
+	}
+	return nil
+}
+
+// rar4Encryption walks RAR 1.5-4.x block headers
+func rar4Encryption(r io.ReaderAt, size int64) *EncryptionInfo {
+	info := &EncryptionInfo{}
+	pos := int64(len(rar4Signature))
+	block := make([]byte, 36)
+
+	for i := 0; i < maxRARBlocks && pos+7 <= size; i++ {
+		n, _ := r.ReadAt(block, pos)
+		if n < 7 {
+			break
+		}
+		headType := block[2]
+		flags := binary.LittleEndian.Uint16(block[3:5])
+		headSize := int64(binary.LittleEndian.Uint16(block[5:7]))
+		if headSize < 7 {
+			break
+		}
+
+		var dataSize int64
+		if headType == rar4FileHeader || flags&rar4FlagLongBlock != 0 {
+			if n < 11 {
+				break
+			}
+			dataSize = int64(binary.LittleEndian.Uint32(block[7:11]))
+		}
+
+		switch headType {
+		case rar4MainHeader:
+			if flags&rar4MainFlagPassword != 0 {
+				info.Encrypted = true
+				info.HeadersEncrypted = true
+				info.Algorithm = "AES-128"
+				return info
+			}
+		case rar4FileHeader:
+			info.TotalEntries++
+			if flags&rar4FileFlagPassword != 0 {
+				info.Encrypted = true
+				info.EncryptedEntries++
+				info.Algorithm = "AES-128"
+			}
+			if flags&rar4FileFlagLarge != 0 && n >= 36 {
+				dataSize += int64(binary.LittleEndian.Uint32(block[32:36])) << 32
+			}
+		case rar4EndHeader:
+			return info
+		}
+
+		pos += headSize + dataSize
+	}
+	return info
+}
+
+// rar5Encryption walks RAR 5.0 block headers
+func rar5Encryption(r io.ReaderAt, size int64) *EncryptionInfo {
+	info := &EncryptionInfo{}
+	pos := int64(len(rar5Signature))
+	buf := make([]byte, 64*1024)
+
+	for i := 0; i < maxRARBlocks && pos < size; i++ {
+		n, _ := r.ReadAt(buf, pos)
+		block := buf[:n]
+		if len(block) < 5 {
+			break
+		}
+
+		// CRC32, then the header size counted from the type field
+		headSize, sizeLen := readVint(block[4:])
+		if sizeLen == 0 || headSize == 0 || headSize > uint64(len(block)) {
+			break
+		}
+		headStart := 4 + sizeLen
+		headEnd := headStart + int(headSize)
+		if headEnd > len(block) {
+			break
+		}
+		header := block[headStart:headEnd]
+
+		fields := header
+		headType, fields := nextVint(fields)
+		flags, fields := nextVint(fields)
+		var extraSize, dataSize uint64
+		if flags&rar5FlagExtraArea != 0 {
+			extraSize, fields = nextVint(fields)
+		}
+		if flags&rar5FlagDataArea != 0 {
+			dataSize, _ = nextVint(fields)
+		}
+		if dataSize > uint64(size) {
+			break
+		}
+
+		switch headType {
+		case rar5EncryptionHeader:
+			info.Encrypted = true
+			info.HeadersEncrypted = true
+			info.Algorithm = "AES-256"
+			return info
+		case rar5FileHeader:
+			info.TotalEntries++
+			if extraSize <= uint64(len(header)) && rar5HasEncryptionRecord(header[len(header)-int(extraSize):]) {
+				info.Encrypted = true
+				info.EncryptedEntries++
+				info.Algorithm = "AES-256"
+			}
+		case rar5EndHeader:
+			return info
+		}
+
+		pos += int64(headEnd) + int64(dataSize)
+	}
+	return info
+}
+
+// rar5HasEncryptionRecord reports whether a file header's extra area has a
+// file encryption record
+func rar5HasEncryptionRecord(extra []byte) bool {
+	for len(extra) > 0 {
+		recordSize, n := readVint(extra)
+		if n == 0 || recordSize == 0 || uint64(len(extra)-n) < recordSize {
+			return false
+		}
+		record := extra[n : n+int(recordSize)]
+		if recordType, _ := nextVint(record); recordType == rar5ExtraEncryption {
+			return true
+		}
+		extra = extra[n+int(recordSize):]
+	}
+	return false
+}
+
+// readVint decodes a RAR5 variable-length integer, returning the value and
+// the number of bytes used (0 if malformed)
+func readVint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// nextVint decodes a vint and returns the remaining bytes
+func nextVint(b []byte) (uint64, []byte) {
+	v, n := readVint(b)
+	return v, b[n:]
+}
+
+// PDF encryption dictionary patterns
+var (
+	pdfEncryptRef    = regexp.MustCompile(`^/Encrypt\s+(\d+)\s+(\d+)\s+R`)
+	pdfEncryptInline = regexp.MustCompile(`^/Encrypt\s*<<`)
+	pdfVersionKey    = regexp.MustCompile(`/V\s+(\d+)`)
+	pdfLengthKey     = regexp.MustCompile(`/Length\s+(\d+)`)
+	pdfCFMKey        = regexp.MustCompile(`/CFM\s*/(\w+)`)
+)
+
+// pdfEncryption finds the trailer's /Encrypt entry and derives the
+// algorithm from the encryption dictionary
+func pdfEncryption(r io.ReaderAt, size int64) *EncryptionInfo {
+	// Skip longer keys such as /EncryptMetadata
+	keyEnds := func(offset int64) bool {
+		b := make([]byte, 1)
+		r.ReadAt(b, offset+int64(len("/Encrypt")))
+		return !(b[0] >= 'A' && b[0] <= 'Z' || b[0] >= 'a' && b[0] <= 'z')
+	}
+
+	offset := findInReaderAt(r, size, []byte("/Encrypt"), keyEnds)
+	if offset < 0 {
+		return nil
+	}
+
+	window := make([]byte, 4096)
+	n, _ := r.ReadAt(window, offset)
+	window = window[:n]
+
+	var dict []byte
+	if loc := pdfEncryptInline.FindIndex(window); loc != nil {
+		dict = window[loc[1]:]
+	} else if m := pdfEncryptRef.FindSubmatch(window); m != nil {
+		dict = pdfObject(r, size, string(m[1]), string(m[2]))
+	} else {
+		return nil
+	}
+
+	return &EncryptionInfo{Encrypted: true, Algorithm: pdfAlgorithm(dict)}
+}
+
+// pdfObject returns the start of the body of indirect object "num gen obj"
+func pdfObject(r io.ReaderAt, size int64, num, gen string) []byte {
+	marker := []byte(num + " " + gen + " obj")
+	// Reject matches that are the tail of a longer object number
+	notDigitBefore := func(offset int64) bool {
+		if offset == 0 {
+			return true
+		}
+		b := make([]byte, 1)
+		r.ReadAt(b, offset-1)
+		return b[0] < '0' || b[0] > '9'
+	}
+
+	offset := findInReaderAt(r, size, marker, notDigitBefore)
+	if offset < 0 {
+		return nil
+	}
+
+	body := make([]byte, 2048)
+	n, _ := r.ReadAt(body, offset+int64(len(marker)))
+	body = body[:n]
+	if end := bytes.Index(body, []byte("endobj")); end >= 0 {
+		body = body[:end]
+	}
+	return body
+}
+
+// pdfAlgorithm maps the encryption dictionary's /V, /Length and /CFM
+// entries to an algorithm name
+func pdfAlgorithm(dict []byte) string {
+	version := 0
+	if m := pdfVersionKey.FindSubmatch(dict); m != nil {
+		version, _ = strconv.Atoi(string(m[1]))
+	}
+
+	switch version {
+	case 1:
+		return "RC4-40"
+	case 2, 3:
+		bits := 40
+		if m := pdfLengthKey.FindSubmatch(dict); m != nil {
+			bits, _ = strconv.Atoi(string(m[1]))
+		}
+		return fmt.Sprintf("RC4-%d", bits)
+	case 4:
+		if m := pdfCFMKey.FindSubmatch(dict); m != nil && string(m[1]) == "AESV2" {
+			return "AES-128"
+		}
+		return "RC4-128"
+	case 5:
+		return "AES-256"
+	}
+	return ""
+}
+
+// findInReaderAt returns the offset of the first occurrence of pattern for
+// which accept returns true (or any occurrence if accept is nil), or -1
+func findInReaderAt(r io.ReaderAt, size int64, pattern []byte, accept func(int64) bool) int64 {
+	buf := make([]byte, polyglotChunkSize+len(pattern))
+	for base := int64(0); base < size; base += polyglotChunkSize {
+		n, err := r.ReadAt(buf, base)
+		chunk := buf[:n]
+		for from := 0; from < len(chunk); {
+			idx := bytes.Index(chunk[from:], pattern)
+			if idx < 0 {
+				break
+			}
+			offset := base + int64(from+idx)
+			if accept == nil || accept(offset) {
+				return offset
+			}
+			from += idx + 1
+		}
+		if err != nil {
+			break
+		}
+	}
+	return -1
+}
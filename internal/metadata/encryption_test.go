@@ -0,0 +1,166 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDetectEncryption(t *testing.T) {
+	makeZip := func(flags uint16, method uint16, extra []byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: "secret.txt", Flags: flags, Method: method, Extra: extra})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("ciphertext"))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	// WinZip AES extra field: id, size, version, vendor, strength, method
+	aesExtra := []byte{0x01, 0x99, 0x07, 0x00, 0x02, 0x00, 'A', 'E', 0x03, 0x08, 0x00}
+
+	make7z := func(header []byte) []byte {
+		start := make([]byte, 32)
+		copy(start, "7z\xbc\xaf\x27\x1c\x00\x04")
+		binary.LittleEndian.PutUint64(start[12:20], 0)
+		binary.LittleEndian.PutUint64(start[20:28], uint64(len(header)))
+		return concat(start, header)
+	}
+
+	tests := []struct {
+		name              string
+		data              []byte
+		primary           string
+		expectNil         bool
+		expectedAlgorithm string
+		expectHeaders     bool
+	}{
+		{
+			name:      "plain zip",
+			data:      makeZip(0, zip.Store, nil),
+			primary:   "zip",
+			expectNil: true,
+		},
+		{
+			name:              "ZipCrypto zip",
+			data:              makeZip(zipFlagEncrypted, zip.Store, nil),
+			primary:           "zip",
+			expectedAlgorithm: "ZipCrypto",
+		},
+		{
+			name:              "WinZip AES-256 zip",
+			data:              makeZip(zipFlagEncrypted, zipMethodAES, aesExtra),
+			primary:           "zip",
+			expectedAlgorithm: "AES-256",
+		},
+		{
+			name:              "7z with encrypted header",
+			data:              make7z([]byte{0x17, 0x06, 0x80, 0x01, 0x0b, 0x01, 0x00, 0x02, 0x24, 0x06, 0xF1, 0x07, 0x01}),
+			primary:           "7z",
+			expectedAlgorithm: "AES-256",
+			expectHeaders:     true,
+		},
+		{
+			name:      "7z without AES",
+			data:      make7z([]byte{0x17, 0x06, 0x80, 0x01, 0x0b, 0x01, 0x00, 0x01, 0x03, 0x03, 0x01, 0x01}),
+			primary:   "7z",
+			expectNil: true,
+		},
+		{
+			name:              "RAR4 with encrypted headers",
+			data:              concat(rar4Signature, []byte{0x00, 0x00, rar4MainHeader, 0x80, 0x00, 0x0d, 0x00, 0, 0, 0, 0, 0, 0}),
+			primary:           "rar",
+			expectedAlgorithm: "AES-128",
+			expectHeaders:     true,
+		},
+		{
+			name:              "RAR5 with encrypted file",
+			data:              rar5Archive(true),
+			primary:           "rar",
+			expectedAlgorithm: "AES-256",
+		},
+		{
+			name:      "RAR5 without encryption",
+			data:      rar5Archive(false),
+			primary:   "rar",
+			expectNil: true,
+		},
+		{
+			name: "PDF with AES-128 encryption dictionary",
+			data: []byte("%PDF-1.6\n15 0 obj\n<< /V 1 >>\nendobj\n5 0 obj\n<< /Filter /Standard /V 4 /R 4 /CF << /StdCF << /CFM /AESV2 >> >> /EncryptMetadata false >>\nendobj\n" +
+				"trailer\n<< /Root 1 0 R /Encrypt 5 0 R >>\n%%EOF\n"),
+			primary:           "pdf",
+			expectedAlgorithm: "AES-128",
+		},
+		{
+			name:              "PDF with inline RC4 dictionary",
+			data:              []byte("%PDF-1.4\ntrailer\n<< /Encrypt << /Filter /Standard /V 2 /Length 128 >> >>\n%%EOF\n"),
+			primary:           "pdf",
+			expectedAlgorithm: "RC4-128",
+		},
+		{
+			name:      "plain PDF",
+			data:      []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n"),
+			primary:   "pdf",
+			expectNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := detectEncryption(bytes.NewReader(tt.data), int64(len(tt.data)), tt.primary)
+
+			if tt.expectNil {
+				if info != nil {
+					t.Errorf("detectEncryption() = %+v, want nil", info)
+				}
+				return
+			}
+
+			if info == nil {
+				t.Fatal("detectEncryption() returned nil")
+			}
+
+			if !info.Encrypted {
+				t.Errorf("Encrypted = false, want true")
+			}
+
+			if info.Algorithm != tt.expectedAlgorithm {
+				t.Errorf("Algorithm = %v, want %v", info.Algorithm, tt.expectedAlgorithm)
+			}
+
+			if info.HeadersEncrypted != tt.expectHeaders {
+				t.Errorf("HeadersEncrypted = %v, want %v", info.HeadersEncrypted, tt.expectHeaders)
+			}
+		})
+	}
+}
+
+// rar5Archive builds a minimal RAR5 archive with one file header, optionally
+// carrying a file encryption extra record
+func rar5Archive(encrypted bool) []byte {
+	block := func(fields ...byte) []byte {
+		// CRC32 (unchecked), header size, header fields
+		return concat([]byte{0, 0, 0, 0, byte(len(fields))}, fields)
+	}
+
+	main := block(0x01, 0x00, 0x00)
+
+	var extra []byte
+	if encrypted {
+		// Record size, type 0x01 (encryption), version 0 (AES-256), flags
+		extra = []byte{0x03, 0x01, 0x00, 0x00}
+	}
+	fileFields := []byte{0x02, 0x03, byte(len(extra)), 0x04}
+	// File flags, unpacked size, attributes, compression, host OS, name
+	fileFields = append(fileFields, 0x00, 0x04, 0x20, 0x00, 0x00, 0x01, 'a')
+	fileFields = append(fileFields, extra...)
+	file := concat(block(fileFields...), []byte("data"))
+
+	end := block(0x05, 0x00, 0x00)
+	return concat(rar5Signature, main, file, end)
+}
@@ -0,0 +1,173 @@
+package metadata
+
+import (
+	"math"
+	"strings"
+)
+
+// EntropyAnalysis contains Shannon entropy statistics over the file bytes.
+// Entropy is measured in bits per byte, from 0 (constant) to 8 (random).
+type EntropyAnalysis struct {
+	Overall            float64 `json:"overall"`
+	WindowSize         int     `json:"window_size"`
+	Windows            int     `json:"windows"`
+	MinWindow          float64 `json:"min_window"`
+	MaxWindow          float64 `json:"max_window"`
+	HighEntropyWindows int     `json:"high_entropy_windows"`
+	LikelyEncrypted    bool    `json:"likely_encrypted"`
+	Assessment         string  `json:"assessment"` // "low", "normal", "compressed", "encrypted_or_packed", "embedded_high_entropy"
+}
+
+const (
+	// entropyWindowSize is the block size for windowed entropy
+	entropyWindowSize = 4096
+
+	// highEntropyThreshold marks a window as near-random. Random data in a
+	// 4KB window measures ~7.95 bits/byte; typical compressed data is lower.
+	highEntropyThreshold = 7.5
+
+	// encryptedOverallThreshold is the whole-file entropy above which
+	// content is treated as encrypted or packed
+	encryptedOverallThreshold = 7.2
+
+	// minEntropyBytes is the smallest file worth analyzing; tiny samples
+	// can't reach high entropy values
+	minEntropyBytes = 512
+)
+
+// compressedMIMEPrefixes are formats expected to have high entropy because
+// their payload is compressed
+var compressedMIMEPrefixes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp", "image/heif", "image/jp2",
+	"audio/", "video/",
+	"application/zip", "application/gzip", "application/x-bzip2", "application/x-xz",
+	"application/zstd", "application/x-7z-compressed", "application/vnd.rar",
+	"application/x-compress", "application/x-lzip", "application/pdf",
+	"application/epub+zip", "application/vnd.openxmlformats-officedocument",
+	"application/font-woff", "application/x-rpm", "application/vnd.debian.binary-package",
+	"application/vnd.ms-cab-compressed", "application/x-google-chrome-extension",
+}
+
+// entropyAnalyzer accumulates byte frequencies as an io.Writer so it can
+// share a single pass over the file with the checksum
+type entropyAnalyzer struct {
+	total       [256]int64
+	window      [256]int64
+	windowBytes int
+	totalBytes  int64
+
+	windows   int
+	minWindow float64
+	maxWindow float64
+	high      int
+}
+
+func newEntropyAnalyzer() *entropyAnalyzer {
+	return &entropyAnalyzer{minWindow: math.MaxFloat64}
+}
+
+// Write implements io.Writer
+func (e *entropyAnalyzer) Write(p []byte) (int, error) {
+	for _, b := range p {
+		e.total[b]++
+		e.window[b]++
+		e.windowBytes++
+		if e.windowBytes == entropyWindowSize {
+			e.closeWindow()
+		}
+	}
+	e.totalBytes += int64(len(p))
+	return len(p), nil
+}
+
+// closeWindow records the entropy of the current window and resets it
+func (e *entropyAnalyzer) closeWindow() {
+	h := entropyOf(&e.window, int64(e.windowBytes))
+	e.windows++
+	if h < e.minWindow {
+		e.minWindow = h
+	}
+	if h > e.maxWindow {
+		e.maxWindow = h
+	}
+	if h >= highEntropyThreshold {
+		e.high++
+	}
+	e.window = [256]int64{}
+	e.windowBytes = 0
+}
+
+// Result summarizes the analysis for content of the given MIME type.
+// Returns nil for files too small to measure meaningfully.
+func (e *entropyAnalyzer) Result(mimeType string) *EntropyAnalysis {
+	if e.totalBytes < minEntropyBytes {
+		return nil
+	}
+
+	// Only count a trailing partial window if it's large enough to measure
+	if e.windowBytes >= minEntropyBytes {
+		e.closeWindow()
+	}
+
+	analysis := &EntropyAnalysis{
+		Overall:            round2(entropyOf(&e.total, e.totalBytes)),
+		WindowSize:         entropyWindowSize,
+		Windows:            e.windows,
+		HighEntropyWindows: e.high,
+	}
+	if e.windows > 0 {
+		analysis.MinWindow = round2(e.minWindow)
+		analysis.MaxWindow = round2(e.maxWindow)
+	}
+
+	compressed := isCompressedMIME(mimeType)
+	highRatio := 0.0
+	if e.windows > 0 {
+		highRatio = float64(e.high) / float64(e.windows)
+	}
+
+	switch {
+	case analysis.Overall >= encryptedOverallThreshold && highRatio >= 0.9 && !compressed:
+		// Near-random throughout, but not a format that explains it
+		analysis.LikelyEncrypted = true
+		analysis.Assessment = "encrypted_or_packed"
+	case compressed:
+		analysis.Assessment = "compressed"
+	case e.high > 0:
+		// Random-looking blocks inside otherwise structured content, e.g.
+		// an encrypted payload embedded in a text or executable file
+		analysis.Assessment = "embedded_high_entropy"
+	case analysis.Overall < 3.5:
+		analysis.Assessment = "low"
+	default:
+		analysis.Assessment = "normal"
+	}
+
+	return analysis
+}
+
+// entropyOf computes Shannon entropy in bits per byte from byte counts
+func entropyOf(counts *[256]int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	h := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(total)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// isCompressedMIME reports whether a MIME type is a compressed format
+func isCompressedMIME(mimeType string) bool {
+	for _, prefix := range compressedMIMEPrefixes {
+		if strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
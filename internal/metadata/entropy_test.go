@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEntropyAnalyzer(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	random := make([]byte, 64*1024)
+	rng.Read(random)
+
+	text := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 2000))
+
+	tests := []struct {
+		name               string
+		data               []byte
+		mimeType           string
+		expectNil          bool
+		expectedEncrypted  bool
+		expectedAssessment string
+	}{
+		{
+			name:      "tiny file is skipped",
+			data:      []byte("hello"),
+			mimeType:  "text/plain",
+			expectNil: true,
+		},
+		{
+			name:               "random data with unknown type",
+			data:               random,
+			mimeType:           "application/octet-stream",
+			expectedEncrypted:  true,
+			expectedAssessment: "encrypted_or_packed",
+		},
+		{
+			name:               "random data declared as ZIP",
+			data:               random,
+			mimeType:           "application/zip",
+			expectedAssessment: "compressed",
+		},
+		{
+			name:               "English text",
+			data:               text,
+			mimeType:           "text/plain",
+			expectedAssessment: "normal",
+		},
+		{
+			name:               "text with embedded encrypted blob",
+			data:               bytes.Join([][]byte{text, random[:16*1024], text}, nil),
+			mimeType:           "text/plain",
+			expectedAssessment: "embedded_high_entropy",
+		},
+		{
+			name:               "all zero bytes",
+			data:               make([]byte, 8192),
+			mimeType:           "application/octet-stream",
+			expectedAssessment: "low",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyzer := newEntropyAnalyzer()
+			// Write in uneven pieces to exercise window boundaries
+			for data := tt.data; len(data) > 0; {
+				n := min(len(data), 1000)
+				analyzer.Write(data[:n])
+				data = data[n:]
+			}
+
+			analysis := analyzer.Result(tt.mimeType)
+
+			if tt.expectNil {
+				if analysis != nil {
+					t.Errorf("Result() = %+v, want nil", analysis)
+				}
+				return
+			}
+
+			if analysis == nil {
+				t.Fatal("Result() returned nil")
+			}
+
+			if analysis.LikelyEncrypted != tt.expectedEncrypted {
+				t.Errorf("LikelyEncrypted = %v, want %v", analysis.LikelyEncrypted, tt.expectedEncrypted)
+			}
+
+			if analysis.Assessment != tt.expectedAssessment {
+				t.Errorf("Assessment = %v, want %v (%+v)", analysis.Assessment, tt.expectedAssessment, analysis)
+			}
+
+			if analysis.Overall < 0 || analysis.Overall > 8 {
+				t.Errorf("Overall = %v, want value in [0, 8]", analysis.Overall)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// findJPEGExif walks the JPEG segments that precede the image data and
+// returns the EXIF APP1 payload, if any. Only segment headers are read until
+// the EXIF segment turns up, and nothing past limit bytes. limited reports
+// that the walk stopped at the limit before reaching the image data.
+func findJPEGExif(r io.Reader, limit int64) (payload []byte, limited bool) {
+	lr := &io.LimitedReader{R: r, N: limit}
+	br := bufio.NewReader(lr)
+	truncated := func() bool { return lr.N == 0 }
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, false
+	}
+
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, truncated()
+		}
+		if b != 0xFF {
+			return nil, false
+		}
+
+		// Markers may be padded with fill bytes
+		marker := byte(0xFF)
+		for marker == 0xFF {
+			if marker, err = br.ReadByte(); err != nil {
+				return nil, truncated()
+			}
+		}
+
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			// Start of scan or end of image: EXIF would have come before
+			return nil, false
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Standalone markers carry no length
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil, truncated()
+		}
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return nil, false
+		}
+
+		if marker == 0xE1 {
+			segment := make([]byte, n)
+			if _, err := io.ReadFull(br, segment); err != nil {
+				return nil, truncated()
+			}
+			// XMP packets share the APP1 marker
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment, false
+			}
+			continue
+		}
+		if _, err := br.Discard(n); err != nil {
+			return nil, truncated()
+		}
+	}
+}
+
+// decodeEXIF parses an APP1 payload found by findJPEGExif
+func decodeEXIF(segment []byte) (*exif.Exif, error) {
+	if segment == nil {
+		return nil, errNoEXIF
+	}
+	return exif.Decode(bytes.NewReader(segment))
+}
+
+var errNoEXIF = errors.New("no EXIF segment")
+
+// Device serial number tags, which goexif doesn't name
+const (
+	bodySerialNumber   exif.FieldName = "BodySerialNumber"   // Exif IFD 0xA431
+	lensSerialNumber   exif.FieldName = "LensSerialNumber"   // Exif IFD 0xA435
+	cameraSerialNumber exif.FieldName = "CameraSerialNumber" // IFD0 0xC62F, from DNG
+)
+
+// exifSerialNumbers returns the serial numbers of the camera body and lens
+// that x records, if any
+func exifSerialNumbers(x *exif.Exif) (body, lens string) {
+	if len(x.Tiff.Dirs) > 0 {
+		x.LoadTags(x.Tiff.Dirs[0], map[uint16]exif.FieldName{0xC62F: cameraSerialNumber}, false)
+	}
+	if pointer, err := x.Get(exif.ExifIFDPointer); err == nil {
+		// Tag values are at offsets from the start of the TIFF block
+		r := bytes.NewReader(x.Raw)
+		if offset, err := pointer.Int64(0); err == nil && offset > 0 && offset < r.Size() {
+			r.Seek(offset, io.SeekStart)
+			if dir, _, err := tiff.DecodeDir(r, x.Tiff.Order); err == nil {
+				x.LoadTags(dir, map[uint16]exif.FieldName{0xA431: bodySerialNumber, 0xA435: lensSerialNumber}, false)
+			}
+		}
+	}
+
+	stringVal := func(name exif.FieldName) string {
+		tag, err := x.Get(name)
+		if err != nil {
+			return ""
+		}
+		val, err := tag.StringVal()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(val)
+	}
+	body = stringVal(bodySerialNumber)
+	if body == "" {
+		body = stringVal(cameraSerialNumber)
+	}
+	return body, stringVal(lensSerialNumber)
+}
@@ -0,0 +1,57 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// serialTIFF builds a little-endian TIFF block whose Exif IFD has the body
+// and lens serial numbers given
+func serialTIFF(body, lens string) []byte {
+	bodyValue, lensValue := append([]byte(body), 0), append([]byte(lens), 0)
+	entry := func(b []byte, tag, typ uint16, count, value uint32) []byte {
+		b = binary.LittleEndian.AppendUint16(b, tag)
+		b = binary.LittleEndian.AppendUint16(b, typ)
+		b = binary.LittleEndian.AppendUint32(b, count)
+		return binary.LittleEndian.AppendUint32(b, value)
+	}
+
+	b := []byte("II*\x00")
+	b = binary.LittleEndian.AppendUint32(b, 8)
+	// IFD0, pointing at the Exif IFD at 26
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = entry(b, 0x8769, 4, 1, 26)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	// Exif IFD, with its values after it at 56
+	b = binary.LittleEndian.AppendUint16(b, 2)
+	b = entry(b, 0xA431, 2, uint32(len(bodyValue)), 56)
+	b = entry(b, 0xA435, 2, uint32(len(lensValue)), uint32(56+len(bodyValue)))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = append(b, bodyValue...)
+	return append(b, lensValue...)
+}
+
+func TestEXIFSerialNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		tiff     []byte
+		wantBody string
+		wantLens string
+	}{
+		{name: "body and lens", tiff: serialTIFF("083024001234", "0000c1a2b3"), wantBody: "083024001234", wantLens: "0000c1a2b3"},
+		{name: "none", tiff: makeTIFF("Canon")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := decodeEXIF(append([]byte("Exif\x00\x00"), tt.tiff...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, lens := exifSerialNumbers(x)
+			if body != tt.wantBody || lens != tt.wantLens {
+				t.Errorf("serial numbers = %q, %q, want %q, %q", body, lens, tt.wantBody, tt.wantLens)
+			}
+		})
+	}
+}
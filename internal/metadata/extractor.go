@@ -1,8 +1,8 @@
 package metadata
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -12,9 +12,11 @@ import (
 	"mime/multipart"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"file-meta/internal/tempfiles"
 
 	"github.com/dhowden/tag"
-	"github.com/h2non/filetype"
 	"github.com/rwcarlsen/goexif/exif"
 )
 
@@ -24,19 +26,51 @@ type Result struct {
 	SizeBytes int64             `json:"size_bytes"`
 	MimeType  string            `json:"mime_type"`
 	SHA256    string            `json:"checksum_sha256"`
+	SSDeep    string            `json:"ssdeep,omitempty"`
+	TLSH      string            `json:"tlsh,omitempty"`
 	Extension string            `json:"extension,omitempty"`
 	Image     *ImageMetadata    `json:"image,omitempty"`
 	Audio     *AudioMetadata    `json:"audio,omitempty"`
 	Video     *VideoMetadata    `json:"video,omitempty"`
 	Document  *DocumentMetadata `json:"document,omitempty"`
+	Security  *SecurityMetadata `json:"security,omitempty"`
+	Warnings  []Warning         `json:"warnings,omitempty"`
+
+	// DuplicateOf is set on responses, never on stored results
+	DuplicateOf *Duplicate `json:"duplicate_of,omitempty"`
+}
+
+// WithoutPersonalData returns a copy of r without location data and device
+// serial numbers, or r itself if it has none. r is not modified.
+func (r *Result) WithoutPersonalData() *Result {
+	if r.Image == nil || (r.Image.GPS == nil && r.Image.SerialNumber == "" && r.Image.LensSerialNumber == "") {
+		return r
+	}
+	redacted, image := *r, *r.Image
+	image.GPS, image.SerialNumber, image.LensSerialNumber = nil, "", ""
+	redacted.Image = &image
+	return &redacted
+}
+
+// Duplicate describes the first extraction of the same content with the
+// same API key
+type Duplicate struct {
+	FirstSeen time.Time `json:"first_seen"`
+	Filename  string    `json:"filename"`
+	RequestID string    `json:"request_id,omitempty"`
 }
 
 // DocumentMetadata contains text/code specific metadata
 type DocumentMetadata struct {
-	LineCount int    `json:"line_count"`
-	WordCount int    `json:"word_count"`
-	Language  string `json:"language,omitempty"`
-	Encoding  string `json:"encoding,omitempty"`
+	LineCount          int                 `json:"line_count"`
+	WordCount          int                 `json:"word_count"`
+	Language           string              `json:"language,omitempty"`
+	Encoding           string              `json:"encoding,omitempty"`
+	EncodingConfidence float64             `json:"encoding_confidence,omitempty"`
+	HasBOM             bool                `json:"has_bom,omitempty"`
+	Readability        *ReadabilityMetrics `json:"readability,omitempty"`
+	PII                *PIIReport          `json:"pii,omitempty"`
+	AIDetection        *AIDetection        `json:"ai_detection,omitempty"`
 }
 
 // ImageMetadata contains image-specific metadata
@@ -46,6 +80,8 @@ type ImageMetadata struct {
 	ColorModel          string               `json:"color_model,omitempty"`
 	Make                string               `json:"make,omitempty"`
 	Model               string               `json:"model,omitempty"`
+	SerialNumber        string               `json:"serial_number,omitempty"` // camera body
+	LensSerialNumber    string               `json:"lens_serial_number,omitempty"`
 	DateTime            string               `json:"datetime,omitempty"`
 	Orientation         int                  `json:"orientation,omitempty"`
 	Flash               string               `json:"flash,omitempty"`
@@ -55,6 +91,7 @@ type ImageMetadata struct {
 	AIDetection         *AIDetection         `json:"ai_detection,omitempty"`
 	ScreenshotDetection *ScreenshotDetection `json:"screenshot_detection,omitempty"`
 	Software            string               `json:"software,omitempty"`
+	PHash               string               `json:"phash,omitempty"` // 64-bit perceptual hash, 16 hex digits
 }
 
 // GPSData contains GPS coordinates
@@ -64,20 +101,49 @@ type GPSData struct {
 	Altitude  float64 `json:"altitude,omitempty"`
 }
 
+// String keeps coordinates out of logs and error messages; they are only
+// ever returned as JSON
+func (g GPSData) String() string {
+	return "GPS(redacted)"
+}
+
+// GoString redacts %#v like String
+func (g GPSData) GoString() string {
+	return g.String()
+}
+
 // AIDetection contains AI-generation detection results
 type AIDetection struct {
-	LikelyAIGenerated bool     `json:"likely_ai_generated"`
-	Confidence        string   `json:"confidence"` // "high", "medium", "low"
-	Indicators        []string `json:"indicators,omitempty"`
-	Reasons           []string `json:"reasons,omitempty"`
+	LikelyAIGenerated bool           `json:"likely_ai_generated"` // probability >= 0.5
+	Probability       float64        `json:"probability"`         // 0-1
+	Confidence        string         `json:"confidence"`          // "high", "medium", "low", derived from probability
+	Signals           []Signal       `json:"signals,omitempty"`
+	Indicators        []string       `json:"indicators,omitempty"` // signal codes
+	Reasons           []string       `json:"reasons,omitempty"`
+	Metrics           *TextAIMetrics `json:"metrics,omitempty"` // documents only
+
+	// Set when an external classifier was consulted; the fields above are
+	// then the blend of both signals
+	Heuristic  *AIHeuristicSignal  `json:"heuristic,omitempty"`
+	Classifier *AIClassifierSignal `json:"classifier,omitempty"`
 }
 
 // ScreenshotDetection contains screenshot detection results
 type ScreenshotDetection struct {
-	LikelyScreenshot bool     `json:"likely_screenshot"`
-	Confidence       string   `json:"confidence"` // "high", "medium", "low"
-	Indicators       []string `json:"indicators,omitempty"`
-	MatchedPattern   string   `json:"matched_pattern,omitempty"`
+	LikelyScreenshot bool                   `json:"likely_screenshot"` // probability >= 0.5
+	Probability      float64                `json:"probability"`       // 0-1
+	Confidence       string                 `json:"confidence"`        // "high", "medium", "low", derived from probability
+	Signals          []Signal               `json:"signals,omitempty"`
+	Indicators       []string               `json:"indicators,omitempty"` // signal codes
+	MatchedPattern   string                 `json:"matched_pattern,omitempty"`
+	Content          *ScreenContentAnalysis `json:"content,omitempty"`
+}
+
+// screenResolution is a known display resolution
+type screenResolution struct {
+	width  int
+	height int
+	name   string
 }
 
 // AudioMetadata contains audio-specific metadata
@@ -111,100 +177,157 @@ type VideoMetadata struct {
 	AspectRatio string `json:"aspect_ratio,omitempty"`
 }
 
-// Extract extracts metadata from uploaded file
-func Extract(file multipart.File, header *multipart.FileHeader) (*Result, error) {
-	defer file.Close()
+// Optional checksum types that can be requested in addition to SHA256 and
+// ssdeep, which are always computed
+const (
+	ChecksumTLSH = "tlsh"
+)
 
-	// Calculate SHA256 while reading file
-	hasher := sha256.New()
-	size, err := io.Copy(hasher, file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
-	}
+// Extraction modules that callers can select per request. SHA256, size and
+// MIME type are always returned.
+const (
+	ModuleSSDeep              = "ssdeep"
+	ModuleImage               = "image"
+	ModuleAudio               = "audio"
+	ModuleVideo               = "video"
+	ModuleDocument            = "document"
+	ModuleAIDetection         = "ai_detection"
+	ModuleScreenshotDetection = "screenshot_detection"
+	ModuleSecurity            = "security"
+	ModulePerceptualHash      = "phash"
+)
+
+// Modules lists every selectable extraction module
+var Modules = []string{
+	ModuleSSDeep,
+	ModuleImage,
+	ModuleAudio,
+	ModuleVideo,
+	ModuleDocument,
+	ModuleAIDetection,
+	ModuleScreenshotDetection,
+	ModuleSecurity,
+	ModulePerceptualHash,
+}
 
-	hash := hex.EncodeToString(hasher.Sum(nil))
+// Options controls optional parts of the extraction
+type Options struct {
+	// TLSH adds a TLSH locality-sensitive hash to the result
+	TLSH bool
 
-	// Rewind file for type detection
-	if seeker, ok := file.(io.Seeker); ok {
-		if _, err := seeker.Seek(0, 0); err != nil {
-			return nil, fmt.Errorf("failed to rewind file: %w", err)
-		}
-	}
+	// Skip names modules that are not run; nil runs every module
+	Skip map[string]bool
 
-	// Detect file type via magic bytes
-	head := make([]byte, 261)
-	n, err := file.Read(head)
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read file header: %w", err)
-	}
+	// Decompression bounds archive and image inspection
+	Decompression DecompressionLimits
 
-	kind, _ := filetype.Match(head[:n])
+	// Memory bounds image decoding, EXIF parsing and text analysis
+	Memory MemoryLimits
 
-	// Use detected MIME type or fall back to header
-	mime := header.Header.Get("Content-Type")
-	if kind != filetype.Unknown {
-		mime = kind.MIME.Value
-	}
+	// TempFiles creates the files ExtractStream spills content to when
+	// extractors need to seek within it; nil uses the system default
+	TempFiles *tempfiles.Manager
 
-	// Get file extension
-	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+	// Progress, if set, is called with each Stage as extraction finishes it
+	Progress func(stage string)
 
-	result := &Result{
-		Filename:  header.Filename,
-		SizeBytes: size,
-		MimeType:  mime,
-		SHA256:    hash,
-		Extension: ext,
+	// Timing, if set, is called with how long each extractor module took.
+	// mimeType is the type detected from magic bytes, or empty if the
+	// content wasn't recognized.
+	Timing func(module, mimeType string, elapsed time.Duration)
+}
+
+// Extraction stages reported to Options.Progress. Only the stages that
+// apply to the file and the enabled modules are reported.
+const (
+	StageHashed           = "hashed"            // content read, checksums computed
+	StageInspected        = "inspected"         // container security checks done
+	StageImageDecoded     = "image-decoded"     // image header, EXIF and pixels analyzed
+	StageAudioDecoded     = "audio-decoded"     // audio tags read
+	StageVideoDecoded     = "video-decoded"     // video container parsed
+	StageDocumentAnalyzed = "document-analyzed" // text analysis done
+)
+
+// progress reports a finished stage
+func (o Options) progress(stage string) {
+	if o.Progress != nil {
+		o.Progress(stage)
 	}
+}
 
-	// Rewind for specific metadata extraction
-	if seeker, ok := file.(io.Seeker); ok {
-		seeker.Seek(0, 0)
+// timing reports how long module took since start
+func (o Options) timing(module, mimeType string, start time.Time) {
+	if o.Timing != nil {
+		o.Timing(module, mimeType, time.Since(start))
 	}
+}
 
-	// Extract type-specific metadata
-	if strings.HasPrefix(mime, "image/") {
-		result.Image = extractImageMetadata(file, mime, header.Filename)
-	} else if strings.HasPrefix(mime, "audio/") {
-		result.Audio = extractAudioMetadata(file)
-	} else if strings.HasPrefix(mime, "video/") {
-		result.Video = extractVideoMetadata(file)
-	} else {
-		// Try to extract document metadata for text/code files or unknown types
-		doc := extractDocumentMetadata(file, header.Filename)
-		if doc != nil && (strings.HasPrefix(mime, "text/") || doc.Language != "Unknown") {
-			result.Document = doc
-		}
+// runs reports whether module is enabled
+func (o Options) runs(module string) bool {
+	return !o.Skip[module]
+}
+
+// Extract extracts metadata from uploaded file
+func Extract(ctx context.Context, file multipart.File, header *multipart.FileHeader) (*Result, error) {
+	return ExtractWithOptions(ctx, file, header, Options{})
+}
+
+// ExtractWithOptions extracts metadata from uploaded file, including the
+// optional parts enabled in opts. The file is already seekable, so nothing is
+// spilled to disk. It stops with ctx's error once ctx is done. The file is
+// left open for the caller, which may still need it after extraction.
+func ExtractWithOptions(ctx context.Context, file multipart.File, header *multipart.FileHeader, opts Options) (*Result, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind file: %w", err)
 	}
 
-	return result, nil
+	info := FileInfo{
+		Filename:    header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+		Size:        size,
+	}
+	return extract(ctx, file, file, info, opts)
 }
 
-// extractImageMetadata extracts EXIF and basic image metadata
-func extractImageMetadata(file multipart.File, mimeType, filename string) *ImageMetadata {
+// extractImageMetadata extracts EXIF and basic image metadata. Warnings
+// report parts skipped because they would exceed opts.Memory or could not
+// be decoded.
+func extractImageMetadata(file io.ReadSeeker, mimeType, filename string, opts Options) (*ImageMetadata, []Warning) {
 	metadata := &ImageMetadata{}
+	limits := opts.Memory.withDefaults()
+	var warnings []Warning
 
-	// Try to decode image for dimensions
-	if seeker, ok := file.(io.Seeker); ok {
-		seeker.Seek(0, 0)
-	}
+	// Read dimensions from the image header without decoding pixel data,
+	// which could be a decompression bomb
+	file.Seek(0, io.SeekStart)
 
-	img, _, err := image.Decode(file)
+	config, _, err := image.DecodeConfig(file)
 	if err == nil {
-		bounds := img.Bounds()
-		metadata.Width = bounds.Dx()
-		metadata.Height = bounds.Dy()
-		metadata.ColorModel = fmt.Sprintf("%T", img.ColorModel())
+		metadata.Width = config.Width
+		metadata.Height = config.Height
+		metadata.ColorModel = fmt.Sprintf("%T", config.ColorModel)
+	} else {
+		warnings = append(warnings, imageUndecodableWarning(err))
 	}
 
 	// Try to extract EXIF data (JPEG images)
 	var exifData *exif.Exif
 	if strings.Contains(mimeType, "jpeg") || strings.Contains(mimeType, "jpg") {
-		if seeker, ok := file.(io.Seeker); ok {
-			seeker.Seek(0, 0)
+		file.Seek(0, io.SeekStart)
+
+		segment, limited := findJPEGExif(file, limits.MaxEXIFBytes)
+		if limited {
+			warnings = append(warnings, exifLimitWarning(limits.MaxEXIFBytes))
 		}
 
-		x, err := exif.Decode(file)
+		x, err := decodeEXIF(segment)
+		if err != nil && segment != nil {
+			warnings = append(warnings, exifInvalidWarning(err))
+		}
 		if err == nil {
 			exifData = x
 
@@ -220,6 +343,9 @@ func extractImageMetadata(file multipart.File, mimeType, filename string) *Image
 				}
 			}
 
+			// Device serial numbers
+			metadata.SerialNumber, metadata.LensSerialNumber = exifSerialNumbers(x)
+
 			// Software
 			if software, err := x.Get(exif.Software); err == nil {
 				if val, err := software.StringVal(); err == nil {
@@ -280,29 +406,60 @@ func extractImageMetadata(file multipart.File, mimeType, filename string) *Image
 		}
 	}
 
-	// Perform screenshot detection first
-	metadata.ScreenshotDetection = detectScreenshot(metadata, filename)
+	// Pixels are decoded once, and only for the modules that sample them:
+	// screen content analysis and the perceptual hash
+	var img image.Image
+	if opts.runs(ModuleScreenshotDetection) || opts.runs(ModulePerceptualHash) {
+		if pixels := int64(metadata.Width) * int64(metadata.Height); pixels > limits.MaxPixels {
+			warnings = append(warnings, pixelLimitWarning(metadata.Width, metadata.Height, limits.MaxPixels))
+		} else if img, err = decodePixels(file, metadata.Width, metadata.Height, limits.MaxPixels); err != nil {
+			warnings = append(warnings, pixelsUndecodableWarning(err))
+		}
+	}
+	if img != nil && opts.runs(ModulePerceptualHash) {
+		metadata.PHash = perceptualHash(img)
+	}
+
+	// AI detection builds on the screenshot verdict, so screenshots are
+	// detected whenever either module runs. AI detection on its own uses the
+	// header-based screenshot verdict.
+	if opts.runs(ModuleScreenshotDetection) || opts.runs(ModuleAIDetection) {
+		var content *ScreenContentAnalysis
+		if img != nil && opts.runs(ModuleScreenshotDetection) {
+			content = analyzeScreenContent(img)
+		}
 
-	// Perform AI detection analysis (which will consider screenshot detection)
-	metadata.AIDetection = detectAIGenerated(metadata, exifData)
+		// Perform screenshot detection first
+		metadata.ScreenshotDetection = detectScreenshot(metadata, filename, content)
+
+		// Perform AI detection analysis (which will consider screenshot detection)
+		if opts.runs(ModuleAIDetection) {
+			metadata.AIDetection = detectAIGenerated(metadata, exifData)
+		}
+		if !opts.runs(ModuleScreenshotDetection) {
+			metadata.ScreenshotDetection = nil
+		}
+	}
 
 	// Return nil if no metadata was extracted
 	if metadata.Width == 0 && metadata.Height == 0 && metadata.Make == "" {
-		return nil
+		return nil, warnings
 	}
 
-	return metadata
+	return metadata, warnings
 }
 
-// extractAudioMetadata extracts ID3 tags and audio properties
-func extractAudioMetadata(file multipart.File) *AudioMetadata {
-	if seeker, ok := file.(io.Seeker); ok {
-		seeker.Seek(0, 0)
-	}
+// extractAudioMetadata extracts ID3 tags and audio properties. A file
+// without tags has no metadata; one whose tags can't be read gets a warning.
+func extractAudioMetadata(file io.ReadSeeker) (*AudioMetadata, []Warning) {
+	file.Seek(0, io.SeekStart)
 
 	m, err := tag.ReadFrom(file)
+	if errors.Is(err, tag.ErrNoTagsFound) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, []Warning{audioTagsInvalidWarning(err)}
 	}
 
 	metadata := &AudioMetadata{
@@ -336,42 +493,38 @@ func extractAudioMetadata(file multipart.File) *AudioMetadata {
 
 	// Return nil if no meaningful data
 	if metadata.Title == "" && metadata.Artist == "" && metadata.Album == "" {
-		return nil
+		return nil, nil
 	}
 
-	return metadata
+	return metadata, nil
 }
 
 // extractVideoMetadata extracts video properties
-func extractVideoMetadata(file multipart.File) *VideoMetadata {
+func extractVideoMetadata(file io.ReadSeeker) *VideoMetadata {
 	// Note: Video metadata extraction requires more complex libraries
 	// For now, we'll return a placeholder
 	// In production, consider using ffmpeg bindings or similar
 	return nil
 }
 
-// extractDocumentMetadata extracts text/code properties
-func extractDocumentMetadata(file multipart.File, filename string) *DocumentMetadata {
-	if seeker, ok := file.(io.Seeker); ok {
-		seeker.Seek(0, 0)
-	}
-
-	// Read first 1MB for analysis to avoid memory issues with huge files
-	// but enough to get good stats
-	buf := make([]byte, 1024*1024)
-	n, err := file.Read(buf)
-	if err != nil && err != io.EOF {
-		return nil
-	}
-	content := string(buf[:n])
+// extractDocumentMetadata extracts text/code properties from a sample of the
+// file, its first 1MB. The decoded text is returned alongside so security
+// scanners can reuse it.
+func extractDocumentMetadata(sample []byte, filename string, opts Options) (*DocumentMetadata, string) {
+	// Detect character encoding and decode multi-byte Unicode so counts
+	// reflect characters rather than raw bytes
+	enc := detectEncoding(sample)
+	content := decodeText(sample, enc)
 
 	metadata := &DocumentMetadata{
-		Encoding: "UTF-8", // Default assumption, could use library to detect
+		Encoding:           enc.Name,
+		EncodingConfidence: enc.Confidence,
+		HasBOM:             enc.HasBOM,
 	}
 
 	// Count lines
 	metadata.LineCount = strings.Count(content, "\n") + 1
-	if n == 0 {
+	if len(sample) == 0 {
 		metadata.LineCount = 0
 	}
 
@@ -397,6 +550,8 @@ func extractDocumentMetadata(file multipart.File, filename string) *DocumentMeta
 		metadata.Language = "Markdown"
 	case ".json":
 		metadata.Language = "JSON"
+	case ".csv":
+		metadata.Language = "CSV"
 	case ".xml":
 		metadata.Language = "XML"
 	case ".yaml", ".yml":
@@ -405,6 +560,10 @@ func extractDocumentMetadata(file multipart.File, filename string) *DocumentMeta
 		metadata.Language = "SQL"
 	case ".sh", ".bash":
 		metadata.Language = "Shell"
+	case ".env":
+		metadata.Language = "Dotenv"
+	case ".pem", ".key":
+		metadata.Language = "PEM"
 	case ".c":
 		metadata.Language = "C"
 	case ".cpp", ".cc":
@@ -419,35 +578,56 @@ func extractDocumentMetadata(file multipart.File, filename string) *DocumentMeta
 		metadata.Language = "Unknown"
 	}
 
-	return metadata
+	// Readability scores only make sense for prose, not source code
+	if proseLanguages[metadata.Language] {
+		metadata.Readability = analyzeReadability(content)
+		if opts.runs(ModuleAIDetection) {
+			metadata.AIDetection = detectAIText(content)
+		}
+	}
+
+	// Scan data and prose files for personally identifiable information
+	if piiLanguages[metadata.Language] {
+		metadata.PII = scanPII(content)
+	}
+
+	return metadata, content
 }
 
+// AI-image signal weights in log-odds. The prior puts an image with no
+// evidence either way at about 12% likely AI-generated.
+const (
+	aiImageBias              = -2.0
+	aiWeightSoftware         = 6.0
+	aiWeightNoCameraMetadata = 2.4
+	aiWeightNoTechnical      = 1.6
+	aiWeightNoGPS            = 0.8
+	aiWeightNoEXIF           = 1.6
+	aiWeightDateTimeNoCamera = 0.8
+	aiWeightCameraMetadata   = -1.0
+	aiWeightScreenshot       = -6.0
+	aiWeightMaybeScreenshot  = -0.8
+)
+
 // detectAIGenerated analyzes image metadata to detect if it's likely AI-generated
 func detectAIGenerated(metadata *ImageMetadata, exifData *exif.Exif) *AIDetection {
 	detection := &AIDetection{
-		LikelyAIGenerated: false,
-		Confidence:        "low",
-		Indicators:        []string{},
-		Reasons:           []string{},
+		Reasons: []string{},
 	}
+	score := newScorer(aiImageBias)
 
 	// Check if it's a screenshot first - screenshots shouldn't be flagged as AI
 	if metadata.ScreenshotDetection != nil && metadata.ScreenshotDetection.LikelyScreenshot {
 		if metadata.ScreenshotDetection.Confidence == "high" {
-			detection.LikelyAIGenerated = false
-			detection.Confidence = "high"
-			detection.Indicators = append(detection.Indicators, "screenshot_detected")
+			score.add(ReasonScreenshotDetected, aiWeightScreenshot)
 			detection.Reasons = append(detection.Reasons,
 				fmt.Sprintf("Image appears to be a screenshot: %s", metadata.ScreenshotDetection.MatchedPattern))
-			return detection
-		} else if metadata.ScreenshotDetection.Confidence == "medium" {
-			// Medium confidence screenshot - still check but be less aggressive
-			detection.Indicators = append(detection.Indicators, "possible_screenshot")
+			return detection.apply(score)
 		}
+		// Lower confidence screenshot - still check but be less aggressive
+		score.add(ReasonPossibleScreenshot, aiWeightMaybeScreenshot)
 	}
 
-	score := 0 // Scoring system to determine confidence
-
 	// Known AI generator software signatures
 	aiSoftwareKeywords := []string{
 		"midjourney", "dall-e", "dalle", "stable diffusion", "stablediffusion",
@@ -460,86 +640,88 @@ func detectAIGenerated(metadata *ImageMetadata, exifData *exif.Exif) *AIDetectio
 		softwareLower := strings.ToLower(metadata.Software)
 		for _, keyword := range aiSoftwareKeywords {
 			if strings.Contains(softwareLower, keyword) {
-				detection.LikelyAIGenerated = true
-				detection.Confidence = "high"
-				detection.Indicators = append(detection.Indicators, "ai_software_detected")
+				score.add(ReasonAISoftware, aiWeightSoftware)
 				detection.Reasons = append(detection.Reasons, fmt.Sprintf("Software field contains AI generator signature: %s", metadata.Software))
-				return detection
+				return detection.apply(score)
 			}
 		}
 	}
 
 	// Check 2: Absence of camera metadata (strong indicator)
 	if metadata.Make == "" && metadata.Model == "" {
-		score += 3
-		detection.Indicators = append(detection.Indicators, "no_camera_metadata")
+		score.add(ReasonNoCameraMetadata, aiWeightNoCameraMetadata)
 		detection.Reasons = append(detection.Reasons, "No camera make/model found in EXIF data")
 	}
 
 	// Check 3: No camera-specific technical data
 	if metadata.FocalLength == "" && metadata.ISOSpeed == 0 && metadata.Flash == "" {
-		score += 2
-		detection.Indicators = append(detection.Indicators, "no_camera_technical_data")
+		score.add(ReasonNoCameraTechnical, aiWeightNoTechnical)
 		detection.Reasons = append(detection.Reasons, "No camera technical data (focal length, ISO, flash) found")
 	}
 
 	// Check 4: No GPS data (cameras often include GPS)
 	if metadata.GPS == nil && metadata.Make == "" {
-		score += 1
-		detection.Indicators = append(detection.Indicators, "no_gps_data")
+		score.add(ReasonNoGPS, aiWeightNoGPS)
 	}
 
 	// Check 5: No EXIF data at all for JPEG (highly suspicious)
 	if exifData == nil && metadata.Make == "" {
-		score += 2
-		detection.Indicators = append(detection.Indicators, "no_exif_data")
+		score.add(ReasonNoEXIF, aiWeightNoEXIF)
 		detection.Reasons = append(detection.Reasons, "JPEG image with no EXIF data - typical of AI-generated images")
 	}
 
 	// Check 6: DateTime but no camera data (unusual for real photos)
 	if metadata.DateTime != "" && metadata.Make == "" && metadata.Model == "" {
-		score += 1
-		detection.Indicators = append(detection.Indicators, "datetime_without_camera")
+		score.add(ReasonDateTimeWithoutCam, aiWeightDateTimeNoCamera)
 	}
 
-	// Determine overall result based on score
-	if score >= 5 {
-		detection.LikelyAIGenerated = true
-		detection.Confidence = "high"
-		if len(detection.Reasons) == 0 {
-			detection.Reasons = append(detection.Reasons, "Multiple indicators suggest this is an AI-generated image")
-		}
-	} else if score >= 3 {
-		detection.LikelyAIGenerated = true
-		detection.Confidence = "medium"
-		if len(detection.Reasons) == 0 {
-			detection.Reasons = append(detection.Reasons, "Several indicators suggest this might be AI-generated")
-		}
-	} else if score >= 1 {
-		detection.LikelyAIGenerated = false
-		detection.Confidence = "low"
-		detection.Reasons = append(detection.Reasons, "Insufficient evidence to determine if AI-generated")
-	} else {
-		// Image has camera metadata, likely authentic
-		detection.LikelyAIGenerated = false
-		detection.Confidence = "high"
-		detection.Indicators = append(detection.Indicators, "camera_metadata_present")
+	// Check 7: Camera metadata present, likely authentic
+	if metadata.Make != "" || metadata.Model != "" {
+		score.add(ReasonCameraMetadata, aiWeightCameraMetadata)
 		detection.Reasons = append(detection.Reasons, "Image contains authentic camera metadata")
 	}
 
-	return detection
+	return detection.apply(score)
 }
 
-// detectScreenshot analyzes image dimensions and metadata to detect screenshots
-func detectScreenshot(metadata *ImageMetadata, filename string) *ScreenshotDetection {
+// apply sets the verdict, probability and signals from a scorer
+func (d *AIDetection) apply(s *scorer) *AIDetection {
+	d.Probability = s.probability()
+	d.LikelyAIGenerated = d.Probability >= 0.5
+	d.Confidence = confidenceLabel(d.Probability)
+	d.Signals = s.signals
+	d.Indicators = s.indicators()
+	return d
+}
+
+// Screenshot signal weights in log-odds. The prior puts an image with no
+// evidence either way at about 18% likely to be a screenshot.
+const (
+	screenshotBias             = -1.5
+	screenshotWeightConclusive = 6.0
+	screenshotWeightFilename   = 1.6
+	screenshotWeightExact      = 2.4
+	screenshotWeightAspect     = 1.6
+	screenshotWeightScaled     = 1.6
+	screenshotWeightFlat       = 1.6
+	screenshotWeightStatusBar  = 1.6
+	screenshotWeightEdges      = 0.8
+	screenshotWeightPhoto      = -1.6
+	screenshotWeightCamera     = -2.4
+)
+
+// detectScreenshot analyzes image dimensions, metadata and, when available,
+// pixel content to detect screenshots. Filename and software signatures are
+// conclusive; everything else contributes points so that a screen-sized
+// camera export isn't flagged on resolution alone.
+func detectScreenshot(metadata *ImageMetadata, filename string, content *ScreenContentAnalysis) *ScreenshotDetection {
 	detection := &ScreenshotDetection{
-		LikelyScreenshot: false,
-		Confidence:       "low",
-		Indicators:       []string{},
+		Content: content,
 	}
+	score := newScorer(screenshotBias)
 
 	if metadata.Width == 0 || metadata.Height == 0 {
-		return detection
+		return detection.apply(score)
 	}
 
 	// Check 0: Filename patterns (Strongest indicator for OS screenshots)
@@ -550,16 +732,14 @@ func detectScreenshot(metadata *ImageMetadata, filename string) *ScreenshotDetec
 		// Check for specific macOS/Windows patterns
 		if strings.Contains(filenameLower, " at ") || // macOS
 			strings.Contains(filenameLower, " (") { // Windows "Screenshot (1).png"
-			detection.LikelyScreenshot = true
-			detection.Confidence = "high"
-			detection.Indicators = append(detection.Indicators, "filename_pattern_match")
+			score.add(ReasonScreenshotFilename, screenshotWeightConclusive)
 			detection.MatchedPattern = "Filename matches OS screenshot pattern"
 			// We return immediately if it's a known filename pattern, as this is very strong evidence
-			return detection
+			return detection.apply(score)
 		}
 
 		// Generic "screenshot" in name
-		detection.Indicators = append(detection.Indicators, "filename_contains_screenshot")
+		score.add(ReasonFilenameScreenshot, screenshotWeightFilename)
 	}
 
 	// Common screenshot software signatures
@@ -575,11 +755,9 @@ func detectScreenshot(metadata *ImageMetadata, filename string) *ScreenshotDetec
 		softwareLower := strings.ToLower(metadata.Software)
 		for _, keyword := range screenshotSoftware {
 			if strings.Contains(softwareLower, keyword) {
-				detection.LikelyScreenshot = true
-				detection.Confidence = "high"
-				detection.Indicators = append(detection.Indicators, "screenshot_software_detected")
+				score.add(ReasonScreenshotSoftware, screenshotWeightConclusive)
 				detection.MatchedPattern = fmt.Sprintf("Software: %s", metadata.Software)
-				return detection
+				return detection.apply(score)
 			}
 		}
 	}
@@ -588,11 +766,7 @@ func detectScreenshot(metadata *ImageMetadata, filename string) *ScreenshotDetec
 	height := metadata.Height
 
 	// Common screen resolutions (width x height)
-	commonResolutions := []struct {
-		width  int
-		height int
-		name   string
-	}{
+	commonResolutions := []screenResolution{
 		// HD and Full HD
 		{1280, 720, "HD 720p"},
 		{1920, 1080, "Full HD 1080p"},
@@ -638,18 +812,64 @@ func detectScreenshot(metadata *ImageMetadata, filename string) *ScreenshotDetec
 		{1440, 900, "WXGA+"},
 	}
 
-	// Check 2: Exact resolution match
+	// Check 2: Resolution, as one signal among several. Only the strongest
+	// of exact, aspect-ratio and scaled matches counts.
+	if pattern, code, weight := matchScreenResolution(width, height, commonResolutions); code != "" {
+		score.add(code, weight)
+		detection.MatchedPattern = pattern
+	}
+
+	// Check 3: Pixel content
+	if content != nil {
+		if content.FlatFraction >= flatScreenThreshold {
+			score.add(ReasonFlatColorRegions, screenshotWeightFlat)
+		} else if content.FlatFraction < flatPhotoThreshold {
+			score.add(ReasonPhotographicContent, screenshotWeightPhoto)
+		}
+
+		if content.StatusBar {
+			score.add(ReasonUniformStatusBar, screenshotWeightStatusBar)
+		}
+
+		if content.StraightEdges >= minStraightEdges {
+			score.add(ReasonRectangularEdges, screenshotWeightEdges)
+		}
+
+		if detection.MatchedPattern == "" && score.logOdds() > 0 {
+			detection.MatchedPattern = fmt.Sprintf("%dx%d (screen-like content)", width, height)
+		}
+	}
+
+	// Check 4: Cameras record make and model; screen captures don't
+	if metadata.Make != "" || metadata.Model != "" {
+		score.add(ReasonCameraMetadata, screenshotWeightCamera)
+	}
+
+	return detection.apply(score)
+}
+
+// apply sets the verdict, probability and signals from a scorer
+func (d *ScreenshotDetection) apply(s *scorer) *ScreenshotDetection {
+	d.Probability = s.probability()
+	d.LikelyScreenshot = d.Probability >= 0.5
+	d.Confidence = confidenceLabel(d.Probability)
+	d.Signals = s.signals
+	d.Indicators = s.indicators()
+	return d
+}
+
+// matchScreenResolution checks exact, aspect-ratio and scaled matches
+// against common screen resolutions, returning the matched pattern, its
+// reason code and its weight
+func matchScreenResolution(width, height int, commonResolutions []screenResolution) (string, ReasonCode, float64) {
+	// Exact resolution match
 	for _, res := range commonResolutions {
 		if (width == res.width && height == res.height) || (width == res.height && height == res.width) {
-			detection.LikelyScreenshot = true
-			detection.Confidence = "high"
-			detection.Indicators = append(detection.Indicators, "common_screen_resolution")
-			detection.MatchedPattern = fmt.Sprintf("%dx%d (%s)", res.width, res.height, res.name)
-			return detection
+			return fmt.Sprintf("%dx%d (%s)", res.width, res.height, res.name), ReasonScreenResolution, screenshotWeightExact
 		}
 	}
 
-	// Check 3: Common aspect ratios
+	// Common aspect ratios
 	aspectRatio := float64(width) / float64(height)
 	commonAspectRatios := []struct {
 		ratio     float64
@@ -668,50 +888,26 @@ func detectScreenshot(metadata *ImageMetadata, filename string) *ScreenshotDetec
 	for _, ar := range commonAspectRatios {
 		if (aspectRatio >= ar.ratio-ar.tolerance && aspectRatio <= ar.ratio+ar.tolerance) ||
 			(1/aspectRatio >= ar.ratio-ar.tolerance && 1/aspectRatio <= ar.ratio+ar.tolerance) {
-
 			// Check if dimensions are "screen-like"
 			if isScreenLikeDimension(width, height) {
-				detection.LikelyScreenshot = true
-				// If we already have a filename hint, upgrade to high, otherwise medium
-				if len(detection.Indicators) > 0 {
-					detection.Confidence = "high"
-				} else {
-					detection.Confidence = "medium"
-				}
-				detection.Indicators = append(detection.Indicators, "screen_aspect_ratio")
-				detection.MatchedPattern = fmt.Sprintf("%dx%d (Aspect ratio: %s)", width, height, ar.name)
-				return detection
+				return fmt.Sprintf("%dx%d (Aspect ratio: %s)", width, height, ar.name), ReasonScreenAspectRatio, screenshotWeightAspect
 			}
 		}
 	}
 
-	// Check 4: Scaled versions of common resolutions (e.g., 50%, 200%)
+	// Scaled versions of common resolutions (e.g., 50%, 200%)
 	for _, res := range commonResolutions {
 		for _, scale := range []float64{0.5, 0.75, 1.5, 2.0} {
 			scaledW := int(float64(res.width) * scale)
 			scaledH := int(float64(res.height) * scale)
 
 			if (width == scaledW && height == scaledH) || (width == scaledH && height == scaledW) {
-				detection.LikelyScreenshot = true
-				if len(detection.Indicators) > 0 {
-					detection.Confidence = "high"
-				} else {
-					detection.Confidence = "medium"
-				}
-				detection.Indicators = append(detection.Indicators, "scaled_screen_resolution")
-				detection.MatchedPattern = fmt.Sprintf("%dx%d (%.0f%% of %s)", width, height, scale*100, res.name)
-				return detection
+				return fmt.Sprintf("%dx%d (%.0f%% of %s)", width, height, scale*100, res.name), ReasonScaledResolution, screenshotWeightScaled
 			}
 		}
 	}
 
-	// If we had a weak filename match but no resolution match, potential screenshot
-	if len(detection.Indicators) > 0 {
-		detection.LikelyScreenshot = true
-		detection.Confidence = "low"
-	}
-
-	return detection
+	return "", "", 0
 }
 
 // isScreenLikeDimension checks if dimensions are typical for screenshots
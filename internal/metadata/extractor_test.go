@@ -2,6 +2,8 @@ package metadata
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"mime/multipart"
 	"net/textproto"
@@ -74,7 +76,7 @@ func TestExtract(t *testing.T) {
 			}
 
 			// Test Extract function
-			result, err := Extract(file, form.File["file"][0])
+			result, err := Extract(context.Background(), file, form.File["file"][0])
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Extract() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -115,6 +117,44 @@ func TestExtract(t *testing.T) {
 	}
 }
 
+func TestGPSDataString(t *testing.T) {
+	gps := &GPSData{Latitude: 48.8584, Longitude: 2.2945}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		if got := fmt.Sprintf(format, gps); strings.Contains(got, "48.8584") || strings.Contains(got, "2.2945") {
+			t.Errorf("Sprintf(%q) = %q, want the coordinates left out", format, got)
+		}
+	}
+	got := fmt.Sprintf("%+v", ImageMetadata{Width: 10, GPS: gps})
+	if strings.Contains(got, "48.8584") {
+		t.Errorf("Sprintf of image metadata = %q, want the coordinates left out", got)
+	}
+}
+
+func TestWithoutPersonalData(t *testing.T) {
+	result := &Result{Filename: "photo.jpg", Image: &ImageMetadata{
+		Make:             "Canon",
+		SerialNumber:     "083024001234",
+		LensSerialNumber: "0000c1a2b3",
+		GPS:              &GPSData{Latitude: 48.8584, Longitude: 2.2945},
+	}}
+
+	redacted := result.WithoutPersonalData()
+	if redacted.Image.GPS != nil || redacted.Image.SerialNumber != "" || redacted.Image.LensSerialNumber != "" {
+		t.Errorf("redacted image = %+v, want no GPS or serial numbers", redacted.Image)
+	}
+	if redacted.Image.Make != "Canon" || redacted.Filename != "photo.jpg" {
+		t.Errorf("redacted = %+v, want the rest kept", redacted)
+	}
+	if result.Image.GPS == nil || result.Image.SerialNumber == "" {
+		t.Error("WithoutPersonalData modified the result")
+	}
+
+	plain := &Result{Filename: "notes.txt"}
+	if plain.WithoutPersonalData() != plain {
+		t.Error("WithoutPersonalData copied a result without personal data")
+	}
+}
+
 func TestExtractVerifyChecksum(t *testing.T) {
 	content := "test content"
 
@@ -136,11 +176,11 @@ func TestExtractVerifyChecksum(t *testing.T) {
 
 	file, _ := form.File["file"][0].Open()
 
-	result1, _ := Extract(file, form.File["file"][0])
+	result1, _ := Extract(context.Background(), file, form.File["file"][0])
 
 	// Extract again with same content
 	file2, _ := form.File["file"][0].Open()
-	result2, _ := Extract(file2, form.File["file"][0])
+	result2, _ := Extract(context.Background(), file2, form.File["file"][0])
 
 	// Checksums should match
 	if result1.SHA256 != result2.SHA256 {
@@ -234,7 +274,7 @@ func TestDetectAIGenerated(t *testing.T) {
 			},
 			exifData:           "has_exif",
 			expectedAI:         false,
-			expectedConfidence: "low", // Changed from high - has camera make/model but missing technical data (score:2)
+			expectedConfidence: "medium", // Camera make/model outweighs the missing technical data
 			expectedHasReasons: true,
 		},
 	}
@@ -289,6 +329,7 @@ func TestDetectScreenshot(t *testing.T) {
 		name               string
 		metadata           *ImageMetadata
 		filename           string // Added filename field
+		content            *ScreenContentAnalysis
 		expectedScreenshot bool
 		expectedConfidence string
 		expectedPattern    string
@@ -300,7 +341,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 1080,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "high",
+			expectedConfidence: "medium",
 			expectedPattern:    "1920x1080 (Full HD 1080p)",
 		},
 		{
@@ -310,7 +351,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 1800,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "high",
+			expectedConfidence: "medium",
 			expectedPattern:    "2880x1800 (MacBook Pro 15\" Retina)",
 		},
 		{
@@ -320,7 +361,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 2732,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "high",
+			expectedConfidence: "medium",
 			expectedPattern:    "2732x2048 (iPad Pro 12.9\")",
 		},
 		{
@@ -330,7 +371,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 2160,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "high",
+			expectedConfidence: "medium",
 			expectedPattern:    "3840x2160 (4K UHD)",
 		},
 		{
@@ -351,7 +392,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 540,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "medium",
+			expectedConfidence: "low",
 			expectedPattern:    "960x540 (A", // Matches "Aspect ratio" since it hits that check first
 		},
 		{
@@ -361,7 +402,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 900,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "high",
+			expectedConfidence: "medium",
 			expectedPattern:    "1600x900 (HD+ 900p)",
 		},
 		{
@@ -371,7 +412,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 1024,
 			},
 			expectedScreenshot: false,
-			expectedConfidence: "low",
+			expectedConfidence: "medium", // Only the prior, about 18%
 		},
 		{
 			name: "Camera photo resolution (not screenshot)",
@@ -380,7 +421,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 3000,
 			},
 			expectedScreenshot: false,
-			expectedConfidence: "low",
+			expectedConfidence: "medium", // Only the prior, about 18%
 		},
 		{
 			name: "Unusual aspect ratio (not screenshot)",
@@ -389,7 +430,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 567,
 			},
 			expectedScreenshot: false,
-			expectedConfidence: "low",
+			expectedConfidence: "medium", // Only the prior, about 18%
 		},
 		{
 			name: "Mobile screenshot (portrait)",
@@ -398,7 +439,7 @@ func TestDetectScreenshot(t *testing.T) {
 				Height: 2340,
 			},
 			expectedScreenshot: true,
-			expectedConfidence: "high",
+			expectedConfidence: "medium",
 			expectedPattern:    "2340x1080", // Detection normalizes to landscape orientation
 		},
 		{
@@ -412,6 +453,50 @@ func TestDetectScreenshot(t *testing.T) {
 			expectedConfidence: "high",
 			expectedPattern:    "Filename matches OS screenshot pattern",
 		},
+		{
+			name: "Full HD with UI content",
+			metadata: &ImageMetadata{
+				Width:  1920,
+				Height: 1080,
+			},
+			content:            &ScreenContentAnalysis{FlatFraction: 0.82, StatusBar: true, StraightEdges: 14},
+			expectedScreenshot: true,
+			expectedConfidence: "high",
+			expectedPattern:    "1920x1080 (Full HD 1080p)",
+		},
+		{
+			name: "Full HD camera export (not screenshot)",
+			metadata: &ImageMetadata{
+				Width:  1920,
+				Height: 1080,
+				Make:   "SONY",
+				Model:  "ILCE-7M3",
+			},
+			content:            &ScreenContentAnalysis{FlatFraction: 0.01},
+			expectedScreenshot: false,
+			expectedConfidence: "high",
+		},
+		{
+			name: "Full HD photo with stripped EXIF (not screenshot)",
+			metadata: &ImageMetadata{
+				Width:  1920,
+				Height: 1080,
+			},
+			content:            &ScreenContentAnalysis{FlatFraction: 0.02},
+			expectedScreenshot: false,
+			expectedConfidence: "medium",
+		},
+		{
+			name: "Cropped capture at unusual size",
+			metadata: &ImageMetadata{
+				Width:  1234,
+				Height: 567,
+			},
+			content:            &ScreenContentAnalysis{FlatFraction: 0.7, StraightEdges: 6},
+			expectedScreenshot: true,
+			expectedConfidence: "medium",
+			expectedPattern:    "1234x567 (screen-like content)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -420,7 +505,7 @@ func TestDetectScreenshot(t *testing.T) {
 			if tt.filename != "" {
 				filename = tt.filename
 			}
-			detection := detectScreenshot(tt.metadata, filename)
+			detection := detectScreenshot(tt.metadata, filename, tt.content)
 
 			if detection == nil {
 				t.Fatal("detectScreenshot returned nil")
@@ -461,6 +546,7 @@ func TestScreenshotAndAIDetectionIntegration(t *testing.T) {
 	tests := []struct {
 		name                   string
 		metadata               *ImageMetadata
+		content                *ScreenContentAnalysis
 		expectedAI             bool
 		expectedAIConfidence   string
 		shouldDetectScreenshot bool
@@ -471,6 +557,7 @@ func TestScreenshotAndAIDetectionIntegration(t *testing.T) {
 				Width:  1920,
 				Height: 1080,
 			},
+			content:                &ScreenContentAnalysis{FlatFraction: 0.82, StatusBar: true, StraightEdges: 14},
 			expectedAI:             false,
 			expectedAIConfidence:   "high",
 			shouldDetectScreenshot: true,
@@ -492,6 +579,7 @@ func TestScreenshotAndAIDetectionIntegration(t *testing.T) {
 				Height:   1080,
 				Software: "Midjourney",
 			},
+			content:                &ScreenContentAnalysis{FlatFraction: 0.82, StatusBar: true, StraightEdges: 14},
 			expectedAI:             false, // Screenshot detection takes precedence with high confidence
 			expectedAIConfidence:   "high",
 			shouldDetectScreenshot: true,
@@ -514,7 +602,7 @@ func TestScreenshotAndAIDetectionIntegration(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// First detect screenshot
-			screenshotDetection := detectScreenshot(tt.metadata, "test.png")
+			screenshotDetection := detectScreenshot(tt.metadata, "test.png", tt.content)
 			tt.metadata.ScreenshotDetection = screenshotDetection
 
 			// Then detect AI (which considers screenshot detection)
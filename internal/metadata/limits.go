@@ -0,0 +1,61 @@
+package metadata
+
+import "fmt"
+
+// MemoryLimits bounds how much memory a single extraction may use. Zero
+// fields fall back to DefaultMemoryLimits.
+type MemoryLimits struct {
+	MaxPixels        int64 // largest image, in pixels, decoded for content analysis
+	MaxDocumentBytes int   // leading bytes of a document run through text analysis
+	MaxEXIFBytes     int64 // bytes read while looking for and parsing EXIF data
+}
+
+// DefaultMemoryLimits are used when no limits are configured
+var DefaultMemoryLimits = MemoryLimits{
+	MaxPixels:        4_000_000, // 16 MB decoded as RGBA, enough for most screenshots
+	MaxDocumentBytes: headSize,
+	MaxEXIFBytes:     256 << 10,
+}
+
+// withDefaults fills zero fields from DefaultMemoryLimits. Text analysis
+// works from the buffered head, so documents are capped at its size.
+func (l MemoryLimits) withDefaults() MemoryLimits {
+	if l.MaxPixels <= 0 {
+		l.MaxPixels = DefaultMemoryLimits.MaxPixels
+	}
+	if l.MaxDocumentBytes <= 0 || l.MaxDocumentBytes > headSize {
+		l.MaxDocumentBytes = DefaultMemoryLimits.MaxDocumentBytes
+	}
+	if l.MaxEXIFBytes <= 0 {
+		l.MaxEXIFBytes = DefaultMemoryLimits.MaxEXIFBytes
+	}
+	return l
+}
+
+// Warning codes for results cut short by a memory limit
+const (
+	WarningPixelLimit    = "pixel_limit"
+	WarningDocumentLimit = "document_limit"
+	WarningEXIFLimit     = "exif_limit"
+)
+
+func pixelLimitWarning(width, height int, limit int64) Warning {
+	return Warning{
+		Code:    WarningPixelLimit,
+		Message: fmt.Sprintf("%dx%d image exceeds the %d pixel decode limit; pixel content was not analyzed", width, height, limit),
+	}
+}
+
+func documentLimitWarning(size int64, limit int) Warning {
+	return Warning{
+		Code:    WarningDocumentLimit,
+		Message: fmt.Sprintf("only the first %d of %d bytes were analyzed", limit, size),
+	}
+}
+
+func exifLimitWarning(limit int64) Warning {
+	return Warning{
+		Code:    WarningEXIFLimit,
+		Message: fmt.Sprintf("no complete EXIF block within the first %d bytes", limit),
+	}
+}
@@ -0,0 +1,119 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestMemoryLimits(t *testing.T) {
+	var pic bytes.Buffer
+	png.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+
+	// A tiny PNG whose header claims 2500x2000 pixels: decoding it fails,
+	// so only a pixel_limit warning shows it was never decoded
+	var big bytes.Buffer
+	png.Encode(&big, image.NewGray(image.Rect(0, 0, 1, 1)))
+	bigPNG := big.Bytes()
+	binary.BigEndian.PutUint32(bigPNG[16:20], 2500)
+	binary.BigEndian.PutUint32(bigPNG[20:24], 2000)
+	binary.BigEndian.PutUint32(bigPNG[29:33], crc32.ChecksumIEEE(bigPNG[12:29]))
+
+	exifJPEG := jpegWithSegments(t, app1([]byte("Exif\x00\x00"), makeTIFF("Canon")))
+	paddedJPEG := jpegWithSegments(t, app1([]byte("http://ns.adobe.com/xap/1.0/\x00"), make([]byte, 60000)), app1([]byte("Exif\x00\x00"), makeTIFF("Canon")))
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		limits   MemoryLimits
+		wantCode string
+		wantMake string
+	}{
+		{name: "image within pixel limit", filename: "pic.png", content: pic.Bytes()},
+		{name: "image over pixel limit", filename: "pic.png", content: pic.Bytes(), limits: MemoryLimits{MaxPixels: 9999}, wantCode: WarningPixelLimit},
+		{name: "large image not decoded by default", filename: "big.png", content: bigPNG, wantCode: WarningPixelLimit},
+		{name: "document within limit", filename: "notes.txt", content: []byte("hello world\n")},
+		{name: "document over limit", filename: "notes.txt", content: []byte(strings.Repeat("hello world\n", 10)), limits: MemoryLimits{MaxDocumentBytes: 24}, wantCode: WarningDocumentLimit},
+		{name: "exif found", filename: "photo.jpg", content: exifJPEG, wantMake: "Canon"},
+		{name: "exif after xmp", filename: "photo.jpg", content: paddedJPEG, wantMake: "Canon"},
+		{name: "exif beyond limit", filename: "photo.jpg", content: paddedJPEG, limits: MemoryLimits{MaxEXIFBytes: 1024}, wantCode: WarningEXIFLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Memory: tt.limits}
+			result, err := ExtractWithOptions(context.Background(), memoryFile{bytes.NewReader(tt.content)}, fileHeader(tt.filename), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var codes []string
+			for _, warning := range result.Warnings {
+				codes = append(codes, warning.Code)
+			}
+			if tt.wantCode == "" && len(codes) != 0 {
+				t.Errorf("Warnings = %v, want none", codes)
+			}
+			if tt.wantCode != "" && (len(codes) != 1 || codes[0] != tt.wantCode) {
+				t.Errorf("Warnings = %v, want [%s]", codes, tt.wantCode)
+			}
+
+			if tt.wantMake != "" && (result.Image == nil || result.Image.Make != tt.wantMake) {
+				t.Errorf("Image = %+v, want make %q", result.Image, tt.wantMake)
+			}
+		})
+	}
+}
+
+func TestMemoryLimitsWithDefaults(t *testing.T) {
+	got := MemoryLimits{MaxPixels: 10, MaxDocumentBytes: 2 * headSize}.withDefaults()
+	want := MemoryLimits{MaxPixels: 10, MaxDocumentBytes: headSize, MaxEXIFBytes: DefaultMemoryLimits.MaxEXIFBytes}
+	if got != want {
+		t.Errorf("withDefaults() = %+v, want %+v", got, want)
+	}
+}
+
+// jpegWithSegments encodes a small JPEG with segments inserted after SOI
+func jpegWithSegments(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	out := append([]byte{}, encoded[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, encoded[2:]...)
+}
+
+// app1 builds an APP1 segment from its parts
+func app1(parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// makeTIFF builds a little-endian TIFF block with only a Make tag
+func makeTIFF(camera string) []byte {
+	value := append([]byte(camera), 0)
+	b := []byte("II*\x00")
+	b = binary.LittleEndian.AppendUint32(b, 8)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 0x010F) // Make
+	b = binary.LittleEndian.AppendUint16(b, 2)      // ASCII
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+	b = binary.LittleEndian.AppendUint32(b, 26)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	return append(b, value...)
+}
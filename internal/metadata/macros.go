@@ -0,0 +1,307 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MacroDetection reports VBA macros in Office documents
+type MacroDetection struct {
+	HasMacros  bool     `json:"has_macros"`
+	Container  string   `json:"container"` // "ooxml" or "ole"
+	Location   string   `json:"location,omitempty"`
+	Modules    []string `json:"modules,omitempty"`
+	AutoExec   []string `json:"auto_exec,omitempty"`
+	Suspicious []string `json:"suspicious,omitempty"`
+	Indicators []string `json:"indicators,omitempty"`
+}
+
+// ooxmlMacroFree are extensions that must not contain macros; Office refuses
+// to run macros from them, so macros there indicate a renamed file
+var ooxmlMacroFree = map[string]bool{"docx": true, "xlsx": true, "pptx": true}
+
+// vbaAutoExec matches procedures Office runs automatically
+var vbaAutoExec = regexp.MustCompile(`(?i)\b(AutoExec|AutoOpen|Auto_Open|AutoClose|Auto_Close|AutoNew|AutoExit|` +
+	`Document_Open|Document_Close|Document_New|Document_BeforeClose|Document_ContentControlOnEnter|DocumentOpen|DocumentBeforeClose|` +
+	`Workbook_Open|Workbook_Activate|Workbook_Close|Workbook_BeforeClose|Workbook_Deactivate|` +
+	`Presentation_Open|App_PresentationOpen|UserForm_Initialize|UserForm_Activate)\b`)
+
+// vbaSuspicious matches calls commonly used by malicious macros
+var vbaSuspicious = regexp.MustCompile(`(?i)\b(Shell|ShellExecute|WScript\.Shell|CreateObject|GetObject|PowerShell|` +
+	`URLDownloadToFile|XMLHTTP|ServerXMLHTTP|ADODB\.Stream|CallByName|Environ|Declare\s+(?:PtrSafe\s+)?Function|` +
+	`RtlMoveMemory|VirtualAlloc|CreateThread|StrReverse|Chr[BW]?\$?\(|Base64|ExecuteExcel4Macro|MacroOptions)`)
+
+// maxVBAProjectSize bounds how large a vbaProject.bin is read into memory
+const maxVBAProjectSize = 32 << 20
+
+// detectMacros checks OOXML (zip) and legacy OLE Office files for VBA
+// projects. primary is the extension-style name of the detected format and
+// ext the upload's file extension. Returns nil when no macros are found.
+func detectMacros(r io.ReaderAt, size int64, primary, ext string) *MacroDetection {
+	if zipContainerFormats[primary] {
+		return ooxmlMacros(r, size, ext)
+	}
+	// Any compound file may carry a VBA project, not only those identified
+	// as doc/xls/ppt
+	return oleMacros(r, size)
+}
+
+func ooxmlMacros(r io.ReaderAt, size int64, ext string) *MacroDetection {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil
+	}
+
+	for _, f := range zr.File {
+		if !strings.EqualFold(path.Base(f.Name), "vbaProject.bin") {
+			continue
+		}
+
+		detection := &MacroDetection{HasMacros: true, Container: "ooxml", Location: f.Name}
+		if ooxmlMacroFree[ext] {
+			detection.Indicators = append(detection.Indicators, "macros_in_macro_free_extension")
+		}
+		if f.UncompressedSize64 > maxVBAProjectSize {
+			return detection
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return detection
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxVBAProjectSize))
+		rc.Close()
+		if err != nil {
+			return detection
+		}
+
+		if cfb, err := openCFB(bytes.NewReader(data), int64(len(data))); err == nil {
+			analyzeVBA(cfb, detection)
+		}
+		return detection
+	}
+	return nil
+}
+
+func oleMacros(r io.ReaderAt, size int64) *MacroDetection {
+	cfb, err := openCFB(r, size)
+	if err != nil {
+		return nil
+	}
+
+	detection := &MacroDetection{Container: "ole"}
+	analyzeVBA(cfb, detection)
+	if !detection.HasMacros {
+		return nil
+	}
+	return detection
+}
+
+// analyzeVBA reads every VBA storage's module source and records module
+// names, auto-exec entry points and suspicious calls
+func analyzeVBA(cfb *cfbFile, detection *MacroDetection) {
+	autoExec := make(map[string]bool)
+	suspicious := make(map[string]bool)
+
+	for _, vba := range cfb.storages("VBA") {
+		dirEntry := cfb.find(vba + "/dir")
+		if dirEntry == nil {
+			continue
+		}
+		detection.HasMacros = true
+		if detection.Location == "" {
+			detection.Location = vba
+		}
+
+		compressed, err := cfb.readStream(dirEntry)
+		if err != nil {
+			continue
+		}
+		dir, err := decompressVBA(compressed)
+		if err != nil {
+			detection.Indicators = appendUnique(detection.Indicators, "unreadable_vba_dir")
+			continue
+		}
+
+		for _, module := range parseVBADir(dir) {
+			detection.Modules = append(detection.Modules, module.name)
+
+			stream := cfb.find(vba + "/" + module.stream)
+			if stream == nil {
+				continue
+			}
+			data, err := cfb.readStream(stream)
+			if err != nil || int(module.offset) > len(data) {
+				continue
+			}
+			source, err := decompressVBA(data[module.offset:])
+			if err != nil {
+				continue
+			}
+
+			for _, m := range vbaAutoExec.FindAllString(string(source), -1) {
+				autoExec[m] = true
+			}
+			for _, m := range vbaSuspicious.FindAllString(string(source), -1) {
+				suspicious[strings.TrimSuffix(m, "(")] = true
+			}
+		}
+	}
+
+	detection.AutoExec = sortedKeys(autoExec)
+	detection.Suspicious = sortedKeys(suspicious)
+	if len(detection.AutoExec) > 0 {
+		detection.Indicators = appendUnique(detection.Indicators, "auto_exec")
+	}
+	if len(detection.AutoExec) > 0 && len(detection.Suspicious) > 0 {
+		detection.Indicators = appendUnique(detection.Indicators, "auto_exec_with_suspicious_calls")
+	}
+}
+
+// vbaModule is a module described by the VBA dir stream
+type vbaModule struct {
+	name   string
+	stream string
+	offset uint32
+}
+
+// VBA dir stream record IDs, see [MS-OVBA] 2.3.4.2
+const (
+	vbaRecordProjectVersion = 0x0009
+	vbaRecordModuleName     = 0x0019
+	vbaRecordStreamName     = 0x001A
+	vbaRecordModuleOffset   = 0x0031
+	vbaRecordTerminator     = 0x002B
+	vbaRecordDirEnd         = 0x0010
+)
+
+// parseVBADir extracts module names, stream names and source offsets from
+// a decompressed dir stream
+func parseVBADir(dir []byte) []vbaModule {
+	var modules []vbaModule
+	var current *vbaModule
+
+	for pos := 0; pos+6 <= len(dir); {
+		id := binary.LittleEndian.Uint16(dir[pos:])
+		size := int(binary.LittleEndian.Uint32(dir[pos+2:]))
+		pos += 6
+
+		// PROJECTVERSION's size field doesn't count its 2-byte minor version
+		if id == vbaRecordProjectVersion {
+			size += 2
+		}
+		if size < 0 || pos+size > len(dir) {
+			break
+		}
+		data := dir[pos : pos+size]
+		pos += size
+
+		switch id {
+		case vbaRecordModuleName:
+			modules = append(modules, vbaModule{name: string(data)})
+			current = &modules[len(modules)-1]
+		case vbaRecordStreamName:
+			if current != nil {
+				current.stream = string(data)
+			}
+		case vbaRecordModuleOffset:
+			if current != nil && len(data) >= 4 {
+				current.offset = binary.LittleEndian.Uint32(data)
+			}
+		case vbaRecordTerminator:
+			current = nil
+		case vbaRecordDirEnd:
+			return modules
+		}
+	}
+	return modules
+}
+
+var errInvalidVBACompression = errors.New("invalid VBA compressed container")
+
+// decompressVBA decompresses an [MS-OVBA] 2.4.1 compressed container
+func decompressVBA(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 0x01 {
+		return nil, errInvalidVBACompression
+	}
+
+	var out []byte
+	for pos := 1; pos+2 <= len(data); {
+		header := binary.LittleEndian.Uint16(data[pos:])
+		chunkEnd := min(pos+int(header&0x0FFF)+3, len(data))
+		compressed := header&0x8000 != 0
+		pos += 2
+
+		if !compressed {
+			out = append(out, data[pos:min(pos+4096, len(data))]...)
+			pos += 4096
+			continue
+		}
+
+		chunkStart := len(out)
+		for pos < chunkEnd {
+			flags := data[pos]
+			pos++
+			for bit := 0; bit < 8 && pos < chunkEnd; bit++ {
+				if flags&(1<<bit) == 0 {
+					out = append(out, data[pos])
+					pos++
+					continue
+				}
+
+				if pos+2 > chunkEnd {
+					return nil, errInvalidVBACompression
+				}
+				token := binary.LittleEndian.Uint16(data[pos:])
+				pos += 2
+
+				// Offset bits grow with the decompressed position in the chunk
+				bitCount := 4
+				for (1 << bitCount) < len(out)-chunkStart {
+					bitCount++
+				}
+				lengthMask := uint16(0xFFFF) >> bitCount
+				length := int(token&lengthMask) + 3
+				offset := int(token>>(16-bitCount)) + 1
+
+				src := len(out) - offset
+				if src < chunkStart {
+					return nil, errInvalidVBACompression
+				}
+				for i := 0; i < length; i++ {
+					out = append(out, out[src+i])
+				}
+			}
+		}
+		pos = chunkEnd
+	}
+	return out, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,308 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestDecompressVBA(t *testing.T) {
+	// Example from [MS-OVBA] 3.2.3
+	compressed, _ := hex.DecodeString("012FB000236161616263646582660070616768696A01380861" +
+		"6B6C00306D6E6F700671027004107273747576107778797A003C")
+	expected := "#aaabcdefaaaaghijaaaaaklaaamnopqaaaaaaaaaaaarstuvwxyzaaa"
+
+	got, err := decompressVBA(compressed)
+	if err != nil {
+		t.Fatalf("decompressVBA() error = %v", err)
+	}
+	if string(got) != expected {
+		t.Errorf("decompressVBA() = %q, want %q", got, expected)
+	}
+
+	if _, err := decompressVBA([]byte{0x02, 0x00}); err == nil {
+		t.Error("decompressVBA() accepted an invalid signature")
+	}
+}
+
+func TestDetectMacros(t *testing.T) {
+	malicious := vbaProject("Module1", "Sub AutoOpen()\r\n  Shell \"cmd /c calc\"\r\nEnd Sub\r\n")
+	benign := vbaProject("Module1", "Sub Format()\r\n  Selection.Font.Bold = True\r\nEnd Sub\r\n")
+
+	makeZip := func(files map[string][]byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, data := range files {
+			w, _ := zw.Create(name)
+			w.Write(data)
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name               string
+		data               []byte
+		primary            string
+		ext                string
+		expectNil          bool
+		expectedContainer  string
+		expectedModules    []string
+		expectedAutoExec   []string
+		expectedSuspicious []string
+		expectedIndicators []string
+	}{
+		{
+			name:               "docm with auto-exec macro",
+			data:               makeZip(map[string][]byte{"[Content_Types].xml": nil, "word/vbaProject.bin": malicious}),
+			primary:            "docx",
+			ext:                "docm",
+			expectedContainer:  "ooxml",
+			expectedModules:    []string{"Module1"},
+			expectedAutoExec:   []string{"AutoOpen"},
+			expectedSuspicious: []string{"Shell"},
+			expectedIndicators: []string{"auto_exec", "auto_exec_with_suspicious_calls"},
+		},
+		{
+			name:               "xlsx renamed from xlsm",
+			data:               makeZip(map[string][]byte{"xl/vbaProject.bin": benign}),
+			primary:            "xlsx",
+			ext:                "xlsx",
+			expectedContainer:  "ooxml",
+			expectedModules:    []string{"Module1"},
+			expectedIndicators: []string{"macros_in_macro_free_extension"},
+		},
+		{
+			name:      "docx without macros",
+			data:      makeZip(map[string][]byte{"word/document.xml": []byte("<w:document/>")}),
+			primary:   "docx",
+			ext:       "docx",
+			expectNil: true,
+		},
+		{
+			name:               "legacy doc with macros",
+			data:               malicious,
+			primary:            "doc",
+			ext:                "doc",
+			expectedContainer:  "ole",
+			expectedModules:    []string{"Module1"},
+			expectedAutoExec:   []string{"AutoOpen"},
+			expectedSuspicious: []string{"Shell"},
+			expectedIndicators: []string{"auto_exec", "auto_exec_with_suspicious_calls"},
+		},
+		{
+			name:      "not a compound file",
+			data:      []byte("plain text"),
+			primary:   "txt",
+			ext:       "txt",
+			expectNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detection := detectMacros(bytes.NewReader(tt.data), int64(len(tt.data)), tt.primary, tt.ext)
+
+			if tt.expectNil {
+				if detection != nil {
+					t.Errorf("detectMacros() = %+v, want nil", detection)
+				}
+				return
+			}
+
+			if detection == nil {
+				t.Fatal("detectMacros() returned nil")
+			}
+
+			if !detection.HasMacros {
+				t.Error("HasMacros = false, want true")
+			}
+
+			if detection.Container != tt.expectedContainer {
+				t.Errorf("Container = %v, want %v", detection.Container, tt.expectedContainer)
+			}
+
+			if !reflect.DeepEqual(detection.Modules, tt.expectedModules) {
+				t.Errorf("Modules = %v, want %v", detection.Modules, tt.expectedModules)
+			}
+
+			if !reflect.DeepEqual(detection.AutoExec, tt.expectedAutoExec) {
+				t.Errorf("AutoExec = %v, want %v", detection.AutoExec, tt.expectedAutoExec)
+			}
+
+			if !reflect.DeepEqual(detection.Suspicious, tt.expectedSuspicious) {
+				t.Errorf("Suspicious = %v, want %v", detection.Suspicious, tt.expectedSuspicious)
+			}
+
+			if !reflect.DeepEqual(detection.Indicators, tt.expectedIndicators) {
+				t.Errorf("Indicators = %v, want %v", detection.Indicators, tt.expectedIndicators)
+			}
+		})
+	}
+}
+
+// compressVBALiterals wraps data in an [MS-OVBA] compressed container using
+// only literal tokens
+func compressVBALiterals(data []byte) []byte {
+	out := []byte{0x01}
+	for len(data) > 0 {
+		n := min(len(data), 4096)
+		var chunk []byte
+		for i := 0; i < n; i += 8 {
+			chunk = append(chunk, 0x00)
+			chunk = append(chunk, data[i:min(i+8, n)]...)
+		}
+		header := uint16(0x8000|0x3000) | uint16(len(chunk)+2-3)
+		out = binary.LittleEndian.AppendUint16(out, header)
+		out = append(out, chunk...)
+		data = data[n:]
+	}
+	return out
+}
+
+// vbaProject builds a compound file holding a VBA storage with a dir
+// stream and a single module. Both streams are below the mini stream
+// cutoff so they are read through the mini FAT.
+func vbaProject(module, source string) []byte {
+	record := func(id uint16, data []byte) []byte {
+		b := binary.LittleEndian.AppendUint16(nil, id)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+		return append(b, data...)
+	}
+
+	// A performance cache precedes the source text in the module stream
+	cache := bytes.Repeat([]byte{0xCC}, 20)
+
+	dir := concat(
+		record(0x0001, []byte{1, 0, 0, 0}),
+		// PROJECTVERSION: size 4 followed by a 2-byte minor version
+		[]byte{0x09, 0x00, 0x04, 0x00, 0x00, 0x00, 1, 0, 0, 0, 2, 0},
+		record(0x000F, []byte{1, 0}),
+		record(0x0013, []byte{0xFF, 0xFF}),
+		record(vbaRecordModuleName, []byte(module)),
+		record(vbaRecordStreamName, []byte(module)),
+		record(vbaRecordModuleOffset, binary.LittleEndian.AppendUint32(nil, uint32(len(cache)))),
+		record(vbaRecordTerminator, nil),
+		record(vbaRecordDirEnd, nil),
+	)
+
+	return buildCFB([]cfbTestStream{
+		{name: "dir", data: compressVBALiterals(dir)},
+		{name: module, data: concat(cache, compressVBALiterals([]byte(source)))},
+	})
+}
+
+type cfbTestStream struct {
+	name string
+	data []byte
+}
+
+// buildCFB writes a version 3 compound file whose root holds one "VBA"
+// storage containing the given streams, all stored in the mini stream.
+// Sector layout: 0 FAT, 1 directory, 2 mini FAT, 3+ mini stream.
+func buildCFB(streams []cfbTestStream) []byte {
+	const sector = 512
+	const mini = 64
+	le := binary.LittleEndian
+
+	var miniStream []byte
+	var miniFAT []uint32
+	starts := make([]uint32, len(streams))
+	for i, s := range streams {
+		starts[i] = uint32(len(miniFAT))
+		n := (len(s.data) + mini - 1) / mini
+		for j := 0; j < n; j++ {
+			next := uint32(len(miniFAT) + 1)
+			if j == n-1 {
+				next = cfbEndOfChain
+			}
+			miniFAT = append(miniFAT, next)
+		}
+		padded := make([]byte, n*mini)
+		copy(padded, s.data)
+		miniStream = append(miniStream, padded...)
+	}
+	miniSectors := (len(miniStream) + sector - 1) / sector
+
+	entry := func(name string, kind byte, left, right, child, start uint32, size int) []byte {
+		b := make([]byte, cfbDirEntrySize)
+		units := utf16.Encode([]rune(name))
+		for i, u := range units {
+			le.PutUint16(b[2*i:], u)
+		}
+		le.PutUint16(b[64:], uint16(2*len(units)+2))
+		b[66] = kind
+		le.PutUint32(b[68:], left)
+		le.PutUint32(b[72:], right)
+		le.PutUint32(b[76:], child)
+		le.PutUint32(b[116:], start)
+		le.PutUint64(b[120:], uint64(size))
+		return b
+	}
+
+	// Root -> VBA -> streams, with streams chained as right siblings
+	dir := concat(
+		entry("Root Entry", cfbTypeRoot, cfbNoStream, cfbNoStream, 1, 3, len(miniStream)),
+		entry("VBA", cfbTypeStorage, cfbNoStream, cfbNoStream, 2, 0, 0),
+	)
+	for i, s := range streams {
+		right := uint32(cfbNoStream)
+		if i < len(streams)-1 {
+			right = uint32(i + 3)
+		}
+		dir = append(dir, entry(s.name, cfbTypeStream, cfbNoStream, right, cfbNoStream, starts[i], len(s.data))...)
+	}
+
+	fat := make([]byte, sector)
+	for i := 0; i < sector/4; i++ {
+		le.PutUint32(fat[4*i:], cfbNoStream)
+	}
+	le.PutUint32(fat[0:], 0xFFFFFFFD)
+	le.PutUint32(fat[4:], cfbEndOfChain)
+	le.PutUint32(fat[8:], cfbEndOfChain)
+	for i := 0; i < miniSectors; i++ {
+		next := uint32(4 + i)
+		if i == miniSectors-1 {
+			next = cfbEndOfChain
+		}
+		le.PutUint32(fat[4*(3+i):], next)
+	}
+
+	miniFATSector := make([]byte, sector)
+	for i := 0; i < sector/4; i++ {
+		v := uint32(cfbNoStream)
+		if i < len(miniFAT) {
+			v = miniFAT[i]
+		}
+		le.PutUint32(miniFATSector[4*i:], v)
+	}
+
+	header := make([]byte, cfbHeaderSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], 1)
+	le.PutUint32(header[0x30:], 1)
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], 2)
+	le.PutUint32(header[0x40:], 1)
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4C+4*i:], cfbNoStream)
+	}
+	le.PutUint32(header[0x4C:], 0)
+
+	dirSector := make([]byte, sector)
+	copy(dirSector, dir)
+	miniData := make([]byte, miniSectors*sector)
+	copy(miniData, miniStream)
+
+	return concat(header, fat, dirSector, miniFATSector, miniData)
+}
@@ -0,0 +1,225 @@
+package metadata
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/h2non/filetype"
+)
+
+// Mismatch severities reported in MIMECheck.Severity, in increasing order
+const (
+	SeverityNone     = "none"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// MIMECheck compares the client-declared Content-Type and file extension
+// with the type detected from magic bytes
+type MIMECheck struct {
+	Mismatch      bool     `json:"mismatch"`
+	Severity      string   `json:"severity"` // "none", "low", "medium", "high", "critical"
+	DetectedType  string   `json:"detected_type"`
+	DeclaredType  string   `json:"declared_type,omitempty"`
+	ExtensionType string   `json:"extension_type,omitempty"`
+	Reasons       []string `json:"reasons,omitempty"`
+}
+
+// mimeAliases maps non-canonical MIME types seen in the wild to the values
+// reported by filetype.Match
+var mimeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"image/tif":                    "image/tiff",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/x-mp3":                  "audio/mpeg",
+	"audio/x-mpeg":                 "audio/mpeg",
+	"audio/wav":                    "audio/x-wav",
+	"audio/wave":                   "audio/x-wav",
+	"audio/vnd.wave":               "audio/x-wav",
+	"audio/flac":                   "audio/x-flac",
+	"audio/x-m4a":                  "audio/m4a",
+	"audio/mp4":                    "audio/m4a",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-zip":            "application/zip",
+	"application/x-gzip":           "application/gzip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"application/x-rar":            "application/vnd.rar",
+	"application/x-pdf":            "application/pdf",
+	"application/x-msdownload":     "application/vnd.microsoft.portable-executable",
+	"application/x-dosexec":        "application/vnd.microsoft.portable-executable",
+	"application/x-msdos-program":  "application/vnd.microsoft.portable-executable",
+	"application/x-elf":            "application/x-executable",
+	"application/x-sharedlib":      "application/x-executable",
+}
+
+// genericMIMETypes carry no information about the content and are not
+// compared against the detected type
+var genericMIMETypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/unknown":      true,
+}
+
+// executableMIMETypes are detected types that can run code natively
+var executableMIMETypes = map[string]bool{
+	"application/vnd.microsoft.portable-executable": true,
+	"application/x-executable":                      true,
+	"application/x-mach-binary":                     true,
+	"application/vnd.android.dex":                   true,
+	"application/vnd.android.dey":                   true,
+}
+
+// zipContainerTypes are formats stored as ZIP archives, which magic-byte
+// detection may legitimately report as application/zip
+var zipContainerTypes = map[string]bool{
+	"application/epub+zip":                                                      true,
+	"application/java-archive":                                                  true,
+	"application/vnd.android.package-archive":                                   true,
+	"application/vnd.oasis.opendocument.text":                                   true,
+	"application/vnd.oasis.opendocument.spreadsheet":                            true,
+	"application/vnd.oasis.opendocument.presentation":                           true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/vnd.ms-word.document.macroenabled.12":                          true,
+	"application/vnd.ms-excel.sheet.macroenabled.12":                            true,
+	"application/vnd.ms-powerpoint.presentation.macroenabled.12":                true,
+}
+
+// extensionAliases maps extensions to the canonical extension registered
+// with filetype
+var extensionAliases = map[string]string{
+	"jpeg": "jpg",
+	"jpe":  "jpg",
+	"tiff": "tif",
+	"mpeg": "mpg",
+	"aif":  "aiff",
+	"heic": "heif",
+}
+
+// checkMIMEMismatch compares the detected MIME type with the declared
+// Content-Type and the type implied by the file extension. Returns nil when
+// the content type could not be detected from magic bytes.
+func checkMIMEMismatch(detected, declared, ext string) *MIMECheck {
+	detected = NormalizeMIME(detected)
+	if detected == "" || genericMIMETypes[detected] {
+		return nil
+	}
+
+	check := &MIMECheck{
+		Severity:     SeverityNone,
+		DetectedType: detected,
+	}
+
+	if declared = NormalizeMIME(declared); !genericMIMETypes[declared] {
+		check.DeclaredType = declared
+		if severity := mismatchSeverity(declared, detected); severity != SeverityNone {
+			check.raise(severity, fmt.Sprintf("Declared Content-Type %s does not match detected type %s", declared, detected))
+		}
+	}
+
+	if extType := mimeForExtension(ext); extType != "" {
+		check.ExtensionType = extType
+		if severity := mismatchSeverity(extType, detected); severity != SeverityNone {
+			check.raise(severity, fmt.Sprintf("Extension .%s implies %s but content is %s", ext, extType, detected))
+		}
+	}
+
+	return check
+}
+
+// raise records a mismatch reason and keeps the highest severity seen
+func (c *MIMECheck) raise(severity, reason string) {
+	c.Mismatch = true
+	c.Reasons = append(c.Reasons, reason)
+	if severityRank(severity) > severityRank(c.Severity) {
+		c.Severity = severity
+	}
+}
+
+// mismatchSeverity grades how dangerous it is for content of type detected
+// to be presented as claimed
+func mismatchSeverity(claimed, detected string) string {
+	if claimed == detected {
+		return SeverityNone
+	}
+
+	// ZIP-based document formats are detected as plain ZIP archives
+	if detected == "application/zip" && zipContainerTypes[claimed] {
+		return SeverityNone
+	}
+	if claimed == "application/zip" && zipContainerTypes[detected] {
+		return SeverityNone
+	}
+
+	// Executables disguised as anything else are the classic attack
+	if executableMIMETypes[detected] && !executableMIMETypes[claimed] {
+		return SeverityCritical
+	}
+
+	claimedTop, _, _ := strings.Cut(claimed, "/")
+	detectedTop, _, _ := strings.Cut(detected, "/")
+
+	switch {
+	case claimedTop == detectedTop:
+		// e.g. a PNG named .jpg - usually harmless mislabelling
+		return SeverityLow
+	case detectedTop == "application":
+		// Media or text that is really an archive, PDF or other container
+		return SeverityHigh
+	default:
+		return SeverityMedium
+	}
+}
+
+// mimeForExtension returns the canonical MIME type implied by a file
+// extension, or "" if unknown
+func mimeForExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if ext == "" {
+		return ""
+	}
+	if alias, ok := extensionAliases[ext]; ok {
+		ext = alias
+	}
+
+	if kind := filetype.GetType(ext); kind != filetype.Unknown {
+		return NormalizeMIME(kind.MIME.Value)
+	}
+	return NormalizeMIME(mime.TypeByExtension("." + ext))
+}
+
+// NormalizeMIME lowercases a MIME type, strips parameters and resolves
+// known aliases
+func NormalizeMIME(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(value))
+	}
+	if canonical, ok := mimeAliases[mediaType]; ok {
+		return canonical
+	}
+	return mediaType
+}
+
+// severityRank orders severities for comparison
+func severityRank(severity string) int {
+	switch severity {
+	case SeverityLow:
+		return 1
+	case SeverityMedium:
+		return 2
+	case SeverityHigh:
+		return 3
+	case SeverityCritical:
+		return 4
+	default:
+		return 0
+	}
+}
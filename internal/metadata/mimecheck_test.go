@@ -0,0 +1,119 @@
+package metadata
+
+import "testing"
+
+func TestCheckMIMEMismatch(t *testing.T) {
+	tests := []struct {
+		name             string
+		detected         string
+		declared         string
+		ext              string
+		expectNil        bool
+		expectedMismatch bool
+		expectedSeverity string
+	}{
+		{
+			name:      "undetected content is not checked",
+			detected:  "",
+			declared:  "text/plain",
+			ext:       "txt",
+			expectNil: true,
+		},
+		{
+			name:             "matching JPEG",
+			detected:         "image/jpeg",
+			declared:         "image/jpeg",
+			ext:              "jpeg",
+			expectedSeverity: SeverityNone,
+		},
+		{
+			name:             "alias Content-Type is not a mismatch",
+			detected:         "image/jpeg",
+			declared:         "image/jpg; charset=binary",
+			ext:              "jpg",
+			expectedSeverity: SeverityNone,
+		},
+		{
+			name:             "generic declared type is ignored",
+			detected:         "image/png",
+			declared:         "application/octet-stream",
+			ext:              "png",
+			expectedSeverity: SeverityNone,
+		},
+		{
+			name:             "DOCX detected as ZIP container",
+			detected:         "application/zip",
+			declared:         "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			ext:              "docx",
+			expectedSeverity: SeverityNone,
+		},
+		{
+			name:             "PNG named as JPEG",
+			detected:         "image/png",
+			declared:         "image/jpeg",
+			ext:              "jpg",
+			expectedMismatch: true,
+			expectedSeverity: SeverityLow,
+		},
+		{
+			name:             "audio presented as image",
+			detected:         "audio/mpeg",
+			declared:         "image/jpeg",
+			ext:              "jpg",
+			expectedMismatch: true,
+			expectedSeverity: SeverityMedium,
+		},
+		{
+			name:             "ZIP archive disguised as image",
+			detected:         "application/zip",
+			declared:         "image/png",
+			ext:              "png",
+			expectedMismatch: true,
+			expectedSeverity: SeverityHigh,
+		},
+		{
+			name:             "PE executable disguised as JPEG",
+			detected:         "application/vnd.microsoft.portable-executable",
+			declared:         "image/jpeg",
+			ext:              "jpg",
+			expectedMismatch: true,
+			expectedSeverity: SeverityCritical,
+		},
+		{
+			name:             "executable with honest extension only",
+			detected:         "application/vnd.microsoft.portable-executable",
+			declared:         "",
+			ext:              "exe",
+			expectedSeverity: SeverityNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checkMIMEMismatch(tt.detected, tt.declared, tt.ext)
+
+			if tt.expectNil {
+				if check != nil {
+					t.Errorf("checkMIMEMismatch() = %+v, want nil", check)
+				}
+				return
+			}
+
+			if check == nil {
+				t.Fatal("checkMIMEMismatch returned nil")
+			}
+
+			if check.Mismatch != tt.expectedMismatch {
+				t.Errorf("Mismatch = %v, want %v (reasons: %v)", check.Mismatch, tt.expectedMismatch, check.Reasons)
+			}
+
+			if check.Severity != tt.expectedSeverity {
+				t.Errorf("Severity = %v, want %v", check.Severity, tt.expectedSeverity)
+			}
+
+			if check.Mismatch && len(check.Reasons) == 0 {
+				t.Error("Expected reasons for mismatch but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+)
+
+// The image is shrunk to phashSize x phashSize grey levels, of which the
+// lowest phashBits x phashBits DCT frequencies make up the hash. Each cell
+// averages up to phashSamples x phashSamples pixels.
+const (
+	phashSize    = 32
+	phashBits    = 8
+	phashSamples = 8
+)
+
+// phashCos[u][x] is the DCT-II basis cos((2x+1)uπ / 2N)
+var phashCos = func() (table [phashBits][phashSize]float64) {
+	for u := range table {
+		for x := range table[u] {
+			table[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	return table
+}()
+
+// perceptualHash returns the 64-bit DCT hash of img as 16 hex digits: each
+// bit says whether one of the 8x8 lowest frequencies of the shrunk grey
+// image is above their median, row by row from the most significant bit.
+// Resizing, recompression and small colour changes flip few bits. It is
+// the same layout as the imagehash library's phash, though values differ
+// slightly because the shrinking differs.
+func perceptualHash(img image.Image) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	at := rgbAccessor(img)
+
+	var grey [phashSize][phashSize]float64
+	for cy := range phashSize {
+		ys := samplePoints(cy, h)
+		for cx := range phashSize {
+			xs := samplePoints(cx, w)
+			var sum float64
+			for _, y := range ys {
+				for _, x := range xs {
+					p := at(x, y)
+					sum += 0.299*float64(p>>16&0xFF) + 0.587*float64(p>>8&0xFF) + 0.114*float64(p&0xFF)
+				}
+			}
+			grey[cy][cx] = sum / float64(len(ys)*len(xs))
+		}
+	}
+
+	// Separable 2D DCT, keeping only the low frequencies
+	var rows [phashSize][phashBits]float64
+	for y := range phashSize {
+		for u := range phashBits {
+			for x := range phashSize {
+				rows[y][u] += grey[y][x] * phashCos[u][x]
+			}
+		}
+	}
+	coefficients := make([]float64, 0, phashBits*phashBits)
+	for v := range phashBits {
+		for u := range phashBits {
+			var sum float64
+			for y := range phashSize {
+				sum += rows[y][u] * phashCos[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	sorted := slices.Clone(coefficients)
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// samplePoints spreads up to phashSamples coordinates evenly over cell i
+// of phashSize cells covering length pixels
+func samplePoints(i, length int) []int {
+	start := i * length / phashSize
+	end := max((i+1)*length/phashSize, start+1)
+	n := min(phashSamples, end-start)
+	points := make([]int, n)
+	for k := range points {
+		points[k] = min(start+(end-start)*(2*k+1)/(2*n), length-1)
+	}
+	return points
+}
+
+// ParsePerceptualHash reads a perceptual hash as 16 hex digits
+func ParsePerceptualHash(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("perceptual hash must be 16 hex digits")
+	}
+	hash, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("perceptual hash must be 16 hex digits")
+	}
+	return hash, nil
+}
+
+// HammingDistance counts the bits in which two perceptual hashes differ
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
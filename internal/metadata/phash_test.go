@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+func TestPerceptualHash(t *testing.T) {
+	scene := func(w, h int, shift uint8) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := range h {
+			for x := range w {
+				img.Set(x, y, color.RGBA{uint8(x*255/w)/2 + shift, uint8(y*255/h)/2 + shift, 90 + shift, 255})
+			}
+		}
+		draw.Draw(img, image.Rect(w/5, h/4, w/2, h*3/4), &image.Uniform{color.RGBA{240, 200, 40, 255}}, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(w*3/5, h/8, w*9/10, h/3), &image.Uniform{color.RGBA{20, 20, 60, 255}}, image.Point{}, draw.Src)
+		return img
+	}
+	original := scene(640, 480, 0)
+
+	var jpegBuf bytes.Buffer
+	jpeg.Encode(&jpegBuf, original, &jpeg.Options{Quality: 60})
+	recompressed, err := jpeg.Decode(&jpegBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A different layout: stripes instead of blocks
+	stripes := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := range 480 {
+		for x := range 640 {
+			v := uint8(40)
+			if (x+y)/60%2 == 0 {
+				v = 220
+			}
+			stripes.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		maxDistance int
+		minDistance int
+	}{
+		{name: "identical", img: original, maxDistance: 0},
+		{name: "recompressed", img: recompressed, maxDistance: 4},
+		{name: "downscaled", img: scene(160, 120, 0), maxDistance: 6},
+		{name: "brightened", img: scene(640, 480, 20), maxDistance: 6},
+		{name: "different image", img: stripes, maxDistance: 64, minDistance: 16},
+	}
+
+	want, err := ParsePerceptualHash(perceptualHash(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePerceptualHash(perceptualHash(tt.img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := HammingDistance(want, got); d > tt.maxDistance || d < tt.minDistance {
+				t.Errorf("distance = %d, want %d to %d", d, tt.minDistance, tt.maxDistance)
+			}
+		})
+	}
+
+	if hash := perceptualHash(image.NewRGBA(image.Rect(0, 0, 3, 2))); len(hash) != 16 {
+		t.Errorf("tiny image hash = %q, want 16 hex digits", hash)
+	}
+}
+
+func TestParsePerceptualHash(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{input: "8f0c3e1a00ff7b21", want: 0x8f0c3e1a00ff7b21},
+		{input: "FFFFFFFFFFFFFFFF", want: 1<<64 - 1},
+		{input: "8f0c3e1a00ff7b2", wantErr: true},
+		{input: "8f0c3e1a00ff7b21a", wantErr: true},
+		{input: "8f0c3e1a00ff7bzz", wantErr: true},
+		{input: "+f0c3e1a00ff7b21", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePerceptualHash(tt.input)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParsePerceptualHash(%q) = %x, %v", tt.input, got, err)
+			}
+		})
+	}
+}
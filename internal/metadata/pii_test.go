@@ -0,0 +1,85 @@
+package metadata
+
+import "testing"
+
+func TestScanPII(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		expectedCounts map[string]int
+	}{
+		{
+			name:           "no PII",
+			content:        "The quick brown fox jumps over the lazy dog.",
+			expectedCounts: map[string]int{},
+		},
+		{
+			name:           "email addresses",
+			content:        "Contact alice@example.com or bob.smith+tag@mail.example.org",
+			expectedCounts: map[string]int{PIIEmail: 2},
+		},
+		{
+			name:           "valid credit card passes Luhn",
+			content:        "card: 4111 1111 1111 1111",
+			expectedCounts: map[string]int{PIICreditCard: 1},
+		},
+		{
+			name:           "invalid credit card fails Luhn",
+			content:        "card: 4111 1111 1111 1112",
+			expectedCounts: map[string]int{},
+		},
+		{
+			name:           "US SSN",
+			content:        "ssn,123-45-6789\nbad,000-12-3456",
+			expectedCounts: map[string]int{PIIUSSSN: 1},
+		},
+		{
+			name:           "UK national insurance number",
+			content:        "NI number: AB 12 34 56 C",
+			expectedCounts: map[string]int{PIIUKNINO: 1},
+		},
+		{
+			name:           "valid IBAN",
+			content:        `{"iban": "GB82 WEST 1234 5698 7654 32"}`,
+			expectedCounts: map[string]int{PIIIBAN: 1},
+		},
+		{
+			name:           "invalid IBAN checksum",
+			content:        `{"iban": "GB00 WEST 1234 5698 7654 32"}`,
+			expectedCounts: map[string]int{},
+		},
+		{
+			name:           "phone numbers",
+			content:        "Call (555) 123-4567 or +442071838750",
+			expectedCounts: map[string]int{PIIPhone: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := scanPII(tt.content)
+
+			if report == nil {
+				t.Fatal("scanPII returned nil")
+			}
+
+			if report.Detected != (len(tt.expectedCounts) > 0) {
+				t.Errorf("Detected = %v, want %v", report.Detected, len(tt.expectedCounts) > 0)
+			}
+
+			for kind, want := range tt.expectedCounts {
+				if got := report.Counts[kind]; got != want {
+					t.Errorf("Counts[%s] = %d, want %d (all counts: %v)", kind, got, want, report.Counts)
+				}
+			}
+
+			total := 0
+			for _, c := range tt.expectedCounts {
+				total += c
+			}
+			if report.TotalMatches != total {
+				t.Errorf("TotalMatches = %d, want %d (all counts: %v)", report.TotalMatches, total, report.Counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-meta/internal/tempfiles"
+
+	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/types"
+)
+
+// headSize is how much of the content is buffered for type detection and
+// text analysis. Content no larger than this is never spilled to disk.
+const headSize = 1 << 20
+
+// sniffSize is how much of the head magic-byte detection looks at
+const sniffSize = 261
+
+// FileInfo describes content passed to ExtractStream
+type FileInfo struct {
+	// Filename is the client's name for the file, used for its extension
+	Filename string
+
+	// ContentType is the client's declared MIME type, used when the type
+	// can't be detected from the content
+	ContentType string
+
+	// Size is the content length in bytes, or -1 if unknown
+	Size int64
+}
+
+// randomAccess is content extractors can seek within
+type randomAccess interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// ExtractStream extracts metadata reading r once. Checksums and entropy are
+// computed as the content streams past, and type detection and text analysis
+// use the first 1MB. The content is spilled to a temporary file only when an
+// enabled extractor needs to seek within it, such as image decoding or
+// archive inspection.
+//
+// Extraction stops with ctx's error once ctx is done. Parsers are aborted at
+// their next read, so a slow or hostile file can't outlive the deadline.
+func ExtractStream(ctx context.Context, r io.Reader, info FileInfo, opts Options) (*Result, error) {
+	return extract(ctx, r, nil, info, opts)
+}
+
+// extract runs the extraction pipeline over r. src, if not nil, is a
+// seekable view of the same content, in which case nothing is spilled.
+func extract(ctx context.Context, r io.Reader, src randomAccess, info FileInfo, opts Options) (*Result, error) {
+	r = contextReader{ctx, r}
+	if src != nil {
+		src = contextSource{ctx, src}
+	}
+
+	// Buffer the head for type detection and text analysis
+	head := make([]byte, headSize)
+	n, err := io.ReadFull(r, head)
+	complete := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !complete {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	head = head[:n]
+
+	// Detect file type via magic bytes, falling back to the declared type
+	declared := info.ContentType
+	kind, mime := sniff(head, declared)
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(info.Filename)), ".")
+
+	// Content that fits in the head needs no spill, and content the
+	// extractors only stream past needs no random access
+	if src == nil && complete {
+		src = contextSource{ctx, bytes.NewReader(head)}
+	}
+	var spill *tempfiles.File
+	if src == nil && needsRandomAccess(kind, mime, info.Size, opts) {
+		spill, err = opts.TempFiles.Create("file-meta-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spill file: %w", err)
+		}
+		defer spill.Close()
+	}
+
+	// Hash, measure entropy and spill in one pass
+	hasher := sha256.New()
+	writers := []io.Writer{hasher}
+
+	var entropy *entropyAnalyzer
+	if opts.runs(ModuleSecurity) {
+		entropy = newEntropyAnalyzer()
+		writers = append(writers, entropy)
+	}
+
+	var tlsh *tlshHasher
+	if opts.TLSH {
+		tlsh = newTLSHHasher()
+		writers = append(writers, tlsh)
+	}
+
+	var fuzzy *ssdeepHasher
+	if opts.runs(ModuleSSDeep) && info.Size >= 0 {
+		fuzzy = newSSDeepHasher(info.Size)
+		writers = append(writers, fuzzy)
+	}
+
+	if spill != nil {
+		writers = append(writers, spill)
+	}
+
+	w := io.MultiWriter(writers...)
+	if _, err := w.Write(head); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	rest, err := io.Copy(w, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	size := int64(n) + rest
+	if info.Size >= 0 && size != info.Size {
+		return nil, fmt.Errorf("read %d bytes, expected %d", size, info.Size)
+	}
+
+	if spill != nil {
+		src = contextSource{ctx, spill}
+	}
+
+	// Fuzzy hash for clustering near-identical files
+	var ssdeep string
+	if fuzzy != nil {
+		ssdeep = fuzzy.Sum()
+	} else if opts.runs(ModuleSSDeep) {
+		if ssdeep, err = computeSSDeep(src, size); err != nil {
+			return nil, fmt.Errorf("failed to compute ssdeep hash: %w", err)
+		}
+	}
+
+	opts.progress(StageHashed)
+
+	result := &Result{
+		Filename:  info.Filename,
+		SizeBytes: size,
+		MimeType:  mime,
+		SHA256:    hex.EncodeToString(hasher.Sum(nil)),
+		SSDeep:    ssdeep,
+		Extension: ext,
+	}
+	if tlsh != nil {
+		result.TLSH = tlsh.Sum()
+	}
+
+	// Label timings with the detected type only, never the client's claim
+	var detected string
+	if kind != filetype.Unknown {
+		detected = mime
+	}
+
+	// Keep the spoofing signal when the detected type overrides the claim
+	security := &SecurityMetadata{}
+	if kind != filetype.Unknown && opts.runs(ModuleSecurity) {
+		start := time.Now()
+		inspectContainer(security, src, size, kind, declared, mime, ext, opts)
+		opts.timing(ModuleSecurity, detected, start)
+		opts.progress(StageInspected)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if entropy != nil {
+		security.Entropy = entropy.Result(mime)
+	}
+
+	// Extract type-specific metadata
+	if strings.HasPrefix(mime, "image/") {
+		if opts.runs(ModuleImage) {
+			var header randomAccess = src
+			if header == nil {
+				header = contextSource{ctx, bytes.NewReader(head)}
+			}
+			start := time.Now()
+			var warnings []Warning
+			result.Image, warnings = extractImageMetadata(header, mime, info.Filename, opts)
+			result.Warnings = append(result.Warnings, warnings...)
+			opts.timing(ModuleImage, detected, start)
+			opts.progress(StageImageDecoded)
+		}
+	} else if strings.HasPrefix(mime, "audio/") {
+		if opts.runs(ModuleAudio) {
+			start := time.Now()
+			var warnings []Warning
+			result.Audio, warnings = extractAudioMetadata(src)
+			result.Warnings = append(result.Warnings, warnings...)
+			opts.timing(ModuleAudio, detected, start)
+			opts.progress(StageAudioDecoded)
+		}
+	} else if strings.HasPrefix(mime, "video/") {
+		if opts.runs(ModuleVideo) {
+			start := time.Now()
+			result.Video = extractVideoMetadata(src)
+			opts.timing(ModuleVideo, detected, start)
+			opts.progress(StageVideoDecoded)
+		}
+	} else if opts.runs(ModuleDocument) {
+		// Try to extract document metadata for text/code files or unknown types
+		start := time.Now()
+		sample := head
+		if limit := opts.Memory.withDefaults().MaxDocumentBytes; len(sample) > limit {
+			sample = sample[:limit]
+		}
+		doc, content := extractDocumentMetadata(sample, info.Filename, opts)
+		if doc != nil && (strings.HasPrefix(mime, "text/") || doc.Language != "Unknown") {
+			result.Document = doc
+			if size > int64(len(sample)) {
+				result.Warnings = append(result.Warnings, documentLimitWarning(size, len(sample)))
+			}
+			if opts.runs(ModuleSecurity) {
+				security.Secrets = scanSecrets(content)
+			}
+		}
+		opts.timing(ModuleDocument, detected, start)
+		opts.progress(StageDocumentAnalyzed)
+	}
+
+	// Extractors treat a failed read as missing metadata, so a result
+	// finished after the deadline may be incomplete
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !security.isEmpty() {
+		result.Security = security
+	}
+
+	return result, nil
+}
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// contextSource fails reads once its context is done
+type contextSource struct {
+	ctx context.Context
+	randomAccess
+}
+
+func (s contextSource) Read(p []byte) (int, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.randomAccess.Read(p)
+}
+
+func (s contextSource) ReadAt(p []byte, off int64) (int, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.randomAccess.ReadAt(p, off)
+}
+
+// sniff detects head's type from its magic bytes. The MIME type is
+// declared when the content isn't recognized.
+func sniff(head []byte, declared string) (types.Type, string) {
+	kind, _ := filetype.Match(head[:min(len(head), sniffSize)])
+	if kind == filetype.Unknown {
+		return kind, declared
+	}
+	return kind, kind.MIME.Value
+}
+
+// DetectMIME returns the MIME type extraction reports for the content r
+// holds, detected from its magic bytes or else the declared type
+func DetectMIME(r io.ReaderAt, declared string) (string, error) {
+	head := make([]byte, sniffSize)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	_, mime := sniff(head[:n], declared)
+	return mime, nil
+}
+
+// needsRandomAccess reports whether an enabled extractor has to seek within
+// content of the detected type. Everything else works from the streamed
+// checksums and the buffered head.
+func needsRandomAccess(kind types.Type, mime string, size int64, opts Options) bool {
+	switch {
+	case opts.runs(ModuleSSDeep) && size < 0:
+		// The block size depends on the length
+		return true
+	case kind != filetype.Unknown && opts.runs(ModuleSecurity):
+		// Container checks read footers, directories and embedded streams
+		return true
+	case strings.HasPrefix(mime, "image/"):
+		// Dimensions and EXIF come from the header; only pixel analysis
+		// and the perceptual hash decode the whole image
+		return opts.runs(ModuleImage) && (opts.runs(ModuleScreenshotDetection) || opts.runs(ModulePerceptualHash))
+	case strings.HasPrefix(mime, "audio/"):
+		// ID3v1 tags sit at the end of the file
+		return opts.runs(ModuleAudio)
+	}
+	return false
+}
+
+// inspectContainer runs the security checks that parse the file's structure
+func inspectContainer(security *SecurityMetadata, src randomAccess, size int64, kind types.Type, declared, mime, ext string, opts Options) {
+	security.MIMECheck = checkMIMEMismatch(kind.MIME.Value, declared, ext)
+
+	// Look for embedded formats and appended data across the whole file
+	polyglot := detectPolyglot(src, size, kind.Extension, mime)
+	if polyglot.LikelyPolyglot || polyglot.TrailingDataBytes > 0 {
+		security.Polyglot = polyglot
+	}
+
+	// Password-protected content can't be inspected any further
+	security.Encryption = detectEncryption(src, size, kind.Extension)
+
+	// VBA projects in OOXML and legacy OLE Office documents
+	security.Macros = detectMacros(src, size, kind.Extension, ext)
+
+	// Flag decompression bombs from headers and bounded reads only
+	if check := checkDecompression(src, size, kind.Extension, mime, opts.Decompression); check != nil && check.SuspectedBomb {
+		security.Decompression = check
+	}
+}
@@ -0,0 +1,243 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"file-meta/internal/tempfiles"
+)
+
+func TestExtractStream(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+
+	// Noise doesn't compress, so the PNG is larger than the buffered head
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rng.Intn(256))
+	}
+	for x := 0; x < 800; x++ {
+		img.Set(x, 0, color.Black)
+	}
+	var noise bytes.Buffer
+	png.Encode(&noise, img)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("notes.txt")
+	w.Write([]byte(strings.Repeat("hello ", 1000)))
+	zw.Close()
+
+	prose := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 25000)
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		skip     map[string]bool
+		spills   bool
+	}{
+		{name: "small text", filename: "hello.txt", content: []byte("Hello, World!\n")},
+		{name: "large text streams", filename: "prose.txt", content: []byte(prose)},
+		{name: "large image spills", filename: "noise.png", content: noise.Bytes(), spills: true},
+		{
+			name:     "large image without pixel analysis streams",
+			filename: "noise.png",
+			content:  noise.Bytes(),
+			skip:     map[string]bool{ModuleSecurity: true, ModuleScreenshotDetection: true, ModulePerceptualHash: true},
+		},
+		{name: "small archive", filename: "notes.zip", content: archive.Bytes()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.spills && len(tt.content) <= headSize {
+				t.Fatalf("content is %d bytes, want more than the %d byte head", len(tt.content), headSize)
+			}
+			opts := Options{TLSH: true, Skip: tt.skip}
+
+			want, err := ExtractWithOptions(context.Background(), memoryFile{bytes.NewReader(tt.content)}, fileHeader(tt.filename), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A one byte quota fails any extraction that spills
+			opts.TempFiles, _ = tempfiles.New(t.TempDir(), 1)
+			for _, size := range []int64{int64(len(tt.content)), -1} {
+				info := FileInfo{Filename: tt.filename, ContentType: "application/octet-stream", Size: size}
+				got, err := ExtractStream(context.Background(), iotest.HalfReader(bytes.NewReader(tt.content)), info, opts)
+
+				spills := tt.spills || (size < 0 && len(tt.content) > headSize)
+				if spills {
+					if err == nil {
+						t.Fatalf("ExtractStream(size %d) should have spilled", size)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("ExtractStream(size %d) error = %v", size, err)
+				}
+				assertSameResult(t, got, want)
+			}
+
+			if !tt.spills {
+				return
+			}
+			dir := t.TempDir()
+			opts.TempFiles, _ = tempfiles.New(dir, 0)
+			got, err := ExtractStream(context.Background(), bytes.NewReader(tt.content), FileInfo{Filename: tt.filename, Size: int64(len(tt.content))}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameResult(t, got, want)
+
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("spill file left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestExtractProgress(t *testing.T) {
+	var pic bytes.Buffer
+	png.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 32, 32)))
+
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		want     []string
+		timings  []string
+	}{
+		{name: "text", filename: "notes.txt", content: []byte("Hello, World!\n"), want: []string{StageHashed, StageDocumentAnalyzed}, timings: []string{"document "}},
+		{name: "image", filename: "pic.png", content: pic.Bytes(), want: []string{StageHashed, StageInspected, StageImageDecoded}, timings: []string{"security image/png", "image image/png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stages, timings []string
+			opts := Options{
+				Progress: func(stage string) { stages = append(stages, stage) },
+				Timing: func(module, mimeType string, elapsed time.Duration) {
+					timings = append(timings, module+" "+mimeType)
+				},
+			}
+			if _, err := ExtractWithOptions(context.Background(), memoryFile{bytes.NewReader(tt.content)}, fileHeader(tt.filename), opts); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(stages, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("stages = %v, want %v", stages, tt.want)
+			}
+			if strings.Join(timings, ",") != strings.Join(tt.timings, ",") {
+				t.Errorf("timings = %q, want %q", timings, tt.timings)
+			}
+		})
+	}
+}
+
+func TestExtractStreamSizeMismatch(t *testing.T) {
+	_, err := ExtractStream(context.Background(), strings.NewReader("Hello"), FileInfo{Filename: "hello.txt", Size: 10}, Options{})
+	if err == nil {
+		t.Error("ExtractStream() should fail when the content is shorter than Size")
+	}
+}
+
+func TestExtractCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ExtractStream(ctx, strings.NewReader("Hello"), FileInfo{Filename: "hello.txt", Size: 5}, Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExtractStream() error = %v, want context.Canceled", err)
+	}
+
+	_, err = ExtractWithOptions(ctx, memoryFile{bytes.NewReader([]byte("Hello"))}, fileHeader("hello.txt"), Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExtractWithOptions() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestExtractStreamDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// A reader that never finishes stands in for a slow upload or parser
+	slow := io.MultiReader(strings.NewReader("Hello"), slowReader{})
+	_, err := ExtractStream(ctx, slow, FileInfo{Filename: "hello.txt", Size: -1}, Options{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExtractStream() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// slowReader returns a byte every few milliseconds, forever
+type slowReader struct{}
+
+func (slowReader) Read(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	p[0] = 'a'
+	return 1, nil
+}
+
+func assertSameResult(t *testing.T, got, want *Result) {
+	t.Helper()
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("ExtractStream() = %s\nwant %s", gotJSON, wantJSON)
+	}
+}
+
+// memoryFile serves a byte slice as a multipart.File
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+func fileHeader(filename string) *multipart.FileHeader {
+	return &multipart.FileHeader{
+		Filename: filename,
+		Header:   textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}},
+	}
+}
+
+func TestDetectMIME(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		content  []byte
+		declared string
+		want     string
+	}{
+		{"magic bytes win", encoded.Bytes(), "text/plain", "image/png"},
+		{"unrecognized content", []byte("hello"), "text/plain", "text/plain"},
+		{"empty content", nil, "application/octet-stream", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectMIME(bytes.NewReader(tt.content), tt.declared)
+			if err != nil || got != tt.want {
+				t.Errorf("DetectMIME() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
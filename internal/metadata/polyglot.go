@@ -0,0 +1,242 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PolyglotDetection reports content that is valid in more than one file
+// format, or data appended after the end of the primary format
+type PolyglotDetection struct {
+	LikelyPolyglot    bool             `json:"likely_polyglot"`
+	PrimaryFormat     string           `json:"primary_format,omitempty"`
+	EmbeddedFormats   []EmbeddedFormat `json:"embedded_formats,omitempty"`
+	TrailingDataBytes int64            `json:"trailing_data_bytes,omitempty"`
+	Indicators        []string         `json:"indicators,omitempty"`
+}
+
+// EmbeddedFormat is a secondary format signature found inside the file
+type EmbeddedFormat struct {
+	Format string `json:"format"`
+	Offset int64  `json:"offset"`
+}
+
+// embeddedSignature is a magic sequence that should not appear inside a file
+// of a different format
+type embeddedSignature struct {
+	format string
+	magic  []byte
+	// mediaOnly restricts the signature to image/audio/video primaries,
+	// since text formats such as HTML legitimately contain markup
+	mediaOnly bool
+}
+
+var embeddedSignatures = []embeddedSignature{
+	{format: "zip", magic: []byte("PK\x03\x04")},
+	{format: "zip", magic: []byte("PK\x05\x06")},
+	{format: "rar", magic: []byte("Rar!\x1a\x07")},
+	{format: "7z", magic: []byte("7z\xbc\xaf\x27\x1c")},
+	{format: "gz", magic: []byte("\x1f\x8b\x08\x00")},
+	{format: "pdf", magic: []byte("%PDF-")},
+	{format: "elf", magic: []byte("\x7fELF")},
+	{format: "exe", magic: []byte("This program cannot be run in DOS mode")},
+	{format: "html", magic: []byte("<script"), mediaOnly: true},
+	{format: "html", magic: []byte("<SCRIPT"), mediaOnly: true},
+	{format: "php", magic: []byte("<?php"), mediaOnly: true},
+}
+
+// End-of-format markers used to measure appended trailing data
+var (
+	pngEnd  = []byte("IEND\xae\x42\x60\x82")
+	jpegEnd = []byte{0xFF, 0xD9}
+	pdfEnd  = []byte("%%EOF")
+	zipEOCD = []byte("PK\x05\x06")
+)
+
+// zipContainerFormats are detected formats stored as ZIP archives, whose
+// internal ZIP headers must not be reported as an embedded archive
+var zipContainerFormats = map[string]bool{
+	"zip":  true,
+	"docx": true,
+	"xlsx": true,
+	"pptx": true,
+	"epub": true,
+}
+
+// polyglotChunkSize is the read size when scanning for signatures
+const polyglotChunkSize = 1 << 20
+
+// detectPolyglot scans the whole file for embedded format signatures and
+// data appended after the end of the primary format. primary is the
+// extension-style name of the detected format (e.g. "jpg", "pdf", "zip").
+func detectPolyglot(r io.ReaderAt, size int64, primary, mimeType string) *PolyglotDetection {
+	detection := &PolyglotDetection{PrimaryFormat: primary}
+	media := isMediaMIME(mimeType)
+	if zipContainerFormats[primary] {
+		primary = "zip"
+	}
+
+	var endMarker []byte
+	switch primary {
+	case "png":
+		endMarker = pngEnd
+	case "jpg":
+		endMarker = jpegEnd
+	case "pdf":
+		endMarker = pdfEnd
+	case "zip":
+		endMarker = zipEOCD
+	}
+
+	// Chunks overlap by the longest signature so matches spanning a chunk
+	// boundary are still found
+	overlap := 0
+	for _, sig := range embeddedSignatures {
+		if len(sig.magic) > overlap {
+			overlap = len(sig.magic)
+		}
+	}
+	buf := make([]byte, polyglotChunkSize+overlap)
+
+	// First offset of each foreign format, and last offset of the primary
+	// format's end marker
+	firstSeen := make(map[string]int64)
+	firstForeign := int64(-1)
+	lastEnd := int64(-1)
+
+	for base := int64(0); base < size; base += polyglotChunkSize {
+		n, err := r.ReadAt(buf, base)
+		if n == 0 {
+			break
+		}
+		chunk := buf[:n]
+
+		for _, sig := range embeddedSignatures {
+			if sig.format == primary || (sig.mediaOnly && !media) {
+				continue
+			}
+			if _, seen := firstSeen[sig.format]; seen {
+				continue
+			}
+			if offset := indexFrom(chunk, sig.magic, base); offset > 0 {
+				firstSeen[sig.format] = offset
+				if firstForeign < 0 || offset < firstForeign {
+					firstForeign = offset
+				}
+			}
+		}
+
+		if endMarker != nil {
+			for from := 0; ; {
+				idx := bytes.Index(chunk[from:], endMarker)
+				if idx < 0 {
+					break
+				}
+				offset := base + int64(from+idx)
+				from += idx + 1
+				// For JPEG, stop moving the end once foreign data starts so
+				// 0xFFD9 bytes inside an appended archive aren't taken as EOI
+				if primary == "jpg" && firstForeign >= 0 && offset > firstForeign {
+					break
+				}
+				lastEnd = offset
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	// Report embedded formats ordered by position
+	for format, offset := range firstSeen {
+		detection.EmbeddedFormats = append(detection.EmbeddedFormats, EmbeddedFormat{Format: format, Offset: offset})
+		detection.Indicators = append(detection.Indicators, "embedded_"+format)
+	}
+	sort.Slice(detection.EmbeddedFormats, func(i, j int) bool {
+		return detection.EmbeddedFormats[i].Offset < detection.EmbeddedFormats[j].Offset
+	})
+	sort.Strings(detection.Indicators)
+
+	// Measure data after the primary format's end marker
+	if end := formatEnd(r, size, primary, lastEnd); end > 0 && end < size {
+		detection.TrailingDataBytes = size - end
+	} else if end < 0 && firstForeign > 0 {
+		// No reliable end marker; treat everything from the first foreign
+		// signature as appended
+		detection.TrailingDataBytes = size - firstForeign
+	}
+	if detection.TrailingDataBytes > 0 {
+		detection.Indicators = append(detection.Indicators, "trailing_data")
+	}
+
+	detection.LikelyPolyglot = len(detection.EmbeddedFormats) > 0
+	return detection
+}
+
+// indexFrom returns the absolute offset of the first occurrence of magic in
+// chunk that isn't at file offset 0, or -1 if there is none
+func indexFrom(chunk, magic []byte, base int64) int64 {
+	for from := 0; from < len(chunk); {
+		idx := bytes.Index(chunk[from:], magic)
+		if idx < 0 {
+			return -1
+		}
+		if offset := base + int64(from+idx); offset > 0 {
+			return offset
+		}
+		from += idx + 1
+	}
+	return -1
+}
+
+// formatEnd returns the offset just past the end of the primary format
+// given the position of its last end marker, or -1 if unknown
+func formatEnd(r io.ReaderAt, size int64, primary string, lastEnd int64) int64 {
+	if lastEnd < 0 {
+		return -1
+	}
+
+	switch primary {
+	case "png":
+		return lastEnd + int64(len(pngEnd))
+	case "jpg":
+		return lastEnd + int64(len(jpegEnd))
+	case "pdf":
+		// PDF writers commonly add a trailing newline after %%EOF
+		end := lastEnd + int64(len(pdfEnd))
+		tail := make([]byte, 2)
+		n, _ := r.ReadAt(tail, end)
+		for _, b := range tail[:n] {
+			if b != '\r' && b != '\n' {
+				break
+			}
+			end++
+		}
+		return end
+	case "zip":
+		// End of central directory record: 22 bytes plus a variable comment
+		record := make([]byte, 22)
+		if n, _ := r.ReadAt(record, lastEnd); n < len(record) {
+			return -1
+		}
+		commentLen := int64(binary.LittleEndian.Uint16(record[20:22]))
+		end := lastEnd + 22 + commentLen
+		if end > size {
+			return -1
+		}
+		return end
+	}
+
+	return -1
+}
+
+// isMediaMIME reports whether a MIME type is an image, audio or video type
+func isMediaMIME(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") ||
+		strings.HasPrefix(mimeType, "audio/") ||
+		strings.HasPrefix(mimeType, "video/")
+}
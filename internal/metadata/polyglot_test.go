@@ -0,0 +1,148 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestDetectPolyglot(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var jpegBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("payload.class")
+	w.Write([]byte("malicious payload"))
+	zw.Close()
+
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+
+	tests := []struct {
+		name             string
+		data             []byte
+		primary          string
+		mimeType         string
+		expectedPolyglot bool
+		expectedEmbedded []string
+		expectTrailing   bool
+	}{
+		{
+			name:     "clean PNG",
+			data:     pngBuf.Bytes(),
+			primary:  "png",
+			mimeType: "image/png",
+		},
+		{
+			name:     "clean JPEG",
+			data:     jpegBuf.Bytes(),
+			primary:  "jpg",
+			mimeType: "image/jpeg",
+		},
+		{
+			name:     "clean PDF with trailing newline",
+			data:     pdf,
+			primary:  "pdf",
+			mimeType: "application/pdf",
+		},
+		{
+			name:     "clean ZIP",
+			data:     zipBuf.Bytes(),
+			primary:  "zip",
+			mimeType: "application/zip",
+		},
+		{
+			name:             "PNG with appended ZIP",
+			data:             concat(pngBuf.Bytes(), zipBuf.Bytes()),
+			primary:          "png",
+			mimeType:         "image/png",
+			expectedPolyglot: true,
+			expectedEmbedded: []string{"zip"},
+			expectTrailing:   true,
+		},
+		{
+			name:             "JPEG with appended ZIP",
+			data:             concat(jpegBuf.Bytes(), zipBuf.Bytes()),
+			primary:          "jpg",
+			mimeType:         "image/jpeg",
+			expectedPolyglot: true,
+			expectedEmbedded: []string{"zip"},
+			expectTrailing:   true,
+		},
+		{
+			name:           "JPEG with appended text",
+			data:           concat(jpegBuf.Bytes(), []byte("hidden message")),
+			primary:        "jpg",
+			mimeType:       "image/jpeg",
+			expectTrailing: true,
+		},
+		{
+			name:             "GIF with appended JAR (GIFAR)",
+			data:             concat([]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), zipBuf.Bytes()),
+			primary:          "gif",
+			mimeType:         "image/gif",
+			expectedPolyglot: true,
+			expectedEmbedded: []string{"zip"},
+			expectTrailing:   true,
+		},
+		{
+			name:             "PDF and ZIP polyglot",
+			data:             concat(pdf, zipBuf.Bytes()),
+			primary:          "pdf",
+			mimeType:         "application/pdf",
+			expectedPolyglot: true,
+			expectedEmbedded: []string{"zip"},
+			expectTrailing:   true,
+		},
+		{
+			name:             "GIF with embedded script",
+			data:             []byte("GIF89a/*<script>alert(1)</script>*/;"),
+			primary:          "gif",
+			mimeType:         "image/gif",
+			expectedPolyglot: true,
+			expectedEmbedded: []string{"html"},
+			expectTrailing:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detection := detectPolyglot(bytes.NewReader(tt.data), int64(len(tt.data)), tt.primary, tt.mimeType)
+
+			if detection.LikelyPolyglot != tt.expectedPolyglot {
+				t.Errorf("LikelyPolyglot = %v, want %v (%+v)", detection.LikelyPolyglot, tt.expectedPolyglot, detection)
+			}
+
+			if len(detection.EmbeddedFormats) != len(tt.expectedEmbedded) {
+				t.Fatalf("EmbeddedFormats = %+v, want %v", detection.EmbeddedFormats, tt.expectedEmbedded)
+			}
+			for i, format := range tt.expectedEmbedded {
+				if detection.EmbeddedFormats[i].Format != format {
+					t.Errorf("EmbeddedFormats[%d] = %v, want %v", i, detection.EmbeddedFormats[i].Format, format)
+				}
+			}
+
+			if (detection.TrailingDataBytes > 0) != tt.expectTrailing {
+				t.Errorf("TrailingDataBytes = %d, want trailing data: %v", detection.TrailingDataBytes, tt.expectTrailing)
+			}
+		})
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
@@ -0,0 +1,152 @@
+package metadata
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ReadabilityMetrics contains prose readability and complexity scores
+type ReadabilityMetrics struct {
+	SentenceCount       int     `json:"sentence_count"`
+	AvgSentenceLength   float64 `json:"avg_sentence_length"`    // words per sentence
+	AvgSyllablesPerWord float64 `json:"avg_syllables_per_word"` // syllables per word
+	FleschReadingEase   float64 `json:"flesch_reading_ease"`    // 0-100, higher is easier
+	FleschKincaidGrade  float64 `json:"flesch_kincaid_grade"`   // US school grade level
+	VocabularyRichness  float64 `json:"vocabulary_richness"`    // unique words / total words
+	UniqueWordCount     int     `json:"unique_word_count"`
+	LongWordPercentage  float64 `json:"long_word_percentage"` // words with 3+ syllables
+}
+
+// proseLanguages lists document languages that contain natural-language text
+// rather than source code, for which readability scores are meaningful
+var proseLanguages = map[string]bool{
+	"Plain Text": true,
+	"Markdown":   true,
+	"Unknown":    true,
+}
+
+// analyzeReadability computes sentence, word and vocabulary statistics for
+// natural-language text. Returns nil when there are no words to score.
+func analyzeReadability(content string) *ReadabilityMetrics {
+	sentences := splitSentences(content)
+
+	var words []string
+	for _, sentence := range sentences {
+		words = append(words, tokenizeWords(sentence)...)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	syllables := 0
+	longWords := 0
+	unique := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		s := countSyllables(word)
+		syllables += s
+		if s >= 3 {
+			longWords++
+		}
+		unique[word] = struct{}{}
+	}
+
+	wordCount := float64(len(words))
+	sentenceCount := float64(len(sentences))
+	wordsPerSentence := wordCount / sentenceCount
+	syllablesPerWord := float64(syllables) / wordCount
+
+	return &ReadabilityMetrics{
+		SentenceCount:       len(sentences),
+		AvgSentenceLength:   round2(wordsPerSentence),
+		AvgSyllablesPerWord: round2(syllablesPerWord),
+		FleschReadingEase:   round2(206.835 - 1.015*wordsPerSentence - 84.6*syllablesPerWord),
+		FleschKincaidGrade:  round2(0.39*wordsPerSentence + 11.8*syllablesPerWord - 15.59),
+		VocabularyRichness:  round2(float64(len(unique)) / wordCount),
+		UniqueWordCount:     len(unique),
+		LongWordPercentage:  round2(float64(longWords) / wordCount * 100),
+	}
+}
+
+// splitSentences splits text on terminal punctuation and blank lines,
+// dropping fragments that contain no letters
+func splitSentences(content string) []string {
+	var sentences []string
+	var current strings.Builder
+
+	flush := func() {
+		s := strings.TrimSpace(current.String())
+		current.Reset()
+		if strings.IndexFunc(s, unicode.IsLetter) >= 0 {
+			sentences = append(sentences, s)
+		}
+	}
+
+	runes := []rune(content)
+	for i, r := range runes {
+		current.WriteRune(r)
+		switch r {
+		case '.', '!', '?':
+			// Only treat punctuation as a boundary when followed by
+			// whitespace or end of input, so "3.14" and "e.g." mid-word
+			// don't split
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				flush()
+			}
+		case '\n':
+			// Blank lines end paragraphs (and headings/list items without
+			// punctuation)
+			if i+1 < len(runes) && runes[i+1] == '\n' {
+				flush()
+			}
+		}
+	}
+	flush()
+
+	return sentences
+}
+
+// tokenizeWords returns lowercase words made of letters, digits and
+// apostrophes
+func tokenizeWords(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	words := make([]string, 0, len(fields))
+	for _, f := range fields {
+		f = strings.Trim(f, "'")
+		if f != "" && strings.IndexFunc(f, unicode.IsLetter) >= 0 {
+			words = append(words, strings.ToLower(f))
+		}
+	}
+	return words
+}
+
+// countSyllables estimates English syllables by counting vowel groups, with
+// the usual adjustment for a silent trailing "e"
+func countSyllables(word string) int {
+	count := 0
+	prevVowel := false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouy", r)
+		if vowel && !prevVowel {
+			count++
+		}
+		prevVowel = vowel
+	}
+
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && count > 1 {
+		count--
+	}
+
+	if count == 0 {
+		count = 1
+	}
+	return count
+}
+
+// round2 rounds a float to two decimal places for stable JSON output
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,94 @@
+package metadata
+
+import "testing"
+
+func TestAnalyzeReadability(t *testing.T) {
+	tests := []struct {
+		name              string
+		content           string
+		expectNil         bool
+		expectedSentences int
+		expectedUnique    int
+		easierThan        float64 // minimum Flesch reading ease
+	}{
+		{
+			name:      "empty content",
+			content:   "",
+			expectNil: true,
+		},
+		{
+			name:      "punctuation only",
+			content:   "... !!! ???",
+			expectNil: true,
+		},
+		{
+			name:              "simple sentences",
+			content:           "The cat sat. The dog ran! Did the cat see the dog?",
+			expectedSentences: 3,
+			expectedUnique:    7,
+			easierThan:        90,
+		},
+		{
+			name:              "decimal numbers do not split sentences",
+			content:           "Pi is about 3.14 in value. That is all.",
+			expectedSentences: 2,
+			expectedUnique:    7,
+		},
+		{
+			name:              "blank lines end paragraphs",
+			content:           "# Heading\n\nA short paragraph here.",
+			expectedSentences: 2,
+			expectedUnique:    5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := analyzeReadability(tt.content)
+
+			if tt.expectNil {
+				if metrics != nil {
+					t.Errorf("analyzeReadability() = %+v, want nil", metrics)
+				}
+				return
+			}
+
+			if metrics == nil {
+				t.Fatal("analyzeReadability() returned nil")
+			}
+
+			if metrics.SentenceCount != tt.expectedSentences {
+				t.Errorf("SentenceCount = %v, want %v", metrics.SentenceCount, tt.expectedSentences)
+			}
+
+			if metrics.UniqueWordCount != tt.expectedUnique {
+				t.Errorf("UniqueWordCount = %v, want %v", metrics.UniqueWordCount, tt.expectedUnique)
+			}
+
+			if metrics.VocabularyRichness <= 0 || metrics.VocabularyRichness > 1 {
+				t.Errorf("VocabularyRichness = %v, want value in (0, 1]", metrics.VocabularyRichness)
+			}
+
+			if tt.easierThan > 0 && metrics.FleschReadingEase < tt.easierThan {
+				t.Errorf("FleschReadingEase = %v, want >= %v", metrics.FleschReadingEase, tt.easierThan)
+			}
+		})
+	}
+}
+
+func TestCountSyllables(t *testing.T) {
+	tests := map[string]int{
+		"cat":         1,
+		"table":       2,
+		"make":        1,
+		"readability": 5,
+		"rhythm":      1,
+		"beautiful":   3,
+	}
+
+	for word, want := range tests {
+		if got := countSyllables(word); got != want {
+			t.Errorf("countSyllables(%q) = %d, want %d", word, got, want)
+		}
+	}
+}
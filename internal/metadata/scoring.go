@@ -0,0 +1,117 @@
+package metadata
+
+import "math"
+
+// ReasonCode identifies a detection signal. Codes are stable API values
+// that clients can match on; human-readable text goes in Reasons.
+type ReasonCode string
+
+// AI-generated image signals
+const (
+	ReasonAISoftware            ReasonCode = "ai_software_detected"
+	ReasonNoCameraMetadata      ReasonCode = "no_camera_metadata"
+	ReasonNoCameraTechnical     ReasonCode = "no_camera_technical_data"
+	ReasonNoGPS                 ReasonCode = "no_gps_data"
+	ReasonNoEXIF                ReasonCode = "no_exif_data"
+	ReasonDateTimeWithoutCam    ReasonCode = "datetime_without_camera"
+	ReasonCameraMetadata        ReasonCode = "camera_metadata_present"
+	ReasonScreenshotDetected    ReasonCode = "screenshot_detected"
+	ReasonPossibleScreenshot    ReasonCode = "possible_screenshot"
+	ReasonClassifierAI          ReasonCode = "classifier_ai_generated"
+	ReasonClassifierAuthentic   ReasonCode = "classifier_authentic"
+	ReasonClassifierUnavailable ReasonCode = "classifier_unavailable"
+)
+
+// AI-generated text signals
+const (
+	ReasonAIDisclaimer      ReasonCode = "ai_disclaimer_detected"
+	ReasonLowBurstiness     ReasonCode = "low_burstiness"
+	ReasonLowPerplexity     ReasonCode = "low_perplexity"
+	ReasonBoilerplate       ReasonCode = "boilerplate_phrasing"
+	ReasonSomeBoilerplate   ReasonCode = "some_boilerplate_phrasing"
+	ReasonHumanLikeVariance ReasonCode = "human_like_variation"
+)
+
+// Screenshot signals
+const (
+	ReasonScreenshotFilename  ReasonCode = "filename_pattern_match"
+	ReasonFilenameScreenshot  ReasonCode = "filename_contains_screenshot"
+	ReasonScreenshotSoftware  ReasonCode = "screenshot_software_detected"
+	ReasonScreenResolution    ReasonCode = "common_screen_resolution"
+	ReasonScreenAspectRatio   ReasonCode = "screen_aspect_ratio"
+	ReasonScaledResolution    ReasonCode = "scaled_screen_resolution"
+	ReasonFlatColorRegions    ReasonCode = "flat_color_regions"
+	ReasonUniformStatusBar    ReasonCode = "uniform_status_bar"
+	ReasonRectangularEdges    ReasonCode = "rectangular_edges"
+	ReasonPhotographicContent ReasonCode = "photographic_content"
+)
+
+// Signal is one piece of evidence behind a detection. Weight is in
+// log-odds: positive weights argue for the detection, negative against.
+type Signal struct {
+	Code   ReasonCode `json:"code"`
+	Weight float64    `json:"weight"`
+}
+
+// scorer accumulates weighted signals on top of a prior (bias, in log-odds)
+// and turns them into a probability with the logistic function
+type scorer struct {
+	bias    float64
+	signals []Signal
+}
+
+func newScorer(bias float64) *scorer {
+	return &scorer{bias: bias}
+}
+
+// add records a signal
+func (s *scorer) add(code ReasonCode, weight float64) {
+	s.signals = append(s.signals, Signal{Code: code, Weight: weight})
+}
+
+// logOdds is the bias plus the sum of all signal weights
+func (s *scorer) logOdds() float64 {
+	total := s.bias
+	for _, signal := range s.signals {
+		total += signal.Weight
+	}
+	return total
+}
+
+// probability returns the detection probability in [0, 1]
+func (s *scorer) probability() float64 {
+	return round2(sigmoid(s.logOdds()))
+}
+
+// indicators returns the signal codes as strings, in the order added
+func (s *scorer) indicators() []string {
+	codes := make([]string, 0, len(s.signals))
+	for _, signal := range s.signals {
+		codes = append(codes, string(signal.Code))
+	}
+	return codes
+}
+
+// confidenceLabel summarizes how far a probability is from undecided. It is
+// kept for clients that predate Probability; new clients should threshold
+// on the probability itself.
+func confidenceLabel(p float64) string {
+	switch distance := math.Abs(p - 0.5); {
+	case distance >= 0.35:
+		return "high"
+	case distance >= 0.1:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// logit is the inverse of sigmoid, with p clamped away from 0 and 1
+func logit(p float64) float64 {
+	p = min(max(p, 0.001), 0.999)
+	return math.Log(p / (1 - p))
+}
@@ -0,0 +1,68 @@
+package metadata
+
+import "testing"
+
+func TestScorer(t *testing.T) {
+	tests := []struct {
+		name                string
+		bias                float64
+		weights             []float64
+		expectedProbability float64
+		expectedConfidence  string
+	}{
+		{
+			name:                "no evidence",
+			bias:                0,
+			expectedProbability: 0.5,
+			expectedConfidence:  "low",
+		},
+		{
+			name:                "prior only",
+			bias:                -2.0,
+			expectedProbability: 0.12,
+			expectedConfidence:  "high",
+		},
+		{
+			name:                "signals cancel",
+			bias:                -1.0,
+			weights:             []float64{2.4, -1.4},
+			expectedProbability: 0.5,
+			expectedConfidence:  "low",
+		},
+		{
+			name:                "moderate evidence",
+			bias:                -1.5,
+			weights:             []float64{2.4},
+			expectedProbability: 0.71,
+			expectedConfidence:  "medium",
+		},
+		{
+			name:                "conclusive signal",
+			bias:                -2.0,
+			weights:             []float64{6.0},
+			expectedProbability: 0.98,
+			expectedConfidence:  "high",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScorer(tt.bias)
+			for _, weight := range tt.weights {
+				s.add(ReasonNoEXIF, weight)
+			}
+
+			if p := s.probability(); p != tt.expectedProbability {
+				t.Errorf("probability() = %v, want %v", p, tt.expectedProbability)
+			}
+
+			if c := confidenceLabel(s.probability()); c != tt.expectedConfidence {
+				t.Errorf("confidenceLabel() = %v, want %v", c, tt.expectedConfidence)
+			}
+
+			if len(s.indicators()) != len(tt.weights) {
+				t.Errorf("indicators() = %v, want %d codes", s.indicators(), len(tt.weights))
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package metadata
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+// ScreenContentAnalysis holds pixel statistics that separate rendered
+// screen content from camera photos
+type ScreenContentAnalysis struct {
+	FlatFraction  float64 `json:"flat_fraction"`  // sampled pixels identical to their right and lower neighbours
+	StatusBar     bool    `json:"status_bar"`     // uniform rows along the top or bottom edge
+	StraightEdges int     `json:"straight_edges"` // long pixel-aligned horizontal and vertical edges
+}
+
+const (
+	// screenSampleSize is the target number of samples along each axis
+	screenSampleSize = 512
+
+	// Flat fractions above and below which content looks rendered or
+	// photographic. Sensor noise makes exact neighbour matches rare in photos.
+	flatScreenThreshold = 0.5
+	flatPhotoThreshold  = 0.05
+
+	// statusBarBand is the share of the image height checked at the top and
+	// bottom for status bars, menu bars and task bars
+	statusBarBand = 0.05
+
+	// minStraightEdges is how many long edges suggest window and UI chrome
+	minStraightEdges = 3
+
+	// edgeContrast is the summed RGB difference that counts as an edge
+	edgeContrast = 48
+)
+
+// decodePixels decodes the full image when it has at most maxPixels pixels,
+// and returns nil without an error otherwise
+func decodePixels(r io.ReadSeeker, width, height int, maxPixels int64) (image.Image, error) {
+	if width <= 0 || height <= 0 || int64(width)*int64(height) > maxPixels {
+		return nil, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// analyzeScreenContent samples the image for large flat-colour regions,
+// uniform status-bar rows and sharp rectangular edges
+func analyzeScreenContent(img image.Image) *ScreenContentAnalysis {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 16 || h < 16 {
+		return nil
+	}
+	at := rgbAccessor(img)
+
+	xStep := max(1, w/screenSampleSize)
+	yStep := max(1, h/screenSampleSize)
+
+	// Flat regions
+	flat, total := 0, 0
+	for y := 0; y+1 < h; y += yStep {
+		for x := 0; x+1 < w; x += xStep {
+			p := at(x, y)
+			if p == at(x+1, y) && p == at(x, y+1) {
+				flat++
+			}
+			total++
+		}
+	}
+
+	analysis := &ScreenContentAnalysis{FlatFraction: round2(float64(flat) / float64(total))}
+
+	// Status and task bars
+	band := max(2, int(float64(h)*statusBarBand))
+	analysis.StatusBar = uniformBand(at, w, 0, band, xStep) || uniformBand(at, w, h-band, h, xStep)
+
+	// Horizontal edges between every pair of rows, then vertical edges
+	// between every pair of columns
+	for y := 1; y < h; y++ {
+		if longEdge(w, xStep, func(i int) (uint32, uint32, uint32, uint32) {
+			return at(i, y-1), at(i, y), at(min(i+1, w-1), y-1), at(min(i+1, w-1), y)
+		}) {
+			analysis.StraightEdges++
+		}
+	}
+	for x := 1; x < w; x++ {
+		if longEdge(h, yStep, func(i int) (uint32, uint32, uint32, uint32) {
+			return at(x-1, i), at(x, i), at(x-1, min(i+1, h-1)), at(x, min(i+1, h-1))
+		}) {
+			analysis.StraightEdges++
+		}
+	}
+
+	return analysis
+}
+
+// uniformBand reports whether most rows in [y0, y1) are dominated by a
+// single colour
+func uniformBand(at func(x, y int) uint32, w, y0, y1, xStep int) bool {
+	uniform := 0
+	for y := y0; y < y1; y++ {
+		counts := make(map[uint32]int)
+		samples, top := 0, 0
+		for x := 0; x < w; x += xStep {
+			c := at(x, y)
+			counts[c]++
+			top = max(top, counts[c])
+			samples++
+		}
+		if float64(top) >= 0.8*float64(samples) {
+			uniform++
+		}
+	}
+	return float64(uniform) >= 0.6*float64(y1-y0)
+}
+
+// longEdge walks one boundary between two adjacent rows or columns of
+// length n. pixels returns the two pixels across the boundary at i and the
+// two at i+1. An edge is a run, covering at least 15% of the sampled
+// length, where both sides are flat and differ sharply.
+func longEdge(n, step int, pixels func(i int) (a, b, nextA, nextB uint32)) bool {
+	samples := (n + step - 1) / step
+	need := max(8, samples*15/100)
+
+	run := 0
+	for i := 0; i < n; i += step {
+		a, b, nextA, nextB := pixels(i)
+		if a == nextA && b == nextB && colorDistance(a, b) > edgeContrast {
+			run++
+			if run >= need {
+				return true
+			}
+		} else {
+			run = 0
+		}
+	}
+	return false
+}
+
+// colorDistance is the summed absolute difference of packed RGB channels
+func colorDistance(a, b uint32) int {
+	d := 0
+	for shift := 0; shift <= 16; shift += 8 {
+		ca, cb := int(a>>shift&0xFF), int(b>>shift&0xFF)
+		if ca > cb {
+			d += ca - cb
+		} else {
+			d += cb - ca
+		}
+	}
+	return d
+}
+
+// rgbAccessor returns a fast packed-RGB pixel reader for common image types
+func rgbAccessor(img image.Image) func(x, y int) uint32 {
+	b := img.Bounds()
+	switch m := img.(type) {
+	case *image.RGBA:
+		return func(x, y int) uint32 {
+			i := m.PixOffset(b.Min.X+x, b.Min.Y+y)
+			return uint32(m.Pix[i])<<16 | uint32(m.Pix[i+1])<<8 | uint32(m.Pix[i+2])
+		}
+	case *image.NRGBA:
+		return func(x, y int) uint32 {
+			i := m.PixOffset(b.Min.X+x, b.Min.Y+y)
+			return uint32(m.Pix[i])<<16 | uint32(m.Pix[i+1])<<8 | uint32(m.Pix[i+2])
+		}
+	case *image.YCbCr:
+		return func(x, y int) uint32 {
+			c := m.YCbCrAt(b.Min.X+x, b.Min.Y+y)
+			r, g, bl := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+			return uint32(r)<<16 | uint32(g)<<8 | uint32(bl)
+		}
+	}
+	return func(x, y int) uint32 {
+		r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return (r>>8)<<16 | (g>>8)<<8 | bl>>8
+	}
+}
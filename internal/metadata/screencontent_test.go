@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+func TestAnalyzeScreenContent(t *testing.T) {
+	// A desktop-style capture: flat background, menu bar and two windows
+	ui := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	fill := func(img draw.Image, r image.Rectangle, c color.Color) {
+		draw.Draw(img, r, &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	fill(ui, ui.Bounds(), color.RGBA{230, 230, 235, 255})
+	fill(ui, image.Rect(0, 0, 800, 24), color.RGBA{40, 40, 40, 255})
+	fill(ui, image.Rect(60, 80, 500, 400), color.RGBA{255, 255, 255, 255})
+	fill(ui, image.Rect(60, 80, 500, 110), color.RGBA{0, 120, 215, 255})
+	fill(ui, image.Rect(420, 300, 760, 560), color.RGBA{30, 30, 30, 255})
+
+	// Sensor-like noise over a gradient
+	rng := rand.New(rand.NewSource(1))
+	photo := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			v := uint8(x/4 + rng.Intn(12))
+			photo.Set(x, y, color.RGBA{v, uint8(y / 3), 128 + uint8(rng.Intn(8)), 255})
+		}
+	}
+
+	// The same capture saved as JPEG keeps its flat blocks
+	var jpegBuf bytes.Buffer
+	jpeg.Encode(&jpegBuf, ui, &jpeg.Options{Quality: 90})
+	uiJPEG, err := jpeg.Decode(&jpegBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		img             image.Image
+		expectFlat      bool
+		expectPhoto     bool
+		expectStatusBar bool
+		expectEdges     bool
+	}{
+		{
+			name:            "UI capture",
+			img:             ui,
+			expectFlat:      true,
+			expectStatusBar: true,
+			expectEdges:     true,
+		},
+		{
+			name:        "UI capture as JPEG",
+			img:         uiJPEG,
+			expectFlat:  true,
+			expectEdges: true,
+		},
+		{
+			name:        "noisy photo",
+			img:         photo,
+			expectPhoto: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyzeScreenContent(tt.img)
+			if analysis == nil {
+				t.Fatal("analyzeScreenContent() returned nil")
+			}
+
+			if got := analysis.FlatFraction >= flatScreenThreshold; got != tt.expectFlat {
+				t.Errorf("FlatFraction = %v, want flat: %v", analysis.FlatFraction, tt.expectFlat)
+			}
+
+			if got := analysis.FlatFraction < flatPhotoThreshold; got != tt.expectPhoto {
+				t.Errorf("FlatFraction = %v, want photographic: %v", analysis.FlatFraction, tt.expectPhoto)
+			}
+
+			if tt.expectStatusBar && !analysis.StatusBar {
+				t.Error("StatusBar = false, want true")
+			}
+
+			if got := analysis.StraightEdges >= minStraightEdges; got != tt.expectEdges {
+				t.Errorf("StraightEdges = %v, want edges: %v", analysis.StraightEdges, tt.expectEdges)
+			}
+		})
+	}
+
+	if analyzeScreenContent(image.NewRGBA(image.Rect(0, 0, 8, 8))) != nil {
+		t.Error("analyzeScreenContent() should skip tiny images")
+	}
+}
+
+func TestDecodeForScreenAnalysisLimit(t *testing.T) {
+	if img, _ := decodePixels(bytes.NewReader(nil), 10000, 10000, DefaultMemoryLimits.MaxPixels); img != nil {
+		t.Error("decodePixels() decoded an image over the pixel limit")
+	}
+}
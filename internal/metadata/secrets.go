@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"bufio"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// SecretFinding describes a credential-like string found in a text file.
+// The secret value itself is never included.
+type SecretFinding struct {
+	Type        string `json:"type"`
+	Line        int    `json:"line"`
+	Description string `json:"description"`
+}
+
+// secretRule describes a class of secret and how to recognize it
+type secretRule struct {
+	kind        string
+	description string
+	re          *regexp.Regexp
+	// group selects the capture group holding the secret value for the
+	// entropy check; 0 means the whole match
+	group int
+	// minEntropy is the minimum Shannon entropy (bits per character) the
+	// value must have, or 0 to skip the check
+	minEntropy float64
+}
+
+var secretRules = []secretRule{
+	{
+		kind:        "aws_access_key_id",
+		description: "AWS access key ID",
+		re:          regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|AIPA|ANPA|ANVA)[0-9A-Z]{16}\b`),
+	},
+	{
+		kind:        "aws_secret_access_key",
+		description: "AWS secret access key",
+		re:          regexp.MustCompile(`(?i)aws.{0,20}(?:secret|private).{0,20}?[:=]\s*["']?([0-9a-zA-Z/+]{40})\b`),
+		group:       1,
+		minEntropy:  3.5,
+	},
+	{
+		kind:        "private_key",
+		description: "Private key block",
+		re:          regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`),
+	},
+	{
+		kind:        "jwt",
+		description: "JSON Web Token",
+		re:          regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{10,}\.eyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,}`),
+	},
+	{
+		kind:        "github_token",
+		description: "GitHub token",
+		re:          regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+	},
+	{
+		kind:        "slack_token",
+		description: "Slack token",
+		re:          regexp.MustCompile(`\bxox[baprs]-[A-Za-z0-9\-]{10,}`),
+	},
+	{
+		kind:        "high_entropy_secret",
+		description: "High-entropy value assigned to a credential-like name",
+		re:          regexp.MustCompile(`(?i)(?:api[_\-]?key|secret|token|passw(?:or)?d|pwd|auth|credential)[A-Za-z0-9_\-]*["']?\s*[:=]\s*["']([^"'\s]{16,})["']`),
+		group:       1,
+		minEntropy:  3.5,
+	},
+}
+
+// scanSecrets scans text line by line for credentials and returns findings
+// with 1-based line numbers. Each line reports at most one finding per type.
+func scanSecrets(content string) []SecretFinding {
+	var findings []SecretFinding
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		for _, rule := range secretRules {
+			if rule.matches(text) {
+				findings = append(findings, SecretFinding{
+					Type:        rule.kind,
+					Line:        line,
+					Description: rule.description,
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+// matches reports whether the rule finds a secret in a single line
+func (r secretRule) matches(line string) bool {
+	for _, m := range r.re.FindAllStringSubmatch(line, -1) {
+		value := m[r.group]
+		if r.minEntropy > 0 && shannonEntropy(value) < r.minEntropy {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	freq := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		freq[r]++
+		total++
+	}
+
+	entropy := 0.0
+	for _, count := range freq {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,45 @@
+package metadata
+
+// SecurityMetadata contains security-relevant findings about an upload
+type SecurityMetadata struct {
+	Antivirus     *AntivirusVerdict   `json:"antivirus,omitempty"`
+	KnownFile     *KnownFileMatch     `json:"known_file,omitempty"`
+	MIMECheck     *MIMECheck          `json:"mime_check,omitempty"`
+	Polyglot      *PolyglotDetection  `json:"polyglot,omitempty"`
+	Decompression *DecompressionCheck `json:"decompression,omitempty"`
+	Encryption    *EncryptionInfo     `json:"encryption,omitempty"`
+	Macros        *MacroDetection     `json:"macros,omitempty"`
+	Entropy       *EntropyAnalysis    `json:"entropy,omitempty"`
+	Secrets       []SecretFinding     `json:"secrets,omitempty"`
+}
+
+// AntivirusVerdict is the result of scanning the upload with an antivirus
+// engine
+type AntivirusVerdict struct {
+	Engine    string `json:"engine"`
+	Status    string `json:"status"` // "clean", "infected", "error"
+	Signature string `json:"signature,omitempty"`
+}
+
+// KnownFileMatch marks an upload found in a known-good hash set such as the
+// NSRL Reference Data Set
+type KnownFileMatch struct {
+	KnownBenign bool   `json:"known_benign"`
+	Source      string `json:"source"`
+	MatchedHash string `json:"matched_hash"` // "sha256", "sha1" or "md5"
+	FileName    string `json:"file_name,omitempty"`
+	ProductCode string `json:"product_code,omitempty"`
+}
+
+// Antivirus verdict statuses
+const (
+	AntivirusClean    = "clean"
+	AntivirusInfected = "infected"
+	AntivirusError    = "error"
+)
+
+// isEmpty reports whether no security findings were recorded, in which case
+// the section is omitted from the response
+func (s *SecurityMetadata) isEmpty() bool {
+	return s.Antivirus == nil && s.KnownFile == nil && s.MIMECheck == nil && s.Polyglot == nil && s.Decompression == nil && s.Encryption == nil && s.Macros == nil && s.Entropy == nil && len(s.Secrets) == 0
+}
@@ -0,0 +1,261 @@
+package metadata
+
+import (
+	"fmt"
+	"io"
+)
+
+// ssdeep (context triggered piecewise hashing) parameters, matching the
+// reference spamsum implementation
+const (
+	ssdeepRollingWindow = 7
+	ssdeepMinBlockSize  = 3
+	ssdeepSpamsumLength = 64
+	ssdeepHashPrime     = 0x01000193
+	ssdeepHashInit      = 0x28021967
+
+	// ssdeepMinFileSize is the smallest input hashed. Shorter inputs produce
+	// digests too short to compare meaningfully.
+	ssdeepMinFileSize = 4096
+)
+
+const ssdeepB64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// ssdeepRollingHash is the Adler-32 style rolling hash that decides where
+// piece boundaries fall
+type ssdeepRollingHash struct {
+	window     [ssdeepRollingWindow]byte
+	h1, h2, h3 uint32
+	n          uint32
+}
+
+func (r *ssdeepRollingHash) roll(c byte) uint32 {
+	r.h2 -= r.h1
+	r.h2 += ssdeepRollingWindow * uint32(c)
+
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n%ssdeepRollingWindow])
+
+	r.window[r.n%ssdeepRollingWindow] = c
+	r.n++
+
+	r.h3 <<= 5
+	r.h3 ^= uint32(c)
+
+	return r.h1 + r.h2 + r.h3
+}
+
+// ssdeepSumHash is the FNV-style hash of each piece
+func ssdeepSumHash(c byte, h uint32) uint32 {
+	return (h * ssdeepHashPrime) ^ uint32(c)
+}
+
+// computeSSDeep returns the ssdeep fuzzy hash ("blocksize:hash1:hash2") of
+// the first size bytes of r, or "" for inputs too small to hash usefully
+func computeSSDeep(r io.ReaderAt, size int64) (string, error) {
+	if size < ssdeepMinFileSize {
+		return "", nil
+	}
+
+	blockSize := uint32(ssdeepMinBlockSize)
+	for int64(blockSize)*ssdeepSpamsumLength < size {
+		blockSize *= 2
+	}
+
+	for {
+		sig1, sig2, err := ssdeepDigest(io.NewSectionReader(r, 0, size), blockSize)
+		if err != nil {
+			return "", err
+		}
+
+		// Retry with a smaller block size if too few pieces were produced
+		if blockSize > ssdeepMinBlockSize && len(sig1) < ssdeepSpamsumLength/2 {
+			blockSize /= 2
+			continue
+		}
+
+		return fmt.Sprintf("%d:%s:%s", blockSize, sig1, sig2), nil
+	}
+}
+
+// ssdeepDigest computes the two piecewise signatures for one block size
+func ssdeepDigest(r io.Reader, blockSize uint32) (string, string, error) {
+	var roll ssdeepRollingHash
+	h2, h3 := uint32(ssdeepHashInit), uint32(ssdeepHashInit)
+	sig1 := make([]byte, 0, ssdeepSpamsumLength)
+	sig2 := make([]byte, 0, ssdeepSpamsumLength/2)
+
+	// The last character of each signature is overwritten by subsequent
+	// pieces once the signature is full
+	var last1, last2 byte
+	full1, full2 := false, false
+
+	var h uint32
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, c := range buf[:n] {
+			h = roll.roll(c)
+			h2 = ssdeepSumHash(c, h2)
+			h3 = ssdeepSumHash(c, h3)
+
+			if h%blockSize == blockSize-1 {
+				last1 = ssdeepB64[h2%64]
+				if len(sig1) < ssdeepSpamsumLength-1 {
+					sig1 = append(sig1, last1)
+					h2 = ssdeepHashInit
+				} else {
+					full1 = true
+				}
+			}
+
+			if h%(blockSize*2) == blockSize*2-1 {
+				last2 = ssdeepB64[h3%64]
+				if len(sig2) < ssdeepSpamsumLength/2-1 {
+					sig2 = append(sig2, last2)
+					h3 = ssdeepHashInit
+				} else {
+					full2 = true
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	// Append the hash of the final partial piece
+	if h != 0 {
+		sig1 = append(sig1, ssdeepB64[h2%64])
+		sig2 = append(sig2, ssdeepB64[h3%64])
+	} else {
+		if full1 {
+			sig1 = append(sig1, last1)
+		}
+		if full2 {
+			sig2 = append(sig2, last2)
+		}
+	}
+
+	return string(sig1), string(sig2), nil
+}
+
+// ssdeepHasher computes the ssdeep hash of a stream of known size in one
+// pass. computeSSDeep may retry with smaller block sizes, so every block
+// size it could fall back to is hashed side by side. A block size is dropped
+// once the next larger one has enough pieces that the retry never reaches it.
+type ssdeepHasher struct {
+	roll   ssdeepRollingHash
+	h      uint32
+	size   int64
+	states []*ssdeepState
+	start  int
+}
+
+// ssdeepState is the signature state for one block size
+type ssdeepState struct {
+	blockSize    uint32
+	h2, h3       uint32
+	sig1, sig2   []byte
+	last1, last2 byte
+	full1, full2 bool
+}
+
+// newSSDeepHasher creates a hasher for exactly size bytes
+func newSSDeepHasher(size int64) *ssdeepHasher {
+	s := &ssdeepHasher{size: size}
+	blockSize := uint32(ssdeepMinBlockSize)
+	for {
+		s.states = append(s.states, &ssdeepState{
+			blockSize: blockSize,
+			h2:        ssdeepHashInit,
+			h3:        ssdeepHashInit,
+			sig1:      make([]byte, 0, ssdeepSpamsumLength),
+			sig2:      make([]byte, 0, ssdeepSpamsumLength/2),
+		})
+		if int64(blockSize)*ssdeepSpamsumLength >= size {
+			return s
+		}
+		blockSize *= 2
+	}
+}
+
+// Write implements io.Writer
+func (s *ssdeepHasher) Write(p []byte) (int, error) {
+	active := s.states[s.start:]
+	for _, c := range p {
+		s.h = s.roll.roll(c)
+		for _, state := range active {
+			state.h2 = ssdeepSumHash(c, state.h2)
+			state.h3 = ssdeepSumHash(c, state.h3)
+		}
+
+		// Block sizes double, so a piece boundary for one block size is a
+		// boundary for every smaller one
+		prune := 0
+		for i, state := range active {
+			if s.h%state.blockSize != state.blockSize-1 {
+				break
+			}
+			state.last1 = ssdeepB64[state.h2%64]
+			if len(state.sig1) < ssdeepSpamsumLength-1 {
+				state.sig1 = append(state.sig1, state.last1)
+				state.h2 = ssdeepHashInit
+			} else {
+				state.full1 = true
+			}
+
+			// Smaller block sizes are only used when this one is too short
+			if len(state.sig1) >= ssdeepSpamsumLength/2 {
+				prune = i
+			}
+
+			if s.h%(state.blockSize*2) != state.blockSize*2-1 {
+				break
+			}
+			state.last2 = ssdeepB64[state.h3%64]
+			if len(state.sig2) < ssdeepSpamsumLength/2-1 {
+				state.sig2 = append(state.sig2, state.last2)
+				state.h3 = ssdeepHashInit
+			} else {
+				state.full2 = true
+			}
+		}
+		if prune > 0 {
+			s.start += prune
+			active = active[prune:]
+		}
+	}
+	return len(p), nil
+}
+
+// Sum returns the hash, matching computeSSDeep for the same input
+func (s *ssdeepHasher) Sum() string {
+	if s.size < ssdeepMinFileSize {
+		return ""
+	}
+
+	for i := len(s.states) - 1; ; i-- {
+		state := s.states[i]
+		sig1, sig2 := state.sig1, state.sig2
+		if s.h != 0 {
+			sig1 = append(sig1, ssdeepB64[state.h2%64])
+			sig2 = append(sig2, ssdeepB64[state.h3%64])
+		} else {
+			if state.full1 {
+				sig1 = append(sig1, state.last1)
+			}
+			if state.full2 {
+				sig2 = append(sig2, state.last2)
+			}
+		}
+
+		if i > s.start && len(sig1) < ssdeepSpamsumLength/2 {
+			continue
+		}
+		return fmt.Sprintf("%d:%s:%s", state.blockSize, sig1, sig2)
+	}
+}
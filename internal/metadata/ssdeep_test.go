@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"bytes"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var ssdeepFormat = regexp.MustCompile(`^\d+:[A-Za-z0-9+/]{0,64}:[A-Za-z0-9+/]{0,32}$`)
+
+func TestComputeSSDeep(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	words := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet"}
+	var sb strings.Builder
+	for sb.Len() < 64*1024 {
+		sb.WriteString(words[rng.Intn(len(words))])
+		sb.WriteByte(' ')
+	}
+	original := []byte(sb.String())
+
+	// Change a few bytes in the middle of the file
+	modified := bytes.Clone(original)
+	copy(modified[len(modified)/2:], "MODIFIED")
+
+	tests := []struct {
+		name      string
+		data      []byte
+		expectNil bool
+	}{
+		{name: "small file is skipped", data: []byte("too small to hash"), expectNil: true},
+		{name: "text", data: original},
+		{name: "zero bytes", data: make([]byte, 8192)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := computeSSDeep(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("computeSSDeep() error = %v", err)
+			}
+
+			if tt.expectNil {
+				if hash != "" {
+					t.Errorf("computeSSDeep() = %q, want empty", hash)
+				}
+				return
+			}
+
+			if !ssdeepFormat.MatchString(hash) {
+				t.Errorf("computeSSDeep() = %q, want blocksize:hash1:hash2", hash)
+			}
+
+			again, _ := computeSSDeep(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if again != hash {
+				t.Errorf("computeSSDeep() not deterministic: %q != %q", hash, again)
+			}
+		})
+	}
+
+	t.Run("small modification keeps most of the hash", func(t *testing.T) {
+		a, _ := computeSSDeep(bytes.NewReader(original), int64(len(original)))
+		b, _ := computeSSDeep(bytes.NewReader(modified), int64(len(modified)))
+		if a == b {
+			t.Fatalf("hashes of different files are identical: %q", a)
+		}
+
+		partsA := strings.Split(a, ":")
+		partsB := strings.Split(b, ":")
+		if partsA[0] != partsB[0] {
+			t.Fatalf("block sizes differ: %q vs %q", a, b)
+		}
+
+		// Pieces before the change are unaffected
+		prefix := 0
+		for prefix < len(partsA[1]) && prefix < len(partsB[1]) && partsA[1][prefix] == partsB[1][prefix] {
+			prefix++
+		}
+		if prefix < len(partsA[1])/4 {
+			t.Errorf("common prefix = %d chars, want at least %d (%q vs %q)", prefix, len(partsA[1])/4, a, b)
+		}
+	})
+}
+
+func TestSSDeepHasherMatchesComputeSSDeep(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	text := make([]byte, 3<<20)
+	for i := range text {
+		text[i] = "abcdefgh \n"[rng.Intn(10)]
+	}
+	random := make([]byte, 2<<20)
+	rng.Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "too small", data: text[:100]},
+		{name: "minimum size", data: text[:ssdeepMinFileSize]},
+		{name: "text", data: text[:70000]},
+		{name: "large text", data: text},
+		{name: "random", data: random},
+		{name: "zero bytes", data: make([]byte, 1<<20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := computeSSDeep(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Odd write sizes exercise state kept across writes
+			hasher := newSSDeepHasher(int64(len(tt.data)))
+			for data := tt.data; len(data) > 0; {
+				n := min(len(data), 4093)
+				hasher.Write(data[:n])
+				data = data[n:]
+			}
+
+			if got := hasher.Sum(); got != want {
+				t.Errorf("Sum() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package metadata
+
+import (
+	"encoding/hex"
+	"math"
+	"sort"
+	"strings"
+)
+
+// TLSH (Trend Micro locality sensitive hash) parameters for the standard
+// 128-bucket, 1-byte checksum variant with the "T1" version prefix
+const (
+	tlshWindowSize    = 5
+	tlshBuckets       = 128
+	tlshCodeSize      = tlshBuckets / 4
+	tlshMinDataLength = 50
+	tlshVersionPrefix = "T1"
+)
+
+// tlshPearson is the Pearson hashing permutation used by TLSH
+var tlshPearson = [256]byte{
+	1, 87, 49, 12, 176, 178, 102, 166, 121, 193, 6, 84, 249, 230, 44, 163,
+	14, 197, 213, 181, 161, 85, 218, 80, 64, 239, 24, 226, 236, 142, 38, 200,
+	110, 177, 104, 103, 141, 253, 255, 50, 77, 101, 81, 18, 45, 96, 31, 222,
+	25, 107, 190, 70, 86, 237, 240, 34, 72, 242, 20, 214, 244, 227, 149, 235,
+	97, 234, 57, 22, 60, 250, 82, 175, 208, 5, 127, 199, 111, 62, 135, 248,
+	174, 169, 211, 58, 66, 154, 106, 195, 245, 171, 17, 187, 182, 179, 0, 243,
+	132, 56, 148, 75, 128, 133, 158, 100, 130, 126, 91, 13, 153, 246, 216, 219,
+	119, 68, 223, 78, 83, 88, 201, 99, 122, 11, 92, 32, 136, 114, 52, 10,
+	138, 30, 48, 183, 156, 35, 61, 26, 143, 74, 251, 94, 129, 162, 63, 152,
+	170, 7, 115, 167, 241, 206, 3, 150, 55, 59, 151, 220, 90, 53, 23, 131,
+	125, 173, 15, 238, 79, 95, 89, 16, 105, 137, 225, 224, 217, 160, 37, 123,
+	118, 73, 2, 157, 46, 116, 9, 145, 134, 228, 207, 212, 202, 215, 69, 229,
+	27, 188, 67, 124, 168, 252, 42, 4, 29, 108, 21, 247, 19, 205, 39, 203,
+	233, 40, 186, 147, 198, 192, 155, 33, 164, 191, 98, 204, 165, 180, 117, 76,
+	140, 36, 210, 172, 41, 54, 159, 8, 185, 232, 113, 196, 231, 47, 146, 120,
+	51, 65, 28, 144, 254, 221, 93, 189, 194, 139, 112, 43, 71, 109, 184, 209,
+}
+
+// tlshHasher accumulates TLSH bucket counts as an io.Writer so it can share
+// the checksum pass over the file
+type tlshHasher struct {
+	window   [tlshWindowSize]byte
+	buckets  [256]uint32
+	checksum byte
+	length   int64
+}
+
+func newTLSHHasher() *tlshHasher {
+	return &tlshHasher{}
+}
+
+// tlshMapping hashes a salt and byte triplet into a bucket index
+func tlshMapping(salt, i, j, k byte) byte {
+	h := tlshPearson[salt]
+	h = tlshPearson[h^i]
+	h = tlshPearson[h^j]
+	return tlshPearson[h^k]
+}
+
+// Write implements io.Writer
+func (t *tlshHasher) Write(p []byte) (int, error) {
+	for _, c := range p {
+		pos := int(t.length % tlshWindowSize)
+		t.window[pos] = c
+		t.length++
+
+		// Triplets are only formed once the window is full
+		if t.length < tlshWindowSize {
+			continue
+		}
+
+		a := t.window[pos]
+		b := t.window[(pos+4)%tlshWindowSize]
+		c := t.window[(pos+3)%tlshWindowSize]
+		d := t.window[(pos+2)%tlshWindowSize]
+		e := t.window[(pos+1)%tlshWindowSize]
+
+		t.checksum = tlshMapping(0, a, b, t.checksum)
+
+		t.buckets[tlshMapping(2, a, b, c)]++
+		t.buckets[tlshMapping(3, a, b, d)]++
+		t.buckets[tlshMapping(5, a, c, d)]++
+		t.buckets[tlshMapping(7, a, c, e)]++
+		t.buckets[tlshMapping(11, a, b, e)]++
+		t.buckets[tlshMapping(13, a, d, e)]++
+	}
+	return len(p), nil
+}
+
+// Sum returns the hex TLSH digest, or "" when the input is too short or too
+// uniform to produce a meaningful hash
+func (t *tlshHasher) Sum() string {
+	if t.length < tlshMinDataLength {
+		return ""
+	}
+
+	nonZero := 0
+	for _, count := range t.buckets[:tlshBuckets] {
+		if count > 0 {
+			nonZero++
+		}
+	}
+	if nonZero <= tlshBuckets/2 {
+		return ""
+	}
+
+	q1, q2, q3 := tlshQuartiles(t.buckets[:tlshBuckets])
+
+	// Each bucket contributes two bits depending on its quartile
+	var code [tlshCodeSize]byte
+	for i := range code {
+		var h byte
+		for j := 0; j < 4; j++ {
+			count := t.buckets[4*i+j]
+			switch {
+			case count > q3:
+				h += 3 << (j * 2)
+			case count > q2:
+				h += 2 << (j * 2)
+			case count > q1:
+				h += 1 << (j * 2)
+			}
+		}
+		code[i] = h
+	}
+
+	q1Ratio := byte(uint32(float32(q1*100)/float32(q3)) % 16)
+	q2Ratio := byte(uint32(float32(q2*100)/float32(q3)) % 16)
+
+	// Header bytes are written nibble-swapped and the body reversed, as in
+	// the reference implementation
+	digest := make([]byte, 0, 3+tlshCodeSize)
+	digest = append(digest, swapNibbles(t.checksum), swapNibbles(tlshLength(t.length)), q1Ratio<<4|q2Ratio)
+	for i := tlshCodeSize - 1; i >= 0; i-- {
+		digest = append(digest, code[i])
+	}
+
+	return tlshVersionPrefix + strings.ToUpper(hex.EncodeToString(digest))
+}
+
+// tlshQuartiles returns the 25th, 50th and 75th percentile bucket counts
+func tlshQuartiles(buckets []uint32) (uint32, uint32, uint32) {
+	sorted := make([]uint32, len(buckets))
+	copy(sorted, buckets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	quarter := len(sorted) / 4
+	return sorted[quarter-1], sorted[2*quarter-1], sorted[3*quarter-1]
+}
+
+// tlshLength encodes the data length on a logarithmic scale
+func tlshLength(n int64) byte {
+	l := math.Log(float64(float32(n)))
+	var i int
+	switch {
+	case n <= 656:
+		i = int(math.Floor(l / 0.4054651))
+	case n <= 3199:
+		i = int(math.Floor(l/0.26236426 - 8.72777))
+	default:
+		i = int(math.Floor(l/0.095310180 - 62.5472))
+	}
+	return byte(i & 0xFF)
+}
+
+func swapNibbles(b byte) byte {
+	return b<<4 | b>>4
+}
@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"bytes"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var tlshFormat = regexp.MustCompile(`^T1[0-9A-F]{70}$`)
+
+func TestTLSHPearsonTableIsPermutation(t *testing.T) {
+	var seen [256]bool
+	for _, v := range tlshPearson {
+		if seen[v] {
+			t.Fatalf("value %d appears more than once", v)
+		}
+		seen[v] = true
+	}
+}
+
+func TestTLSHHasher(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	words := []string{"kilo", "lima", "mike", "november", "oscar", "papa", "quebec", "romeo", "sierra", "tango"}
+	var sb strings.Builder
+	for sb.Len() < 32*1024 {
+		sb.WriteString(words[rng.Intn(len(words))])
+		sb.WriteByte(' ')
+	}
+	original := []byte(sb.String())
+
+	modified := bytes.Clone(original)
+	copy(modified[len(modified)/2:], "MODIFIED")
+
+	random := make([]byte, 32*1024)
+	rng.Read(random)
+
+	sum := func(data []byte) string {
+		h := newTLSHHasher()
+		// Write in uneven pieces to exercise the sliding window
+		for len(data) > 0 {
+			n := min(len(data), 777)
+			h.Write(data[:n])
+			data = data[n:]
+		}
+		return h.Sum()
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		expectEmpty bool
+	}{
+		{name: "too short", data: []byte("short input"), expectEmpty: true},
+		{name: "too uniform", data: make([]byte, 4096), expectEmpty: true},
+		{name: "text", data: original},
+		{name: "random", data: random},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := sum(tt.data)
+			if tt.expectEmpty {
+				if digest != "" {
+					t.Errorf("Sum() = %q, want empty", digest)
+				}
+				return
+			}
+
+			if !tlshFormat.MatchString(digest) {
+				t.Errorf("Sum() = %q, want T1 followed by 70 hex digits", digest)
+			}
+			if again := sum(tt.data); again != digest {
+				t.Errorf("Sum() not deterministic: %q != %q", digest, again)
+			}
+		})
+	}
+
+	t.Run("similar inputs have closer digests", func(t *testing.T) {
+		a, b, c := sum(original), sum(modified), sum(random)
+		if a == b {
+			t.Fatalf("digests of different inputs are identical: %q", a)
+		}
+		if near, far := hexDistance(a, b), hexDistance(a, c); near >= far {
+			t.Errorf("distance(original, modified) = %d, want less than distance(original, random) = %d", near, far)
+		}
+	})
+}
+
+// hexDistance counts differing digits between two equal-length digests
+func hexDistance(a, b string) int {
+	d := 0
+	for i := range a {
+		if a[i] != b[i] {
+			d++
+		}
+	}
+	return d
+}
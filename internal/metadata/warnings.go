@@ -0,0 +1,50 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warning explains why part of a result is missing or incomplete
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Warning codes for extractors that failed on malformed content. The rest
+// of the result is still returned.
+const (
+	WarningImageUndecodable  = "image_undecodable"
+	WarningPixelsUndecodable = "pixels_undecodable"
+	WarningEXIFInvalid       = "exif_invalid"
+	WarningAudioTagsInvalid  = "audio_tags_invalid"
+)
+
+func imageUndecodableWarning(err error) Warning {
+	return Warning{
+		Code:    WarningImageUndecodable,
+		Message: fmt.Sprintf("image header could not be decoded (%v); dimensions and pixel content were not analyzed", err),
+	}
+}
+
+func pixelsUndecodableWarning(err error) Warning {
+	return Warning{
+		Code:    WarningPixelsUndecodable,
+		Message: fmt.Sprintf("image data could not be decoded (%v); screenshot content and perceptual hash were not analyzed", err),
+	}
+}
+
+func exifInvalidWarning(err error) Warning {
+	// goexif's errors may end in a space
+	return Warning{
+		Code:    WarningEXIFInvalid,
+		Message: fmt.Sprintf("EXIF block could not be parsed (%s); camera, date and GPS fields are missing", strings.TrimSpace(err.Error())),
+	}
+}
+
+func audioTagsInvalidWarning(err error) Warning {
+	return Warning{
+		Code:    WarningAudioTagsInvalid,
+		Message: fmt.Sprintf("audio tags could not be read (%v)", err),
+	}
+}
@@ -0,0 +1,57 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+func TestExtractWarnings(t *testing.T) {
+	var pic bytes.Buffer
+	png.Encode(&pic, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+	truncatedPNG := pic.Bytes()[:pic.Len()/2]
+
+	corruptEXIF := jpegWithSegments(t, app1([]byte("Exif\x00\x00"), []byte("II*\x00\xff\xff\xff\x7f")))
+
+	// WebP is detected, but no decoder is registered for it
+	webp := append([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "), make([]byte, 32)...)
+
+	// A title frame claiming more data than the tag holds
+	brokenID3 := []byte("ID3\x03\x00\x00\x00\x00\x00\x10TIT2\x00\x00\x7f\xff\x00\x00\x00abc")
+
+	tests := []struct {
+		name      string
+		filename  string
+		content   []byte
+		wantCodes []string
+	}{
+		{name: "valid image", filename: "pic.png", content: pic.Bytes()},
+		{name: "truncated image", filename: "pic.png", content: truncatedPNG, wantCodes: []string{WarningPixelsUndecodable}},
+		{name: "corrupt EXIF", filename: "photo.jpg", content: corruptEXIF, wantCodes: []string{WarningEXIFInvalid}},
+		{name: "unsupported image format", filename: "pic.webp", content: webp, wantCodes: []string{WarningImageUndecodable}},
+		{name: "unreadable audio tags", filename: "song.mp3", content: brokenID3, wantCodes: []string{WarningAudioTagsInvalid}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ExtractWithOptions(context.Background(), memoryFile{bytes.NewReader(tt.content)}, fileHeader(tt.filename), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var codes []string
+			for _, warning := range result.Warnings {
+				codes = append(codes, warning.Code)
+			}
+			if !slices.Equal(codes, tt.wantCodes) {
+				t.Errorf("Warnings = %v, want %v", result.Warnings, tt.wantCodes)
+			}
+			if result.SHA256 == "" {
+				t.Error("SHA256 is returned whatever the extractors make of the file")
+			}
+		})
+	}
+}
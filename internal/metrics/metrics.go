@@ -0,0 +1,389 @@
+// Package metrics keeps process-wide counters, gauges and histograms and
+// serves them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metric is a single named time series
+type Metric interface {
+	// Name returns the metric name
+	Name() string
+
+	// write appends the metric in the text exposition format
+	write(w io.Writer) error
+}
+
+// Registry holds metrics by name
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]Metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+// Default is the registry the package-level constructors register with
+var Default = NewRegistry()
+
+// Register adds m to the registry. Registering a name twice panics, as it
+// would produce an invalid exposition.
+func (r *Registry) Register(m Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[m.Name()]; ok {
+		panic("metrics: duplicate metric " + m.Name())
+	}
+	r.metrics[m.Name()] = m
+}
+
+// Write writes every metric, sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]Metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name() < metrics[j].Name() })
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the registry's metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+// NewCounter creates a counter registered with Default
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	Default.Register(c)
+	return c
+}
+
+// Inc adds one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+	addFloat(&c.bits, v)
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// Name implements Metric
+func (c *Counter) Name() string { return c.name }
+
+func (c *Counter) write(w io.Writer) error {
+	return writeSample(w, c.name, c.help, "counter", c.Value())
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name string
+	help string
+	bits atomic.Uint64
+}
+
+// NewGauge creates a gauge registered with Default
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	Default.Register(g)
+	return g
+}
+
+// Set replaces the value
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add adds v, which may be negative
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Name implements Metric
+func (g *Gauge) Name() string { return g.name }
+
+func (g *Gauge) write(w io.Writer) error {
+	return writeSample(w, g.name, g.help, "gauge", g.Value())
+}
+
+// addFloat atomically adds v to the float64 stored in bits
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func writeSample(w io.Writer, name, help, kind string, value float64) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+		name, help, name, kind, name, formatFloat(value))
+	return err
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// DefaultBuckets are histogram upper bounds in seconds, from 5ms to a minute
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts observations in buckets and keeps their sum
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64 // per bucket, with +Inf last
+	sum     atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+}
+
+// Observe records v
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i].Add(1)
+	addFloat(&h.sum, v)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	var count uint64
+	for i := range h.counts {
+		count += h.counts[i].Load()
+	}
+	return count
+}
+
+// vec holds the series of a metric family by their label values
+type vec[T any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	create func() T
+	format func(w io.Writer, name, labels string, series T) error
+
+	mu     sync.Mutex
+	series map[string]T
+}
+
+// with returns the series for values, creating it on first use
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + `="` + labelEscaper.Replace(value) + `"`
+	}
+	key := strings.Join(pairs, ",")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	series, ok := v.series[key]
+	if !ok {
+		series = v.create()
+		v.series[key] = series
+	}
+	return series
+}
+
+// writeAll writes the family's series, sorted by label values
+func (v *vec[T]) writeAll(w io.Writer) error {
+	v.mu.Lock()
+	series := make(map[string]T, len(v.series))
+	maps.Copy(series, v.series)
+	v.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(series)) {
+		if err := v.format(w, v.name, key, series[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// CounterVec is a counter per combination of label values
+type CounterVec struct {
+	vec[*Counter]
+}
+
+// NewCounterVec creates a counter family with the given label names,
+// registered with Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := newCounterVec(name, help, labels)
+	Default.Register(v)
+	return v
+}
+
+func newCounterVec(name, help string, labels []string) *CounterVec {
+	return &CounterVec{vec[*Counter]{
+		name:   name,
+		help:   help,
+		kind:   "counter",
+		labels: labels,
+		create: func() *Counter { return &Counter{name: name, help: help} },
+		format: func(w io.Writer, name, labels string, c *Counter) error {
+			_, err := fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(c.Value()))
+			return err
+		},
+		series: make(map[string]*Counter),
+	}}
+}
+
+// With returns the counter for the label values, in the order the labels
+// were named
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values)
+}
+
+// Name implements Metric
+func (v *CounterVec) Name() string { return v.name }
+
+func (v *CounterVec) write(w io.Writer) error { return v.writeAll(w) }
+
+// GaugeVec is a gauge per combination of label values
+type GaugeVec struct {
+	vec[*Gauge]
+}
+
+// NewGaugeVec creates a gauge family with the given label names,
+// registered with Default
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := newGaugeVec(name, help, labels)
+	Default.Register(v)
+	return v
+}
+
+func newGaugeVec(name, help string, labels []string) *GaugeVec {
+	return &GaugeVec{vec[*Gauge]{
+		name:   name,
+		help:   help,
+		kind:   "gauge",
+		labels: labels,
+		create: func() *Gauge { return &Gauge{name: name, help: help} },
+		format: func(w io.Writer, name, labels string, g *Gauge) error {
+			_, err := fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(g.Value()))
+			return err
+		},
+		series: make(map[string]*Gauge),
+	}}
+}
+
+// With returns the gauge for the label values, in the order the labels
+// were named
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values)
+}
+
+// Name implements Metric
+func (v *GaugeVec) Name() string { return v.name }
+
+func (v *GaugeVec) write(w io.Writer) error { return v.writeAll(w) }
+
+// HistogramVec is a histogram per combination of label values
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+// NewHistogramVec creates a histogram family with the given bucket upper
+// bounds, in increasing order, and label names, registered with Default
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := newHistogramVec(name, help, buckets, labels)
+	Default.Register(v)
+	return v
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels []string) *HistogramVec {
+	return &HistogramVec{vec[*Histogram]{
+		name:   name,
+		help:   help,
+		kind:   "histogram",
+		labels: labels,
+		create: func() *Histogram { return newHistogram(buckets) },
+		format: writeHistogram,
+		series: make(map[string]*Histogram),
+	}}
+}
+
+// With returns the histogram for the label values, in the order the labels
+// were named
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values)
+}
+
+// Name implements Metric
+func (v *HistogramVec) Name() string { return v.name }
+
+func (v *HistogramVec) write(w io.Writer) error { return v.writeAll(w) }
+
+// writeHistogram writes a histogram's cumulative buckets, sum and count
+func writeHistogram(w io.Writer, name, labels string, h *Histogram) error {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.buckets) {
+			le = formatFloat(h.buckets[i])
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, le, cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_sum{%s} %s\n%s_count{%s} %d\n",
+		name, labels, formatFloat(math.Float64frombits(h.sum.Load())), name, labels, cumulative)
+	return err
+}
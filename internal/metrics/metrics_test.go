@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	spills := &Counter{name: "test_spills_total", help: "Uploads spilled to disk"}
+	registry.Register(spills)
+	inFlight := &Gauge{name: "test_in_flight", help: "Requests in flight"}
+	registry.Register(inFlight)
+
+	spills.Inc()
+	spills.Add(2.5)
+	inFlight.Add(3)
+	inFlight.Add(-1)
+
+	rr := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP test_in_flight Requests in flight
+# TYPE test_in_flight gauge
+test_in_flight 2
+# HELP test_spills_total Uploads spilled to disk
+# TYPE test_spills_total counter
+test_spills_total 3.5
+`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("exposition = %q, want %q", got, want)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(&Counter{name: "dup_total"})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() should panic on a duplicate name")
+		}
+	}()
+	registry.Register(&Gauge{name: "dup_total"})
+}
+
+func TestVecs(t *testing.T) {
+	registry := NewRegistry()
+
+	runs := newCounterVec("test_runs_total", "Extractor runs", []string{"module"})
+	registry.Register(runs)
+	remaining := newGaugeVec("test_remaining", "Requests left", []string{"key_id"})
+	registry.Register(remaining)
+	durations := newHistogramVec("test_duration_seconds", "Extractor time", []float64{0.1, 1}, []string{"mime_type", "module"})
+	registry.Register(durations)
+
+	runs.With("image").Inc()
+	runs.With("image").Inc()
+	runs.With(`a"b`).Inc()
+	remaining.With("k1").Set(4)
+	remaining.With("k1").Add(-1)
+	durations.With("image/tiff", "image").Observe(0.05)
+	durations.With("image/tiff", "image").Observe(0.1)
+	durations.With("image/tiff", "image").Observe(3)
+
+	rr := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP test_duration_seconds Extractor time
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{mime_type="image/tiff",module="image",le="0.1"} 2
+test_duration_seconds_bucket{mime_type="image/tiff",module="image",le="1"} 2
+test_duration_seconds_bucket{mime_type="image/tiff",module="image",le="+Inf"} 3
+test_duration_seconds_sum{mime_type="image/tiff",module="image"} 3.15
+test_duration_seconds_count{mime_type="image/tiff",module="image"} 3
+# HELP test_remaining Requests left
+# TYPE test_remaining gauge
+test_remaining{key_id="k1"} 3
+# HELP test_runs_total Extractor runs
+# TYPE test_runs_total counter
+test_runs_total{module="a\"b"} 1
+test_runs_total{module="image"} 2
+`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("exposition = %q, want %q", got, want)
+	}
+}
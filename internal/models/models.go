@@ -7,7 +7,93 @@ type ErrorResponse struct {
 	Code    string `json:"code,omitempty"`
 }
 
+// Error codes in ErrorResponse.Code. Clients branch on these rather than on
+// the English message; codes are never renamed or removed, only added.
+const (
+	CodeBadRequest             = "BAD_REQUEST"              // malformed body, header or parameter
+	CodeInvalidOptions         = "INVALID_OPTIONS"          // query options such as profile, fields or format
+	CodeInvalidFile            = "INVALID_FILE"             // missing or unreadable file in the upload
+	CodeUnauthorized           = "UNAUTHORIZED"             // missing or invalid API key or access token
+	CodeForbidden              = "FORBIDDEN"                // API key lacks the route's scope, or storage denied access
+	CodeNotFound               = "NOT_FOUND"                // result, job, upload or object doesn't exist
+	CodeFeatureDisabled        = "FEATURE_DISABLED"         // the endpoint's feature isn't configured
+	CodeMethodNotAllowed       = "METHOD_NOT_ALLOWED"       // see the Allow header
+	CodeRequestTimeout         = "REQUEST_TIMEOUT"          // file data didn't arrive in time
+	CodeConflict               = "CONFLICT"                 // resumable upload offset or state doesn't match
+	CodePreconditionFailed     = "PRECONDITION_FAILED"      // unsupported Tus-Resumable version
+	CodeFileTooLarge           = "FILE_TOO_LARGE"           // file or decompressed body over the size limit
+	CodeTooManyFiles           = "TOO_MANY_FILES"           // more file parts than MAX_FILES_PER_REQUEST
+	CodeRequestTooLarge        = "REQUEST_TOO_LARGE"        // whole request over MAX_REQUEST_SIZE_MB
+	CodeUnsupportedMediaType   = "UNSUPPORTED_MEDIA_TYPE"   // request Content-Type or Content-Encoding
+	CodeUnsupportedType        = "UNSUPPORTED_TYPE"         // file type rejected by the upload policy
+	CodeRateLimited            = "RATE_LIMITED"             // see Retry-After
+	CodeAuthBanned             = "AUTH_BANNED"              // client IP sent too many invalid API keys
+	CodeConcurrencyLimited     = "CONCURRENCY_LIMITED"      // API key already runs its extractions
+	CodeServerBusy             = "SERVER_BUSY"              // no extraction slot, temporary space or capacity
+	CodeShuttingDown           = "SHUTTING_DOWN"            // server is stopping; retry elsewhere
+	CodeAntivirusUnavailable   = "ANTIVIRUS_UNAVAILABLE"    // scan failed with CLAMAV_FAIL_MODE closed
+	CodeRateLimiterUnavailable = "RATE_LIMITER_UNAVAILABLE" // Redis is down and RATE_LIMIT_FAIL_MODE is closed
+	CodeUpstreamError          = "UPSTREAM_ERROR"           // cloud storage provider failed
+	CodeExtractionTimeout      = "EXTRACTION_TIMEOUT"       // extraction exceeded EXTRACTION_TIMEOUT
+	CodeExtractionFailed       = "EXTRACTION_FAILED"        // extraction itself failed
+	CodeInternal               = "INTERNAL_ERROR"           // anything else on the server's side
+)
+
+// ErrorCodes lists every error code, in the order above
+var ErrorCodes = []string{
+	CodeBadRequest, CodeInvalidOptions, CodeInvalidFile, CodeUnauthorized, CodeForbidden,
+	CodeNotFound, CodeFeatureDisabled, CodeMethodNotAllowed, CodeRequestTimeout, CodeConflict, CodePreconditionFailed,
+	CodeFileTooLarge, CodeTooManyFiles, CodeRequestTooLarge, CodeUnsupportedMediaType, CodeUnsupportedType,
+	CodeRateLimited, CodeAuthBanned, CodeConcurrencyLimited, CodeServerBusy, CodeShuttingDown,
+	CodeAntivirusUnavailable, CodeRateLimiterUnavailable, CodeUpstreamError, CodeExtractionTimeout,
+	CodeExtractionFailed, CodeInternal,
+}
+
+// Health statuses: a service is down when a critical dependency is, and
+// degraded when another one is
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
 // HealthResponse represents health check response
 type HealthResponse struct {
-	Status string `json:"status"`
+	Status           string                      `json:"status"`
+	Version          string                      `json:"version,omitempty"`
+	Commit           string                      `json:"commit,omitempty"`
+	UptimeSeconds    int64                       `json:"uptime_seconds"`
+	InFlightRequests int64                       `json:"in_flight_requests"`
+	Dependencies     map[string]DependencyHealth `json:"dependencies,omitempty"`
+}
+
+// Probe statuses of readiness checks
+const (
+	ProbeReady    = "ready"
+	ProbeNotReady = "not_ready"
+)
+
+// ProbeResponse represents liveness and readiness probe responses
+type ProbeResponse struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"` // why the service isn't ready
+}
+
+// DependencyHealth is the state of one dependency in a health check
+type DependencyHealth struct {
+	Status    string  `json:"status"` // ok or down
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// VersionInfo represents the build and what it serves
+type VersionInfo struct {
+	Version     string   `json:"version"`
+	Commit      string   `json:"commit,omitempty"`
+	BuildDate   string   `json:"build_date,omitempty"`
+	GoVersion   string   `json:"go_version"`
+	Features    []string `json:"features"`     // optional features enabled
+	Modules     []string `json:"modules"`      // extraction modules
+	Profiles    []string `json:"profiles"`     // extraction profiles
+	APIVersions []string `json:"api_versions"` // path prefixes
 }
@@ -0,0 +1,64 @@
+// Package modules switches extraction modules off for a whole deployment,
+// from the configuration at startup and by admins at runtime.
+package modules
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"file-meta/internal/metadata"
+)
+
+// Switches records which extraction modules are turned off. It is safe for
+// concurrent use.
+type Switches struct {
+	mu       sync.RWMutex
+	disabled map[string]bool
+}
+
+// New creates switches with the given modules turned off
+func New(disabled []string) *Switches {
+	s := &Switches{disabled: make(map[string]bool)}
+	for _, module := range disabled {
+		s.disabled[module] = true
+	}
+	return s
+}
+
+// Enabled reports whether module may run
+func (s *Switches) Enabled(module string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.disabled[module]
+}
+
+// States returns whether each module is enabled, by name
+func (s *Switches) States() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make(map[string]bool, len(metadata.Modules))
+	for _, module := range metadata.Modules {
+		states[module] = !s.disabled[module]
+	}
+	return states
+}
+
+// Set turns modules on or off, all of them or none if one is unknown
+func (s *Switches) Set(states map[string]bool) error {
+	for module := range states {
+		if !slices.Contains(metadata.Modules, module) {
+			return fmt.Errorf("unknown module %q", module)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for module, enabled := range states {
+		if enabled {
+			delete(s.disabled, module)
+		} else {
+			s.disabled[module] = true
+		}
+	}
+	return nil
+}
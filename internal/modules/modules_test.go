@@ -0,0 +1,25 @@
+package modules
+
+import "testing"
+
+func TestSwitches(t *testing.T) {
+	s := New([]string{"ai_detection"})
+	if s.Enabled("ai_detection") || !s.Enabled("image") {
+		t.Errorf("Enabled() = %v, %v, want ai_detection off and image on", s.Enabled("ai_detection"), s.Enabled("image"))
+	}
+
+	if err := s.Set(map[string]bool{"ai_detection": true, "video": false}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	states := s.States()
+	if !states["ai_detection"] || states["video"] || !states["image"] {
+		t.Errorf("States() = %v, want ai_detection and image on, video off", states)
+	}
+
+	if err := s.Set(map[string]bool{"image": false, "ocr": false}); err == nil {
+		t.Error("Set() should return error for an unknown module")
+	}
+	if !s.Enabled("image") {
+		t.Error("Set() with an unknown module still switched image off")
+	}
+}
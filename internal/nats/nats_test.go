@@ -0,0 +1,308 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+)
+
+// fakeServer routes messages between its clients' subscriptions. Messages
+// to a subject under stream are acknowledged as JetStream would.
+type fakeServer struct {
+	t        *testing.T
+	listener net.Listener
+	stream   string
+
+	mu        sync.Mutex
+	conns     []*fakeClient
+	connects  []map[string]any
+	published []string
+}
+
+type fakeClient struct {
+	conn net.Conn
+	mu   sync.Mutex
+	subs map[string]string // sid to subject
+}
+
+func newFakeServer(t *testing.T, stream string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, listener: listener, stream: stream}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url(userinfo string) string {
+	return "nats://" + userinfo + s.listener.Addr().String()
+}
+
+// dropAll closes every client connection
+func (s *fakeServer) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	client := &fakeClient{conn: conn, subs: make(map[string]string)}
+	s.mu.Lock()
+	s.conns = append(s.conns, client)
+	s.mu.Unlock()
+
+	io.WriteString(conn, `INFO {"server_id":"fake","max_payload":1048576}`+"\r\n")
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch op {
+		case "CONNECT":
+			var options map[string]any
+			json.Unmarshal([]byte(args), &options)
+			s.mu.Lock()
+			s.connects = append(s.connects, options)
+			s.mu.Unlock()
+		case "PING":
+			client.write("PONG\r\n")
+		case "SUB":
+			fields := strings.Fields(args)
+			client.mu.Lock()
+			client.subs[fields[len(fields)-1]] = fields[0]
+			client.mu.Unlock()
+		case "PUB":
+			fields := strings.Fields(args)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return
+			}
+			var reply string
+			if len(fields) == 3 {
+				reply = fields[1]
+			}
+			s.publish(fields[0], reply, data[:size])
+		}
+	}
+}
+
+func (c *fakeClient) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	io.WriteString(c.conn, s)
+}
+
+// publish delivers a message to matching subscriptions, and acks it if it
+// falls under the stream
+func (s *fakeServer) publish(subject, reply string, data []byte) {
+	s.mu.Lock()
+	s.published = append(s.published, subject+" "+string(data))
+	clients := append([]*fakeClient(nil), s.conns...)
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.mu.Lock()
+		var matched []string
+		for sid, pattern := range c.subs {
+			if matches(pattern, subject) {
+				matched = append(matched, sid)
+			}
+		}
+		c.mu.Unlock()
+		for _, sid := range matched {
+			if reply != "" {
+				c.write(fmt.Sprintf("MSG %s %s %s %d\r\n%s\r\n", subject, sid, reply, len(data), data))
+			} else {
+				c.write(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", subject, sid, len(data), data))
+			}
+		}
+	}
+
+	if s.stream != "" && reply != "" {
+		ack := `{"error":{"code":503,"description":"no stream matches subject"}}`
+		if strings.HasPrefix(subject, s.stream+".") {
+			ack = `{"stream":"RESULTS","seq":1}`
+		}
+		s.publish(reply, "", []byte(ack))
+	}
+}
+
+// matches reports whether subject matches pattern, which may end in a *
+// wildcard
+func matches(pattern, subject string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, ".*"); ok {
+		rest, ok := strings.CutPrefix(subject, prefix+".")
+		return ok && rest != "" && !strings.Contains(rest, ".")
+	}
+	return pattern == subject
+}
+
+func (s *fakeServer) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.published...)
+}
+
+func TestConn(t *testing.T) {
+	server := newFakeServer(t, "")
+	conn, err := Connect(server.url("user:secret@"), "file-meta", nil, 5*time.Second, logger.New("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := server.connects[0]; got["user"] != "user" || got["pass"] != "secret" || got["name"] != "file-meta" {
+		t.Errorf("CONNECT = %v, want user, pass and name", got)
+	}
+
+	received := make(chan Msg, 1)
+	if err := conn.Subscribe("jobs", "workers", func(msg Msg) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Subscribe("echo", "", func(msg Msg) {
+		conn.Publish(msg.Reply, append([]byte("echo: "), msg.Data...))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply, err := conn.Request(ctx, "echo", []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "echo: hi" {
+		t.Errorf("reply = %q, want %q", reply, "echo: hi")
+	}
+
+	if err := conn.Publish("jobs", []byte("job 1")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Subject != "jobs" || string(msg.Data) != "job 1" {
+			t.Errorf("received %+v, want job 1 on jobs", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestConnReconnects(t *testing.T) {
+	defer func(delay time.Duration) { reconnectDelay = delay }(reconnectDelay)
+	reconnectDelay = 10 * time.Millisecond
+
+	server := newFakeServer(t, "")
+	conn, err := Connect(server.url("token@"), "file-meta", nil, 5*time.Second, logger.New("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := server.connects[0]["auth_token"]; got != "token" {
+		t.Errorf("auth_token = %v, want token", got)
+	}
+
+	received := make(chan Msg, 1)
+	if err := conn.Subscribe("jobs", "", func(msg Msg) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	server.dropAll()
+
+	// The subscription is restored once the client reconnects
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if conn.Publish("jobs", []byte("after")) == nil {
+			select {
+			case msg := <-received:
+				if string(msg.Data) != "after" {
+					t.Errorf("received %q, want %q", msg.Data, "after")
+				}
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no message received after reconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		subject   string
+		jetStream bool
+		want      int
+	}{
+		{"core", "results", false, 2},
+		{"jetstream", "results", true, 2},
+		{"jetstream without a stream", "other", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, "results")
+			conn, err := Connect(server.url(""), "file-meta", nil, 5*time.Second, logger.New("error"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			stored := make(chan Msg, 2)
+			if err := conn.Subscribe(tt.subject+".*", "", func(msg Msg) { stored <- msg }); err != nil {
+				t.Fatal(err)
+			}
+
+			publisher, err := NewPublisher(conn, tt.subject, tt.jetStream, 10, time.Second, logger.New("error"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := messagesPublished.Value()
+			publisher.Publish([]byte("abc"), []byte(`{"n":1}`))
+			publisher.Publish([]byte("def"), []byte(`{"n":2}`))
+			if err := publisher.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := int(messagesPublished.Value() - before); got != tt.want {
+				t.Errorf("published %d messages, want %d", got, tt.want)
+			}
+			msg := <-stored
+			if msg.Subject != tt.subject+".abc" || string(msg.Data) != `{"n":1}` {
+				t.Errorf("first message = %s %s, want %s.abc", msg.Subject, msg.Data, tt.subject)
+			}
+			if publisher.Publish([]byte("ghi"), nil) {
+				t.Error("Publish() after Close() = true, want false")
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
+)
+
+var (
+	messagesPublished = metrics.NewCounter("file_meta_nats_messages_published_total",
+		"Results published to NATS")
+	messagesDropped = metrics.NewCounter("file_meta_nats_messages_dropped_total",
+		"Results dropped because the NATS buffer was full or the publish failed")
+)
+
+// pubAck is JetStream's reply to a publish on a stream's subject
+type pubAck struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// Publisher publishes messages to subject.<key> in the background. Publish
+// never blocks. With JetStream each publish waits for the stream's ack, so
+// a message the stream didn't store counts as dropped.
+type Publisher struct {
+	conn      *Conn
+	subject   string
+	jetStream bool
+	timeout   time.Duration
+	log       *logger.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan message
+	done   chan struct{}
+}
+
+type message struct {
+	key, value []byte
+}
+
+// NewPublisher starts publishing to subject over conn, buffering up to
+// bufferSize messages. Close it to flush them.
+func NewPublisher(conn *Conn, subject string, jetStream bool, bufferSize int, timeout time.Duration, log *logger.Logger) (*Publisher, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("subject is required")
+	}
+	if bufferSize <= 0 || timeout <= 0 {
+		return nil, fmt.Errorf("buffer size and timeout must be positive")
+	}
+
+	p := &Publisher{
+		conn:      conn,
+		subject:   subject,
+		jetStream: jetStream,
+		timeout:   timeout,
+		log:       log,
+		queue:     make(chan message, bufferSize),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Publish queues a message. It returns false, dropping the message, when
+// the buffer is full or the publisher is closed.
+func (p *Publisher) Publish(key, value []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		messagesDropped.Inc()
+		return false
+	}
+	select {
+	case p.queue <- message{key: key, value: value}:
+		return true
+	default:
+		messagesDropped.Inc()
+		return false
+	}
+}
+
+// Close stops accepting messages and waits until those buffered are sent,
+// or ctx is done. It leaves the connection open.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run publishes queued messages until the queue is closed
+func (p *Publisher) run() {
+	defer close(p.done)
+	for m := range p.queue {
+		subject := p.subject
+		if len(m.key) > 0 {
+			subject += "." + string(m.key)
+		}
+		if err := p.send(subject, m.value); err != nil {
+			messagesDropped.Inc()
+			p.log.Errorf("Dropped result for NATS subject %s: %v", subject, err)
+			continue
+		}
+		messagesPublished.Inc()
+	}
+}
+
+func (p *Publisher) send(subject string, data []byte) error {
+	if !p.jetStream {
+		return p.conn.Publish(subject, data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	reply, err := p.conn.Request(ctx, subject, data)
+	if err != nil {
+		return err
+	}
+	var ack pubAck
+	if err := json.Unmarshal(reply, &ack); err != nil {
+		return fmt.Errorf("invalid JetStream ack %q", reply)
+	}
+	if ack.Error != nil {
+		return fmt.Errorf("JetStream error %d: %s", ack.Error.Code, ack.Error.Description)
+	}
+	if ack.Stream == "" {
+		return fmt.Errorf("no JetStream stream stored the message")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package oauth issues and verifies the short-lived access tokens of the
+// OAuth 2.0 client credentials grant, as JWTs signed with HMAC-SHA256
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, not signed
+// with the secret or expired
+var ErrInvalidToken = errors.New("invalid access token")
+
+// issuer is the iss claim of every token
+const issuer = "file-meta"
+
+// header is the JOSE header of every token; Verify accepts no other
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// claims are what a token says about its client
+type claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Key       string `json:"key"` // fingerprint of the API key the client authenticated with
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Issue returns a token for clientID that expires after ttl. key
+// identifies the API key the client authenticated with, so that the token
+// stops working with the key.
+func Issue(secret []byte, clientID, key string, ttl time.Duration, now time.Time) (string, error) {
+	payload, err := json.Marshal(claims{
+		Issuer:    issuer,
+		Subject:   clientID,
+		Key:       key,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + sign(secret, signed), nil
+}
+
+// Verify returns the client ID token was issued to and the key it was
+// issued for, or ErrInvalidToken
+func Verify(secret []byte, token string, now time.Time) (clientID, key string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return "", "", ErrInvalidToken
+	}
+	signed := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(secret, signed))) {
+		return "", "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return "", "", ErrInvalidToken
+	}
+	if c.Issuer != issuer || c.Subject == "" || c.Key == "" || now.Unix() >= c.ExpiresAt {
+		return "", "", ErrInvalidToken
+	}
+	return c.Subject, c.Key, nil
+}
+
+// sign returns the base64url HMAC-SHA256 of signed
+func sign(secret []byte, signed string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package oauth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1_700_000_000, 0)
+	token, err := Issue(secret, "reporting", "f1", time.Hour, now)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	// A token whose payload claims another client, keeping the signature
+	parts := strings.Split(token, ".")
+	forged, _ := Issue(secret, "billing", "f1", time.Hour, now)
+	forged = parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		token   string
+		now     time.Time
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", secret: secret, token: token, now: now, want: "reporting"},
+		{name: "just before expiry", secret: secret, token: token, now: now.Add(time.Hour - time.Second), want: "reporting"},
+		{name: "expired", secret: secret, token: token, now: now.Add(time.Hour), wantErr: true},
+		{name: "other secret", secret: []byte("another secret of thirty-two b!"), token: token, now: now, wantErr: true},
+		{name: "forged payload", secret: secret, token: forged, now: now, wantErr: true},
+		{name: "unsigned", secret: secret, token: parts[0] + "." + parts[1] + ".", now: now, wantErr: true},
+		{name: "garbage", secret: secret, token: "sk_live_123", now: now, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, key, err := Verify(tt.secret, tt.token, tt.now)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("Verify() error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want || key != "f1" {
+				t.Errorf("Verify() = %q, %q, %v, want %q, f1", got, key, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package openapi
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+type testBase struct {
+	ID string `json:"id"`
+}
+
+type testNode struct {
+	testBase
+	Name     string         `json:"name"`
+	Count    int64          `json:"count,omitempty"`
+	Score    float64        `json:"score"`
+	Tags     []string       `json:"tags,omitempty"`
+	Labels   map[string]int `json:"labels,omitempty"`
+	Children []*testNode    `json:"children,omitempty"`
+	Created  time.Time      `json:"created"`
+	Raw      []byte         `json:"raw,omitempty"`
+	Ignored  string         `json:"-"`
+	Untagged bool
+	hidden   string
+	Extra    map[string]string `json:"extra,omitempty"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"})
+
+	ref := doc.SchemaFor(&testNode{})
+	if ref.Ref != "#/components/schemas/testNode" {
+		t.Fatalf("SchemaFor() = %+v, want a reference to testNode", ref)
+	}
+
+	schema := doc.Components.Schemas["testNode"]
+	if schema == nil || schema.Type != "object" {
+		t.Fatalf("components.schemas.testNode = %+v, want an object", schema)
+	}
+
+	tests := []struct {
+		property       string
+		expectedType   string
+		expectedFormat string
+	}{
+		{property: "id", expectedType: "string"},
+		{property: "name", expectedType: "string"},
+		{property: "count", expectedType: "integer", expectedFormat: "int64"},
+		{property: "score", expectedType: "number", expectedFormat: "double"},
+		{property: "tags", expectedType: "array"},
+		{property: "labels", expectedType: "object"},
+		{property: "children", expectedType: "array"},
+		{property: "created", expectedType: "string", expectedFormat: "date-time"},
+		{property: "raw", expectedType: "string", expectedFormat: "byte"},
+		{property: "Untagged", expectedType: "boolean"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.property, func(t *testing.T) {
+			property, ok := schema.Properties[tt.property]
+			if !ok {
+				t.Fatalf("property %q missing from %v", tt.property, schema.Properties)
+			}
+			if property.Type != tt.expectedType || property.Format != tt.expectedFormat {
+				t.Errorf("property %q = %+v, want type %q format %q", tt.property, property, tt.expectedType, tt.expectedFormat)
+			}
+		})
+	}
+
+	for _, name := range []string{"-", "Ignored", "hidden", "testBase"} {
+		if _, ok := schema.Properties[name]; ok {
+			t.Errorf("property %q should not be in the schema", name)
+		}
+	}
+
+	// Recursive types refer back to themselves
+	if items := schema.Properties["children"].Items; items == nil || items.Ref != ref.Ref {
+		t.Errorf("children.items = %+v, want %s", items, ref.Ref)
+	}
+
+	if labels := schema.Properties["labels"].AdditionalProperties; labels == nil || labels.Type != "integer" {
+		t.Errorf("labels.additionalProperties = %+v, want integer", labels)
+	}
+
+	for _, name := range []string{"id", "name", "score", "created", "Untagged"} {
+		if !slices.Contains(schema.Required, name) {
+			t.Errorf("required = %v, want %q", schema.Required, name)
+		}
+	}
+	if slices.Contains(schema.Required, "count") {
+		t.Errorf("required = %v, omitempty fields are optional", schema.Required)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema(testNode{}, "/test/schema", "testNode")
+
+	if schema.Dialect != JSONSchemaDialect || schema.ID != "/test/schema" || schema.Title != "testNode" {
+		t.Errorf("JSONSchema() = %+v, want the dialect, id and title set", schema)
+	}
+	if schema.Ref != "#/$defs/testNode" {
+		t.Fatalf("$ref = %q, want #/$defs/testNode", schema.Ref)
+	}
+
+	node := schema.Defs["testNode"]
+	if node == nil || node.Type != "object" {
+		t.Fatalf("$defs.testNode = %+v, want an object", node)
+	}
+	if items := node.Properties["children"].Items; items == nil || items.Ref != "#/$defs/testNode" {
+		t.Errorf("children.items = %+v, want a reference into $defs", items)
+	}
+	if node.Dialect != "" || node.ID != "" {
+		t.Errorf("$defs.testNode = %+v, want no $schema or $id", node)
+	}
+}
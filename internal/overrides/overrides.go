@@ -0,0 +1,154 @@
+// Package overrides keeps settings of individual API keys that replace the
+// process-wide configuration for their requests. Keys are identified by
+// history.KeyID, never stored themselves.
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"file-meta/internal/metadata"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Overrides are an API key's own settings. Zero values leave the
+// process-wide setting, or the key's tier, in place.
+type Overrides struct {
+	// MaxFileSizeMB replaces the upload size limit
+	MaxFileSizeMB int64 `json:"max_file_size_mb,omitempty"`
+	// Modules are the only extraction modules that run, whatever the
+	// request's profile, include and exclude
+	Modules []string `json:"modules,omitempty"`
+	// ResultRetentionSeconds replaces RESULT_CACHE_TTL for results the key
+	// extracts
+	ResultRetentionSeconds int64 `json:"result_retention_seconds,omitempty"`
+	// PrivacyMode replaces PRIVACY_MODE when set
+	PrivacyMode *bool `json:"privacy_mode,omitempty"`
+}
+
+// ResultRetention returns how long results the key extracts are cached, or
+// zero for the default
+func (o *Overrides) ResultRetention() time.Duration {
+	return time.Duration(o.ResultRetentionSeconds) * time.Second
+}
+
+// Validate checks that the overrides can be applied
+func (o *Overrides) Validate() error {
+	if o.MaxFileSizeMB < 0 {
+		return fmt.Errorf("max_file_size_mb cannot be negative")
+	}
+	if o.ResultRetentionSeconds < 0 {
+		return fmt.Errorf("result_retention_seconds cannot be negative")
+	}
+	for _, module := range o.Modules {
+		if !slices.Contains(metadata.Modules, module) {
+			return fmt.Errorf("unknown module %q", module)
+		}
+	}
+	return nil
+}
+
+// clone returns a copy of o that shares no memory with it
+func (o Overrides) clone() Overrides {
+	o.Modules = slices.Clone(o.Modules)
+	if o.PrivacyMode != nil {
+		privacy := *o.PrivacyMode
+		o.PrivacyMode = &privacy
+	}
+	return o
+}
+
+// MemoryStore keeps overrides in this process only
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Overrides
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Overrides)}
+}
+
+// Get returns the overrides of the API key with keyID, or nil if it has
+// none
+func (s *MemoryStore) Get(ctx context.Context, keyID string) (*Overrides, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.keys[keyID]
+	if !ok {
+		return nil, nil
+	}
+	o = o.clone()
+	return &o, nil
+}
+
+// Put replaces the overrides of the API key with keyID
+func (s *MemoryStore) Put(ctx context.Context, keyID string, o Overrides) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = o.clone()
+	return nil
+}
+
+// Delete removes the overrides of the API key with keyID
+func (s *MemoryStore) Delete(ctx context.Context, keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, keyID)
+	return nil
+}
+
+// RedisStore keeps overrides as JSON in Redis keys named
+// "<prefix>:<key ID>", so every instance applies the same ones
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a Redis-backed store
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Get returns the overrides of the API key with keyID, or nil if it has
+// none
+func (s *RedisStore) Get(ctx context.Context, keyID string) (*Overrides, error) {
+	data, err := s.client.Get(ctx, s.prefix+":"+keyID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+
+	var o Overrides
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("failed to decode overrides: %w", err)
+	}
+	return &o, nil
+}
+
+// Put replaces the overrides of the API key with keyID
+func (s *RedisStore) Put(ctx context.Context, keyID string, o Overrides) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("failed to encode overrides: %w", err)
+	}
+	if err := s.client.Set(ctx, s.prefix+":"+keyID, data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to write overrides: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the overrides of the API key with keyID
+func (s *RedisStore) Delete(ctx context.Context, keyID string) error {
+	if err := s.client.Del(ctx, s.prefix+":"+keyID).Err(); err != nil {
+		return fmt.Errorf("failed to delete overrides: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package overrides
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	if o, err := s.Get(ctx, "alice"); o != nil || err != nil {
+		t.Fatalf("Get() before Put = %v, %v, want nil", o, err)
+	}
+
+	want := Overrides{MaxFileSizeMB: 100, Modules: []string{metadata.ModuleImage}, ResultRetentionSeconds: 3600}
+	s.Put(ctx, "alice", want)
+	got, err := s.Get(ctx, "alice")
+	if err != nil || !reflect.DeepEqual(*got, want) {
+		t.Fatalf("Get() = %+v, %v, want %+v", got, err, want)
+	}
+	if got.ResultRetention() != time.Hour {
+		t.Errorf("ResultRetention() = %v, want 1h", got.ResultRetention())
+	}
+
+	// Callers can't change what's stored
+	got.Modules[0] = metadata.ModuleAudio
+	if again, _ := s.Get(ctx, "alice"); again.Modules[0] != metadata.ModuleImage {
+		t.Error("changing a returned module changed the stored overrides")
+	}
+
+	s.Delete(ctx, "alice")
+	if o, _ := s.Get(ctx, "alice"); o != nil {
+		t.Errorf("Get() after Delete = %+v, want nil", o)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		o       Overrides
+		wantErr bool
+	}{
+		{"empty", Overrides{}, false},
+		{"all set", Overrides{MaxFileSizeMB: 5, Modules: []string{metadata.ModuleImage}, ResultRetentionSeconds: 60}, false},
+		{"negative size", Overrides{MaxFileSizeMB: -1}, true},
+		{"negative retention", Overrides{ResultRetentionSeconds: -1}, true},
+		{"unknown module", Overrides{Modules: []string{"telepathy"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.o.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
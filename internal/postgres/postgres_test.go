@@ -0,0 +1,362 @@
+package postgres
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer authenticates clients with auth and answers statements:
+// "SELECT" with one row echoing the parameters, "FAIL" with an error and
+// "SLEEP" not at all
+type fakeServer struct {
+	t        *testing.T
+	listener net.Listener
+	auth     string // "trust", "password" or "md5"
+	password string
+
+	mu         sync.Mutex
+	startups   []map[string]string
+	statements []string
+}
+
+func newFakeServer(t *testing.T, auth, password string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, listener: listener, auth: auth, password: password}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) url(userinfo string) string {
+	return "postgres://" + userinfo + s.listener.Addr().String() + "/files?sslmode=prefer"
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	params, ok := s.readStartup(conn, br)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.startups = append(s.startups, params)
+	s.mu.Unlock()
+
+	switch s.auth {
+	case "password", "md5":
+		salt := []byte{1, 2, 3, 4}
+		want := s.password
+		if s.auth == "md5" {
+			writeFake(conn, 'R', append([]byte{0, 0, 0, 5}, salt...))
+			want = md5Password(params["user"], s.password, salt)
+		} else {
+			writeFake(conn, 'R', []byte{0, 0, 0, 3})
+		}
+		typ, payload, err := readFake(br)
+		if err != nil || typ != 'p' || string(payload) != want+"\x00" {
+			writeFake(conn, 'E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+			return
+		}
+	}
+	writeFake(conn, 'R', []byte{0, 0, 0, 0})
+	writeFake(conn, 'S', []byte("server_version\x0016.0\x00"))
+	writeFake(conn, 'Z', []byte{'I'})
+
+	var statement string
+	var args [][]byte
+	for {
+		typ, payload, err := readFake(br)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 'Q':
+			statement, args = strings.TrimSuffix(string(payload), "\x00"), nil
+			s.answer(conn, statement, nil)
+			writeFake(conn, 'Z', []byte{'I'})
+		case 'P':
+			statement = string(payload[1 : len(payload)-3])
+		case 'B':
+			args = parseBind(payload)
+		case 'E':
+			writeFake(conn, '1', nil)
+			writeFake(conn, '2', nil)
+			s.answer(conn, statement, args)
+		case 'S':
+			writeFake(conn, 'Z', []byte{'I'})
+		case 'X':
+			return
+		}
+	}
+}
+
+func (s *fakeServer) readStartup(conn net.Conn, br *bufio.Reader) (map[string]string, bool) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, false
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[:4])-8)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return nil, false
+		}
+		if binary.BigEndian.Uint32(header[4:]) == sslRequestCode {
+			conn.Write([]byte{'N'})
+			continue
+		}
+		params := make(map[string]string)
+		fields := strings.Split(string(payload), "\x00")
+		for i := 0; i+1 < len(fields); i += 2 {
+			params[fields[i]] = fields[i+1]
+		}
+		return params, true
+	}
+}
+
+func (s *fakeServer) answer(conn net.Conn, statement string, args [][]byte) {
+	s.mu.Lock()
+	s.statements = append(s.statements, statement)
+	s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(statement, "SELECT"):
+		row := binary.BigEndian.AppendUint16(nil, uint16(len(args)))
+		for _, arg := range args {
+			if arg == nil {
+				row = binary.BigEndian.AppendUint32(row, 0xFFFFFFFF)
+				continue
+			}
+			row = binary.BigEndian.AppendUint32(row, uint32(len(arg)))
+			row = append(row, arg...)
+		}
+		writeFake(conn, 'T', []byte{0, 0})
+		writeFake(conn, 'D', row)
+		writeFake(conn, 'C', []byte("SELECT 1\x00"))
+	case strings.HasPrefix(statement, "FAIL"):
+		writeFake(conn, 'E', []byte("SERROR\x00C42P01\x00Mrelation \"missing\" does not exist\x00\x00"))
+	case strings.HasPrefix(statement, "SLEEP"):
+		time.Sleep(time.Second)
+	default:
+		writeFake(conn, 'C', []byte("OK\x00"))
+	}
+}
+
+func parseBind(payload []byte) [][]byte {
+	// Unnamed portal and statement, then no format codes
+	payload = payload[4:]
+	args := make([][]byte, binary.BigEndian.Uint16(payload))
+	payload = payload[2:]
+	for i := range args {
+		size := int32(binary.BigEndian.Uint32(payload))
+		payload = payload[4:]
+		if size < 0 {
+			continue
+		}
+		args[i] = payload[:size]
+		payload = payload[size:]
+	}
+	return args
+}
+
+func readFake(br *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err := io.ReadFull(br, payload)
+	return header[0], payload, err
+}
+
+func writeFake(conn net.Conn, typ byte, payload []byte) {
+	msg := []byte{typ}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(4+len(payload)))
+	conn.Write(append(msg, payload...))
+}
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     string
+		userinfo string
+		wantErr  bool
+	}{
+		{"trust", "trust", "app@", false},
+		{"cleartext password", "password", "app:secret@", false},
+		{"md5 password", "md5", "app:secret@", false},
+		{"wrong password", "md5", "app:wrong@", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, tt.auth, "secret")
+			db, err := Open(server.url(tt.userinfo), 2, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			rows, err := db.Query(context.Background(), "SELECT $1, $2, $3, $4", "a.txt", int64(42), nil, when)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != 1 || len(rows[0]) != 4 {
+				t.Fatalf("got rows %q", rows)
+			}
+			want := []string{"a.txt", "42", "", "2024-03-01T12:00:00Z"}
+			for i, column := range rows[0] {
+				if string(column) != want[i] {
+					t.Errorf("column %d = %q, want %q", i, column, want[i])
+				}
+			}
+			if rows[0][2] != nil {
+				t.Error("NULL parameter came back non-nil")
+			}
+			if got := server.startups[0]; got["user"] != "app" || got["database"] != "files" {
+				t.Errorf("startup parameters = %v", got)
+			}
+		})
+	}
+}
+
+func TestServerErrorKeepsConnection(t *testing.T) {
+	server := newFakeServer(t, "trust", "")
+	db, err := Open(server.url(""), 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Exec(context.Background(), "FAIL $1", "x")
+	var serverErr *Error
+	if !errors.As(err, &serverErr) || serverErr.Code != "42P01" {
+		t.Fatalf("got error %v, want SQLSTATE 42P01", err)
+	}
+	if err := db.Exec(context.Background(), "CREATE TABLE t (a int); CREATE INDEX ON t (a)"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.startups) != 1 {
+		t.Errorf("opened %d connections, want 1", len(server.startups))
+	}
+	if len(server.statements) != 2 {
+		t.Errorf("got statements %q", server.statements)
+	}
+}
+
+func TestQueryCancelled(t *testing.T) {
+	server := newFakeServer(t, "trust", "")
+	db, err := Open(server.url(""), 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := db.Exec(ctx, "SLEEP"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+
+	// The abandoned connection is replaced
+	if err := db.Exec(context.Background(), "SELECT"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    connConfig
+		wantErr bool
+	}{
+		{
+			url:  "postgres://app:pw@db.internal:6543/files?sslmode=require",
+			want: connConfig{addr: "db.internal:6543", user: "app", password: "pw", database: "files", sslMode: "require"},
+		},
+		{
+			url:  "postgresql://localhost",
+			want: connConfig{addr: "localhost:5432", user: "postgres", database: "postgres", sslMode: "prefer"},
+		},
+		{
+			url:  "postgres://app@[::1]/?sslmode=disable",
+			want: connConfig{addr: "[::1]:5432", user: "app", database: "app", sslMode: "disable"},
+		},
+		{url: "mysql://localhost/files", wantErr: true},
+		{url: "postgres://localhost/files?sslmode=allow", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := parseURL(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.tls = nil
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSCRAM follows the SCRAM-SHA-256 example in RFC 7677
+func TestSCRAM(t *testing.T) {
+	s := &scramClient{user: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if got := s.first(); got != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("client-first = %q", got)
+	}
+
+	final, err := s.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != want {
+		t.Errorf("client-final = %q, want %q", final, want)
+	}
+
+	if err := s.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := s.verify("v=AAAA"); err == nil {
+		t.Error("accepted a wrong server signature")
+	}
+}
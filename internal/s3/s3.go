@@ -0,0 +1,146 @@
+// Package s3 reads objects from Amazon S3 and S3-compatible stores, with
+// requests signed with AWS Signature Version 4 or authorized by a presigned
+// URL
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"file-meta/internal/sigv4"
+	"file-meta/internal/storage"
+)
+
+// Client reads objects from one region, or from an S3-compatible endpoint.
+// It is the storage.Provider for s3:// references.
+type Client struct {
+	region    string
+	endpoint  *url.URL
+	creds     sigv4.Credentials
+	buckets   map[string]bool
+	chunkSize int64
+	http      *http.Client
+	now       func() time.Time
+}
+
+// NewClient creates a client. An empty endpoint means AWS in region; any
+// other endpoint is addressed path-style. creds are only used for objects in
+// buckets, or in any bucket if buckets is empty. Each ranged GET reads up to
+// chunkSize bytes and is bounded by timeout.
+func NewClient(region, endpoint string, creds sigv4.Credentials, buckets []string, chunkSize int64, timeout time.Duration) (*Client, error) {
+	if region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+	u, err := storage.ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed map[string]bool
+	for _, bucket := range buckets {
+		if allowed == nil {
+			allowed = make(map[string]bool)
+		}
+		allowed[bucket] = true
+	}
+
+	return &Client{
+		region:    region,
+		endpoint:  u,
+		creds:     creds,
+		buckets:   allowed,
+		chunkSize: chunkSize,
+		http:      storage.NewHTTPClient(timeout),
+		now:       time.Now,
+	}, nil
+}
+
+// Open starts reading an object with the configured credentials
+func (c *Client) Open(ctx context.Context, bucket, key string) (*storage.Object, error) {
+	if c.creds.AccessKeyID == "" || c.creds.SecretAccessKey == "" {
+		return nil, storage.ErrNoCredentials
+	}
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("bucket and key are required")
+	}
+	if c.buckets != nil && !c.buckets[bucket] {
+		return nil, storage.ErrBucketNotAllowed
+	}
+	return c.open(ctx, c.objectURL(bucket, key), true, key)
+}
+
+// OpenURL starts reading an object through a presigned URL. Only S3 URLs
+// are accepted: AWS S3 hosts over HTTPS, or the configured endpoint.
+func (c *Client) OpenURL(ctx context.Context, u *url.URL) (*storage.Object, error) {
+	if !c.allowedURL(u) {
+		return nil, storage.ErrURLNotAllowed
+	}
+	return c.open(ctx, u, false, u.Path)
+}
+
+// objectURL addresses an object virtual-hosted style on AWS and path style
+// elsewhere. Buckets with dots are addressed path style so the wildcard
+// certificate still matches.
+func (c *Client) objectURL(bucket, key string) *url.URL {
+	u := &url.URL{Scheme: "https", Host: "s3." + c.region + ".amazonaws.com"}
+	objectPath := "/" + bucket + "/" + key
+	switch {
+	case c.endpoint != nil:
+		u.Scheme = c.endpoint.Scheme
+		u.Host = c.endpoint.Host
+		objectPath = strings.TrimSuffix(c.endpoint.Path, "/") + objectPath
+	case !strings.Contains(bucket, "."):
+		u.Host = bucket + "." + u.Host
+		objectPath = "/" + key
+	}
+	u.Path = objectPath
+	u.RawPath = storage.EscapePath(objectPath)
+	return u
+}
+
+// allowedURL reports whether u points at S3, so presigned URLs can't make
+// the server fetch from arbitrary hosts
+func (c *Client) allowedURL(u *url.URL) bool {
+	if storage.SameEndpoint(u, c.endpoint) {
+		return true
+	}
+	if !storage.HostAllowed(u, "amazonaws.com") {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(u.Hostname(), ".amazonaws.com"), ".") {
+		if label == "s3" || strings.HasPrefix(label, "s3-") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) open(ctx context.Context, u *url.URL, sign bool, name string) (*storage.Object, error) {
+	return storage.OpenRanges(ctx, c.http, c.chunkSize, name, func(ctx context.Context, start, end int64, etag string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		if sign {
+			c.sign(req, c.now())
+		}
+		return req, nil
+	})
+}
+
+// sign adds AWS Signature Version 4 headers to a request without a body
+func (c *Client) sign(req *http.Request, now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.EmptyPayloadHash)
+	sigv4.Sign(req, c.creds, c.region, "s3", sigv4.EmptyPayloadHash, now)
+}
@@ -0,0 +1,267 @@
+// Package sandbox runs extractions in a WebAssembly runtime (wazero) with
+// memory and time limits, so a parser that crashes, hangs or is exploited by
+// a hostile file takes down only its own module instance. The guest is the
+// extractor built for WASI from cmd/sandbox-extractor, which calls Serve.
+// It gets no environment, no network and no filesystem beyond an empty
+// scratch directory of its own, and sees the file only on stdin.
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+
+	"file-meta/internal/metadata"
+	"file-meta/internal/metrics"
+)
+
+// ModuleFile is the name the extractor module is built as
+const ModuleFile = "sandbox-extractor.wasm"
+
+var crashes = metrics.NewCounter("file_meta_sandbox_crashes_total",
+	"Sandboxed extractions whose module instance crashed or hit a limit")
+
+// ErrCrashed is returned when the module instance died instead of answering
+var ErrCrashed = errors.New("sandboxed extractor crashed")
+
+// pageSize is the size of a WebAssembly memory page
+const pageSize = 64 << 10
+
+// Limits bound each module instance. Zero fields are unlimited, up to the
+// 4 GB a WebAssembly instance can address.
+type Limits struct {
+	MemoryBytes uint64
+	Timeout     time.Duration
+}
+
+// request is what the guest is asked to do, passed as its argument
+type request struct {
+	Filename      string                       `json:"filename"`
+	ContentType   string                       `json:"content_type"`
+	Size          int64                        `json:"size"`
+	TLSH          bool                         `json:"tlsh"`
+	Skip          map[string]bool              `json:"skip"`
+	Decompression metadata.DecompressionLimits `json:"decompression"`
+	Memory        metadata.MemoryLimits        `json:"memory"`
+	MemoryBytes   uint64                       `json:"memory_bytes"`
+}
+
+// reply is one line of the guest's output: a progress stage or a module's
+// timing, and finally the result or the error
+type reply struct {
+	Stage  string           `json:"stage,omitempty"`
+	Timing *timing          `json:"timing,omitempty"`
+	Result *metadata.Result `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// timing is a call to metadata.Options.Timing
+type timing struct {
+	Module   string        `json:"module"`
+	MIMEType string        `json:"mime_type"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// Extractor runs each extraction in a new instance of the extractor module
+type Extractor struct {
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	limits  Limits
+}
+
+// New compiles the extractor module at path, which takes a while for a
+// module the size of a Go program, so it's done once. Close releases it.
+func New(ctx context.Context, path string, limits Limits) (*Extractor, error) {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extractor module: %w", err)
+	}
+
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if limits.MemoryBytes > 0 {
+		config = config.WithMemoryLimitPages(uint32(min(limits.MemoryBytes/pageSize, 1<<16)))
+	}
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	module, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to compile extractor module: %w", err)
+	}
+	return &Extractor{runtime: runtime, module: module, limits: limits}, nil
+}
+
+// Close releases the compiled module
+func (e *Extractor) Close(ctx context.Context) error {
+	return e.runtime.Close(ctx)
+}
+
+// Extract extracts file's metadata in a new module instance, with the same
+// results as metadata.ExtractWithOptions. Progress stages and timings are
+// relayed; temporary files the guest needs go to a scratch directory in
+// opts.TempFiles' directory, removed afterwards. The instance is stopped
+// when ctx is done.
+func (e *Extractor) Extract(ctx context.Context, file multipart.File, header *multipart.FileHeader, opts metadata.Options) (*metadata.Result, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind file: %w", err)
+	}
+	arg, err := json.Marshal(request{
+		Filename:      header.Filename,
+		ContentType:   header.Header.Get("Content-Type"),
+		Size:          header.Size,
+		TLSH:          opts.TLSH,
+		Skip:          opts.Skip,
+		Decompression: opts.Decompression,
+		Memory:        opts.Memory,
+		MemoryBytes:   e.limits.MemoryBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var scratchParent string
+	if opts.TempFiles != nil {
+		scratchParent = opts.TempFiles.Dir()
+	}
+	scratch, err := os.MkdirTemp(scratchParent, "file-meta-sandbox-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	runCtx := ctx
+	if e.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, e.limits.Timeout)
+		defer cancel()
+	}
+
+	stdout, guestStdout := io.Pipe()
+	var stderr strings.Builder
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs("sandbox-extractor", string(arg)).
+		WithEnv("TMPDIR", "/tmp").
+		WithFSConfig(wazero.NewFSConfig().WithDirMount(scratch, "/tmp")).
+		WithStdin(file).
+		WithStdout(guestStdout).
+		WithStderr(&stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+
+	done := make(chan error, 1)
+	go func() {
+		instance, err := e.runtime.InstantiateModule(runCtx, e.module, config)
+		if instance != nil {
+			instance.Close(runCtx)
+		}
+		guestStdout.Close()
+		done <- err
+	}()
+
+	var final *reply
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var line reply
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+		if line.Stage != "" {
+			if opts.Progress != nil {
+				opts.Progress(line.Stage)
+			}
+			continue
+		}
+		if line.Timing != nil {
+			if opts.Timing != nil {
+				opts.Timing(line.Timing.Module, line.Timing.MIMEType, line.Timing.Elapsed)
+			}
+			continue
+		}
+		final = &line
+	}
+	io.Copy(io.Discard, stdout)
+	runErr := <-done
+
+	switch {
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case final != nil && final.Error != "":
+		return nil, errors.New(final.Error)
+	case final != nil && final.Result != nil && runErr == nil:
+		return final.Result, nil
+	}
+	crashes.Inc()
+	detail := strings.TrimSpace(stderr.String())
+	if i := strings.IndexByte(detail, '\n'); i >= 0 {
+		// A Go panic's first line says what happened
+		detail = detail[:i]
+	}
+	var exitErr *sys.ExitError
+	switch {
+	case runCtx.Err() != nil:
+		detail = fmt.Sprintf("exceeded the %s limit", e.limits.Timeout)
+	case errors.As(runErr, &exitErr):
+		detail = strings.TrimSpace(fmt.Sprintf("exit code %d: %s", exitErr.ExitCode(), detail))
+	case runErr != nil:
+		detail = strings.TrimSpace(runErr.Error() + ": " + detail)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCrashed, detail)
+}
+
+// Serve runs in the guest: it extracts the content on stdin as arg
+// describes and writes progress and the result to stdout. It returns the
+// exit code.
+func Serve(arg string, stdin io.Reader, stdout io.Writer) int {
+	var req request
+	if err := json.Unmarshal([]byte(arg), &req); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid sandbox request: %v\n", err)
+		return 2
+	}
+	if req.MemoryBytes > 0 {
+		// Collect hard well before the instance's memory runs out
+		debug.SetMemoryLimit(int64(req.MemoryBytes) / 4 * 3)
+	}
+
+	enc := json.NewEncoder(stdout)
+	opts := metadata.Options{
+		TLSH:          req.TLSH,
+		Skip:          req.Skip,
+		Decompression: req.Decompression,
+		Memory:        req.Memory,
+		Progress:      func(stage string) { enc.Encode(reply{Stage: stage}) },
+		Timing: func(module, mimeType string, elapsed time.Duration) {
+			enc.Encode(reply{Timing: &timing{Module: module, MIMEType: mimeType, Elapsed: elapsed}})
+		},
+	}
+
+	info := metadata.FileInfo{Filename: req.Filename, ContentType: req.ContentType, Size: req.Size}
+	result, err := metadata.ExtractStream(context.Background(), stdin, info, opts)
+	if err != nil {
+		enc.Encode(reply{Error: err.Error()})
+		return 1
+	}
+	if err := enc.Encode(reply{Result: result}); err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+// buildGuest builds testdata/guest, the extractor module the tests run, for
+// WASI
+func buildGuest(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go isn't installed to build the extractor module")
+	}
+	path := filepath.Join(t.TempDir(), ModuleFile)
+	cmd := exec.Command(goTool, "build", "-o", path, "./testdata/guest")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building the extractor module: %v\n%s", err, out)
+	}
+	return path
+}
+
+// memoryFile is an upload held in memory
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+func TestExtractor(t *testing.T) {
+	content := []byte("The quick brown fox jumps over the lazy dog.\n")
+	header := &multipart.FileHeader{Filename: "fox.txt", Size: int64(len(content))}
+	header.Header = map[string][]string{"Content-Type": {"text/plain"}}
+
+	path := t.TempDir() + "/fox.txt"
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	onDisk, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer onDisk.Close()
+
+	want, err := metadata.ExtractWithOptions(context.Background(), memoryFile{bytes.NewReader(content)}, header, metadata.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		file     multipart.File
+		limits   Limits
+		timeout  time.Duration
+		wantErr  error
+	}{
+		{"in memory", "fox.txt", memoryFile{bytes.NewReader(content)}, Limits{}, 0, nil},
+		{"on disk", "fox.txt", onDisk, Limits{}, 0, nil},
+		{"crash", "crash.txt", memoryFile{bytes.NewReader(content)}, Limits{}, 0, ErrCrashed},
+		{"hang", "hang.txt", memoryFile{bytes.NewReader(content)}, Limits{}, 200 * time.Millisecond, context.DeadlineExceeded},
+		{"time limit", "hang.txt", memoryFile{bytes.NewReader(content)}, Limits{Timeout: 200 * time.Millisecond}, 0, ErrCrashed},
+		{"memory limit", "oom.txt", memoryFile{bytes.NewReader(content)}, Limits{MemoryBytes: 256 << 20}, 0, ErrCrashed},
+		{"host withheld", path, memoryFile{bytes.NewReader(content)}, Limits{}, 0, nil},
+	}
+
+	module := buildGuest(t)
+	extractors := map[Limits]*Extractor{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractor := extractors[tt.limits]
+			if extractor == nil {
+				var err error
+				extractor, err = New(context.Background(), module, tt.limits)
+				if err != nil {
+					t.Fatal(err)
+				}
+				extractors[tt.limits] = extractor
+			}
+			t.Setenv("API_KEYS", "sk_live_secret")
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			header := &multipart.FileHeader{Filename: tt.filename, Size: int64(len(content)), Header: header.Header}
+			var stages, modules []string
+			opts := metadata.Options{
+				Progress: func(stage string) { stages = append(stages, stage) },
+				Timing:   func(module, mimeType string, elapsed time.Duration) { modules = append(modules, module) },
+			}
+			result, err := extractor.Extract(ctx, tt.file, header, opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if result.SHA256 != want.SHA256 || result.MimeType != want.MimeType || result.Document == nil {
+				t.Errorf("result = %+v, want %+v", result, want)
+			}
+			if len(stages) == 0 || stages[0] != metadata.StageHashed {
+				t.Errorf("stages = %v, want them relayed starting with %s", stages, metadata.StageHashed)
+			}
+			if !slices.Contains(modules, metadata.ModuleDocument) {
+				t.Errorf("timed modules = %v, want %s relayed", modules, metadata.ModuleDocument)
+			}
+		})
+	}
+	for _, extractor := range extractors {
+		extractor.Close(context.Background())
+	}
+}
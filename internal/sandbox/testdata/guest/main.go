@@ -0,0 +1,40 @@
+// Command guest is the extractor module the sandbox tests run: it serves
+// extractions like cmd/sandbox-extractor, but misbehaves when the file is
+// named for it
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"file-meta/internal/sandbox"
+)
+
+func main() {
+	var req struct {
+		Filename string `json:"filename"`
+	}
+	json.Unmarshal([]byte(os.Args[1]), &req)
+	switch {
+	case req.Filename == "crash.txt":
+		panic("parser bug")
+	case req.Filename == "hang.txt":
+		for {
+		}
+	case req.Filename == "oom.txt":
+		var chunks [][]byte
+		for {
+			chunks = append(chunks, make([]byte, 16<<20))
+		}
+	case strings.HasPrefix(req.Filename, "/"):
+		// Named for a file on the host, which the module must not see
+		if _, err := os.ReadFile(req.Filename); err == nil {
+			panic("read " + req.Filename + " on the host")
+		}
+		if os.Getenv("API_KEYS") != "" {
+			panic("inherited API_KEYS")
+		}
+	}
+	os.Exit(sandbox.Serve(os.Args[1], os.Stdin, os.Stdout))
+}
@@ -0,0 +1,135 @@
+// Package sqs receives and deletes messages on an Amazon SQS queue over the
+// JSON protocol, with requests signed with AWS Signature Version 4
+package sqs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"file-meta/internal/sigv4"
+)
+
+// maxWait is the longest long-poll SQS allows
+const maxWait = 20 * time.Second
+
+// Message is a received message. ReceiptHandle deletes it.
+type Message struct {
+	ID            string `json:"MessageId"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+	Body          string `json:"Body"`
+}
+
+// Client talks to one queue
+type Client struct {
+	queueURL string
+	endpoint *url.URL
+	region   string
+	creds    sigv4.Credentials
+	http     *http.Client
+	now      func() time.Time
+}
+
+// NewClient creates a client for the queue at queueURL. Requests go to the
+// queue URL's host, so an SQS-compatible queue (e.g. LocalStack) works
+// too. timeout bounds each request beyond its long-poll wait.
+func NewClient(queueURL, region string, creds sigv4.Credentials, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid queue URL %q", queueURL)
+	}
+	if region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("credentials are required")
+	}
+
+	return &Client{
+		queueURL: queueURL,
+		endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"},
+		region:   region,
+		creds:    creds,
+		http:     &http.Client{Timeout: timeout + maxWait},
+		now:      time.Now,
+	}, nil
+}
+
+// Receive long-polls for up to count messages (at most 10), waiting up to
+// wait (at most 20s) for the first to arrive. Received messages stay
+// hidden from other consumers for visibility, or the queue's default when
+// it is zero, and are redelivered unless deleted.
+func (c *Client) Receive(ctx context.Context, count int, wait, visibility time.Duration) ([]Message, error) {
+	params := map[string]any{
+		"QueueUrl":            c.queueURL,
+		"MaxNumberOfMessages": min(max(count, 1), 10),
+		"WaitTimeSeconds":     int(min(wait, maxWait).Seconds()),
+	}
+	if visibility > 0 {
+		params["VisibilityTimeout"] = int(visibility.Seconds())
+	}
+
+	var out struct {
+		Messages []Message `json:"Messages"`
+	}
+	if err := c.call(ctx, "ReceiveMessage", params, &out); err != nil {
+		return nil, err
+	}
+	return out.Messages, nil
+}
+
+// Delete removes a handled message from the queue
+func (c *Client) Delete(ctx context.Context, receiptHandle string) error {
+	return c.call(ctx, "DeleteMessage", map[string]any{
+		"QueueUrl":      c.queueURL,
+		"ReceiptHandle": receiptHandle,
+	}, nil)
+}
+
+// call sends one JSON protocol action and decodes the response into out
+func (c *Client) call(ctx context.Context, action string, params any, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	hash := sha256.Sum256(body)
+	sigv4.Sign(req, c.creds, c.region, "sqs", hex.EncodeToString(hash[:]), c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Type != "" {
+			return fmt.Errorf("%s failed with status %d: %s: %s", action, resp.StatusCode, apiErr.Type, apiErr.Message)
+		}
+		return fmt.Errorf("%s failed with status %d", action, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", action, err)
+	}
+	return nil
+}
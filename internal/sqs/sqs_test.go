@@ -0,0 +1,90 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/internal/sigv4"
+)
+
+func TestClient(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/sqs/aws4_request") {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		if params["QueueUrl"] != "http://"+r.Host+"/000000000000/uploads" {
+			t.Errorf("QueueUrl = %v", params["QueueUrl"])
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			if params["MaxNumberOfMessages"] != float64(10) || params["WaitTimeSeconds"] != float64(20) || params["VisibilityTimeout"] != float64(60) {
+				t.Errorf("ReceiveMessage params = %v", params)
+			}
+			io.WriteString(w, `{"Messages": [{"MessageId": "m1", "ReceiptHandle": "h1", "Body": "{}"}]}`)
+		case "AmazonSQS.DeleteMessage":
+			if params["ReceiptHandle"] == "gone" {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"__type": "com.amazonaws.sqs#ReceiptHandleIsInvalid", "message": "The receipt handle is not valid"}`)
+				return
+			}
+			deleted = append(deleted, params["ReceiptHandle"].(string))
+			io.WriteString(w, `{}`)
+		default:
+			t.Errorf("unexpected target %q", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL+"/000000000000/uploads", "eu-west-1", sigv4.Credentials{AccessKeyID: "AK", SecretAccessKey: "secret"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := c.Receive(context.Background(), 50, time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != "m1" || messages[0].ReceiptHandle != "h1" || messages[0].Body != "{}" {
+		t.Fatalf("messages = %+v", messages)
+	}
+
+	if err := c.Delete(context.Background(), "h1"); err != nil || len(deleted) != 1 {
+		t.Errorf("Delete() = %v, deleted %v", err, deleted)
+	}
+	err = c.Delete(context.Background(), "gone")
+	if err == nil || !strings.Contains(err.Error(), "ReceiptHandleIsInvalid") {
+		t.Errorf("Delete() of an invalid handle error = %v", err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	creds := sigv4.Credentials{AccessKeyID: "AK", SecretAccessKey: "secret"}
+	tests := []struct {
+		name     string
+		queueURL string
+		creds    sigv4.Credentials
+	}{
+		{"relative URL", "000000000000/uploads", creds},
+		{"other scheme", "sqs://queue", creds},
+		{"no credentials", "https://sqs.us-east-1.amazonaws.com/000000000000/uploads", sigv4.Credentials{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(tt.queueURL, "us-east-1", tt.creds, time.Second); err == nil {
+				t.Error("NewClient() succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+// Package storage reads objects from cloud object stores. Each store is a
+// Provider; objects are read in sequential ranged GETs pinned to the first
+// response's ETag, so large objects stream without one long-lived request
+// and can't change midway.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"file-meta/internal/tracecontext"
+)
+
+// maxErrorSize bounds how much of an error response is read
+const maxErrorSize = 4096
+
+var (
+	// ErrNotFound is returned when the container or object doesn't exist
+	ErrNotFound = errors.New("object not found")
+
+	// ErrAccessDenied is returned when the credentials or presigned URL
+	// don't grant access, or the URL has expired
+	ErrAccessDenied = errors.New("access denied")
+
+	// ErrNoCredentials is returned for container/name references when the
+	// provider has no credentials configured
+	ErrNoCredentials = errors.New("no credentials configured")
+
+	// ErrBucketNotAllowed is returned for container/name references outside
+	// the buckets the provider's credentials may be used for
+	ErrBucketNotAllowed = errors.New("bucket not allowed")
+
+	// ErrURLNotAllowed is returned for URLs that don't point at a
+	// configured provider
+	ErrURLNotAllowed = errors.New("URL is not a storage endpoint")
+
+	// ErrUnknownScheme is returned for references in a scheme no provider
+	// serves
+	ErrUnknownScheme = errors.New("unsupported storage reference")
+
+	// ErrInvalidReference is returned for malformed references
+	ErrInvalidReference = errors.New("invalid storage reference")
+
+	// ErrChanged is returned when the object is replaced while it is read
+	ErrChanged = errors.New("object changed while reading")
+)
+
+// Provider reads objects from one object store
+type Provider interface {
+	// Open starts reading an object by container (bucket) and name (key)
+	// with the provider's credentials
+	Open(ctx context.Context, container, name string) (*Object, error)
+
+	// OpenURL starts reading an object through a presigned or signed URL.
+	// It returns ErrURLNotAllowed unless u points at the provider's store.
+	OpenURL(ctx context.Context, u *url.URL) (*Object, error)
+}
+
+// Providers maps reference schemes, such as "s3" or "gs", to providers
+type Providers map[string]Provider
+
+// Open starts reading the object a reference names: scheme://container/name
+// in a provider's scheme, or an http(s) URL one of the providers accepts
+func (p Providers) Open(ctx context.Context, ref string) (*Object, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidReference, err)
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		schemes := make([]string, 0, len(p))
+		for scheme := range p {
+			schemes = append(schemes, scheme)
+		}
+		slices.Sort(schemes)
+		for _, scheme := range schemes {
+			obj, err := p[scheme].OpenURL(ctx, u)
+			if !errors.Is(err, ErrURLNotAllowed) {
+				return obj, err
+			}
+		}
+		return nil, ErrURLNotAllowed
+	}
+
+	provider, ok := p[u.Scheme]
+	if !ok {
+		return nil, ErrUnknownScheme
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || name == "" {
+		return nil, fmt.Errorf("%w: expected %s://container/name", ErrInvalidReference, u.Scheme)
+	}
+	return provider.Open(ctx, u.Host, name)
+}
+
+// RangeFunc builds the request for bytes start through end of an object.
+// etag is empty for the first range and the object's ETag afterwards; the
+// request should then fail with 412 if the object no longer matches.
+type RangeFunc func(ctx context.Context, start, end int64, etag string) (*http.Request, error)
+
+// Object reads an object's content. The first range has been fetched, so
+// Size and ContentType are known.
+type Object struct {
+	// Name is the last element of the object's name
+	Name string
+
+	// Size is the object's length, or -1 if the store didn't report it
+	Size int64
+
+	ContentType string
+
+	ctx        context.Context
+	client     *http.Client
+	chunkSize  int64
+	newRequest RangeFunc
+	etag       string
+	body       io.ReadCloser
+	offset     int64
+	end        int64
+}
+
+// OpenRanges starts reading an object in ranges of chunkSize bytes. name is
+// the object's full name; Object.Name is its last element.
+func OpenRanges(ctx context.Context, client *http.Client, chunkSize int64, name string, newRequest RangeFunc) (*Object, error) {
+	obj := &Object{
+		Name:       path.Base(name),
+		Size:       -1,
+		ctx:        ctx,
+		client:     client,
+		chunkSize:  chunkSize,
+		newRequest: newRequest,
+	}
+	if err := obj.fetch(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Read reads the object, fetching the next range when the current one is
+// exhausted
+func (o *Object) Read(p []byte) (int, error) {
+	for {
+		if o.body != nil {
+			n, err := o.body.Read(p)
+			o.offset += int64(n)
+			if errors.Is(err, io.EOF) {
+				o.body.Close()
+				o.body = nil
+				if o.end >= 0 && o.offset < o.end {
+					return n, io.ErrUnexpectedEOF
+				}
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+
+		if o.Size < 0 || o.offset >= o.Size {
+			return 0, io.EOF
+		}
+		if err := o.fetch(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close releases the current range's response
+func (o *Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+// fetch requests the range starting at the current offset
+func (o *Object) fetch() error {
+	req, err := o.newRequest(o.ctx, o.offset, o.offset+o.chunkSize-1, o.etag)
+	if err != nil {
+		return err
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != o.offset || (o.Size >= 0 && size != o.Size) {
+			resp.Body.Close()
+			return fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		o.Size = size
+		o.end = end + 1
+	case http.StatusOK:
+		// The whole object, from a store that ignores ranges
+		if o.offset != 0 {
+			resp.Body.Close()
+			return fmt.Errorf("range request for offset %d answered with the whole object", o.offset)
+		}
+		o.Size = resp.ContentLength
+		o.end = resp.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty object has no first byte
+		resp.Body.Close()
+		if o.offset != 0 {
+			return ErrChanged
+		}
+		o.Size = 0
+		return nil
+	default:
+		defer resp.Body.Close()
+		return responseError(resp)
+	}
+
+	if o.etag == "" {
+		o.etag = resp.Header.Get("ETag")
+		o.ContentType = resp.Header.Get("Content-Type")
+	}
+	o.body = resp.Body
+	return nil
+}
+
+// parseContentRange parses "bytes start-end/size"
+func parseContentRange(value string) (start, end, size int64, err error) {
+	if _, err := fmt.Sscanf(value, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, 0, 0, err
+	}
+	if start > end || end >= size {
+		return 0, 0, 0, fmt.Errorf("invalid range")
+	}
+	return start, end, size, nil
+}
+
+// responseError turns an error response into an error. The stores answer
+// with a short XML or JSON document, included in the error.
+func responseError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAccessDenied
+	case http.StatusPreconditionFailed:
+		return ErrChanged
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	if detail := strings.Join(strings.Fields(string(body)), " "); detail != "" {
+		return fmt.Errorf("storage returned status %d: %s", resp.StatusCode, detail)
+	}
+	return fmt.Errorf("storage returned status %d", resp.StatusCode)
+}
+
+// NewHTTPClient returns a client for ranged reads that doesn't follow
+// redirects, which could point a presigned URL anywhere, and passes on the
+// trace context of each request's context
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport:     &tracecontext.Transport{},
+		Timeout:       timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// HostAllowed reports whether u uses HTTPS on the default port and its host
+// is domain or a subdomain of it
+func HostAllowed(u *url.URL, domain string) bool {
+	if u.Scheme != "https" || u.Port() != "" {
+		return false
+	}
+	host := u.Hostname()
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// SameEndpoint reports whether u points at the endpoint's scheme and host
+func SameEndpoint(u, endpoint *url.URL) bool {
+	return endpoint != nil && u.Scheme == endpoint.Scheme && u.Host == endpoint.Host
+}
+
+// ParseEndpoint parses an endpoint override. An empty value returns nil.
+func ParseEndpoint(endpoint string) (*url.URL, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	return u, nil
+}
+
+// EscapePath percent-encodes everything but unreserved characters and
+// slashes
+func EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		ch := p[i]
+		if ch == '/' || ch == '-' || ch == '_' || ch == '.' || ch == '~' ||
+			('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeProvider records what it was asked to open
+type fakeProvider struct {
+	host   string
+	opened string
+}
+
+func (p *fakeProvider) Open(ctx context.Context, container, name string) (*Object, error) {
+	p.opened = container + "/" + name
+	return &Object{Name: name}, nil
+}
+
+func (p *fakeProvider) OpenURL(ctx context.Context, u *url.URL) (*Object, error) {
+	if u.Host != p.host {
+		return nil, ErrURLNotAllowed
+	}
+	p.opened = u.String()
+	return &Object{}, nil
+}
+
+func TestProvidersOpen(t *testing.T) {
+	gs := &fakeProvider{host: "storage.googleapis.com"}
+	s3 := &fakeProvider{host: "bucket.s3.amazonaws.com"}
+	providers := Providers{"gs": gs, "s3": s3}
+
+	tests := []struct {
+		ref      string
+		provider *fakeProvider
+		want     string
+		wantErr  error
+	}{
+		{"gs://bucket/dir/file.txt", gs, "bucket/dir/file.txt", nil},
+		{"s3://bucket/key", s3, "bucket/key", nil},
+		{"https://bucket.s3.amazonaws.com/key?X-Amz-Signature=x", s3, "https://bucket.s3.amazonaws.com/key?X-Amz-Signature=x", nil},
+		{"https://example.com/file", nil, "", ErrURLNotAllowed},
+		{"azure://container/blob", nil, "", ErrUnknownScheme},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			_, err := providers.Open(context.Background(), tt.ref)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.provider != nil && tt.provider.opened != tt.want {
+				t.Errorf("opened %q, want %q", tt.provider.opened, tt.want)
+			}
+		})
+	}
+
+	if _, err := providers.Open(context.Background(), "gs://bucket"); !errors.Is(err, ErrInvalidReference) {
+		t.Errorf("Open() of a reference without a name error = %v, want ErrInvalidReference", err)
+	}
+}
+
+func TestOpenRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		wantErr error
+	}{
+		{
+			name: "empty object",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+			want: "",
+		},
+		{
+			name: "ranges ignored",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "whole object")
+			},
+			want: "whole object",
+		},
+		{
+			name: "replaced midway",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-Match") != "" {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					return
+				}
+				w.Header().Set("ETag", `"1"`)
+				w.Header().Set("Content-Range", "bytes 0-3/10")
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, "abcd")
+			},
+			want:    "abcd",
+			wantErr: ErrChanged,
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "<Error><Code>SlowDown</Code></Error>", http.StatusServiceUnavailable)
+			},
+			wantErr: errors.New("storage returned status 503: <Error><Code>SlowDown</Code></Error>"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			newRequest := func(ctx context.Context, start, end int64, etag string) (*http.Request, error) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+				if err == nil {
+					req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+					if etag != "" {
+						req.Header.Set("If-Match", etag)
+					}
+				}
+				return req, err
+			}
+
+			var data []byte
+			obj, err := OpenRanges(context.Background(), NewHTTPClient(5*time.Second), 4, "dir/file", newRequest)
+			if err == nil {
+				defer obj.Close()
+				data, err = io.ReadAll(obj)
+			}
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("error = %v", err)
+			case tt.wantErr != nil && (err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error())):
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(data) != tt.want {
+				t.Errorf("data = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Package store keeps extraction results addressed by the API key that
+// uploaded the file and its SHA256, so they can be fetched again without
+// re-uploading it. A key never sees another key's results.
+package store
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"file-meta/internal/metadata"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryStore is an in-process store holding at most a fixed number of
+// results. The least recently used result is evicted first, and results
+// expire after the TTL.
+type MemoryStore struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	result  *metadata.Result
+	expires time.Time
+}
+
+// NewMemoryStore creates a store for up to size results. A zero TTL keeps
+// results until they are evicted.
+func NewMemoryStore(size int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Get returns the result stored for sha256 with the API key with keyID, or
+// nil if there is none
+func (s *MemoryStore) Get(ctx context.Context, keyID, sha256 string) (*metadata.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := resultKey(keyID, sha256)
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && !s.now().Before(entry.expires) {
+		s.order.Remove(element)
+		delete(s.entries, key)
+		return nil, nil
+	}
+
+	s.order.MoveToFront(element)
+	return entry.result, nil
+}
+
+// Put stores result under keyID and its SHA256 for ttl, or the store's TTL
+// if zero, replacing any earlier result. The result must not be modified
+// afterwards.
+func (s *MemoryStore) Put(ctx context.Context, keyID string, result *metadata.Result, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+	entry := &memoryEntry{key: resultKey(keyID, result.SHA256), result: result}
+	if ttl > 0 {
+		entry.expires = s.now().Add(ttl)
+	}
+
+	if element, ok := s.entries[entry.key]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+		return nil
+	}
+
+	s.entries[entry.key] = s.order.PushFront(entry)
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of stored results, including expired ones not yet
+// evicted
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// RedisStore keeps results as JSON in Redis keys named
+// "<prefix>:<key ID>:<sha256>", so every instance behind a load balancer
+// shares them
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisStore creates a Redis-backed store. A zero TTL keeps results
+// until Redis evicts them.
+func NewRedisStore(client *redis.Client, prefix string, ttl time.Duration) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix, ttl: ttl}
+}
+
+// Get returns the result stored for sha256 with the API key with keyID, or
+// nil if there is none
+func (s *RedisStore) Get(ctx context.Context, keyID, sha256 string) (*metadata.Result, error) {
+	data, err := s.client.Get(ctx, s.prefix+":"+resultKey(keyID, sha256)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result: %w", err)
+	}
+
+	var result metadata.Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	return &result, nil
+}
+
+// Put stores result under keyID and its SHA256 for ttl, or the store's TTL
+// if zero, replacing any earlier result
+func (s *RedisStore) Put(ctx context.Context, keyID string, result *metadata.Result, ttl time.Duration) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+	if err := s.client.Set(ctx, s.prefix+":"+resultKey(keyID, result.SHA256), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to write result: %w", err)
+	}
+	return nil
+}
+
+// resultKey addresses a result by the API key ID and SHA256
+func resultKey(keyID, sha256 string) string {
+	return keyID + ":" + sha256
+}
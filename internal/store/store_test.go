@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"file-meta/internal/metadata"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(2, time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	a := &metadata.Result{SHA256: "a", Filename: "a.txt"}
+	b := &metadata.Result{SHA256: "b", Filename: "b.txt"}
+	c := &metadata.Result{SHA256: "c", Filename: "c.txt"}
+
+	s.Put(ctx, "alice", a, 0)
+	s.Put(ctx, "alice", b, 0)
+
+	// Reading a makes b the least recently used
+	if got, _ := s.Get(ctx, "alice", "a"); got != a {
+		t.Errorf("Get(a) = %v, want %v", got, a)
+	}
+	s.Put(ctx, "alice", c, 0)
+
+	tests := []struct {
+		sha256 string
+		want   *metadata.Result
+	}{
+		{"a", a},
+		{"b", nil},
+		{"c", c},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		got, err := s.Get(ctx, "alice", tt.sha256)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", tt.sha256, err)
+		}
+		if got != tt.want {
+			t.Errorf("Get(%s) = %v, want %v", tt.sha256, got, tt.want)
+		}
+	}
+
+	// Another key doesn't see alice's results
+	if got, _ := s.Get(ctx, "bob", "a"); got != nil {
+		t.Errorf("Get(bob, a) = %v, want nil", got)
+	}
+
+	// A new result for the same hash replaces the old one
+	a2 := &metadata.Result{SHA256: "a", Filename: "renamed.txt"}
+	s.Put(ctx, "alice", a2, 0)
+	if got, _ := s.Get(ctx, "alice", "a"); got != a2 {
+		t.Errorf("Get(a) = %v, want replacement %v", got, a2)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", s.Len())
+	}
+
+	now = now.Add(time.Hour)
+	if got, _ := s.Get(ctx, "alice", "c"); got != nil {
+		t.Errorf("Get(c) = %v after TTL, want nil", got)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d after expiry, want 1", s.Len())
+	}
+
+	// A result can be kept for less, or longer, than the store's TTL
+	short := &metadata.Result{SHA256: "short"}
+	long := &metadata.Result{SHA256: "long"}
+	s.Put(ctx, "alice", short, time.Minute)
+	s.Put(ctx, "alice", long, 2*time.Hour)
+	now = now.Add(time.Hour)
+	if got, _ := s.Get(ctx, "alice", "short"); got != nil {
+		t.Errorf("Get(short) = %v after its TTL, want nil", got)
+	}
+	if got, _ := s.Get(ctx, "alice", "long"); got != long {
+		t.Errorf("Get(long) = %v before its TTL, want %v", got, long)
+	}
+}
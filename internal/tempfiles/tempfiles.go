@@ -0,0 +1,201 @@
+// Package tempfiles manages the temporary files requests spill to. Files are
+// created in one directory, count against a shared disk quota while open and
+// are removed on Close. Files left behind by a crashed process are swept as
+// orphans once they are old enough.
+package tempfiles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"file-meta/internal/metrics"
+)
+
+// ErrQuotaExceeded is returned by Write when the directory is full
+var ErrQuotaExceeded = errors.New("temporary file quota exceeded")
+
+var (
+	usageBytes = metrics.NewGauge("file_meta_temp_bytes",
+		"Bytes held in managed temporary files")
+	quotaRejections = metrics.NewCounter("file_meta_temp_quota_rejections_total",
+		"Writes rejected because the temporary file quota was full")
+	orphansRemoved = metrics.NewCounter("file_meta_temp_orphans_removed_total",
+		"Orphaned temporary files removed by the sweeper")
+)
+
+// Manager creates and tracks temporary files in a directory. A nil Manager
+// creates untracked files in the system temporary directory.
+type Manager struct {
+	dir   string
+	quota int64
+	now   func() time.Time
+
+	mu    sync.Mutex
+	used  int64
+	files map[string]bool
+}
+
+// New creates a manager for dir, creating it if needed. A zero quota
+// doesn't limit disk use.
+func New(dir string, quota int64) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	return &Manager{
+		dir:   dir,
+		quota: quota,
+		now:   time.Now,
+		files: make(map[string]bool),
+	}, nil
+}
+
+// Dir returns the directory files are created in
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Usage returns the bytes held by open files
+func (m *Manager) Usage() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used
+}
+
+// Create opens a new temporary file named by pattern as in os.CreateTemp
+func (m *Manager) Create(pattern string) (*File, error) {
+	if m == nil {
+		f, err := os.CreateTemp("", pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &File{f: f}, nil
+	}
+
+	f, err := os.CreateTemp(m.dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.files[f.Name()] = true
+	m.mu.Unlock()
+
+	return &File{f: f, m: m}, nil
+}
+
+// Sweep removes files in the directory that no open File owns and that
+// were last modified more than maxAge ago, returning how many it removed.
+// The age keeps it from racing other processes sharing the directory.
+func (m *Manager) Sweep(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list temporary files: %w", err)
+	}
+
+	cutoff := m.now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		path := filepath.Join(m.dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		m.mu.Lock()
+		owned := m.files[path]
+		m.mu.Unlock()
+		if owned {
+			continue
+		}
+
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	orphansRemoved.Add(float64(removed))
+	return removed, nil
+}
+
+// reserve accounts n more bytes against the quota
+func (m *Manager) reserve(n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.quota > 0 && m.used+n > m.quota {
+		quotaRejections.Inc()
+		return ErrQuotaExceeded
+	}
+	m.used += n
+	usageBytes.Add(float64(n))
+	return nil
+}
+
+// release returns a closed file's bytes to the quota
+func (m *Manager) release(name string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.files, name)
+	m.used -= n
+	usageBytes.Add(float64(-n))
+}
+
+// File is a managed temporary file. Writes count against the manager's
+// quota, and Close removes the file.
+type File struct {
+	f      *os.File
+	m      *Manager
+	size   int64
+	closed bool
+}
+
+// Name returns the file's path
+func (f *File) Name() string {
+	return f.f.Name()
+}
+
+// Write appends p, failing with ErrQuotaExceeded if it would go over quota
+func (f *File) Write(p []byte) (int, error) {
+	if f.m != nil {
+		if err := f.m.reserve(int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(len(p))
+	return n, err
+}
+
+// Read implements io.Reader
+func (f *File) Read(p []byte) (int, error) {
+	return f.f.Read(p)
+}
+
+// ReadAt implements io.ReaderAt
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	return f.f.ReadAt(p, off)
+}
+
+// Seek implements io.Seeker
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	return f.f.Seek(offset, whence)
+}
+
+// Close closes and removes the file
+func (f *File) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+
+	err := f.f.Close()
+	os.Remove(f.f.Name())
+	if f.m != nil {
+		f.m.release(f.f.Name(), f.size)
+	}
+	return err
+}
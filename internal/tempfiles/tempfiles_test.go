@@ -0,0 +1,97 @@
+package tempfiles
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManagerQuota(t *testing.T) {
+	m, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := m.Create("a-*")
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	b, _ := m.Create("b-*")
+	if _, err := b.Write([]byte("world!")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Write() over quota error = %v, want ErrQuotaExceeded", err)
+	}
+	if m.Usage() != 5 {
+		t.Errorf("Usage() = %d, want 5", m.Usage())
+	}
+
+	// Closing frees the quota and the disk
+	a.Close()
+	if _, err := b.Write([]byte("world!")); err != nil {
+		t.Errorf("Write() after Close error = %v", err)
+	}
+	if _, err := os.Stat(a.Name()); !os.IsNotExist(err) {
+		t.Errorf("closed file still exists: %v", err)
+	}
+
+	b.Seek(0, io.SeekStart)
+	if got, _ := io.ReadAll(b); string(got) != "world!" {
+		t.Errorf("read back %q, want world!", got)
+	}
+	b.Close()
+	if err := b.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("second Close() error = %v, want os.ErrClosed", err)
+	}
+	if m.Usage() != 0 {
+		t.Errorf("Usage() = %d after closing everything, want 0", m.Usage())
+	}
+}
+
+func TestManagerSweep(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := New(dir, 0)
+
+	now := time.Now()
+	m.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	// An old file nobody owns, one in use, and a fresh one from another process
+	orphan := filepath.Join(dir, "orphan")
+	os.WriteFile(orphan, []byte("left behind"), 0o600)
+	os.Chtimes(orphan, now, now)
+
+	open, _ := m.Create("open-*")
+	defer open.Close()
+	os.Chtimes(open.Name(), now, now)
+
+	fresh := filepath.Join(dir, "fresh")
+	os.WriteFile(fresh, []byte("in use elsewhere"), 0o600)
+	os.Chtimes(fresh, now.Add(90*time.Minute), now.Add(90*time.Minute))
+
+	removed, err := m.Sweep(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Sweep() removed %d files, want 1", removed)
+	}
+	for path, want := range map[string]bool{orphan: false, open.Name(): true, fresh: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", filepath.Base(path), err == nil, want)
+		}
+	}
+}
+
+func TestNilManager(t *testing.T) {
+	var m *Manager
+	f, err := m.Create("file-meta-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("closed file still exists: %v", err)
+	}
+}
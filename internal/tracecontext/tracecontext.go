@@ -0,0 +1,138 @@
+// Package tracecontext carries W3C Trace Context from incoming requests to
+// the outgoing ones they cause, so file-meta shows up in distributed traces
+// as a span of its caller's trace. See https://www.w3.org/TR/trace-context/.
+package tracecontext
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Header names
+const (
+	ParentHeader = "traceparent"
+	StateHeader  = "tracestate"
+)
+
+// maxStateLength is the longest tracestate passed on, as the specification
+// lets vendors truncate longer ones
+const maxStateLength = 512
+
+// Trace is this service's span of a trace
+type Trace struct {
+	TraceID string // 32 lowercase hex digits
+	SpanID  string // 16 lowercase hex digits, identifying this span
+	// ParentID is the caller's span, or empty when the trace started here
+	ParentID string
+	Flags    string // 2 hex digits; 01 means sampled
+	State    string // the caller's tracestate, passed on unchanged
+}
+
+// Start continues the trace of an incoming request's traceparent and
+// tracestate headers in a new span, or starts a new unsampled trace when
+// traceparent is missing or invalid
+func Start(traceparent, tracestate string) Trace {
+	t, ok := parse(traceparent)
+	if !ok {
+		return Trace{TraceID: randomHex(16), SpanID: randomHex(8), Flags: "00"}
+	}
+	if len(tracestate) <= maxStateLength {
+		t.State = strings.TrimSpace(tracestate)
+	}
+	t.ParentID, t.SpanID = t.SpanID, randomHex(8)
+	return t
+}
+
+// parse reads a traceparent header, whose span becomes the Trace's SpanID
+func parse(traceparent string) (Trace, bool) {
+	// version-traceid-parentid-flags; later versions may append fields
+	traceparent = strings.TrimSpace(traceparent)
+	if len(traceparent) < 55 || (len(traceparent) > 55 && traceparent[55] != '-') {
+		return Trace{}, false
+	}
+	version, traceID, spanID, flags := traceparent[0:2], traceparent[3:35], traceparent[36:52], traceparent[53:55]
+	if traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+		return Trace{}, false
+	}
+	if !isHex(version) || version == "ff" || (version == "00" && len(traceparent) != 55) {
+		return Trace{}, false
+	}
+	if !isHex(traceID) || traceID == strings.Repeat("0", 32) || !isHex(spanID) || spanID == strings.Repeat("0", 16) || !isHex(flags) {
+		return Trace{}, false
+	}
+	return Trace{TraceID: traceID, SpanID: spanID, Flags: flags}, true
+}
+
+// isHex reports whether s is made of lowercase hex digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TraceParent returns the traceparent header of requests made in this span
+func (t Trace) TraceParent() string {
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + t.Flags
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying t
+func NewContext(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the trace ctx carries, if any
+func FromContext(ctx context.Context) (Trace, bool) {
+	t, ok := ctx.Value(contextKey{}).(Trace)
+	return t, ok
+}
+
+// Inject sets the trace headers of ctx's trace on h, if ctx has one
+func Inject(ctx context.Context, h http.Header) {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	h.Set(ParentHeader, t.TraceParent())
+	if t.State != "" {
+		h.Set(StateHeader, t.State)
+	} else {
+		h.Del(StateHeader)
+	}
+}
+
+// Transport adds the trace headers of each request's context to it before
+// handing it to Base, or http.DefaultTransport if nil
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if _, ok := FromContext(req.Context()); !ok {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request
+	req = req.Clone(req.Context())
+	Inject(req.Context(), req.Header)
+	return base.RoundTrip(req)
+}
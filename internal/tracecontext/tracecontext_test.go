@@ -0,0 +1,86 @@
+package tracecontext
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		traceparent string
+		tracestate  string
+		// continued is whether the caller's trace is continued
+		continued bool
+		wantState string
+	}{
+		{name: "sampled", traceparent: "00-" + traceID + "-00f067aa0ba902b7-01", tracestate: "congo=t61rcWkgMzE", continued: true, wantState: "congo=t61rcWkgMzE"},
+		{name: "later version with more fields", traceparent: "01-" + traceID + "-00f067aa0ba902b7-00-extra", continued: true},
+		{name: "missing"},
+		{name: "uppercase", traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{name: "zero trace ID", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "zero parent ID", traceparent: "00-" + traceID + "-0000000000000000-01"},
+		{name: "version ff", traceparent: "ff-" + traceID + "-00f067aa0ba902b7-01"},
+		{name: "version 00 with more fields", traceparent: "00-" + traceID + "-00f067aa0ba902b7-01-extra"},
+		{name: "truncated", traceparent: "00-" + traceID + "-00f067aa0ba902b7"},
+		{name: "state without parent", tracestate: "congo=t61rcWkgMzE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Start(tt.traceparent, tt.tracestate)
+			if len(got.TraceID) != 32 || len(got.SpanID) != 16 || !isHex(got.TraceID+got.SpanID) {
+				t.Fatalf("Start() = %+v, want valid IDs", got)
+			}
+			if continued := got.TraceID == traceID && got.ParentID == "00f067aa0ba902b7"; continued != tt.continued {
+				t.Errorf("Start() = %+v, want the caller's trace continued: %v", got, tt.continued)
+			}
+			if got.SpanID == "00f067aa0ba902b7" {
+				t.Error("Start() reused the caller's span ID")
+			}
+			if got.State != tt.wantState {
+				t.Errorf("State = %q, want %q", got.State, tt.wantState)
+			}
+			if _, ok := parse(got.TraceParent()); !ok {
+				t.Errorf("TraceParent() = %q, which doesn't parse", got.TraceParent())
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	trace := Start("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "congo=t61rcWkgMzE")
+	req, _ := http.NewRequestWithContext(NewContext(context.Background(), trace), http.MethodGet, server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got.Get(ParentHeader) != trace.TraceParent() || got.Get(StateHeader) != "congo=t61rcWkgMzE" {
+		t.Errorf("headers = %q, %q, want %q and the caller's state", got.Get(ParentHeader), got.Get(StateHeader), trace.TraceParent())
+	}
+	if req.Header.Get(ParentHeader) != "" {
+		t.Error("RoundTrip() modified the caller's request")
+	}
+
+	// Requests outside a trace go out as they are
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got.Get(ParentHeader) != "" {
+		t.Errorf("traceparent = %q without a trace, want none", got.Get(ParentHeader))
+	}
+}
@@ -0,0 +1,303 @@
+// Package uploads stores resumable uploads on disk while their parts
+// arrive. Each upload is a data file, whose size is the upload offset, and
+// a JSON info file, so a restart loses nothing that was written.
+package uploads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for unknown or expired uploads
+	ErrNotFound = errors.New("upload not found")
+
+	// ErrOffsetMismatch is returned when a part doesn't start at the
+	// current offset
+	ErrOffsetMismatch = errors.New("offset does not match upload")
+
+	// ErrExceedsLength is returned when a part runs past the declared length
+	ErrExceedsLength = errors.New("part exceeds upload length")
+
+	// ErrTooLarge is returned when the declared length is over the limit
+	ErrTooLarge = errors.New("upload too large")
+
+	// ErrIncomplete is returned when opening an upload that is missing parts
+	ErrIncomplete = errors.New("upload incomplete")
+)
+
+// Info describes an upload
+type Info struct {
+	ID       string    `json:"id"`
+	Length   int64     `json:"length"`
+	Offset   int64     `json:"-"`
+	Filename string    `json:"filename,omitempty"`
+	FileType string    `json:"filetype,omitempty"`
+	Owner    string    `json:"owner,omitempty"` // history.KeyID of the API key that created it
+	Expires  time.Time `json:"expires"`
+}
+
+// Complete reports whether every byte has been received
+func (i *Info) Complete() bool {
+	return i.Offset == i.Length
+}
+
+// Store keeps uploads in a directory
+type Store struct {
+	dir     string
+	maxSize int64
+	expiry  time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewStore creates a store in dir for uploads of up to maxSize bytes that
+// expire after expiry
+func NewStore(dir string, maxSize int64, expiry time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &Store{
+		dir:     dir,
+		maxSize: maxSize,
+		expiry:  expiry,
+		now:     time.Now,
+		locks:   make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// MaxSize returns the largest accepted upload length
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Create starts an upload of length bytes for owner
+func (s *Store) Create(length int64, filename, fileType, owner string) (*Info, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid upload length %d", length)
+	}
+	if length > s.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		ID:       id,
+		Length:   length,
+		Filename: filename,
+		FileType: fileType,
+		Owner:    owner,
+		Expires:  s.now().Add(s.expiry),
+	}
+
+	data, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	data.Close()
+
+	if err := s.writeInfo(info); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	return info, nil
+}
+
+// Get returns an upload's info and current offset
+func (s *Store) Get(id string) (*Info, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	raw, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode upload info: %w", err)
+	}
+	if !s.now().Before(info.Expires) {
+		return nil, ErrNotFound
+	}
+
+	stat, err := os.Stat(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	info.Offset = stat.Size()
+	return &info, nil
+}
+
+// Append writes a part starting at offset and returns the new offset. When
+// r fails partway, the bytes already written are kept so the client can
+// resume from the returned offset.
+func (s *Store) Append(id string, offset int64, r io.Reader) (int64, error) {
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	info, err := s.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != info.Offset {
+		return info.Offset, ErrOffsetMismatch
+	}
+
+	data, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return offset, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer data.Close()
+
+	remaining := info.Length - offset
+	n, copyErr := io.Copy(data, io.LimitReader(r, remaining))
+	if copyErr == nil && n == remaining {
+		// Anything past the declared length rejects the whole part
+		var extra [1]byte
+		if m, _ := r.Read(extra[:]); m > 0 {
+			if err := data.Truncate(offset); err != nil {
+				return offset + n, fmt.Errorf("failed to discard part: %w", err)
+			}
+			return offset, ErrExceedsLength
+		}
+	}
+	if copyErr != nil {
+		return offset + n, fmt.Errorf("failed to write part: %w", copyErr)
+	}
+	return offset + n, nil
+}
+
+// Open returns a completed upload for reading
+func (s *Store) Open(id string) (*os.File, *Info, error) {
+	info, err := s.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.Complete() {
+		return nil, info, ErrIncomplete
+	}
+
+	file, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	return file, info, nil
+}
+
+// Delete removes an upload
+func (s *Store) Delete(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	lock := s.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dataErr := os.Remove(s.dataPath(id))
+	infoErr := os.Remove(s.infoPath(id))
+	if errors.Is(dataErr, os.ErrNotExist) && errors.Is(infoErr, os.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Sweep deletes expired uploads and returns how many were removed
+func (s *Store) Sweep() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".info")
+		if !ok || !validID(id) {
+			continue
+		}
+		if _, err := s.Get(id); errors.Is(err, ErrNotFound) {
+			if s.Delete(id) == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+func (s *Store) writeInfo(info *Info) error {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload info: %w", err)
+	}
+	if err := os.WriteFile(s.infoPath(info.ID), raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write upload info: %w", err)
+	}
+	return nil
+}
+
+// lock returns the mutex serializing writes to one upload
+func (s *Store) lock(id string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[id] = lock
+	}
+	return lock
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".info")
+}
+
+// newID returns a random upload ID. IDs are unguessable, so knowing one is
+// what grants access to an upload.
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// validID reports whether id could have come from newID, which keeps IDs
+// from the URL from naming paths outside the store
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
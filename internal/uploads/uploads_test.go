@@ -0,0 +1,106 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Create(101, "big.bin", "", ""); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Create(101) error = %v, want ErrTooLarge", err)
+	}
+
+	info, err := s.Create(11, "hello.txt", "text/plain", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		offset     int64
+		part       io.Reader
+		wantOffset int64
+		wantErr    error
+	}{
+		{"first part", 0, strings.NewReader("hello"), 5, nil},
+		{"stale offset", 0, strings.NewReader("hello"), 5, ErrOffsetMismatch},
+		{"past the length", 5, strings.NewReader(" world and more"), 5, ErrExceedsLength},
+		{"interrupted part", 5, iotest.TimeoutReader(strings.NewReader(" wo")), 8, iotest.ErrTimeout},
+		{"last part", 8, strings.NewReader("rld"), 11, nil},
+	}
+	for _, step := range steps {
+		offset, err := s.Append(info.ID, step.offset, step.part)
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("%s: Append() error = %v, want %v", step.name, err, step.wantErr)
+		}
+		if offset != step.wantOffset {
+			t.Errorf("%s: Append() offset = %d, want %d", step.name, offset, step.wantOffset)
+		}
+
+		if step.name == "past the length" {
+			if _, _, err := s.Open(info.ID); !errors.Is(err, ErrIncomplete) {
+				t.Errorf("Open() error = %v, want ErrIncomplete", err)
+			}
+		}
+	}
+
+	file, got, err := s.Open(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != "hello world" {
+		t.Errorf("content = %q, want %q", content, "hello world")
+	}
+	if got.Filename != "hello.txt" || got.FileType != "text/plain" || got.Owner != "alice" || !got.Complete() {
+		t.Errorf("info = %+v, want alice's complete hello.txt", got)
+	}
+
+	if err := s.Delete(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(info.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get("../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() with a path error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreSweep(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	old, _ := s.Create(10, "old.bin", "", "")
+	now = now.Add(30 * time.Minute)
+	fresh, _ := s.Create(10, "fresh.bin", "", "")
+	now = now.Add(45 * time.Minute)
+
+	removed, err := s.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Sweep() removed %d, want 1", removed)
+	}
+	if _, err := s.Get(old.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(old) error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(fresh.ID); err != nil {
+		t.Errorf("Get(fresh) error = %v", err)
+	}
+}
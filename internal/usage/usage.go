@@ -0,0 +1,312 @@
+// Package usage counts what each API key uses per calendar month (UTC):
+// requests by endpoint and by where they came from, and files extracted and
+// bytes processed by MIME type. Keys are identified by history.KeyID, never stored themselves.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MonthLayout formats the months usage is counted in
+const MonthLayout = "2006-01"
+
+// Month returns the month t falls in
+func Month(t time.Time) string {
+	return t.UTC().Format(MonthLayout)
+}
+
+// Usage is one API key's usage in one month
+type Usage struct {
+	Month     string               `json:"month"`
+	APIKeyID  string               `json:"api_key_id"`
+	Requests  int64                `json:"requests"`
+	Files     int64                `json:"files"`
+	Bytes     int64                `json:"bytes"`
+	Endpoints map[string]int64     `json:"endpoints"`           // requests by route pattern
+	Countries map[string]int64     `json:"countries,omitempty"` // requests by Origin country
+	ASNs      map[string]int64     `json:"asns,omitempty"`      // requests by Origin AS
+	Types     map[string]TypeUsage `json:"types"`               // by MIME type
+}
+
+// Origin is where a request came from, as GeoIP databases tell. Empty
+// fields are unknown and not counted.
+type Origin struct {
+	Country string // ISO country code
+	AS      string // autonomous system, e.g. AS15169
+}
+
+// TypeUsage is the files of one MIME type extracted in a month
+type TypeUsage struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func newUsage(month, keyID string) *Usage {
+	return &Usage{
+		Month:     month,
+		APIKeyID:  keyID,
+		Endpoints: make(map[string]int64),
+		Countries: make(map[string]int64),
+		ASNs:      make(map[string]int64),
+		Types:     make(map[string]TypeUsage),
+	}
+}
+
+// sortUsage orders usage by API key ID
+func sortUsage(all []Usage) {
+	sort.Slice(all, func(i, j int) bool { return all[i].APIKeyID < all[j].APIKeyID })
+}
+
+// MemoryTracker counts usage in process, so each instance counts only its
+// own requests and the counts restart with it
+type MemoryTracker struct {
+	mu     sync.Mutex
+	months map[string]map[string]*Usage // month to key ID to usage
+	now    func() time.Time
+}
+
+// NewMemoryTracker creates an empty in-process tracker
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{months: make(map[string]map[string]*Usage), now: time.Now}
+}
+
+// usage returns the current month's usage for keyID. The lock must be held.
+func (t *MemoryTracker) usage(keyID string) *Usage {
+	month := Month(t.now())
+	keys := t.months[month]
+	if keys == nil {
+		keys = make(map[string]*Usage)
+		t.months[month] = keys
+	}
+	u := keys[keyID]
+	if u == nil {
+		u = newUsage(month, keyID)
+		keys[keyID] = u
+	}
+	return u
+}
+
+// Request counts a request to endpoint made with the API key with keyID
+// from origin
+func (t *MemoryTracker) Request(ctx context.Context, keyID, endpoint string, origin Origin) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.usage(keyID)
+	u.Requests++
+	u.Endpoints[endpoint]++
+	if origin.Country != "" {
+		u.Countries[origin.Country]++
+	}
+	if origin.AS != "" {
+		u.ASNs[origin.AS]++
+	}
+	return nil
+}
+
+// Extraction counts a file of size bytes and type mimeType extracted with
+// the API key with keyID
+func (t *MemoryTracker) Extraction(ctx context.Context, keyID, mimeType string, size int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u := t.usage(keyID)
+	u.Files++
+	u.Bytes += size
+	byType := u.Types[mimeType]
+	byType.Files++
+	byType.Bytes += size
+	u.Types[mimeType] = byType
+	return nil
+}
+
+// Get returns the usage of the API key with keyID in month, which is zero
+// if it wasn't used
+func (t *MemoryTracker) Get(ctx context.Context, keyID, month string) (Usage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u := t.months[month][keyID]; u != nil {
+		return u.copy(), nil
+	}
+	return *newUsage(month, keyID), nil
+}
+
+// All returns the usage of every API key used in month
+func (t *MemoryTracker) All(ctx context.Context, month string) ([]Usage, error) {
+	t.mu.Lock()
+	all := make([]Usage, 0, len(t.months[month]))
+	for _, u := range t.months[month] {
+		all = append(all, u.copy())
+	}
+	t.mu.Unlock()
+	sortUsage(all)
+	return all, nil
+}
+
+func (u *Usage) copy() Usage {
+	c := *u
+	c.Endpoints = make(map[string]int64, len(u.Endpoints))
+	for endpoint, n := range u.Endpoints {
+		c.Endpoints[endpoint] = n
+	}
+	c.Countries = maps.Clone(u.Countries)
+	c.ASNs = maps.Clone(u.ASNs)
+	c.Types = make(map[string]TypeUsage, len(u.Types))
+	for mimeType, byType := range u.Types {
+		c.Types[mimeType] = byType
+	}
+	return c
+}
+
+// RedisTracker counts usage in Redis, shared by every instance. Each key's
+// month is a hash named "<prefix>:<month>:<key ID>", and the set
+// "<prefix>:<month>" lists the key IDs used that month. Both expire after
+// retention.
+type RedisTracker struct {
+	client    *redis.Client
+	prefix    string
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewRedisTracker creates a Redis-backed tracker keeping each month for
+// retention after its last update
+func NewRedisTracker(client *redis.Client, prefix string, retention time.Duration) *RedisTracker {
+	return &RedisTracker{client: client, prefix: prefix, retention: retention, now: time.Now}
+}
+
+// Hash fields: the totals, requests per endpoint as "requests:<endpoint>",
+// per origin as "countries:<country>" and "asns:<AS>", and files and bytes
+// per type as "files:<type>" and "bytes:<type>"
+const (
+	fieldRequests  = "requests"
+	fieldCountries = "countries"
+	fieldASNs      = "asns"
+	fieldFiles     = "files"
+	fieldBytes     = "bytes"
+)
+
+// increment adds to fields of the current month's hash for keyID
+func (t *RedisTracker) increment(ctx context.Context, keyID string, fields map[string]int64) error {
+	month := Month(t.now())
+	hash := t.prefix + ":" + month + ":" + keyID
+	keys := t.prefix + ":" + month
+
+	_, err := t.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for field, n := range fields {
+			pipe.HIncrBy(ctx, hash, field, n)
+		}
+		pipe.SAdd(ctx, keys, keyID)
+		pipe.Expire(ctx, hash, t.retention)
+		pipe.Expire(ctx, keys, t.retention)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count usage: %w", err)
+	}
+	return nil
+}
+
+// Request counts a request to endpoint made with the API key with keyID
+// from origin
+func (t *RedisTracker) Request(ctx context.Context, keyID, endpoint string, origin Origin) error {
+	fields := map[string]int64{
+		fieldRequests:                  1,
+		fieldRequests + ":" + endpoint: 1,
+	}
+	if origin.Country != "" {
+		fields[fieldCountries+":"+origin.Country] = 1
+	}
+	if origin.AS != "" {
+		fields[fieldASNs+":"+origin.AS] = 1
+	}
+	return t.increment(ctx, keyID, fields)
+}
+
+// Extraction counts a file of size bytes and type mimeType extracted with
+// the API key with keyID
+func (t *RedisTracker) Extraction(ctx context.Context, keyID, mimeType string, size int64) error {
+	return t.increment(ctx, keyID, map[string]int64{
+		fieldFiles:                  1,
+		fieldBytes:                  size,
+		fieldFiles + ":" + mimeType: 1,
+		fieldBytes + ":" + mimeType: size,
+	})
+}
+
+// Get returns the usage of the API key with keyID in month, which is zero
+// if it wasn't used
+func (t *RedisTracker) Get(ctx context.Context, keyID, month string) (Usage, error) {
+	fields, err := t.client.HGetAll(ctx, t.prefix+":"+month+":"+keyID).Result()
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to read usage: %w", err)
+	}
+	return *parseFields(month, keyID, fields), nil
+}
+
+// All returns the usage of every API key used in month
+func (t *RedisTracker) All(ctx context.Context, month string) ([]Usage, error) {
+	keyIDs, err := t.client.SMembers(ctx, t.prefix+":"+month).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	cmds, err := t.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, keyID := range keyIDs {
+			pipe.HGetAll(ctx, t.prefix+":"+month+":"+keyID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	all := make([]Usage, 0, len(keyIDs))
+	for i, cmd := range cmds {
+		all = append(all, *parseFields(month, keyIDs[i], cmd.(*redis.MapStringStringCmd).Val()))
+	}
+	sortUsage(all)
+	return all, nil
+}
+
+// parseFields reads a usage hash, skipping fields it doesn't know
+func parseFields(month, keyID string, fields map[string]string) *Usage {
+	u := newUsage(month, keyID)
+	for field, value := range fields {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		name, key, perType := strings.Cut(field, ":")
+		switch {
+		case !perType && name == fieldRequests:
+			u.Requests = n
+		case !perType && name == fieldFiles:
+			u.Files = n
+		case !perType && name == fieldBytes:
+			u.Bytes = n
+		case perType && name == fieldRequests:
+			u.Endpoints[key] = n
+		case perType && name == fieldCountries:
+			u.Countries[key] = n
+		case perType && name == fieldASNs:
+			u.ASNs[key] = n
+		case perType && name == fieldFiles:
+			byType := u.Types[key]
+			byType.Files = n
+			u.Types[key] = byType
+		case perType && name == fieldBytes:
+			byType := u.Types[key]
+			byType.Bytes = n
+			u.Types[key] = byType
+		}
+	}
+	return u
+}
@@ -0,0 +1,110 @@
+package usage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryTracker(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewMemoryTracker()
+	now := time.Date(2024, 3, 31, 23, 59, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Request(ctx, "alice", "/v1/metadata", Origin{Country: "US", AS: "AS15169"})
+	tracker.Request(ctx, "alice", "/v1/metadata/{sha256}", Origin{Country: "US"})
+	tracker.Extraction(ctx, "alice", "image/png", 100)
+	tracker.Extraction(ctx, "alice", "image/png", 50)
+	tracker.Extraction(ctx, "alice", "text/plain", 7)
+	tracker.Request(ctx, "bob", "/v1/metadata", Origin{})
+
+	// The next request falls in April
+	now = now.Add(time.Hour)
+	tracker.Request(ctx, "alice", "/v1/metadata", Origin{})
+
+	march, err := tracker.Get(ctx, "alice", "2024-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Usage{
+		Month:    "2024-03",
+		APIKeyID: "alice",
+		Requests: 2,
+		Files:    3,
+		Bytes:    157,
+		Endpoints: map[string]int64{
+			"/v1/metadata":          1,
+			"/v1/metadata/{sha256}": 1,
+		},
+		Countries: map[string]int64{"US": 2},
+		ASNs:      map[string]int64{"AS15169": 1},
+		Types: map[string]TypeUsage{
+			"image/png":  {Files: 2, Bytes: 150},
+			"text/plain": {Files: 1, Bytes: 7},
+		},
+	}
+	if !reflect.DeepEqual(march, want) {
+		t.Errorf("Get(alice, 2024-03) = %+v, want %+v", march, want)
+	}
+
+	if april, _ := tracker.Get(ctx, "alice", "2024-04"); april.Requests != 1 || april.Files != 0 {
+		t.Errorf("Get(alice, 2024-04) = %+v, want 1 request", april)
+	}
+	if unused, _ := tracker.Get(ctx, "carol", "2024-03"); unused.Requests != 0 || unused.Endpoints == nil || unused.Types == nil {
+		t.Errorf("Get(carol, 2024-03) = %+v, want zero usage", unused)
+	}
+
+	all, err := tracker.All(ctx, "2024-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].APIKeyID != "alice" || all[1].APIKeyID != "bob" || all[1].Requests != 1 {
+		t.Errorf("All(2024-03) = %+v, want alice and bob", all)
+	}
+
+	// Results are copies
+	march.Types["image/png"] = TypeUsage{}
+	if again, _ := tracker.Get(ctx, "alice", "2024-03"); again.Types["image/png"].Files != 2 {
+		t.Error("modifying a returned usage changed the tracker")
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	got := parseFields("2024-03", "alice", map[string]string{
+		"requests":                     "12",
+		"requests:/v1/metadata":        "10",
+		"requests:/v1/history":         "2",
+		"countries:DE":                 "9",
+		"asns:AS3320":                  "4",
+		"files":                        "3",
+		"bytes":                        "157",
+		"files:image/png":              "2",
+		"bytes:image/png":              "150",
+		"files:text/plain":             "1",
+		"bytes:text/plain":             "7",
+		"files:application/x-whatever": "not a number",
+		"unknown":                      "5",
+	})
+	want := &Usage{
+		Month:    "2024-03",
+		APIKeyID: "alice",
+		Requests: 12,
+		Files:    3,
+		Bytes:    157,
+		Endpoints: map[string]int64{
+			"/v1/metadata": 10,
+			"/v1/history":  2,
+		},
+		Countries: map[string]int64{"DE": 9},
+		ASNs:      map[string]int64{"AS3320": 4},
+		Types: map[string]TypeUsage{
+			"image/png":  {Files: 2, Bytes: 150},
+			"text/plain": {Files: 1, Bytes: 7},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFields() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,221 @@
+// Package watch runs extraction on drop folders: it polls directories for
+// new and changed files, extracts metadata for each once it stops changing
+// and hands the result on to a webhook. Polling works the same on every
+// platform and on network mounts, where change notifications don't.
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"file-meta/internal/logger"
+
+	"github.com/google/uuid"
+)
+
+// maxAttempts is how often a file is tried before it's left alone until it
+// changes again
+const maxAttempts = 3
+
+// Notifier receives the result of each extraction; *webhook.Client
+// implements it
+type Notifier interface {
+	Send(ctx context.Context, payload any) error
+}
+
+// ExtractFunc extracts and stores a file's metadata and returns the result
+// as the API would
+type ExtractFunc func(ctx context.Context, requestID, path string) (any, error)
+
+// Event says whether a file is new or replaced one the watcher had
+// processed
+type Event string
+
+const (
+	Created  Event = "created"
+	Modified Event = "modified"
+)
+
+// Extracted is the webhook payload for one file
+type Extracted struct {
+	Path     string    `json:"path"`
+	Event    Event     `json:"event"`
+	Size     int64     `json:"size_bytes"`
+	Modified time.Time `json:"modified_at"`
+	Result   any       `json:"result"`
+}
+
+// state is what a poll sees of a file; a change to either means new content
+type state struct {
+	size    int64
+	modTime time.Time
+}
+
+// file is what the watcher knows about a path
+type file struct {
+	// done is the state last processed, or found at startup
+	done     state
+	hasDone  bool
+	seen     state
+	attempts int
+}
+
+// Watcher polls directories and processes files one at a time
+type Watcher struct {
+	dirs     []string
+	interval time.Duration
+	extract  ExtractFunc
+	notifier Notifier
+	maxSize  int64
+	log      *logger.Logger
+	files    map[string]*file
+}
+
+// NewWatcher creates a watcher for dirs, polled every interval. notifier
+// may be nil. Files larger than maxSize bytes are skipped.
+func NewWatcher(dirs []string, interval time.Duration, extract ExtractFunc, notifier Notifier, maxSize int64, log *logger.Logger) *Watcher {
+	return &Watcher{
+		dirs:     dirs,
+		interval: interval,
+		extract:  extract,
+		notifier: notifier,
+		maxSize:  maxSize,
+		log:      log,
+		files:    make(map[string]*file),
+	}
+}
+
+// Run polls until ctx is done. Files already there when it starts are left
+// alone until they change; a directory scan backfills them.
+func (w *Watcher) Run(ctx context.Context) {
+	w.start()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.poll(ctx)
+		}
+	}
+}
+
+// start records the files already there as processed
+func (w *Watcher) start() {
+	for path, st := range w.list() {
+		w.files[path] = &file{done: st, hasDone: true, seen: st}
+	}
+}
+
+// poll processes the files that are new or changed and have stayed the
+// same since the previous poll, so files still being written are left for
+// later
+func (w *Watcher) poll(ctx context.Context) {
+	current := w.list()
+	for path := range w.files {
+		if _, ok := current[path]; !ok {
+			delete(w.files, path)
+		}
+	}
+
+	for path, st := range current {
+		f, ok := w.files[path]
+		if !ok {
+			w.files[path] = &file{seen: st}
+			continue
+		}
+		if f.seen != st {
+			f.seen = st
+			f.attempts = 0
+			continue
+		}
+		if (f.hasDone && f.done == st) || f.attempts >= maxAttempts {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		event := Created
+		if f.hasDone {
+			event = Modified
+		}
+		if err := w.process(ctx, path, event, st); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			f.attempts++
+			if f.attempts < maxAttempts {
+				w.log.Warnf("Failed to process %s, retrying: %v", w.log.Filename(path), err)
+			} else {
+				w.log.Errorf("Failed to process %s %d times, skipping it until it changes: %v", w.log.Filename(path), f.attempts, err)
+			}
+			continue
+		}
+		f.done, f.hasDone = st, true
+	}
+}
+
+// process extracts one file and notifies the webhook
+func (w *Watcher) process(ctx context.Context, path string, event Event, st state) error {
+	if w.maxSize > 0 && st.size > w.maxSize {
+		w.log.Warnf("Skipping %s: %d bytes is over the size limit", w.log.Filename(path), st.size)
+		return nil
+	}
+
+	id := uuid.New().String()
+	result, err := w.extract(ctx, id, path)
+	if err != nil {
+		return err
+	}
+
+	if w.notifier != nil {
+		err := w.notifier.Send(ctx, Extracted{
+			Path:     path,
+			Event:    event,
+			Size:     st.size,
+			Modified: st.modTime,
+			Result:   result,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.log.Infof("[%s] Extracted metadata for %s (%s)", id, w.log.Filename(path), event)
+	return nil
+}
+
+// list returns every regular file under the watched directories. Symlinks
+// are skipped, so the watcher never leaves them.
+func (w *Watcher) list() map[string]state {
+	files := make(map[string]state)
+	for _, dir := range w.dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == dir {
+					return err
+				}
+				w.log.Warnf("Skipping %s: %v", w.log.Filename(path), err)
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				// Removed since the directory was read
+				return nil
+			}
+			files[path] = state{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			w.log.Warnf("Failed to list %s: %v", dir, err)
+		}
+	}
+	return files
+}
@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"file-meta/internal/logger"
+)
+
+// recorder collects webhook payloads
+type recorder struct {
+	sent []Extracted
+}
+
+func (r *recorder) Send(ctx context.Context, payload any) error {
+	r.sent = append(r.sent, payload.(Extracted))
+	return nil
+}
+
+// write creates or replaces a file with a given modification time
+func write(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := filepath.Join(dir, "old.txt")
+	write(t, old, "already here", base)
+
+	var extracted []string
+	failures := map[string]int{filepath.Join(dir, "flaky.txt"): 1, filepath.Join(dir, "broken.txt"): 10}
+	extract := func(ctx context.Context, requestID, path string) (any, error) {
+		if failures[path] > 0 {
+			failures[path]--
+			return nil, errors.New("extraction failed")
+		}
+		extracted = append(extracted, filepath.Base(path))
+		return map[string]string{"file": filepath.Base(path)}, nil
+	}
+	hook := &recorder{}
+	w := NewWatcher([]string{dir}, time.Second, extract, hook, 100, logger.New("error"))
+	ctx := context.Background()
+
+	// poll runs one polling round and returns what it extracted
+	poll := func() []string {
+		extracted = nil
+		w.poll(ctx)
+		slices.Sort(extracted)
+		return extracted
+	}
+
+	w.start()
+	if got := poll(); len(got) != 0 {
+		t.Fatalf("extracted %v from a folder with no new files", got)
+	}
+
+	write(t, filepath.Join(dir, "new.txt"), "new", base)
+	write(t, filepath.Join(dir, "flaky.txt"), "flaky", base)
+	write(t, filepath.Join(dir, "broken.txt"), "broken", base)
+	write(t, filepath.Join(dir, "large.bin"), string(make([]byte, 200)), base)
+	os.Symlink(old, filepath.Join(dir, "link.txt"))
+	if got := poll(); len(got) != 0 {
+		t.Fatalf("extracted %v before the files settled", got)
+	}
+	if got := poll(); !slices.Equal(got, []string{"new.txt"}) {
+		t.Fatalf("extracted %v once settled, want new.txt", got)
+	}
+	if got := poll(); !slices.Equal(got, []string{"flaky.txt"}) {
+		t.Fatalf("extracted %v on retry, want flaky.txt", got)
+	}
+	if got := poll(); len(got) != 0 {
+		t.Fatalf("extracted %v after giving up on broken.txt", got)
+	}
+
+	// A changed file is extracted again once it settles
+	write(t, old, "replaced", base.Add(time.Minute))
+	write(t, filepath.Join(dir, "new.txt"), "still being written", base.Add(time.Minute))
+	poll()
+	write(t, filepath.Join(dir, "new.txt"), "written", base.Add(2*time.Minute))
+	if got := poll(); !slices.Equal(got, []string{"old.txt"}) {
+		t.Fatalf("extracted %v, want old.txt", got)
+	}
+	if got := poll(); !slices.Equal(got, []string{"new.txt"}) {
+		t.Fatalf("extracted %v, want new.txt", got)
+	}
+
+	var events []string
+	for _, sent := range hook.sent {
+		events = append(events, filepath.Base(sent.Path)+" "+string(sent.Event))
+	}
+	want := []string{"new.txt created", "flaky.txt created", "old.txt modified", "new.txt modified"}
+	if !slices.Equal(events, want) {
+		t.Errorf("webhook got %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,73 @@
+// Package webhook delivers extraction results to an HTTP endpoint
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"file-meta/internal/tracecontext"
+)
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body,
+// keyed with the shared secret, so the receiver can verify the sender
+const SignatureHeader = "X-File-Meta-Signature"
+
+// Client posts JSON payloads to one URL
+type Client struct {
+	url    string
+	secret []byte
+	http   *http.Client
+}
+
+// NewClient creates a client for rawURL. Payloads are signed when secret
+// is set, and each delivery is bounded by timeout.
+func NewClient(rawURL, secret string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	c := &Client{url: rawURL, http: &http.Client{Transport: &tracecontext.Transport{}, Timeout: timeout}}
+	if secret != "" {
+		c.secret = []byte(secret)
+	}
+	return c, nil
+}
+
+// Send posts payload as JSON. Any response other than 2xx is an error.
+func (c *Client) Send(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.secret != nil {
+		mac := hmac.New(sha256.New, c.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook delivery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	status := http.StatusNoContent
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "shared-secret", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(context.Background(), map[string]string{"key": "photo.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"key":"photo.jpg"}` {
+		t.Errorf("body = %s", body)
+	}
+	mac := hmac.New(sha256.New, []byte("shared-secret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	status = http.StatusInternalServerError
+	if err := c.Send(context.Background(), "retry me"); err == nil {
+		t.Error("Send() to a failing receiver succeeded")
+	}
+
+	unsigned, _ := NewClient(server.URL, "", time.Second)
+	status = http.StatusOK
+	if err := unsigned.Send(context.Background(), 1); err != nil || signature != "" {
+		t.Errorf("Send() without a secret = %v, signature %q", err, signature)
+	}
+}
@@ -0,0 +1,362 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455): the opening handshake, fragmented text and binary messages,
+// ping/pong and the closing handshake. Extensions such as compression are
+// not negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"file-meta/internal/models"
+)
+
+// Message types
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+// Close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	ClosePolicyViolation = 1008
+	CloseTooLarge        = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+
+	// maxControlPayload is the largest payload of a control frame
+	maxControlPayload = 125
+
+	// acceptGUID is appended to the client's key to derive the accept key
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// ErrProtocol is returned when the client breaks the framing rules
+var ErrProtocol = errors.New("websocket protocol error")
+
+// CloseError is returned by reads once the client has closed the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// HandshakeError is returned by Upgrade for a request it can't upgrade. No
+// response has been written; the caller answers with Status, Code (one of
+// the models.Code values) and Message.
+type HandshakeError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// IsUpgrade reports whether r asks to switch to the WebSocket protocol
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// A request that can't be upgraded gets a *HandshakeError, which the caller
+// writes as its error response. The server's read and write deadlines are
+// cleared; use SetReadDeadline to bound idle time.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		return nil, &HandshakeError{http.StatusBadRequest, models.CodeBadRequest, "WebSocket upgrade required", errors.New("not a websocket handshake")}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, models.CodeBadRequest, "Unsupported WebSocket version", errors.New("unsupported websocket version")}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{http.StatusBadRequest, models.CodeBadRequest, "Invalid Sec-WebSocket-Key", errors.New("invalid websocket key")}
+	}
+
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, &HandshakeError{http.StatusInternalServerError, models.CodeInternal, "WebSocket upgrade unsupported", fmt.Errorf("failed to hijack connection: %w", err)}
+	}
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: netConn, r: rw.Reader, final: true}, nil
+}
+
+// Conn is an upgraded connection. One goroutine may read while others
+// write.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// Current data frame being read
+	remaining int64
+	final     bool
+	mask      [4]byte
+	maskPos   int
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+// NextMessage waits for the next data message and returns its type and a
+// reader for its payload, which is valid until the next call. Pings are
+// answered and a close frame from the client is acknowledged and returned
+// as a *CloseError.
+func (c *Conn) NextMessage() (int, io.Reader, error) {
+	// Skip whatever is left of the previous message
+	if c.remaining > 0 || !c.final {
+		if _, err := io.Copy(io.Discard, messageReader{c}); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	opcode, err := c.nextDataFrame()
+	if err != nil {
+		return 0, nil, err
+	}
+	if opcode == opContinuation {
+		return 0, nil, c.fail(ErrProtocol)
+	}
+	return opcode, messageReader{c}, nil
+}
+
+// messageReader reads one message across its frames
+type messageReader struct {
+	c *Conn
+}
+
+func (m messageReader) Read(p []byte) (int, error) {
+	c := m.c
+	for c.remaining == 0 {
+		if c.final {
+			return 0, io.EOF
+		}
+		opcode, err := c.nextDataFrame()
+		if err != nil {
+			return 0, err
+		}
+		if opcode != opContinuation {
+			return 0, c.fail(ErrProtocol)
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextDataFrame reads frame headers until a data frame, handling control
+// frames on the way, and returns its opcode
+func (c *Conn) nextDataFrame() (int, error) {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.r, head[:]); err != nil {
+			return 0, err
+		}
+		final := head[0]&0x80 != 0
+		opcode := int(head[0] & 0x0f)
+		if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+			// Reserved bits without an extension, or an unmasked client frame
+			return 0, c.fail(ErrProtocol)
+		}
+
+		length := int64(head[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return 0, err
+			}
+			length = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+				return 0, err
+			}
+			length = int64(binary.BigEndian.Uint64(ext[:]))
+			if length < 0 {
+				return 0, c.fail(ErrProtocol)
+			}
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return 0, err
+		}
+
+		switch opcode {
+		case opContinuation, TextMessage, BinaryMessage:
+			c.remaining, c.final, c.mask, c.maskPos = length, final, mask, 0
+			return opcode, nil
+		case opClose, opPing, opPong:
+			if !final || length > maxControlPayload {
+				return 0, c.fail(ErrProtocol)
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.r, payload); err != nil {
+				return 0, err
+			}
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+			if err := c.control(opcode, payload); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, c.fail(ErrProtocol)
+		}
+	}
+}
+
+// control answers a control frame
+func (c *Conn) control(opcode int, payload []byte) error {
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		closeErr := &CloseError{Code: CloseNormal}
+		if len(payload) >= 2 {
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Reason = string(payload[2:])
+		}
+		c.Close(closeErr.Code, "")
+		return closeErr
+	}
+	return nil
+}
+
+// WriteMessage sends a complete text or binary message
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("invalid message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// SetReadDeadline bounds how long reads wait for the client
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close sends a close frame with code and reason, then closes the
+// connection. Further calls do nothing.
+func (c *Conn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason[:min(len(reason), maxControlPayload-2)]...)
+
+	err := c.writeFrame(opClose, payload)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fail closes the connection after a protocol violation
+func (c *Conn) fail(err error) error {
+	c.Close(CloseProtocolError, "")
+	return err
+}
+
+// writeFrame sends one unmasked, final frame
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// acceptKey derives Sec-WebSocket-Accept from the client's key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains reports whether a comma-separated header lists token
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"file-meta/internal/models"
+)
+
+// client is the client side of a test connection
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, url string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	// Example key and accept value from RFC 6455 section 1.3
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", accept)
+	}
+	return &client{conn: conn, r: r}
+}
+
+// send writes a masked frame
+func (c *client) send(final bool, opcode byte, payload []byte) {
+	head := []byte{opcode, 0x80 | byte(len(payload))}
+	if final {
+		head[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	c.conn.Write(append(append(head, mask...), masked...))
+}
+
+// receive reads an unmasked server frame of up to 125 bytes
+func (c *client) receive(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, head[1]&0x7f)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func TestConn(t *testing.T) {
+	received := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		for {
+			messageType, message, err := conn.NextMessage()
+			if err != nil {
+				var closeErr *CloseError
+				if errors.As(err, &closeErr) {
+					received <- "close"
+				}
+				return
+			}
+			data, _ := io.ReadAll(message)
+			received <- string(data)
+			conn.WriteMessage(messageType, data)
+		}
+	}))
+	defer server.Close()
+
+	c := dial(t, server.URL)
+
+	// A message fragmented around a ping
+	c.send(false, BinaryMessage, []byte("hel"))
+	c.send(true, opPing, []byte("p"))
+	c.send(true, opContinuation, []byte("lo"))
+	if opcode, payload := c.receive(t); opcode != opPong || string(payload) != "p" {
+		t.Errorf("ping answer = %d %q, want pong", opcode, payload)
+	}
+	if got := <-received; got != "hello" {
+		t.Errorf("message = %q, want hello", got)
+	}
+	if opcode, payload := c.receive(t); opcode != BinaryMessage || string(payload) != "hello" {
+		t.Errorf("echo = %d %q, want binary hello", opcode, payload)
+	}
+
+	c.send(true, opClose, binary.BigEndian.AppendUint16(nil, CloseNormal))
+	if got := <-received; got != "close" {
+		t.Errorf("after close frame got %q", got)
+	}
+	if opcode, payload := c.receive(t); opcode != opClose || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("close answer = %d %v, want close 1000", opcode, payload)
+	}
+}
+
+func TestConnProtocolError(t *testing.T) {
+	errs := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_, _, err = conn.NextMessage()
+		errs <- err
+	}))
+	defer server.Close()
+
+	c := dial(t, server.URL)
+	c.conn.Write([]byte{0x82, 0x01, 'x'}) // unmasked client frame
+
+	if err := <-errs; !errors.Is(err, ErrProtocol) {
+		t.Errorf("NextMessage() error = %v, want ErrProtocol", err)
+	}
+	if opcode, payload := c.receive(t); opcode != opClose || binary.BigEndian.Uint16(payload) != CloseProtocolError {
+		t.Errorf("close = %d %v, want close 1002", opcode, payload)
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"plain request", nil, http.StatusBadRequest},
+		{"old version", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"bad key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rr := httptest.NewRecorder()
+			_, err := Upgrade(rr, req)
+			var handshake *HandshakeError
+			if !errors.As(err, &handshake) {
+				t.Fatalf("Upgrade() error = %v, want a HandshakeError", err)
+			}
+			if handshake.Status != tt.wantStatus || handshake.Code != models.CodeBadRequest {
+				t.Errorf("handshake error = %d %s, want %d %s", handshake.Status, handshake.Code, tt.wantStatus, models.CodeBadRequest)
+			}
+			if rr.Body.Len() != 0 {
+				t.Errorf("Upgrade() wrote %q", rr.Body)
+			}
+		})
+	}
+}
+
+func TestWriteMessageLengths(t *testing.T) {
+	server, clientConn := net.Pipe()
+	defer clientConn.Close()
+	conn := &Conn{conn: server, final: true}
+
+	for _, size := range []int{0, 125, 126, 70000} {
+		go conn.WriteMessage(BinaryMessage, bytes.Repeat([]byte{'a'}, size))
+
+		var head [2]byte
+		io.ReadFull(clientConn, head[:])
+		length := int(head[1])
+		switch length {
+		case 126:
+			var ext [2]byte
+			io.ReadFull(clientConn, ext[:])
+			length = int(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			io.ReadFull(clientConn, ext[:])
+			length = int(binary.BigEndian.Uint64(ext[:]))
+		}
+		payload := make([]byte, length)
+		io.ReadFull(clientConn, payload)
+		if length != size || head[0] != 0x82 {
+			t.Errorf("frame for %d bytes: header %x, length %d", size, head, length)
+		}
+	}
+}
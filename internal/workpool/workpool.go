@@ -0,0 +1,154 @@
+// Package workpool bounds how many extractions run at once so a burst of
+// large uploads can't exhaust memory.
+package workpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBusy is returned when no slot frees up within the queue timeout
+var ErrBusy = errors.New("all extraction slots are busy")
+
+// ErrKeyBusy is returned when an API key already runs as many extractions
+// as it may
+var ErrKeyBusy = errors.New("too many extractions running for this API key")
+
+// Pool hands out a fixed number of slots. Callers beyond that wait in line
+// for up to the queue timeout.
+type Pool struct {
+	slots   chan struct{}
+	wait    time.Duration
+	waiting atomic.Int64
+}
+
+// New creates a pool of size slots. A zero wait rejects callers as soon as
+// every slot is taken.
+func New(size int, wait time.Duration) *Pool {
+	return &Pool{slots: make(chan struct{}, size), wait: wait}
+}
+
+// Acquire takes a slot, waiting up to the queue timeout for one to free up.
+// The returned func gives the slot back and must be called exactly once.
+func (p *Pool) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	default:
+	}
+	if p.wait <= 0 {
+		return nil, ErrBusy
+	}
+
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+	timer := time.NewTimer(p.wait)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	case <-timer.C:
+		return nil, ErrBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Wait takes a slot, waiting for as long as ctx allows instead of the queue
+// timeout. It suits background work that no client is blocked on.
+func (p *Pool) Wait(ctx context.Context) (func(), error) {
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Pool) release() {
+	<-p.slots
+}
+
+// Size returns the number of slots
+func (p *Pool) Size() int {
+	return cap(p.slots)
+}
+
+// InUse returns the number of slots currently taken
+func (p *Pool) InUse() int {
+	return len(p.slots)
+}
+
+// Waiting returns the number of callers waiting for a slot
+func (p *Pool) Waiting() int {
+	return int(p.waiting.Load())
+}
+
+// Saturated reports whether every slot is taken and, if callers may wait
+// for one, some already do, so new work would be turned away or queued
+// behind others
+func (p *Pool) Saturated() bool {
+	return p.InUse() >= p.Size() && (p.wait <= 0 || p.Waiting() > 0)
+}
+
+// KeyLimit caps how many extractions each API key runs at once, so one key
+// can't take every slot of a Pool
+type KeyLimit struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewKeyLimit creates a limit of limit extractions per key
+func NewKeyLimit(limit int) *KeyLimit {
+	return &KeyLimit{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// keySlots returns key's slots, creating them on first use
+func (k *KeyLimit) keySlots(key string) chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	slots := k.slots[key]
+	if slots == nil {
+		slots = make(chan struct{}, k.limit)
+		k.slots[key] = slots
+	}
+	return slots
+}
+
+// Acquire takes one of key's slots, failing with ErrKeyBusy at once if all
+// are taken. The returned func gives the slot back and must be called
+// exactly once.
+func (k *KeyLimit) Acquire(key string) (func(), error) {
+	slots := k.keySlots(key)
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	default:
+		return nil, ErrKeyBusy
+	}
+}
+
+// Wait takes one of key's slots, waiting for as long as ctx allows. It
+// suits background work that no client is blocked on.
+func (k *KeyLimit) Wait(ctx context.Context, key string) (func(), error) {
+	slots := k.keySlots(key)
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// InUse returns the number of key's slots currently taken
+func (k *KeyLimit) InUse(key string) int {
+	return len(k.keySlots(key))
+}
@@ -0,0 +1,166 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		held    int
+		wait    time.Duration
+		wantErr error
+	}{
+		{name: "free slot", size: 2, held: 1},
+		{name: "full without queue", size: 1, held: 1, wantErr: ErrBusy},
+		{name: "full after waiting", size: 1, held: 1, wait: 10 * time.Millisecond, wantErr: ErrBusy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := New(tt.size, tt.wait)
+			for i := 0; i < tt.held; i++ {
+				if _, err := pool.Acquire(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			release, err := pool.Acquire(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Acquire() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				release()
+				if pool.InUse() != tt.held {
+					t.Errorf("InUse() = %d after release, want %d", pool.InUse(), tt.held)
+				}
+			}
+		})
+	}
+}
+
+func TestAcquireQueued(t *testing.T) {
+	pool := New(1, time.Second)
+	release, _ := pool.Acquire(context.Background())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if waiting := pool.Waiting(); waiting != 1 {
+			t.Errorf("Waiting() = %d, want 1", waiting)
+		}
+		release()
+	}()
+
+	next, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() error = %v, want a slot once the holder releases", err)
+	}
+	next()
+	if waiting := pool.Waiting(); waiting != 0 {
+		t.Errorf("Waiting() = %d after the slot was taken, want 0", waiting)
+	}
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	pool := New(1, time.Minute)
+	pool.Acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	pool := New(1, 0)
+	release, _ := pool.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	next, err := pool.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("Wait() error = %v, want a slot once the holder releases", err)
+	}
+	next()
+}
+
+func TestSaturated(t *testing.T) {
+	// Without a queue, a full pool turns work away
+	pool := New(1, 0)
+	release, _ := pool.Acquire(context.Background())
+	if !pool.Saturated() {
+		t.Error("Saturated() = false with every slot taken and no queue")
+	}
+	release()
+	if pool.Saturated() {
+		t.Error("Saturated() = true with a free slot")
+	}
+
+	// With one, it is saturated once work waits
+	pool = New(1, time.Second)
+	release, _ = pool.Acquire(context.Background())
+	if pool.Saturated() {
+		t.Error("Saturated() = true with nobody waiting")
+	}
+	done := make(chan struct{})
+	go func() {
+		next, _ := pool.Acquire(context.Background())
+		next()
+		close(done)
+	}()
+	for pool.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if !pool.Saturated() {
+		t.Error("Saturated() = false with work waiting")
+	}
+	release()
+	<-done
+}
+
+func TestKeyLimit(t *testing.T) {
+	limit := NewKeyLimit(2)
+	first, err := limit.Acquire("a")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	second, _ := limit.Acquire("a")
+	if _, err := limit.Acquire("a"); !errors.Is(err, ErrKeyBusy) {
+		t.Errorf("Acquire() past the limit error = %v, want ErrKeyBusy", err)
+	}
+	other, err := limit.Acquire("b")
+	if err != nil {
+		t.Errorf("Acquire() for another key error = %v, want a slot", err)
+	}
+	other()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := limit.Wait(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	first()
+	if got := limit.InUse("a"); got != 1 {
+		t.Errorf("InUse() = %d, want 1", got)
+	}
+	next, err := limit.Wait(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Wait() error = %v, want the released slot", err)
+	}
+	next()
+	second()
+}
@@ -0,0 +1,96 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// backwardReader reads a bitstream from its end towards its start, as
+// Huffman and FSE streams are written. Bits before the start read as
+// zeros, so decoders can peek past it and check overflowed afterwards.
+type backwardReader struct {
+	src []byte
+	pos int // bits left to read
+}
+
+// init starts reading src after the padding and the marker bit that end
+// it
+func (b *backwardReader) init(src []byte) error {
+	if len(src) == 0 || src[len(src)-1] == 0 {
+		return fmt.Errorf("%w: bitstream without end marker", ErrCorrupt)
+	}
+	b.src = src
+	b.pos = (len(src)-1)*8 + bits.Len8(src[len(src)-1]) - 1
+	return nil
+}
+
+// peek returns the next n bits, at most 56, without consuming them
+func (b *backwardReader) peek(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	start := b.pos - n
+	if start >= 0 {
+		return b.extract(start, n)
+	}
+	if n+start <= 0 {
+		return 0
+	}
+	return b.extract(0, n+start) << -start
+}
+
+// read consumes the next n bits, at most 56
+func (b *backwardReader) read(n int) uint64 {
+	v := b.peek(n)
+	b.pos -= n
+	return v
+}
+
+// overflowed reports whether more bits were read than the stream holds
+func (b *backwardReader) overflowed() bool {
+	return b.pos < 0
+}
+
+// extract returns n bits of src from bit start, counting from the least
+// significant bit of src[0]
+func (b *backwardReader) extract(start, n int) uint64 {
+	i := start >> 3
+	var v uint64
+	if i+8 <= len(b.src) {
+		v = binary.LittleEndian.Uint64(b.src[i:])
+	} else {
+		for j := len(b.src) - 1; j >= i; j-- {
+			v = v<<8 | uint64(b.src[j])
+		}
+	}
+	return v >> (start & 7) & (1<<n - 1)
+}
+
+// forwardReader reads bits from the start of src, least significant
+// first, as FSE table descriptions are written
+type forwardReader struct {
+	src []byte
+	pos int // bits read
+}
+
+// read consumes the next n bits, at most 56, reading zeros past the end
+func (f *forwardReader) read(n int) uint64 {
+	v := f.peek(n)
+	f.pos += n
+	return v
+}
+
+func (f *forwardReader) peek(n int) uint64 {
+	i := f.pos >> 3
+	var v uint64
+	for j := min(i+8, len(f.src)) - 1; j >= i; j-- {
+		v = v<<8 | uint64(f.src[j])
+	}
+	return v >> (f.pos & 7) & (1<<n - 1)
+}
+
+// bytesRead returns how many bytes the bits read so far span
+func (f *forwardReader) bytesRead() int {
+	return (f.pos + 7) / 8
+}
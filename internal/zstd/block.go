@@ -0,0 +1,453 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// maxHuffmanBits is the longest Huffman code allowed
+const maxHuffmanBits = 11
+
+// huffmanEntry decodes the code a table index starts with
+type huffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+// decoder holds what compressed blocks of a frame may reuse from the
+// blocks before them
+type decoder struct {
+	huffman     []huffmanEntry // nil until a block describes a tree
+	huffmanBits int
+
+	literalLengths fseTable
+	offsets        fseTable
+	matchLengths   fseTable
+	haveTables     [3]bool // each table was set, for repeat mode
+
+	repeats [3]int
+
+	literals []byte
+	weights  fseTable
+}
+
+// reset prepares the decoder for a new frame
+func (d *decoder) reset() {
+	d.huffman = nil
+	d.haveTables = [3]bool{}
+	d.repeats = [3]int{1, 4, 8}
+}
+
+// decompress decodes a compressed block, appending its content to hist.
+// Matches may refer back up to window bytes.
+func (d *decoder) decompress(src, hist []byte, window int) ([]byte, error) {
+	n, err := d.readLiterals(src)
+	if err != nil {
+		return hist, err
+	}
+	return d.executeSequences(src[n:], hist, window)
+}
+
+// readLiterals decodes the literals section into d.literals and returns
+// its size
+func (d *decoder) readLiterals(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, fmt.Errorf("%w: empty block", ErrCorrupt)
+	}
+	literalsType := src[0] & 3
+	sizeFormat := (src[0] >> 2) & 3
+
+	if literalsType < 2 { // raw or RLE
+		var size, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			size, headerSize = int(src[0]>>3), 1
+		case 1:
+			if len(src) < 2 {
+				return 0, fmt.Errorf("%w: truncated literals header", ErrCorrupt)
+			}
+			size, headerSize = int(src[0]>>4)|int(src[1])<<4, 2
+		case 3:
+			if len(src) < 3 {
+				return 0, fmt.Errorf("%w: truncated literals header", ErrCorrupt)
+			}
+			size, headerSize = int(src[0]>>4)|int(src[1])<<4|int(src[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return 0, fmt.Errorf("%w: too many literals", ErrCorrupt)
+		}
+		if literalsType == 0 {
+			if len(src) < headerSize+size {
+				return 0, fmt.Errorf("%w: truncated literals", ErrCorrupt)
+			}
+			d.literals = append(d.literals[:0], src[headerSize:headerSize+size]...)
+			return headerSize + size, nil
+		}
+		if len(src) < headerSize+1 {
+			return 0, fmt.Errorf("%w: truncated literals", ErrCorrupt)
+		}
+		d.literals = d.literals[:0]
+		for range size {
+			d.literals = append(d.literals, src[headerSize])
+		}
+		return headerSize + 1, nil
+	}
+
+	// Compressed, with a new Huffman tree or the previous one
+	headerSize, sizeBits, streams := 3, 10, 4
+	switch sizeFormat {
+	case 0:
+		streams = 1
+	case 2:
+		headerSize, sizeBits = 4, 14
+	case 3:
+		headerSize, sizeBits = 5, 18
+	}
+	if len(src) < headerSize {
+		return 0, fmt.Errorf("%w: truncated literals header", ErrCorrupt)
+	}
+	var header uint64
+	for i := headerSize - 1; i >= 0; i-- {
+		header = header<<8 | uint64(src[i])
+	}
+	mask := uint64(1)<<sizeBits - 1
+	size := int(header >> 4 & mask)
+	compressedSize := int(header >> (4 + sizeBits) & mask)
+	if size > maxBlockSize || len(src) < headerSize+compressedSize {
+		return 0, fmt.Errorf("%w: invalid literals size", ErrCorrupt)
+	}
+	data := src[headerSize : headerSize+compressedSize]
+
+	if literalsType == 2 {
+		n, err := d.readHuffmanTree(data)
+		if err != nil {
+			return 0, err
+		}
+		data = data[n:]
+	} else if d.huffman == nil {
+		return 0, fmt.Errorf("%w: literals reuse a missing Huffman tree", ErrCorrupt)
+	}
+
+	if cap(d.literals) < size {
+		d.literals = make([]byte, size)
+	}
+	d.literals = d.literals[:size]
+	if streams == 1 {
+		if err := d.decodeHuffman(d.literals, data); err != nil {
+			return 0, err
+		}
+		return headerSize + compressedSize, nil
+	}
+
+	if len(data) < 6 {
+		return 0, fmt.Errorf("%w: truncated jump table", ErrCorrupt)
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(data[0:])),
+		int(binary.LittleEndian.Uint16(data[2:])),
+		int(binary.LittleEndian.Uint16(data[4:])),
+	}
+	data = data[6:]
+	sizes[3] = len(data) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return 0, fmt.Errorf("%w: invalid jump table", ErrCorrupt)
+	}
+	segment := (size + 3) / 4
+	out := d.literals
+	for i, n := range sizes {
+		end := min(segment, len(out))
+		if i == 3 {
+			end = len(out)
+		}
+		if err := d.decodeHuffman(out[:end], data[:n]); err != nil {
+			return 0, err
+		}
+		out, data = out[end:], data[n:]
+	}
+	return headerSize + compressedSize, nil
+}
+
+// readHuffmanTree reads a tree description from the start of src and
+// builds its decoding table, returning the description's size
+func (d *decoder) readHuffmanTree(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, fmt.Errorf("%w: missing Huffman tree", ErrCorrupt)
+	}
+	var weights [256]uint8
+	count := 0
+	headerByte := int(src[0])
+	size := 0
+
+	if headerByte < 128 {
+		// Weights compressed with FSE, decoded by two interleaved states
+		size = 1 + headerByte
+		if len(src) < size {
+			return 0, fmt.Errorf("%w: truncated Huffman tree", ErrCorrupt)
+		}
+		data := src[1:size]
+		n, err := readFSETable(&d.weights, data, 255, 6)
+		if err != nil {
+			return 0, err
+		}
+		var br backwardReader
+		if err := br.init(data[n:]); err != nil {
+			return 0, err
+		}
+		var s1, s2 fseState
+		s1.init(&d.weights, &br)
+		s2.init(&d.weights, &br)
+		for {
+			if count > 253 {
+				return 0, fmt.Errorf("%w: too many Huffman weights", ErrCorrupt)
+			}
+			weights[count] = s1.symbol()
+			count++
+			s1.update(&br)
+			if br.overflowed() {
+				weights[count] = s2.symbol()
+				count++
+				break
+			}
+			weights[count] = s2.symbol()
+			count++
+			s2.update(&br)
+			if br.overflowed() {
+				weights[count] = s1.symbol()
+				count++
+				break
+			}
+		}
+	} else {
+		// Weights in 4 bits each
+		count = headerByte - 127
+		size = 1 + (count+1)/2
+		if len(src) < size {
+			return 0, fmt.Errorf("%w: truncated Huffman tree", ErrCorrupt)
+		}
+		for i := 0; i < count; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+	}
+
+	// The last symbol's weight makes the total a power of two
+	total := 0
+	for _, w := range weights[:count] {
+		if w > maxHuffmanBits {
+			return 0, fmt.Errorf("%w: invalid Huffman weight", ErrCorrupt)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || count >= 256 {
+		return 0, fmt.Errorf("%w: invalid Huffman weights", ErrCorrupt)
+	}
+	maxBits := bits.Len(uint(total))
+	left := 1<<maxBits - total
+	if maxBits > maxHuffmanBits || left&(left-1) != 0 {
+		return 0, fmt.Errorf("%w: invalid Huffman weights", ErrCorrupt)
+	}
+	weights[count] = uint8(bits.Len(uint(left)))
+	count++
+
+	// Codes are assigned by increasing weight, then symbol
+	tableSize := 1 << maxBits
+	if cap(d.huffman) < tableSize {
+		d.huffman = make([]huffmanEntry, tableSize)
+	}
+	d.huffman = d.huffman[:tableSize]
+	d.huffmanBits = maxBits
+	pos := 0
+	for w := 1; w <= maxBits; w++ {
+		for s, sw := range weights[:count] {
+			if int(sw) != w {
+				continue
+			}
+			n := 1 << (w - 1)
+			entry := huffmanEntry{symbol: uint8(s), nbBits: uint8(maxBits + 1 - w)}
+			for i := pos; i < pos+n; i++ {
+				d.huffman[i] = entry
+			}
+			pos += n
+		}
+	}
+	return size, nil
+}
+
+// decodeHuffman fills out with the symbols of one Huffman stream
+func (d *decoder) decodeHuffman(out, src []byte) error {
+	var br backwardReader
+	if err := br.init(src); err != nil {
+		return err
+	}
+	for i := range out {
+		e := d.huffman[br.peek(d.huffmanBits)]
+		out[i] = e.symbol
+		br.pos -= int(e.nbBits)
+	}
+	if br.pos != 0 {
+		return fmt.Errorf("%w: Huffman stream of the wrong length", ErrCorrupt)
+	}
+	return nil
+}
+
+// executeSequences decodes the sequences section, copying literals and
+// matches to hist
+func (d *decoder) executeSequences(src, hist []byte, window int) ([]byte, error) {
+	if len(src) == 0 {
+		return hist, fmt.Errorf("%w: missing sequences section", ErrCorrupt)
+	}
+	count := int(src[0])
+	switch {
+	case count == 0:
+		return append(hist, d.literals...), nil
+	case count < 128:
+		src = src[1:]
+	case count < 255:
+		if len(src) < 2 {
+			return hist, fmt.Errorf("%w: truncated sequences header", ErrCorrupt)
+		}
+		count = (count-128)<<8 | int(src[1])
+		src = src[2:]
+	default:
+		if len(src) < 3 {
+			return hist, fmt.Errorf("%w: truncated sequences header", ErrCorrupt)
+		}
+		count = int(src[1]) | int(src[2])<<8 + 0x7F00
+		src = src[3:]
+	}
+	if len(src) == 0 {
+		return hist, fmt.Errorf("%w: missing compression modes", ErrCorrupt)
+	}
+	modes := src[0]
+	if modes&3 != 0 {
+		return hist, fmt.Errorf("%w: reserved compression mode bits set", ErrCorrupt)
+	}
+	src = src[1:]
+
+	tables := [3]struct {
+		table      *fseTable
+		mode       byte
+		predefined []int16
+		accuracy   int
+		maxSymbol  int
+		maxLog     int
+	}{
+		{&d.literalLengths, modes >> 6, predefinedLiteralLengths, 6, maxLiteralLengthSymbol, maxLiteralLengthLog},
+		{&d.offsets, modes >> 4 & 3, predefinedOffsets, 5, maxOffsetSymbol, maxOffsetLog},
+		{&d.matchLengths, modes >> 2 & 3, predefinedMatchLengths, 6, maxMatchLengthSymbol, maxMatchLengthLog},
+	}
+	for i, t := range tables {
+		switch t.mode {
+		case 0: // predefined
+			t.table.build(t.predefined, t.accuracy)
+		case 1: // RLE
+			if len(src) == 0 || int(src[0]) > t.maxSymbol {
+				return hist, fmt.Errorf("%w: invalid RLE symbol", ErrCorrupt)
+			}
+			t.table.rle(src[0])
+			src = src[1:]
+		case 2: // FSE compressed
+			n, err := readFSETable(t.table, src, t.maxSymbol, t.maxLog)
+			if err != nil {
+				return hist, err
+			}
+			src = src[n:]
+		case 3: // repeat
+			if !d.haveTables[i] {
+				return hist, fmt.Errorf("%w: sequences reuse a missing table", ErrCorrupt)
+			}
+		}
+		d.haveTables[i] = true
+	}
+
+	var br backwardReader
+	if err := br.init(src); err != nil {
+		return hist, err
+	}
+	var ll, of, ml fseState
+	ll.init(&d.literalLengths, &br)
+	of.init(&d.offsets, &br)
+	ml.init(&d.matchLengths, &br)
+
+	literals := d.literals
+	for i := 0; i < count; i++ {
+		ofCode := of.symbol()
+		if ofCode > maxOffsetSymbol {
+			return hist, fmt.Errorf("%w: invalid offset code", ErrCorrupt)
+		}
+		offsetValue := 1<<ofCode + int(br.read(int(ofCode)))
+		mlBase, mlBits := matchLengthCode(ml.symbol())
+		matchLength := int(mlBase) + int(br.read(mlBits))
+		llBase, llBits := literalLengthCode(ll.symbol())
+		literalLength := int(llBase) + int(br.read(llBits))
+		if i < count-1 {
+			ll.update(&br)
+			ml.update(&br)
+			of.update(&br)
+		}
+		if br.overflowed() {
+			return hist, fmt.Errorf("%w: sequences bitstream overflow", ErrCorrupt)
+		}
+
+		offset, err := d.offset(offsetValue, literalLength)
+		if err != nil {
+			return hist, err
+		}
+
+		if literalLength > len(literals) {
+			return hist, fmt.Errorf("%w: sequence uses more literals than decoded", ErrCorrupt)
+		}
+		hist = append(hist, literals[:literalLength]...)
+		literals = literals[literalLength:]
+
+		if offset > len(hist) || offset > window {
+			return hist, fmt.Errorf("%w: match offset beyond the window", ErrCorrupt)
+		}
+		if matchLength > maxBlockSize {
+			return hist, fmt.Errorf("%w: match too long", ErrCorrupt)
+		}
+		start := len(hist) - offset
+		for j := 0; j < matchLength; j++ {
+			hist = append(hist, hist[start+j])
+		}
+	}
+	if br.pos != 0 {
+		return hist, fmt.Errorf("%w: sequences bitstream of the wrong length", ErrCorrupt)
+	}
+	return append(hist, literals...), nil
+}
+
+// offset resolves an offset value to a distance, updating the repeated
+// offsets
+func (d *decoder) offset(value, literalLength int) (int, error) {
+	r := &d.repeats
+	if value > 3 {
+		offset := value - 3
+		r[0], r[1], r[2] = offset, r[0], r[1]
+		return offset, nil
+	}
+	if literalLength == 0 {
+		value++
+	}
+	switch value {
+	case 1:
+		return r[0], nil
+	case 2:
+		r[0], r[1] = r[1], r[0]
+	case 3:
+		r[0], r[1], r[2] = r[2], r[0], r[1]
+	case 4:
+		if r[0] <= 1 {
+			return 0, fmt.Errorf("%w: invalid repeated offset", ErrCorrupt)
+		}
+		r[0], r[1], r[2] = r[0]-1, r[0], r[1]
+	}
+	return r[0], nil
+}
@@ -0,0 +1,209 @@
+package zstd
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// fseEntry is one state of an FSE decoding table: the symbol it decodes
+// to, and how to reach the next state
+type fseEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	baseline uint16
+}
+
+// fseTable decodes symbols with finite state entropy. A table of one
+// entry with no bits repeats a single symbol.
+type fseTable struct {
+	accuracyLog int
+	entries     []fseEntry
+}
+
+// fseState walks an fseTable over a backward bitstream
+type fseState struct {
+	table *fseTable
+	state int
+}
+
+func (s *fseState) init(table *fseTable, br *backwardReader) {
+	s.table = table
+	s.state = int(br.read(table.accuracyLog))
+}
+
+func (s *fseState) symbol() uint8 {
+	return s.table.entries[s.state].symbol
+}
+
+func (s *fseState) update(br *backwardReader) {
+	e := s.table.entries[s.state]
+	s.state = int(e.baseline) + int(br.read(int(e.nbBits)))
+}
+
+// readFSETable reads a table description from the start of src, with
+// symbols up to maxSymbol and an accuracy log up to maxLog. It returns how
+// many bytes the description took.
+func readFSETable(t *fseTable, src []byte, maxSymbol, maxLog int) (int, error) {
+	if len(src) == 0 {
+		return 0, fmt.Errorf("%w: missing FSE table", ErrCorrupt)
+	}
+	f := forwardReader{src: src}
+	accuracyLog := int(f.read(4)) + 5
+	if accuracyLog > maxLog {
+		return 0, fmt.Errorf("%w: FSE accuracy log %d too large", ErrCorrupt, accuracyLog)
+	}
+
+	var counts [256]int16
+	remaining := 1<<accuracyLog + 1
+	threshold := 1 << accuracyLog
+	nbBits := accuracyLog + 1
+	symbol := 0
+	previous0 := false
+	for remaining > 1 && symbol <= maxSymbol {
+		if previous0 {
+			// A count of zero is followed by how many more zeros follow
+			for {
+				repeat := int(f.read(2))
+				symbol += repeat
+				if repeat != 3 {
+					break
+				}
+			}
+			if symbol > maxSymbol {
+				break
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		var count int
+		if low := int(f.peek(nbBits - 1)); low < max {
+			count = low
+			f.pos += nbBits - 1
+		} else {
+			count = int(f.peek(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			f.pos += nbBits
+		}
+		count-- // -1 is a probability below one
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		counts[symbol] = int16(count)
+		symbol++
+		previous0 = count == 0
+		for remaining < threshold && threshold > 1 {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || f.pos > len(src)*8 {
+		return 0, fmt.Errorf("%w: invalid FSE table", ErrCorrupt)
+	}
+	t.build(counts[:symbol], accuracyLog)
+	return f.bytesRead(), nil
+}
+
+// build fills the decoding table for normalized counts
+func (t *fseTable) build(counts []int16, accuracyLog int) {
+	size := 1 << accuracyLog
+	t.accuracyLog = accuracyLog
+	if cap(t.entries) < size {
+		t.entries = make([]fseEntry, size)
+	}
+	t.entries = t.entries[:size]
+
+	var next [256]uint16
+	high := size - 1
+	for s, count := range counts {
+		if count == -1 {
+			t.entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint16(count)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, count := range counts {
+		for i := 0; i < int(count); i++ {
+			t.entries[pos].symbol = uint8(s)
+			for pos = (pos + step) & (size - 1); pos > high; pos = (pos + step) & (size - 1) {
+			}
+		}
+	}
+	for i := range t.entries {
+		s := t.entries[i].symbol
+		n := next[s]
+		next[s]++
+		nb := accuracyLog - (bits.Len16(n) - 1)
+		t.entries[i].nbBits = uint8(nb)
+		t.entries[i].baseline = uint16(int(n)<<nb - size)
+	}
+}
+
+// rle makes the table repeat symbol
+func (t *fseTable) rle(symbol uint8) {
+	t.accuracyLog = 0
+	t.entries = append(t.entries[:0], fseEntry{symbol: symbol})
+}
+
+// Predefined distributions of literal lengths, match lengths and offsets
+var (
+	predefinedLiteralLengths = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	predefinedMatchLengths = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	predefinedOffsets = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+// Limits of the sequence tables
+const (
+	maxLiteralLengthSymbol = 35
+	maxMatchLengthSymbol   = 52
+	maxOffsetSymbol        = 31
+	maxLiteralLengthLog    = 9
+	maxMatchLengthLog      = 9
+	maxOffsetLog           = 8
+)
+
+// Baselines and extra bits of literal length codes 16 and up; lower codes
+// are the length itself
+var (
+	literalLengthBaselines = [...]uint32{16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	literalLengthBits      = [...]uint8{1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// Baselines and extra bits of match length codes 32 and up; lower codes
+// are the length minus 3
+var (
+	matchLengthBaselines = [...]uint32{35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	matchLengthBits      = [...]uint8{1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+func literalLengthCode(code uint8) (baseline uint32, extra int) {
+	if code < 16 {
+		return uint32(code), 0
+	}
+	return literalLengthBaselines[code-16], int(literalLengthBits[code-16])
+}
+
+func matchLengthCode(code uint8) (baseline uint32, extra int) {
+	if code < 32 {
+		return uint32(code) + 3, 0
+	}
+	return matchLengthBaselines[code-32], int(matchLengthBits[code-32])
+}
@@ -0,0 +1,263 @@
+// Package zstd decompresses Zstandard streams (RFC 8878). It decodes
+// frames without dictionaries, and bounds the memory a stream may claim
+// with its window size.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	frameMagic         = 0xFD2FB528
+	skippableMagicMask = 0xFFFFFFF0
+	skippableMagic     = 0x184D2A50
+
+	// maxBlockSize is the most a block may hold, compressed or not
+	maxBlockSize = 128 << 10
+
+	// MaxWindowSize is the largest window accepted, as recommended for
+	// decoders; frames needing more are rejected
+	MaxWindowSize = 1 << 27
+)
+
+var (
+	// ErrCorrupt is returned for malformed streams
+	ErrCorrupt = errors.New("zstd: corrupt stream")
+
+	// ErrChecksum is returned when a frame's content doesn't match its
+	// checksum
+	ErrChecksum = errors.New("zstd: checksum mismatch")
+)
+
+// Reader decompresses a stream of frames, as many as it holds
+type Reader struct {
+	r   io.Reader
+	err error
+
+	// State of the current frame
+	inFrame  bool
+	last     bool // the frame's last block has been decoded
+	window   int
+	checksum bool
+	digest   *xxhash.Digest
+
+	// hist is the frame's decoded content that matches may still refer
+	// to; out is the part of it not read yet
+	hist []byte
+	out  []byte
+
+	block []byte // compressed block being decoded
+	dec   decoder
+}
+
+// NewReader creates a reader decompressing r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, digest: xxhash.New()}
+}
+
+// Read reads decompressed data
+func (z *Reader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// next decodes the next block, starting or finishing frames as needed
+func (z *Reader) next() error {
+	if !z.inFrame {
+		return z.startFrame()
+	}
+	if z.last {
+		z.inFrame = false
+		if !z.checksum {
+			return nil
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(z.r, sum[:]); err != nil {
+			return unexpected(err)
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != uint32(z.digest.Sum64()) {
+			return ErrChecksum
+		}
+		return nil
+	}
+	return z.decodeBlock()
+}
+
+// startFrame reads a frame header, skipping skippable frames. It returns
+// io.EOF when the stream ends between frames.
+func (z *Reader) startFrame() error {
+	var magic [4]byte
+	if _, err := io.ReadFull(z.r, magic[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return unexpected(err)
+	}
+	switch m := binary.LittleEndian.Uint32(magic[:]); {
+	case m&skippableMagicMask == skippableMagic:
+		var size [4]byte
+		if _, err := io.ReadFull(z.r, size[:]); err != nil {
+			return unexpected(err)
+		}
+		_, err := io.CopyN(io.Discard, z.r, int64(binary.LittleEndian.Uint32(size[:])))
+		return unexpected(err)
+	case m != frameMagic:
+		return fmt.Errorf("%w: not a zstd frame", ErrCorrupt)
+	}
+
+	var descriptor [1]byte
+	if _, err := io.ReadFull(z.r, descriptor[:]); err != nil {
+		return unexpected(err)
+	}
+	d := descriptor[0]
+	fcsFlag := d >> 6
+	singleSegment := d&0x20 != 0
+	if d&0x08 != 0 {
+		return fmt.Errorf("%w: reserved frame header bit set", ErrCorrupt)
+	}
+	dictIDSize := [4]int{0, 1, 2, 4}[d&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	windowSize := 0
+	if !singleSegment {
+		windowSize = 1 // read below
+	}
+
+	header := make([]byte, windowSize+dictIDSize+fcsSize)
+	if _, err := io.ReadFull(z.r, header); err != nil {
+		return unexpected(err)
+	}
+	if !singleSegment {
+		exponent, mantissa := header[0]>>3, header[0]&7
+		base := uint64(1) << (10 + exponent)
+		size := base + base/8*uint64(mantissa)
+		if size > MaxWindowSize {
+			return fmt.Errorf("zstd: window of %d bytes is too large", size)
+		}
+		windowSize = int(size)
+		header = header[1:]
+	}
+	var dictID uint32
+	for i := dictIDSize - 1; i >= 0; i-- {
+		dictID = dictID<<8 | uint32(header[i])
+	}
+	if dictID != 0 {
+		return fmt.Errorf("zstd: dictionaries are not supported")
+	}
+	header = header[dictIDSize:]
+	if singleSegment {
+		var contentSize uint64
+		for i := fcsSize - 1; i >= 0; i-- {
+			contentSize = contentSize<<8 | uint64(header[i])
+		}
+		if fcsSize == 2 {
+			contentSize += 256
+		}
+		if contentSize > MaxWindowSize {
+			return fmt.Errorf("zstd: window of %d bytes is too large", contentSize)
+		}
+		windowSize = int(contentSize)
+	}
+
+	z.inFrame, z.last = true, false
+	z.window = windowSize
+	z.checksum = d&0x04 != 0
+	z.digest.Reset()
+	z.hist = z.hist[:0]
+	z.dec.reset()
+	return nil
+}
+
+// decodeBlock decodes the frame's next block into hist and out
+func (z *Reader) decodeBlock() error {
+	var header [3]byte
+	if _, err := io.ReadFull(z.r, header[:]); err != nil {
+		return unexpected(err)
+	}
+	h := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	z.last = h&1 != 0
+	blockType := (h >> 1) & 3
+	size := int(h >> 3)
+	if size > min(maxBlockSize, z.window) {
+		return fmt.Errorf("%w: block of %d bytes exceeds the maximum", ErrCorrupt, size)
+	}
+
+	// Keep only the window of the content decoded so far
+	if len(z.hist) > 2*z.window && len(z.hist) > maxBlockSize {
+		keep := z.hist[len(z.hist)-z.window:]
+		z.hist = z.hist[:copy(z.hist, keep)]
+	}
+	start := len(z.hist)
+
+	switch blockType {
+	case 0: // raw
+		z.hist = grow(z.hist, size)
+		if _, err := io.ReadFull(z.r, z.hist[start:]); err != nil {
+			return unexpected(err)
+		}
+	case 1: // RLE
+		var b [1]byte
+		if _, err := io.ReadFull(z.r, b[:]); err != nil {
+			return unexpected(err)
+		}
+		z.hist = grow(z.hist, size)
+		for i := start; i < len(z.hist); i++ {
+			z.hist[i] = b[0]
+		}
+	case 2: // compressed
+		if cap(z.block) < size {
+			z.block = make([]byte, size)
+		}
+		z.block = z.block[:size]
+		if _, err := io.ReadFull(z.r, z.block); err != nil {
+			return unexpected(err)
+		}
+		var err error
+		if z.hist, err = z.dec.decompress(z.block, z.hist, z.window); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: reserved block type", ErrCorrupt)
+	}
+
+	z.out = z.hist[start:]
+	if len(z.out) > maxBlockSize {
+		return fmt.Errorf("%w: block decodes to more than %d bytes", ErrCorrupt, maxBlockSize)
+	}
+	if z.checksum {
+		z.digest.Write(z.out)
+	}
+	return nil
+}
+
+// grow extends b by n bytes
+func grow(b []byte, n int) []byte {
+	if cap(b)-len(b) < n {
+		grown := make([]byte, len(b), 2*cap(b)+n)
+		copy(grown, b)
+		b = grown
+	}
+	return b[:len(b)+n]
+}
+
+// unexpected turns the end of input inside a frame into an error
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
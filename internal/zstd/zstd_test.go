@@ -0,0 +1,89 @@
+package zstd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	// Fixtures made with the zstd command line tool
+	tests := []struct {
+		file   string
+		size   int
+		sha256 string
+	}{
+		{"rows-1.csv.zst", 176375, "22d542b7555b46dd73e180c46426d60fef068d3db58b055e94f19dac233706f7"},
+		{"rows-19.csv.zst", 176375, "22d542b7555b46dd73e180c46426d60fef068d3db58b055e94f19dac233706f7"},
+		{"hello.txt.zst", 36, "afcf283270d0322c53738f6fdaa77c740cc42f249cb89b9d6062fa9552e9276f"},
+		{"noise.bin.zst", 3000, "9a1132c1b5f0539aa933800b5a1daea7231007c7193e90d7c9830b8235b58f1e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := io.ReadAll(NewReader(bytes.NewReader(readFixture(t, tt.file))))
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			sum := sha256.Sum256(got)
+			if len(got) != tt.size || hex.EncodeToString(sum[:]) != tt.sha256 {
+				t.Errorf("decoded %d bytes with SHA-256 %x, want %d bytes with %s", len(got), sum, tt.size, tt.sha256)
+			}
+		})
+	}
+}
+
+func TestReaderFrames(t *testing.T) {
+	hello := readFixture(t, "hello.txt.zst")
+	skippable := []byte{0x5A, 0x2A, 0x4D, 0x18, 3, 0, 0, 0, 'a', 'b', 'c'}
+	stream := append(append(append([]byte{}, hello...), skippable...), hello...)
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	want := bytes.Repeat([]byte("hello, zstd\n"), 6)
+	if !bytes.Equal(got, want) {
+		t.Errorf("ReadAll() = %q, want %q", got, want)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	noise := readFixture(t, "noise.bin.zst")
+	badChecksum := append([]byte{}, noise...)
+	badChecksum[len(badChecksum)-1] ^= 0xFF
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{"checksum mismatch", badChecksum, ErrChecksum},
+		{"truncated", noise[:len(noise)/2], io.ErrUnexpectedEOF},
+		{"not zstd", []byte("plain text, not compressed"), ErrCorrupt},
+		{"window too large", []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00, 18 << 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(NewReader(bytes.NewReader(tt.input)))
+			if err == nil {
+				t.Fatal("ReadAll() error = nil")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("ReadAll() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
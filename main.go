@@ -2,23 +2,71 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"file-meta/config"
 	"file-meta/handlers"
+	"file-meta/internal/acme"
+	"file-meta/internal/aiclassifier"
+	"file-meta/internal/azureblob"
+	"file-meta/internal/background"
+	"file-meta/internal/clamav"
+	"file-meta/internal/cli"
+	"file-meta/internal/filepolicy"
+	"file-meta/internal/gcs"
+	"file-meta/internal/geoip"
+	"file-meta/internal/history"
+	"file-meta/internal/jobs"
+	"file-meta/internal/kafka"
+	"file-meta/internal/knownfiles"
 	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
 	"file-meta/internal/models"
+	"file-meta/internal/modules"
+	"file-meta/internal/nats"
+	"file-meta/internal/overrides"
+	"file-meta/internal/postgres"
+	"file-meta/internal/s3"
+	"file-meta/internal/sandbox"
+	"file-meta/internal/sigv4"
+	"file-meta/internal/storage"
+	"file-meta/internal/store"
+	"file-meta/internal/tempfiles"
+	"file-meta/internal/uploads"
+	"file-meta/internal/usage"
+	"file-meta/internal/workpool"
 	"file-meta/middleware"
 
 	"github.com/redis/go-redis/v9"
 )
 
 func main() {
+	// Local subcommands run without the server's configuration
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	} else if code, ok := cli.Run(args, os.Stdin, os.Stdout, os.Stderr); ok {
+		os.Exit(code)
+	}
+
+	worker := flag.Bool("worker", false, "Consume S3 event notifications from SQS_QUEUE_URL instead of serving HTTP")
+	scan := flag.String("scan", "", "Extract metadata for every file under a directory in SCAN_ALLOWED_DIRS and print NDJSON results instead of serving HTTP")
+	watchMode := flag.Bool("watch", false, "Extract metadata for files dropped into WATCH_DIRS instead of serving HTTP")
+	flag.CommandLine.Parse(args)
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -27,7 +75,14 @@ func main() {
 
 	// Initialize logger
 	log := logger.New(cfg.LogLevel)
+	log.SetFilenames(cfg.LogFilenames)
+	if *scan != "" {
+		// Keep stdout for the results
+		log.SetOutput(os.Stderr)
+	}
 	log.Infof("Starting file-meta server in %s mode", cfg.Environment)
+	log.Infof("Configuration: %s", configSummary(cfg))
+	started := time.Now()
 
 	// Initialize Redis client (optional)
 	var redisClient *redis.Client
@@ -62,67 +117,745 @@ func main() {
 		}
 	}
 
+	// Dependencies checked by /health, critical unless the service copes
+	// without them
+	var healthChecks []handlers.HealthCheck
+	if redisClient != nil {
+		healthChecks = append(healthChecks, handlers.HealthCheck{Name: "redis", Critical: true, Check: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}})
+	}
+
+	// Initialize ClamAV client (optional)
+	var deps handlers.Deps
+
+	// Work outliving its request, waited for at shutdown
+	deps.Background = background.NewTracker()
+	deps.Modules = modules.New(cfg.DisabledModules)
+	deps.FilePolicy = filepolicy.New(cfg.AllowedMIMETypes, cfg.DeniedMIMETypes, cfg.AllowedExtensions, cfg.DeniedExtensions)
+	if cfg.ClamAVAddress != "" {
+		scanner, err := clamav.NewClient(cfg.ClamAVAddress, cfg.ClamAVTimeout)
+		if err != nil {
+			log.Fatalf("Invalid CLAMAV_ADDRESS: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := scanner.Ping(ctx); err != nil {
+			log.Warnf("ClamAV ping failed, scans will be retried per request: %v", err)
+		} else {
+			log.Info("Successfully connected to ClamAV")
+		}
+		cancel()
+
+		deps.Scanner = scanner
+		healthChecks = append(healthChecks, handlers.HealthCheck{Name: "clamav", Critical: !cfg.ClamAVFailOpen, Check: scanner.Ping})
+	}
+
+	// Load known-good hash set (optional)
+	if cfg.NSRLFile != "" {
+		set, err := knownfiles.LoadFile(cfg.NSRLFile)
+		if err != nil {
+			log.Fatalf("Failed to load NSRL_FILE: %v", err)
+		}
+		log.Infof("Loaded %d known-file hashes from %s", set.Len(), cfg.NSRLFile)
+		deps.KnownFiles = set
+	} else if cfg.NSRLRedisPrefix != "" {
+		if redisClient != nil {
+			deps.KnownFiles = knownfiles.NewRedisSet(redisClient, cfg.NSRLRedisPrefix)
+			log.Infof("Using Redis known-file hash set %s:*", cfg.NSRLRedisPrefix)
+		} else {
+			log.Warnf("NSRL_REDIS_PREFIX is set but Redis is unavailable, known-file lookup disabled")
+		}
+	}
+
+	// External AI-image classifier (optional)
+	if cfg.AIClassifierURL != "" {
+		deps.AIClassifier = aiclassifier.NewClient(cfg.AIClassifierURL, cfg.AIClassifierAPIKey, cfg.AIClassifierTimeout)
+		log.Infof("Blending AI-image heuristics with classifier at %s (weight %.2f)", cfg.AIClassifierURL, cfg.AIClassifierWeight)
+	}
+
+	// Result cache for hash lookups (optional)
+	if cfg.ResultCacheSize > 0 {
+		if redisClient != nil {
+			deps.Results = store.NewRedisStore(redisClient, "result", cfg.ResultCacheTTL)
+			log.Infof("Caching results in Redis for %s", cfg.ResultCacheTTL)
+		} else {
+			deps.Results = store.NewMemoryStore(cfg.ResultCacheSize, cfg.ResultCacheTTL)
+			log.Infof("Caching up to %d results in memory for %s", cfg.ResultCacheSize, cfg.ResultCacheTTL)
+		}
+	}
+
+	// Extraction history in PostgreSQL or a local file (optional)
+	if cfg.DatabaseURL != "" {
+		db, err := postgres.Open(cfg.DatabaseURL, cfg.DatabaseMaxConns, cfg.DatabaseTimeout)
+		if err != nil {
+			log.Fatalf("Invalid DATABASE_URL: %v", err)
+		}
+		defer db.Close()
+		historyStore, err := history.NewPostgresStore(db, cfg.DatabaseTable)
+		if err != nil {
+			log.Fatalf("Invalid DATABASE_TABLE: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DatabaseTimeout)
+		err = historyStore.Migrate(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to prepare the history table: %v", err)
+		}
+		deps.History = historyStore
+		healthChecks = append(healthChecks, handlers.HealthCheck{Name: "postgres", Critical: true, Check: func(ctx context.Context) error {
+			_, err := db.Query(ctx, "SELECT 1")
+			return err
+		}})
+		log.Infof("Recording extraction history in PostgreSQL table %s", cfg.DatabaseTable)
+	} else if cfg.HistoryFile != "" {
+		historyFile, err := history.OpenFile(cfg.HistoryFile)
+		if err != nil {
+			log.Fatalf("Failed to open HISTORY_FILE: %v", err)
+		}
+		defer historyFile.Close()
+		deps.History = historyFile
+		log.Infof("Recording extraction history in %s", cfg.HistoryFile)
+	}
+
+	// Per-key usage counts (optional)
+	if cfg.UsageTracking {
+		if redisClient != nil {
+			deps.Usage = usage.NewRedisTracker(redisClient, "usage", cfg.UsageRetention)
+			log.Infof("Counting usage per API key in Redis for %s", cfg.UsageRetention)
+		} else {
+			deps.Usage = usage.NewMemoryTracker()
+			log.Infof("Counting usage per API key in memory")
+		}
+	}
+
+	// Per-key settings overrides (optional)
+	if cfg.KeyOverrides {
+		if redisClient != nil {
+			deps.Overrides = overrides.NewRedisStore(redisClient, "overrides")
+			log.Info("Keeping per-key overrides in Redis")
+		} else {
+			deps.Overrides = overrides.NewMemoryStore()
+			log.Info("Keeping per-key overrides in memory")
+		}
+	}
+
+	// Resumable uploads (optional)
+	if cfg.UploadMaxSizeMB > 0 {
+		uploadStore, err := uploads.NewStore(cfg.UploadDir, cfg.UploadMaxSizeMB<<20, cfg.UploadExpiry)
+		if err != nil {
+			log.Fatalf("Invalid UPLOAD_DIR: %v", err)
+		}
+		deps.Uploads = uploadStore
+		healthChecks = append(healthChecks, handlers.HealthCheck{Name: "upload_dir", Check: handlers.WritableDir(cfg.UploadDir)})
+		log.Infof("Accepting resumable uploads up to %d MB in %s", cfg.UploadMaxSizeMB, cfg.UploadDir)
+
+		// Async extraction jobs run on completed uploads
+		jobManager := jobs.NewManager(cfg.JobRetention)
+		deps.Jobs = jobManager
+
+		// Remove uploads that were abandoned before completing or extraction,
+		// and jobs whose results have been kept long enough
+		go func() {
+			for range time.Tick(time.Hour) {
+				if removed, err := uploadStore.Sweep(); err != nil {
+					log.Warnf("Failed to sweep expired uploads: %v", err)
+				} else if removed > 0 {
+					log.Infof("Removed %d expired uploads", removed)
+				}
+				if removed := jobManager.Sweep(); removed > 0 {
+					log.Infof("Removed %d finished jobs", removed)
+				}
+			}
+		}()
+	}
+
+	// Temporary files for spilled uploads, with orphans from earlier runs
+	// swept at startup and then hourly
+	tempFiles, err := tempfiles.New(cfg.TempDir, cfg.TempQuotaMB<<20)
+	if err != nil {
+		log.Fatalf("Invalid TEMP_DIR: %v", err)
+	}
+	deps.TempFiles = tempFiles
+	healthChecks = append(healthChecks, handlers.HealthCheck{Name: "temp_dir", Critical: true, Check: handlers.WritableDir(tempFiles.Dir())})
+	sweepTempFiles := func() {
+		if removed, err := tempFiles.Sweep(cfg.TempOrphanAge); err != nil {
+			log.Warnf("Failed to sweep temporary files: %v", err)
+		} else if removed > 0 {
+			log.Infof("Removed %d orphaned temporary files", removed)
+		}
+	}
+	sweepTempFiles()
+	go func() {
+		for range time.Tick(time.Hour) {
+			sweepTempFiles()
+		}
+	}()
+
+	// Cloud storage ingestion (optional)
+	chunkSize := cfg.StorageChunkSizeMB << 20
+	providers := storage.Providers{}
+	if cfg.S3Enabled {
+		creds := sigv4.Credentials{
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			SessionToken:    cfg.S3SessionToken,
+		}
+		client, err := s3.NewClient(cfg.S3Region, cfg.S3Endpoint, creds, cfg.S3AllowedBuckets, chunkSize, cfg.StorageRequestTimeout)
+		if err != nil {
+			log.Fatalf("Invalid S3 configuration: %v", err)
+		}
+		providers["s3"] = client
+		switch {
+		case creds.AccessKeyID != "" && len(cfg.S3AllowedBuckets) > 0:
+			log.Infof("Reading S3 objects in %s by presigned URL, or by bucket/key from %s", cfg.S3Region, strings.Join(cfg.S3AllowedBuckets, ", "))
+		case creds.AccessKeyID != "":
+			log.Warnf("Reading S3 objects in %s by bucket/key from any bucket the AWS credentials can read; set S3_ALLOWED_BUCKETS to limit them", cfg.S3Region)
+		default:
+			log.Infof("Reading S3 objects by presigned URL (no AWS credentials for bucket/key)")
+		}
+	}
+	if cfg.GCSEnabled {
+		var account *gcs.ServiceAccount
+		if cfg.GCSCredentialsFile != "" {
+			var err error
+			if account, err = gcs.LoadServiceAccount(cfg.GCSCredentialsFile); err != nil {
+				log.Fatalf("Failed to load GCS service account: %v", err)
+			}
+		}
+		client, err := gcs.NewClient(account, cfg.GCSEndpoint, chunkSize, cfg.StorageRequestTimeout)
+		if err != nil {
+			log.Fatalf("Invalid GCS configuration: %v", err)
+		}
+		providers["gs"] = client
+		if account != nil {
+			log.Infof("Reading GCS objects as %s by gs:// reference or signed URL", account.ClientEmail)
+		} else {
+			log.Infof("Reading GCS objects by signed URL (no service account for gs:// references)")
+		}
+	}
+	if cfg.AzureEnabled {
+		client, err := azureblob.NewClient(cfg.AzureStorageAccount, cfg.AzureStorageKey, cfg.AzureSASToken, cfg.AzureBlobEndpoint, chunkSize, cfg.StorageRequestTimeout)
+		if err != nil {
+			log.Fatalf("Invalid Azure Blob configuration: %v", err)
+		}
+		providers["azure"] = client
+		if cfg.AzureStorageKey != "" || cfg.AzureSASToken != "" {
+			log.Infof("Reading Azure blobs in %s by azure:// reference or SAS URL", cfg.AzureStorageAccount)
+		} else {
+			log.Infof("Reading Azure blobs by SAS URL (no account credentials for azure:// references)")
+		}
+	}
+	if len(providers) > 0 {
+		deps.Storage = providers
+	}
+
+	// Run parsers in a WebAssembly sandbox (optional)
+	if cfg.SandboxEnabled {
+		module := cfg.SandboxModule
+		if module == "" {
+			exe, err := os.Executable()
+			if err != nil {
+				log.Fatalf("Cannot find the binary for sandboxed extraction: %v", err)
+			}
+			module = filepath.Join(filepath.Dir(exe), sandbox.ModuleFile)
+		}
+		extractor, err := sandbox.New(context.Background(), module, sandbox.Limits{
+			MemoryBytes: uint64(cfg.SandboxMemoryMB) << 20,
+			Timeout:     cfg.SandboxTimeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to load the sandboxed extractor: %v", err)
+		}
+		defer extractor.Close(context.Background())
+		deps.Sandbox = extractor
+		log.Infof("Extracting in a WebAssembly sandbox (%s) limited to %d MB and %s", module, cfg.SandboxMemoryMB, cfg.SandboxTimeout)
+	}
+
+	// Publish every completed result to Kafka (optional)
+	if len(cfg.KafkaBrokers) > 0 {
+		opts := kafka.Options{
+			Username:   cfg.KafkaUsername,
+			Password:   cfg.KafkaPassword,
+			BufferSize: cfg.KafkaBufferSize,
+			Timeout:    cfg.KafkaTimeout,
+		}
+		if cfg.KafkaTLS {
+			opts.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		producer, err := kafka.NewProducer(cfg.KafkaBrokers, cfg.KafkaTopic, opts, log)
+		if err != nil {
+			log.Fatalf("Invalid Kafka configuration: %v", err)
+		}
+		deps.Publishers = append(deps.Publishers, producer)
+		log.Infof("Publishing results to Kafka topic %s", cfg.KafkaTopic)
+
+		// Flush buffered results on the way out, after the server or worker
+		// has stopped producing them
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := producer.Close(ctx); err != nil {
+				log.Warnf("Gave up flushing results to Kafka: %v", err)
+			}
+		}()
+	}
+
+	// Bound concurrent extractions (optional)
+	if cfg.MaxConcurrentExtractions > 0 {
+		deps.Workers = workpool.New(cfg.MaxConcurrentExtractions, cfg.ExtractionQueueTimeout)
+		log.Infof("Running up to %d extractions at once, queueing for %s", cfg.MaxConcurrentExtractions, cfg.ExtractionQueueTimeout)
+	}
+	if cfg.MaxConcurrentPerKey > 0 {
+		deps.KeySlots = workpool.NewKeyLimit(cfg.MaxConcurrentPerKey)
+		log.Infof("Running up to %d extractions at once per API key", cfg.MaxConcurrentPerKey)
+	}
+
+	// Publish results to, and take extraction requests from, NATS (optional)
+	if cfg.NATSURL != "" {
+		conn, err := nats.Connect(cfg.NATSURL, "file-meta", nil, cfg.NATSTimeout, log)
+		if err != nil {
+			log.Fatalf("Failed to connect to NATS: %v", err)
+		}
+		defer conn.Close()
+
+		if cfg.NATSResultsSubject != "" {
+			publisher, err := nats.NewPublisher(conn, cfg.NATSResultsSubject, cfg.NATSJetStream, cfg.NATSBufferSize, cfg.NATSTimeout, log)
+			if err != nil {
+				log.Fatalf("Invalid NATS configuration: %v", err)
+			}
+			deps.Publishers = append(deps.Publishers, publisher)
+			log.Infof("Publishing results to NATS subject %s.<sha256>", cfg.NATSResultsSubject)
+
+			// Flush buffered results before the connection closes
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if err := publisher.Close(ctx); err != nil {
+					log.Warnf("Gave up flushing results to NATS: %v", err)
+				}
+			}()
+		}
+		if cfg.NATSRequestsSubject != "" && *scan == "" {
+			serveNATSRequests(cfg, log, deps, conn)
+		}
+	}
+
+	// Scan mode extracts a directory's files and exits
+	if *scan != "" {
+		runScan(cfg, log, deps, *scan)
+		return
+	}
+
+	// Watch mode runs extractions for files dropped into folders only
+	if *watchMode {
+		runWatch(cfg, log, deps)
+		return
+	}
+
+	// Event worker mode runs extractions for queued notifications only
+	if *worker {
+		runWorker(cfg, log, deps)
+		return
+	}
+
 	// Create router
 	mux := http.NewServeMux()
 
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(models.HealthResponse{Status: "ok"})
-	})
+	// Health check endpoint, with the requests being served
+	var requestsInFlight middleware.InFlight
+	buildVersion, buildCommit, buildDate := buildInfo()
+	mux.HandleFunc("/health", handlers.HealthHandler(cfg, log, handlers.Health{
+		Version:  buildVersion,
+		Commit:   buildCommit,
+		Started:  started,
+		InFlight: requestsInFlight.Count,
+		Checks:   healthChecks,
+	}))
+
+	// Probes for orchestrators: alive as long as the process serves, ready
+	// unless it's shutting down, a critical dependency is down or every
+	// extraction slot is taken
+	var draining atomic.Bool
+	readiness := handlers.Readiness{Draining: draining.Load, Checks: healthChecks}
+	if deps.Workers != nil {
+		readiness.Saturated = deps.Workers.Saturated
+	}
+	mux.HandleFunc("/livez", handlers.LivenessHandler())
+	mux.HandleFunc("/readyz", handlers.ReadinessHandler(log, readiness))
+
+	// Build and enabled features, for support
+	var features []string
+	if redisClient != nil {
+		features = append(features, "redis")
+	}
+	if len(cfg.GeoIPDatabases) > 0 {
+		features = append(features, "geoip")
+	}
+	mux.HandleFunc("/version", handlers.VersionHandler(cfg, log, models.VersionInfo{
+		Version:   buildVersion,
+		Commit:    buildCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Features:  handlers.Features(cfg, deps, features...),
+	}))
+
+	// Prometheus scrape target, public like the health check
+	if len(cfg.MetricsKeySecret) > 0 {
+		middleware.SetMetricsKeySecret(cfg.MetricsKeySecret)
+	}
+	mux.Handle("/metrics", metrics.Default.Handler())
+
+	// API contract and an index of the endpoints, public like the health check
+	mux.Handle("/openapi.json", middleware.CORS(handlers.OpenAPIHandler(cfg, log)))
+	mux.Handle("/{$}", middleware.CORS(handlers.IndexHandler(cfg, log, buildVersion)))
+	if cfg.DocsUI {
+		mux.HandleFunc("/docs", handlers.DocsHandler())
+	}
 
 	// Choose rate limiting strategy
 	var rateLimitMiddleware func(http.Handler) http.Handler
-	if redisClient != nil {
+	slidingWindow := cfg.RateLimitAlgorithm == config.RateLimitSlidingWindow
+	switch {
+	case redisClient != nil && slidingWindow:
+		rateLimitMiddleware = middleware.RedisSlidingWindowRateLimit(cfg, log, redisClient)
+	case redisClient != nil:
 		rateLimitMiddleware = middleware.RedisRateLimit(cfg, log, redisClient)
-	} else {
+	case slidingWindow:
+		rateLimitMiddleware = middleware.SlidingWindowRateLimit(cfg, log)
+	default:
 		rateLimitMiddleware = middleware.RateLimit(cfg, log)
 	}
 
-	// Metadata endpoint with middleware chain
-	handler := middleware.Recovery(log)(
-		middleware.RequestLogger(log)(
-			rateLimitMiddleware(
-				middleware.APIKeyAuth(cfg, log)(
-					http.HandlerFunc(handlers.MetadataHandler(cfg, log)),
+	// Shed the lowest-priority requests under pressure (optional)
+	var inFlight func() int
+	if deps.Workers != nil {
+		inFlight = func() int { return deps.Workers.InUse() + deps.Workers.Waiting() }
+	} else if cfg.LoadShedMaxInFlight > 0 {
+		log.Warn("LOAD_SHED_MAX_IN_FLIGHT needs MAX_CONCURRENT_EXTRACTIONS to count extractions, ignoring it")
+	}
+	loadShed := middleware.LoadShed(cfg, log, inFlight)
+
+	// Ban client IPs that keep guessing API keys
+	authBan := middleware.AuthBan(cfg, log, redisClient)
+
+	// Authenticated requests count towards the key's usage
+	countRequests := func(h http.Handler) http.Handler { return h }
+	if deps.Usage != nil {
+		countRequests = middleware.CountRequests(deps.Usage, log)
+	}
+	resolveOverrides := func(h http.Handler) http.Handler { return h }
+	if deps.Overrides != nil {
+		resolveOverrides = middleware.ResolveOverrides(deps.Overrides, log)
+	}
+
+	// Place client IPs for access logs and usage (optional)
+	locate := func(h http.Handler) http.Handler { return h }
+	if len(cfg.GeoIPDatabases) > 0 {
+		db, err := geoip.Open(cfg.GeoIPDatabases...)
+		if err != nil {
+			log.Fatalf("Invalid GEOIP_DATABASES: %v", err)
+		}
+		log.Infof("Placing client IPs with GeoIP databases %v", db.Types())
+		locate = middleware.Locate(cfg, db)
+	}
+
+	// Requests and errors of the API endpoints, for the admin dashboard
+	activity := &middleware.Activity{}
+
+	// Authenticated API endpoints share the middleware chain, each requiring
+	// a scope of the key
+	protect := func(scope string, h http.HandlerFunc) http.Handler {
+		return middleware.CORS(
+			middleware.Recovery(log)(locate(
+				middleware.RequestLogger(cfg, log)(activity.Track(
+					loadShed(
+						authBan(
+							rateLimitMiddleware(
+								middleware.APIKeyAuth(cfg, log)(
+									middleware.RequireScope(cfg, log, scope)(middleware.DebugRequests(cfg, log)(countRequests(resolveOverrides(middleware.PrivacyMode(cfg)(h))))),
+								),
+							),
+						),
+					),
+				)),
+			)),
+		)
+	}
+
+	// Upload parts skip rate limiting, as a large file takes many requests,
+	// but not the ban on guessing keys
+	authenticate := func(scope string, h http.HandlerFunc) http.Handler {
+		return middleware.CORS(
+			middleware.Recovery(log)(locate(
+				middleware.RequestLogger(cfg, log)(activity.Track(
+					authBan(
+						middleware.APIKeyAuth(cfg, log)(
+							middleware.RequireScope(cfg, log, scope)(middleware.DebugRequests(cfg, log)(countRequests(resolveOverrides(middleware.PrivacyMode(cfg)(h))))),
+						),
+					),
+				)),
+			)),
+		)
+	}
+
+	// Metadata endpoints for each API version. OPTIONS on the extraction
+	// and tus upload endpoints reports what they take, without an API key.
+	metadataOptions, jsonPostOptions := handlers.MetadataOptions(cfg), handlers.JSONPostOptions()
+	uploadsOptions, uploadOptions := handlers.UploadsOptions(deps), handlers.UploadOptions(deps)
+	for _, version := range handlers.Versions {
+		prefix := "/" + version.Name
+		// Result schema, public like the API contract
+		mux.Handle(prefix+"/schema", middleware.CORS(handlers.SchemaHandler(log, version)))
+		mux.Handle(prefix+"/metadata", handlers.AnswerOptions(metadataOptions, protect(config.ScopeMetadataWrite, handlers.VersionedMetadataHandler(cfg, log, deps, version))))
+		mux.Handle(prefix+"/metadata/{sha256}", protect(config.ScopeMetadataRead, handlers.LookupHandler(cfg, log, deps, version)))
+		mux.Handle(prefix+"/metadata/remote", handlers.AnswerOptions(jsonPostOptions, protect(config.ScopeMetadataWrite, handlers.RemoteMetadataHandler(cfg, log, deps, version))))
+		mux.Handle(prefix+"/metadata/s3", handlers.AnswerOptions(jsonPostOptions, protect(config.ScopeMetadataWrite, handlers.S3MetadataHandler(cfg, log, deps, version))))
+		mux.Handle(prefix+"/metadata/ws", protect(config.ScopeMetadataWrite, handlers.WebSocketHandler(cfg, log, deps, version)))
+		mux.Handle(prefix+"/uploads", handlers.AnswerOptions(uploadsOptions, protect(config.ScopeMetadataWrite, handlers.UploadsHandler(cfg, log, deps))))
+		mux.Handle(prefix+"/uploads/{id}", handlers.AnswerOptions(uploadOptions, authenticate(config.ScopeMetadataWrite, handlers.UploadHandler(log, deps))))
+		mux.Handle(prefix+"/uploads/{id}/metadata", protect(config.ScopeMetadataWrite, handlers.UploadMetadataHandler(cfg, log, deps, version)))
+		mux.Handle(prefix+"/jobs", protect(config.ScopeMetadataWrite, handlers.JobsHandler(cfg, log, deps, version)))
+		mux.Handle(prefix+"/jobs/{id}", protect(config.ScopeMetadataRead, handlers.JobHandler(log, deps, version)))
+		mux.Handle(prefix+"/jobs/{id}/events", protect(config.ScopeMetadataRead, handlers.JobEventsHandler(log, deps, version)))
+		mux.Handle(prefix+"/history", protect(config.ScopeMetadataRead, handlers.HistoryHandler(log, deps)))
+		mux.Handle(prefix+"/similar", protect(config.ScopeMetadataRead, handlers.SimilarHandler(log, deps)))
+		mux.Handle(prefix+"/usage", protect(config.ScopeMetadataRead, handlers.UsageHandler(log, deps)))
+	}
+
+	// Access tokens for OAuth clients, which have no key to check yet. Wrong
+	// client secrets are guessed keys, so they count towards bans.
+	mux.Handle("/oauth/token", middleware.CORS(
+		middleware.Recovery(log)(locate(
+			middleware.RequestLogger(cfg, log)(
+				loadShed(
+					authBan(
+						rateLimitMiddleware(handlers.TokenHandler(cfg, log)),
+					),
 				),
 			),
-		),
-	)
+		)),
+	))
+
+	// Every key's usage and its billing export, for admin keys
+	mux.Handle("/admin/usage", protect(config.ScopeAdmin, handlers.AdminUsageHandler(log, deps)))
+	mux.Handle("/admin/usage/export", protect(config.ScopeAdmin, handlers.UsageExportHandler(log, deps)))
+
+	// Per-key settings overrides, for admin keys
+	mux.Handle("/admin/overrides/{key_id}", protect(config.ScopeAdmin, handlers.OverridesHandler(log, deps)))
+
+	// This instance's log level, for admin keys
+	mux.Handle("/admin/log-level", protect(config.ScopeAdmin, handlers.LogLevelHandler(log)))
 
-	mux.Handle("/v1/metadata", middleware.CORS(handler))
+	// This instance's extraction module switches, for admin keys
+	mux.Handle("/admin/modules", protect(config.ScopeAdmin, handlers.ModulesHandler(log, deps)))
+
+	// This instance's effective configuration, secrets redacted, for admin keys
+	mux.Handle("/admin/config", protect(config.ScopeAdmin, handlers.ConfigHandler(cfg, log)))
+
+	// A dashboard of this instance for admin keys. The page holds no data,
+	// and its frequent polls skip rate limiting.
+	mux.Handle("/admin/dashboard/", middleware.Recovery(log)(handlers.DashboardHandler()))
+	mux.Handle("/admin/dashboard/data", authenticate(config.ScopeAdmin, handlers.DashboardDataHandler(log, deps, handlers.Dashboard{
+		Activity: activity,
+		InFlight: requestsInFlight.Count,
+		Started:  started,
+	})))
+
+	// GraphQL over stored results, in the newest version's schema
+	mux.Handle("/graphql", protect(config.ScopeMetadataRead, handlers.GraphQLHandler(log, deps)))
+
+	// Profiling and runtime debugging, for admin keys (optional)
+	if cfg.DebugEndpoints {
+		mux.Handle("/admin/debug/", protect(config.ScopeAdmin, http.StripPrefix("/admin", handlers.DebugHandler(cfg, log)).ServeHTTP))
+		log.Info("Serving profiling and debug endpoints under /admin/debug/ for admin keys")
+	}
 
 	// Create server
 	srv := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              ":" + cfg.Port,
+		Handler:           requestsInFlight.Track(mux),
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		MaxHeaderBytes:    cfg.ServerMaxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(cfg.ServerKeepAlives)
+
+	// HTTPS with modern defaults: only forward-secret AEAD ciphers below
+	// TLS 1.3, whose ciphers are all of those
+	if cfg.TLSEnabled() {
+		srv.TLSConfig = &tls.Config{
+			MinVersion: cfg.TLSMinVersion,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			},
+		}
+	}
+
+	// Certificates from Let's Encrypt or another ACME CA (optional), obtained
+	// and renewed in the background; handshakes fail until the first arrives
+	var certs *acme.Manager
+	if len(cfg.TLSAutocertDomains) > 0 {
+		certs, err = acme.NewManager(cfg.TLSAutocertDirectoryURL, cfg.TLSAutocertDomains, cfg.TLSAutocertEmail, cfg.TLSAutocertDir, log)
+		if err != nil {
+			log.Fatalf("Invalid TLS_AUTOCERT_DIR: %v", err)
+		}
+		srv.TLSConfig.GetCertificate = certs.GetCertificate
+		go certs.Run(context.Background())
+		log.Infof("Using certificates for %s from %s", strings.Join(cfg.TLSAutocertDomains, ", "), cfg.TLSAutocertDirectoryURL)
+	}
+
+	// Client certificates must be issued by a CA of TLS_CLIENT_CA_FILE
+	if cfg.TLSClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to read TLS_CLIENT_CA_FILE: %v", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			log.Fatalf("TLS_CLIENT_CA_FILE has no PEM certificates")
+		}
+		clientAuth := tls.RequireAndVerifyClientCert
+		if cfg.TLSClientCertOptional {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		srv.TLSConfig.ClientCAs = clientCAs
+		srv.TLSConfig.ClientAuth = clientAuth
 	}
 
 	// Start server in a goroutine
 	go func() {
-		log.Infof("Server listening on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSEnabled() {
+			log.Infof("Server listening on port %s with TLS", cfg.Port)
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Infof("Server listening on port %s", cfg.Port)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
+	// Plain HTTP redirecting to HTTPS, and answering the ACME CA (optional)
+	var redirectSrv *http.Server
+	if cfg.HTTPRedirectPort != "" {
+		var redirect http.Handler = handlers.RedirectHTTPSHandler(cfg.Port)
+		if certs != nil {
+			redirect = certs.HTTPHandler(redirect)
+		}
+		redirectSrv = &http.Server{
+			Addr:              ":" + cfg.HTTPRedirectPort,
+			Handler:           redirect,
+			ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+			IdleTimeout:       cfg.ServerIdleTimeout,
+			MaxHeaderBytes:    cfg.ServerMaxHeaderBytes,
+		}
+		go func() {
+			log.Infof("Redirecting HTTP on port %s to HTTPS", cfg.HTTPRedirectPort)
+			if err := redirectSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Redirect server error: %v", err)
+			}
+		}()
+	}
+
+	// Profiling and runtime debugging on a private listener (optional),
+	// without the write timeout so CPU profiles and traces can run long
+	var debugSrv *http.Server
+	if cfg.DebugAddr != "" {
+		if host, _, err := net.SplitHostPort(cfg.DebugAddr); err != nil || !isLoopback(host) {
+			log.Warnf("DEBUG_ADDR %s is not a loopback address; its endpoints need no API key", cfg.DebugAddr)
+		}
+		debugSrv = &http.Server{
+			Addr:              cfg.DebugAddr,
+			Handler:           handlers.DebugHandler(cfg, log),
+			ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		}
+		go func() {
+			log.Infof("Serving profiling and debug endpoints on %s", cfg.DebugAddr)
+			if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Debug server error: %v", err)
+			}
+		}()
+	}
+
+	toggleDebugOnSignal(cfg, log)
+
 	// Wait for interrupt signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 
+	// Stop being ready first, so load balancers send requests elsewhere
+	// before the listener closes; another signal skips the wait
+	draining.Store(true)
+	if cfg.ShutdownDrainDelay > 0 {
+		log.Infof("Server draining for %s before shutting down...", cfg.ShutdownDrainDelay)
+		select {
+		case <-time.After(cfg.ShutdownDrainDelay):
+		case <-quit:
+		}
+	}
+
 	log.Info("Server shutting down...")
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	if debugSrv != nil {
+		debugSrv.Close()
+	}
+	if redirectSrv != nil {
+		redirectSrv.Close()
+	}
+	shutdownErr := srv.Shutdown(ctx)
+
+	// Then for what requests left running in the background, such as async
+	// jobs, within the same deadline
+	if pending := deps.Background.Pending(); len(pending) > 0 {
+		log.Infof("Waiting for %d background tasks: %s", len(pending), strings.Join(pending, ", "))
+	}
+	for _, task := range deps.Background.Wait(ctx) {
+		log.Warnf("Abandoned %s at the shutdown deadline", task)
+	}
+	if shutdownErr != nil {
+		log.Fatalf("Server forced to shutdown: %v", shutdownErr)
 	}
 
 	log.Info("Server stopped gracefully")
 }
+
+// isLoopback reports whether host is a loopback address or localhost
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// configSummary lists the settings that aren't empty as name=value pairs,
+// secrets redacted
+func configSummary(cfg *config.Config) string {
+	var pairs []string
+	for _, s := range cfg.Report() {
+		if s.Value == "" {
+			continue
+		}
+		value := s.Value
+		if strings.ContainsAny(value, " \"") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, s.Name+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-meta/config"
+)
+
+// accessEntry is what the access log records of a request
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	TraceID    string    `json:"trace_id"`
+	ClientIP   string    `json:"client_ip"`
+	Country    string    `json:"country,omitempty"`
+	ASN        string    `json:"asn,omitempty"`
+	APIKey     string    `json:"api_key,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+}
+
+// format renders e as a line of the access log format, one of the config's
+// AccessLog formats other than text
+func (e *accessEntry) format(format string) string {
+	switch format {
+	case config.AccessLogJSON:
+		line, _ := json.Marshal(e)
+		return string(line)
+	case config.AccessLogCombined:
+		bytes := "-"
+		if e.Bytes > 0 {
+			bytes = strconv.FormatInt(e.Bytes, 10)
+		}
+		return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s "%s" "%s"`,
+			e.ClientIP, orDash(e.APIKey), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method, escapeQuoted(e.Path), e.Proto, e.Status, bytes,
+			escapeQuoted(orDash(e.Referer)), escapeQuoted(orDash(e.UserAgent)))
+	default:
+		pairs := []string{
+			"time=" + e.Time.Format(time.RFC3339Nano),
+			"request_id=" + e.RequestID,
+			"trace_id=" + e.TraceID,
+			"client_ip=" + e.ClientIP,
+		}
+		// The location only when GeoIP knows it
+		if e.Country != "" {
+			pairs = append(pairs, "country="+e.Country)
+		}
+		if e.ASN != "" {
+			pairs = append(pairs, "asn="+e.ASN)
+		}
+		pairs = append(pairs,
+			"api_key="+kvValue(orDash(e.APIKey)),
+			"method="+kvValue(e.Method),
+			"path="+kvValue(e.Path),
+			"proto="+kvValue(e.Proto),
+			"status="+strconv.Itoa(e.Status),
+			"bytes="+strconv.FormatInt(e.Bytes, 10),
+			"duration_ms="+strconv.FormatFloat(e.DurationMS, 'f', 3, 64),
+			"user_agent="+kvValue(e.UserAgent),
+			"referer="+kvValue(e.Referer),
+		)
+		return strings.Join(pairs, " ")
+	}
+}
+
+// orDash returns s, or "-" if it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escapeQuoted escapes s for a double-quoted field of the combined format,
+// so clients can't forge fields or lines
+func escapeQuoted(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
+
+// kvValue returns s as a key=value value, quoted if it is empty or has
+// spaces, quotes, "=" or unprintable characters
+func kvValue(s string) string {
+	if s == "" || strings.ContainsAny(s, ` "=`) || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recentErrorsKept is how many error responses Activity remembers
+const recentErrorsKept = 50
+
+// RecentError is an error response Activity saw
+type RecentError struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// Activity counts the requests it tracks and their error responses, and
+// keeps the latest of those for the admin dashboard
+type Activity struct {
+	requests     atomic.Int64
+	clientErrors atomic.Int64
+	serverErrors atomic.Int64
+
+	mu     sync.Mutex
+	recent []RecentError // a ring, next is the oldest once full
+	next   int
+}
+
+// Track counts requests to next and records their 4xx and 5xx responses.
+// It must run inside RequestLogger, whose request ID it records.
+func (a *Activity) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		a.requests.Add(1)
+		switch {
+		case wrapped.statusCode >= 500:
+			a.serverErrors.Add(1)
+		case wrapped.statusCode >= 400:
+			a.clientErrors.Add(1)
+		default:
+			return
+		}
+		a.record(RecentError{
+			Time:       start,
+			RequestID:  GetRequestID(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     wrapped.statusCode,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		})
+	})
+}
+
+// record keeps e, dropping the oldest error once recentErrorsKept are kept
+func (a *Activity) record(e RecentError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.recent) < recentErrorsKept {
+		a.recent = append(a.recent, e)
+		return
+	}
+	a.recent[a.next] = e
+	a.next = (a.next + 1) % recentErrorsKept
+}
+
+// Requests returns the number of requests tracked, and how many of them got
+// a 4xx and a 5xx response
+func (a *Activity) Requests() (total, clientErrors, serverErrors int64) {
+	return a.requests.Load(), a.clientErrors.Load(), a.serverErrors.Load()
+}
+
+// RecentErrors returns the latest error responses, newest first
+func (a *Activity) RecentErrors() []RecentError {
+	a.mu.Lock()
+	recent := slices.Concat(a.recent[a.next:], a.recent[:a.next])
+	a.mu.Unlock()
+
+	slices.Reverse(recent)
+	return recent
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestActivity(t *testing.T) {
+	activity := &Activity{}
+	handler := activity.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}))
+
+	for i := range recentErrorsKept + 5 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?status=404", nil))
+		if i == recentErrorsKept+3 {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?status=200", nil))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/last?status=503", nil))
+		}
+	}
+
+	total, clientErrors, serverErrors := activity.Requests()
+	if total != recentErrorsKept+7 || clientErrors != recentErrorsKept+5 || serverErrors != 1 {
+		t.Errorf("Requests() = %d, %d, %d, want %d, %d, 1", total, clientErrors, serverErrors, recentErrorsKept+7, recentErrorsKept+5)
+	}
+
+	recent := activity.RecentErrors()
+	if len(recent) != recentErrorsKept {
+		t.Fatalf("RecentErrors() has %d errors, want %d", len(recent), recentErrorsKept)
+	}
+	if recent[0].Status != http.StatusNotFound || recent[1].Path != "/last" || recent[1].Status != http.StatusServiceUnavailable {
+		t.Errorf("RecentErrors() starts %+v, %+v, want the newest first", recent[0], recent[1])
+	}
+}
@@ -1,38 +1,151 @@
 package middleware
 
 import (
+	"context"
+	"crypto/x509"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"file-meta/config"
 	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
+	"file-meta/internal/models"
+	"file-meta/internal/oauth"
+	"file-meta/internal/websocket"
 )
 
-// APIKeyAuth validates API key from request header
+var replacedKeyRequests = metrics.NewCounter("file_meta_replaced_key_requests_total",
+	"Requests authenticated with an API key that has been replaced and stops working at its rotation's deadline")
+
+// APIKeyAuth validates API key from request header, or from the api_key
+// query parameter on WebSocket handshakes, an OAuth access token from the
+// Authorization header, or a client certificate listed in CLIENT_CERT_KEYS
 func APIKeyAuth(cfg *config.Config, log *logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := r.Header.Get("X-API-Key")
-
-			if key == "" {
-				log.Warn("Missing API key in request")
-				http.Error(w, "Missing API key", http.StatusUnauthorized)
+			key := requestAPIKey(r)
+			entry, sunset, ok := requestEntry(cfg, r, time.Now())
+			switch {
+			case ok:
+			case key != "":
+				log.Warnf("Invalid API key attempted: %s", logger.KeyPrefix(key))
+				WriteError(w, http.StatusUnauthorized, models.CodeUnauthorized, "Invalid API key")
+				return
+			case bearerToken(r) != "":
+				log.Warn("Invalid access token attempted")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				WriteError(w, http.StatusUnauthorized, models.CodeUnauthorized, "Invalid access token")
+				return
+			default:
+				if cert := clientCert(r); cert != nil {
+					log.Warnf("Client certificate %q isn't listed in CLIENT_CERT_KEYS", cert.Subject.CommonName)
+				} else {
+					log.Warn("Missing API key in request")
+				}
+				WriteError(w, http.StatusUnauthorized, models.CodeUnauthorized, "Missing API key")
 				return
 			}
 
-			if !cfg.APIKeys[key] {
-				log.Warnf("Invalid API key attempted: %s", key[:min(len(key), 8)]+"...")
-				http.Error(w, "Invalid API key", http.StatusUnauthorized)
-				return
+			// Replaced keys keep working until their deadline, with a
+			// warning
+			if !sunset.IsZero() {
+				replacedKeyRequests.Inc()
+				log.Infof("[%s] Replaced API key %s used, it stops working at %s", GetRequestID(r.Context()), logger.KeyPrefix(key), sunset.Format(time.RFC3339))
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+				w.Header().Set("Warning", fmt.Sprintf(`299 file-meta "API key replaced, use the new key before %s"`, sunset.UTC().Format(time.RFC3339)))
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyKey, entry)))
+		})
+	}
+}
+
+// requestEntry returns the API_KEYS entry r authenticates as at now, with an
+// API key or, failing that, an access token of one of OAUTH_CLIENTS or a
+// client certificate, and when its key stops working if it has been replaced
+func requestEntry(cfg *config.Config, r *http.Request, now time.Time) (string, time.Time, bool) {
+	if key := requestAPIKey(r); key != "" {
+		return cfg.LookupAPIKey(key, now)
+	}
+	if token := bearerToken(r); token != "" {
+		if len(cfg.OAuthClients) > 0 {
+			// A token works only while the key it was issued for does,
+			// so a replaced key's tokens stop at its deadline
+			client, key, err := oauth.Verify(cfg.OAuthTokenSecret, token, now)
+			if entry, ok := cfg.OAuthClients[client]; err == nil && ok {
+				if keyEntry, sunset, ok := cfg.LookupKeyFingerprint(key, now); ok && keyEntry == entry {
+					return entry, sunset, true
+				}
 			}
+		}
+		return "", time.Time{}, false
+	}
+	if cert := clientCert(r); cert != nil {
+		if entry, ok := cfg.ClientCertKeys[cert.Subject.CommonName]; ok {
+			return entry, time.Time{}, true
+		}
+		for _, uri := range cert.URIs {
+			if entry, ok := cfg.ClientCertKeys[uri.String()]; ok {
+				return entry, time.Time{}, true
+			}
+		}
+	}
+	return "", time.Time{}, false
+}
 
+// clientCert returns the certificate r's client presented, if the server
+// verified it against TLS_CLIENT_CA_FILE
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// bearerToken returns the access token r was sent with, valid or not
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// requestAPIKey returns the API key r was sent with, valid or not
+func requestAPIKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+
+	// Browsers can't set headers on a WebSocket handshake
+	if key == "" && websocket.IsUpgrade(r) {
+		key = r.URL.Query().Get("api_key")
+	}
+	return key
+}
+
+// RequireScope rejects requests whose API key lacks scope. It must run after
+// APIKeyAuth.
+func RequireScope(cfg *config.Config, log *logger.Logger, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.HasScope(GetAPIKey(r.Context()), scope) {
+				log.Warnf("[%s] API key without the %s scope denied access to %s", GetRequestID(r.Context()), scope, r.URL.Path)
+				WriteError(w, http.StatusForbidden, models.CodeForbidden, "API key lacks the "+scope+" scope")
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+const apiKeyKey contextKey = "apiKey"
+
+// GetAPIKey retrieves the authenticated API key from context, as it is
+// listed in API_KEYS: the key itself, or its hash for hashed entries
+func GetAPIKey(ctx context.Context) string {
+	if key, ok := ctx.Value(apiKeyKey).(string); ok {
+		return key
 	}
-	return b
+	return ""
 }
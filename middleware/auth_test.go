@@ -1,18 +1,25 @@
 package middleware
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"file-meta/config"
 	"file-meta/internal/logger"
+	"file-meta/internal/oauth"
 )
 
 func TestAPIKeyAuth(t *testing.T) {
 	cfg := &config.Config{
 		APIKeys: map[string]bool{
-			"valid_key": true,
+			"valid_key":                     true,
+			config.HashAPIKey("hashed_key"): true,
 		},
 	}
 	log := logger.New("info")
@@ -20,13 +27,28 @@ func TestAPIKeyAuth(t *testing.T) {
 	tests := []struct {
 		name           string
 		apiKey         string
+		target         string
+		websocket      bool
 		expectedStatus int
+		// wantKey is the key the next handler sees, valid_key if empty
+		wantKey string
 	}{
 		{
 			name:           "valid API key",
 			apiKey:         "valid_key",
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "key listed hashed",
+			apiKey:         "hashed_key",
+			expectedStatus: http.StatusOK,
+			wantKey:        config.HashAPIKey("hashed_key"),
+		},
+		{
+			name:           "hash of a key listed hashed",
+			apiKey:         config.HashAPIKey("hashed_key"),
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:           "invalid API key",
 			apiKey:         "invalid_key",
@@ -37,12 +59,25 @@ func TestAPIKeyAuth(t *testing.T) {
 			apiKey:         "",
 			expectedStatus: http.StatusUnauthorized,
 		},
+		{
+			name:           "query key on WebSocket handshake",
+			target:         "/test?api_key=valid_key",
+			websocket:      true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "query key on plain request",
+			target:         "/test?api_key=valid_key",
+			expectedStatus: http.StatusUnauthorized,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create a test handler that returns 200 OK
+			var gotKey string
 			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotKey = GetAPIKey(r.Context())
 				w.WriteHeader(http.StatusOK)
 			})
 
@@ -50,7 +85,15 @@ func TestAPIKeyAuth(t *testing.T) {
 			handler := APIKeyAuth(cfg, log)(nextHandler)
 
 			// Create request
-			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			target := "/test"
+			if tt.target != "" {
+				target = tt.target
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.websocket {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
 			if tt.apiKey != "" {
 				req.Header.Set("X-API-Key", tt.apiKey)
 			}
@@ -65,6 +108,195 @@ func TestAPIKeyAuth(t *testing.T) {
 			if status := rr.Code; status != tt.expectedStatus {
 				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
 			}
+			wantKey := tt.wantKey
+			if wantKey == "" {
+				wantKey = "valid_key"
+			}
+			if rr.Code == http.StatusOK && gotKey != wantKey {
+				t.Errorf("handler saw API key %q, want %q", gotKey, wantKey)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthReplacedKey(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys: map[string]bool{"old_key": true},
+		APIKeyRotations: map[string]config.KeyRotation{
+			"new_key": {Replaces: "old_key", Until: time.Now().Add(time.Hour)},
+		},
+	}
+	var gotKey string
+	handler := APIKeyAuth(cfg, logger.New("error"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = GetAPIKey(r.Context())
+	}))
+
+	tests := []struct {
+		key         string
+		wantWarning bool
+	}{
+		{"old_key", true},
+		{"new_key", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-API-Key", tt.key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || gotKey != "old_key" {
+			t.Errorf("%s: status %d with key %q, want 200 with old_key", tt.key, rr.Code, gotKey)
+		}
+		if hasWarning := rr.Header().Get("Sunset") != "" && rr.Header().Get("Warning") != ""; hasWarning != tt.wantWarning {
+			t.Errorf("%s: Sunset %q, Warning %q, want them: %v", tt.key, rr.Header().Get("Sunset"), rr.Header().Get("Warning"), tt.wantWarning)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:      map[string]bool{"customer": true, "operator": true, "dashboard": true},
+		AdminAPIKeys: map[string]bool{"operator": true},
+		KeyScopes: map[string]map[string]bool{
+			"dashboard": {config.ScopeMetadataRead: true},
+		},
+	}
+	log := logger.New("info")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		apiKey         string
+		scope          string
+		expectedStatus int
+	}{
+		{apiKey: "operator", scope: config.ScopeAdmin, expectedStatus: http.StatusOK},
+		{apiKey: "customer", scope: config.ScopeAdmin, expectedStatus: http.StatusForbidden},
+		{apiKey: "", scope: config.ScopeAdmin, expectedStatus: http.StatusUnauthorized},
+		{apiKey: "customer", scope: config.ScopeMetadataWrite, expectedStatus: http.StatusOK},
+		{apiKey: "dashboard", scope: config.ScopeMetadataRead, expectedStatus: http.StatusOK},
+		{apiKey: "dashboard", scope: config.ScopeMetadataWrite, expectedStatus: http.StatusForbidden},
+		{apiKey: "dashboard", scope: config.ScopeAdmin, expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.apiKey+" "+tt.scope, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/usage", nil)
+			req.Header.Set("X-API-Key", tt.apiKey)
+			rr := httptest.NewRecorder()
+			APIKeyAuth(cfg, log)(RequireScope(cfg, log, tt.scope)(ok)).ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthAccessToken(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	cfg := &config.Config{
+		APIKeys:          map[string]bool{"valid_key": true, "old_key": true},
+		OAuthClients:     map[string]string{"reports": "valid_key", "legacy": "old_key"},
+		OAuthTokenSecret: secret,
+		APIKeyRotations: map[string]config.KeyRotation{
+			"new_key":     {Replaces: "valid_key", Until: time.Now().Add(time.Hour)},
+			"current_key": {Replaces: "old_key", Until: time.Now().Add(-time.Minute)},
+		},
+	}
+	var gotKey string
+	handler := APIKeyAuth(cfg, logger.New("error"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = GetAPIKey(r.Context())
+	}))
+
+	issue := func(client, key string, secret []byte, ttl time.Duration) string {
+		token, err := oauth.Issue(secret, client, cfg.KeyFingerprint(key), ttl, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid token", issue("reports", "valid_key", secret, time.Hour), http.StatusOK},
+		{"token of a replacement key", issue("reports", "new_key", secret, time.Hour), http.StatusOK},
+		{"expired token", issue("reports", "valid_key", secret, -time.Minute), http.StatusUnauthorized},
+		{"token of another secret", issue("reports", "valid_key", []byte("fedcba9876543210fedcba9876543210"), time.Hour), http.StatusUnauthorized},
+		{"token of an unknown client", issue("nobody", "valid_key", secret, time.Hour), http.StatusUnauthorized},
+		{"token of another client's key", issue("reports", "old_key", secret, time.Hour), http.StatusUnauthorized},
+		{"token of a key past its deadline", issue("legacy", "old_key", secret, time.Hour), http.StatusUnauthorized},
+		{"token of an unknown key", issue("reports", "guessed_key", secret, time.Hour), http.StatusUnauthorized},
+		{"not a bearer token", "Basic cmVwb3J0czp2YWxpZF9rZXk=", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKey = ""
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.want)
+			}
+			if rr.Code == http.StatusOK && gotKey != "valid_key" {
+				t.Errorf("handler saw API key %q, want valid_key", gotKey)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthClientCert(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys: map[string]bool{"valid_key": true, "other_key": true},
+		ClientCertKeys: map[string]string{
+			"reporting":                    "valid_key",
+			"spiffe://example.org/billing": "valid_key",
+		},
+	}
+	var gotKey string
+	handler := APIKeyAuth(cfg, logger.New("error"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = GetAPIKey(r.Context())
+	}))
+
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		apiKey string
+		want   int
+		// wantKey is the key the next handler sees, valid_key if empty
+		wantKey string
+	}{
+		{name: "common name", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "reporting"}}, want: http.StatusOK},
+		{name: "URI SAN", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{spiffe}}, want: http.StatusOK},
+		{name: "unlisted certificate", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}, want: http.StatusUnauthorized},
+		{name: "API key wins", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "reporting"}}, apiKey: "other_key", want: http.StatusOK, wantKey: "other_key"},
+		{name: "no certificate", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKey = ""
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.cert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			}
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.want)
+			}
+			wantKey := tt.wantKey
+			if wantKey == "" {
+				wantKey = "valid_key"
+			}
+			if rr.Code == http.StatusOK && gotKey != wantKey {
+				t.Errorf("handler saw API key %q, want %q", gotKey, wantKey)
+			}
 		})
 	}
 }
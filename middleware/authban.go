@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
+	"file-meta/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	authFailures = metrics.NewCounter("file_meta_auth_failures_total",
+		"Requests with an invalid API key or access token")
+	authBansIssued = metrics.NewCounter("file_meta_auth_bans_total",
+		"Client IPs banned for sending too many invalid API keys")
+	authBanRejections = metrics.NewCounter("file_meta_auth_ban_rejections_total",
+		"Requests rejected because their client IP was banned")
+)
+
+// authBans counts invalid API keys per client IP and bans the IPs that send
+// too many
+type authBans interface {
+	// banned returns how much longer ip is banned, or zero
+	banned(ctx context.Context, ip string, now time.Time) (time.Duration, error)
+	// failure records an invalid key from ip and returns the ban it earned,
+	// or zero
+	failure(ctx context.Context, ip string, now time.Time) (time.Duration, error)
+}
+
+// AuthBan bans client IPs that send AUTH_BAN_THRESHOLD invalid API keys
+// within AUTH_BAN_WINDOW, so keys can't be guessed at full request rate.
+// Banned IPs get 429 for AUTH_BAN_DURATION, doubling with each ban up to
+// AUTH_BAN_MAX_DURATION. With a Redis client the counts are shared between
+// instances; while Redis fails nobody is banned, as APIKeyAuth still rejects
+// the keys. It must run before APIKeyAuth.
+func AuthBan(cfg *config.Config, log *logger.Logger, redisClient *redis.Client) func(http.Handler) http.Handler {
+	if cfg.AuthBanThreshold == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	var bans authBans
+	if redisClient != nil {
+		bans = &redisAuthBans{client: redisClient, cfg: cfg}
+	} else {
+		bans = newMemoryAuthBans(cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r, cfg.TrustedProxies)
+			now := time.Now()
+
+			ban, err := bans.banned(r.Context(), ip, now)
+			if err != nil {
+				log.Errorf("Redis error: %v", err)
+			}
+			if ban > 0 {
+				authBanRejections.Inc()
+				log.Warnf("[%s] Rejecting banned client IP: %s", GetRequestID(r.Context()), ip)
+				authBanned(w, cfg, ban)
+				return
+			}
+
+			fail := func() {
+				authFailures.Inc()
+				ban, err := bans.failure(r.Context(), ip, now)
+				if err != nil {
+					log.Errorf("Redis error: %v", err)
+				}
+				if ban > 0 {
+					authBansIssued.Inc()
+					log.Warnf("Banned client IP %s for %s after %d invalid API keys", ip, ban, cfg.AuthBanThreshold)
+				}
+			}
+
+			// Missing keys are left to APIKeyAuth: they guess nothing
+			presented := requestAPIKey(r) != "" || bearerToken(r) != ""
+			if _, _, valid := requestEntry(cfg, r, now); presented && !valid {
+				fail()
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authFailureKey, fail)))
+		})
+	}
+}
+
+// authFailureKey holds AuthBan's func that counts an invalid key
+const authFailureKey contextKey = "authFailure"
+
+// AuthFailed counts an invalid key that a handler checked itself, such as an
+// OAuth client secret, towards the ban of the request's client IP. It does
+// nothing outside AuthBan.
+func AuthFailed(ctx context.Context) {
+	if fail, ok := ctx.Value(authFailureKey).(func()); ok {
+		fail()
+	}
+}
+
+// authBanned answers a request from a banned IP
+func authBanned(w http.ResponseWriter, cfg *config.Config, ban time.Duration) {
+	wait := max(1, seconds(ban))
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", wait))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(RateLimitError{Error: "Too many invalid API keys", Code: models.CodeAuthBanned, Limit: cfg.AuthBanThreshold, RetryAfter: wait})
+}
+
+// banDuration is how long an IP's bans-th ban lasts
+func banDuration(cfg *config.Config, bans int) time.Duration {
+	ban := cfg.AuthBanDuration
+	for i := 1; i < bans && ban < cfg.AuthBanMaxDuration; i++ {
+		ban *= 2
+	}
+	if ban > cfg.AuthBanMaxDuration {
+		ban = cfg.AuthBanMaxDuration
+	}
+	return ban
+}
+
+// ipStrikes is what memoryAuthBans knows about a client IP
+type ipStrikes struct {
+	failures    int
+	windowStart time.Time
+	bans        int
+	until       time.Time
+}
+
+// memoryAuthBans keeps the counts of a single instance
+type memoryAuthBans struct {
+	cfg *config.Config
+
+	mu  sync.Mutex
+	ips map[string]*ipStrikes
+}
+
+func newMemoryAuthBans(cfg *config.Config) *memoryAuthBans {
+	b := &memoryAuthBans{cfg: cfg, ips: make(map[string]*ipStrikes)}
+	go func() {
+		ticker := time.NewTicker(cfg.AuthBanWindow)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			b.cleanup(now)
+		}
+	}()
+	return b
+}
+
+func (b *memoryAuthBans) banned(_ context.Context, ip string, now time.Time) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := b.ips[ip]; s != nil && now.Before(s.until) {
+		return s.until.Sub(now), nil
+	}
+	return 0, nil
+}
+
+func (b *memoryAuthBans) failure(_ context.Context, ip string, now time.Time) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.ips[ip]
+	if s == nil {
+		s = &ipStrikes{}
+		b.ips[ip] = s
+	}
+	if now.Sub(s.windowStart) >= b.cfg.AuthBanWindow {
+		s.failures = 0
+		s.windowStart = now
+	}
+	s.failures++
+	if s.failures < b.cfg.AuthBanThreshold {
+		return 0, nil
+	}
+
+	s.failures = 0
+	s.bans++
+	ban := banDuration(b.cfg, s.bans)
+	s.until = now.Add(ban)
+	return ban, nil
+}
+
+// cleanup forgets IPs without recent failures once their last ban is
+// AUTH_BAN_MAX_DURATION in the past, so their next ban is short again
+func (b *memoryAuthBans) cleanup(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ip, s := range b.ips {
+		if now.Sub(s.windowStart) >= b.cfg.AuthBanWindow && now.Sub(s.until) >= b.cfg.AuthBanMaxDuration {
+			delete(b.ips, ip)
+		}
+	}
+}
+
+// authFailureScript counts an invalid key in KEYS[1], which expires after
+// the window of ARGV[2] milliseconds. At ARGV[1] failures it bans the IP by
+// setting KEYS[3] for ARGV[3] milliseconds, doubled for every earlier ban
+// counted in KEYS[2] and capped at ARGV[4], and returns the ban, else 0. The
+// count of bans is forgotten ARGV[4] after the ban ends.
+var authFailureScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if failures < tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+local bans = redis.call('INCR', KEYS[2])
+local ban = math.min(tonumber(ARGV[3]) * 2 ^ (bans - 1), tonumber(ARGV[4]))
+redis.call('SET', KEYS[3], 1, 'PX', ban)
+redis.call('PEXPIRE', KEYS[2], ban + tonumber(ARGV[4]))
+return ban
+`)
+
+// redisAuthBans shares the counts between instances
+type redisAuthBans struct {
+	client *redis.Client
+	cfg    *config.Config
+}
+
+func (b *redisAuthBans) banned(ctx context.Context, ip string, _ time.Time) (time.Duration, error) {
+	ttl, err := b.client.PTTL(ctx, "authban:banned:"+ip).Result()
+	if err != nil || ttl < 0 {
+		// A missing key has a negative TTL
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (b *redisAuthBans) failure(ctx context.Context, ip string, _ time.Time) (time.Duration, error) {
+	ban, err := authFailureScript.Run(ctx, b.client,
+		[]string{"authban:failures:" + ip, "authban:bans:" + ip, "authban:banned:" + ip},
+		b.cfg.AuthBanThreshold,
+		b.cfg.AuthBanWindow.Milliseconds(),
+		b.cfg.AuthBanDuration.Milliseconds(),
+		b.cfg.AuthBanMaxDuration.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ban) * time.Millisecond, nil
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+func TestAuthBan(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:            map[string]bool{"valid_key": true},
+		AuthBanThreshold:   3,
+		AuthBanWindow:      time.Minute,
+		AuthBanDuration:    time.Minute,
+		AuthBanMaxDuration: time.Hour,
+	}
+	log := logger.New("error")
+	handler := AuthBan(cfg, log, nil)(APIKeyAuth(cfg, log)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		name string
+		ip   string
+		key  string
+		want int
+	}{
+		{name: "first guess", ip: "192.0.2.1", key: "guess_1", want: http.StatusUnauthorized},
+		{name: "missing key isn't a guess", ip: "192.0.2.1", want: http.StatusUnauthorized},
+		{name: "second guess", ip: "192.0.2.1", key: "guess_2", want: http.StatusUnauthorized},
+		{name: "guess that earns the ban", ip: "192.0.2.1", key: "guess_3", want: http.StatusUnauthorized},
+		{name: "banned guess", ip: "192.0.2.1", key: "guess_4", want: http.StatusTooManyRequests},
+		{name: "banned valid key", ip: "192.0.2.1", key: "valid_key", want: http.StatusTooManyRequests},
+		{name: "other IP", ip: "192.0.2.2", key: "valid_key", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/metadata", nil)
+			req.RemoteAddr = tt.ip + ":1234"
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.want)
+			}
+			if rr.Code != http.StatusTooManyRequests {
+				return
+			}
+			if got := rr.Header().Get("Retry-After"); got != "60" {
+				t.Errorf("Retry-After = %q, want 60", got)
+			}
+			var body RateLimitError
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body.Error != "Too many invalid API keys" {
+				t.Errorf("body = %+v (%v), want the ban error", body, err)
+			}
+		})
+	}
+}
+
+func TestMemoryAuthBansBackoff(t *testing.T) {
+	cfg := &config.Config{
+		AuthBanThreshold:   2,
+		AuthBanWindow:      time.Minute,
+		AuthBanDuration:    time.Minute,
+		AuthBanMaxDuration: 3 * time.Minute,
+	}
+	bans := &memoryAuthBans{cfg: cfg, ips: make(map[string]*ipStrikes)}
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	// Each ban doubles the last, up to the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		if ban, _ := bans.failure(ctx, "192.0.2.1", now); ban != 0 {
+			t.Fatalf("first failure banned for %v, want no ban", ban)
+		}
+		ban, _ := bans.failure(ctx, "192.0.2.1", now)
+		if ban != want {
+			t.Errorf("ban = %v, want %v", ban, want)
+		}
+		if left, _ := bans.banned(ctx, "192.0.2.1", now.Add(ban-time.Second)); left != time.Second {
+			t.Errorf("banned() a second before the end = %v, want 1s", left)
+		}
+		now = now.Add(ban)
+		if left, _ := bans.banned(ctx, "192.0.2.1", now); left != 0 {
+			t.Errorf("banned() once over = %v, want 0", left)
+		}
+	}
+
+	// Failures spread over more than a window don't add up
+	later := now.Add(cfg.AuthBanMaxDuration)
+	bans.failure(ctx, "192.0.2.2", later.Add(-time.Minute))
+	if ban, _ := bans.failure(ctx, "192.0.2.2", later); ban != 0 {
+		t.Errorf("failures a window apart banned for %v, want no ban", ban)
+	}
+
+	// IPs are forgotten, bans and all, a maximum ban after their last
+	bans.cleanup(later)
+	if _, ok := bans.ips["192.0.2.1"]; ok {
+		t.Error("cleanup() kept an IP whose last ban is long over")
+	}
+	if _, ok := bans.ips["192.0.2.2"]; !ok {
+		t.Error("cleanup() dropped an IP with recent failures")
+	}
+}
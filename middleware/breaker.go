@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
+	"file-meta/internal/models"
+)
+
+var (
+	breakerState = metrics.NewGauge("file_meta_ratelimit_breaker_state",
+		"State of the Redis rate limiter's circuit breaker: 0 closed, 1 half-open, 2 open")
+	breakerTrips = metrics.NewCounter("file_meta_ratelimit_breaker_trips_total",
+		"Times the Redis rate limiter's circuit breaker opened")
+	redisLimiterErrors = metrics.NewCounter("file_meta_ratelimit_redis_errors_total",
+		"Redis errors while rate limiting")
+	redisLimiterFallbacks = metrics.NewCounter("file_meta_ratelimit_fallback_requests_total",
+		"Requests handled per RATE_LIMIT_FAIL_MODE because Redis was unavailable")
+)
+
+// Circuit breaker states, as reported by breakerState
+const (
+	breakerClosed = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// breaker stops calls to a failing dependency. After failures errors in a
+// row it opens for cooldown, then lets a single call through to probe it:
+// success closes it again and failure reopens it.
+type breaker struct {
+	failures int
+	cooldown time.Duration
+	log      *logger.Logger
+
+	mu       sync.Mutex
+	state    int
+	errors   int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(failures int, cooldown time.Duration, log *logger.Logger) *breaker {
+	return &breaker{failures: max(failures, 1), cooldown: cooldown, log: log}
+}
+
+// allow reports whether a call may be made
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// success records a call that worked
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.errors = 0
+	b.probing = false
+	if b.state != breakerClosed {
+		b.log.Info("Redis rate limiting recovered, circuit breaker closed")
+		b.setState(breakerClosed)
+	}
+}
+
+// abandon records a call that neither worked nor failed, such as one whose
+// request was canceled
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// failure records a call that failed, opening the breaker if it was a probe
+// or one too many
+func (b *breaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.errors++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.errors >= b.failures) {
+		if b.state == breakerClosed {
+			breakerTrips.Inc()
+			b.log.Warnf("Redis rate limiting failed %d times in a row, circuit breaker open for %s", b.errors, b.cooldown)
+		}
+		b.setState(breakerOpen)
+		b.openedAt = now
+	}
+}
+
+// setState changes the state. The lock must be held.
+func (b *breaker) setState(state int) {
+	b.state = state
+	breakerState.Set(float64(state))
+}
+
+// redisGuard runs a Redis rate limiter behind a circuit breaker, handling
+// requests per RATE_LIMIT_FAIL_MODE while Redis is unavailable
+type redisGuard struct {
+	breaker *breaker
+	cfg     *config.Config
+	log     *logger.Logger
+	local   func(http.Handler) http.Handler // the in-memory limiter for RateLimitFailMemory
+}
+
+func newRedisGuard(cfg *config.Config, log *logger.Logger, local func(http.Handler) http.Handler) *redisGuard {
+	return &redisGuard{
+		breaker: newBreaker(cfg.RateLimitBreakerFailures, cfg.RateLimitBreakerCooldown, log),
+		cfg:     cfg,
+		log:     log,
+		local:   local,
+	}
+}
+
+// fallback returns the handler for requests Redis can't limit
+func (g *redisGuard) fallback(next http.Handler) http.Handler {
+	var handler http.Handler
+	switch g.cfg.RateLimitFailMode {
+	case config.RateLimitFailClosed:
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", max(1, seconds(g.cfg.RateLimitBreakerCooldown))))
+			WriteError(w, http.StatusServiceUnavailable, models.CodeRateLimiterUnavailable, "Rate limiter unavailable")
+		})
+	case config.RateLimitFailMemory:
+		handler = g.local(next)
+	default:
+		handler = next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redisLimiterFallbacks.Inc()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// failed records a Redis error
+func (g *redisGuard) failed(err error, now time.Time) {
+	redisLimiterErrors.Inc()
+	g.log.Errorf("Redis error: %v", err)
+	g.breaker.failure(now)
+}
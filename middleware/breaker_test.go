@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, time.Minute, logger.New("info"))
+	start := time.Now()
+
+	steps := []struct {
+		name    string
+		at      time.Duration
+		allowed bool
+		failed  bool
+		state   int
+	}{
+		{name: "first error", allowed: true, failed: true, state: breakerClosed},
+		{name: "second error trips", allowed: true, failed: true, state: breakerOpen},
+		{name: "open", at: 30 * time.Second, allowed: false, state: breakerOpen},
+		{name: "failed probe reopens", at: time.Minute, allowed: true, failed: true, state: breakerOpen},
+		{name: "reopened", at: 90 * time.Second, allowed: false, state: breakerOpen},
+		{name: "probe closes", at: 2 * time.Minute, allowed: true, state: breakerClosed},
+		{name: "closed", at: 2 * time.Minute, allowed: true, state: breakerClosed},
+	}
+
+	for _, step := range steps {
+		now := start.Add(step.at)
+		if allowed := b.allow(now); allowed != step.allowed {
+			t.Fatalf("%s: allow() = %v, want %v", step.name, allowed, step.allowed)
+		}
+		if step.allowed && step.failed {
+			b.failure(now)
+		} else if step.allowed {
+			b.success()
+		}
+		if b.state != step.state {
+			t.Fatalf("%s: state = %d, want %d", step.name, b.state, step.state)
+		}
+	}
+
+	// A half-open breaker lets only one probe through
+	b.failure(start)
+	b.failure(start)
+	if !b.allow(start.Add(time.Minute)) || b.allow(start.Add(time.Minute)) {
+		t.Error("half-open breaker should allow exactly one probe")
+	}
+	b.abandon()
+	if !b.allow(start.Add(time.Minute)) {
+		t.Error("half-open breaker should allow a new probe after one is abandoned")
+	}
+}
+
+func TestRedisRateLimitFailMode(t *testing.T) {
+	// Nothing listens on port 1, so every call fails at once
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer client.Close()
+
+	tests := []struct {
+		failMode string
+		want     []int
+	}{
+		{failMode: config.RateLimitFailOpen, want: []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+		{failMode: config.RateLimitFailClosed, want: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}},
+		{failMode: config.RateLimitFailMemory, want: []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.failMode, func(t *testing.T) {
+			key := "fail_" + tt.failMode + "_key"
+			cfg := &config.Config{
+				APIKeys:                  map[string]bool{key: true},
+				RateLimitRequests:        1,
+				RateLimitWindow:          time.Minute,
+				RateLimitFailMode:        tt.failMode,
+				RateLimitBreakerFailures: 1,
+				RateLimitBreakerCooldown: time.Minute,
+			}
+			handler := RedisRateLimit(cfg, logger.New("error"), client)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			errorsBefore := redisLimiterErrors.Value()
+			for i, want := range tt.want {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-API-Key", key)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				if rr.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, rr.Code, want)
+				}
+			}
+
+			// The breaker opened after the first error, sparing Redis the rest
+			if errors := redisLimiterErrors.Value() - errorsBefore; errors != 1 {
+				t.Errorf("Redis errors = %v, want 1", errors)
+			}
+		})
+	}
+}
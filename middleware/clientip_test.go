@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:4242", want: "203.0.113.7"},
+		{name: "untrusted proxy", remoteAddr: "203.0.113.7:4242", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:4242", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged hop", remoteAddr: "10.0.0.2:4242", forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy chain", remoteAddr: "10.0.0.2:4242", forwarded: []string{"198.51.100.1, 10.0.0.9", "10.0.0.3"}, want: "198.51.100.1"},
+		{name: "malformed hop", remoteAddr: "10.0.0.2:4242", forwarded: []string{"unknown"}, want: "10.0.0.2"},
+		{name: "no header", remoteAddr: "10.0.0.2:4242", want: "10.0.0.2"},
+		{name: "IPv6", remoteAddr: "[fd00::1]:4242", forwarded: []string{"2001:db8::5"}, want: "2001:db8::5"},
+		{name: "IPv4-mapped", remoteAddr: "[::ffff:10.0.0.2]:4242", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := ClientIP(req, trusted); got != tt.want {
+				t.Errorf("ClientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+// DebugHeader asks for a request's debug messages to be logged whatever the
+// log level
+const DebugHeader = "X-Debug"
+
+// DebugRequests logs the debug messages of requests with a true X-Debug
+// header, when their API key has the admin scope, so a problem can be
+// reproduced without raising the level for everyone. It must run after
+// APIKeyAuth.
+func DebugRequests(cfg *config.Config, log *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			debug, _ := strconv.ParseBool(r.Header.Get(DebugHeader))
+			if !debug {
+				next.ServeHTTP(w, r)
+				return
+			}
+			requestID := GetRequestID(r.Context())
+			if !cfg.HasScope(GetAPIKey(r.Context()), config.ScopeAdmin) {
+				log.Warnf("[%s] Ignoring %s from an API key without the admin scope", requestID, DebugHeader)
+				next.ServeHTTP(w, r)
+				return
+			}
+			log.Infof("[%s] Debug logging requested for %s %s", requestID, r.Method, r.URL.Path)
+			next.ServeHTTP(w, r.WithContext(logger.WithDebug(r.Context())))
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+func TestDebugRequests(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:      map[string]bool{"customer": true, "operator": true},
+		AdminAPIKeys: map[string]bool{"operator": true},
+	}
+	log := logger.New("error")
+
+	tests := []struct {
+		name   string
+		apiKey string
+		header string
+		want   bool
+	}{
+		{name: "admin", apiKey: "operator", header: "1", want: true},
+		{name: "admin without header", apiKey: "operator"},
+		{name: "admin with false", apiKey: "operator", header: "false"},
+		{name: "customer", apiKey: "customer", header: "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var debugging bool
+			handler := APIKeyAuth(cfg, log)(DebugRequests(cfg, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				debugging = logger.Debugging(r.Context())
+			})))
+			req := httptest.NewRequest(http.MethodGet, "/v1/history", nil)
+			req.Header.Set("X-API-Key", tt.apiKey)
+			if tt.header != "" {
+				req.Header.Set(DebugHeader, tt.header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if debugging != tt.want {
+				t.Errorf("debugging = %v, want %v", debugging, tt.want)
+			}
+		})
+	}
+}
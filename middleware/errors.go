@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"file-meta/internal/models"
+)
+
+// WriteError answers with an ErrorResponse carrying message and one of the
+// models.Code values
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Error: message, Code: code})
+}
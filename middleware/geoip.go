@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+
+	"file-meta/config"
+	"file-meta/internal/geoip"
+)
+
+// Locator places IPs, as geoip.DB does
+type Locator interface {
+	Lookup(ip netip.Addr) geoip.Location
+}
+
+// Locate looks the client IP up with locator, for RequestLogger and
+// CountRequests to record where requests come from. It must run before
+// both.
+func Locate(cfg *config.Config, locator Locator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, err := netip.ParseAddr(ClientIP(r, cfg.TrustedProxies))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := context.WithValue(r.Context(), locationKey, locator.Lookup(ip))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+const locationKey contextKey = "location"
+
+// GetLocation retrieves the client's location from context, which is empty
+// without Locate or if the databases don't know the IP
+func GetLocation(ctx context.Context) geoip.Location {
+	location, _ := ctx.Value(locationKey).(geoip.Location)
+	return location
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/geoip"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/usage"
+)
+
+// testLocator places 203.0.113.0/24 in the US, on AS64496
+type testLocator struct{}
+
+func (testLocator) Lookup(ip netip.Addr) geoip.Location {
+	if netip.MustParsePrefix("203.0.113.0/24").Contains(ip) {
+		return geoip.Location{Country: "US", ASN: 64496}
+	}
+	return geoip.Location{}
+}
+
+func TestLocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientIP string
+		format   string
+		wantLog  string
+		located  bool
+	}{
+		{name: "text", clientIP: "203.0.113.7", format: config.AccessLogText, wantLog: ") from US AS64496\n", located: true},
+		{name: "json", clientIP: "203.0.113.7", format: config.AccessLogJSON, wantLog: `"client_ip":"203.0.113.7","country":"US","asn":"AS64496"`, located: true},
+		{name: "kv", clientIP: "203.0.113.7", format: config.AccessLogKeyValue, wantLog: "client_ip=203.0.113.7 country=US asn=AS64496 api_key=", located: true},
+		{name: "unknown", clientIP: "198.51.100.1", format: config.AccessLogKeyValue, wantLog: "client_ip=198.51.100.1 api_key="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			log := logger.New("info")
+			log.SetOutput(&buf)
+			cfg := &config.Config{
+				APIKeys:         map[string]bool{"customer": true},
+				AccessLogFormat: tt.format,
+				TrustedProxies:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			}
+			tracker := usage.NewMemoryTracker()
+			handler := Locate(cfg, testLocator{})(RequestLogger(cfg, log)(APIKeyAuth(cfg, log)(CountRequests(tracker, log)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			))))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", tt.clientIP)
+			req.Header.Set("X-API-Key", "customer")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if out := buf.String(); !strings.Contains(out, tt.wantLog) {
+				t.Errorf("log = %q, want it to contain %q", out, tt.wantLog)
+			}
+			got, _ := tracker.Get(context.Background(), history.KeyID("customer"), usage.Month(time.Now()))
+			if located := got.Countries["US"] == 1 && got.ASNs["AS64496"] == 1; located != tt.located || len(got.Countries) > 1 {
+				t.Errorf("countries = %v, asns = %v, want the request counted as located: %v", got.Countries, got.ASNs, tt.located)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// InFlight counts the requests being served
+type InFlight struct {
+	n atomic.Int64
+}
+
+// Track counts requests to next while they are served
+func (f *InFlight) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.n.Add(1)
+		defer f.n.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count returns the number of requests being served
+func (f *InFlight) Count() int64 {
+	return f.n.Load()
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInFlight(t *testing.T) {
+	var inFlight InFlight
+	var during int64
+	handler := inFlight.Track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		during = inFlight.Count()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if during != 1 || inFlight.Count() != 0 {
+		t.Errorf("Count() = %d while serving and %d after, want 1 and 0", during, inFlight.Count())
+	}
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"file-meta/internal/history"
+	"file-meta/internal/metrics"
+)
+
+var (
+	keyRequests = metrics.NewCounterVec("file_meta_key_requests_total",
+		"Rate limited requests by API key, allowed or rejected", "key_id")
+	keyRateLimited = metrics.NewCounterVec("file_meta_key_rate_limited_total",
+		"Requests rejected with 429 by API key", "key_id")
+	keyRemaining = metrics.NewGaugeVec("file_meta_key_ratelimit_remaining",
+		"Requests each API key had left when it last made one", "key_id")
+	keySaturation = metrics.NewGaugeVec("file_meta_key_ratelimit_saturation",
+		"Share of each API key's limit used when it last made a request, from 0 to 1", "key_id")
+)
+
+// KeyQuota is a key's rate limit as this instance last saw it
+type KeyQuota struct {
+	KeyID       string    `json:"key_id"`
+	APIKeyID    string    `json:"api_key_id,omitempty"` // as in usage reports
+	Limit       int       `json:"limit"`
+	Remaining   int       `json:"remaining"`
+	Saturation  float64   `json:"saturation"`
+	Requests    int64     `json:"requests"`
+	RateLimited int64     `json:"rate_limited"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+var (
+	quotasMu sync.Mutex
+	quotas   = make(map[string]KeyQuota)
+)
+
+// anonymousKeyID labels the requests limited by client IP
+const anonymousKeyID = "anonymous"
+
+// metricsKeySecret keys the HMAC in metricsKeyID. It is random unless
+// SetMetricsKeySecret replaces it.
+var metricsKeySecret = func() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}()
+
+// SetMetricsKeySecret sets the secret API keys are labeled with in metrics,
+// so that instances sharing it label each key the same way. Call it before
+// serving requests.
+func SetMetricsKeySecret(secret []byte) {
+	metricsKeySecret = secret
+}
+
+// metricsKeyID labels a rate limit key in metrics with the first 16 hex
+// digits of the HMAC-SHA256 of its API_KEYS entry. /metrics is public, so
+// unlike history.KeyID the label can't be used to test guessed keys, and
+// there's a series per configured key at most. Client IPs share one series.
+func metricsKeyID(key string) string {
+	if strings.HasPrefix(key, "ip:") {
+		return anonymousKeyID
+	}
+	mac := hmac.New(sha256.New, metricsKeySecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// recordQuota records the requests key has left of its limit
+func recordQuota(key string, limit, remaining int) {
+	id := metricsKeyID(key)
+	var saturation float64
+	if limit > 0 {
+		saturation = math.Max(0, 1-float64(remaining)/float64(limit))
+	}
+	keyRemaining.With(id).Set(float64(remaining))
+	keySaturation.With(id).Set(saturation)
+
+	var apiKeyID string
+	if id != anonymousKeyID {
+		apiKeyID = history.KeyID(key)
+	}
+
+	quotasMu.Lock()
+	quotas[id] = KeyQuota{KeyID: id, APIKeyID: apiKeyID, Limit: limit, Remaining: remaining, Saturation: saturation, LastSeen: time.Now()}
+	quotasMu.Unlock()
+}
+
+// KeyQuotas returns the rate limit of every key that made a request, most
+// saturated first. Keys are identified as in the per-key metrics.
+func KeyQuotas() []KeyQuota {
+	quotasMu.Lock()
+	all := make([]KeyQuota, 0, len(quotas))
+	for _, q := range quotas {
+		all = append(all, q)
+	}
+	quotasMu.Unlock()
+
+	for i, q := range all {
+		all[i].Requests = int64(keyRequests.With(q.KeyID).Value())
+		all[i].RateLimited = int64(keyRateLimited.With(q.KeyID).Value())
+	}
+	slices.SortFunc(all, func(a, b KeyQuota) int {
+		return cmp.Or(cmp.Compare(b.Saturation, a.Saturation), cmp.Compare(a.KeyID, b.KeyID))
+	})
+	return all
+}
+
+// recordAllowed counts a request the limiter let through
+func recordAllowed(key string) {
+	keyRequests.With(metricsKeyID(key)).Inc()
+}
+
+// recordRateLimited counts a request the limiter rejected
+func recordRateLimited(key string) {
+	id := metricsKeyID(key)
+	keyRequests.With(id).Inc()
+	keyRateLimited.With(id).Inc()
+}
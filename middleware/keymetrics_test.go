@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+)
+
+func TestKeyMetrics(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:           map[string]bool{"metrics_key": true},
+		RateLimitRequests: 2,
+		RateLimitWindow:   time.Minute,
+	}
+	handler := RateLimit(cfg, logger.New("info"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	id := metricsKeyID("metrics_key")
+	if id == history.KeyID("metrics_key") {
+		t.Fatalf("metrics label %s is the key's unkeyed hash", id)
+	}
+	requests, limited := keyRequests.With(id).Value(), keyRateLimited.With(id).Value()
+	anonymous := keyRequests.With(anonymousKeyID).Value()
+
+	steps := []struct {
+		key            string
+		wantStatus     int
+		wantRemaining  float64
+		wantSaturation float64
+	}{
+		{key: "metrics_key", wantStatus: http.StatusOK, wantRemaining: 2, wantSaturation: 0},
+		{key: "metrics_key", wantStatus: http.StatusOK, wantRemaining: 1, wantSaturation: 0.5},
+		{key: "metrics_key", wantStatus: http.StatusTooManyRequests, wantRemaining: 0, wantSaturation: 1},
+		{key: "", wantStatus: http.StatusOK},
+	}
+	for i, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, "/v1/metadata", nil)
+		req.Header.Set("X-API-Key", step.key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != step.wantStatus {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rr.Code, step.wantStatus)
+		}
+		if step.key == "" {
+			continue
+		}
+		if got := keyRemaining.With(id).Value(); got != step.wantRemaining {
+			t.Errorf("request %d: remaining = %v, want %v", i+1, got, step.wantRemaining)
+		}
+		if got := keySaturation.With(id).Value(); got != step.wantSaturation {
+			t.Errorf("request %d: saturation = %v, want %v", i+1, got, step.wantSaturation)
+		}
+	}
+
+	if got := keyRequests.With(id).Value() - requests; got != 3 {
+		t.Errorf("requests counted = %v, want 3", got)
+	}
+	if got := keyRateLimited.With(id).Value() - limited; got != 1 {
+		t.Errorf("rate limited counted = %v, want 1", got)
+	}
+	if got := keyRequests.With(anonymousKeyID).Value() - anonymous; got != 1 {
+		t.Errorf("anonymous requests counted = %v, want 1", got)
+	}
+
+	i := slices.IndexFunc(KeyQuotas(), func(q KeyQuota) bool { return q.KeyID == id })
+	if i < 0 {
+		t.Fatalf("KeyQuotas() = %+v, want %s", KeyQuotas(), id)
+	}
+	if q := KeyQuotas()[i]; q.Limit != 2 || q.Remaining != 0 || q.Saturation != 1 || q.RateLimited != int64(limited)+1 || q.APIKeyID != history.KeyID("metrics_key") {
+		t.Errorf("KeyQuotas() has %+v, want the exhausted limit of 2", q)
+	}
+}
+
+func TestMetricsKeyID(t *testing.T) {
+	defer SetMetricsKeySecret(metricsKeySecret)
+
+	SetMetricsKeySecret([]byte("first secret of at least 32 bytes"))
+	first := metricsKeyID("metrics_key")
+	if again := metricsKeyID("metrics_key"); again != first || len(first) != 16 {
+		t.Errorf("metricsKeyID() = %s, then %s, want the same 16 digits", first, again)
+	}
+	if other := metricsKeyID("other_key"); other == first {
+		t.Errorf("metricsKeyID() labels two keys %s", first)
+	}
+
+	SetMetricsKeySecret([]byte("second secret of at least 32 bytes"))
+	if got := metricsKeyID("metrics_key"); got == first {
+		t.Errorf("metricsKeyID() = %s under both secrets", got)
+	}
+	if got := metricsKeyID("ip:192.0.2.1"); got != anonymousKeyID {
+		t.Errorf("metricsKeyID(ip) = %s, want %s", got, anonymousKeyID)
+	}
+}
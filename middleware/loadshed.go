@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime"
+	runtimemetrics "runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/metrics"
+	"file-meta/internal/models"
+	"file-meta/internal/websocket"
+)
+
+var (
+	loadPressure = metrics.NewGauge("file_meta_load_pressure",
+		"Highest ratio of goroutines, heap or in-flight extractions to its LOAD_SHED_* limit")
+	requestsShed = metrics.NewCounter("file_meta_requests_shed_total",
+		"Requests rejected with 503 to shed load")
+)
+
+// Request priorities for load shedding, lowest first
+const (
+	// Requests that start work, from keys without a tier or without a
+	// valid key
+	priorityLow = iota
+	// Requests that start work, from keys with a tier
+	priorityNormal
+	// Reads, other than WebSocket uploads, and keys exempt from rate
+	// limiting
+	priorityHigh
+)
+
+// criticalPressure is the pressure from which normal priority requests are
+// shed as well
+const criticalPressure = 1.25
+
+// heapMetric is the runtime metric for memory held by live and unswept heap
+// objects
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// loadShedder samples the process's load and decides which requests to shed
+type loadShedder struct {
+	cfg        *config.Config
+	inFlight   func() int
+	goroutines atomic.Int64
+	heapBytes  atomic.Uint64
+}
+
+// LoadShed rejects requests with 503 while the goroutines, heap or
+// in-flight extractions reported by inFlight reach their LOAD_SHED_* limits,
+// lowest priority first, so the requests that are served stay fast. It
+// passes everything through when no limit is set.
+func LoadShed(cfg *config.Config, log *logger.Logger, inFlight func() int) func(http.Handler) http.Handler {
+	if cfg.LoadShedMaxGoroutines == 0 && cfg.LoadShedMaxHeapMB == 0 && cfg.LoadShedMaxInFlight == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	s := &loadShedder{cfg: cfg, inFlight: inFlight}
+	s.sample()
+	go func() {
+		// Reading the heap is too slow to do per request
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.sample()
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pressure := s.pressure()
+			if s.shed(requestPriority(s.cfg, r), pressure) {
+				requestsShed.Inc()
+				log.Warnf("[%s] Shedding %s %s at load pressure %.2f", GetRequestID(r.Context()), r.Method, r.URL.Path, pressure)
+				w.Header().Set("Retry-After", "1")
+				WriteError(w, http.StatusServiceUnavailable, models.CodeServerBusy, "Server overloaded")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sample reads the goroutine count and heap size
+func (s *loadShedder) sample() {
+	s.goroutines.Store(int64(runtime.NumGoroutine()))
+
+	sample := []runtimemetrics.Sample{{Name: heapMetric}}
+	runtimemetrics.Read(sample)
+	if sample[0].Value.Kind() == runtimemetrics.KindUint64 {
+		s.heapBytes.Store(sample[0].Value.Uint64())
+	}
+}
+
+// pressure is the highest ratio of a signal to its limit, so 1 means a
+// limit has been reached
+func (s *loadShedder) pressure() float64 {
+	var ratios []float64
+	if limit := s.cfg.LoadShedMaxGoroutines; limit > 0 {
+		ratios = append(ratios, float64(s.goroutines.Load())/float64(limit))
+	}
+	if limit := s.cfg.LoadShedMaxHeapMB; limit > 0 {
+		ratios = append(ratios, float64(s.heapBytes.Load())/float64(limit<<20))
+	}
+	if limit := s.cfg.LoadShedMaxInFlight; limit > 0 && s.inFlight != nil {
+		ratios = append(ratios, float64(s.inFlight())/float64(limit))
+	}
+
+	var pressure float64
+	for _, ratio := range ratios {
+		if ratio > pressure {
+			pressure = ratio
+		}
+	}
+	loadPressure.Set(pressure)
+	return pressure
+}
+
+// shed reports whether to reject a request of priority at pressure
+func (s *loadShedder) shed(priority int, pressure float64) bool {
+	switch {
+	case pressure >= criticalPressure:
+		return priority < priorityHigh
+	case pressure >= 1:
+		return priority < priorityNormal
+	}
+	return false
+}
+
+// requestPriority ranks r for load shedding
+func requestPriority(cfg *config.Config, r *http.Request) int {
+	key, _, valid := requestEntry(cfg, r, time.Now())
+	read := (r.Method == http.MethodGet && !websocket.IsUpgrade(r)) || r.Method == http.MethodHead
+	if cfg.RateLimitExemptKeys[key] || read {
+		return priorityHigh
+	}
+	if valid && cfg.Limits(key).Name != "" {
+		return priorityNormal
+	}
+	return priorityLow
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/models"
+)
+
+func TestLoadShed(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:             map[string]bool{"free_key": true, "paid_key": true, "internal_key": true},
+		RateLimitRequests:   10,
+		Tiers:               map[string]config.Tier{"paid": {Name: "paid", RateLimitRequests: 100}},
+		KeyTiers:            map[string]string{"paid_key": "paid"},
+		RateLimitExemptKeys: map[string]bool{"internal_key": true},
+		LoadShedMaxInFlight: 4,
+	}
+	inFlight := 0
+	handler := LoadShed(cfg, logger.New("error"), func() int { return inFlight })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name     string
+		inFlight int
+		method   string
+		key      string
+		want     int
+	}{
+		{name: "no pressure", inFlight: 3, method: http.MethodPost, key: "free_key", want: http.StatusOK},
+		{name: "low priority at the limit", inFlight: 4, method: http.MethodPost, key: "free_key", want: http.StatusServiceUnavailable},
+		{name: "no key at the limit", inFlight: 4, method: http.MethodPost, want: http.StatusServiceUnavailable},
+		{name: "tier at the limit", inFlight: 4, method: http.MethodPost, key: "paid_key", want: http.StatusOK},
+		{name: "tier past critical pressure", inFlight: 5, method: http.MethodPost, key: "paid_key", want: http.StatusServiceUnavailable},
+		{name: "read past critical pressure", inFlight: 8, method: http.MethodGet, key: "free_key", want: http.StatusOK},
+		{name: "exempt key past critical pressure", inFlight: 8, method: http.MethodPost, key: "internal_key", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inFlight = tt.inFlight
+			req := httptest.NewRequest(tt.method, "/v1/metadata", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rr.Code, tt.want)
+			}
+			if rr.Code != http.StatusServiceUnavailable {
+				return
+			}
+			if rr.Header().Get("Retry-After") == "" {
+				t.Error("shed request has no Retry-After")
+			}
+			var body models.ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body.Code != models.CodeServerBusy {
+				t.Errorf("shed request body = %+v, %v, want a %s error", body, err, models.CodeServerBusy)
+			}
+		})
+	}
+}
+
+func TestLoadShedDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := LoadShed(&config.Config{}, logger.New("error"), nil)(next)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/metadata", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d without limits", rr.Code, http.StatusOK)
+	}
+}
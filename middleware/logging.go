@@ -3,9 +3,12 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
+	"file-meta/config"
 	"file-meta/internal/logger"
+	"file-meta/internal/tracecontext"
 
 	"github.com/google/uuid"
 )
@@ -14,39 +17,101 @@ type contextKey string
 
 const requestIDKey contextKey = "requestID"
 
-// RequestLogger logs HTTP requests with request ID and timing
-func RequestLogger(log *logger.Logger) func(http.Handler) http.Handler {
+// maxRequestIDLength bounds the X-Request-ID a caller may set
+const maxRequestIDLength = 128
+
+// RequestLogger logs HTTP requests with request ID, trace ID and timing, in
+// the configured access log format, and with Locate where they come from. The ID is the caller's X-Request-ID if
+// it is valid, so gateways can correlate logs, and the request joins the
+// caller's W3C trace if any.
+func RequestLogger(cfg *config.Config, log *logger.Logger) func(http.Handler) http.Handler {
+	text := cfg.AccessLogFormat == "" || cfg.AccessLogFormat == config.AccessLogText
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			// Generate request ID
-			requestID := uuid.New().String()
-
-			// Add request ID to context
+			// Use the caller's request ID, or generate one
+			requestID := r.Header.Get("X-Request-ID")
+			if !validRequestID(requestID) {
+				if requestID != "" {
+					log.Warnf("Ignoring invalid X-Request-ID of %d bytes", len(requestID))
+				}
+				requestID = uuid.New().String()
+			}
+
+			// Add request ID and trace to context, for outgoing calls
+			trace := tracecontext.Start(r.Header.Get(tracecontext.ParentHeader), r.Header.Get(tracecontext.StateHeader))
 			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
-			r = r.WithContext(ctx)
+			r = r.WithContext(tracecontext.NewContext(ctx, trace))
 
 			// Add request ID to response headers
 			w.Header().Set("X-Request-ID", requestID)
 
-			// Wrap response writer to capture status code
+			// Wrap response writer to capture status code and size
 			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 			// Log request
-			log.Infof("[%s] %s %s - Started", requestID, r.Method, r.URL.Path)
+			if text {
+				log.Infof("[%s] %s %s - Started (trace %s, span %s)", requestID, r.Method, r.URL.Path, trace.TraceID, trace.SpanID)
+			}
 
 			// Process request
 			next.ServeHTTP(wrapped, r)
 
 			// Log response
 			duration := time.Since(start)
-			log.Infof("[%s] %s %s - Completed %d in %v",
-				requestID, r.Method, r.URL.Path, wrapped.statusCode, duration)
+			location := GetLocation(r.Context())
+			if text {
+				var from string
+				if location.Country != "" || location.ASN != 0 {
+					from = " from " + strings.TrimSpace(location.Country+" "+location.AS())
+				}
+				log.Infof("[%s] %s %s - Completed %d (%d bytes) in %v (trace %s)%s",
+					requestID, r.Method, r.URL.Path, wrapped.statusCode, wrapped.bytes, duration, trace.TraceID, from)
+				return
+			}
+
+			// The path leaves out the query, which may hold an API key
+			entry := accessEntry{
+				Time:       start,
+				RequestID:  requestID,
+				TraceID:    trace.TraceID,
+				ClientIP:   ClientIP(r, cfg.TrustedProxies),
+				Country:    location.Country,
+				ASN:        location.AS(),
+				APIKey:     logger.KeyPrefix(requestAPIKey(r)),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Proto:      r.Proto,
+				Status:     wrapped.statusCode,
+				Bytes:      wrapped.bytes,
+				DurationMS: float64(duration.Microseconds()) / 1000,
+				UserAgent:  r.UserAgent(),
+				Referer:    r.Referer(),
+			}
+			log.Access(entry.format(cfg.AccessLogFormat))
 		})
 	}
 }
 
+// validRequestID reports whether id can be used as a request ID: up to
+// maxRequestIDLength letters, digits and the characters "-_.:", so it can't
+// forge log lines or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // GetRequestID retrieves request ID from context
 func GetRequestID(ctx context.Context) string {
 	if id, ok := ctx.Value(requestIDKey).(string); ok {
@@ -58,9 +123,22 @@ func GetRequestID(ctx context.Context) string {
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+	"file-meta/internal/tracecontext"
+)
+
+func TestRequestLogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		// keep is whether the incoming ID is used rather than a new one
+		keep bool
+	}{
+		{name: "no ID", incoming: ""},
+		{name: "gateway UUID", incoming: "0f8fad5b-d9cb-469f-a165-70867728950e", keep: true},
+		{name: "other format", incoming: "gw:req_01HX.42", keep: true},
+		{name: "log injection", incoming: "abc\n[forged] GET /admin"},
+		{name: "spaces", incoming: "abc def"},
+		{name: "too long", incoming: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "longest", incoming: strings.Repeat("a", maxRequestIDLength), keep: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestLogger(&config.Config{}, logger.New("error"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = GetRequestID(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("X-Request-ID"); got != seen || seen == "" {
+				t.Fatalf("X-Request-ID = %q, context has %q, want the same non-empty ID", got, seen)
+			}
+			if (seen == tt.incoming) != tt.keep {
+				t.Errorf("request ID = %q for incoming %q, want it kept: %v", seen, tt.incoming, tt.keep)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerTrace(t *testing.T) {
+	var trace tracecontext.Trace
+	handler := RequestLogger(&config.Config{}, logger.New("error"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace, _ = tracecontext.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if trace.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || trace.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("trace = %+v, want the caller's trace continued", trace)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	if trace.TraceID == "" || trace.ParentID != "" {
+		t.Errorf("trace = %+v, want a new trace", trace)
+	}
+}
+
+func TestRequestLoggerFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: config.AccessLogText,
+			want:   []string{"[req-1] GET /v1/metadata - Started", "Completed 201 (5 bytes)"},
+		},
+		{
+			format: config.AccessLogJSON,
+			want: []string{`"request_id":"req-1"`, `"client_ip":"203.0.113.7"`, `"api_key":"abcdefgh..."`,
+				`"method":"GET"`, `"path":"/v1/metadata"`, `"status":201`, `"bytes":5`, `"user_agent":"curl/8.0 \"x\""`},
+		},
+		{
+			format: config.AccessLogCombined,
+			want:   []string{`203.0.113.7 - abcdefgh... [`, `] "GET /v1/metadata HTTP/1.1" 201 5 "-" "curl/8.0 \"x\""`},
+		},
+		{
+			format: config.AccessLogKeyValue,
+			want: []string{"request_id=req-1 ", "client_ip=203.0.113.7 api_key=abcdefgh... method=GET path=/v1/metadata proto=HTTP/1.1 status=201 bytes=5 ",
+				`user_agent="curl/8.0 \"x\"" referer=""`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf strings.Builder
+			log := logger.New("info")
+			log.SetOutput(&buf)
+			cfg := &config.Config{AccessLogFormat: tt.format, TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+			handler := RequestLogger(cfg, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("hello"))
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/metadata?api_key=secret", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			req.Header.Set("X-Request-ID", "req-1")
+			req.Header.Set("X-API-Key", "abcdefghijklmnopqrstuvwxyz")
+			req.Header.Set("User-Agent", `curl/8.0 "x"`)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("log = %q, want it to contain %q", out, want)
+				}
+			}
+			if strings.Contains(out, "secret") || strings.Contains(out, "ijklmnop") {
+				t.Errorf("log = %q, want no API key", out)
+			}
+			if tt.format != config.AccessLogText && strings.Count(out, "\n") != 1 {
+				t.Errorf("log = %q, want a single line", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/overrides"
+)
+
+// OverrideLookup finds an API key's settings overrides by history.KeyID,
+// returning nil for keys without any
+type OverrideLookup interface {
+	Get(ctx context.Context, keyID string) (*overrides.Overrides, error)
+}
+
+// ResolveOverrides looks up the overrides of the request's API key for
+// GetOverrides. It must run after APIKeyAuth; a failed lookup is logged and
+// the request is served with the process-wide settings.
+func ResolveOverrides(lookup OverrideLookup, log *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := GetAPIKey(r.Context())
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			o, err := lookup.Get(r.Context(), history.KeyID(key))
+			if err != nil {
+				log.Warnf("[%s] Failed to look up overrides: %v", GetRequestID(r.Context()), err)
+			}
+			if o == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), overridesKey, o)))
+		})
+	}
+}
+
+const overridesKey contextKey = "overrides"
+
+// GetOverrides retrieves the API key's overrides from context, or nil
+func GetOverrides(ctx context.Context) *overrides.Overrides {
+	o, _ := ctx.Value(overridesKey).(*overrides.Overrides)
+	return o
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"file-meta/config"
+)
+
+// PrivacyMode decides whether responses to the request leave out location
+// data and device serial numbers, for WithholdPersonalData: in privacy mode,
+// PRIVACY_MODE or the key's override, unless the key has the
+// metadata:personal scope. It must run after ResolveOverrides.
+func PrivacyMode(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			privacy := cfg.PrivacyMode
+			if o := GetOverrides(r.Context()); o != nil && o.PrivacyMode != nil {
+				privacy = *o.PrivacyMode
+			}
+			if !privacy || cfg.HasScope(GetAPIKey(r.Context()), config.ScopeMetadataPersonal) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), privacyKey, true)))
+		})
+	}
+}
+
+const privacyKey contextKey = "privacy"
+
+// WithholdPersonalData reports whether PrivacyMode decided to leave personal
+// data out of responses to the request with ctx
+func WithholdPersonalData(ctx context.Context) bool {
+	withhold, _ := ctx.Value(privacyKey).(bool)
+	return withhold
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"file-meta/config"
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/overrides"
+)
+
+func TestPrivacyMode(t *testing.T) {
+	off, on := false, true
+	log := logger.New("error")
+
+	tests := []struct {
+		name     string
+		privacy  bool
+		apiKey   string
+		override *bool
+		want     bool
+	}{
+		{name: "off", apiKey: "customer"},
+		{name: "on", privacy: true, apiKey: "customer", want: true},
+		{name: "on with the scope", privacy: true, apiKey: "investigator"},
+		{name: "on for an admin", privacy: true, apiKey: "operator", want: true},
+		{name: "switched on for the key", apiKey: "customer", override: &on, want: true},
+		{name: "switched off for the key", privacy: true, apiKey: "customer", override: &off},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				APIKeys:      map[string]bool{"customer": true, "investigator": true, "operator": true},
+				AdminAPIKeys: map[string]bool{"operator": true},
+				KeyScopes: map[string]map[string]bool{
+					"investigator": {config.ScopeMetadataRead: true, config.ScopeMetadataPersonal: true},
+				},
+				PrivacyMode: tt.privacy,
+			}
+			lookup := overrides.NewMemoryStore()
+			if tt.override != nil {
+				lookup.Put(context.Background(), history.KeyID(tt.apiKey), overrides.Overrides{PrivacyMode: tt.override})
+			}
+
+			var withheld bool
+			handler := APIKeyAuth(cfg, log)(ResolveOverrides(lookup, log)(PrivacyMode(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				withheld = WithholdPersonalData(r.Context())
+			}))))
+			req := httptest.NewRequest(http.MethodGet, "/v1/metadata/abc", nil)
+			req.Header.Set("X-API-Key", tt.apiKey)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if withheld != tt.want {
+				t.Errorf("withheld = %v, want %v", withheld, tt.want)
+			}
+		})
+	}
+}
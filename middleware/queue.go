@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/metrics"
+)
+
+var (
+	rateLimitQueued = metrics.NewCounter("file_meta_ratelimit_queued_total",
+		"Rate limited requests held until their key had a token")
+	rateLimitQueueRejections = metrics.NewCounter("file_meta_ratelimit_queue_rejections_total",
+		"Rate limited requests rejected because waiting would take too long or the key's queue was full")
+)
+
+const queueDeadlineKey contextKey = "rateLimitQueueDeadline"
+
+// rateLimitQueue holds rate limited requests until their key should have a
+// token again, instead of rejecting them, for up to RateLimitQueueMaxDelay
+// and with at most RateLimitQueueDepth requests of a key waiting at once
+type rateLimitQueue struct {
+	maxDelay time.Duration
+	depth    int
+
+	mu      sync.Mutex
+	waiting map[string]int
+}
+
+// newRateLimitQueue returns the queue cfg asks for, or nil if requests
+// shouldn't wait
+func newRateLimitQueue(cfg *config.Config) *rateLimitQueue {
+	if cfg.RateLimitQueueMaxDelay <= 0 || cfg.RateLimitQueueDepth <= 0 {
+		return nil
+	}
+	return &rateLimitQueue{
+		maxDelay: cfg.RateLimitQueueMaxDelay,
+		depth:    cfg.RateLimitQueueDepth,
+		waiting:  make(map[string]int),
+	}
+}
+
+// wait holds r, which key may retry after retryAfter, until then. It
+// reports false, without waiting, if that would take r past its deadline or
+// key's queue is full, and false if r is canceled meanwhile. The request
+// returned carries r's deadline for its retries.
+func (q *rateLimitQueue) wait(r *http.Request, key string, retryAfter time.Duration) (*http.Request, bool) {
+	if q == nil {
+		return r, false
+	}
+
+	// The deadline is set when a request is first limited, so one that
+	// loses the token to another waiter can't wait again indefinitely
+	now := time.Now()
+	deadline, ok := r.Context().Value(queueDeadlineKey).(time.Time)
+	if !ok {
+		deadline = now.Add(q.maxDelay)
+		r = r.WithContext(context.WithValue(r.Context(), queueDeadlineKey, deadline))
+	}
+	if now.Add(retryAfter).After(deadline) {
+		rateLimitQueueRejections.Inc()
+		return r, false
+	}
+
+	q.mu.Lock()
+	if q.waiting[key] >= q.depth {
+		q.mu.Unlock()
+		rateLimitQueueRejections.Inc()
+		return r, false
+	}
+	q.waiting[key]++
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		if q.waiting[key]--; q.waiting[key] == 0 {
+			delete(q.waiting, key)
+		}
+		q.mu.Unlock()
+	}()
+
+	if !ok {
+		rateLimitQueued.Inc()
+	}
+	timer := time.NewTimer(retryAfter)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return r, true
+	case <-r.Context().Done():
+		return r, false
+	}
+}
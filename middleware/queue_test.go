@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+func TestRateLimitQueue(t *testing.T) {
+	log := logger.New("error")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(handler http.Handler, key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("waits for a token", func(t *testing.T) {
+		cfg := &config.Config{
+			APIKeys:                map[string]bool{"queue_wait_key": true},
+			RateLimitRequests:      1,
+			RateLimitWindow:        100 * time.Millisecond,
+			RateLimitQueueMaxDelay: time.Second,
+			RateLimitQueueDepth:    1,
+		}
+		handler := RateLimit(cfg, log)(next)
+
+		if status := request(handler, "queue_wait_key"); status != http.StatusOK {
+			t.Fatalf("first request: status = %d", status)
+		}
+		start := time.Now()
+		if status := request(handler, "queue_wait_key"); status != http.StatusOK {
+			t.Fatalf("queued request: status = %d, want %d", status, http.StatusOK)
+		}
+		if waited := time.Since(start); waited < 50*time.Millisecond {
+			t.Errorf("queued request took %v, want it to wait for the refill", waited)
+		}
+	})
+
+	t.Run("wait too long", func(t *testing.T) {
+		cfg := &config.Config{
+			APIKeys:                map[string]bool{"queue_long_key": true},
+			RateLimitRequests:      1,
+			RateLimitWindow:        time.Minute,
+			RateLimitQueueMaxDelay: 100 * time.Millisecond,
+			RateLimitQueueDepth:    1,
+		}
+		handler := SlidingWindowRateLimit(cfg, log)(next)
+
+		request(handler, "queue_long_key")
+		start := time.Now()
+		if status := request(handler, "queue_long_key"); status != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want %d", status, http.StatusTooManyRequests)
+		}
+		if waited := time.Since(start); waited > 50*time.Millisecond {
+			t.Errorf("rejected request waited %v, want none", waited)
+		}
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		cfg := &config.Config{
+			APIKeys:                map[string]bool{"queue_full_key": true},
+			RateLimitRequests:      1,
+			RateLimitWindow:        200 * time.Millisecond,
+			RateLimitQueueMaxDelay: time.Second,
+			RateLimitQueueDepth:    1,
+		}
+		handler := RateLimit(cfg, log)(next)
+		request(handler, "queue_full_key")
+
+		// Both wait for the same token, and only one of them fits in the queue
+		var wg sync.WaitGroup
+		statuses := make(chan int, 2)
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				statuses <- request(handler, "queue_full_key")
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		counts := make(map[int]int)
+		for status := range statuses {
+			counts[status]++
+		}
+		if counts[http.StatusOK] != 1 || counts[http.StatusTooManyRequests] != 1 {
+			t.Errorf("statuses = %v, want one 200 and one 429", counts)
+		}
+	})
+}
@@ -1,13 +1,17 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"file-meta/config"
 	"file-meta/internal/logger"
+	"file-meta/internal/models"
 )
 
 type client struct {
@@ -22,23 +26,32 @@ var (
 	cleanupStarted sync.Once
 )
 
-// RateLimit implements token bucket rate limiting
+// RateLimit implements token bucket rate limiting. A key's bucket holds its
+// burst of tokens and gains its requests' worth every window.
 func RateLimit(cfg *config.Config, log *logger.Logger) func(http.Handler) http.Handler {
 	// Start cleanup goroutine only once
 	cleanupStarted.Do(func() {
-		go cleanupExpiredClients(cfg.RateLimitWindow, log)
+		go cleanupExpiredClients(cfg, log)
 	})
 
+	queue := newRateLimitQueue(cfg)
+
 	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := r.Header.Get("X-API-Key")
+		var handler http.HandlerFunc
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(cfg, r)
+			if cfg.RateLimitExemptKeys[key] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limits := cfg.Limits(key)
 			now := time.Now()
 
 			mu.Lock()
 			c, exists := clients[key]
 			if !exists {
 				c = &client{
-					tokens:     cfg.RateLimitRequests,
+					tokens:     limits.Burst(),
 					lastRefill: now,
 				}
 				clients[key] = c
@@ -46,34 +59,116 @@ func RateLimit(cfg *config.Config, log *logger.Logger) func(http.Handler) http.H
 			mu.Unlock()
 
 			c.mu.Lock()
-			defer c.mu.Unlock()
 
-			// Refill tokens if window has passed
-			if now.Sub(c.lastRefill) > cfg.RateLimitWindow {
-				c.tokens = cfg.RateLimitRequests
-				c.lastRefill = now
+			// Refill tokens for every window that has passed
+			if windows := int(now.Sub(c.lastRefill) / limits.RateLimitWindow); windows > 0 {
+				c.tokens = min(limits.Burst(), c.tokens+windows*limits.RateLimitRequests)
+				c.lastRefill = c.lastRefill.Add(time.Duration(windows) * limits.RateLimitWindow)
 			}
 
+			tokens := c.tokens
+			refill := c.lastRefill.Add(limits.RateLimitWindow)
+			if tokens > 0 {
+				c.tokens--
+			}
+			c.mu.Unlock()
+
 			// Add rate limit headers
-			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", cfg.RateLimitRequests))
-			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", c.tokens))
-			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", c.lastRefill.Add(cfg.RateLimitWindow).Unix()))
+			setRateLimitHeaders(w, key, limits.RateLimitRequests, tokens, refill, now)
 
-			if c.tokens <= 0 {
-				log.Warnf("Rate limit exceeded for API key: %s", key[:min(len(key), 8)]+"...")
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			if tokens <= 0 {
+				if queued, ok := queue.wait(r, key, refill.Sub(now)); ok {
+					handler(w, queued)
+					return
+				}
+				rateLimitExceeded(w, log, key, limits.RateLimitRequests, refill.Sub(now))
 				return
 			}
 
-			c.tokens--
+			recordAllowed(key)
 			next.ServeHTTP(w, r)
-		})
+		}
+		return handler
+	}
+}
+
+// rateLimitKey is the bucket r counts against: its API key's entry in
+// API_KEYS, or its client IP when it has no valid key or access token, so
+// made-up keys can't dodge the limit
+func rateLimitKey(cfg *config.Config, r *http.Request) string {
+	if entry, _, ok := requestEntry(cfg, r, time.Now()); ok {
+		return entry
 	}
+	return "ip:" + ClientIP(r, cfg.TrustedProxies)
+}
+
+// RateLimitError is the body of a 429 response
+type RateLimitError struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"` // models.CodeRateLimited or models.CodeAuthBanned
+	Limit      int    `json:"limit"`
+	RetryAfter int    `json:"retry_after"` // seconds, as in the Retry-After header
+}
+
+// setRateLimitHeaders reports a key's limit, the requests it has left and
+// when it gets more, as both the IETF RateLimit headers, with the reset in
+// seconds from now, and the X-RateLimit ones, with the reset as a Unix time.
+// The requests left are also recorded in the key's metrics.
+func setRateLimitHeaders(w http.ResponseWriter, key string, limit, remaining int, reset, now time.Time) {
+	recordQuota(key, limit, remaining)
+	w.Header().Set("RateLimit-Limit", fmt.Sprintf("%d", limit))
+	w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	w.Header().Set("RateLimit-Reset", fmt.Sprintf("%d", seconds(reset.Sub(now))))
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", reset.Unix()))
+}
+
+// rateLimitExceeded answers a request over its key's limit with a JSON
+// error and when it may be retried
+func rateLimitExceeded(w http.ResponseWriter, log *logger.Logger, key string, limit int, retryAfter time.Duration) {
+	if ip, ok := strings.CutPrefix(key, "ip:"); ok {
+		log.Warnf("Rate limit exceeded for client IP: %s", ip)
+	} else {
+		log.Warnf("Rate limit exceeded for API key: %s", logger.KeyPrefix(key))
+	}
+	recordRateLimited(key)
+
+	wait := max(1, seconds(retryAfter))
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", wait))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(RateLimitError{Error: "Rate limit exceeded", Code: models.CodeRateLimited, Limit: limit, RetryAfter: wait})
+}
+
+// seconds rounds d up to whole seconds, and negative durations to zero
+func seconds(d time.Duration) int {
+	return max(0, int(math.Ceil(d.Seconds())))
+}
+
+// longestWindow is the longest rate limit window of any tier, which sets how
+// often idle clients are cleaned up
+func longestWindow(cfg *config.Config) time.Duration {
+	window := cfg.RateLimitWindow
+	for _, tier := range cfg.Tiers {
+		if tier.RateLimitWindow > window {
+			window = tier.RateLimitWindow
+		}
+	}
+	return window
+}
+
+// refillTime is how long an empty bucket takes to fill up again
+func refillTime(limits config.Tier) time.Duration {
+	windows := (limits.Burst() + limits.RateLimitRequests - 1) / limits.RateLimitRequests
+	return time.Duration(windows) * limits.RateLimitWindow
 }
 
-// cleanupExpiredClients removes expired clients from memory
-func cleanupExpiredClients(window time.Duration, log *logger.Logger) {
-	ticker := time.NewTicker(window * 2)
+// cleanupExpiredClients removes clients from memory once their buckets
+// would be full again anyway
+func cleanupExpiredClients(cfg *config.Config, log *logger.Logger) {
+	ticker := time.NewTicker(longestWindow(cfg) * 2)
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -81,9 +176,10 @@ func cleanupExpiredClients(window time.Duration, log *logger.Logger) {
 		now := time.Now()
 		for key, c := range clients {
 			c.mu.Lock()
-			if now.Sub(c.lastRefill) > window*3 {
+			limits := cfg.Limits(key)
+			if now.Sub(c.lastRefill) > refillTime(limits)+limits.RateLimitWindow*2 {
 				delete(clients, key)
-				log.Debugf("Cleaned up expired client: %s", key[:min(len(key), 8)]+"...")
+				log.Debugf("Cleaned up expired client: %s", logger.KeyPrefix(key))
 			}
 			c.mu.Unlock()
 		}
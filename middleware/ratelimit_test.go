@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -8,10 +9,12 @@ import (
 
 	"file-meta/config"
 	"file-meta/internal/logger"
+	"file-meta/internal/models"
 )
 
 func TestRateLimit(t *testing.T) {
 	cfg := &config.Config{
+		APIKeys:           map[string]bool{"test_key": true},
 		RateLimitRequests: 2,
 		RateLimitWindow:   time.Second,
 	}
@@ -50,8 +53,24 @@ func TestRateLimit(t *testing.T) {
 	}
 
 	// Check rate limit headers
-	if rr.Header().Get("X-RateLimit-Limit") == "" {
-		t.Error("X-RateLimit-Limit header not set")
+	for _, header := range []string{"RateLimit-Limit", "X-RateLimit-Limit"} {
+		if limit := rr.Header().Get(header); limit != "2" {
+			t.Errorf("%s = %q, want 2", header, limit)
+		}
+	}
+	if reset := rr.Header().Get("RateLimit-Reset"); reset != "0" && reset != "1" {
+		t.Errorf("RateLimit-Reset = %q, want seconds until the refill", reset)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Retry-After = %q, want 1", retryAfter)
+	}
+
+	var body RateLimitError
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("rate limited body is not JSON: %v", err)
+	}
+	if body.Error != "Rate limit exceeded" || body.Code != models.CodeRateLimited || body.Limit != 2 || body.RetryAfter != 1 {
+		t.Errorf("rate limited body = %+v", body)
 	}
 }
 
@@ -62,6 +81,7 @@ func TestRateLimitRefill(t *testing.T) {
 	mu.Unlock()
 
 	cfg := &config.Config{
+		APIKeys:           map[string]bool{"test_refill_key": true},
 		RateLimitRequests: 1,
 		RateLimitWindow:   100 * time.Millisecond,
 	}
@@ -106,3 +126,258 @@ func TestRateLimitRefill(t *testing.T) {
 		t.Errorf("third request after refill: got status %v, want %v", status, http.StatusOK)
 	}
 }
+
+func TestRateLimitBurst(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:           map[string]bool{"test_burst_key": true},
+		RateLimitRequests: 1,
+		RateLimitWindow:   100 * time.Millisecond,
+		RateLimitBurst:    3,
+	}
+	log := logger.New("info")
+	handler := RateLimit(cfg, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-API-Key", "test_burst_key")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The whole burst is available at once
+	for i := 0; i < 3; i++ {
+		if status := request(); status != http.StatusOK {
+			t.Errorf("burst request %d: got status %v, want %v", i+1, status, http.StatusOK)
+		}
+	}
+	if status := request(); status != http.StatusTooManyRequests {
+		t.Errorf("request after burst: got status %v, want %v", status, http.StatusTooManyRequests)
+	}
+
+	// A window refills one request, not the burst
+	time.Sleep(150 * time.Millisecond)
+	if status := request(); status != http.StatusOK {
+		t.Errorf("request after refill: got status %v, want %v", status, http.StatusOK)
+	}
+	if status := request(); status != http.StatusTooManyRequests {
+		t.Errorf("second request after refill: got status %v, want %v", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:           map[string]bool{"test_ip_key": true},
+		RateLimitRequests: 1,
+		RateLimitWindow:   time.Minute,
+	}
+	log := logger.New("info")
+	handler := RateLimit(cfg, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		key        string
+		remoteAddr string
+		want       int
+	}{
+		{name: "no key", remoteAddr: "198.51.100.10:1000", want: http.StatusOK},
+		{name: "no key again", remoteAddr: "198.51.100.10:2000", want: http.StatusTooManyRequests},
+		{name: "made-up key from the same IP", key: "random", remoteAddr: "198.51.100.10:3000", want: http.StatusTooManyRequests},
+		{name: "no key from another IP", remoteAddr: "198.51.100.11:1000", want: http.StatusOK},
+		{name: "valid key from the same IP", key: "test_ip_key", remoteAddr: "198.51.100.10:4000", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitExemptKeys(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:             map[string]bool{"exempt_prober": true},
+		RateLimitRequests:   1,
+		RateLimitWindow:     time.Minute,
+		RateLimitExemptKeys: map[string]bool{"exempt_prober": true},
+	}
+	log := logger.New("info")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		limiter func(http.Handler) http.Handler
+	}{
+		{name: "token bucket", limiter: RateLimit(cfg, log)},
+		{name: "sliding window", limiter: SlidingWindowRateLimit(cfg, log)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := tt.limiter(next)
+			for i := 0; i < 5; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-API-Key", "exempt_prober")
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("request %d: status = %d, want %d", i+1, rr.Code, http.StatusOK)
+				}
+				if limit := rr.Header().Get("RateLimit-Limit"); limit != "" {
+					t.Errorf("RateLimit-Limit = %s, want none for an exempt key", limit)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitTiers(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:           map[string]bool{"free_key": true, "paid_key": true},
+		RateLimitRequests: 1,
+		RateLimitWindow:   time.Minute,
+		Tiers: map[string]config.Tier{
+			"paid": {Name: "paid", RateLimitRequests: 3, RateLimitWindow: time.Minute, MaxFileSizeMB: 100},
+		},
+		KeyTiers: map[string]string{"paid_key": "paid"},
+	}
+	log := logger.New("info")
+	handler := RateLimit(cfg, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		key     string
+		allowed int
+		limit   string
+	}{
+		{key: "free_key", allowed: 1, limit: "1"},
+		{key: "paid_key", allowed: 3, limit: "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			for i := 0; i <= tt.allowed; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-API-Key", tt.key)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				want := http.StatusOK
+				if i == tt.allowed {
+					want = http.StatusTooManyRequests
+				}
+				if rr.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, rr.Code, want)
+				}
+				if limit := rr.Header().Get("X-RateLimit-Limit"); limit != tt.limit {
+					t.Errorf("X-RateLimit-Limit = %s, want %s", limit, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestSlidingWindowRateLimit(t *testing.T) {
+	cfg := &config.Config{
+		APIKeys:           map[string]bool{"sliding_free_key": true, "sliding_paid_key": true},
+		RateLimitRequests: 1,
+		RateLimitWindow:   time.Minute,
+		Tiers: map[string]config.Tier{
+			"paid": {Name: "paid", RateLimitRequests: 3, RateLimitWindow: time.Minute, MaxFileSizeMB: 100},
+		},
+		KeyTiers: map[string]string{"sliding_paid_key": "paid"},
+	}
+	log := logger.New("info")
+	handler := SlidingWindowRateLimit(cfg, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		key     string
+		allowed int
+		limit   string
+	}{
+		{key: "sliding_free_key", allowed: 1, limit: "1"},
+		{key: "sliding_paid_key", allowed: 3, limit: "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			for i := 0; i <= tt.allowed; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-API-Key", tt.key)
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				want := http.StatusOK
+				if i == tt.allowed {
+					want = http.StatusTooManyRequests
+				}
+				if rr.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, rr.Code, want)
+				}
+				if limit := rr.Header().Get("X-RateLimit-Limit"); limit != tt.limit {
+					t.Errorf("X-RateLimit-Limit = %s, want %s", limit, tt.limit)
+				}
+				if remaining := rr.Header().Get("X-RateLimit-Remaining"); i >= tt.allowed-1 && remaining != "0" {
+					t.Errorf("request %d: X-RateLimit-Remaining = %s, want 0", i+1, remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestSlidingRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous int
+		current  int
+		elapsed  time.Duration
+		want     time.Duration
+	}{
+		// 10 + 0 requests: 10 is the limit, so wait for one to slide out
+		{name: "previous window full", previous: 10, current: 0, elapsed: 0, want: 6 * time.Second},
+		// 5 + 8 requests 30s in: 1 of the previous window's must slide out
+		{name: "both windows", previous: 5, current: 8, elapsed: 30 * time.Second, want: 18 * time.Second},
+		// 0 + 10 requests: in the next window, 1 of these must slide out
+		{name: "current window full", previous: 0, current: 10, elapsed: 15 * time.Second, want: 51 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slidingRetryAfter(tt.previous, tt.current, 10, tt.elapsed, time.Minute)
+			if got.Round(time.Millisecond) != tt.want {
+				t.Errorf("slidingRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlidingCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous int
+		current  int
+		elapsed  time.Duration
+		want     float64
+	}{
+		{name: "window start", previous: 10, current: 0, elapsed: 0, want: 10},
+		{name: "a quarter in", previous: 10, current: 2, elapsed: 15 * time.Second, want: 9.5},
+		{name: "halfway", previous: 10, current: 4, elapsed: 30 * time.Second, want: 9},
+		{name: "no previous requests", previous: 0, current: 3, elapsed: 45 * time.Second, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slidingCount(tt.previous, tt.current, tt.elapsed, time.Minute); got != tt.want {
+				t.Errorf("slidingCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"file-meta/internal/logger"
+	"file-meta/internal/models"
 )
 
 // Recovery recovers from panics and returns 500 error
@@ -13,7 +14,7 @@ func Recovery(log *logger.Logger) func(http.Handler) http.Handler {
 			defer func() {
 				if err := recover(); err != nil {
 					log.Errorf("Panic recovered: %v", err)
-					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					WriteError(w, http.StatusInternalServerError, models.CodeInternal, "Internal server error")
 				}
 			}()
 
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"time"
@@ -12,73 +11,101 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-// RedisRateLimit implements distributed rate limiting using Redis
+// tokenBucketScript takes a token from the bucket in the hash KEYS[1], first
+// adding ARGV[3] tokens, up to the burst ARGV[4], for every window of ARGV[2]
+// milliseconds since it was last refilled. ARGV[1] is the current time in
+// milliseconds. The hash expires once the bucket would be full again. It
+// returns whether a token was taken, the tokens left and when the bucket was
+// last refilled.
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local rate = tonumber(ARGV[3])
+local burst = tonumber(ARGV[4])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'refilled')
+local tokens = tonumber(state[1])
+local refilled = tonumber(state[2])
+if tokens == nil or refilled == nil then
+	tokens = burst
+	refilled = now
+end
+local windows = math.floor((now - refilled) / window)
+if windows > 0 then
+	tokens = math.min(burst, tokens + windows * rate)
+	refilled = refilled + windows * window
+end
+local allowed = 0
+if tokens > 0 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'refilled', refilled)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) * window + window)
+return {allowed, tokens, refilled}
+`)
+
+// RedisRateLimit implements distributed rate limiting using Redis. While
+// Redis fails, requests are handled per RATE_LIMIT_FAIL_MODE.
 func RedisRateLimit(cfg *config.Config, log *logger.Logger, redisClient *redis.Client) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.Background()
-			key := r.Header.Get("X-API-Key")
-			now := time.Now()
+	guard := newRedisGuard(cfg, log, RateLimit(cfg, log))
+	queue := newRateLimitQueue(cfg)
 
-			// Redis key for this API key
-			rateLimitKey := fmt.Sprintf("ratelimit:%s", key)
+	return func(next http.Handler) http.Handler {
+		fallback := guard.fallback(next)
 
-			// Try to get current token count
-			tokens, err := redisClient.Get(ctx, rateLimitKey).Int()
-			if err == redis.Nil {
-				// Key doesn't exist, initialize with max tokens
-				tokens = cfg.RateLimitRequests
-				err = redisClient.Set(ctx, rateLimitKey, tokens, cfg.RateLimitWindow).Err()
-				if err != nil {
-					log.Errorf("Redis error: %v", err)
-					// Fallback: allow request if Redis is down
-					next.ServeHTTP(w, r)
-					return
-				}
-			} else if err != nil {
-				log.Errorf("Redis error: %v", err)
-				// Fallback: allow request if Redis is down
+		var handler http.HandlerFunc
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(cfg, r)
+			if cfg.RateLimitExemptKeys[key] {
 				next.ServeHTTP(w, r)
 				return
 			}
-
-			// Get TTL to calculate reset time
-			ttl, err := redisClient.TTL(ctx, rateLimitKey).Result()
-			if err != nil {
-				log.Errorf("Redis TTL error: %v", err)
-				ttl = cfg.RateLimitWindow
+			limits := cfg.Limits(key)
+			window := limits.RateLimitWindow.Milliseconds()
+			now := time.Now()
+			if !guard.breaker.allow(now) {
+				fallback.ServeHTTP(w, r)
+				return
 			}
 
-			resetTime := now.Add(ttl).Unix()
-
-			// Add rate limit headers
-			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", cfg.RateLimitRequests))
-			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", max(0, tokens-1)))
-			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", resetTime))
+			// Redis key for this API key
+			rateLimitKey := fmt.Sprintf("ratelimit:bucket:%s", key)
 
-			// Check if rate limited
-			if tokens <= 0 {
-				log.Warnf("Rate limit exceeded for API key: %s", key[:min(len(key), 8)]+"...")
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-				return
+			result, err := tokenBucketScript.Run(r.Context(), redisClient, []string{rateLimitKey},
+				now.UnixMilli(), max(1, int(window)), limits.RateLimitRequests, limits.Burst()).Int64Slice()
+			if r.Context().Err() != nil {
+				guard.breaker.abandon()
+				return // the client is gone
+			}
+			if err == nil && len(result) != 3 {
+				err = fmt.Errorf("unexpected script result %v", result)
 			}
-
-			// Decrement tokens
-			newTokens, err := redisClient.Decr(ctx, rateLimitKey).Result()
 			if err != nil {
-				log.Errorf("Redis decrement error: %v", err)
-				// Fallback: allow request if Redis is down
-				next.ServeHTTP(w, r)
+				guard.failed(err, now)
+				fallback.ServeHTTP(w, r)
 				return
 			}
+			guard.breaker.success()
+			allowed, tokens, refilled := result[0] == 1, result[1], result[2]
 
-			// If this was the first decrement after initialization, set expiry
-			if newTokens == int64(cfg.RateLimitRequests-1) {
-				redisClient.Expire(ctx, rateLimitKey, cfg.RateLimitWindow)
+			// Add rate limit headers
+			refill := time.UnixMilli(refilled).Add(limits.RateLimitWindow)
+			setRateLimitHeaders(w, key, limits.RateLimitRequests, int(tokens), refill, now)
+
+			// Check if rate limited
+			if !allowed {
+				if queued, ok := queue.wait(r, key, refill.Sub(now)); ok {
+					handler(w, queued)
+					return
+				}
+				rateLimitExceeded(w, log, key, limits.RateLimitRequests, refill.Sub(now))
+				return
 			}
 
+			recordAllowed(key)
 			next.ServeHTTP(w, r)
-		})
+		}
+		return handler
 	}
 }
 
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowScript counts a request in KEYS[1], the current fixed window's
+// counter, unless it and the previous window's counter KEYS[2], weighted by
+// ARGV[1], already reach the limit ARGV[2]. Counters expire after ARGV[3]
+// milliseconds. It returns whether the request was allowed and both counts.
+var slidingWindowScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+if previous * tonumber(ARGV[1]) + current + 1 > tonumber(ARGV[2]) then
+	return {0, current, previous}
+end
+current = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {1, current, previous}
+`)
+
+// RedisSlidingWindowRateLimit implements SlidingWindowRateLimit with the
+// counters in Redis, shared by every instance. While Redis fails, requests
+// are handled per RATE_LIMIT_FAIL_MODE.
+func RedisSlidingWindowRateLimit(cfg *config.Config, log *logger.Logger, redisClient *redis.Client) func(http.Handler) http.Handler {
+	guard := newRedisGuard(cfg, log, SlidingWindowRateLimit(cfg, log))
+	queue := newRateLimitQueue(cfg)
+
+	return func(next http.Handler) http.Handler {
+		fallback := guard.fallback(next)
+
+		var handler http.HandlerFunc
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(cfg, r)
+			if cfg.RateLimitExemptKeys[key] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limits := cfg.Limits(key)
+			now := time.Now()
+			if !guard.breaker.allow(now) {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+			index, elapsed := windowPosition(now, limits.RateLimitWindow)
+
+			// A counter per fixed window, kept until the next window is over
+			keys := []string{
+				fmt.Sprintf("ratelimit:sliding:%s:%d", key, index),
+				fmt.Sprintf("ratelimit:sliding:%s:%d", key, index-1),
+			}
+			weight := 1 - float64(elapsed)/float64(limits.RateLimitWindow)
+			result, err := slidingWindowScript.Run(r.Context(), redisClient, keys,
+				weight, limits.RateLimitRequests, (2 * limits.RateLimitWindow).Milliseconds()).Int64Slice()
+			if r.Context().Err() != nil {
+				guard.breaker.abandon()
+				return // the client is gone
+			}
+			if err == nil && len(result) != 3 {
+				err = fmt.Errorf("unexpected script result %v", result)
+			}
+			if err != nil {
+				guard.failed(err, now)
+				fallback.ServeHTTP(w, r)
+				return
+			}
+			guard.breaker.success()
+
+			allowed, current, previous := result[0] == 1, int(result[1]), int(result[2])
+			setSlidingWindowHeaders(w, key, limits, previous, current, index, elapsed, now)
+			if !allowed {
+				retryAfter := slidingRetryAfter(previous, current, limits.RateLimitRequests, elapsed, limits.RateLimitWindow)
+				if queued, ok := queue.wait(r, key, retryAfter); ok {
+					handler(w, queued)
+					return
+				}
+				rateLimitExceeded(w, log, key, limits.RateLimitRequests, retryAfter)
+				return
+			}
+
+			recordAllowed(key)
+			next.ServeHTTP(w, r)
+		}
+		return handler
+	}
+}
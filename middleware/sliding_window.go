@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"file-meta/config"
+	"file-meta/internal/logger"
+)
+
+// slidingWindow counts one key's requests in the current and previous fixed
+// windows
+type slidingWindow struct {
+	index    int64 // current fixed window, counted from the Unix epoch
+	current  int
+	previous int
+	lastSeen time.Time
+	mu       sync.Mutex
+}
+
+var (
+	windowsMu      sync.Mutex
+	windows        = make(map[string]*slidingWindow)
+	windowsCleanup sync.Once
+)
+
+// SlidingWindowRateLimit implements sliding window rate limiting. Unlike
+// RateLimit it doesn't let a key spend two windows' requests across a window
+// boundary: the previous fixed window's requests count towards the limit in
+// proportion to how much of it the sliding window still covers.
+func SlidingWindowRateLimit(cfg *config.Config, log *logger.Logger) func(http.Handler) http.Handler {
+	windowsCleanup.Do(func() {
+		go cleanupExpiredWindows(longestWindow(cfg), log)
+	})
+
+	queue := newRateLimitQueue(cfg)
+
+	return func(next http.Handler) http.Handler {
+		var handler http.HandlerFunc
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(cfg, r)
+			if cfg.RateLimitExemptKeys[key] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limits := cfg.Limits(key)
+			now := time.Now()
+			index, elapsed := windowPosition(now, limits.RateLimitWindow)
+
+			windowsMu.Lock()
+			sw, exists := windows[key]
+			if !exists {
+				sw = &slidingWindow{index: index}
+				windows[key] = sw
+			}
+			windowsMu.Unlock()
+
+			sw.mu.Lock()
+			switch {
+			case index == sw.index+1:
+				sw.previous, sw.current = sw.current, 0
+			case index > sw.index+1:
+				sw.previous, sw.current = 0, 0
+			}
+			sw.index = index
+			sw.lastSeen = now
+
+			previous, current := sw.previous, sw.current
+			allowed := slidingCount(previous, current, elapsed, limits.RateLimitWindow)+1 <= float64(limits.RateLimitRequests)
+			if allowed {
+				sw.current++
+				current++
+			}
+			sw.mu.Unlock()
+
+			setSlidingWindowHeaders(w, key, limits, previous, current, index, elapsed, now)
+			if !allowed {
+				retryAfter := slidingRetryAfter(previous, current, limits.RateLimitRequests, elapsed, limits.RateLimitWindow)
+				if queued, ok := queue.wait(r, key, retryAfter); ok {
+					handler(w, queued)
+					return
+				}
+				rateLimitExceeded(w, log, key, limits.RateLimitRequests, retryAfter)
+				return
+			}
+
+			recordAllowed(key)
+			next.ServeHTTP(w, r)
+		}
+		return handler
+	}
+}
+
+// windowPosition returns the fixed window now falls in, counted from the
+// Unix epoch so every instance agrees on it, and how far into it now is
+func windowPosition(now time.Time, window time.Duration) (int64, time.Duration) {
+	nanos := now.UnixNano()
+	return nanos / int64(window), time.Duration(nanos % int64(window))
+}
+
+// slidingCount estimates a key's requests in the window ending elapsed into
+// the current fixed window, assuming the previous fixed window's requests
+// were spread evenly across it
+func slidingCount(previous, current int, elapsed, window time.Duration) float64 {
+	return float64(previous)*(1-float64(elapsed)/float64(window)) + float64(current)
+}
+
+// slidingRetryAfter is how long after elapsed into the current fixed window
+// the estimate leaves room for another request
+func slidingRetryAfter(previous, current, limit int, elapsed, window time.Duration) time.Duration {
+	if current < limit && previous > 0 {
+		// Wait for enough of the previous window to slide out
+		share := 1 - float64(limit-current-1)/float64(previous)
+		return time.Duration(share*float64(window)) - elapsed
+	}
+	// The current window alone is full, so wait until enough of it slides out
+	// of the next one
+	share := 1 - float64(limit-1)/float64(max(current, 1))
+	return window - elapsed + time.Duration(share*float64(window))
+}
+
+// setSlidingWindowHeaders reports the limit, the requests left of it and the
+// end of the current fixed window, after which the key's requests so far
+// start to expire
+func setSlidingWindowHeaders(w http.ResponseWriter, key string, limits config.Tier, previous, current int, index int64, elapsed time.Duration, now time.Time) {
+	count := slidingCount(previous, current, elapsed, limits.RateLimitWindow)
+	remaining := max(0, limits.RateLimitRequests-int(math.Ceil(count)))
+	reset := time.Unix(0, (index+1)*int64(limits.RateLimitWindow))
+	setRateLimitHeaders(w, key, limits.RateLimitRequests, remaining, reset, now)
+}
+
+// cleanupExpiredWindows removes keys that haven't made a request in the last
+// two windows, whose counts would both be zero
+func cleanupExpiredWindows(window time.Duration, log *logger.Logger) {
+	ticker := time.NewTicker(window * 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		windowsMu.Lock()
+		now := time.Now()
+		for key, sw := range windows {
+			sw.mu.Lock()
+			if now.Sub(sw.lastSeen) > window*2 {
+				delete(windows, key)
+				log.Debugf("Cleaned up expired client: %s", logger.KeyPrefix(key))
+			}
+			sw.mu.Unlock()
+		}
+		windowsMu.Unlock()
+	}
+}
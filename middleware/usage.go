@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"file-meta/internal/history"
+	"file-meta/internal/logger"
+	"file-meta/internal/usage"
+)
+
+// RequestCounter counts requests per API key, identified by history.KeyID,
+// endpoint and origin
+type RequestCounter interface {
+	Request(ctx context.Context, keyID, endpoint string, origin usage.Origin) error
+}
+
+// CountRequests counts every authenticated request against its API key and
+// the route pattern it matched, so path parameters don't split the counts,
+// and where Locate placed the client. It must run after APIKeyAuth; a failure to count is logged and the
+// request is served anyway.
+func CountRequests(counter RequestCounter, log *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := GetAPIKey(r.Context()); key != "" {
+				endpoint := r.Pattern
+				if endpoint == "" {
+					endpoint = r.URL.Path
+				}
+				location := GetLocation(r.Context())
+				origin := usage.Origin{Country: location.Country, AS: location.AS()}
+				if err := counter.Request(r.Context(), history.KeyID(key), endpoint, origin); err != nil {
+					log.Warnf("[%s] Failed to count usage: %v", GetRequestID(r.Context()), err)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"runtime"
+
+	"file-meta/config"
+	"file-meta/handlers"
+	"file-meta/internal/logger"
+	"file-meta/internal/nats"
+
+	"github.com/google/uuid"
+)
+
+// serveNATSRequests runs the extraction requests published to
+// NATS_REQUESTS_SUBJECT, replying to those that ask for one. Requests are
+// shared with the other members of the queue group, and handled as many at
+// a time as extractions are allowed to run.
+func serveNATSRequests(cfg *config.Config, log *logger.Logger, deps handlers.Deps, conn *nats.Conn) {
+	limit := cfg.MaxConcurrentExtractions
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+	slots := make(chan struct{}, limit)
+
+	err := conn.Subscribe(cfg.NATSRequestsSubject, cfg.NATSQueueGroup, func(msg nats.Msg) {
+		slots <- struct{}{}
+		requestID := uuid.New().String()
+		run := func(ctx context.Context) {
+			defer func() { <-slots }()
+			log.Infof("[%s] NATS extraction request on %s", requestID, msg.Subject)
+
+			reply := handlers.ExtractMessage(ctx, cfg, log, requestID, deps, msg.Data)
+			if msg.Reply == "" {
+				return
+			}
+			if err := conn.Publish(msg.Reply, reply); err != nil {
+				log.Warnf("[%s] Failed to send NATS reply: %v", requestID, err)
+			}
+		}
+		if deps.Background == nil {
+			go run(context.Background())
+		} else if !deps.Background.Go(context.Background(), "NATS request "+requestID, run) {
+			// Shutting down: leave it to another member of the queue group
+			<-slots
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to subscribe to %s: %v", cfg.NATSRequestsSubject, err)
+	}
+	log.Infof("Serving extraction requests on NATS subject %s (queue group %s)", cfg.NATSRequestsSubject, cfg.NATSQueueGroup)
+}
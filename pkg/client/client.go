@@ -0,0 +1,382 @@
+// Package client is a typed Go client for the file-meta HTTP API, for
+// services that call a file-meta server rather than embed the extractor
+// (see pkg/extract for that). Results are decoded into the same types the
+// extractor returns.
+//
+// Requests rejected before the server did any work, a 429 or a 503 with
+// Retry-After, are retried with backoff. Each call sends an Idempotency-Key
+// header, the same on all of its attempts, for gateways and logs to tie the
+// attempts together.
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"file-meta/internal/metadata"
+)
+
+// Result is an extraction result, as returned by the v1 API
+type Result = metadata.Result
+
+// Defaults for the zero Options fields
+const (
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// maxErrorBody bounds how much of an error response is read
+const maxErrorBody = 64 << 10
+
+// Options configure a Client. Zero fields use the defaults.
+type Options struct {
+	// APIKey is sent as X-API-Key; empty sends none
+	APIKey string
+
+	// HTTPClient makes the requests; nil uses http.DefaultClient
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times a rejected request is retried; negative
+	// disables retries
+	MaxRetries int
+
+	// MinBackoff is the first wait between attempts when the server sends
+	// no Retry-After, doubling up to MaxBackoff. MaxBackoff also caps
+	// Retry-After.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ExtractOptions select what an extraction runs. The zero value runs the
+// server's defaults.
+type ExtractOptions struct {
+	Include   []string // modules to run, as the include parameter
+	Exclude   []string // modules to skip, as the exclude parameter
+	Profile   string   // a named set of modules, as the profile parameter
+	Checksums []string // extra checksums such as "md5", as the checksums parameter
+
+	// IdempotencyKey is sent as the Idempotency-Key header; empty generates
+	// one per call
+	IdempotencyKey string
+}
+
+// Error is an error response from the API. Code is one of the API's stable
+// error codes, such as "NOT_FOUND" or "RATE_LIMITED".
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("file-meta: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("file-meta: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client calls one file-meta server. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	opts    Options
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://files.example.com"
+func New(baseURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: want http(s)://host", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	return &Client{baseURL: u, opts: opts}, nil
+}
+
+// UploadFile uploads the file at path and returns its metadata
+func (c *Client) UploadFile(ctx context.Context, path string, opts ExtractOptions) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.UploadReader(ctx, f, filepath.Base(path), opts)
+}
+
+// UploadReader uploads r's content as filename and returns its metadata. The
+// content is streamed, not buffered, so only an r that is also an io.Seeker,
+// such as an *os.File or *bytes.Reader, is rewound and retried.
+func (c *Client) UploadReader(ctx context.Context, r io.Reader, filename string, opts ExtractOptions) (*Result, error) {
+	seeker, seekable := r.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	// The previous attempt's body, closed and waited for before r is
+	// rewound, since the transport may still be reading it
+	var body io.ReadCloser
+	var wait func()
+	defer func() {
+		if body != nil {
+			body.Close()
+			wait()
+		}
+	}()
+
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		if body != nil {
+			body.Close()
+			wait()
+			if !seekable {
+				return nil, errNoRetry
+			}
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+
+		var contentType string
+		body, contentType, wait = multipartBody(r, filename)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/v1/metadata", opts), body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		return req, nil
+	}
+
+	var result Result
+	if err := c.do(ctx, newRequest, opts.IdempotencyKey, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ExtractURL has the server read and extract the object at uri: s3://,
+// gs:// or azure://container/name, or an https URL of a storage provider the
+// server is configured for
+func (c *Client) ExtractURL(ctx context.Context, uri string, opts ExtractOptions) (*Result, error) {
+	body, err := json.Marshal(struct {
+		URI string `json:"uri"`
+	}{uri})
+	if err != nil {
+		return nil, err
+	}
+
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("/v1/metadata/remote", opts), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	var result Result
+	if err := c.do(ctx, newRequest, opts.IdempotencyKey, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetByHash returns the stored result for the content with the given SHA256.
+// A result that isn't stored is an *Error with the code "NOT_FOUND".
+func (c *Client) GetByHash(ctx context.Context, sha256 string) (*Result, error) {
+	newRequest := func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint("/v1/metadata/"+url.PathEscape(sha256), ExtractOptions{}), nil)
+	}
+
+	var result Result
+	if err := c.do(ctx, newRequest, "", &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// endpoint is the URL of path with opts as query parameters
+func (c *Client) endpoint(path string, opts ExtractOptions) string {
+	u := *c.baseURL
+	u.Path += path
+
+	query := url.Values{}
+	if len(opts.Include) > 0 {
+		query.Set("include", strings.Join(opts.Include, ","))
+	}
+	if len(opts.Exclude) > 0 {
+		query.Set("exclude", strings.Join(opts.Exclude, ","))
+	}
+	if opts.Profile != "" {
+		query.Set("profile", opts.Profile)
+	}
+	if len(opts.Checksums) > 0 {
+		query.Set("checksums", strings.Join(opts.Checksums, ","))
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// errNoRetry stops retries when a request can't be made again; the last
+// rejection is returned instead
+var errNoRetry = errors.New("request can't be retried")
+
+// do sends the requests newRequest makes until one isn't rejected or the
+// retries run out, and decodes a successful response into v
+func (c *Client) do(ctx context.Context, newRequest func(context.Context) (*http.Request, error), idempotencyKey string, v any) error {
+	if idempotencyKey == "" {
+		idempotencyKey = uuid.New().String()
+	}
+
+	var rejected error
+	backoff := c.opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest(ctx)
+		if errors.Is(err, errNoRetry) {
+			return rejected
+		}
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		if c.opts.APIKey != "" {
+			req.Header.Set("X-API-Key", c.opts.APIKey)
+		}
+
+		resp, err := c.opts.HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				return fmt.Errorf("decoding response: %w", err)
+			}
+			return nil
+		}
+
+		rejected = readError(resp)
+		wait, retry := retryWait(resp.Header, resp.StatusCode, backoff, c.opts.MaxBackoff)
+		if !retry || attempt >= c.opts.MaxRetries {
+			return rejected
+		}
+		backoff = min(2*backoff, c.opts.MaxBackoff)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return rejected
+		case <-timer.C:
+		}
+	}
+}
+
+// retryWait returns how long to wait before retrying a response with the
+// given header and status, and whether to retry it at all: a 429, or a 503
+// with Retry-After, was rejected before the server did any work. The wait
+// is Retry-After, or else backoff, capped at maxBackoff.
+func retryWait(header http.Header, status int, backoff, maxBackoff time.Duration) (time.Duration, bool) {
+	retryAfter := header.Get("Retry-After")
+	switch {
+	case status == http.StatusTooManyRequests:
+	case status == http.StatusServiceUnavailable && retryAfter != "":
+	default:
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, maxBackoff), true
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		return min(max(time.Until(at), 0), maxBackoff), true
+	}
+	return backoff, true
+}
+
+// readError reads resp's error body, an error response or problem details,
+// and closes it
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+
+	apiErr := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	var body struct {
+		Error  string `json:"error"`
+		Code   string `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if json.Unmarshal(data, &body) == nil {
+		apiErr.Code = body.Code
+		apiErr.Message = cmp.Or(body.Error, body.Detail, body.Title)
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// quoteEscaper escapes a quoted multipart parameter, as mime/multipart does
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// multipartBody streams r as the file part of a multipart form. wait returns
+// once r is no longer read, after the body is closed or read to the end.
+func multipartBody(r io.Reader, filename string) (body io.ReadCloser, contentType string, wait func()) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(filename)))
+		header.Set("Content-Type", "application/octet-stream")
+		part, err := form.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, form.FormDataContentType(), func() { <-done }
+}
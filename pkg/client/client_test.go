@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a fake server that answers with responses in turn, the last
+// one repeated, and records the requests it got
+type recorder struct {
+	responses []func(w http.ResponseWriter)
+
+	mu       sync.Mutex
+	requests []recorded
+}
+
+type recorded struct {
+	method, path, query string
+	header              http.Header
+	filename, content   string
+	body                string
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := recorded{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, header: r.Header}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if file, header, err := r.FormFile("file"); err == nil {
+			data, _ := io.ReadAll(file)
+			req.filename, req.content = header.Filename, string(data)
+		}
+	} else {
+		data, _ := io.ReadAll(r.Body)
+		req.body = string(data)
+	}
+
+	rec.mu.Lock()
+	rec.requests = append(rec.requests, req)
+	n := min(len(rec.requests), len(rec.responses)) - 1
+	rec.mu.Unlock()
+	rec.responses[n](w)
+}
+
+func respond(status int, header map[string]string, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+var (
+	ok          = respond(http.StatusOK, nil, `{"filename":"a.txt","size_bytes":5,"mime_type":"text/plain","checksum_sha256":"abc"}`)
+	rateLimited = respond(http.StatusTooManyRequests, map[string]string{"Retry-After": "0"}, `{"error":"Rate limit exceeded","code":"RATE_LIMITED","limit":10,"retry_after":0}`)
+	busy        = respond(http.StatusServiceUnavailable, map[string]string{"Retry-After": "0"}, `{"error":"Server busy, retry later","code":"SERVER_BUSY"}`)
+	unavailable = respond(http.StatusServiceUnavailable, nil, `{"error":"Antivirus scan unavailable","code":"ANTIVIRUS_UNAVAILABLE"}`)
+	tooLarge    = respond(http.StatusRequestEntityTooLarge, map[string]string{"Content-Type": "application/problem+json", "X-Request-ID": "req-1"}, `{"type":"about:blank","title":"Request Entity Too Large","status":413,"code":"FILE_TOO_LARGE","detail":"File too large"}`)
+)
+
+func newTestClient(t *testing.T, rec *recorder, opts Options) *Client {
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	opts.MinBackoff = time.Millisecond
+	c, err := New(server.URL+"/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUploadReader(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []func(w http.ResponseWriter)
+		reader       func() io.Reader
+		maxRetries   int
+		wantRequests int
+		wantCode     string
+	}{
+		{"ok", []func(http.ResponseWriter){ok}, func() io.Reader { return strings.NewReader("hello") }, 0, 1, ""},
+		{"rate limited then ok", []func(http.ResponseWriter){rateLimited, busy, ok}, func() io.Reader { return strings.NewReader("hello") }, 0, 3, ""},
+		{"retries run out", []func(http.ResponseWriter){rateLimited}, func() io.Reader { return strings.NewReader("hello") }, 2, 3, "RATE_LIMITED"},
+		{"retries disabled", []func(http.ResponseWriter){rateLimited, ok}, func() io.Reader { return strings.NewReader("hello") }, -1, 1, "RATE_LIMITED"},
+		{"503 without Retry-After", []func(http.ResponseWriter){unavailable, ok}, func() io.Reader { return strings.NewReader("hello") }, 0, 1, "ANTIVIRUS_UNAVAILABLE"},
+		{"problem details", []func(http.ResponseWriter){tooLarge}, func() io.Reader { return strings.NewReader("hello") }, 0, 1, "FILE_TOO_LARGE"},
+		{"stream not retried", []func(http.ResponseWriter){rateLimited, ok}, func() io.Reader { return io.MultiReader(strings.NewReader("hello")) }, 0, 1, "RATE_LIMITED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{responses: tt.responses}
+			c := newTestClient(t, rec, Options{APIKey: "secret", MaxRetries: tt.maxRetries})
+
+			result, err := c.UploadReader(context.Background(), tt.reader(), "a.txt", ExtractOptions{Include: []string{"document", "security"}})
+			if len(rec.requests) != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", len(rec.requests), tt.wantRequests)
+			}
+			if tt.wantCode != "" {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || apiErr.Code != tt.wantCode {
+					t.Fatalf("UploadReader() error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadReader() error = %v", err)
+			}
+			if result.MimeType != "text/plain" || result.SizeBytes != 5 {
+				t.Errorf("result = %+v", result)
+			}
+
+			key := rec.requests[0].header.Get("Idempotency-Key")
+			if key == "" {
+				t.Error("no Idempotency-Key")
+			}
+			for _, req := range rec.requests {
+				if req.method != http.MethodPost || req.path != "/v1/metadata" || req.query != "include=document%2Csecurity" {
+					t.Errorf("request = %s %s?%s", req.method, req.path, req.query)
+				}
+				if req.header.Get("X-API-Key") != "secret" {
+					t.Errorf("X-API-Key = %q", req.header.Get("X-API-Key"))
+				}
+				if req.header.Get("Idempotency-Key") != key {
+					t.Errorf("Idempotency-Key = %q, want %q on every attempt", req.header.Get("Idempotency-Key"), key)
+				}
+				if req.filename != "a.txt" || req.content != "hello" {
+					t.Errorf("uploaded %q as %q, want hello as a.txt", req.content, req.filename)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{responses: []func(http.ResponseWriter){rateLimited, ok}}
+	c := newTestClient(t, rec, Options{})
+	if _, err := c.UploadFile(context.Background(), path, ExtractOptions{IdempotencyKey: "upload-1"}); err != nil {
+		t.Fatalf("UploadFile() error = %v", err)
+	}
+
+	if len(rec.requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(rec.requests))
+	}
+	for _, req := range rec.requests {
+		if req.filename != "notes.txt" || req.content != "hello" {
+			t.Errorf("uploaded %q as %q, want hello as notes.txt", req.content, req.filename)
+		}
+		if req.header.Get("Idempotency-Key") != "upload-1" {
+			t.Errorf("Idempotency-Key = %q, want upload-1", req.header.Get("Idempotency-Key"))
+		}
+	}
+}
+
+func TestExtractURL(t *testing.T) {
+	rec := &recorder{responses: []func(http.ResponseWriter){ok}}
+	c := newTestClient(t, rec, Options{})
+	if _, err := c.ExtractURL(context.Background(), "s3://bucket/a.txt", ExtractOptions{Profile: "fast"}); err != nil {
+		t.Fatalf("ExtractURL() error = %v", err)
+	}
+
+	req := rec.requests[0]
+	if req.method != http.MethodPost || req.path != "/v1/metadata/remote" || req.query != "profile=fast" {
+		t.Errorf("request = %s %s?%s", req.method, req.path, req.query)
+	}
+	var body struct{ URI string }
+	if err := json.Unmarshal([]byte(req.body), &body); err != nil || body.URI != "s3://bucket/a.txt" {
+		t.Errorf("body = %s", req.body)
+	}
+}
+
+func TestGetByHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(w http.ResponseWriter)
+		wantCode string
+	}{
+		{"found", ok, ""},
+		{"not found", respond(http.StatusNotFound, nil, `{"error":"Result not found","code":"NOT_FOUND"}`), "NOT_FOUND"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{responses: []func(http.ResponseWriter){tt.response}}
+			c := newTestClient(t, rec, Options{})
+			result, err := c.GetByHash(context.Background(), "abc")
+
+			if req := rec.requests[0]; req.method != http.MethodGet || req.path != "/v1/metadata/abc" {
+				t.Errorf("request = %s %s", req.method, req.path)
+			}
+			if tt.wantCode != "" {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || apiErr.Code != tt.wantCode || apiErr.StatusCode != http.StatusNotFound {
+					t.Fatalf("GetByHash() error = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil || result.SHA256 != "abc" {
+				t.Fatalf("GetByHash() = %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       time.Duration
+		wantRetry  bool
+	}{
+		{"429 with seconds", http.StatusTooManyRequests, "3", 3 * time.Second, true},
+		{"429 capped", http.StatusTooManyRequests, "3600", time.Minute, true},
+		{"429 without Retry-After", http.StatusTooManyRequests, "", time.Second, true},
+		{"503 with Retry-After", http.StatusServiceUnavailable, "2", 2 * time.Second, true},
+		{"503 without Retry-After", http.StatusServiceUnavailable, "", 0, false},
+		{"500", http.StatusInternalServerError, "2", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.retryAfter != "" {
+				header.Set("Retry-After", tt.retryAfter)
+			}
+			got, retry := retryWait(header, tt.status, time.Second, time.Minute)
+			if got != tt.want || retry != tt.wantRetry {
+				t.Errorf("retryWait() = %v, %v, want %v, %v", got, retry, tt.want, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"", "files.example.com", "ftp://files.example.com", "http://"} {
+		if _, err := New(baseURL, Options{}); err == nil {
+			t.Errorf("New(%q) succeeded", baseURL)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package extract is the file-meta extractor as a library, for Go services
+// that embed extraction instead of calling the HTTP API. Results are the
+// same as the API's: a Result's JSON form is the /v1 response.
+//
+// The API here is stable; the implementation behind it is not exported and
+// may change.
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+
+	"file-meta/internal/metadata"
+)
+
+// Result and the types it is made of
+type (
+	Result           = metadata.Result
+	ImageMetadata    = metadata.ImageMetadata
+	AudioMetadata    = metadata.AudioMetadata
+	VideoMetadata    = metadata.VideoMetadata
+	DocumentMetadata = metadata.DocumentMetadata
+	SecurityMetadata = metadata.SecurityMetadata
+	Warning          = metadata.Warning
+)
+
+// DecompressionLimits bound archive and image inspection, and MemoryLimits
+// image decoding, EXIF parsing and text analysis. Zero fields use the
+// defaults.
+type (
+	DecompressionLimits = metadata.DecompressionLimits
+	MemoryLimits        = metadata.MemoryLimits
+)
+
+// Modules that Options.Modules can select
+const (
+	ModuleSSDeep              = metadata.ModuleSSDeep
+	ModuleImage               = metadata.ModuleImage
+	ModuleAudio               = metadata.ModuleAudio
+	ModuleVideo               = metadata.ModuleVideo
+	ModuleDocument            = metadata.ModuleDocument
+	ModuleAIDetection         = metadata.ModuleAIDetection
+	ModuleScreenshotDetection = metadata.ModuleScreenshotDetection
+	ModuleSecurity            = metadata.ModuleSecurity
+	ModulePerceptualHash      = metadata.ModulePerceptualHash
+)
+
+// Options describe the content and what to extract. The zero value runs
+// every module with the default limits.
+type Options struct {
+	// Filename is the content's name, used for its extension
+	Filename string
+
+	// ContentType is the declared MIME type, used when the type can't be
+	// detected from the content
+	ContentType string
+
+	// Modules lists the modules to run; nil runs every one. SHA256 is
+	// always computed.
+	Modules []string
+
+	// TLSH adds a TLSH locality-sensitive hash
+	TLSH bool
+
+	Decompression DecompressionLimits
+	Memory        MemoryLimits
+}
+
+// seekableReader is content that can be read in place
+type seekableReader interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// Extract extracts metadata from r. Content that can also seek and read at
+// offsets, such as an *os.File or *bytes.Reader, is read in place from its
+// start; other readers are read once, spilling to a temporary file only
+// when an enabled module needs to seek. Extraction stops with ctx's error
+// once ctx is done.
+func Extract(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	internal := metadata.Options{
+		TLSH:          opts.TLSH,
+		Decompression: opts.Decompression,
+		Memory:        opts.Memory,
+	}
+	if opts.Modules != nil {
+		for _, module := range opts.Modules {
+			if !slices.Contains(metadata.Modules, module) {
+				return nil, fmt.Errorf("unknown module %q", module)
+			}
+		}
+		internal.Skip = make(map[string]bool)
+		for _, module := range metadata.Modules {
+			internal.Skip[module] = !slices.Contains(opts.Modules, module)
+		}
+	}
+
+	if src, ok := r.(seekableReader); ok {
+		header := &multipart.FileHeader{
+			Filename: opts.Filename,
+			Header:   textproto.MIMEHeader{"Content-Type": {opts.ContentType}},
+		}
+		return metadata.ExtractWithOptions(ctx, file{src}, header, internal)
+	}
+	info := metadata.FileInfo{Filename: opts.Filename, ContentType: opts.ContentType, Size: -1}
+	return metadata.ExtractStream(ctx, r, info, internal)
+}
+
+// file adapts seekable content to the multipart.File the extractor reads
+type file struct {
+	seekableReader
+}
+
+// Close implements io.Closer; the caller owns the content
+func (file) Close() error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	text := []byte("The quick brown fox jumps over the lazy dog.\n")
+
+	tests := []struct {
+		name      string
+		reader    io.Reader
+		opts      Options
+		wantMIME  string
+		wantImage bool
+		wantDoc   bool
+		wantErr   bool
+	}{
+		{"seekable image", bytes.NewReader(img.Bytes()), Options{Filename: "a.png"}, "image/png", true, false, false},
+		{"streamed image", io.MultiReader(bytes.NewReader(img.Bytes())), Options{Filename: "a.png"}, "image/png", true, false, false},
+		{"image module skipped", bytes.NewReader(img.Bytes()), Options{Modules: []string{ModuleDocument}}, "image/png", false, false, false},
+		{"declared text", bytes.NewReader(text), Options{Filename: "fox.txt", ContentType: "text/plain"}, "text/plain", false, true, false},
+		{"unknown module", bytes.NewReader(text), Options{Modules: []string{"ocr"}}, "", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Extract(context.Background(), tt.reader, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if result.MimeType != tt.wantMIME || result.SHA256 == "" {
+				t.Errorf("result = %s with SHA256 %q, want %s with a SHA256", result.MimeType, result.SHA256, tt.wantMIME)
+			}
+			if (result.Image != nil) != tt.wantImage {
+				t.Errorf("image = %+v, want present %v", result.Image, tt.wantImage)
+			}
+			if tt.wantImage && (result.Image.Width != 4 || result.Image.Height != 3) {
+				t.Errorf("image is %dx%d, want 4x3", result.Image.Width, result.Image.Height)
+			}
+			if (result.Document != nil) != tt.wantDoc {
+				t.Errorf("document = %+v, want present %v", result.Document, tt.wantDoc)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"file-meta/config"
+	"file-meta/handlers"
+	"file-meta/internal/dirscan"
+	"file-meta/internal/logger"
+
+	"github.com/google/uuid"
+)
+
+// runScan extracts metadata for every file under dir, which must be in
+// SCAN_ALLOWED_DIRS, and prints the results to stdout as NDJSON in the
+// newest API version's schema; main keeps logs on stderr. Results are
+// stored and published as uploads' are.
+func runScan(cfg *config.Config, log *logger.Logger, deps handlers.Deps, dir string) {
+	if len(cfg.ScanAllowedDirs) == 0 {
+		log.Fatalf("Scan mode needs SCAN_ALLOWED_DIRS")
+	}
+	root, err := dirscan.Resolve(dir, cfg.ScanAllowedDirs)
+	if err != nil {
+		log.Fatalf("Cannot scan %s: %v", dir, err)
+	}
+
+	workers := cfg.MaxConcurrentExtractions
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	version := handlers.Versions[len(handlers.Versions)-1]
+	extract := func(ctx context.Context, path string) (any, error) {
+		return handlers.ExtractLocalFile(ctx, cfg, log, uuid.New().String(), deps, version, path)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("Scanning %s with %d workers", root, workers)
+	start := time.Now()
+	summary, err := dirscan.Run(ctx, root, workers, extract, os.Stdout, log)
+	log.Infof("Scanned %d files in %s, %d failed", summary.Files, time.Since(start).Round(time.Millisecond), summary.Failed)
+	if err != nil {
+		log.Errorf("Scan stopped early: %v", err)
+	}
+}
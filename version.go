@@ -0,0 +1,44 @@
+package main
+
+import "runtime/debug"
+
+// release and buildDate describe the binary, set at build time with
+// -ldflags "-X main.release=v1.2.3 -X main.buildDate=2024-03-01T12:00:00Z"
+var (
+	release   string
+	buildDate string
+)
+
+// buildInfo returns the version of the binary, the VCS revision it was
+// built from and when, as far as they are known
+func buildInfo() (version, commit, date string) {
+	version, date = release, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		info = &debug.BuildInfo{}
+	}
+	if version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			commit = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		case "vcs.time":
+			// The commit's time, when the build's isn't set
+			if date == "" {
+				date = setting.Value
+			}
+		}
+	}
+	if commit != "" && modified {
+		commit += "-dirty"
+	}
+	if version == "" {
+		version = "dev"
+	}
+	return version, commit, date
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"file-meta/config"
+	"file-meta/handlers"
+	"file-meta/internal/logger"
+	"file-meta/internal/watch"
+)
+
+// runWatch extracts metadata for files dropped into WATCH_DIRS until
+// interrupted, instead of serving HTTP. Each result goes to the result
+// store and the webhook, in the newest API version's schema.
+func runWatch(cfg *config.Config, log *logger.Logger, deps handlers.Deps) {
+	if len(cfg.WatchDirs) == 0 {
+		log.Fatalf("Watch mode needs WATCH_DIRS")
+	}
+	for _, dir := range cfg.WatchDirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			log.Fatalf("Cannot watch %s: not a readable directory", dir)
+		}
+	}
+
+	var notifier watch.Notifier
+	if hook := resultWebhook(cfg, log, deps); hook != nil {
+		notifier = hook
+	}
+
+	version := handlers.Versions[len(handlers.Versions)-1]
+	extract := func(ctx context.Context, requestID, path string) (any, error) {
+		return handlers.ExtractLocalFile(ctx, cfg, log, requestID, deps, version, path)
+	}
+	watcher := watch.NewWatcher(cfg.WatchDirs, cfg.WatchInterval, extract, notifier, cfg.MaxFileSizeMB<<20, log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("Watching %v for new files every %s", cfg.WatchDirs, cfg.WatchInterval)
+	watcher.Run(ctx)
+	log.Info("Watcher stopped")
+}
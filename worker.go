@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"file-meta/config"
+	"file-meta/handlers"
+	"file-meta/internal/events"
+	"file-meta/internal/logger"
+	"file-meta/internal/sigv4"
+	"file-meta/internal/sqs"
+	"file-meta/internal/webhook"
+)
+
+// runWorker consumes S3 event notifications from SQS until interrupted,
+// instead of serving HTTP. Each new object's result goes to the result
+// store and the webhook, in the newest API version's schema.
+func runWorker(cfg *config.Config, log *logger.Logger, deps handlers.Deps) {
+	provider, ok := deps.Storage["s3"]
+	if !ok || cfg.S3AccessKeyID == "" {
+		log.Fatalf("Worker mode needs S3_ENABLED and AWS credentials")
+	}
+	if cfg.SQSQueueURL == "" {
+		log.Fatalf("Worker mode needs SQS_QUEUE_URL")
+	}
+
+	creds := sigv4.Credentials{
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+		SessionToken:    cfg.S3SessionToken,
+	}
+	queue, err := sqs.NewClient(cfg.SQSQueueURL, cfg.S3Region, creds, cfg.StorageRequestTimeout)
+	if err != nil {
+		log.Fatalf("Invalid SQS configuration: %v", err)
+	}
+
+	var notifier events.Notifier
+	if hook := resultWebhook(cfg, log, deps); hook != nil {
+		notifier = hook
+	}
+
+	version := handlers.Versions[len(handlers.Versions)-1]
+	extract := func(ctx context.Context, requestID, bucket, key string) (any, error) {
+		return handlers.ExtractObject(ctx, cfg, log, requestID, deps, version, provider, bucket, key)
+	}
+	worker := events.NewWorker(queue, extract, notifier, cfg.MaxFileSizeMB<<20, cfg.SQSVisibilityTimeout, log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("Worker consuming S3 event notifications from %s", cfg.SQSQueueURL)
+	worker.Run(ctx)
+	log.Info("Worker stopped")
+}
+
+// resultWebhook returns the client for WEBHOOK_URL, or nil when it isn't
+// set
+func resultWebhook(cfg *config.Config, log *logger.Logger, deps handlers.Deps) *webhook.Client {
+	if cfg.WebhookURL == "" {
+		if deps.Results == nil {
+			log.Warnf("Neither WEBHOOK_URL nor a result cache is configured, results will only be logged")
+		}
+		return nil
+	}
+	hook, err := webhook.NewClient(cfg.WebhookURL, cfg.WebhookSecret, cfg.WebhookTimeout)
+	if err != nil {
+		log.Fatalf("Invalid WEBHOOK_URL: %v", err)
+	}
+	log.Infof("Sending results to %s", cfg.WebhookURL)
+	return hook
+}